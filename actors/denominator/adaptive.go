@@ -0,0 +1,163 @@
+package denominator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+)
+
+// AdaptiveStats shows how the adaptive clients of an entry point backed off and ramped up again
+type AdaptiveStats struct {
+	Requests   int     `json:"requests"`
+	Successes  int     `json:"successes"`
+	Rejections int     `json:"rejections"` // gets the entry point said it was too overloaded to answer
+	Failures   int     `json:"failures"`   // the other failed gets, which don't change the rate
+	Decreases  int     `json:"decreases"`
+	Offered    float64 `json:"offered"`  // requests per second the clients would send at if they never backed off
+	Rate       float64 `json:"rate"`     // requests per second they were sending at when the run ended
+	MinRate    float64 `json:"minrate"`  // slowest they went
+	MeanRate   float64 `json:"meanrate"` // over the requests they sent
+	rateTotal  float64
+}
+
+// aimd is the rate the adaptive clients of an entry point are sending at, in requests per second
+type aimd struct {
+	rate, max, min     float64
+	increase, decrease float64
+	window             time.Duration
+	decreased          time.Time
+	pending            map[gotocol.TraceContextType]bool // gets waiting for their response
+	stats              *AdaptiveStats
+}
+
+var adaptiveStats = make(map[string]*AdaptiveStats) // by entry point service
+var adaptiveLock sync.Mutex
+
+// rejections are the failures that say a service is overloaded rather than broken, a 429 or 503 from an external service,
+// or load shedding and admission control
+var rejections = map[string]bool{gotocol.Failure("429"): true, gotocol.Failure("503"): true,
+	gotocol.Failure("shed"): true, gotocol.Failure("codel"): true, gotocol.Failure("admission"): true}
+
+func summarizeAdaptive() {
+	summary := make(map[string]AdaptiveStats, len(adaptiveStats))
+	for k, v := range adaptiveStats {
+		summary[k] = *v
+	}
+	collect.Summarize("adaptive", summary)
+}
+
+// newAIMD starts the adaptive clients of an entry point at its full rate, given as the interval between requests
+func newAIMD(e *archaius.Entrypoint, rate time.Duration) *aimd {
+	ad := e.Adaptive
+	a := &aimd{max: float64(time.Second) / float64(rate), increase: ad.Increase, decrease: ad.Decrease,
+		pending: make(map[gotocol.TraceContextType]bool)}
+	if a.increase == 0 {
+		a.increase = 1
+	}
+	if a.decrease == 0 {
+		a.decrease = 0.5
+	}
+	slowest, err := time.ParseDuration(ad.Min)
+	if err != nil || slowest <= 0 {
+		slowest = time.Second
+	}
+	a.min = float64(time.Second) / float64(slowest)
+	if a.min > a.max {
+		a.min = a.max
+	}
+	if a.window, err = time.ParseDuration(ad.Window); err != nil {
+		a.window = 100 * time.Millisecond
+	}
+	a.rate = a.max
+	adaptiveLock.Lock()
+	defer adaptiveLock.Unlock()
+	a.stats = adaptiveStats[e.Service] // the clients carry on from where they were if the chat rate changes
+	if a.stats == nil {
+		a.stats = &AdaptiveStats{MinRate: a.max}
+		adaptiveStats[e.Service] = a.stats
+	}
+	a.stats.Offered, a.stats.Rate = a.max, a.rate
+	return a
+}
+
+// interval until the clients send their next request
+func (a *aimd) interval() time.Duration {
+	adaptiveLock.Lock()
+	defer adaptiveLock.Unlock()
+	return time.Duration(float64(time.Second) / a.rate)
+}
+
+// adaptiveTicks sends the index of the entry point each time its adaptive clients send a request, until stop or done is closed
+func adaptiveTicks(i int, a *aimd, starts chan int, stop, done chan bool) {
+	for {
+		select {
+		case <-clock.After(a.interval()):
+			select {
+			case starts <- i:
+			case <-stop:
+				return
+			case <-done:
+				return
+			}
+		case <-stop:
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// sent counts a request from the adaptive clients, and remembers a get by its trace until its response arrives
+func (a *aimd) sent(sm gotocol.Message) {
+	adaptiveLock.Lock()
+	defer adaptiveLock.Unlock()
+	a.stats.Requests++
+	a.stats.rateTotal += a.rate
+	a.stats.MeanRate = a.stats.rateTotal / float64(a.stats.Requests)
+	if sm.Imposition == gotocol.GetRequest { // puts aren't answered
+		a.pending[sm.Ctx.Trace] = true
+	}
+	summarizeAdaptive()
+}
+
+// adapt the rate of the adaptive clients the response is for, adding to it on a success so it goes up by the increase each
+// second at the rate it's at, and cutting it on a rejection, once per window
+func adapt(msg gotocol.Message, clients []*aimd) {
+	adaptiveLock.Lock()
+	defer adaptiveLock.Unlock()
+	for _, a := range clients {
+		if a == nil || !a.pending[msg.Ctx.Trace] {
+			continue
+		}
+		delete(a.pending, msg.Ctx.Trace)
+		s := a.stats
+		switch {
+		case !gotocol.Failed(msg.Intention):
+			s.Successes++
+			if a.rate += a.increase / a.rate; a.rate > a.max {
+				a.rate = a.max
+			}
+		case rejections[msg.Intention]:
+			s.Rejections++
+			if clock.Since(a.decreased) >= a.window {
+				a.decreased = clock.Now()
+				s.Decreases++
+				if a.rate *= a.decrease; a.rate < a.min {
+					a.rate = a.min
+				}
+			}
+		default:
+			s.Failures++
+		}
+		s.Rate = a.rate
+		if a.rate < s.MinRate {
+			s.MinRate = a.rate
+		}
+		summarizeAdaptive()
+		return
+	}
+}
@@ -0,0 +1,62 @@
+package denominator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// request a client sent to an entry point, waiting for its response so the availability it saw can be measured end to end
+type request struct {
+	from, service string
+	sent          time.Time
+}
+
+var requests = make(map[gotocol.TraceContextType]request) // by trace
+var requestLock sync.Mutex
+
+// requested remembers a get sent to an entry point until its response arrives, puts aren't answered
+func requested(sm gotocol.Message, from, service string) {
+	if sm.Imposition != gotocol.GetRequest || !archaius.Conf.Collect {
+		return
+	}
+	requestLock.Lock()
+	defer requestLock.Unlock()
+	requests[sm.Ctx.Trace] = request{from, service, sm.Sent}
+}
+
+// responded counts the response to a get sent to an entry point as available unless it failed
+func responded(msg gotocol.Message) {
+	requestLock.Lock()
+	r, ok := requests[msg.Ctx.Trace]
+	delete(requests, msg.Ctx.Trace)
+	requestLock.Unlock()
+	if ok {
+		collect.MeasureEntry(r.service, gotocol.Failed(msg.Intention))
+	}
+}
+
+// unanswered counts the gets a denominator sent that are still waiting for a response when it goes away as failed, if they
+// were sent longer ago than the timeout of its edge to the entry point, default 1s, the rest could still have been answered
+func unanswered(name string) {
+	requestLock.Lock()
+	defer requestLock.Unlock()
+	for t, r := range requests {
+		if r.from != name {
+			continue
+		}
+		timeout, err := time.ParseDuration(archaius.Edge(names.Service(name), r.service).Timeout)
+		if err != nil || timeout <= 0 {
+			timeout = time.Second
+		}
+		if clock.Since(r.sent) > timeout {
+			collect.MeasureEntry(r.service, true)
+		}
+		delete(requests, t)
+	}
+}
@@ -3,15 +3,16 @@
 package denominator
 
 import (
-	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
 	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
 	"github.com/adrianco/spigo/tooling/ribbon"
+	"github.com/go-kit/kit/metrics/generic"
 	"log"
-	"math/rand"
 	"time"
 )
 
@@ -28,10 +29,23 @@ func Start(listener chan gotocol.Message) {
 	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)*archaius.Conf.Regions) // service registry per zone and region
 	var chatrate time.Duration
 	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
-	eurekaTicker := time.NewTicker(ep)
-	chatTicker := time.NewTicker(time.Hour)
+	eurekaTicker := clock.NewTicker(ep)
+	chatTicker := clock.NewTicker(time.Hour)
 	chatTicker.Stop()
-	w := 1 // counter for random messages
+	w := 1                                                  // counter for random messages
+	var journeyStarts chan int                              // index of the journey to start, nil unless the architecture has journeys
+	sessions := make(map[gotocol.TraceContextType]*session) // users part way through a journey, by the trace of their current step
+	done := make(chan bool)                                 // stops the journey tickers
+	var entryStarts chan int                                // index of the entry point to send to, nil unless the architecture has them
+	var entryStop chan bool                                 // stops the entry point tickers when the chat rate changes
+	var adaptive []*aimd                                    // rate of the adaptive clients of each entry point, nil for the others
+	var probeStarts chan int                                // index of the probe to send, nil unless the architecture has probes
+	probes := make(map[gotocol.TraceContextType]probing)    // canary requests waiting for their response, by trace
+	probehists := make(map[string]*generic.Histogram)       // latency seen by each probe, apart from the organic traffic
+	clients := make(map[gotocol.TraceContextType]client)    // gets to entry points with ephemeral clients, by trace
+	var script []scripted                                   // requests from the -requestfile, sent instead of any at a rate
+	var scriptStarts chan int                               // index of the next request of the script, nil until it starts
+	scriptWaiting := false                                  // for an entry point to send the script to
 	for {
 		select {
 		case msg := <-listener:
@@ -42,15 +56,26 @@ func Start(listener chan gotocol.Message) {
 					// if I don't have a name yet remember what I've been named
 					parent = msg.ResponseChan // remember how to talk to my namer
 					name = msg.Intention      // message body is my name
+					microservices.Own(name)
 					nethist = collect.NewHist(name + "_net")
 					resphist = collect.NewHist(name + "_resp")
 					servhist = collect.NewHist(name + "_serv")
 					rthist = collect.NewHist(name + "_rt")
+					for _, p := range archaius.Probes() {
+						probehists[p.Name] = collect.NewHist(name + "_probe_" + p.Name)
+					}
+					if archaius.Conf.RequestFile != "" {
+						script = readScript(archaius.Conf.RequestFile)
+					}
 				}
 			case gotocol.Inform:
 				eureka[msg.Intention] = handlers.Inform(msg, name, listener)
 			case gotocol.NameDrop:
 				handlers.NameDrop(&dependencies, microservices, msg, name, listener, eureka, true)
+				if scriptWaiting && entrypoints(microservices, "").Len() > 0 {
+					scriptWaiting = false
+					scriptStarts = startScript(script, done)
+				}
 			case gotocol.Forget:
 				// forget a buddy
 				handlers.Forget(&dependencies, microservices, msg)
@@ -59,11 +84,40 @@ func Start(listener chan gotocol.Message) {
 				d, e := time.ParseDuration(msg.Intention)
 				if e == nil && d >= time.Millisecond && d <= time.Hour {
 					chatrate = d
-					chatTicker = time.NewTicker(chatrate)
+					if probeStarts == nil && len(archaius.Probes()) > 0 { // probes have their own intervals
+						probeStarts = startProbes(done)
+					}
+					if archaius.Conf.RequestFile != "" { // the script has its own times, from when there's an entry point to send to
+						if scriptStarts == nil && entrypoints(microservices, "").Len() > 0 {
+							scriptStarts = startScript(script, done)
+						} else if scriptStarts == nil {
+							scriptWaiting = true
+						}
+					} else if len(archaius.Journeys()) > 0 { // journeys have their own rates
+						if journeyStarts == nil {
+							journeyStarts = startJourneys(done)
+						}
+					} else if archaius.Entrypoints() != nil { // entry points without a rate of their own use the chat rate
+						if entryStop != nil {
+							close(entryStop)
+						}
+						entryStop = make(chan bool)
+						entryStarts, adaptive = startEntrypoints(chatrate, entryStop, done)
+					} else {
+						chatTicker.Stop() // the rate can be changed while running
+						chatTicker = clock.NewTicker(chatrate)
+					}
 				}
 			case gotocol.GetResponse:
-				// return path from a request, terminate and log response time in histograms
+				// return path from a request, terminate and log response time in histograms, apart from the organic ones for probes
+				if probed(msg, probes, probehists) {
+					break
+				}
+				answered(msg, clients)
+				responded(msg)
+				adapt(msg, adaptive)
 				flow.End(msg, resphist, servhist, rthist)
+				nextStep(msg, name, listener, microservices, sessions)
 			case gotocol.Goodbye:
 				if archaius.Conf.Msglog {
 					log.Printf("%v: Going away, was chatting every %v\n", name, chatrate)
@@ -72,34 +126,35 @@ func Start(listener chan gotocol.Message) {
 				collect.SaveHist(resphist, name, "_resp")
 				collect.SaveHist(servhist, name, "_serv")
 				collect.SaveHist(rthist, name, "_rt")
+				for p, h := range probehists {
+					collect.SaveHist(h, name, "_probe_"+p)
+				}
 				collect.SaveAllGuesses(name)
-				gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NilContext, name}.GoSend(parent)
+				unanswered(name)
+				close(done)
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
 				return
 			}
 		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
-			for dep := range dependencies {
-				for _, ch := range eureka {
-					ch <- gotocol.Message{gotocol.GetRequest, listener, time.Now(), gotocol.NilContext, dep}
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
 				}
 			}
+		case i := <-journeyStarts:
+			s := &session{journey: &archaius.Journeys()[i], started: clock.Now()}
+			journeyDone(s.journey, s.started, "started")
+			sendStep(s, name, listener, microservices, sessions)
+		case i := <-scriptStarts:
+			sendScripted(&script[i], name, listener, microservices)
+		case i := <-probeStarts:
+			sendProbe(i, name, listener, microservices, probes)
+		case i := <-entryStarts:
+			sendEntry(i, name, listener, microservices, &w, clients, adaptive[i])
 		case <-chatTicker.C:
-			c := microservices.Random()
-			if c != nil {
-				ctx := gotocol.NewTrace()
-				now := time.Now()
-				var sm gotocol.Message
-				switch rand.Intn(3) {
-				case 0:
-					sm = gotocol.Message{gotocol.GetRequest, listener, now, ctx, "why?"}
-				case 1:
-					q := rand.Intn(w) // pick a random key that has already been put
-					sm = gotocol.Message{gotocol.GetRequest, listener, now, ctx, fmt.Sprintf("Why%v%v", q, q*q)}
-				case 2:
-					sm = gotocol.Message{gotocol.Put, listener, now, ctx, fmt.Sprintf("Why%v%v me", w, w*w)}
-					w++ // put a new key each time
-				}
-				flow.AnnotateSend(sm, name) // service send logs creation time for this flow
-				sm.GoSend(c)
+			c, wan := entry(microservices)
+			if sm, ok := chat(c, name, listener, &w, 0, wan); ok {
+				requested(sm, name, names.Service(microservices.NameChan(c)))
 			}
 		}
 	}
@@ -0,0 +1,129 @@
+package denominator
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+var entryStats = make(map[string]int) // requests sent to each entry point
+var entryLock sync.Mutex
+
+// startEntrypoints starts a ticker for each entry point at its own rate, or the chat rate, that sends the index of the entry
+// point to send a request to, until stop or done is closed. Entry points with adaptive clients tick at the rate they've
+// adapted to instead, and have the state of their clients at the same index of the slice
+func startEntrypoints(chatrate time.Duration, stop, done chan bool) (chan int, []*aimd) {
+	starts := make(chan int)
+	eps := archaius.Entrypoints()
+	adaptive := make([]*aimd, len(eps))
+	for i, e := range eps {
+		rate := chatrate
+		if e.Rate != "" {
+			rate, _ = time.ParseDuration(e.Rate)
+		}
+		if e.Adaptive != nil {
+			adaptive[i] = newAIMD(&eps[i], rate)
+			go adaptiveTicks(i, adaptive[i], starts, stop, done)
+			continue
+		}
+		go func(i int, ticker *clock.Ticker) {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					select {
+					case starts <- i:
+					case <-stop:
+						return
+					case <-done:
+						return
+					}
+				case <-stop:
+					return
+				case <-done:
+					return
+				}
+			}
+		}(i, clock.NewTicker(rate))
+	}
+	return starts, adaptive
+}
+
+// entrypoints narrows the router to the configured entry points, or any of them if service is empty, and is the router itself
+// if the architecture doesn't have entry points
+func entrypoints(microservices *ribbon.Router, service string) *ribbon.Router {
+	eps := archaius.Entrypoints()
+	if eps == nil {
+		return microservices
+	}
+	return microservices.Select(func(n string) bool {
+		for _, e := range eps {
+			if names.Service(n) == e.Service && (service == "" || service == e.Service) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// sendEntry sends a random request to an instance of an entry point, and counts it in the entrypoints summary
+func sendEntry(i int, name string, listener chan gotocol.Message, microservices *ribbon.Router, w *int, clients map[gotocol.TraceContextType]client, a *aimd) {
+	e := &archaius.Entrypoints()[i]
+	setup := ephemeral(e)
+	c, wan := entry(entrypoints(microservices, e.Service))
+	sm, ok := chat(c, name, listener, w, setup, wan)
+	if !ok {
+		return
+	}
+	connected(e, sm, setup, clients)
+	requested(sm, name, e.Service)
+	if a != nil {
+		a.sent(sm)
+	}
+	entryLock.Lock()
+	entryStats[e.Service]++
+	summary := make(map[string]int, len(entryStats))
+	for k, v := range entryStats {
+		summary[k] = v
+	}
+	entryLock.Unlock()
+	collect.Summarize("entrypoints", summary)
+}
+
+// chat sends a random get of a new or already put key, or a put of a new key, as a new trace, and is false if there's nowhere
+// to send it. A request from an ephemeral client arrives after the setup of its connection, so the setup shows up in the flow
+// between its "cs" and "sr", and it carries client=ephemeral in its baggage. One moved out of an evacuated region is sent after
+// the wan round trip as well
+func chat(c chan gotocol.Message, name string, listener chan gotocol.Message, w *int, setup, wan time.Duration) (gotocol.Message, bool) {
+	if c == nil {
+		return gotocol.Message{}, false
+	}
+	ctx := handlers.NewTrace(name)
+	if setup > 0 {
+		ctx = ctx.WithBaggage("client", "ephemeral")
+	}
+	now := clock.Now()
+	var sm gotocol.Message
+	switch archaius.Rand(name).Intn(3) {
+	case 0:
+		sm = gotocol.Message{gotocol.GetRequest, listener, now, ctx, "why?"}
+	case 1:
+		q := key(name, *w) // pick a key that has already been put
+		sm = gotocol.Message{gotocol.GetRequest, listener, now, ctx, fmt.Sprintf("Why%v%v", q, q*q)}
+	case 2:
+		sm = gotocol.Message{gotocol.Put, listener, now, ctx, fmt.Sprintf("Why%v%v me", *w, *w**w)}
+		*w++ // put a new key each time
+	}
+	flow.AnnotateSend(sm, name) // service send logs creation time for this flow
+	sm.GoSendAfter(c, setup+wan)
+	return sm, true
+}
@@ -0,0 +1,101 @@
+package denominator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// EphemeralStats compares the requests to an entry point from short lived clients, that open a new connection for each one,
+// with the ones from clients that keep their connection open
+type EphemeralStats struct {
+	Requests     int     `json:"requests"`
+	Ephemeral    int     `json:"ephemeral"`
+	Setup        float64 `json:"setupms"` // connection setup latency added to the ephemeral requests
+	PooledP50    float64 `json:"pooledp50ms"`
+	PooledP99    float64 `json:"pooledp99ms"`
+	EphemeralP50 float64 `json:"ephemeralp50ms"`
+	EphemeralP99 float64 `json:"ephemeralp99ms"`
+	pooled, eph  *generic.Histogram
+}
+
+// client of a get sent to an entry point with ephemeral clients, waiting for its response
+type client struct {
+	service   string
+	ephemeral bool
+	sent      time.Time
+}
+
+var ephemeralStats = make(map[string]*EphemeralStats) // by entry point service
+var ephemeralLock sync.Mutex
+
+func summarizeEphemeral() {
+	summary := make(map[string]EphemeralStats, len(ephemeralStats))
+	for k, v := range ephemeralStats {
+		summary[k] = *v
+	}
+	collect.Summarize("ephemeral", summary)
+}
+
+// ephemeral is the connection setup latency of a request to an entry point if it's from a short lived client, and zero if
+// it's from one that has a connection to reuse
+func ephemeral(e *archaius.Entrypoint) time.Duration {
+	if e.Ephemeral <= 0 || archaius.Rand(e.Service).Float64() >= e.Ephemeral {
+		return 0
+	}
+	setup, err := time.ParseDuration(e.Setup)
+	if err != nil {
+		setup = 20 * time.Millisecond
+	}
+	return setup
+}
+
+// connected counts a request sent to an entry point with ephemeral clients, and remembers a get by its trace until the
+// response arrives
+func connected(e *archaius.Entrypoint, sm gotocol.Message, setup time.Duration, clients map[gotocol.TraceContextType]client) {
+	if e.Ephemeral <= 0 {
+		return
+	}
+	ephemeralLock.Lock()
+	defer ephemeralLock.Unlock()
+	s := ephemeralStats[e.Service]
+	if s == nil {
+		s = &EphemeralStats{pooled: generic.NewHistogram(e.Service, 100), eph: generic.NewHistogram(e.Service, 100)}
+		ephemeralStats[e.Service] = s
+	}
+	s.Requests++
+	if setup > 0 {
+		s.Ephemeral++
+		s.Setup += float64(setup) / float64(time.Millisecond)
+	}
+	if sm.Imposition == gotocol.GetRequest { // puts aren't answered
+		clients[sm.Ctx.Trace] = client{e.Service, setup > 0, sm.Sent}
+	}
+	summarizeEphemeral()
+}
+
+// answered records the latency of the response to a get from a pooled or an ephemeral client
+func answered(msg gotocol.Message, clients map[gotocol.TraceContextType]client) {
+	c, ok := clients[msg.Ctx.Trace]
+	if !ok {
+		return
+	}
+	delete(clients, msg.Ctx.Trace)
+	ephemeralLock.Lock()
+	defer ephemeralLock.Unlock()
+	s := ephemeralStats[c.service]
+	ms := func(ns float64) float64 { return ns / float64(time.Millisecond) }
+	if c.ephemeral {
+		s.eph.Observe(float64(clock.Since(c.sent)))
+		s.EphemeralP50, s.EphemeralP99 = ms(s.eph.Quantile(0.5)), ms(s.eph.Quantile(0.99))
+	} else {
+		s.pooled.Observe(float64(clock.Since(c.sent)))
+		s.PooledP50, s.PooledP99 = ms(s.pooled.Quantile(0.5)), ms(s.pooled.Quantile(0.99))
+	}
+	summarizeEphemeral()
+}
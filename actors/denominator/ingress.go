@@ -0,0 +1,107 @@
+package denominator
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+var ingressStats = make(map[string]int)   // requests sent into each region
+var evacuatedStats = make(map[string]int) // requests moved out of an evacuated region, by the regions they moved from and to
+var ingressLock sync.Mutex
+
+// entry picks an entry point to send a request to, and a delay to send it after, which is the wan round trip for a request
+// that was moved out of an evacuated region
+func entry(router *ribbon.Router) (chan gotocol.Message, time.Duration) {
+	return evacuate(router, land(router))
+}
+
+// land picks a random entry point. With ingress weights the region is picked by weight first, like weighted global DNS,
+// out of the regions that have an entry point. If none of them have one, any entry point is used
+func land(router *ribbon.Router) chan gotocol.Message {
+	weights := archaius.Ingress()
+	if weights == nil {
+		return router.Random()
+	}
+	up := make(map[string]bool)
+	total := 0
+	for _, n := range router.Names() {
+		if r := names.Region(n); weights[r] > 0 && !up[r] {
+			up[r] = true
+			total += weights[r]
+		}
+	}
+	if total == 0 {
+		return router.Random()
+	}
+	var regions []string
+	for r := range up {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions) // same picks for the same random numbers
+	pick := router.Rand().Intn(total)
+	for _, r := range regions {
+		if pick < weights[r] {
+			ingressLock.Lock()
+			ingressStats[r]++
+			summary := make(map[string]int, len(ingressStats))
+			for k, v := range ingressStats {
+				summary[k] = v
+			}
+			ingressLock.Unlock()
+			collect.Summarize("ingress", summary)
+			return router.Select(func(n string) bool { return names.Region(n) == r }).Random()
+		}
+		pick -= weights[r]
+	}
+	return router.Random()
+}
+
+// evacuate moves a request that landed in an evacuated region to a random entry point in the nearest region that isn't, like
+// a global DNS failover. The users of the evacuated region are still where they were, so the request pays the wan latency
+// there and back on top of the usual latency of the region that takes it. If every region is evacuated it stays put
+func evacuate(router *ribbon.Router, c chan gotocol.Message) (chan gotocol.Message, time.Duration) {
+	if c == nil {
+		return nil, 0
+	}
+	from := names.Region(router.NameChan(c))
+	if from == "*" || !archaius.Evacuated(from) {
+		return c, 0
+	}
+	up := make(map[string]bool)
+	for _, n := range router.Names() {
+		if r := names.Region(n); r != "*" && !archaius.Evacuated(r) {
+			up[r] = true
+		}
+	}
+	var regions []string
+	for r := range up {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions) // the same region wins a tie each time
+	to := ""
+	var nearest time.Duration
+	for _, r := range regions {
+		if l := archaius.RegionLatency(from, r); to == "" || l < nearest {
+			to, nearest = r, l
+		}
+	}
+	if to == "" {
+		return c, 0
+	}
+	ingressLock.Lock()
+	evacuatedStats[from+"->"+to]++
+	summary := make(map[string]int, len(evacuatedStats))
+	for k, v := range evacuatedStats {
+		summary[k] = v
+	}
+	ingressLock.Unlock()
+	collect.Summarize("evacuated", summary)
+	return router.Select(func(n string) bool { return names.Region(n) == to }).Random(), 2 * nearest
+}
@@ -0,0 +1,135 @@
+package denominator
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// JourneyStats counts the user journeys of one kind, and how long the whole journey took from the first request to the last response
+type JourneyStats struct {
+	Started   int     `json:"started"`
+	Completed int     `json:"completed"`
+	Failed    int     `json:"failed"` // ended early by a failed step or no entry point to send it to
+	P50       float64 `json:"p50ms"`
+	P99       float64 `json:"p99ms"`
+	hist      *generic.Histogram
+}
+
+// session is a user part way through a journey
+type session struct {
+	journey *archaius.Journey
+	step    int
+	started time.Time
+}
+
+var journeyStats = make(map[string]*JourneyStats) // by journey name
+var journeyStatsLock sync.Mutex
+
+func summarizeJourneys() {
+	summary := make(map[string]JourneyStats, len(journeyStats))
+	for k, v := range journeyStats {
+		summary[k] = *v
+	}
+	collect.Summarize("journeys", summary)
+}
+
+// journeyDone counts a journey that has started, completed or failed
+func journeyDone(j *archaius.Journey, started time.Time, state string) {
+	journeyStatsLock.Lock()
+	defer journeyStatsLock.Unlock()
+	s := journeyStats[j.Name]
+	if s == nil {
+		s = &JourneyStats{hist: generic.NewHistogram(j.Name, 100)}
+		journeyStats[j.Name] = s
+	}
+	switch state {
+	case "started":
+		s.Started++
+	case "failed":
+		s.Failed++
+	case "completed":
+		s.Completed++
+		s.hist.Observe(float64(clock.Since(started)))
+		s.P50 = s.hist.Quantile(0.5) / float64(time.Millisecond)
+		s.P99 = s.hist.Quantile(0.99) / float64(time.Millisecond)
+	}
+	summarizeJourneys()
+}
+
+// startJourneys ticks each journey at its own rate, sending its index on the returned channel until done is closed
+func startJourneys(done chan bool) chan int {
+	starts := make(chan int)
+	for i, j := range archaius.Journeys() {
+		rate, _ := time.ParseDuration(j.Rate)
+		go func(i int, ticker *clock.Ticker) {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					select {
+					case starts <- i:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}(i, clock.NewTicker(rate))
+	}
+	return starts
+}
+
+// sendStep makes the next request of a session as a new trace with the journey and step in its baggage, so the flows can be
+// attributed to the journey, and remembers the session by the trace until the response arrives
+func sendStep(s *session, name string, listener chan gotocol.Message, microservices *ribbon.Router, sessions map[gotocol.TraceContextType]*session) {
+	step := s.journey.Steps[s.step]
+	router := entrypoints(microservices, "") // a step without a service goes to any entry point, or any dependency
+	if step.Service != "" {
+		router = microservices.Select(func(n string) bool { return names.Service(n) == step.Service })
+	}
+	c, wan := entry(router)
+	if c == nil {
+		journeyDone(s.journey, s.started, "failed")
+		return
+	}
+	ctx := handlers.NewTrace(name).WithBaggage("journey", s.journey.Name).WithBaggage("step", strconv.Itoa(s.step+1))
+	request := step.Request
+	if request == "" {
+		request = "why?"
+	}
+	sm := gotocol.Message{gotocol.GetRequest, listener, clock.Now(), ctx, request}
+	flow.AnnotateSend(sm, name)
+	sm.GoSendAfter(c, wan)
+	requested(sm, name, names.Service(router.NameChan(c)))
+	sessions[ctx.Trace] = s
+}
+
+// nextStep moves a session on when the response to its last request arrives, it's ignored if it isn't part of a journey
+func nextStep(msg gotocol.Message, name string, listener chan gotocol.Message, microservices *ribbon.Router, sessions map[gotocol.TraceContextType]*session) {
+	s := sessions[msg.Ctx.Trace]
+	if s == nil {
+		return
+	}
+	delete(sessions, msg.Ctx.Trace)
+	if gotocol.Failed(msg.Intention) {
+		journeyDone(s.journey, s.started, "failed")
+		return
+	}
+	if s.step++; s.step < len(s.journey.Steps) {
+		sendStep(s, name, listener, microservices, sessions)
+		return
+	}
+	journeyDone(s.journey, s.started, "completed")
+}
@@ -0,0 +1,32 @@
+package denominator
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+var zipfRand *rand.Rand // its own source, from the seed keyval or 1, so a skewed workload is the same for the same seed
+var zipfOnce sync.Once
+var zipfLock sync.Mutex
+
+// key picks which of the w keys put so far a get is for, uniformly or with a zipf distribution over the order they were put
+// in, by the keyaccess of the service
+func key(name string, w int) int {
+	k := archaius.Service(names.Service(name)).KeyAccess
+	if k == nil || k.Distribution != "zipf" || w < 2 {
+		return archaius.Rand(name).Intn(w)
+	}
+	zipfOnce.Do(func() {
+		zipfRand = rand.New(rand.NewSource(archaius.Seed()))
+	})
+	s := k.Skew
+	if s == 0 {
+		s = 1.1
+	}
+	zipfLock.Lock()
+	defer zipfLock.Unlock()
+	return int(rand.NewZipf(zipfRand, s, 1, uint64(w-1)).Uint64())
+}
@@ -0,0 +1,139 @@
+package denominator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// ProbeStats counts the canary requests of a synthetic monitor, and the latency it saw, apart from the organic traffic
+type ProbeStats struct {
+	Service   string  `json:"service,omitempty"`
+	Sent      int     `json:"sent"`
+	Succeeded int     `json:"succeeded"`
+	Failed    int     `json:"failed"` // failed responses, or no entry point to send it to
+	P50       float64 `json:"p50ms"`
+	P99       float64 `json:"p99ms"`
+	Max       float64 `json:"maxms"`
+	hist      *generic.Histogram
+}
+
+// probing is a canary request waiting for its response
+type probing struct {
+	probe int // index in the probes of the architecture
+	sent  time.Time
+}
+
+var probeStats = make(map[string]*ProbeStats) // by probe name
+var probeStatsLock sync.Mutex
+
+func summarizeProbes() {
+	summary := make(map[string]ProbeStats, len(probeStats))
+	for k, v := range probeStats {
+		summary[k] = *v
+	}
+	collect.Summarize("probes", summary)
+}
+
+// probeStat finds the stats of a probe, the caller holds probeStatsLock
+func probeStat(p *archaius.Probe) *ProbeStats {
+	s := probeStats[p.Name]
+	if s == nil {
+		s = &ProbeStats{Service: p.Service, hist: generic.NewHistogram(p.Name, 100)}
+		probeStats[p.Name] = s
+	}
+	return s
+}
+
+// startProbes ticks each probe at its own interval, sending its index on the returned channel until done is closed
+func startProbes(done chan bool) chan int {
+	starts := make(chan int)
+	for i, p := range archaius.Probes() {
+		interval, _ := time.ParseDuration(p.Interval)
+		go func(i int, ticker *clock.Ticker) {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					select {
+					case starts <- i:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}(i, clock.NewTicker(interval))
+	}
+	return starts
+}
+
+// sendProbe sends the canary request of a probe as a new trace with the probe in its synthetic baggage, so its flows can be
+// told apart from the organic ones, and remembers it by the trace until the response arrives
+func sendProbe(i int, name string, listener chan gotocol.Message, microservices *ribbon.Router, probes map[gotocol.TraceContextType]probing) {
+	p := &archaius.Probes()[i]
+	router := entrypoints(microservices, "")
+	if p.Service != "" {
+		router = microservices.Select(func(n string) bool { return names.Service(n) == p.Service })
+	}
+	probeStatsLock.Lock()
+	defer probeStatsLock.Unlock()
+	s := probeStat(p)
+	s.Sent++
+	c, wan := entry(router)
+	if c == nil {
+		s.Failed++
+		summarizeProbes()
+		return
+	}
+	ctx := handlers.NewTrace(name).WithBaggage("synthetic", p.Name)
+	request := p.Request
+	if request == "" {
+		request = "probe"
+	}
+	sm := gotocol.Message{gotocol.GetRequest, listener, clock.Now(), ctx, request}
+	flow.AnnotateSend(sm, name)
+	sm.GoSendAfter(c, wan)
+	probes[ctx.Trace] = probing{i, sm.Sent}
+	summarizeProbes()
+}
+
+// probed records the latency of the response to a canary request in the histogram of its probe, and is false if the
+// response is to organic traffic
+func probed(msg gotocol.Message, probes map[gotocol.TraceContextType]probing, hists map[string]*generic.Histogram) bool {
+	pr, ok := probes[msg.Ctx.Trace]
+	if !ok {
+		return false
+	}
+	delete(probes, msg.Ctx.Trace)
+	p := &archaius.Probes()[pr.probe]
+	latency := clock.Since(pr.sent)
+	collect.Measure(hists[p.Name], latency)
+	probeStatsLock.Lock()
+	defer probeStatsLock.Unlock()
+	s := probeStat(p)
+	if gotocol.Failed(msg.Intention) {
+		s.Failed++
+		summarizeProbes()
+		return true
+	}
+	s.Succeeded++
+	s.hist.Observe(float64(latency))
+	s.P50 = s.hist.Quantile(0.5) / float64(time.Millisecond)
+	s.P99 = s.hist.Quantile(0.99) / float64(time.Millisecond)
+	if ms := float64(latency) / float64(time.Millisecond); ms > s.Max {
+		s.Max = ms
+	}
+	summarizeProbes()
+	return true
+}
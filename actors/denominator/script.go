@@ -0,0 +1,163 @@
+package denominator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// scripted is a request from the -requestfile, sent at a time after the traffic starts instead of at a rate. The traffic
+// starts at the chat rate message, or when the first entry point is found if there isn't one by then
+type scripted struct {
+	at        time.Duration
+	service   string // entry point, or * for any of them
+	put       bool
+	intention string // key of a get, key and value of a put
+	baggage   [][2]string
+	line      int
+}
+
+// ScriptStats counts the requests from the -requestfile
+type ScriptStats struct {
+	File     string `json:"file"`
+	Requests int    `json:"requests"`
+	Sent     int    `json:"sent"`
+	Unsent   int    `json:"unsent"` // no instance of the entry point to send it to
+}
+
+var scriptStats ScriptStats
+var scriptLock sync.Mutex
+
+// readScript reads the requests of a -requestfile, and stops the run if any of them are bad
+func readScript(fn string) []scripted {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Fatal("denominator: can't read -requestfile: " + err.Error())
+	}
+	defer f.Close()
+	script, err := parseScript(f)
+	if err != nil {
+		log.Fatalf("denominator: -requestfile %v: %v\n", fn, err)
+	}
+	scriptStats = ScriptStats{File: fn, Requests: len(script)}
+	collect.Summarize("requestfile", scriptStats)
+	return script
+}
+
+// parseScript reads requests one a line, with the time after the traffic starts, the entry point and optionally get or put,
+// the key, or key and value for a put, and key=value baggage items, such as "250ms homepage get Why11 tenant=gold". Blank
+// lines and lines starting with # are skipped, and the requests are sent in the order of the file, so their times have to be
+// in order
+func parseScript(r io.Reader) ([]scripted, error) {
+	var script []scripted
+	bad := func(n int, why string) ([]scripted, error) {
+		return nil, fmt.Errorf("bad request on line %v, %v", n, why)
+	}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return bad(n, "needs a time and an entry point")
+		}
+		at, err := time.ParseDuration(fields[0])
+		if err != nil || at < 0 {
+			return bad(n, "the time should be a duration such as 100ms")
+		}
+		if len(script) > 0 && at < script[len(script)-1].at {
+			return bad(n, "the times are out of order")
+		}
+		s := scripted{at: at, service: fields[1], line: n}
+		var words []string
+		for _, w := range fields[2:] {
+			if kv := strings.SplitN(w, "=", 2); len(kv) == 2 && kv[0] != "" {
+				s.baggage = append(s.baggage, [2]string{kv[0], kv[1]})
+			} else {
+				words = append(words, w)
+			}
+		}
+		if len(words) > 0 && (words[0] == "get" || words[0] == "put") {
+			s.put = words[0] == "put"
+			words = words[1:]
+		}
+		switch {
+		case s.put && len(words) != 2:
+			return bad(n, "a put needs a key and a value")
+		case !s.put && len(words) > 1:
+			return bad(n, "a get has one key")
+		case len(words) == 0:
+			s.intention = "why?"
+		default:
+			s.intention = strings.Join(words, " ")
+		}
+		script = append(script, s)
+	}
+	return script, scanner.Err()
+}
+
+// startScript sends the index of each request of the script at its time after now, one after the other so they keep their
+// order, until done is closed
+func startScript(script []scripted, done chan bool) chan int {
+	starts := make(chan int)
+	began := clock.Now()
+	go func() {
+		for i, s := range script {
+			select {
+			case <-clock.After(s.at - clock.Since(began)):
+			case <-done:
+				return
+			}
+			select {
+			case starts <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return starts
+}
+
+// sendScripted sends a request of the script as a new trace with its baggage, to an instance of its entry point
+func sendScripted(s *scripted, name string, listener chan gotocol.Message, microservices *ribbon.Router) {
+	router := entrypoints(microservices, "")
+	if s.service != "*" {
+		router = microservices.Select(func(n string) bool { return names.Service(n) == s.service })
+	}
+	scriptLock.Lock()
+	defer scriptLock.Unlock()
+	defer func() { collect.Summarize("requestfile", scriptStats) }()
+	c, wan := entry(router)
+	if c == nil {
+		scriptStats.Unsent++
+		log.Printf("denominator: no %v to send the request on line %v of the -requestfile to\n", s.service, s.line)
+		return
+	}
+	ctx := handlers.NewTrace(name)
+	for _, kv := range s.baggage {
+		ctx = ctx.WithBaggage(kv[0], kv[1])
+	}
+	imp := gotocol.GetRequest
+	if s.put {
+		imp = gotocol.Put
+	}
+	sm := gotocol.Message{imp, listener, clock.Now(), ctx, s.intention}
+	flow.AnnotateSend(sm, name)
+	sm.GoSendAfter(c, wan)
+	requested(sm, name, names.Service(router.NameChan(c)))
+	scriptStats.Sent++
+}
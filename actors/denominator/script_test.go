@@ -0,0 +1,48 @@
+package denominator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseScript checks the requests of a -requestfile are read with their times, entry points, keys and baggage, and a
+// malformed line is reported with its line number
+func TestParseScript(t *testing.T) {
+	script, err := parseScript(strings.NewReader(`# a comment, then a blank line
+
+0s homepage
+250ms homepage get Why11 tenant=gold
+250ms * put k1 v1 region=us-east-1 tenant=silver
+1s signup Why12
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scripted{
+		{at: 0, service: "homepage", intention: "why?", line: 3},
+		{at: 250 * time.Millisecond, service: "homepage", intention: "Why11", baggage: [][2]string{{"tenant", "gold"}}, line: 4},
+		{at: 250 * time.Millisecond, service: "*", put: true, intention: "k1 v1", baggage: [][2]string{{"region", "us-east-1"}, {"tenant", "silver"}}, line: 5},
+		{at: time.Second, service: "signup", intention: "Why12", line: 6},
+	}
+	if !reflect.DeepEqual(script, want) {
+		t.Errorf("read %+v\nwant %+v", script, want)
+	}
+	for _, c := range []struct {
+		script, why string
+	}{
+		{"homepage", "line 1, needs a time and an entry point"},
+		{"soon homepage", "line 1, the time should be a duration"},
+		{"-1s homepage", "line 1, the time should be a duration"},
+		{"1s homepage\n\n500ms homepage", "line 3, the times are out of order"},
+		{"1s homepage put k1", "line 1, a put needs a key and a value"},
+		{"1s homepage put k1 v1 v2", "line 1, a put needs a key and a value"},
+		{"1s homepage get k1 k2", "line 1, a get has one key"},
+		{"# fine\n1s homepage Why11 Why12", "line 2, a get has one key"},
+	} {
+		if s, err := parseScript(strings.NewReader(c.script)); err == nil || !strings.Contains(err.Error(), c.why) || s != nil {
+			t.Errorf("%q read as %+v, %v, want %v", c.script, s, err, c.why)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package edda
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/adrianco/spigo/actors/packagenames" // name definitions
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// catalog remembers every service seen and every service it called over the whole run, for -backstage
+var catalog = struct {
+	sync.Mutex
+	packages map[string]string          // package by service name
+	depends  map[string]map[string]bool // dependencies by service name
+}{packages: make(map[string]string), depends: make(map[string]map[string]bool)}
+
+// catalogNode records the service of a full node name
+func catalogNode(node string) {
+	catalog.Lock()
+	catalog.packages[names.Service(node)] = names.Package(node)
+	catalog.Unlock()
+}
+
+// catalogEdge records a dependency between the services of a space separated pair of full node names
+func catalogEdge(edge string) {
+	var from, to string
+	fmt.Sscanf(edge, "%s%s", &from, &to)
+	from, to = names.Service(from), names.Service(to)
+	if from == "" || to == "" || from == to { // peers of the same service aren't a dependency
+		return
+	}
+	catalog.Lock()
+	if catalog.depends[from] == nil {
+		catalog.depends[from] = make(map[string]bool)
+	}
+	catalog.depends[from][to] = true
+	catalog.Unlock()
+}
+
+var badEntityChars = regexp.MustCompile("[^a-zA-Z0-9_.-]+")
+var badTagChars = regexp.MustCompile("[^a-z0-9+#-]+")
+
+// entityRef is the kind and name a service is cataloged as, stores and the network plumbing are resources, the rest are components
+func entityRef(service string) (kind, typ, name string) {
+	name = strings.Trim(badEntityChars.ReplaceAllString(service, "-"), "-._")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	switch catalog.packages[service] {
+	case StorePkg, PriamCassandraPkg, RiakPkg, CachePkg:
+		return "Resource", "database", name
+	case ElbPkg:
+		return "Resource", "load-balancer", name
+	case DenominatorPkg:
+		return "Resource", "dns", name
+	case WorkqueuePkg, TopicPkg:
+		return "Resource", "queue", name
+	}
+	return "Component", "service", name
+}
+
+// writeCatalog writes each service as a Backstage catalog entity to json/<arch>_catalog-info.yaml, with the services it
+// called as dependsOn relations, all part of a System named after the architecture. Owners come from a team tag
+func writeCatalog() {
+	if !archaius.Conf.Backstage {
+		return
+	}
+	catalog.Lock()
+	defer catalog.Unlock()
+	fn := "json/" + archaius.Conf.Arch + "_catalog-info.yaml"
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	log.Printf("Writing %v services to Backstage catalog %v\n", len(catalog.packages), fn)
+	_, _, system := entityRef(archaius.Conf.Arch)
+	run := archaius.Run()
+	fmt.Fprintf(f, "apiVersion: backstage.io/v1alpha1\nkind: System\nmetadata:\n  name: %v\n", system)
+	if run.Description != "" {
+		fmt.Fprintf(f, "  description: %q\n", run.Description)
+	}
+	fmt.Fprintf(f, "spec:\n  owner: %v\n", "spigo")
+	var services []string
+	for s := range catalog.packages {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	for _, s := range services {
+		kind, typ, name := entityRef(s)
+		tags := archaius.Service(s).Tags
+		fmt.Fprintf(f, "---\napiVersion: backstage.io/v1alpha1\nkind: %v\nmetadata:\n  name: %v\n", kind, name)
+		fmt.Fprintf(f, "  annotations:\n    spigo/package: %q\n", catalog.packages[s])
+		if v := archaius.Service(s).Version; v != "" {
+			fmt.Fprintf(f, "    spigo/version: %q\n", v)
+		}
+		if len(tags) > 0 {
+			var keys []string
+			for k := range tags {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(f, "  tags:\n")
+			for _, k := range keys {
+				if t := strings.Trim(badTagChars.ReplaceAllString(strings.ToLower(k+"-"+tags[k]), "-"), "-"); t != "" {
+					fmt.Fprintf(f, "    - %v\n", t)
+				}
+			}
+		}
+		owner := "spigo"
+		if team := tags["team"]; team != "" {
+			_, _, owner = entityRef(team)
+		}
+		fmt.Fprintf(f, "spec:\n  type: %v\n", typ)
+		if kind == "Component" {
+			fmt.Fprintf(f, "  lifecycle: experimental\n")
+		}
+		fmt.Fprintf(f, "  owner: %v\n  system: %v\n", owner, system)
+		var deps []string
+		for d := range catalog.depends[s] {
+			k, _, n := entityRef(d)
+			deps = append(deps, strings.ToLower(k)+":"+n)
+		}
+		sort.Strings(deps)
+		if len(deps) > 0 {
+			fmt.Fprintf(f, "  dependsOn:\n")
+			for _, d := range deps {
+				fmt.Fprintf(f, "    - %v\n", d)
+			}
+		}
+	}
+}
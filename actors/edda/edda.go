@@ -2,17 +2,23 @@
 package edda
 
 import (
+	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/graphgexf"
+	"github.com/adrianco/spigo/tooling/graphhtml"
 	"github.com/adrianco/spigo/tooling/graphjson"
 	"github.com/adrianco/spigo/tooling/graphml"
 	"github.com/adrianco/spigo/tooling/graphneo4j"
+	"github.com/adrianco/spigo/tooling/graphviz"
 	"github.com/adrianco/spigo/tooling/names"
 	"log"
+	"sort"
 	"strings"
 	"sync"
-	"time"
 )
 
 // Logchan is a buffered channel for sending logging messages to, or nil if logging is off
@@ -30,6 +36,7 @@ func Start(name string) {
 	if Logchan == nil {
 		return
 	}
+	gotocol.Actor(Logchan, name)
 	var msg gotocol.Message
 	microservices := make(map[string]bool, archaius.Conf.Dunbar)
 	edges := make(map[string]bool, archaius.Conf.Dunbar)
@@ -45,31 +52,57 @@ func Start(name string) {
 	if archaius.Conf.Neo4jURL != "" {
 		graphneo4j.Setup(archaius.Conf.Neo4jURL)
 	}
-	for {
-		msg, ok = <-Logchan
-		collect.Measure(hist, time.Since(msg.Sent))
-		if !ok {
-			break // channel was closed
-		}
-		if archaius.Conf.Msglog {
-			log.Printf("%v(backlog %v): %v\n", name, len(Logchan), msg)
-		}
+	if archaius.Conf.GexfFile != "" {
+		graphgexf.Setup(archaius.Conf.GexfFile)
+	}
+	if archaius.Conf.GraphvizFile != "" {
+		graphviz.Setup(archaius.Conf.GraphvizFile)
+	}
+	if archaius.Conf.HTMLFile != "" {
+		graphhtml.Setup(archaius.Conf.HTMLFile)
+	}
+	filter := newTagFilter()
+	writeNode := func(msg gotocol.Message) {
+		node := names.FilterNode(msg.Intention)
+		microservices[node] = true
+		graphml.WriteNode(node+" "+names.Package(msg.Intention), tags(msg.Intention), msg.Sent)
+		graphjson.WriteNode(node+" "+names.Package(msg.Intention), tags(msg.Intention), msg.Sent)
+		graphneo4j.WriteNode(msg.Intention+" "+names.Package(msg.Intention), tags(msg.Intention), msg.Sent)
+		graphgexf.WriteNode(node, names.Service(msg.Intention), names.Package(msg.Intention), names.Region(msg.Intention), names.Zone(msg.Intention), tags(msg.Intention), msg.Sent)
+		graphviz.WriteNode(node, names.Service(msg.Intention))
+		graphhtml.WriteNode(node, names.Service(msg.Intention), names.Package(msg.Intention), names.Region(msg.Intention), names.Zone(msg.Intention), tags(msg.Intention))
+		addNode(node, names.Package(msg.Intention))
+	}
+	change := func(msg gotocol.Message) {
+		instance(msg)
 		switch msg.Imposition {
 		case gotocol.Inform:
+			catalogEdge(msg.Intention)
 			edge := names.FilterEdge(msg.Intention)
 			if edges[edge] == false { // only log an edge once
+				var from, to string
+				fmt.Sscanf(edge, "%s%s", &from, &to)
+				ok, neighbors := filter.edge(from, to)
+				for _, n := range neighbors {
+					writeNode(n)
+				}
+				if !ok || archaius.Conf.TagFilter != "" && !(microservices[from] && microservices[to]) {
+					break // filtered out, for now
+				}
 				edges[edge] = true
-				graphml.WriteEdge(edge)
+				graphml.WriteEdge(edge, msg.Sent)
 				graphjson.WriteEdge(edge, msg.Sent)
 				graphneo4j.WriteEdge(strings.Replace(msg.Intention, "-", "_", -1), msg.Sent)
+				graphgexf.WriteEdge(edge, msg.Sent)
+				graphviz.WriteEdge(edge)
+				graphhtml.WriteEdge(edge)
+				addEdge(edge)
 			}
 		case gotocol.Put:
+			catalogNode(msg.Intention)
 			node := names.FilterNode(msg.Intention)
-			if microservices[node] == false { // only log a node once
-				microservices[node] = true
-				graphml.WriteNode(node + " " + names.Package(msg.Intention))
-				graphjson.WriteNode(node+" "+names.Package(msg.Intention), msg.Sent)
-				graphneo4j.WriteNode(strings.Replace(msg.Intention, "-", "_", -1)+" "+names.Package(msg.Intention), msg.Sent)
+			if microservices[node] == false && filter.node(node, msg) { // only log a node once
+				writeNode(msg)
 			}
 		case gotocol.Forget: // forget the edge
 			// problem here in that edges may be reported multiple times from several sources
@@ -79,17 +112,65 @@ func Start(name string) {
 			if edges[edge] == true { // only remove an edge once
 				edges[edge] = false
 				graphjson.WriteForget(edge, msg.Sent)
+				removeEdge(edge)
 			}
 		case gotocol.Delete: // remove the node
 			node := names.FilterNode(msg.Intention)
+			filter.gone(node)
 			if microservices[node] == true { // only remove nodes that exist, and only log it once
 				microservices[node] = false
 				graphjson.WriteDone(node, msg.Sent)
+				removeNode(node)
 			}
 		}
 	}
+	var batch []gotocol.Message // changes made at the same time on a virtual clock
+	for {
+		msg, ok = <-Logchan
+		collect.Measure(hist, clock.Since(msg.Sent))
+		if !ok {
+			break // channel was closed
+		}
+		gotocol.Received(msg, name)
+		if flow.Msglog(name) {
+			log.Printf("%v(backlog %v): %v\n", name, len(Logchan), msg)
+		}
+		if !archaius.Conf.Virtual {
+			change(msg)
+			continue
+		}
+		// on a virtual clock the changes made at the same time arrive in whatever order the goroutines that made them ran,
+		// so they're put in order before they're logged, and the same run logs the same graph
+		if len(batch) > 0 && !msg.Sent.Equal(batch[0].Sent) {
+			batch = inOrder(batch, change)
+		}
+		batch = append(batch, msg)
+	}
+	inOrder(batch, change)
 	log.Println(name + ": closing")
 	graphml.Close()
 	graphjson.Close()
-	graphneo4j.Close()
+	graphneo4j.Close(flow.CallLatencies())
+	graphgexf.Close(flow.Calls())
+	graphviz.Close()
+	graphhtml.Close(flow.Calls())
+	closeStream()
+	writeCatalog()
+}
+
+// lifecycle is the order changes made at the same time are logged in, nodes come up, connect, disconnect then go
+var lifecycle = map[gotocol.Impositions]int{gotocol.Put: 0, gotocol.Inform: 1, gotocol.Forget: 2, gotocol.Delete: 3}
+
+// inOrder logs a batch of changes sorted by lifecycle then name, and returns it emptied for the next batch
+func inOrder(batch []gotocol.Message, change func(gotocol.Message)) []gotocol.Message {
+	sort.SliceStable(batch, func(i, j int) bool {
+		if lifecycle[batch[i].Imposition] != lifecycle[batch[j].Imposition] {
+			return lifecycle[batch[i].Imposition] < lifecycle[batch[j].Imposition]
+		}
+		return batch[i].Intention < batch[j].Intention
+	})
+	for _, m := range batch {
+		change(m)
+	}
+	return batch[:0]
 }
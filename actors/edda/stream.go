@@ -0,0 +1,133 @@
+package edda
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"golang.org/x/net/websocket"
+)
+
+// streamEvent is a change to the graph sent to the websocket clients of /graph
+type streamEvent struct {
+	Event   string `json:"event"` // addnode, removenode, addedge, removeedge or annotate
+	Time    string `json:"timestamp"`
+	Node    string `json:"node,omitempty"`
+	Package string `json:"package,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Kind    string `json:"kind,omitempty"`   // of an annotation, a timeline event such as killed, scaleup or partition
+	Detail  string `json:"detail,omitempty"` // the instance, service or group it happened to
+}
+
+// streamBuffer is how many events a client can fall behind by before it's disconnected, it can reconnect for a fresh snapshot
+const streamBuffer = 1000
+
+var streamClients = make(map[chan []byte]bool)
+var streamLock sync.Mutex
+
+// ServeStream registers the /graph websocket on the default http server, each client is sent the current nodes and edges
+// then every change edda sees as it happens, and the timeline events such as chaos kills and autoscaling as annotations
+func ServeStream() {
+	http.Handle("/graph", websocket.Handler(streamHandler))
+	collect.WatchTimeline(func(e collect.TimelineEvent) {
+		stream(streamEvent{Event: "annotate", Time: e.Time, Kind: e.Kind, Detail: e.Detail})
+	})
+}
+
+// stream sends an event to every client, dropping the ones that have fallen too far behind
+func stream(e streamEvent) {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	if len(streamClients) == 0 {
+		return
+	}
+	j, err := json.Marshal(e)
+	if err != nil {
+		log.Println("edda: stream", err)
+		return
+	}
+	for c := range streamClients {
+		select {
+		case c <- j:
+		default:
+			delete(streamClients, c)
+			close(c)
+		}
+	}
+}
+
+// streamNode and streamEdge send the changes to the topology, an edge is space separated source and target names
+func streamNode(event, node, pack string) {
+	stream(streamEvent{Event: event, Time: clock.Now().Format(time.RFC3339Nano), Node: node, Package: pack})
+}
+
+func streamEdge(event, edge string) {
+	st := strings.Fields(edge)
+	if len(st) != 2 {
+		return
+	}
+	stream(streamEvent{Event: event, Time: clock.Now().Format(time.RFC3339Nano), Source: st[0], Target: st[1]})
+}
+
+// closeStream ends every client's stream when edda closes
+func closeStream() {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	for c := range streamClients {
+		delete(streamClients, c)
+		close(c)
+	}
+}
+
+// streamHandler sends a client the snapshot then the changes, until it goes away or edda closes
+func streamHandler(ws *websocket.Conn) {
+	defer ws.Close()
+	streamLock.Lock()
+	t := snapshot()
+	c := make(chan []byte, len(t.Nodes)+len(t.Edges)+streamBuffer)
+	now := clock.Now().Format(time.RFC3339Nano)
+	for _, n := range t.Nodes {
+		j, _ := json.Marshal(streamEvent{Event: "addnode", Time: now, Node: n.Node, Package: n.Package})
+		c <- j
+	}
+	for _, e := range t.Edges {
+		j, _ := json.Marshal(streamEvent{Event: "addedge", Time: now, Source: e.Source, Target: e.Target})
+		c <- j
+	}
+	streamClients[c] = true
+	streamLock.Unlock()
+	gone := make(chan struct{})
+	go func() { // nothing is expected from the client, reading just notices it closing
+		io.Copy(ioutil.Discard, ws)
+		close(gone)
+	}()
+	defer func() {
+		streamLock.Lock()
+		if streamClients[c] {
+			delete(streamClients, c)
+			close(c)
+		}
+		streamLock.Unlock()
+	}()
+	for {
+		select {
+		case j, ok := <-c:
+			if !ok {
+				return
+			}
+			if _, err := ws.Write(j); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package edda
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"golang.org/x/net/websocket"
+)
+
+// dial the /graph websocket of the server
+func dial(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/graph", "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+// put a node through the edda logger
+func put(node string) {
+	Logchan <- gotocol.Message{gotocol.Put, nil, time.Now(), gotocol.NewTrace(), node}
+}
+
+// TestStream checks a client gets a frame for a node edda logs after the handshake, and a client that has closed or
+// stopped reading doesn't hold up the logger
+func TestStream(t *testing.T) {
+	Logchan = make(chan gotocol.Message, 10)
+	go Start("edda")
+	srv := httptest.NewServer(websocket.Handler(streamHandler))
+	defer srv.Close()
+	ws := dial(t, srv)
+	node := names.Make("test", "us-east-1", "zoneA", "streamdb", "store", 0)
+	put(node)
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for { // skipping the snapshot of anything logged before
+		var e streamEvent
+		if err := websocket.JSON.Receive(ws, &e); err != nil {
+			t.Fatalf("no frame for %v: %v", node, err)
+		}
+		if e.Node == names.FilterNode(node) {
+			if e.Event != "addnode" || e.Package != "store" || e.Time == "" {
+				t.Errorf("frame %+v", e)
+			}
+			break
+		}
+	}
+	ws.Close()
+	stalled := dial(t, srv) // never reads
+	defer stalled.Close()
+	done := make(chan bool)
+	go func() {
+		for i := 1; i <= 2*streamBuffer; i++ {
+			put(names.Make("test", "us-east-1", "zoneA", "streamdb", "store", i))
+		}
+		close(Logchan)
+		Wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the logger was held up by its clients")
+	}
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	if len(streamClients) != 0 {
+		t.Errorf("%v clients still streaming after edda closed", len(streamClients))
+	}
+}
@@ -0,0 +1,70 @@
+package edda
+
+import (
+	"strings"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// tags of the service a full node name belongs to
+func tags(node string) map[string]string {
+	return archaius.Service(names.Service(node)).Tags
+}
+
+// matches is true if the service of a full node name has the -tagfilter tag, or there is no filter
+func matches(node string) bool {
+	if archaius.Conf.TagFilter == "" {
+		return true
+	}
+	kv := strings.SplitN(archaius.Conf.TagFilter, "=", 2)
+	v, ok := tags(node)[kv[0]]
+	return ok && v == kv[1]
+}
+
+// tagFilter tracks which nodes are written to the graphs when -tagfilter is set.
+// With -tagneighbors a node that doesn't match is held back until an edge connects it to one that does
+type tagFilter struct {
+	matched map[string]bool            // by filtered node name
+	held    map[string]gotocol.Message // node Put messages waiting for a matching neighbor
+}
+
+func newTagFilter() *tagFilter {
+	return &tagFilter{make(map[string]bool), make(map[string]gotocol.Message)}
+}
+
+// node decides whether to write a new node now
+func (f *tagFilter) node(node string, msg gotocol.Message) bool {
+	if matches(msg.Intention) {
+		f.matched[node] = true
+		return true
+	}
+	if archaius.Conf.TagNeighbors {
+		f.held[node] = msg
+	}
+	return false
+}
+
+// edge decides whether to write an edge, and returns any neighbor nodes that need to be written first
+func (f *tagFilter) edge(from, to string) (bool, []gotocol.Message) {
+	if archaius.Conf.TagFilter == "" {
+		return true, nil
+	}
+	if !f.matched[from] && !f.matched[to] {
+		return false, nil
+	}
+	var neighbors []gotocol.Message
+	for _, n := range []string{from, to} {
+		if msg, ok := f.held[n]; ok {
+			delete(f.held, n)
+			neighbors = append(neighbors, msg)
+		}
+	}
+	return true, neighbors
+}
+
+// gone forgets a node that was deleted before it was needed
+func (f *tagFilter) gone(node string) {
+	delete(f.held, node)
+}
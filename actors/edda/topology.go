@@ -0,0 +1,194 @@
+package edda
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/graphjson"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// topology is edda's current view of the graph, served as json at /topology
+var topology = struct {
+	sync.RWMutex
+	version int                 // incremented on every change so clients can tell if anything happened
+	nodes   map[string]string   // node name to package name
+	edges   map[string]struct{} // space separated source and target names
+}{nodes: make(map[string]string), edges: make(map[string]struct{})}
+
+type topologyNode struct {
+	Node    string `json:"node"`
+	Package string `json:"package"`
+}
+
+type topologyEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// byNode sortable nodes
+type byNode []topologyNode
+
+func (a byNode) Len() int           { return len(a) }
+func (a byNode) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byNode) Less(i, j int) bool { return a[i].Node < a[j].Node }
+
+// byEdge sortable edges, by source then target
+type byEdge []topologyEdge
+
+func (a byEdge) Len() int      { return len(a) }
+func (a byEdge) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byEdge) Less(i, j int) bool {
+	if a[i].Source == a[j].Source {
+		return a[i].Target < a[j].Target
+	}
+	return a[i].Source < a[j].Source
+}
+
+type topologyV0r0 struct {
+	Version int            `json:"version"`
+	Nodes   []topologyNode `json:"nodes"`
+	Edges   []topologyEdge `json:"edges"`
+}
+
+// ServeTopology registers the /topology handler on the default http server
+func ServeTopology() {
+	http.HandleFunc("/topology", topologyHandler)
+}
+
+func addNode(node, pack string) {
+	topology.Lock()
+	topology.nodes[node] = pack
+	topology.version++
+	topology.Unlock()
+	streamNode("addnode", node, pack)
+}
+
+// removeNode and any edges to or from it
+func removeNode(node string) {
+	topology.Lock()
+	delete(topology.nodes, node)
+	for e := range topology.edges {
+		st := strings.Fields(e)
+		if len(st) == 2 && (st[0] == node || st[1] == node) {
+			delete(topology.edges, e)
+		}
+	}
+	topology.version++
+	topology.Unlock()
+	streamNode("removenode", node, "") // clients drop the edges to and from it too
+}
+
+func addEdge(edge string) {
+	topology.Lock()
+	topology.edges[edge] = struct{}{}
+	topology.version++
+	topology.Unlock()
+	streamEdge("addedge", edge)
+}
+
+func removeEdge(edge string) {
+	topology.Lock()
+	delete(topology.edges, edge)
+	topology.version++
+	topology.Unlock()
+	streamEdge("removeedge", edge)
+}
+
+// snapshot the topology in a stable order
+func snapshot() topologyV0r0 {
+	topology.RLock()
+	defer topology.RUnlock()
+	t := topologyV0r0{Version: topology.version, Nodes: make([]topologyNode, 0, len(topology.nodes)), Edges: make([]topologyEdge, 0, len(topology.edges))}
+	for n, p := range topology.nodes {
+		t.Nodes = append(t.Nodes, topologyNode{n, p})
+	}
+	for e := range topology.edges {
+		var te topologyEdge
+		fmt.Sscanf(e, "%s%s", &te.Source, &te.Target) // two space delimited names
+		t.Edges = append(t.Edges, te)
+	}
+	sort.Sort(byNode(t.Nodes))
+	sort.Sort(byEdge(t.Edges))
+	return t
+}
+
+// topologyHandler returns the current nodes and edges, or 304 if the If-None-Match version is current
+func topologyHandler(w http.ResponseWriter, r *http.Request) {
+	topology.RLock()
+	etag := fmt.Sprintf("\"%v\"", topology.version)
+	topology.RUnlock()
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	t := snapshot()
+	w.Header().Set("ETag", fmt.Sprintf("\"%v\"", t.Version)) // may have moved on since the check above
+	w.Header().Set("Content-Type", "application/json")
+	j, err := json.Marshal(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(j)
+}
+
+// instances tracks the full names of every node and edge, whatever filtering is done to the outputs, so checkpoints can recreate them
+var instances = struct {
+	sync.Mutex
+	nodes map[string]bool
+	edges map[string]bool // space separated source and target names
+}{nodes: make(map[string]bool), edges: make(map[string]bool)}
+
+// instance updates the full names from a message that edda has been sent
+func instance(msg gotocol.Message) {
+	instances.Lock()
+	defer instances.Unlock()
+	switch msg.Imposition {
+	case gotocol.Put:
+		instances.nodes[msg.Intention] = true
+	case gotocol.Inform:
+		instances.edges[msg.Intention] = true
+	case gotocol.Forget:
+		delete(instances.edges, msg.Intention)
+	case gotocol.Delete:
+		delete(instances.nodes, msg.Intention)
+		for e := range instances.edges {
+			st := strings.Fields(e)
+			if len(st) == 2 && (st[0] == msg.Intention || st[1] == msg.Intention) {
+				delete(instances.edges, e)
+			}
+		}
+	}
+}
+
+// Graph returns the full names of the current nodes and edges as graphjson elements, in a stable order, so they can be saved and reloaded
+func Graph() []graphjson.ElementV0r4 {
+	instances.Lock()
+	defer instances.Unlock()
+	var nodes, edges []string
+	for n := range instances.nodes {
+		nodes = append(nodes, n)
+	}
+	for e := range instances.edges {
+		edges = append(edges, e)
+	}
+	sort.Strings(nodes)
+	sort.Strings(edges)
+	g := make([]graphjson.ElementV0r4, 0, len(nodes)+len(edges))
+	for _, n := range nodes {
+		g = append(g, graphjson.ElementV0r4{Node: n, Package: names.Package(n)})
+	}
+	for i, e := range edges {
+		var source, target string
+		fmt.Sscanf(e, "%s%s", &source, &target) // two space delimited names
+		g = append(g, graphjson.ElementV0r4{Edge: fmt.Sprintf("e%v", i), Source: source, Target: target})
+	}
+	return g
+}
@@ -0,0 +1,62 @@
+package edda
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getTopology from the server, sending an If-None-Match etag if there is one, and return the response
+func getTopology(t *testing.T, srv *httptest.Server, etag string) *http.Response {
+	req, _ := http.NewRequest("GET", srv.URL+"/topology", nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// TestTopologyETag checks the topology comes with an etag, asking again with it gets a 304 until the graph changes, and
+// then the new graph comes with a new etag
+func TestTopologyETag(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(topologyHandler))
+	defer srv.Close()
+	addNode("topoweb", "karyon")
+	addNode("topodb", "store")
+	resp := getTopology(t, srv, "")
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("first get %v, etag %q", resp.StatusCode, etag)
+	}
+	resp = getTopology(t, srv, etag)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified || resp.Header.Get("ETag") != etag {
+		t.Errorf("get with a current etag %v, etag %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	addEdge("topoweb topodb")
+	resp = getTopology(t, srv, etag)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Fatalf("get after a change %v, etag %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	var topo topologyV0r0
+	if err := json.NewDecoder(resp.Body).Decode(&topo); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range topo.Edges {
+		found = found || e == topologyEdge{"topoweb", "topodb"}
+	}
+	if !found {
+		t.Errorf("new edge missing from %+v", topo)
+	}
+	removeNode("topodb")
+	if s := snapshot(); len(s.Edges) != len(topo.Edges)-1 || s.Version <= topo.Version {
+		t.Errorf("edge to a removed node left in %+v", s)
+	}
+}
@@ -4,6 +4,7 @@ package elb
 
 import (
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
@@ -21,7 +22,7 @@ func Start(listener chan gotocol.Message) {
 	var name string                                                               // remember my name
 	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
 	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
-	eurekaTicker := time.NewTicker(ep)
+	eurekaTicker := clock.NewTicker(ep)
 	hist := collect.NewHist("")
 	for {
 		select {
@@ -33,6 +34,7 @@ func Start(listener chan gotocol.Message) {
 					// if I don't have a name yet remember what I've been named
 					parent = msg.ResponseChan // remember how to talk to my namer
 					name = msg.Intention      // message body is my name
+					microservices.Own(name)
 					hist = collect.NewHist(name)
 				}
 			case gotocol.Inform:
@@ -52,13 +54,13 @@ func Start(listener chan gotocol.Message) {
 				// route the request on to a random dependency
 				handlers.Put(msg, name, listener, &requestor, microservices)
 			case gotocol.Goodbye:
-				gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NilContext, name}.GoSend(parent)
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
 				return
 			}
 		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
-			for dep := range dependencies {
-				for _, ch := range eureka {
-					ch <- gotocol.Message{gotocol.GetRequest, listener, time.Now(), gotocol.NilContext, dep}
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
 				}
 			}
 		}
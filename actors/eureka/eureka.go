@@ -5,10 +5,13 @@ package eureka
 import (
 	"github.com/adrianco/spigo/actors/edda"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
 	"github.com/adrianco/spigo/tooling/names"
 	"log"
+	"sort"
 	"sync"
 	"time"
 )
@@ -40,13 +43,15 @@ func Start(listener chan gotocol.Message, name string) {
 	eurekaservices := make(map[string]chan gotocol.Message, 2)
 	metadata := make(map[string]meta, archaius.Conf.Dunbar)
 	lastrequest := make(map[callback]time.Time) // remember time of last request for a service from this requestor
+	ttl := archaius.EurekaTTL()                 // with a TTL every poll confirms the online instances, not just the changes
 	log.Println(name + ": starting")
 	for {
 		msg, ok = <-listener
-		collect.Measure(hist, time.Since(msg.Sent))
+		collect.Measure(hist, clock.Since(msg.Sent))
 		if !ok {
 			break // channel was closed
 		}
+		gotocol.Received(msg, name)
 		//commented out because name service traffic is too much noise in the log
 		//if archaius.Conf.Msglog {
 		//	log.Printf("%v(backlog %v): %v\n", name, len(listener), msg)
@@ -59,11 +64,11 @@ func Start(listener chan gotocol.Message, name string) {
 			}
 		// for new nodes record the data, replicate and maybe pass on to be logged
 		case gotocol.Put:
-			if microservices[msg.Intention] == nil { // ignore duplicate requests
+			if microservices[msg.Intention] == nil || !metadata[msg.Intention].online { // ignore duplicate requests, but register again after a delete
 				microservices[msg.Intention] = msg.ResponseChan
 				metadata[msg.Intention] = meta{true, msg.Sent}
 				// replicate request, everyone ends up with the same timestamp for state change of this service
-				for _, c := range eurekaservices {
+				for _, c := range handlers.Registries(eurekaservices) {
 					gotocol.Message{gotocol.Replicate, msg.ResponseChan, msg.Sent, gotocol.NilContext, msg.Intention}.GoSend(c)
 				}
 				if edda.Logchan != nil {
@@ -85,38 +90,45 @@ func Start(listener chan gotocol.Message, name string) {
 				log.Fatal(name + ": empty GetRequest")
 			}
 			if microservices[msg.Intention] != nil { // matched a unique full name
-				gotocol.Message{gotocol.NameDrop, microservices[msg.Intention], time.Now(), gotocol.NilContext, msg.Intention}.GoSend(msg.ResponseChan)
+				gotocol.Message{gotocol.NameDrop, microservices[msg.Intention], clock.Now(), gotocol.NilContext, msg.Intention}.GoSend(msg.ResponseChan)
 				break
 			}
-			for n, ch := range microservices { // respond with all the online names that match the service component
+			// respond with all the online names that match the service component, in the same order every run
+			var matched []string
+			for n := range microservices {
 				if names.Service(n) == msg.Intention {
-					// if there was an update for the looked up service since last check
-					// log.Printf("%v: matching %v with %v, last: %v metadata: %v\n", name, n, msg.Intention, lastrequest[callback{n, msg.ResponseChan}], metadata[n].registered)
-					if metadata[n].registered.After(lastrequest[callback{n, msg.ResponseChan}]) {
-						if metadata[n].online {
-							gotocol.Message{gotocol.NameDrop, ch, time.Now(), gotocol.NilContext, n}.GoSend(msg.ResponseChan)
-						} else {
-							//log.Printf("%v:Forget %v\n", name, n)
-							gotocol.Message{gotocol.Forget, ch, time.Now(), gotocol.NilContext, n}.GoSend(msg.ResponseChan)
-						}
+					matched = append(matched, n)
+				}
+			}
+			sort.Strings(matched)
+			for _, n := range matched {
+				ch := microservices[n]
+				// if there was an update for the looked up service since last check
+				// log.Printf("%v: matching %v with %v, last: %v metadata: %v\n", name, n, msg.Intention, lastrequest[callback{n, msg.ResponseChan}], metadata[n].registered)
+				if metadata[n].registered.After(lastrequest[callback{n, msg.ResponseChan}]) || (ttl > 0 && metadata[n].online) {
+					if metadata[n].online {
+						gotocol.Message{gotocol.NameDrop, ch, clock.Now(), gotocol.NilContext, n}.GoSend(msg.ResponseChan)
+					} else {
+						//log.Printf("%v:Forget %v\n", name, n)
+						gotocol.Message{gotocol.Forget, ch, clock.Now(), gotocol.NilContext, n}.GoSend(msg.ResponseChan)
 					}
-					// remember for next time
-					lastrequest[callback{n, msg.ResponseChan}] = msg.Sent
 				}
+				// remember for next time
+				lastrequest[callback{n, msg.ResponseChan}] = msg.Sent
 			}
 		case gotocol.Delete: // remove a node
 			if microservices[msg.Intention] != nil { // matched a unique full name
-				metadata[msg.Intention] = meta{false, time.Now()}
+				metadata[msg.Intention] = meta{false, clock.Now()}
 				// replicate request
-				for _, c := range eurekaservices {
-					gotocol.Message{gotocol.Replicate, nil, time.Now(), gotocol.NilContext, msg.Intention}.GoSend(c)
+				for _, c := range handlers.Registries(eurekaservices) {
+					gotocol.Message{gotocol.Replicate, nil, clock.Now(), gotocol.NilContext, msg.Intention}.GoSend(c)
 				}
 				if edda.Logchan != nil {
 					edda.Logchan <- msg
 				}
 			}
 		case gotocol.Goodbye:
-			gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NilContext, name}.GoSend(msg.ResponseChan)
+			gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(msg.ResponseChan)
 			log.Println(name + ": closing")
 			return
 		}
@@ -0,0 +1,180 @@
+// Package external simulates a third party API such as a payment gateway, that the architecture depends on but doesn't control
+// It has its own rate limit, response time distribution and outages, so the callers can see how they degrade when it throttles or goes down
+package external
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// ExternalStats is what happened to the requests to an external service
+type ExternalStats struct {
+	Requests  int     `json:"requests"`
+	Served    int     `json:"served"`
+	Throttled int     `json:"throttled"` // failed with a 429 over the rate limit
+	Down      int     `json:"down"`      // failed with a 503 during an outage
+	Mean      float64 `json:"meanms"`    // response time of the ones served
+	total     time.Duration
+}
+
+// limiter is a token bucket shared by the instances of an external service, as the rate limit is for the whole API
+type limiter struct {
+	tokens float64
+	last   time.Time
+}
+
+var stats = make(map[string]*ExternalStats) // by service name
+var limiters = make(map[string]*limiter)
+var lock sync.Mutex
+
+func summarize() {
+	summary := make(map[string]ExternalStats, len(stats))
+	for k, v := range stats {
+		summary[k] = *v
+	}
+	collect.Summarize("external", summary)
+}
+
+// down is true during one of the outages of an external service
+func down(x *archaius.ExternalConfig) bool {
+	now := collect.Elapsed()
+	for _, o := range x.Outages {
+		s, _ := time.ParseDuration(o.Start)
+		d, _ := time.ParseDuration(o.Duration)
+		if now >= s && now < s+d {
+			return true
+		}
+	}
+	return false
+}
+
+// allow takes a token for a request to a service, refilled at the rate up to the burst, and is false if there isn't one.
+// Called with the lock held
+func allow(service string, x *archaius.ExternalConfig) bool {
+	if x.Rate <= 0 {
+		return true
+	}
+	burst := float64(x.Burst)
+	if burst == 0 {
+		burst = math.Max(1, x.Rate)
+	}
+	now := clock.Now()
+	l := limiters[service]
+	if l == nil {
+		l = &limiter{burst, now}
+		limiters[service] = l
+	}
+	l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*x.Rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// respond to a request with a failure straight away
+func fail(msg gotocol.Message, name string, listener chan gotocol.Message, why string) {
+	collect.MeasureService(names.Service(name), clock.Since(msg.Sent), true)
+	outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, gotocol.Failure(why)}
+	flow.AnnotateSend(outmsg, name)
+	handlers.Remember(outmsg, name) // forgets the request, so a retry isn't answered from the dedup cache
+	outmsg.GoRespond(msg.ResponseChan)
+}
+
+// request is checked against the outages and the rate limit, and answered after a response time drawn from the distribution
+func request(msg gotocol.Message, name string, listener chan gotocol.Message, x *archaius.ExternalConfig) {
+	service := names.Service(name)
+	lock.Lock()
+	s := stats[service]
+	if s == nil {
+		s = &ExternalStats{}
+		stats[service] = s
+	}
+	s.Requests++
+	var why string
+	if down(x) {
+		why = "503"
+		s.Down++
+	} else if !allow(service, x) {
+		why = "429"
+		s.Throttled++
+	}
+	summarize()
+	lock.Unlock()
+	if why != "" {
+		fail(msg, name, listener, why)
+		return
+	}
+	mean, _ := time.ParseDuration(x.Latency)
+	l := handlers.Draw(name, mean, x.Distribution)
+	clock.AfterFunc(l, func() { // the response time is spent in the API, between the sr and ss annotations of the flow
+		collect.MeasureService(service, clock.Since(msg.Sent), false)
+		outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, "ok"}
+		flow.AnnotateSend(outmsg, name)
+		handlers.Remember(outmsg, name)
+		outmsg.GoRespond(msg.ResponseChan)
+		lock.Lock()
+		defer lock.Unlock()
+		s.Served++
+		s.total += l
+		s.Mean = float64(s.total) / float64(s.Served) / float64(time.Millisecond)
+		summarize()
+	})
+}
+
+// Start external, all configuration and state is sent via messages
+func Start(listener chan gotocol.Message) {
+	microservices := ribbon.MakeRouter()
+	dependencies := make(map[string]time.Time)                                    // dependent services and time last updated
+	var parent chan gotocol.Message                                               // remember how to talk back to creator
+	var name string                                                               // remember my name
+	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
+	x := new(archaius.ExternalConfig)                                             // an API without a config has no limit and answers straight away
+	hist := collect.NewHist("")
+	for {
+		msg := <-listener
+		flow.Instrument(msg, name, hist)
+		switch msg.Imposition {
+		case gotocol.Hello:
+			if name == "" {
+				// if I don't have a name yet remember what I've been named
+				parent = msg.ResponseChan // remember how to talk to my namer
+				name = msg.Intention      // message body is my name
+				microservices.Own(name)
+				hist = collect.NewHist(name)
+				if c := archaius.Service(names.Service(name)).External; c != nil {
+					x = c
+				}
+			}
+		case gotocol.Inform:
+			eureka[msg.Intention] = handlers.Inform(msg, name, listener)
+		case gotocol.NameDrop:
+			handlers.NameDrop(&dependencies, microservices, msg, name, listener, eureka)
+		case gotocol.Forget:
+			// forget a buddy
+			handlers.Forget(&dependencies, microservices, msg)
+		case gotocol.GetRequest:
+			if handlers.OOM(msg, name, listener, nil) || handlers.Duplicate(msg, name, listener) || handlers.InjectError(msg, name, listener) {
+				break
+			}
+			request(msg, name, listener, x)
+		case gotocol.Goodbye:
+			for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+				ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
+			}
+			gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package featureflag simulates a feature flag service that holds the flags of the architecture
+// Flags are changed at runtime with a Put of name=on or name=off, and any service can query them with a GetRequest
+package featureflag
+
+import (
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/ribbon"
+	"log"
+	"strings"
+	"time"
+)
+
+// state of a flag as it's written in a request or response
+func state(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// values answers a query for one flag with its state, and anything else with every flag as name=state,name=state
+func values(query string) string {
+	if on, ok := archaius.FlagOn(query); ok {
+		return state(on)
+	}
+	var kv []string
+	for _, f := range archaius.Flags() {
+		on, _ := archaius.FlagOn(f.Name)
+		kv = append(kv, f.Name+"="+state(on))
+	}
+	return strings.Join(kv, ",")
+}
+
+// Start featureflag, all configuration and state is sent via messages
+func Start(listener chan gotocol.Message) {
+	microservices := ribbon.MakeRouter()
+	dependencies := make(map[string]time.Time)                                    // dependent services and time last updated
+	var parent chan gotocol.Message                                               // remember how to talk back to creator
+	var name string                                                               // remember my name
+	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
+	hist := collect.NewHist("")
+	for {
+		msg := <-listener
+		flow.Instrument(msg, name, hist)
+		switch msg.Imposition {
+		case gotocol.Hello:
+			if name == "" {
+				// if I don't have a name yet remember what I've been named
+				parent = msg.ResponseChan // remember how to talk to my namer
+				name = msg.Intention      // message body is my name
+				microservices.Own(name)
+				hist = collect.NewHist(name)
+			}
+		case gotocol.Inform:
+			eureka[msg.Intention] = handlers.Inform(msg, name, listener)
+		case gotocol.NameDrop:
+			handlers.NameDrop(&dependencies, microservices, msg, name, listener, eureka)
+		case gotocol.Forget:
+			// forget a buddy
+			handlers.Forget(&dependencies, microservices, msg)
+		case gotocol.GetRequest:
+			if handlers.OOM(msg, name, listener, nil) || handlers.Duplicate(msg, name, listener) || handlers.InjectError(msg, name, listener) {
+				break
+			}
+			outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, values(msg.Intention)}
+			flow.AnnotateSend(outmsg, name)
+			handlers.Remember(outmsg, name)
+			outmsg.GoRespond(msg.ResponseChan)
+		case gotocol.Put:
+			// change a flag, other writes that pass through aren't for a flag so they're ignored
+			var flag, value string
+			if kv := strings.SplitN(msg.Intention, "=", 2); len(kv) == 2 {
+				flag, value = kv[0], kv[1]
+			}
+			if (value == "on" || value == "off") && !handlers.FlipFlag(flag, value == "on", name) && archaius.Conf.Msglog {
+				log.Printf("%v: no flag %v to set\n", name, flag)
+			}
+		case gotocol.Goodbye:
+			for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+				ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
+			}
+			gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package lock simulates a distributed lock service such as Zookeeper or etcd, that grants each lock to one holder at a time
+// Acquires for a lock that is held queue until the holder releases it at the end of its critical section, so contention shows up as wait latency
+package lock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// LockStats is what happened to the acquires of a lock, summed over the instances of the lock service
+type LockStats struct {
+	Acquires  int     `json:"acquires"`
+	Granted   int     `json:"granted"`
+	Contended int     `json:"contended"` // had to wait for the lock to be released
+	TimedOut  int     `json:"timedout"`  // gave up waiting after the timeout
+	MaxQueue  int     `json:"maxqueue"`
+	MeanWait  float64 `json:"meanwaitms"` // of the ones granted
+	MaxWait   float64 `json:"maxwaitms"`
+	wait      time.Duration
+}
+
+// waiter is an acquire queued for a lock, answered by the instance it was sent to
+type waiter struct {
+	msg      gotocol.Message
+	name     string
+	listener chan gotocol.Message
+	arrived  time.Time
+}
+
+// state of a lock, which is shared by the instances of the lock service, as they agree on who holds it
+type state struct {
+	held  bool
+	queue []*waiter
+}
+
+var stats = make(map[string]map[string]*LockStats) // by service and lock name
+var locks = make(map[string]*state)                // by service and lock name
+var lock sync.Mutex
+
+func summarize() {
+	summary := make(map[string]map[string]LockStats, len(stats))
+	for sn, ks := range stats {
+		summary[sn] = make(map[string]LockStats, len(ks))
+		for k, v := range ks {
+			summary[sn][k] = *v
+		}
+	}
+	collect.Summarize("locks", summary)
+}
+
+// keys of a lock service, or a single lock that is released straight away if it doesn't have a config
+func keys(c *archaius.LockConfig) []archaius.LockKey {
+	if c == nil || len(c.Keys) == 0 {
+		return []archaius.LockKey{{Name: "lock"}}
+	}
+	return c.Keys
+}
+
+// pick the lock an acquire is for, at random by the weights of the locks
+func pick(name string, ks []archaius.LockKey) archaius.LockKey {
+	total := 0.0
+	for _, k := range ks {
+		total += weight(k)
+	}
+	r := archaius.Rand(name).Float64() * total
+	for _, k := range ks {
+		if r -= weight(k); r < 0 {
+			return k
+		}
+	}
+	return ks[len(ks)-1]
+}
+
+func weight(k archaius.LockKey) float64 {
+	if k.Weight == 0 {
+		return 1
+	}
+	return k.Weight
+}
+
+// acquire a lock for a request, it's granted straight away if it's free, otherwise the request waits its turn in the queue
+// until the timeout, if there is one. Called with the lock held
+func acquire(msg gotocol.Message, name string, listener chan gotocol.Message, c *archaius.LockConfig) {
+	service := names.Service(name)
+	k := pick(name, keys(c))
+	if stats[service] == nil {
+		stats[service] = make(map[string]*LockStats)
+	}
+	s := stats[service][k.Name]
+	if s == nil {
+		s = &LockStats{}
+		stats[service][k.Name] = s
+	}
+	l := locks[service+" "+k.Name]
+	if l == nil {
+		l = &state{}
+		locks[service+" "+k.Name] = l
+	}
+	s.Acquires++
+	w := &waiter{msg, name, listener, clock.Now()}
+	defer summarize()
+	if !l.held {
+		grant(w, l, s, k)
+		return
+	}
+	s.Contended++
+	l.queue = append(l.queue, w)
+	if len(l.queue) > s.MaxQueue {
+		s.MaxQueue = len(l.queue)
+	}
+	if t, _ := time.ParseDuration(c.Timeout); t > 0 {
+		clock.AfterFunc(t, func() {
+			lock.Lock()
+			defer lock.Unlock()
+			for i, q := range l.queue {
+				if q == w { // still waiting
+					l.queue = append(l.queue[:i], l.queue[i+1:]...)
+					s.TimedOut++
+					summarize()
+					respond(w, gotocol.Failure("lock timeout"), true)
+					return
+				}
+			}
+		})
+	}
+}
+
+// grant a lock to a waiter and release it after the critical section, for the next waiter in the queue. Called with the lock held
+func grant(w *waiter, l *state, s *LockStats, k archaius.LockKey) {
+	l.held = true
+	wait := clock.Since(w.arrived)
+	s.Granted++
+	s.wait += wait
+	s.MeanWait = float64(s.wait) / float64(s.Granted) / float64(time.Millisecond)
+	if ms := float64(wait) / float64(time.Millisecond); ms > s.MaxWait {
+		s.MaxWait = ms
+	}
+	respond(w, "locked "+k.Name, false)
+	hold, _ := time.ParseDuration(k.Hold)
+	clock.AfterFunc(handlers.Draw(w.name, hold, k.Distribution), func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(l.queue) == 0 {
+			l.held = false
+			return
+		}
+		next := l.queue[0]
+		l.queue = l.queue[1:]
+		grant(next, l, s, k)
+		summarize()
+	})
+}
+
+// respond to an acquire from the instance it was sent to
+func respond(w *waiter, intention string, failed bool) {
+	collect.MeasureService(names.Service(w.name), clock.Since(w.msg.Sent), failed)
+	outmsg := gotocol.Message{gotocol.GetResponse, w.listener, clock.Now(), w.msg.Ctx, intention}
+	flow.AnnotateSend(outmsg, w.name)
+	handlers.Remember(outmsg, w.name)
+	outmsg.GoRespond(w.msg.ResponseChan)
+}
+
+// Start lock, all configuration and state is sent via messages
+func Start(listener chan gotocol.Message) {
+	microservices := ribbon.MakeRouter()
+	dependencies := make(map[string]time.Time)                                    // dependent services and time last updated
+	var parent chan gotocol.Message                                               // remember how to talk back to creator
+	var name string                                                               // remember my name
+	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
+	c := new(archaius.LockConfig)                                                 // a lock service without a config has one lock, released straight away
+	hist := collect.NewHist("")
+	for {
+		msg := <-listener
+		flow.Instrument(msg, name, hist)
+		switch msg.Imposition {
+		case gotocol.Hello:
+			if name == "" {
+				// if I don't have a name yet remember what I've been named
+				parent = msg.ResponseChan // remember how to talk to my namer
+				name = msg.Intention      // message body is my name
+				microservices.Own(name)
+				hist = collect.NewHist(name)
+				if lc := archaius.Service(names.Service(name)).Lock; lc != nil {
+					c = lc
+				}
+			}
+		case gotocol.Inform:
+			eureka[msg.Intention] = handlers.Inform(msg, name, listener)
+		case gotocol.NameDrop:
+			handlers.NameDrop(&dependencies, microservices, msg, name, listener, eureka)
+		case gotocol.Forget:
+			// forget a buddy
+			handlers.Forget(&dependencies, microservices, msg)
+		case gotocol.GetRequest:
+			if handlers.OOM(msg, name, listener, nil) || handlers.Duplicate(msg, name, listener) || handlers.InjectError(msg, name, listener) {
+				break
+			}
+			lock.Lock()
+			acquire(msg, name, listener, c)
+			lock.Unlock()
+		case gotocol.Goodbye:
+			for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+				ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
+			}
+			gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
+			return
+		}
+	}
+}
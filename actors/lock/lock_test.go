@@ -0,0 +1,72 @@
+package lock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// instance of a lock service started with its name
+func instance(service string, i int) chan gotocol.Message {
+	listener := make(chan gotocol.Message)
+	go Start(listener)
+	listener <- gotocol.Message{gotocol.Hello, make(chan gotocol.Message, 1), time.Now(), gotocol.NilContext, names.Make("test", "us-east-1", "zoneA", service, "lock", i)}
+	return listener
+}
+
+// acquire the lock, the answer comes back on the returned channel
+func acquireFrom(listener chan gotocol.Message) chan gotocol.Message {
+	client := make(chan gotocol.Message, 1)
+	listener <- gotocol.Message{gotocol.GetRequest, client, time.Now(), gotocol.NewTrace(), "acquire"}
+	return client
+}
+
+func answer(t *testing.T, client chan gotocol.Message) (string, time.Time) {
+	select {
+	case m := <-client:
+		return m.Intention, time.Now()
+	case <-time.After(time.Second):
+		t.Fatal("acquire wasn't answered")
+	}
+	return "", time.Time{}
+}
+
+// TestContention checks a lock is held by one caller at a time across the instances of the service, the next acquire is
+// granted when the holder releases it, and one that waits longer than the timeout fails
+func TestContention(t *testing.T) {
+	archaius.SetService("zookeeper", archaius.ServiceConfig{Lock: &archaius.LockConfig{
+		Keys: []archaius.LockKey{{Name: "order", Hold: "30ms", Distribution: "fixed"}}, Timeout: "45ms"}})
+	a, b := instance("zookeeper", 0), instance("zookeeper", 1)
+	start := time.Now()
+	if got, _ := answer(t, acquireFrom(a)); got != "locked order" {
+		t.Fatalf("first acquire got %v", got)
+	}
+	// the two that queue can get there in either order, the one that gets the lock next waits for the first holder, and
+	// the other one would wait for both of them so it times out
+	next, last := acquireFrom(b), acquireFrom(a)
+	granted, timedout := 0, 0
+	for _, client := range []chan gotocol.Message{next, last} {
+		switch got, at := answer(t, client); got {
+		case "locked order":
+			if granted++; at.Sub(start) < 30*time.Millisecond {
+				t.Errorf("granted after %v, before the first holder released it", at.Sub(start))
+			}
+		case gotocol.Failure("lock timeout"):
+			timedout++
+		default:
+			t.Errorf("acquire got %v", got)
+		}
+	}
+	if granted != 1 || timedout != 1 {
+		t.Errorf("%v granted and %v timed out", granted, timedout)
+	}
+	lock.Lock()
+	s := *stats["zookeeper"]["order"]
+	lock.Unlock()
+	if s.Acquires != 3 || s.Granted != 2 || s.Contended != 2 || s.TimedOut != 1 || s.MaxQueue != 2 || s.MaxWait < 30 {
+		t.Errorf("stats %+v", s)
+	}
+}
@@ -16,7 +16,17 @@ const (
 	RiakPkg           = "riak"
 	VolumePkg         = "volume"
 	CachePkg          = "cache"
+	WorkqueuePkg      = "workqueue"
+	FeatureflagPkg    = "featureflag"
+	ExternalPkg       = "external"
+	SagaPkg           = "saga"
+	LockPkg           = "lock"
+	TwoPhasePkg       = "twophase"
+	TopicPkg          = "topic"
 )
 
 // Packages array of names
-var Packages = []string{EurekaPkg, PiratePkg, ElbPkg, DenominatorPkg, ZuulPkg, KaryonPkg, MonolithPkg, StaashPkg, PriamCassandraPkg, StorePkg, RiakPkg, VolumePkg, CachePkg}
+var Packages = []string{EurekaPkg, PiratePkg, ElbPkg, DenominatorPkg, ZuulPkg, KaryonPkg, MonolithPkg, StaashPkg, PriamCassandraPkg, StorePkg, RiakPkg, VolumePkg, CachePkg, WorkqueuePkg, FeatureflagPkg, ExternalPkg, SagaPkg, LockPkg, TwoPhasePkg, TopicPkg}
+
+// Forwarders pass the requests they get on to their dependencies, the other packages only talk to their peers or start requests
+var Forwarders = []string{ElbPkg, ZuulPkg, KaryonPkg, MonolithPkg, StaashPkg, WorkqueuePkg, SagaPkg, TwoPhasePkg}
@@ -5,11 +5,12 @@ package pirate
 import (
 	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/gotocol"
 	"github.com/adrianco/spigo/tooling/handlers"
 	"log"
-	"math/rand"
+	"sort"
 	"time"
 )
 
@@ -26,12 +27,13 @@ func Start(listener chan gotocol.Message) {
 	var logger chan gotocol.Message // if set, send updates
 	var chatrate time.Duration
 	hist := collect.NewHist("")
-	chatTicker := time.NewTicker(time.Hour)
+	chatTicker := clock.NewTicker(time.Hour)
 	chatTicker.Stop()
 	for {
 		select {
 		case msg := <-listener:
-			collect.Measure(hist, time.Since(msg.Sent))
+			collect.Measure(hist, clock.Since(msg.Sent))
+			gotocol.Received(msg, name)
 			if archaius.Conf.Msglog {
 				log.Printf("%v: %v\n", name, msg)
 			}
@@ -54,7 +56,7 @@ func Start(listener chan gotocol.Message) {
 					buddies[buddy] = msg.ResponseChan // message channel is buddy's listener
 					if logger != nil {
 						// if it's setup, tell the logger I have a new buddy to talk to
-						logger <- gotocol.Message{gotocol.Inform, listener, time.Now(), gotocol.NilContext, name + " " + buddy}
+						logger <- gotocol.Message{gotocol.Inform, listener, clock.Now(), gotocol.NilContext, name + " " + buddy}
 					}
 				}
 			case gotocol.Chat:
@@ -62,9 +64,7 @@ func Start(listener chan gotocol.Message) {
 				d, e := time.ParseDuration(msg.Intention)
 				if e == nil && d >= time.Millisecond && d <= time.Hour {
 					chatrate = d
-					chatTicker = time.NewTicker(chatrate)
-					// assume we got paid before we started chatting
-					rand.Seed(int64(booty))
+					chatTicker = clock.NewTicker(chatrate)
 				}
 			case gotocol.GoldCoin:
 				var coin int
@@ -81,43 +81,48 @@ func Start(listener chan gotocol.Message) {
 				if archaius.Conf.Msglog {
 					log.Printf("%v: Going away with %v gold coins, chatting every %v\n", name, booty, chatrate)
 				}
-				gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NilContext, name}.GoSend(fsm)
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(fsm)
 				return
 			}
 		case <-chatTicker.C:
-			if rand.Intn(100) < 50 { // 50% of the time
+			if archaius.Rand(name).Intn(100) < 50 { // 50% of the time
 				// use Namedrop to tell the last buddy about the first
 				var firstBuddyName string
 				var firstBuddyChan, lastBuddyChan chan gotocol.Message
 				if len(buddies) >= 2 {
-					for name, ch := range buddies {
+					for _, buddy := range inOrder(buddies) {
+						ch := buddies[buddy]
 						if firstBuddyName == "" {
-							firstBuddyName = name
+							firstBuddyName = buddy
 							firstBuddyChan = ch
 						} else {
 							lastBuddyChan = ch
 						}
-						gotocol.Message{gotocol.NameDrop, firstBuddyChan, time.Now(), gotocol.NewTrace(), firstBuddyName}.GoSend(lastBuddyChan)
+						gotocol.Message{gotocol.NameDrop, firstBuddyChan, clock.Now(), gotocol.NewTrace(), firstBuddyName}.GoSend(lastBuddyChan)
 					}
 				}
 			} else {
 				// send a buddy some money
 				if booty > 0 {
-					donation := rand.Intn(booty)
-					luckyNumber := rand.Intn(len(buddies))
+					donation := archaius.Rand(name).Intn(booty)
+					luckyNumber := archaius.Rand(name).Intn(len(buddies))
 					if donation > 0 {
-						for _, ch := range buddies {
-							if luckyNumber == 0 {
-								gotocol.Message{gotocol.GoldCoin, listener, time.Now(), gotocol.NewTrace(), fmt.Sprintf("%d", donation)}.GoSend(ch)
-								booty -= donation
-								break
-							} else {
-								luckyNumber--
-							}
-						}
+						lucky := buddies[inOrder(buddies)[luckyNumber]]
+						gotocol.Message{gotocol.GoldCoin, listener, clock.Now(), gotocol.NewTrace(), fmt.Sprintf("%d", donation)}.GoSend(lucky)
+						booty -= donation
 					}
 				}
 			}
 		}
 	}
 }
+
+// inOrder is the names of the buddies sorted, so a seeded run talks to the same ones
+func inOrder(buddies map[string]chan gotocol.Message) []string {
+	names := make([]string, 0, len(buddies))
+	for n := range buddies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
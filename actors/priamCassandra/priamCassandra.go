@@ -5,6 +5,7 @@ package priamCassandra
 import (
 	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
@@ -12,6 +13,7 @@ import (
 	"github.com/adrianco/spigo/tooling/names"
 	"github.com/adrianco/spigo/tooling/ribbon"
 	"hash/crc32"
+	"log"
 	"sort"
 	"strings"
 	"time"
@@ -54,16 +56,19 @@ func Distribute(cass map[string]chan gotocol.Message) string {
 	// each node owns a share of the full range
 	hashrange := uint32(0xFFFFFFFF) / uint32(size)
 	// make a config string of the form cass1:0,cass4:1000,cass2:2000
-	i := 0
-	s := ""
+	var ns []string
 	for n := range cass {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns) // so the same nodes get the same tokens on every run
+	s := ""
+	for i, n := range ns {
 		s += fmt.Sprintf("%s:%v,", n, hashrange*uint32(i))
-		i++
 	}
 	s = strings.TrimSuffix(s, ",")
 	// send the config to each node, repurposing the Chat message type as a kind of Gossip setup
-	for _, c := range cass {
-		gotocol.Send(c, gotocol.Message{gotocol.Chat, nil, time.Now(), gotocol.NilContext, s})
+	for _, n := range ns {
+		gotocol.Send(cass[n], gotocol.Message{gotocol.Chat, nil, clock.Now(), gotocol.NilContext, s})
 	}
 	return s // for logging and test
 }
@@ -99,7 +104,8 @@ func Start(listener chan gotocol.Message) {
 	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)*archaius.Conf.Regions) // service registry per zone and region
 	hist := collect.NewHist("")
 	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
-	eurekaTicker := time.NewTicker(ep)
+	eurekaTicker := clock.NewTicker(ep)
+	var gc <-chan time.Time // nil unless this service models garbage collection pauses
 	for {
 		select {
 		case msg := <-listener:
@@ -110,6 +116,8 @@ func Start(listener chan gotocol.Message) {
 					// if I don't have a name yet remember what I've been named
 					parent = msg.ResponseChan // remember how to talk to my namer
 					name = msg.Intention      // message body is my name
+					microservices.Own(name)
+					gc = handlers.GCStart(name)
 					hist = collect.NewHist(name)
 				}
 			case gotocol.Inform:
@@ -128,12 +136,12 @@ func Start(listener chan gotocol.Message) {
 				//log.Printf("%v: %v %v\n", name, i, ringHash(msg.Intention))
 				if len(ring) == 0 || ring[i].name == name { // ring is setup so only respond if this is the right place
 					// return any stored value for this key (Cassandra READ.ONE behavior)
-					outmsg := gotocol.Message{gotocol.GetResponse, listener, time.Now(), msg.Ctx, store[msg.Intention]}
+					outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, store[msg.Intention]}
 					flow.AnnotateSend(outmsg, name)
-					outmsg.GoSend(msg.ResponseChan)
+					outmsg.GoRespond(msg.ResponseChan)
 				} else {
 					// forward the message to the right place, but don't change the ResponseChan or span
-					outmsg := gotocol.Message{gotocol.GetRequest, msg.ResponseChan, time.Now(), msg.Ctx.AddSpan(), msg.Intention}
+					outmsg := gotocol.Message{gotocol.GetRequest, msg.ResponseChan, clock.Now(), msg.Ctx.AddSpan(), msg.Intention}
 					flow.AnnotateSend(outmsg, name)
 					outmsg.GoSend(microservices.Named(ring[i].name))
 				}
@@ -149,7 +157,7 @@ func Start(listener chan gotocol.Message) {
 						store[key] = value
 					} else {
 						// forward the message to the right place, but don't change the ResponseChan or context parent
-						outmsg := gotocol.Message{gotocol.Put, msg.ResponseChan, time.Now(), msg.Ctx.AddSpan(), msg.Intention}
+						outmsg := gotocol.Message{gotocol.Put, msg.ResponseChan, clock.Now(), msg.Ctx.AddSpan(), msg.Intention}
 						flow.AnnotateSend(outmsg, name)
 						outmsg.GoSend(microservices.Named(ring[i].name))
 					}
@@ -158,7 +166,7 @@ func Start(listener chan gotocol.Message) {
 						// replicate request
 						for _, n := range microservices.Names() {
 							if names.Region(n) == names.Region(name) && names.Zone(n) == z {
-								outmsg := gotocol.Message{gotocol.Replicate, listener, time.Now(), msg.Ctx.NewParent(), msg.Intention}
+								outmsg := gotocol.Message{gotocol.Replicate, listener, clock.Now(), msg.Ctx.NewParent(), msg.Intention}
 								flow.AnnotateSend(outmsg, name)
 								outmsg.GoSend(microservices.Named(n))
 								break // only need to send it to one node in each zone
@@ -168,9 +176,17 @@ func Start(listener chan gotocol.Message) {
 					for _, r := range names.OtherRegions(name, archaius.Conf.RegionNames[0:archaius.Conf.Regions]) {
 						for _, n := range microservices.Names() {
 							if names.Region(n) == r {
-								outmsg := gotocol.Message{gotocol.Replicate, listener, time.Now(), msg.Ctx.NewParent(), msg.Intention}
+								outmsg := gotocol.Message{gotocol.Replicate, listener, clock.Now(), msg.Ctx.NewParent(), msg.Intention}
+								if archaius.Partitioned(names.Region(name), r) {
+									// the other region can't be reached so it misses this write
+									flow.AnnotateFailFast(outmsg, name)
+									if archaius.Conf.Msglog {
+										log.Printf("%v: partitioned from %v, not replicating %v\n", name, r, msg.Intention)
+									}
+									break
+								}
 								flow.AnnotateSend(outmsg, name)
-								outmsg.GoSend(microservices.Named(n))
+								outmsg.GoSendAfter(microservices.Named(n), handlers.CrossRegion(name, n))
 								break // only need to send it to one node in each region
 							}
 						}
@@ -188,7 +204,7 @@ func Start(listener chan gotocol.Message) {
 						store[key] = value
 					} else {
 						// forward the message to the right place, but don't change the ResponseChan
-						outmsg := gotocol.Message{gotocol.Replicate, msg.ResponseChan, time.Now(), msg.Ctx, msg.Intention}
+						outmsg := gotocol.Message{gotocol.Replicate, msg.ResponseChan, clock.Now(), msg.Ctx, msg.Intention}
 						flow.AnnotateSend(outmsg, name)
 						outmsg.GoSend(microservices.Named(ring[i].name))
 					}
@@ -205,7 +221,7 @@ func Start(listener chan gotocol.Message) {
 						// replicate request
 						for _, n := range microservices.Names() {
 							if names.Region(n) == myregion && names.Zone(n) == z {
-								outmsg := gotocol.Message{gotocol.Replicate, listener, time.Now(), msg.Ctx.NewParent(), msg.Intention}
+								outmsg := gotocol.Message{gotocol.Replicate, listener, clock.Now(), msg.Ctx.NewParent(), msg.Intention}
 								flow.AnnotateSend(outmsg, name)
 								outmsg.GoSend(microservices.Named(n))
 								break // only need to send it to one node in each zone
@@ -215,13 +231,15 @@ func Start(listener chan gotocol.Message) {
 					break
 				}
 			case gotocol.Goodbye:
-				gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NilContext, name}.GoSend(parent)
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
 				return
 			}
+		case <-gc: // stop the world
+			gc = handlers.GCPause(name)
 		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
-			for dep := range dependencies {
-				for _, ch := range eureka {
-					ch <- gotocol.Message{gotocol.GetRequest, listener, time.Now(), gotocol.NilContext, dep}
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
 				}
 			}
 		}
@@ -0,0 +1,239 @@
+// Package saga simulates an orchestrator for distributed transactions that span several services
+// Each saga runs its steps one after another, as calls to the services it depends on, acknowledging the caller straight away.
+// If a step fails, times out or is declined, the steps already done are undone by calls to their compensations in reverse order.
+package saga
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// run of a saga, the step being done or compensated and how it started
+type run struct {
+	ctx          gotocol.Context
+	body         string
+	started      time.Time
+	step         int // index of the step in flight
+	compensating bool
+	attempts     int                      // of the compensation in flight
+	request      gotocol.TraceContextType // idempotency key of the compensation in flight, the same for every retry
+}
+
+// Stats for a saga service, summed over its instances
+type Stats struct {
+	Started       int            `json:"started"`
+	Completed     int            `json:"completed"`
+	Compensated   int            `json:"compensated"`
+	Stuck         int            `json:"stuck"`    // a compensation still failed after its retries, so the saga couldn't be undone
+	FailedAt      map[string]int `json:"failedat"` // by the service of the step that failed
+	Steps         int            `json:"steps"`    // calls made to do steps
+	Compensations int            `json:"compensations"`
+	CompletedMs   float64        `json:"completedms"`   // mean time to run every step
+	CompensatedMs float64        `json:"compensatedms"` // mean time to fail a step and undo the ones before it
+	completed     time.Duration
+	compensated   time.Duration
+}
+
+var stats = make(map[string]*Stats)
+var statsLock sync.Mutex
+
+// record the outcome of a saga and update the run summary
+func record(name string, f func(s *Stats)) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	s := stats[names.Service(name)]
+	if s == nil {
+		s = &Stats{FailedAt: make(map[string]int)}
+		stats[names.Service(name)] = s
+	}
+	f(s)
+	ms := func(d time.Duration, n int) float64 {
+		if n == 0 {
+			return 0
+		}
+		return float64(d) / float64(n) / float64(time.Millisecond)
+	}
+	s.CompletedMs = ms(s.completed, s.Completed)
+	s.CompensatedMs = ms(s.compensated, s.Compensated)
+	summary := make(map[string]Stats, len(stats))
+	for k, v := range stats {
+		c := *v
+		c.FailedAt = make(map[string]int, len(v.FailedAt))
+		for f, n := range v.FailedAt {
+			c.FailedAt[f] = n
+		}
+		summary[k] = c
+	}
+	collect.Summarize("sagas", summary)
+}
+
+// Start saga, all configuration and state is sent via messages
+func Start(listener chan gotocol.Message) {
+	// remember the channel to talk to the services that do the steps
+	microservices := ribbon.MakeRouter()
+	dependencies := make(map[string]time.Time)                                    // dependent services and time last updated
+	var parent chan gotocol.Message                                               // remember how to talk back to creator
+	var name string                                                               // remember my name
+	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
+	hist := collect.NewHist("")
+	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
+	eurekaTicker := clock.NewTicker(ep)
+	var steps []archaius.SagaStep
+	timeout, retries := time.Second, 3
+	inflight := make(map[string]*run) // by span context of the step or compensation call
+	// call the service of the step the run is on, to do it or compensate it
+	call := func(r *run) {
+		st := steps[r.step]
+		body, note := st.Request, fmt.Sprintf("step %v/%v", r.step+1, len(steps))
+		if body == "" {
+			body = r.body
+		}
+		ctx := r.ctx.NewParent()
+		if r.compensating {
+			body, note = st.Compensation, fmt.Sprintf("compensate %v/%v", r.step+1, len(steps))
+			ctx = ctx.WithRequest(r.request)
+		}
+		e := archaius.Edge(names.Service(name), st.Service)
+		response, _ := time.ParseDuration(e.Response)
+		outmsg := gotocol.Message{gotocol.GetRequest, listener, clock.Now(), ctx.WithResponse(response), body}
+		inflight[outmsg.Ctx.String()] = r
+		// if there's no instance or no response in time, fail the call via my own listener
+		fail := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, gotocol.Failure("timeout")}
+		ch := microservices.Select(func(n string) bool { return names.Service(n) == st.Service }).Random()
+		if ch == nil {
+			fail.Intention = gotocol.Failure("unavailable")
+			fail.GoSend(listener)
+			return
+		}
+		latency, _ := time.ParseDuration(e.Latency)
+		latency += handlers.CrossZone(name, microservices.NameChan(ch)) + archaius.Degraded(microservices.NameChan(ch))
+		flow.AnnotateSend(outmsg, name)
+		flow.NoteSaga(outmsg, name, note)
+		outmsg.GoSendAfter(ch, latency)
+		clock.AfterFunc(timeout, func() { gotocol.Send(listener, fail) })
+	}
+	// compensate the step before the one the run is on, skipping steps with nothing to undo, or finish the saga
+	compensate := func(r *run) {
+		for r.step--; r.step >= 0 && steps[r.step].Compensation == ""; r.step-- {
+		}
+		if r.step < 0 {
+			d := clock.Since(r.started)
+			record(name, func(s *Stats) { s.Compensated++; s.compensated += d })
+			collect.MeasureService(names.Service(name), d, true)
+			return
+		}
+		r.compensating, r.attempts, r.request = true, 1, gotocol.NewRequest()
+		record(name, func(s *Stats) { s.Compensations++ })
+		call(r)
+	}
+	start := func(msg gotocol.Message) {
+		if len(steps) == 0 {
+			return
+		}
+		r := &run{ctx: msg.Ctx, body: msg.Intention, started: clock.Now()}
+		record(name, func(s *Stats) { s.Started++; s.Steps++ })
+		call(r)
+	}
+	for {
+		select {
+		case msg := <-listener:
+			flow.Instrument(msg, name, hist)
+			switch msg.Imposition {
+			case gotocol.Hello:
+				if name == "" {
+					// if I don't have a name yet remember what I've been named
+					parent = msg.ResponseChan // remember how to talk to my namer
+					name = msg.Intention      // message body is my name
+					microservices.Own(name)
+					hist = collect.NewHist(name)
+					if sc := archaius.Service(names.Service(name)).Saga; sc != nil {
+						steps = sc.Steps
+						if t, err := time.ParseDuration(sc.Timeout); err == nil && t > 0 {
+							timeout = t
+						}
+						if sc.Retries > 0 {
+							retries = sc.Retries
+						}
+					}
+				}
+			case gotocol.Inform:
+				eureka[msg.Intention] = handlers.Inform(msg, name, listener)
+			case gotocol.NameDrop: // cross zone = true
+				handlers.NameDrop(&dependencies, microservices, msg, name, listener, eureka, true)
+			case gotocol.Forget:
+				// forget a buddy
+				handlers.Forget(&dependencies, microservices, msg)
+			case gotocol.GetRequest:
+				// start a saga and acknowledge it, the caller doesn't wait for the steps
+				start(msg)
+				outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, "started"}
+				flow.AnnotateSend(outmsg, name)
+				outmsg.GoRespond(msg.ResponseChan)
+			case gotocol.Put:
+				// start a saga with no acknowledgement
+				start(msg)
+			case gotocol.GetResponse:
+				// a step or compensation finished or failed, or the timeout came first
+				r := inflight[msg.Ctx.String()]
+				if r == nil {
+					break // already dealt with
+				}
+				delete(inflight, msg.Ctx.String())
+				st := steps[r.step]
+				if r.compensating {
+					if !gotocol.Failed(msg.Intention) {
+						compensate(r)
+					} else if r.attempts > retries {
+						d := clock.Since(r.started)
+						record(name, func(s *Stats) { s.Stuck++ })
+						collect.MeasureService(names.Service(name), d, true)
+						if archaius.Conf.Msglog {
+							log.Printf("%v: saga stuck, %v compensation %v failed after %v attempts, %v\n", name, st.Service, st.Compensation, r.attempts, msg.Intention)
+						}
+					} else {
+						r.attempts++
+						record(name, func(s *Stats) { s.Compensations++ })
+						call(r)
+					}
+					break
+				}
+				if gotocol.Failed(msg.Intention) || archaius.Rand(name).Float64() < st.Errors { // a declined step has nothing to undo itself
+					record(name, func(s *Stats) { s.FailedAt[st.Service]++ })
+					compensate(r)
+					break
+				}
+				if r.step++; r.step < len(steps) {
+					record(name, func(s *Stats) { s.Steps++ })
+					call(r)
+					break
+				}
+				d := clock.Since(r.started)
+				record(name, func(s *Stats) { s.Completed++; s.completed += d })
+				collect.MeasureService(names.Service(name), d, false) // end to end latency
+			case gotocol.Goodbye:
+				for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+					ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
+				}
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
+				return
+			}
+		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
+				}
+			}
+		}
+	}
+}
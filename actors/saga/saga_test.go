@@ -0,0 +1,119 @@
+package saga
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// step is a fake instance of the service of a step, the test answers the calls it gets
+type step chan gotocol.Message
+
+// orchestrator starts a saga named for a service that reserves stock then charges a payment, and tells it about an
+// instance of each
+func orchestrator(t *testing.T, service string) (chan gotocol.Message, step, step) {
+	archaius.Conf.EurekaPoll = "1h"
+	archaius.SetService(service, archaius.ServiceConfig{Saga: &archaius.SagaConfig{Steps: []archaius.SagaStep{
+		{Service: "stock", Request: "reserve", Compensation: "release"},
+		{Service: "payment", Request: "charge", Compensation: "refund"},
+	}, Timeout: "20ms", Retries: 1}})
+	listener := make(chan gotocol.Message)
+	go Start(listener)
+	parent := make(chan gotocol.Message, 10)
+	listener <- gotocol.Message{gotocol.Hello, parent, time.Now(), gotocol.NilContext, names.Make("test", "us-east-1", "zoneA", service, "saga", 0)}
+	stock, payment := make(step, 10), make(step, 10)
+	listener <- gotocol.Message{gotocol.NameDrop, stock, time.Now(), gotocol.NilContext, names.Make("test", "us-east-1", "zoneA", "stock", "store", 0)}
+	listener <- gotocol.Message{gotocol.NameDrop, payment, time.Now(), gotocol.NilContext, names.Make("test", "us-east-1", "zoneA", "payment", "store", 1)}
+	return listener, stock, payment
+}
+
+// call a step got, with what to do in the body
+func (s step) call(t *testing.T, body string) gotocol.Message {
+	select {
+	case m := <-s:
+		if m.Intention != body {
+			t.Fatalf("step got %v, not %v", m.Intention, body)
+		}
+		return m
+	case <-time.After(time.Second):
+		t.Fatalf("%v wasn't called", body)
+	}
+	return gotocol.Message{}
+}
+
+// answer a call to a step
+func (s step) answer(t *testing.T, body, reply string) {
+	m := s.call(t, body)
+	m.ResponseChan <- gotocol.Message{gotocol.GetResponse, nil, time.Now(), m.Ctx, reply}
+}
+
+// statsOf a saga service once the run has ended, the outcome is recorded after the last step is answered
+func statsOf(t *testing.T, service string, done func(s Stats) bool) Stats {
+	var s Stats
+	for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(time.Millisecond) {
+		statsLock.Lock()
+		s = *stats[service]
+		statsLock.Unlock()
+		if done(s) {
+			break
+		}
+	}
+	return s
+}
+
+// begin a saga, it's acknowledged straight away
+func begin(t *testing.T, listener chan gotocol.Message) {
+	client := make(chan gotocol.Message, 1)
+	listener <- gotocol.Message{gotocol.GetRequest, client, time.Now(), gotocol.NewTrace(), "order"}
+	select {
+	case m := <-client:
+		if m.Intention != "started" {
+			t.Fatalf("saga acknowledged with %v", m.Intention)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("saga wasn't acknowledged")
+	}
+}
+
+// TestComplete checks a saga runs its steps in order and completes when the last one succeeds
+func TestComplete(t *testing.T) {
+	listener, stock, payment := orchestrator(t, "completes")
+	begin(t, listener)
+	stock.answer(t, "reserve", "ok")
+	payment.answer(t, "charge", "ok")
+	if s := statsOf(t, "completes", func(s Stats) bool { return s.Completed > 0 }); s.Completed != 1 || s.Steps != 2 || s.Compensations != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// TestCompensate checks a failed step undoes the ones before it, and a compensation that fails is retried
+func TestCompensate(t *testing.T) {
+	listener, stock, payment := orchestrator(t, "compensates")
+	begin(t, listener)
+	stock.answer(t, "reserve", "ok")
+	payment.answer(t, "charge", gotocol.Failure("declined"))
+	stock.answer(t, "release", gotocol.Failure("error"))
+	stock.answer(t, "release", "ok")
+	s := statsOf(t, "compensates", func(s Stats) bool { return s.Compensated > 0 })
+	if s.Compensated != 1 || s.FailedAt["payment"] != 1 || s.Compensations != 2 || s.Completed != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// TestTimeout checks a step that doesn't answer in time fails the saga, and a compensation that never answers leaves it
+// stuck after its retries
+func TestTimeout(t *testing.T) {
+	listener, stock, payment := orchestrator(t, "timeouts")
+	begin(t, listener)
+	stock.answer(t, "reserve", "ok")
+	payment.call(t, "charge") // and never answers
+	stock.call(t, "release")  // nor does the compensation
+	stock.call(t, "release")  // or its retry
+	s := statsOf(t, "timeouts", func(s Stats) bool { return s.Stuck > 0 })
+	if s.Stuck != 1 || s.FailedAt["payment"] != 1 || s.Compensations != 2 || s.Compensated != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
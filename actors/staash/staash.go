@@ -5,6 +5,7 @@ package staash
 import (
 	. "github.com/adrianco/spigo/actors/packagenames"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
@@ -35,7 +36,23 @@ func Start(listener chan gotocol.Message) {
 	eureka := make(map[string]chan gotocol.Message, 1)       // service registry
 	hist := collect.NewHist("")
 	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
-	eurekaTicker := time.NewTicker(ep)
+	eurekaTicker := clock.NewTicker(ep)
+	var gc <-chan time.Time // nil unless this service models garbage collection pauses
+	// lookup sends a request on to the next layer and remembers which layer it went to.
+	// After a miss the response is swapped for the original request, so the next layer answers the original requestor
+	lookup := func(msg gotocol.Message, router *ribbon.Router, state int) {
+		if msg.Imposition == gotocol.GetResponse {
+			r := gotocol.PickRoute(requestor, msg)
+			delete(requestor, msg.Ctx.Route())
+			handlers.Release(msg)
+			msg = gotocol.Message{gotocol.GetResponse, r.ResponseChan, r.Sent, r.Ctx, msg.Intention}
+		}
+		if k := handlers.GetRequest(msg, name, listener, &requestor, router); k != "" {
+			r := requestor[k]
+			r.State = state
+			requestor[k] = r
+		}
+	}
 	for {
 		select {
 		case msg := <-listener:
@@ -46,6 +63,8 @@ func Start(listener chan gotocol.Message) {
 					// if I don't have a name yet remember what I've been named
 					parent = msg.ResponseChan // remember how to talk to my namer
 					name = msg.Intention      // message body is my name
+					microservices.Own(name)
+					gc = handlers.GCStart(name)
 					hist = collect.NewHist(name)
 				}
 			case gotocol.Inform:
@@ -67,30 +86,24 @@ func Start(listener chan gotocol.Message) {
 				staash = microservices.All(StaashPkg)
 			case gotocol.GetRequest:
 				// route the request on to a cache first if configured
-				r := gotocol.PickRoute(requestor, msg)
 				if caches.Len() > 0 {
-					handlers.GetRequest(msg, name, listener, &requestor, caches)
-					r.State = cacheLookup
+					lookup(msg, caches, cacheLookup)
 				} else {
 					// route to any volumes if configured
 					if volumes.Len() > 0 {
-						handlers.GetRequest(msg, name, listener, &requestor, volumes)
-						r.State = volumeLookup
+						lookup(msg, volumes, volumeLookup)
 					} else {
 						// route to any cassandra if configured
 						if cass.Len() > 0 {
-							handlers.GetRequest(msg, name, listener, &requestor, cass)
-							r.State = cassandraLookup
+							lookup(msg, cass, cassandraLookup)
 						} else {
 							// route to stores if configured
 							if stores.Len() > 0 {
-								handlers.GetRequest(msg, name, listener, &requestor, stores)
-								r.State = storeLookup
+								lookup(msg, stores, storeLookup)
 							} else {
 								// route to more staash layers if configured
 								if staash.Len() > 0 {
-									handlers.GetRequest(msg, name, listener, &requestor, staash)
-									r.State = staashLookup
+									lookup(msg, staash, staashLookup)
 								}
 							}
 						}
@@ -105,30 +118,30 @@ func Start(listener chan gotocol.Message) {
 				} else {
 					switch r.State {
 					case cacheLookup:
+						if pattern(caches) != "aside" { // the cache already looked in the origin
+							handlers.GetResponse(msg, name, listener, &requestor)
+							break
+						}
 						if volumes.Len() > 0 {
-							handlers.GetRequest(msg, name, listener, &requestor, volumes)
-							r.State = volumeLookup
+							lookup(msg, volumes, volumeLookup)
 							break
 						}
 						fallthrough // no volumes so look for cassandra
 					case volumeLookup:
 						if cass.Len() > 0 {
-							handlers.GetRequest(msg, name, listener, &requestor, cass)
-							r.State = cassandraLookup
+							lookup(msg, cass, cassandraLookup)
 							break
 						}
 						fallthrough // no cassandra so look for stores
 					case cassandraLookup:
 						if stores.Len() > 0 {
-							handlers.GetRequest(msg, name, listener, &requestor, stores)
-							r.State = storeLookup
+							lookup(msg, stores, storeLookup)
 							break
 						}
 						fallthrough // no stores
 					case storeLookup:
 						if staash.Len() > 0 {
-							handlers.GetRequest(msg, name, listener, &requestor, staash)
-							r.State = staashLookup
+							lookup(msg, staash, staashLookup)
 							break
 						}
 						fallthrough // no staash
@@ -144,24 +157,40 @@ func Start(listener chan gotocol.Message) {
 				// storage class packages sideways Replicate if configured
 				// to get a lossy write, configure multiple stores that don't cross replicate
 				handlers.Put(msg, name, listener, &requestor, caches)
+				if pattern(caches) == "writebehind" { // the cache writes to the rest later
+					break
+				}
 				handlers.Put(msg, name, listener, &requestor, cass)
 				handlers.Put(msg, name, listener, &requestor, staash)
 				handlers.Put(msg, name, listener, &requestor, stores)
 				msg.Intention = names.Instance(name) + "/" + msg.Intention // store to an instance specific volume namespace
 				handlers.Put(msg, name, listener, &requestor, volumes)
 			case gotocol.Goodbye:
-				for _, ch := range eureka { // tell name service I'm not going to be here
-					ch <- gotocol.Message{gotocol.Delete, nil, time.Now(), gotocol.NilContext, name}
+				for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+					ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
 				}
-				gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NilContext, name}.GoSend(parent)
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
 				return
 			}
+		case <-gc: // stop the world
+			gc = handlers.GCPause(name)
 		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
-			for dep := range dependencies {
-				for _, ch := range eureka {
-					ch <- gotocol.Message{gotocol.GetRequest, listener, time.Now(), gotocol.NilContext, dep}
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
 				}
 			}
 		}
 	}
 }
+
+// pattern is the caching pattern of the caches, aside unless one of them reads through or writes behind
+func pattern(caches *ribbon.Router) string {
+	p := "aside"
+	for _, n := range caches.Names() {
+		if c := archaius.Service(names.Service(n)).Caching; c != nil && c.Pattern != "aside" && p != "writebehind" {
+			p = c.Pattern
+		}
+	}
+	return p
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// CachingStats counts what a cache service with a caching pattern did, summed over its instances
+type CachingStats struct {
+	Pattern   string  `json:"pattern"`
+	Eviction  string  `json:"eviction,omitempty"` // policy of a cache with a capacity
+	Capacity  int     `json:"capacity,omitempty"` // keys held by each instance
+	Hits      int     `json:"hits"`
+	Misses    int     `json:"misses"`
+	HitRatio  float64 `json:"hitratio"`
+	Evictions int     `json:"evictions"`
+	Cold      int     `json:"cold"`      // times an instance was flushed or restarted and lost its keys
+	Warming   int     `json:"warming"`   // gets for keys an instance held that missed while it was warming up
+	Fetches   int     `json:"fetches"`   // misses read through from the origin
+	Writes    int     `json:"writes"`    // taken to write behind
+	Flushed   int     `json:"flushed"`   // written to the origin
+	Coalesced int     `json:"coalesced"` // replaced by a later write to the same key before they were flushed
+	Lost      int     `json:"lost"`      // not flushed when the instance failed
+}
+
+var cachingStats = make(map[string]CachingStats) // by service name
+var cachingLock sync.Mutex
+
+func summarizeCaching() {
+	summary := make(map[string]CachingStats, len(cachingStats))
+	for k, v := range cachingStats {
+		summary[k] = v
+	}
+	collect.Summarize("caching", summary)
+}
+
+// count updates the caching stats of the service an instance belongs to
+func count(name string, c *archaius.CachingConfig, update func(*CachingStats)) {
+	cachingLock.Lock()
+	defer cachingLock.Unlock()
+	s := cachingStats[names.Service(name)]
+	s.Pattern = c.Pattern
+	if c.Capacity > 0 {
+		s.Capacity = c.Capacity
+		s.Eviction = c.Eviction
+		if s.Eviction == "" {
+			s.Eviction = "lru"
+		}
+	}
+	update(&s)
+	if s.Hits+s.Misses > 0 {
+		s.HitRatio = float64(s.Hits) / float64(s.Hits+s.Misses)
+	}
+	cachingStats[names.Service(name)] = s
+	summarizeCaching()
+}
+
+// warming is when a cache instance last went cold, and how many of its flushes and restarts it has seen
+type warming struct {
+	since    time.Time
+	flushes  int
+	restarts int
+}
+
+// newWarming starts an instance cold, counting the flushes before it started as seen
+func newWarming(name string, c *archaius.CachingConfig) *warming {
+	return &warming{clock.Now(), flushesDue(c), handlers.Restarts(name)}
+}
+
+// flushesDue is how many of the flushes of a cache are due by now
+func flushesDue(c *archaius.CachingConfig) int {
+	n := 0
+	for _, f := range c.Flushes {
+		if t, _ := time.ParseDuration(f); t <= collect.Elapsed() {
+			n++
+		}
+	}
+	return n
+}
+
+// cold finds out if an instance has been flushed or has restarted after running out of memory since it last looked, if so
+// it starts warming up again and the reason is returned, otherwise an empty string
+func (w *warming) cold(name string, c *archaius.CachingConfig) string {
+	flushes, restarts := flushesDue(c), handlers.Restarts(name)
+	if flushes == w.flushes && restarts == w.restarts {
+		return ""
+	}
+	why := "flush"
+	if restarts != w.restarts {
+		why = "restart"
+	}
+	w.since, w.flushes, w.restarts = clock.Now(), flushes, restarts
+	count(name, c, func(s *CachingStats) { s.Cold++ })
+	return why
+}
+
+// warmed is false for a get of a key the instance holds that misses because it's still warming up, which gets less likely
+// over the warm period. It's counted as a warming miss
+func (w *warming) warmed(name string, c *archaius.CachingConfig) bool {
+	d, err := time.ParseDuration(c.Warm)
+	if err != nil || d <= 0 || archaius.Rand(name).Float64() < float64(clock.Since(w.since))/float64(d) {
+		return true
+	}
+	count(name, c, func(s *CachingStats) { s.Warming++ })
+	return false
+}
+
+// origin is the services a cache reads through and writes behind to, its dependencies other than its own replicas
+func origin(name string, router *ribbon.Router) *ribbon.Router {
+	return router.Select(func(n string) bool { return names.Service(n) != names.Service(name) })
+}
+
+// readThrough fetches a miss from the origin, remembering the key so the value can be cached when it comes back.
+// It returns false if the cache is aside or has no origin, so the miss is answered straight away
+func readThrough(msg gotocol.Message, name string, listener chan gotocol.Message, c *archaius.CachingConfig, router *ribbon.Router,
+	requestor *map[string]gotocol.Routetype, fetching map[string]string) bool {
+	if c == nil || c.Pattern == "aside" {
+		return false
+	}
+	o := origin(name, router)
+	if o.Len() == 0 {
+		return false
+	}
+	if k := handlers.GetRequest(msg, name, listener, requestor, o); k != "" {
+		fetching[k] = msg.Intention
+		count(name, c, func(s *CachingStats) { s.Misses++; s.Fetches++ })
+	}
+	return true
+}
+
+// fetched caches a value read through from the origin with keep and answers the request that missed, it returns false for
+// other responses
+func fetched(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype,
+	fetching map[string]string, keep func(key, value string)) bool {
+	key, ok := fetching[msg.Ctx.Route()]
+	if !ok {
+		return false
+	}
+	delete(fetching, msg.Ctx.Route())
+	if msg.Intention != "" && !gotocol.Failed(msg.Intention) {
+		keep(key, msg.Intention)
+	}
+	handlers.GetResponse(msg, name, listener, requestor)
+	return true
+}
+
+// writeBehind keeps a write to flush to the origin later, starting the flush timer if it isn't running, and returns the timer.
+// Writes aren't kept if the cache isn't write behind or has no origin
+func writeBehind(msg gotocol.Message, key, name string, c *archaius.CachingConfig, router *ribbon.Router, dirty map[string]gotocol.Message,
+	flush <-chan time.Time) <-chan time.Time {
+	if c == nil || c.Pattern != "writebehind" || origin(name, router).Len() == 0 {
+		return flush
+	}
+	_, replaced := dirty[key]
+	dirty[key] = msg
+	count(name, c, func(s *CachingStats) {
+		s.Writes++
+		if replaced {
+			s.Coalesced++
+		}
+	})
+	if flush != nil {
+		return flush
+	}
+	delay, err := time.ParseDuration(c.Flush)
+	if err != nil {
+		delay = 100 * time.Millisecond
+	}
+	return clock.After(delay)
+}
+
+// flushWrites writes the latest value of each key written behind to the origin, as part of the flow of the write
+func flushWrites(name string, listener chan gotocol.Message, c *archaius.CachingConfig, router *ribbon.Router,
+	requestor *map[string]gotocol.Routetype, dirty map[string]gotocol.Message) {
+	o := origin(name, router)
+	n := len(dirty)
+	for key, msg := range dirty {
+		handlers.Put(msg, name, listener, requestor, o)
+		delete(dirty, key)
+	}
+	count(name, c, func(s *CachingStats) { s.Flushed += n })
+}
+
+// lostWrites counts the writes behind that hadn't been flushed when this instance failed
+func lostWrites(name string, c *archaius.CachingConfig, dirty map[string]gotocol.Message) {
+	if c == nil || len(dirty) == 0 {
+		return
+	}
+	count(name, c, func(s *CachingStats) { s.Lost += len(dirty) })
+	log.Printf("%v: lost %v writes that weren't flushed\n", name, len(dirty))
+}
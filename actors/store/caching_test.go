@@ -0,0 +1,29 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// TestWarming checks a cache goes cold when a flush is due, and only hits with the fraction of its warm period that's gone
+func TestWarming(t *testing.T) {
+	c := &archaius.CachingConfig{Pattern: "readthrough", Warm: "1h", Flushes: []string{"1ns", "1h"}}
+	w := &warming{since: time.Now()}
+	if why := w.cold("test.us-east-1.zoneA.cache00", c); why != "flush" || w.flushes != 1 {
+		t.Fatal(why, w)
+	}
+	if w.cold("test.us-east-1.zoneA.cache00", c) != "" {
+		t.Error("a flush should only make it cold once")
+	}
+	for i := 0; i < 100; i++ {
+		if w.warmed("test.us-east-1.zoneA.cache00", c) {
+			t.Fatal("hit straight after going cold")
+		}
+	}
+	w.since = time.Now().Add(-time.Hour)
+	if !w.warmed("test.us-east-1.zoneA.cache00", c) || !w.warmed("", &archaius.CachingConfig{}) {
+		t.Error("should hit once warm, or without a warm period")
+	}
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"container/heap"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// cached is a key held by a cache with a capacity, and what its eviction policy orders it by
+type cached struct {
+	key   string
+	uses  int    // gets and writes of the key since it was cached
+	seq   uint64 // when it was cached, or last used unless the policy is fifo
+	index int    // in the heap
+}
+
+// evictor keeps the keys of a cache instance to its capacity, as a heap with the next key to evict on top
+type evictor struct {
+	capacity int
+	policy   string
+	keys     map[string]*cached
+	order    []*cached
+	seq      uint64
+}
+
+// newEvictor is nil unless the cache has a capacity
+func newEvictor(c *archaius.CachingConfig) *evictor {
+	if c == nil || c.Capacity == 0 {
+		return nil
+	}
+	policy := c.Eviction
+	if policy == "" {
+		policy = "lru"
+	}
+	return &evictor{capacity: c.Capacity, policy: policy, keys: make(map[string]*cached)}
+}
+
+func (e *evictor) Len() int { return len(e.order) }
+func (e *evictor) Less(i, j int) bool {
+	a, b := e.order[i], e.order[j]
+	if e.policy == "lfu" && a.uses != b.uses {
+		return a.uses < b.uses
+	}
+	return a.seq < b.seq // the oldest first, which breaks lfu ties by recency
+}
+func (e *evictor) Swap(i, j int) {
+	e.order[i], e.order[j] = e.order[j], e.order[i]
+	e.order[i].index, e.order[j].index = i, j
+}
+func (e *evictor) Push(x interface{}) {
+	c := x.(*cached)
+	c.index = len(e.order)
+	e.order = append(e.order, c)
+}
+func (e *evictor) Pop() interface{} {
+	c := e.order[len(e.order)-1]
+	e.order = e.order[:len(e.order)-1]
+	return c
+}
+
+// use counts a get that hit or a write to a key that's cached, and is false if it isn't
+func (e *evictor) use(key string) bool {
+	if e == nil {
+		return false
+	}
+	c, ok := e.keys[key]
+	if !ok {
+		return false
+	}
+	e.seq++
+	c.uses++
+	if e.policy != "fifo" {
+		c.seq = e.seq
+	}
+	heap.Fix(e, c.index)
+	return true
+}
+
+// cache a key that's been stored, evicting keys from the store to make room for it first, so lfu doesn't evict the new key
+// straight away, and return how many were evicted
+func (e *evictor) cache(key string, store map[string]string) int {
+	if e == nil || e.use(key) {
+		return 0
+	}
+	evicted := 0
+	for len(e.order) >= e.capacity {
+		v := heap.Pop(e).(*cached)
+		delete(e.keys, v.key)
+		delete(store, v.key)
+		evicted++
+	}
+	e.seq++
+	c := &cached{key: key, uses: 1, seq: e.seq}
+	e.keys[key] = c
+	heap.Push(e, c)
+	return evicted
+}
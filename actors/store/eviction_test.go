@@ -0,0 +1,32 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// TestEviction checks each policy evicts the key it should when a full cache takes a new one
+func TestEviction(t *testing.T) {
+	for policy, evicted := range map[string]string{"lru": "b", "lfu": "c", "fifo": "a"} {
+		store := make(map[string]string)
+		e := newEvictor(&archaius.CachingConfig{Capacity: 3, Eviction: policy})
+		for _, k := range []string{"a", "b", "c"} {
+			store[k] = k
+			e.cache(k, store)
+		}
+		for _, k := range []string{"b", "b", "c", "a"} { // b is the least recently used and the most frequently
+			e.use(k)
+		}
+		store["d"] = "d"
+		if n := e.cache("d", store); n != 1 || len(store) != 3 {
+			t.Fatal(policy, n, store)
+		}
+		if _, ok := store[evicted]; ok {
+			t.Error(policy, "should have evicted", evicted, store)
+		}
+	}
+	if newEvictor(&archaius.CachingConfig{Pattern: "aside"}) != nil {
+		t.Error("a cache without a capacity doesn't evict")
+	}
+}
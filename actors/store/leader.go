@@ -0,0 +1,179 @@
+package store
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// LeaderStats is what the cluster of a store service with a leader did, the unavailable time is how long writes couldn't
+// be taken because there was no leader, after the first one was elected
+type LeaderStats struct {
+	Leader      string  `json:"leader"`
+	Term        int     `json:"term"`
+	Elections   int     `json:"elections"`
+	Forwarded   int     `json:"forwarded"`     // writes that arrived at a follower and were passed on to the leader
+	Failed      int     `json:"failedwrites"`  // writes that arrived while there was no leader
+	Blocked     int     `json:"blockedwrites"` // writes that waited for a leader, then went to it
+	Unavailable float64 `json:"unavailablems"`
+}
+
+// cluster of the instances of a store service that elect a leader
+type cluster struct {
+	config   *archaius.LeaderConfig
+	members  map[string]chan gotocol.Message
+	size     int // most members there have been, the default cluster size
+	leader   string
+	electing bool
+	since    time.Time         // when the cluster lost its leader
+	blocked  []gotocol.Message // writes waiting for a leader
+	stats    LeaderStats
+}
+
+var clusters = make(map[string]*cluster) // by service name
+var clusterLock sync.Mutex
+
+func summarizeLeaders() {
+	summary := make(map[string]LeaderStats, len(clusters))
+	for k, c := range clusters {
+		summary[k] = c.stats
+	}
+	collect.Summarize("leader", summary)
+}
+
+// quorum is the majority of the cluster that has to be up to elect a leader
+func (c *cluster) quorum() int {
+	size := c.config.Size
+	if size <= 0 {
+		size = c.size
+	}
+	return size/2 + 1
+}
+
+// join adds an instance to the cluster of its service. The first leader is elected as soon as there's a quorum, after that
+// an instance joining a cluster without a leader starts an election
+func join(name string, listener chan gotocol.Message, l *archaius.LeaderConfig) {
+	clusterLock.Lock()
+	defer clusterLock.Unlock()
+	service := names.Service(name)
+	c := clusters[service]
+	if c == nil {
+		c = &cluster{config: l, members: make(map[string]chan gotocol.Message)}
+		clusters[service] = c
+	}
+	c.members[name] = listener
+	if len(c.members) > c.size {
+		c.size = len(c.members)
+	}
+	if c.leader != "" || c.electing {
+		return
+	}
+	if c.stats.Term == 0 {
+		c.elect(service)
+	} else {
+		c.election(service)
+	}
+}
+
+// leave removes an instance that has gone from its cluster, starting an election if it was the leader
+func leave(name string) {
+	clusterLock.Lock()
+	defer clusterLock.Unlock()
+	service := names.Service(name)
+	c := clusters[service]
+	if c == nil {
+		return
+	}
+	delete(c.members, name)
+	if name != c.leader {
+		return
+	}
+	c.leader = ""
+	c.since = clock.Now()
+	collect.Mark("leaderlost", names.Instance(name))
+	log.Printf("%v: leader %v has gone, electing a new one\n", service, names.Instance(name))
+	c.election(service)
+}
+
+// election starts the timer for electing a new leader, the caller holds clusterLock
+func (c *cluster) election(service string) {
+	d, err := time.ParseDuration(c.config.Election)
+	if err != nil || d <= 0 {
+		d = time.Second
+	}
+	c.electing = true
+	clock.AfterFunc(d, func() {
+		clusterLock.Lock()
+		defer clusterLock.Unlock()
+		c.electing = false
+		c.elect(service)
+	})
+}
+
+// elect picks the member with the lowest name as the leader if there's a quorum and sends it the writes that were waiting.
+// Without a quorum the cluster stays without a leader until another instance joins. The caller holds clusterLock
+func (c *cluster) elect(service string) {
+	if c.leader != "" || len(c.members) < c.quorum() {
+		if c.stats.Term > 0 && !c.since.IsZero() {
+			collect.Mark("noquorum", service)
+		}
+		return
+	}
+	var ms []string
+	for n := range c.members {
+		ms = append(ms, n)
+	}
+	sort.Strings(ms)
+	c.leader = ms[0]
+	c.stats.Leader = names.Instance(c.leader)
+	c.stats.Term++
+	c.stats.Elections++
+	if !c.since.IsZero() {
+		c.stats.Unavailable += float64(clock.Since(c.since)) / float64(time.Millisecond)
+		c.since = time.Time{}
+		collect.Mark("leader", c.stats.Leader)
+		log.Printf("%v: %v elected leader for term %v\n", service, c.stats.Leader, c.stats.Term)
+	}
+	for _, msg := range c.blocked {
+		msg.GoSend(c.members[c.leader])
+	}
+	c.blocked = nil
+	summarizeLeaders()
+}
+
+// toLeader passes a write that arrived at a follower on to the leader and returns true, a write with no leader to go to fails or
+// waits for one. It's false if this instance is the leader, so it takes the write itself
+func toLeader(msg gotocol.Message, name string, listener chan gotocol.Message) bool {
+	clusterLock.Lock()
+	defer clusterLock.Unlock()
+	c := clusters[names.Service(name)]
+	if c == nil || c.leader == name {
+		return false
+	}
+	defer summarizeLeaders()
+	outmsg := gotocol.Message{gotocol.Put, listener, clock.Now(), msg.Ctx.NewParent(), msg.Intention}
+	if c.leader == "" {
+		if c.config.Writes == "block" {
+			c.stats.Blocked++
+			flow.AnnotateSend(outmsg, name)
+			c.blocked = append(c.blocked, outmsg)
+		} else {
+			c.stats.Failed++
+			flow.AnnotateFailFast(outmsg, name)
+		}
+		return true
+	}
+	c.stats.Forwarded++
+	flow.AnnotateSend(outmsg, name)
+	outmsg.GoSendAfter(c.members[c.leader], replicaLatency(name)+handlers.CrossZone(name, c.leader))
+	return true
+}
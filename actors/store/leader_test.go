@@ -0,0 +1,94 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// members of a cluster that elects a leader, the test plays the part of their listeners
+func members(service string, l *archaius.LeaderConfig, n int) ([]string, []chan gotocol.Message) {
+	var ns []string
+	var chs []chan gotocol.Message
+	for i := 0; i < n; i++ {
+		ns = append(ns, names.Make("test", "us-east-1", "zoneA", service, "store", i))
+		chs = append(chs, make(chan gotocol.Message, 10))
+		join(ns[i], chs[i], l)
+	}
+	return ns, chs
+}
+
+func leaderOf(service string) (string, LeaderStats) {
+	clusterLock.Lock()
+	defer clusterLock.Unlock()
+	return clusters[service].leader, clusters[service].stats
+}
+
+// write a member got passed on
+func written(t *testing.T, ch chan gotocol.Message) {
+	select {
+	case m := <-ch:
+		if m.Imposition != gotocol.Put || m.Intention != "key value" {
+			t.Errorf("got %v %v, not the write", m.Imposition, m.Intention)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the write wasn't passed on")
+	}
+}
+
+func put() gotocol.Message {
+	return gotocol.Message{gotocol.Put, nil, time.Now(), gotocol.NewTrace(), "key value"}
+}
+
+// TestLeaderElection checks the first leader is elected once there's a quorum, followers pass writes on to it, and when it
+// goes the writes block until the election picks a new one
+func TestLeaderElection(t *testing.T) {
+	ns, chs := members("leaderdb", &archaius.LeaderConfig{Size: 3, Election: "20ms", Writes: "block"}, 3)
+	if l, s := leaderOf("leaderdb"); l != ns[0] || s.Term != 1 {
+		t.Fatalf("leader %v for term %v", l, s.Term)
+	}
+	if toLeader(put(), ns[0], chs[0]) {
+		t.Error("the leader passed on a write it should take itself")
+	}
+	if !toLeader(put(), ns[1], chs[1]) {
+		t.Fatal("a follower took a write")
+	}
+	written(t, chs[0])
+	leave(ns[0])
+	if !toLeader(put(), ns[1], chs[1]) {
+		t.Fatal("a follower took a write while there was no leader")
+	}
+	written(t, chs[1]) // once it's elected
+	l, s := leaderOf("leaderdb")
+	if l != ns[1] || s.Term != 2 || s.Elections != 2 || s.Forwarded != 1 || s.Blocked != 1 || s.Unavailable < 20 {
+		t.Errorf("leader %v, stats %+v", l, s)
+	}
+}
+
+// TestLeaderNoQuorum checks writes fail while there's no leader, and a cluster that has lost its quorum doesn't elect one
+// until enough members are back
+func TestLeaderNoQuorum(t *testing.T) {
+	ns, chs := members("noquorumdb", &archaius.LeaderConfig{Election: "20ms"}, 3)
+	leave(ns[2])
+	leave(ns[0]) // the leader, one of three left
+	time.Sleep(40 * time.Millisecond)
+	if l, _ := leaderOf("noquorumdb"); l != "" {
+		t.Fatalf("%v elected without a quorum", l)
+	}
+	if !toLeader(put(), ns[1], chs[1]) {
+		t.Fatal("a follower took a write while there was no leader")
+	}
+	select {
+	case m := <-chs[1]:
+		t.Fatalf("a failed write was passed on as %v", m.Intention)
+	default:
+	}
+	join(ns[2], chs[2], &archaius.LeaderConfig{Election: "20ms"})
+	time.Sleep(40 * time.Millisecond)
+	if l, s := leaderOf("noquorumdb"); l != ns[1] || s.Term != 2 || s.Failed != 1 {
+		t.Errorf("leader %v, stats %+v", l, s)
+	}
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// read taken by this instance, waiting for enough replicas to reply with their copy
+type read struct {
+	msg      gotocol.Message
+	value    string // this instance's own copy
+	received time.Time
+	needed   int // successful replies
+	replied  int
+	failed   int      // replies that failed or timed out
+	spans    []string // of the requests to the replicas
+}
+
+// quorums keeps the reads of an instance with quorum replication that are waiting for replicas, and the histograms of its
+// quorum write and read latency
+type quorums struct {
+	reading       map[string]*read // by the span of each request to a replica
+	writes, reads *generic.Histogram
+}
+
+// newQuorums is nil unless the replication mode is quorum
+func newQuorums(name string, r *archaius.ReplicationConfig) *quorums {
+	if r == nil || r.Mode != "quorum" {
+		return nil
+	}
+	return &quorums{reading: make(map[string]*read), writes: collect.NewHist(name + "_quorumwrite"), reads: collect.NewHist(name + "_quorumread")}
+}
+
+// quorum is how many of n copies to wait for, counting this instance's own, a majority unless it's configured, and all of
+// them if there are fewer
+func quorum(q, n int) int {
+	if q <= 0 {
+		q = n/2 + 1
+	}
+	if q > n {
+		q = n
+	}
+	return q
+}
+
+// copyLatency is the replica latency to one of the replicas, plus anything slowing it down, so a quorum waits out the slow
+// replicas it needs
+func copyLatency(name, replica string) time.Duration {
+	return replicaLatency(name) + archaius.Degraded(replica)
+}
+
+// readTimeout is how long a quorum read waits for its replicas, the timeout of the edge from the service to itself, default 1s
+func readTimeout(name string) time.Duration {
+	if t, err := time.ParseDuration(archaius.Edge(names.Service(name), names.Service(name)).Timeout); err == nil && t > 0 {
+		return t
+	}
+	return time.Second
+}
+
+// quorumRead asks the replicas for their copy of a key if a read needs more than this instance's own, and answers when
+// enough of them have replied, it's false if the read can be answered straight away. Replicas answer the read like any
+// other, the quorum baggage stops them asking their own replicas. A replica that hasn't replied by the timeout has failed
+func (q *quorums) quorumRead(msg gotocol.Message, value, name string, listener chan gotocol.Message, r *archaius.ReplicationConfig, router *ribbon.Router) bool {
+	if q == nil || msg.Ctx.BaggageItem("quorum") != "" {
+		return false
+	}
+	peers := replicas(name, r, router)
+	rd := &read{msg: msg, value: value, received: clock.Now(), needed: quorum(r.R, len(peers)+1) - 1}
+	if rd.needed == 0 {
+		q.answer(rd, name, r)
+		return false
+	}
+	var fails []gotocol.Message
+	for _, n := range peers {
+		latency := copyLatency(name, n)
+		outmsg := gotocol.Message{gotocol.GetRequest, listener, clock.Now().Add(latency), msg.Ctx.NewParent().WithResponse(replicaLatency(name)).WithBaggage("quorum", "read"), msg.Intention}
+		q.reading[outmsg.Ctx.String()] = rd
+		rd.spans = append(rd.spans, outmsg.Ctx.String())
+		fails = append(fails, gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, gotocol.Failure("timeout")})
+		flow.AnnotateSend(outmsg, name)
+		outmsg.GoSendAfter(router.Named(n), latency)
+	}
+	// fail the replicas that haven't replied in time via my own listener, the ones that have are already forgotten
+	clock.AfterFunc(readTimeout(name), func() {
+		for _, f := range fails {
+			gotocol.Send(listener, f)
+		}
+	})
+	return true
+}
+
+// replied counts a reply from a replica to a quorum read, and answers the read when enough of them have succeeded, or fails
+// it once too many have failed for the rest to make a quorum, it's false if the response isn't for a quorum read
+func (q *quorums) replied(msg gotocol.Message, name string, listener chan gotocol.Message, r *archaius.ReplicationConfig) bool {
+	if q == nil {
+		return false
+	}
+	rd, ok := q.reading[msg.Ctx.String()]
+	if !ok {
+		return false
+	}
+	delete(q.reading, msg.Ctx.String())
+	if gotocol.Failed(msg.Intention) {
+		rd.failed++
+	} else {
+		rd.replied++
+	}
+	value := rd.value
+	switch {
+	case rd.replied == rd.needed:
+		q.answer(rd, name, r)
+	case rd.failed == len(rd.spans)-rd.needed+1:
+		q.fail(rd, name, r)
+		value = gotocol.Failure("quorum")
+	default:
+		return true
+	}
+	for _, s := range rd.spans { // the replicas still to reply aren't needed now
+		delete(q.reading, s)
+	}
+	outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), rd.msg.Ctx, value}
+	flow.AnnotateSend(outmsg, name)
+	outmsg.GoRespond(rd.msg.ResponseChan)
+	return true
+}
+
+// fail counts a quorum read that couldn't get enough replies
+func (q *quorums) fail(rd *read, name string, r *archaius.ReplicationConfig) {
+	replicationLock.Lock()
+	defer replicationLock.Unlock()
+	s := replicationStats[names.Service(name)]
+	s.Mode = r.Mode
+	s.FailedReads++
+	replicationStats[names.Service(name)] = s
+	summarizeReplication()
+}
+
+// answer records the latency of a quorum read
+func (q *quorums) answer(rd *read, name string, r *archaius.ReplicationConfig) {
+	latency := clock.Since(rd.received)
+	collect.Measure(q.reads, latency)
+	replicationLock.Lock()
+	defer replicationLock.Unlock()
+	s := replicationStats[names.Service(name)]
+	s.Mode = r.Mode
+	s.Reads++
+	s.readTotal += latency
+	s.MeanRead = float64(s.readTotal) / float64(s.Reads) / float64(time.Millisecond)
+	if ms := float64(latency) / float64(time.Millisecond); ms > s.MaxRead {
+		s.MaxRead = ms
+	}
+	replicationStats[names.Service(name)] = s
+	summarizeReplication()
+}
+
+// save the histograms of the quorum writes and reads
+func (q *quorums) save(name string) {
+	if q == nil {
+		return
+	}
+	collect.SaveHist(q.writes, name, "_quorumwrite")
+	collect.SaveHist(q.reads, name, "_quorumread")
+}
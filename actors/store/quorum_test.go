@@ -0,0 +1,134 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// quorumTest is a store instance with two replicas that doesn't run, the test plays the part of its replicas
+type quorumTest struct {
+	name     string
+	q        *quorums
+	r        *archaius.ReplicationConfig
+	router   *ribbon.Router
+	replicas []chan gotocol.Message
+	listener chan gotocol.Message
+	client   chan gotocol.Message
+}
+
+func newQuorumTest(t *testing.T, reads int) *quorumTest {
+	archaius.SetService("db", archaius.ServiceConfig{Edges: map[string]archaius.EdgeConfig{"db": {Timeout: "20ms"}}})
+	qt := &quorumTest{
+		name:     names.Make("test", "us-east-1", "zoneA", "db", "store", 0),
+		r:        &archaius.ReplicationConfig{Mode: "quorum", R: reads},
+		router:   ribbon.MakeRouter(),
+		listener: make(chan gotocol.Message, 10),
+		client:   make(chan gotocol.Message, 10),
+	}
+	qt.q = newQuorums(qt.name, qt.r)
+	for i := 1; i <= 2; i++ {
+		ch := make(chan gotocol.Message, 10)
+		qt.replicas = append(qt.replicas, ch)
+		qt.router.Add(names.Make("test", "us-east-1", "zoneA", "db", "store", i), ch, time.Now())
+	}
+	msg := gotocol.Message{gotocol.GetRequest, qt.client, time.Now(), gotocol.NewTrace(), "key"}
+	if !qt.q.quorumRead(msg, "mine", qt.name, qt.listener, qt.r, qt.router) {
+		t.Fatal("a read that needs its replicas was answered straight away")
+	}
+	return qt
+}
+
+// request is the read a replica was asked for
+func (qt *quorumTest) request(t *testing.T, replica int) gotocol.Message {
+	select {
+	case m := <-qt.replicas[replica]:
+		return m
+	case <-time.After(time.Second):
+		t.Fatalf("replica %v wasn't asked", replica)
+	}
+	return gotocol.Message{}
+}
+
+// reply from a replica to the read it was asked for
+func (qt *quorumTest) reply(t *testing.T, req gotocol.Message, value string) {
+	if !qt.q.replied(gotocol.Message{gotocol.GetResponse, nil, time.Now(), req.Ctx, value}, qt.name, qt.listener, qt.r) {
+		t.Fatal("the reply wasn't taken as part of the quorum read")
+	}
+}
+
+// answer is what the client of the read got, or "" if it hasn't been answered
+func (qt *quorumTest) answer() string {
+	select {
+	case m := <-qt.client:
+		return m.Intention
+	case <-time.After(50 * time.Millisecond):
+		return ""
+	}
+}
+
+// TestQuorumRead checks R replies answer a read, and that the replies that aren't needed any more are forgotten
+func TestQuorumRead(t *testing.T) {
+	qt := newQuorumTest(t, 2) // this instance and one replica
+	first, second := qt.request(t, 0), qt.request(t, 1)
+	qt.reply(t, first, "theirs")
+	if a := qt.answer(); a != "mine" {
+		t.Fatalf("read answered with %q", a)
+	}
+	if len(qt.q.reading) != 0 {
+		t.Errorf("%v replies still waited for after the read was answered", len(qt.q.reading))
+	}
+	if qt.q.replied(gotocol.Message{gotocol.GetResponse, nil, time.Now(), second.Ctx, "theirs"}, qt.name, qt.listener, qt.r) {
+		t.Error("a late reply was counted")
+	}
+}
+
+// TestQuorumFailedReply checks a failed reply doesn't count towards the quorum, and the read fails once too many have failed
+func TestQuorumFailedReply(t *testing.T) {
+	qt := newQuorumTest(t, 2)
+	first, second := qt.request(t, 0), qt.request(t, 1)
+	qt.reply(t, first, gotocol.Failure("down"))
+	if a := qt.answer(); a != "" {
+		t.Fatalf("a failed reply answered the read with %q", a)
+	}
+	qt.reply(t, second, "theirs")
+	if a := qt.answer(); a != "mine" {
+		t.Fatalf("read answered with %q", a)
+	}
+	qt = newQuorumTest(t, 3) // every copy
+	qt.reply(t, qt.request(t, 0), gotocol.Failure("down"))
+	if a := qt.answer(); !gotocol.Failed(a) {
+		t.Errorf("a read that can't get a quorum answered with %q", a)
+	}
+	if len(qt.q.reading) != 0 {
+		t.Errorf("%v replies still waited for after the read failed", len(qt.q.reading))
+	}
+}
+
+// TestQuorumTimeout checks a replica that never replies times out, and fails a read that needs it
+func TestQuorumTimeout(t *testing.T) {
+	qt := newQuorumTest(t, 3)
+	qt.reply(t, qt.request(t, 0), "theirs")
+	qt.request(t, 1)        // and never replies
+	for range qt.replicas { // the timeout fails each replica, the one that replied is already forgotten
+		select {
+		case m := <-qt.listener:
+			if m.Intention != gotocol.Failure("timeout") {
+				t.Fatalf("timed out with %v", m.Intention)
+			}
+			qt.q.replied(m, qt.name, qt.listener, qt.r)
+		case <-time.After(time.Second):
+			t.Fatal("the read didn't time out")
+		}
+	}
+	if a := qt.answer(); a != gotocol.Failure("quorum") {
+		t.Errorf("timed out read answered with %q", a)
+	}
+	if len(qt.q.reading) != 0 {
+		t.Errorf("%v replies still waited for after the read timed out", len(qt.q.reading))
+	}
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// write taken by this instance, waiting for its replicas to acknowledge it
+type write struct {
+	msg       gotocol.Message
+	received  time.Time
+	needed    int // acknowledgements
+	acked     int
+	committed bool
+
+	// acknowledgements to commit, every replica unless it's a quorum write
+	quorum int
+	hist   *generic.Histogram
+}
+
+// ReplicationStats counts the writes to a replicated store service, summed over its instances
+type ReplicationStats struct {
+	Mode       string  `json:"mode"`
+	Writes     int     `json:"writes"`
+	MeanWrite  float64 `json:"meanwritems"`
+	MaxWrite   float64 `json:"maxwritems"`
+	Failovers  int     `json:"failovers"`
+	Lost       int     `json:"lostwrites"`       // async writes that completed before any replica had them
+	Unfinished int     `json:"unfinishedwrites"` // sync writes still waiting for replicas, never completed so not lost
+	total      time.Duration
+
+	// quorum reads, that waited for enough replicas
+	Reads       int     `json:"reads,omitempty"`
+	MeanRead    float64 `json:"meanreadms,omitempty"`
+	MaxRead     float64 `json:"maxreadms,omitempty"`
+	FailedReads int     `json:"failedreads,omitempty"` // that too many replicas failed or timed out for
+	readTotal   time.Duration
+}
+
+var replicationStats = make(map[string]ReplicationStats) // by service name
+var replicationLock sync.Mutex
+
+func summarizeReplication() {
+	summary := make(map[string]ReplicationStats, len(replicationStats))
+	for k, v := range replicationStats {
+		summary[k] = v
+	}
+	collect.Summarize("replication", summary)
+}
+
+// replicas picks the other instances of this service to copy writes to, in name order so they are the same each time
+func replicas(name string, r *archaius.ReplicationConfig, router *ribbon.Router) []string {
+	var peers []string
+	for _, n := range router.Names() {
+		if names.Service(n) == names.Service(name) {
+			peers = append(peers, n)
+		}
+	}
+	sort.Strings(peers)
+	if r.Replicas > 0 && r.Replicas < len(peers) {
+		peers = peers[:r.Replicas]
+	}
+	return peers
+}
+
+// replicaLatency is the edge latency from the service to itself, each way between an instance and its replicas
+func replicaLatency(name string) time.Duration {
+	latency, _ := time.ParseDuration(archaius.Edge(names.Service(name), names.Service(name)).Latency)
+	return latency
+}
+
+// replicate copies a write to the replicas, async copies are delayed by the lag, and quorum copies by anything slowing their
+// replica down
+func replicate(msg gotocol.Message, name string, listener chan gotocol.Message, r *archaius.ReplicationConfig, router *ribbon.Router, pending map[string]*write, q *quorums) {
+	w := &write{msg: msg, received: clock.Now()}
+	latency := replicaLatency(name)
+	if r.Mode == "async" {
+		lag, _ := time.ParseDuration(r.Lag)
+		latency += lag
+	}
+	peers := replicas(name, r, router)
+	w.quorum = len(peers)
+	if q != nil {
+		w.quorum = quorum(r.W, len(peers)+1) - 1 // this instance has its own copy
+		w.hist = q.writes
+	}
+	for _, n := range peers {
+		if q != nil {
+			latency = copyLatency(name, n)
+		}
+		outmsg := gotocol.Message{gotocol.Replicate, listener, clock.Now().Add(latency), msg.Ctx.NewParent(), msg.Intention}
+		pending[outmsg.Ctx.String()] = w
+		w.needed++
+		flow.AnnotateSend(outmsg, name)
+		outmsg.GoSendAfter(router.Named(n), latency)
+	}
+	if r.Mode == "async" || w.quorum == 0 {
+		commit(w, name, listener, r)
+	}
+}
+
+// commit completes a write, the server send annotation closes the span of the Put in the flow
+func commit(w *write, name string, listener chan gotocol.Message, r *archaius.ReplicationConfig) {
+	w.committed = true
+	done := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), w.msg.Ctx, "committed"}
+	flow.AnnotateSend(done, name)
+	latency := clock.Since(w.received)
+	collect.Measure(w.hist, latency)
+	replicationLock.Lock()
+	defer replicationLock.Unlock()
+	s := replicationStats[names.Service(name)]
+	s.Mode = r.Mode
+	s.Writes++
+	s.total += latency
+	s.MeanWrite = float64(s.total) / float64(s.Writes) / float64(time.Millisecond)
+	if ms := float64(latency) / float64(time.Millisecond); ms > s.MaxWrite {
+		s.MaxWrite = ms
+	}
+	replicationStats[names.Service(name)] = s
+	summarizeReplication()
+}
+
+// acknowledged counts a reply from a replica, sync writes commit when every replica has replied and quorum writes when
+// enough of them have
+func acknowledged(msg gotocol.Message, name string, listener chan gotocol.Message, r *archaius.ReplicationConfig, pending map[string]*write) {
+	w, ok := pending[msg.Ctx.String()]
+	if !ok {
+		return
+	}
+	delete(pending, msg.Ctx.String())
+	w.acked++
+	if !w.committed && w.acked == w.quorum {
+		commit(w, name, listener, r)
+	}
+}
+
+// failover counts the writes that are lost, or never completed, when this instance fails
+func failover(name string, r *archaius.ReplicationConfig, pending map[string]*write) {
+	counted := make(map[*write]bool)
+	replicationLock.Lock()
+	defer replicationLock.Unlock()
+	s := replicationStats[names.Service(name)]
+	s.Mode = r.Mode
+	s.Failovers++
+	for _, w := range pending {
+		if counted[w] {
+			continue
+		}
+		counted[w] = true
+		switch {
+		case !w.committed:
+			s.Unfinished++
+		case w.acked == 0:
+			s.Lost++ // the client was told it worked, but no replica is known to have it
+		}
+	}
+	replicationStats[names.Service(name)] = s
+	summarizeReplication()
+}
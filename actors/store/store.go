@@ -5,6 +5,7 @@ package store
 import (
 	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
@@ -24,9 +25,68 @@ func Start(listener chan gotocol.Message) {
 	var netflixoss chan gotocol.Message                                           // remember creator and how to talk back to incoming requests
 	var name string                                                               // remember my name
 	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
+	var replication *archaius.ReplicationConfig                                   // nil unless writes are replicated to the other instances of this service
+	pending := make(map[string]*write)                                            // writes by the span of each copy to a replica
+	var quora *quorums                                                            // nil unless reads and writes wait for a quorum of the replicas
+	var caching *archaius.CachingConfig                                           // nil unless this is a cache with a caching pattern
+	var evict *evictor                                                            // nil unless the cache has a capacity
+	var warm *warming                                                             // since the cache last went cold
+	requestor := make(map[string]gotocol.Routetype)                               // requests that missed, while they're read through
+	fetching := make(map[string]string)                                           // keys being read through, by the span of the fetch
+	dirty := make(map[string]gotocol.Message)                                     // latest write behind to each key that hasn't been flushed
+	var flush <-chan time.Time                                                    // nil unless there are writes behind to flush
+	var leader *archaius.LeaderConfig                                             // nil unless the instances elect a leader to take the writes
+	var walog *wal                                                                // nil unless writes are made durable by a write ahead log first
 	hist := collect.NewHist("")
 	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
-	eurekaTicker := time.NewTicker(ep)
+	eurekaTicker := clock.NewTicker(ep)
+	// drop every key a cache holds if it has been flushed or restarted, and the writes behind it hadn't flushed if it restarted
+	chill := func() {
+		why := warm.cold(name, caching)
+		if why == "" {
+			return
+		}
+		if why == "restart" {
+			lostWrites(name, caching, dirty)
+			for k := range dirty {
+				delete(dirty, k)
+			}
+		}
+		for k := range store {
+			delete(store, k)
+		}
+		evict = newEvictor(caching)
+	}
+	// keep a value, evicting other keys if the cache is full
+	keep := func(key, value string) {
+		if caching != nil {
+			chill()
+		}
+		store[key] = value
+		if n := evict.cache(key, store); n > 0 {
+			count(name, caching, func(s *CachingStats) { s.Evictions += n })
+		}
+	}
+	// apply a write, replicating it to the other instances
+	apply := func(msg gotocol.Message) {
+		var key, value string
+		fmt.Sscanf(msg.Intention, "%s%s", &key, &value)
+		if key != "" && value != "" {
+			flush = writeBehind(msg, key, name, caching, microservices, dirty, flush)
+		}
+		if key != "" && value != "" && replication != nil {
+			keep(key, value)
+			replicate(msg, name, listener, replication, microservices, pending, quora)
+		} else if key != "" && value != "" {
+			keep(key, value)
+			// duplicate the request on to all connected store nodes with the same package name as this one
+			for _, n := range microservices.All(names.Package(name)).Names() {
+				outmsg := gotocol.Message{gotocol.Replicate, listener, clock.Now(), msg.Ctx.NewParent(), msg.Intention}
+				flow.AnnotateSend(outmsg, name)
+				outmsg.GoSend(microservices.Named(n))
+			}
+		}
+	}
 	for {
 		select {
 		case msg := <-listener:
@@ -37,7 +97,21 @@ func Start(listener chan gotocol.Message) {
 					// if I don't have a name yet remember what I've been named
 					netflixoss = msg.ResponseChan // remember how to talk to my namer
 					name = msg.Intention          // message body is my name
+					microservices.Own(name)
 					hist = collect.NewHist(name)
+					replication = archaius.Service(names.Service(name)).Replication
+					quora = newQuorums(name, replication)
+					caching = archaius.Service(names.Service(name)).Caching
+					if evict = newEvictor(caching); evict != nil {
+						evict.cache("why?", store)
+					}
+					if caching != nil {
+						warm = newWarming(name, caching)
+					}
+					if leader = archaius.Service(names.Service(name)).Leader; leader != nil {
+						join(name, listener, leader)
+					}
+					walog = newWAL(name)
 				}
 			case gotocol.Inform:
 				eureka[msg.Intention] = handlers.Inform(msg, name, listener)
@@ -47,25 +121,56 @@ func Start(listener chan gotocol.Message) {
 				// forget a buddy
 				handlers.Forget(&dependencies, microservices, msg)
 			case gotocol.GetRequest:
+				if caching != nil {
+					chill()
+				}
+				value, hit := store[msg.Intention]
+				if hit && caching != nil && !warm.warmed(name, caching) {
+					value, hit = "", false // still warming up
+				}
+				if caching != nil && !hit && readThrough(msg, name, listener, caching, microservices, &requestor, fetching) {
+					break
+				} else if caching != nil {
+					if hit {
+						evict.use(msg.Intention)
+					}
+					count(name, caching, func(s *CachingStats) {
+						if hit {
+							s.Hits++
+						} else {
+							s.Misses++
+						}
+					})
+				}
+				if handlers.OOM(msg, name, listener, nil) || handlers.Duplicate(msg, name, listener) || handlers.InjectError(msg, name, listener) {
+					break
+				}
+				if quora.quorumRead(msg, value, name, listener, replication, microservices) {
+					break // answered when enough replicas have replied
+				}
 				// return any stored value for this key
-				outmsg := gotocol.Message{gotocol.GetResponse, listener, time.Now(), msg.Ctx, store[msg.Intention]}
+				outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, value}
 				flow.AnnotateSend(outmsg, name)
-				outmsg.GoSend(msg.ResponseChan)
+				handlers.Remember(outmsg, name)
+				outmsg.GoRespond(msg.ResponseChan)
 			case gotocol.GetResponse:
-				// return path from a request, send payload back up (not currently used)
+				// a value read through from the origin, a copy from a replica for a quorum read, or an acknowledgement from a replica
+				if fetched(msg, name, listener, &requestor, fetching, keep) || quora.replied(msg, name, listener, replication) {
+					break
+				}
+				if replication != nil {
+					acknowledged(msg, name, listener, replication, pending)
+				}
 			case gotocol.Put:
 				// set a key value pair and replicate to other stores
-				var key, value string
-				fmt.Sscanf(msg.Intention, "%s%s", &key, &value)
-				if key != "" && value != "" {
-					store[key] = value
-					// duplicate the request on to all connected store nodes with the same package name as this one
-					for _, n := range microservices.All(names.Package(name)).Names() {
-						outmsg := gotocol.Message{gotocol.Replicate, listener, time.Now(), msg.Ctx.NewParent(), msg.Intention}
-						flow.AnnotateSend(outmsg, name)
-						outmsg.GoSend(microservices.Named(n))
-					}
+				if leader != nil && toLeader(msg, name, listener) {
+					break
+				}
+				if walog != nil {
+					walog.append(msg) // applied once it's durable
+					break
 				}
+				apply(msg)
 			case gotocol.Replicate:
 				// Replicate is used between store nodes
 				// end point for a request
@@ -73,16 +178,47 @@ func Start(listener chan gotocol.Message) {
 				fmt.Sscanf(msg.Intention, "%s%s", &key, &value)
 				// log.Printf("store: %v:%v", key, value)
 				if key != "" && value != "" {
-					store[key] = value
+					keep(key, value)
+				}
+				if replication != nil {
+					outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, "ack"}
+					flow.AnnotateSend(outmsg, name)
+					outmsg.GoSendAfter(msg.ResponseChan, replicaLatency(name))
 				}
 			case gotocol.Goodbye:
-				gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NilContext, name}.GoSend(netflixoss)
+				if replication != nil && msg.Intention == "chaosmonkey" {
+					failover(name, replication, pending) // a failure rather than the end of the run
+				}
+				if msg.Intention == "chaosmonkey" {
+					lostWrites(name, caching, dirty)
+				}
+				if walog != nil {
+					collect.SaveHist(walog.hist, name, "_wal")
+				}
+				quora.save(name)
+				if leader != nil && msg.Intention != "shutdown" {
+					leave(name) // killed or scaled down
+				}
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(netflixoss)
 				return
 			}
+		case <-walWindow(walog):
+			walog.windowClosed()
+		case ws := <-walSynced(walog):
+			for _, m := range ws {
+				if replication == nil { // the server send annotation closes the span of the Put in the flow, replicated writes close it when they commit
+					flow.AnnotateSend(gotocol.Message{gotocol.GetResponse, listener, clock.Now(), m.Ctx, "synced"}, name)
+				}
+				apply(m)
+			}
+			walog.done()
+		case <-flush:
+			flush = nil
+			flushWrites(name, listener, caching, microservices, &requestor, dirty)
 		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
-			for dep := range dependencies {
-				for _, ch := range eureka {
-					ch <- gotocol.Message{gotocol.GetRequest, listener, time.Now(), gotocol.NilContext, dep}
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
 				}
 			}
 		}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// WALStats counts the writes a store service made durable with its write ahead log, summed over its instances
+type WALStats struct {
+	Writes    int     `json:"writes"`
+	Fsyncs    int     `json:"fsyncs"`
+	MeanBatch float64 `json:"meanbatch"` // writes per fsync, more than one with group commit
+	MeanFsync float64 `json:"meanfsyncms"`
+	MeanWrite float64 `json:"meanwritems"` // from arriving to durable, including the wait for the window and the fsyncs before
+	MaxWrite  float64 `json:"maxwritems"`
+	fsyncs    time.Duration
+	writes    time.Duration
+}
+
+// wal of an instance, writes queue for the next fsync while one is in progress
+type wal struct {
+	config  *archaius.WALConfig
+	name    string
+	queue   []gotocol.Message
+	arrived []time.Time
+	syncing bool
+	window  <-chan time.Time // open group commit window, nil if there isn't one
+	synced  chan []gotocol.Message
+	hist    *generic.Histogram
+}
+
+var walStats = make(map[string]*WALStats) // by service name
+var walLock sync.Mutex
+
+func summarizeWAL() {
+	summary := make(map[string]WALStats, len(walStats))
+	for k, v := range walStats {
+		summary[k] = *v
+	}
+	collect.Summarize("wal", summary)
+}
+
+// newWAL is the write ahead log of an instance, nil if its service doesn't have one
+func newWAL(name string) *wal {
+	c := archaius.Service(names.Service(name)).WAL
+	if c == nil {
+		return nil
+	}
+	return &wal{config: c, name: name, synced: make(chan []gotocol.Message, 1), hist: collect.NewHist(name + "_wal")}
+}
+
+// append queues a write for the log, the fsync starts straight away if the log is idle, or when the group commit window
+// closes. Writes that arrive during an fsync go in the next one
+func (w *wal) append(msg gotocol.Message) {
+	w.queue = append(w.queue, msg)
+	w.arrived = append(w.arrived, clock.Now())
+	if w.syncing || w.window != nil {
+		return
+	}
+	if g, _ := time.ParseDuration(w.config.Group); g > 0 {
+		w.window = clock.After(g)
+		return
+	}
+	w.fsync()
+}
+
+// fsync the queued writes, all of them with group commit or the first one without, and send them on synced when it's done
+func (w *wal) fsync() {
+	n := len(w.queue)
+	if n == 0 {
+		return
+	}
+	if w.config.Group == "" {
+		n = 1
+	}
+	batch := append([]gotocol.Message(nil), w.queue[:n]...)
+	arrived := append([]time.Time(nil), w.arrived[:n]...)
+	w.queue, w.arrived = w.queue[n:], w.arrived[n:]
+	w.syncing = true
+	mean, _ := time.ParseDuration(w.config.Fsync)
+	d := handlers.Draw(w.name, mean, w.config.Distribution)
+	clock.AfterFunc(d, func() {
+		walLock.Lock()
+		s := walStats[names.Service(w.name)]
+		if s == nil {
+			s = &WALStats{}
+			walStats[names.Service(w.name)] = s
+		}
+		s.Fsyncs++
+		s.fsyncs += d
+		for _, a := range arrived {
+			l := clock.Since(a)
+			collect.Measure(w.hist, l)
+			s.Writes++
+			s.writes += l
+			if ms := float64(l) / float64(time.Millisecond); ms > s.MaxWrite {
+				s.MaxWrite = ms
+			}
+		}
+		s.MeanBatch = float64(s.Writes) / float64(s.Fsyncs)
+		s.MeanFsync = float64(s.fsyncs) / float64(s.Fsyncs) / float64(time.Millisecond)
+		s.MeanWrite = float64(s.writes) / float64(s.Writes) / float64(time.Millisecond)
+		summarizeWAL()
+		walLock.Unlock()
+		w.synced <- batch
+	})
+}
+
+// done is called by the instance with the writes that were just made durable, and starts the next fsync for any writes that
+// queued up during it
+func (w *wal) done() {
+	w.syncing = false
+	w.fsync()
+}
+
+// windowClosed starts the fsync of the writes collected in the group commit window
+func (w *wal) windowClosed() {
+	w.window = nil
+	if !w.syncing {
+		w.fsync()
+	}
+}
+
+// walWindow and walSynced are the channels of the log for the select loop of the instance, nil if it doesn't have a log
+func walWindow(w *wal) <-chan time.Time {
+	if w == nil {
+		return nil
+	}
+	return w.window
+}
+
+func walSynced(w *wal) <-chan []gotocol.Message {
+	if w == nil {
+		return nil
+	}
+	return w.synced
+}
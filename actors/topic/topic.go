@@ -0,0 +1,385 @@
+// Package topic simulates a Kafka style pub/sub topic
+// Producers Publish messages without waiting, and each instance appends them to one of its partitions. Every consumer group
+// that Subscribes has each message Delivered to one of its members, in order within a partition, and commits it when it's acknowledged.
+package topic
+
+import (
+	"fmt"
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// message published to a partition
+type message struct {
+	ctx       gotocol.Context
+	body      string
+	published time.Time
+}
+
+// partition is a log of messages, offsets count from the first message ever published to it
+type partition struct {
+	log   []*message
+	first int // offset of log[0], the older messages have been committed by every group or dropped
+}
+
+// group of consumers, each partition is delivered to one of the members, a message at a time
+type group struct {
+	members  map[string]chan gotocol.Message // subscriber instances
+	offsets  []int                           // of the next message of each partition, committed when it's acknowledged
+	inflight []string                        // span context of the delivery of each partition waiting to be acknowledged, "" if there isn't one
+	attempts []int                           // deliveries of the message at the offset of each partition
+	stats    GroupStats
+}
+
+// delivery waiting for a consumer to acknowledge it
+type delivery struct {
+	group     string
+	partition int
+	consumer  string
+	session   *clock.Timer
+}
+
+// GroupStats for a consumer group of a topic service, summed over its instances
+type GroupStats struct {
+	Delivered   int           `json:"delivered"`
+	Processed   int           `json:"processed"`
+	Retries     int           `json:"retries"`     // deliveries again after a failure or a session timeout
+	DeadLetters int           `json:"deadletters"` // skipped after running out of retries
+	Lost        int           `json:"lost"`        // dropped from a full partition before the group had them
+	Rebalances  int           `json:"rebalances"`  // members taken out of the group for not acknowledging in their session
+	MaxLag      int           `json:"maxlag"`      // most messages the group was behind
+	Lag         int           `json:"lag"`         // left behind at the end
+	Latency     float64       `json:"latencyms"`   // mean time from publish to acknowledgement
+	total       time.Duration // of the processed messages
+}
+
+// Stats for a topic service, summed over its instances
+type Stats struct {
+	Published int                   `json:"published"`
+	MaxDepth  int                   `json:"maxdepth"` // most messages a partition held
+	Groups    map[string]GroupStats `json:"groups"`
+}
+
+var stats = make(map[string]Stats)
+var statsLock sync.Mutex
+
+// summarize adds the counts for an instance that is shutting down to the run summary
+func summarize(name string, s Stats) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	t := stats[names.Service(name)]
+	t.Published += s.Published
+	if s.MaxDepth > t.MaxDepth {
+		t.MaxDepth = s.MaxDepth
+	}
+	if t.Groups == nil {
+		t.Groups = make(map[string]GroupStats)
+	}
+	for g, gs := range s.Groups {
+		ts := t.Groups[g]
+		ts.Delivered += gs.Delivered
+		ts.Processed += gs.Processed
+		ts.Retries += gs.Retries
+		ts.DeadLetters += gs.DeadLetters
+		ts.Lost += gs.Lost
+		ts.Rebalances += gs.Rebalances
+		ts.Lag += gs.Lag
+		if gs.MaxLag > ts.MaxLag {
+			ts.MaxLag = gs.MaxLag
+		}
+		ts.total += gs.total
+		if ts.Processed > 0 {
+			ts.Latency = float64(ts.total) / float64(ts.Processed) / float64(time.Millisecond)
+		}
+		t.Groups[g] = ts
+	}
+	stats[names.Service(name)] = t
+	summary := make(map[string]Stats, len(stats))
+	for k, v := range stats {
+		groups := make(map[string]GroupStats, len(v.Groups))
+		for g, gs := range v.Groups {
+			groups[g] = gs
+		}
+		v.Groups = groups
+		summary[k] = v
+	}
+	collect.Summarize("topics", summary)
+}
+
+// Start topic, all configuration and state is sent via messages
+func Start(listener chan gotocol.Message) {
+	var parent chan gotocol.Message                                               // remember how to talk back to creator
+	var name string                                                               // remember my name
+	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
+	hist := collect.NewHist("")
+	var fetchTicker *clock.Ticker
+	var fetch <-chan time.Time             // nil until I have a name
+	var fetched time.Time                  // when the consumers last fetched, they have what was published before
+	partitions := make([]partition, 1)     // split by trace
+	groups := make(map[string]*group)      // by name
+	inflight := make(map[string]*delivery) // waiting to be acknowledged, by span context
+	expired := make(map[string]*delivery)  // that weren't acknowledged in the session, by span context
+	lag, session, retries := 100*time.Millisecond, 10*time.Second, 3
+	depth := 0
+	var s Stats
+	// lagging is how many of the messages kept a group is behind, across the partitions
+	lagging := func(g *group) int {
+		n := 0
+		for p := range partitions {
+			offset := g.offsets[p]
+			if offset < partitions[p].first {
+				offset = partitions[p].first
+			}
+			n += partitions[p].first + len(partitions[p].log) - offset
+		}
+		return n
+	}
+	// gauge publishes the total lag of the groups
+	gauge := func() {
+		var n int
+		for _, g := range groups {
+			l := lagging(g)
+			if l > g.stats.MaxLag {
+				g.stats.MaxLag = l
+			}
+			n += l
+		}
+		collect.SetGauge(name, int64(n))
+	}
+	// trim drops the messages every group has committed from a partition
+	trim := func(p int) {
+		if len(groups) == 0 {
+			return
+		}
+		min := partitions[p].first + len(partitions[p].log)
+		for _, g := range groups {
+			if g.offsets[p] < min {
+				min = g.offsets[p]
+			}
+		}
+		if n := min - partitions[p].first; n > 0 {
+			partitions[p].log = partitions[p].log[n:]
+			partitions[p].first = min
+		}
+	}
+	// deliver the next message of a partition to the member of a group it's assigned to, if it had been fetched and the last
+	// one has been acknowledged
+	deliver := func(gn string, p int) {
+		g := groups[gn]
+		part := &partitions[p]
+		if g.inflight[p] != "" || len(g.members) == 0 {
+			return
+		}
+		if g.offsets[p] < part.first { // dropped before the group had them
+			g.stats.Lost += part.first - g.offsets[p]
+			g.offsets[p], g.attempts[p] = part.first, 0
+		}
+		if g.offsets[p] >= part.first+len(part.log) {
+			return
+		}
+		m := part.log[g.offsets[p]-part.first]
+		if m.published.After(fetched) {
+			return // waits for the next fetch
+		}
+		var members []string
+		for n := range g.members {
+			members = append(members, n)
+		}
+		sort.Strings(members)
+		consumer := members[p%len(members)]
+		e := archaius.Edge(names.Service(consumer), names.Service(name))
+		latency, _ := time.ParseDuration(e.Latency)
+		latency += handlers.CrossZone(name, consumer) + archaius.Degraded(consumer)
+		response, _ := time.ParseDuration(e.Response)
+		outmsg := gotocol.Message{gotocol.Deliver, listener, clock.Now(), m.ctx.NewParent().WithResponse(response), m.body}
+		g.inflight[p] = outmsg.Ctx.String()
+		g.attempts[p]++
+		g.stats.Delivered++
+		if g.attempts[p] > 1 {
+			g.stats.Retries++
+		}
+		d := &delivery{gn, p, consumer, nil}
+		inflight[outmsg.Ctx.String()] = d
+		flow.AnnotateSend(outmsg, name)
+		flow.NoteTopic(outmsg, name, fmt.Sprintf("%v:%v %v", p, g.offsets[p], gn))
+		outmsg.GoSendAfter(g.members[consumer], latency)
+		// if it isn't acknowledged in time, take it back via my own listener, and the consumer out of the group
+		ctx := outmsg.Ctx
+		d.session = clock.AfterFunc(session, func() {
+			gotocol.Send(listener, gotocol.Message{gotocol.GetResponse, listener, clock.Now(), ctx, gotocol.Failure("session")})
+		})
+	}
+	// deliverAll tries every partition of every group, in name order so a seeded run repeats
+	deliverAll := func() {
+		var gns []string
+		for gn := range groups {
+			gns = append(gns, gn)
+		}
+		sort.Strings(gns)
+		for _, gn := range gns {
+			for p := range partitions {
+				deliver(gn, p)
+			}
+		}
+		gauge()
+	}
+	// join adds a member to a group, a new group starts from the oldest message kept
+	join := func(gn, consumer string, c chan gotocol.Message) {
+		g := groups[gn]
+		if g == nil {
+			g = &group{members: make(map[string]chan gotocol.Message), offsets: make([]int, len(partitions)),
+				inflight: make([]string, len(partitions)), attempts: make([]int, len(partitions))}
+			for p := range partitions {
+				g.offsets[p] = partitions[p].first
+			}
+			groups[gn] = g
+		}
+		if g.members[consumer] == nil && archaius.Conf.Msglog {
+			log.Printf("%v: %v joined group %v\n", name, consumer, gn)
+		}
+		g.members[consumer] = c
+	}
+	// leave takes a member that didn't acknowledge a delivery in its session out of its group, its partitions go to the others
+	leave := func(g *group, consumer string) {
+		if g.members[consumer] == nil {
+			return
+		}
+		delete(g.members, consumer)
+		g.stats.Rebalances++
+		if archaius.Conf.Msglog {
+			log.Printf("%v: %v left the group, rebalancing\n", name, consumer)
+		}
+		collect.Mark("rebalance", name+" "+consumer)
+	}
+	for {
+		select {
+		case msg := <-listener:
+			flow.Instrument(msg, name, hist)
+			switch msg.Imposition {
+			case gotocol.Hello:
+				if name == "" {
+					// if I don't have a name yet remember what I've been named
+					parent = msg.ResponseChan // remember how to talk to my namer
+					name = msg.Intention      // message body is my name
+					hist = collect.NewHist(name)
+					if t := archaius.Service(names.Service(name)).Topic; t != nil {
+						if t.Partitions > 0 {
+							partitions = make([]partition, t.Partitions)
+						}
+						depth = t.Depth
+						if l, err := time.ParseDuration(t.Lag); err == nil && l > 0 {
+							lag = l
+						}
+						if ss, err := time.ParseDuration(t.Session); err == nil && ss > 0 {
+							session = ss
+						}
+						if t.Retries > 0 {
+							retries = t.Retries
+						}
+					}
+					fetchTicker = clock.NewTicker(lag)
+					fetch = fetchTicker.C
+				}
+			case gotocol.Inform:
+				eureka[msg.Intention] = handlers.Inform(msg, name, listener)
+			case gotocol.Publish, gotocol.Put:
+				// append to a partition, the producer isn't waiting for an answer
+				p := int(msg.Ctx.Trace) % len(partitions)
+				part := &partitions[p]
+				offset := part.first + len(part.log)
+				ctx := msg.Ctx
+				ctx.Deadline = 0 // the consumers aren't held to the deadline of the request that published it
+				part.log = append(part.log, &message{ctx, msg.Intention, clock.Now()})
+				if depth > 0 && len(part.log) > depth {
+					part.log[0] = nil
+					part.log = part.log[1:]
+					part.first++
+				}
+				s.Published++
+				if len(part.log) > s.MaxDepth {
+					s.MaxDepth = len(part.log)
+				}
+				outmsg := gotocol.Message{gotocol.GetResponse, nil, clock.Now(), msg.Ctx, "published"} // the end of the span, not sent
+				flow.AnnotateSend(outmsg, name)
+				flow.NoteTopic(outmsg, name, fmt.Sprintf("%v:%v", p, offset))
+				gauge()
+			case gotocol.Subscribe:
+				// join a group, the body is the group and consumer names
+				if f := strings.SplitN(msg.Intention, " ", 2); len(f) == 2 && msg.ResponseChan != nil {
+					join(f[0], f[1], msg.ResponseChan)
+				}
+			case gotocol.GetResponse:
+				// a consumer acknowledged a delivery, or failed it, or the session ran out first
+				d := inflight[msg.Ctx.String()]
+				if d == nil {
+					if d = expired[msg.Ctx.String()]; d != nil && msg.ResponseChan != listener {
+						delete(expired, msg.Ctx.String())
+						join(d.group, d.consumer, msg.ResponseChan) // a slow consumer rejoins when it answers
+						deliverAll()
+					}
+					break // already dealt with
+				}
+				delete(inflight, msg.Ctx.String())
+				g := groups[d.group]
+				g.inflight[d.partition] = ""
+				part := &partitions[d.partition]
+				if msg.ResponseChan == listener && msg.Intention == gotocol.Failure("session") {
+					expired[msg.Ctx.String()] = d
+					leave(g, d.consumer)
+				} else {
+					d.session.Stop()
+				}
+				if gotocol.Failed(msg.Intention) && g.attempts[d.partition] <= retries {
+					deliver(d.group, d.partition) // again, to whichever member has the partition now
+					gauge()
+					break
+				}
+				if gotocol.Failed(msg.Intention) {
+					g.stats.DeadLetters++
+					if archaius.Conf.Msglog {
+						log.Printf("%v: dead letter in group %v after %v attempts, %v\n", name, d.group, g.attempts[d.partition], msg.Intention)
+					}
+				} else if i := g.offsets[d.partition] - part.first; i >= 0 && i < len(part.log) {
+					g.stats.Processed++
+					e2e := clock.Since(part.log[i].published)
+					g.stats.total += e2e
+					collect.MeasureService(names.Service(name), e2e, false) // end to end latency
+				}
+				g.offsets[d.partition]++ // commit
+				g.attempts[d.partition] = 0
+				trim(d.partition)
+				deliver(d.group, d.partition) // the consumer already has the rest of what it fetched
+				gauge()
+			case gotocol.Goodbye:
+				s.Groups = make(map[string]GroupStats, len(groups))
+				for gn, g := range groups {
+					g.stats.Lag = lagging(g)
+					s.Groups[gn] = g.stats
+				}
+				summarize(name, s)
+				collect.DeleteGauge(name)
+				if fetchTicker != nil {
+					fetchTicker.Stop()
+				}
+				for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+					ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
+				}
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
+				return
+			}
+		case <-fetch: // the consumers fetch what has been published
+			fetched = clock.Now()
+			deliverAll()
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Package twophase simulates a coordinator for distributed transactions that commit atomically across several services
+// Each transaction asks all its participants to prepare at once and collects their votes, then tells them all to commit, or
+// to abort if any of them voted no, failed or didn't vote in time. The caller waits for both phases, so each phase takes as
+// long as its slowest participant. A participant that fails to commit after it voted yes leaves the transaction blocked.
+package twophase
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// txn is a transaction in flight, the instance picked for each participant and how far it has got
+type txn struct {
+	msg        gotocol.Message // that started it, answered when it's done unless it's a put
+	tx         *archaius.TwoPhaseTransaction
+	started    time.Time
+	prepared   time.Time // when the last participant voted yes
+	instances  []string  // picked for each participant, the same instance is asked to prepare and commit
+	votes      int
+	committing bool
+	acks       int
+	attempts   map[int]int              // of the commit in flight to each participant
+	request    gotocol.TraceContextType // idempotency key of the commits, the same for every retry
+}
+
+// call to a participant waiting for its vote or acknowledgement
+type call struct {
+	t *txn
+	p int // index of the participant
+}
+
+// Stats for a twophase service, summed over its instances
+type Stats struct {
+	Started     int            `json:"started"`
+	Committed   int            `json:"committed"`
+	Aborted     int            `json:"aborted"`
+	Blocked     int            `json:"blocked"`   // a participant that voted yes never acknowledged the commit, so it's left holding its locks
+	AbortedBy   map[string]int `json:"abortedby"` // by the service that voted no, failed or didn't vote in time
+	Commits     int            `json:"commits"`   // calls made to commit, including retries
+	PrepareMs   float64        `json:"preparems"` // mean time for every participant to vote yes
+	CommitMs    float64        `json:"commitms"`  // mean time for every participant to acknowledge the commit
+	CommittedMs float64        `json:"committedms"`
+	prepare     time.Duration
+	commit      time.Duration
+	committed   time.Duration
+}
+
+var stats = make(map[string]*Stats)
+var statsLock sync.Mutex
+
+// record the outcome of a transaction and update the run summary
+func record(name string, f func(s *Stats)) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	s := stats[names.Service(name)]
+	if s == nil {
+		s = &Stats{AbortedBy: make(map[string]int)}
+		stats[names.Service(name)] = s
+	}
+	f(s)
+	ms := func(d time.Duration, n int) float64 {
+		if n == 0 {
+			return 0
+		}
+		return float64(d) / float64(n) / float64(time.Millisecond)
+	}
+	s.PrepareMs = ms(s.prepare, s.Committed)
+	s.CommitMs = ms(s.commit, s.Committed)
+	s.CommittedMs = ms(s.committed, s.Committed)
+	summary := make(map[string]Stats, len(stats))
+	for k, v := range stats {
+		c := *v
+		c.AbortedBy = make(map[string]int, len(v.AbortedBy))
+		for a, n := range v.AbortedBy {
+			c.AbortedBy[a] = n
+		}
+		summary[k] = c
+	}
+	collect.Summarize("twophase", summary)
+}
+
+// transaction is the type picked by the body of a request, the one with no name if no other matches, or nil
+func transaction(tc *archaius.TwoPhaseConfig, body string) *archaius.TwoPhaseTransaction {
+	var other *archaius.TwoPhaseTransaction
+	for i, tx := range tc.Transactions {
+		if tx.Name == body {
+			return &tc.Transactions[i]
+		}
+		if tx.Name == "" {
+			other = &tc.Transactions[i]
+		}
+	}
+	return other
+}
+
+// Start twophase, all configuration and state is sent via messages
+func Start(listener chan gotocol.Message) {
+	// remember the channel to talk to the participants
+	microservices := ribbon.MakeRouter()
+	dependencies := make(map[string]time.Time)                                    // dependent services and time last updated
+	var parent chan gotocol.Message                                               // remember how to talk back to creator
+	var name string                                                               // remember my name
+	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
+	hist := collect.NewHist("")
+	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
+	eurekaTicker := clock.NewTicker(ep)
+	var tc *archaius.TwoPhaseConfig
+	timeout, retries := time.Second, 3
+	inflight := make(map[string]call) // by span context of the prepare or commit call
+	active := make(map[*txn]bool)     // started and not finished
+	// send the phase to one participant, a prepare or commit waits for its answer, an abort isn't answered
+	send := func(t *txn, p int, phase string) {
+		service := t.tx.Participants[p]
+		ctx := t.msg.Ctx.NewParent()
+		imp := gotocol.GetRequest
+		switch phase {
+		case "commit":
+			ctx = ctx.WithRequest(t.request)
+		case "abort":
+			imp = gotocol.Put
+		}
+		e := archaius.Edge(names.Service(name), service)
+		response, _ := time.ParseDuration(e.Response)
+		outmsg := gotocol.Message{imp, listener, clock.Now(), ctx.WithResponse(response), phase}
+		// if there's no instance or no response in time, fail the call via my own listener
+		fail := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, gotocol.Failure("timeout")}
+		if imp == gotocol.GetRequest {
+			inflight[outmsg.Ctx.String()] = call{t, p}
+		}
+		var ch chan gotocol.Message
+		if t.instances[p] != "" {
+			ch = microservices.Named(t.instances[p])
+		} else if ch = microservices.Select(func(n string) bool { return names.Service(n) == service }).Random(); ch != nil {
+			t.instances[p] = microservices.NameChan(ch)
+		}
+		if ch == nil {
+			if imp == gotocol.GetRequest {
+				fail.Intention = gotocol.Failure("unavailable")
+				fail.GoSend(listener)
+			}
+			return
+		}
+		latency, _ := time.ParseDuration(e.Latency)
+		latency += handlers.CrossZone(name, t.instances[p]) + archaius.Degraded(t.instances[p])
+		flow.AnnotateSend(outmsg, name)
+		flow.NotePhase(outmsg, name, fmt.Sprintf("%v %v/%v", phase, p+1, len(t.tx.Participants)))
+		outmsg.GoSendAfter(ch, latency)
+		if imp == gotocol.GetRequest {
+			clock.AfterFunc(timeout, func() { gotocol.Send(listener, fail) })
+		}
+	}
+	// finish a transaction and answer its caller, with the outcome if it didn't commit
+	finish := func(t *txn, outcome string) {
+		delete(active, t)
+		collect.MeasureService(names.Service(name), clock.Since(t.started), outcome != "committed")
+		if t.msg.Imposition != gotocol.GetRequest {
+			return
+		}
+		if outcome != "committed" {
+			outcome = gotocol.Failure(outcome)
+		}
+		outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), t.msg.Ctx, outcome}
+		flow.AnnotateSend(outmsg, name)
+		outmsg.GoRespond(t.msg.ResponseChan)
+	}
+	// abort a transaction that a participant voted no to, telling the others to roll back what they prepared
+	abort := func(t *txn, p int) {
+		for i := range t.tx.Participants {
+			if i != p {
+				send(t, i, "abort")
+			}
+		}
+		for k, c := range inflight { // votes still to come don't matter now
+			if c.t == t {
+				delete(inflight, k)
+			}
+		}
+		record(name, func(s *Stats) { s.Aborted++; s.AbortedBy[t.tx.Participants[p]]++ })
+		finish(t, "aborted")
+	}
+	start := func(msg gotocol.Message) {
+		if tc == nil {
+			return
+		}
+		tx := transaction(tc, msg.Intention)
+		if tx == nil {
+			if msg.Imposition == gotocol.GetRequest {
+				outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, gotocol.Failure("notransaction")}
+				flow.AnnotateSend(outmsg, name)
+				outmsg.GoRespond(msg.ResponseChan)
+			}
+			return
+		}
+		t := &txn{msg: msg, tx: tx, started: clock.Now(), instances: make([]string, len(tx.Participants)), attempts: make(map[int]int)}
+		active[t] = true
+		record(name, func(s *Stats) { s.Started++ })
+		for p := range tx.Participants {
+			send(t, p, "prepare")
+		}
+	}
+	for {
+		select {
+		case msg := <-listener:
+			flow.Instrument(msg, name, hist)
+			switch msg.Imposition {
+			case gotocol.Hello:
+				if name == "" {
+					// if I don't have a name yet remember what I've been named
+					parent = msg.ResponseChan // remember how to talk to my namer
+					name = msg.Intention      // message body is my name
+					microservices.Own(name)
+					hist = collect.NewHist(name)
+					if tc = archaius.Service(names.Service(name)).TwoPhase; tc != nil {
+						if t, err := time.ParseDuration(tc.Timeout); err == nil && t > 0 {
+							timeout = t
+						}
+						if tc.Retries > 0 {
+							retries = tc.Retries
+						}
+					}
+				}
+			case gotocol.Inform:
+				eureka[msg.Intention] = handlers.Inform(msg, name, listener)
+			case gotocol.NameDrop: // cross zone = true
+				handlers.NameDrop(&dependencies, microservices, msg, name, listener, eureka, true)
+			case gotocol.Forget:
+				// forget a buddy
+				handlers.Forget(&dependencies, microservices, msg)
+			case gotocol.GetRequest, gotocol.Put:
+				// start a transaction, a get is answered when it's committed or aborted
+				start(msg)
+			case gotocol.GetResponse:
+				// a vote or commit acknowledgement came back or failed, or the timeout came first
+				c, ok := inflight[msg.Ctx.String()]
+				if !ok {
+					break // already dealt with
+				}
+				delete(inflight, msg.Ctx.String())
+				t := c.t
+				if t.committing {
+					if !gotocol.Failed(msg.Intention) {
+						if t.acks++; t.acks == len(t.tx.Participants) {
+							now := clock.Now()
+							record(name, func(s *Stats) {
+								s.Committed++
+								s.prepare += t.prepared.Sub(t.started)
+								s.commit += now.Sub(t.prepared)
+								s.committed += now.Sub(t.started)
+							})
+							finish(t, "committed")
+						}
+					} else if t.attempts[c.p] > retries {
+						record(name, func(s *Stats) { s.Blocked++ })
+						if archaius.Conf.Msglog {
+							log.Printf("%v: transaction blocked, %v didn't commit after %v attempts, %v\n", name, t.instances[c.p], t.attempts[c.p], msg.Intention)
+						}
+						finish(t, "blocked")
+					} else {
+						t.attempts[c.p]++
+						record(name, func(s *Stats) { s.Commits++ })
+						send(t, c.p, "commit")
+					}
+					break
+				}
+				if gotocol.Failed(msg.Intention) || archaius.Rand(name).Float64() < t.tx.Aborts {
+					abort(t, c.p)
+					break
+				}
+				if t.votes++; t.votes < len(t.tx.Participants) {
+					break
+				}
+				// everyone voted yes, there's no going back now
+				t.prepared, t.committing, t.request = clock.Now(), true, gotocol.NewRequest()
+				for p := range t.tx.Participants {
+					t.attempts[p] = 1
+					record(name, func(s *Stats) { s.Commits++ })
+					send(t, p, "commit")
+				}
+			case gotocol.Goodbye:
+				// the participants that already voted yes are left waiting to hear the outcome
+				for t := range active {
+					if t.committing || t.votes > 0 {
+						record(name, func(s *Stats) { s.Blocked++ })
+					}
+				}
+				for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+					ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
+				}
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
+				return
+			}
+		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
+				}
+			}
+		}
+	}
+}
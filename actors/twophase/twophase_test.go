@@ -0,0 +1,143 @@
+package twophase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// participant is a fake instance of a participant service, the test answers the calls it gets
+type participant chan gotocol.Message
+
+// coordinator starts a coordinator named for a service with a transaction across the participant services, and tells it
+// about an instance of each
+func coordinator(t *testing.T, service string, participants ...string) (chan gotocol.Message, []participant) {
+	archaius.Conf.EurekaPoll = "1h"
+	archaius.SetService(service, archaius.ServiceConfig{TwoPhase: &archaius.TwoPhaseConfig{
+		Transactions: []archaius.TwoPhaseTransaction{{Participants: participants}}, Timeout: "20ms", Retries: 1}})
+	listener := make(chan gotocol.Message)
+	go Start(listener)
+	parent := make(chan gotocol.Message, 10)
+	listener <- gotocol.Message{gotocol.Hello, parent, time.Now(), gotocol.NilContext, names.Make("test", "us-east-1", "zoneA", service, "twophase", 0)}
+	var ps []participant
+	for i, p := range participants {
+		ch := make(participant, 10)
+		ps = append(ps, ch)
+		listener <- gotocol.Message{gotocol.NameDrop, ch, time.Now(), gotocol.NilContext, names.Make("test", "us-east-1", "zoneA", p, "store", i)}
+	}
+	return listener, ps
+}
+
+// call a participant got, with the phase in the body
+func (p participant) call(t *testing.T) gotocol.Message {
+	select {
+	case m := <-p:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("participant wasn't called")
+	}
+	return gotocol.Message{}
+}
+
+// answer a call to a participant
+func (p participant) answer(t *testing.T, phase, body string) {
+	m := p.call(t)
+	if m.Intention != phase {
+		t.Fatalf("participant got %v, not %v", m.Intention, phase)
+	}
+	m.ResponseChan <- gotocol.Message{gotocol.GetResponse, nil, time.Now(), m.Ctx, body}
+}
+
+// outcome the caller of a transaction is told
+func outcome(t *testing.T, client chan gotocol.Message) string {
+	select {
+	case m := <-client:
+		return m.Intention
+	case <-time.After(time.Second):
+		t.Fatal("transaction wasn't answered")
+	}
+	return ""
+}
+
+// statsOf a coordinator service so far
+func statsOf(service string) Stats {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	return *stats[service]
+}
+
+// begin a transaction, the caller gets the outcome on the returned channel
+func begin(listener chan gotocol.Message) chan gotocol.Message {
+	client := make(chan gotocol.Message, 1)
+	listener <- gotocol.Message{gotocol.GetRequest, client, time.Now(), gotocol.NewTrace(), "order"}
+	return client
+}
+
+// TestCommit checks a transaction commits once every participant has voted yes and acknowledged the commit
+func TestCommit(t *testing.T) {
+	listener, ps := coordinator(t, "commits", "stock", "payment")
+	client := begin(listener)
+	for _, p := range ps {
+		p.answer(t, "prepare", "yes")
+	}
+	for _, p := range ps {
+		p.answer(t, "commit", "ack")
+	}
+	if o := outcome(t, client); o != "committed" {
+		t.Errorf("outcome %v", o)
+	}
+	if s := statsOf("commits"); s.Committed != 1 || s.Commits != 2 || s.Aborted != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// TestAbort checks a no vote aborts the transaction, and the other participant is told to roll back
+func TestAbort(t *testing.T) {
+	listener, ps := coordinator(t, "aborts", "stock", "payment")
+	client := begin(listener)
+	ps[0].answer(t, "prepare", gotocol.Failure("no"))
+	if ps[1].call(t).Intention != "prepare" {
+		t.Fatal("second participant wasn't asked to prepare")
+	}
+	if m := ps[1].call(t); m.Intention != "abort" || m.Imposition != gotocol.Put {
+		t.Errorf("second participant got %v %v, not an abort", m.Imposition, m.Intention)
+	}
+	if o := outcome(t, client); o != gotocol.Failure("aborted") {
+		t.Errorf("outcome %v", o)
+	}
+	if s := statsOf("aborts"); s.Aborted != 1 || s.AbortedBy["stock"] != 1 || s.Committed != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// TestTimeout checks a participant that doesn't vote in time aborts the transaction, and one that doesn't acknowledge a
+// commit is retried then leaves it blocked
+func TestTimeout(t *testing.T) {
+	listener, ps := coordinator(t, "timeouts", "stock", "payment")
+	client := begin(listener)
+	ps[0].answer(t, "prepare", "yes")
+	ps[1].call(t) // and never votes
+	if o := outcome(t, client); o != gotocol.Failure("aborted") {
+		t.Errorf("outcome %v", o)
+	}
+	if s := statsOf("timeouts"); s.Aborted != 1 || s.AbortedBy["payment"] != 1 {
+		t.Errorf("stats %+v", s)
+	}
+	ps[0].call(t) // the abort
+	client = begin(listener)
+	for _, p := range ps {
+		p.answer(t, "prepare", "yes")
+	}
+	ps[0].answer(t, "commit", "ack")
+	ps[1].call(t) // never acknowledged
+	ps[1].call(t) // nor is the retry
+	if o := outcome(t, client); o != gotocol.Failure("blocked") {
+		t.Errorf("outcome %v", o)
+	}
+	if s := statsOf("timeouts"); s.Blocked != 1 || s.Commits != 3 {
+		t.Errorf("stats %+v", s)
+	}
+}
@@ -0,0 +1,196 @@
+// Package workqueue simulates an SQS style work queue
+// Producers enqueue work with Put or GetRequest, and each item is delivered to one of the consumers it depends on.
+// Items that fail, or aren't finished within the visibility timeout, are requeued until they run out of retries.
+package workqueue
+
+import (
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+	"log"
+	"sync"
+	"time"
+)
+
+// item of work waiting in the queue or being processed by a consumer
+type item struct {
+	ctx      gotocol.Context
+	body     string
+	enqueued time.Time
+	request  gotocol.TraceContextType // idempotency key, the same for every delivery
+	attempts int
+	consumer string // set while in flight
+}
+
+// Stats for a queue service, summed over its instances
+type Stats struct {
+	Enqueued    int `json:"enqueued"`
+	Processed   int `json:"processed"`
+	Retries     int `json:"retries"`
+	DeadLetters int `json:"deadletters"`
+	MaxDepth    int `json:"maxdepth"`
+	Depth       int `json:"depth"` // left in the queue at the end
+}
+
+var stats = make(map[string]Stats)
+var statsLock sync.Mutex
+
+// summarize adds the counts for an instance that is shutting down to the run summary
+func summarize(name string, s Stats) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	t := stats[names.Service(name)]
+	t.Enqueued += s.Enqueued
+	t.Processed += s.Processed
+	t.Retries += s.Retries
+	t.DeadLetters += s.DeadLetters
+	t.Depth += s.Depth
+	if s.MaxDepth > t.MaxDepth {
+		t.MaxDepth = s.MaxDepth
+	}
+	stats[names.Service(name)] = t
+	summary := make(map[string]Stats, len(stats))
+	for k, v := range stats {
+		summary[k] = v
+	}
+	collect.Summarize("queues", summary)
+}
+
+// Start workqueue, all configuration and state is sent via messages
+func Start(listener chan gotocol.Message) {
+	// remember the channel to talk to consumers
+	consumers := ribbon.MakeRouter()
+	dependencies := make(map[string]time.Time)                                    // dependent services and time last updated
+	var parent chan gotocol.Message                                               // remember how to talk back to creator
+	var name string                                                               // remember my name
+	eureka := make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)) // service registry per zone
+	hist := collect.NewHist("")
+	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
+	eurekaTicker := clock.NewTicker(ep)
+	var queue []*item                  // waiting to be delivered, oldest first
+	inflight := make(map[string]*item) // being processed, by span context
+	busy := make(map[string]int)       // items in flight for each consumer
+	visibility, retries, concurrency := time.Second, 3, 1
+	var s Stats
+	// deliver waiting items to any consumers that have spare capacity
+	dispatch := func() {
+		for _, c := range consumers.Names() {
+			for len(queue) > 0 && busy[c] < concurrency {
+				it := queue[0]
+				queue = queue[1:]
+				e := archaius.Edge(names.Service(name), names.Service(c))
+				latency, _ := time.ParseDuration(e.Latency)
+				latency += handlers.CrossZone(name, c) + archaius.Degraded(c)
+				response, _ := time.ParseDuration(e.Response)
+				outmsg := gotocol.Message{gotocol.GetRequest, listener, clock.Now(), it.ctx.NewParent().WithResponse(response).WithRequest(it.request), it.body}
+				it.consumer = c
+				it.attempts++
+				inflight[outmsg.Ctx.String()] = it
+				busy[c]++
+				flow.AnnotateSend(outmsg, name)
+				outmsg.GoSendAfter(consumers.Named(c), latency)
+				// if there's no response in time, make the item visible again by failing it via my own listener
+				ctx := outmsg.Ctx
+				clock.AfterFunc(visibility, func() {
+					gotocol.Send(listener, gotocol.Message{gotocol.GetResponse, listener, clock.Now(), ctx, gotocol.Failure("visibility")})
+				})
+			}
+		}
+		collect.SetGauge(name, int64(len(queue)))
+	}
+	enqueue := func(msg gotocol.Message) {
+		queue = append(queue, &item{ctx: msg.Ctx, body: msg.Intention, enqueued: clock.Now(), request: gotocol.NewRequest()})
+		s.Enqueued++
+		if len(queue) > s.MaxDepth {
+			s.MaxDepth = len(queue)
+		}
+		dispatch()
+	}
+	for {
+		select {
+		case msg := <-listener:
+			flow.Instrument(msg, name, hist)
+			switch msg.Imposition {
+			case gotocol.Hello:
+				if name == "" {
+					// if I don't have a name yet remember what I've been named
+					parent = msg.ResponseChan // remember how to talk to my namer
+					name = msg.Intention      // message body is my name
+					consumers.Own(name)
+					hist = collect.NewHist(name)
+					if q := archaius.Service(names.Service(name)).Queue; q != nil {
+						if v, err := time.ParseDuration(q.Visibility); err == nil && v > 0 {
+							visibility = v
+						}
+						if q.Retries > 0 {
+							retries = q.Retries
+						}
+						if q.Concurrency > 0 {
+							concurrency = q.Concurrency
+						}
+					}
+				}
+			case gotocol.Inform:
+				eureka[msg.Intention] = handlers.Inform(msg, name, listener)
+			case gotocol.NameDrop: // cross zone = true
+				handlers.NameDrop(&dependencies, consumers, msg, name, listener, eureka, true)
+				dispatch()
+			case gotocol.Forget:
+				// forget a buddy, anything it was processing will become visible again
+				handlers.Forget(&dependencies, consumers, msg)
+			case gotocol.GetRequest:
+				// enqueue and acknowledge, like an SQS send message
+				enqueue(msg)
+				outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, "queued"}
+				flow.AnnotateSend(outmsg, name)
+				outmsg.GoRespond(msg.ResponseChan)
+			case gotocol.Put:
+				// enqueue with no acknowledgement
+				enqueue(msg)
+			case gotocol.GetResponse:
+				// a consumer finished or failed an item, or the visibility timeout came first
+				it := inflight[msg.Ctx.String()]
+				if it == nil {
+					break // already dealt with
+				}
+				delete(inflight, msg.Ctx.String())
+				busy[it.consumer]--
+				if gotocol.Failed(msg.Intention) {
+					if it.attempts > retries {
+						s.DeadLetters++
+						if archaius.Conf.Msglog {
+							log.Printf("%v: dead letter %v after %v attempts, %v\n", name, it.body, it.attempts, msg.Intention)
+						}
+					} else {
+						s.Retries++
+						queue = append(queue, it)
+					}
+				} else {
+					s.Processed++
+					collect.MeasureService(names.Service(name), clock.Since(it.enqueued), false) // end to end latency
+				}
+				dispatch()
+			case gotocol.Goodbye:
+				s.Depth = len(queue) + len(inflight)
+				summarize(name, s)
+				collect.DeleteGauge(name)
+				for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+					ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
+				}
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
+				return
+			}
+		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
+				}
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ package zuul
 
 import (
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
@@ -22,7 +23,8 @@ func Start(listener chan gotocol.Message) {
 	eureka := make(map[string]chan gotocol.Message, 1) // service registry
 	hist := collect.NewHist("")
 	ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll)
-	eurekaTicker := time.NewTicker(ep)
+	eurekaTicker := clock.NewTicker(ep)
+	var gc <-chan time.Time // nil unless this service models garbage collection pauses
 	for {
 		select {
 		case msg := <-listener:
@@ -33,6 +35,8 @@ func Start(listener chan gotocol.Message) {
 					// if I don't have a name yet remember what I've been named
 					parent = msg.ResponseChan // remember how to talk to my namer
 					name = msg.Intention      // message body is my name
+					microservices.Own(name)
+					gc = handlers.GCStart(name)
 					hist = collect.NewHist(name)
 				}
 			case gotocol.Inform:
@@ -52,16 +56,18 @@ func Start(listener chan gotocol.Message) {
 				// route the request on to a random dependency
 				handlers.Put(msg, name, listener, &requestor, microservices)
 			case gotocol.Goodbye:
-				for _, ch := range eureka { // tell name service I'm not going to be here
-					ch <- gotocol.Message{gotocol.Delete, nil, time.Now(), gotocol.NilContext, name}
+				for _, ch := range handlers.Registries(eureka) { // tell name service I'm not going to be here
+					ch <- gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}
 				}
-				gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NilContext, name}.GoSend(parent)
+				gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NilContext, name}.GoSend(parent)
 				return
 			}
+		case <-gc: // stop the world
+			gc = handlers.GCPause(name)
 		case <-eurekaTicker.C: // check to see if any new dependencies have appeared
-			for dep := range dependencies {
-				for _, ch := range eureka {
-					ch <- gotocol.Message{gotocol.GetRequest, listener, time.Now(), gotocol.NilContext, dep}
+			for _, dep := range handlers.Dependencies(dependencies) {
+				for _, ch := range handlers.Registries(eureka) {
+					ch <- gotocol.Message{gotocol.GetRequest, listener, clock.Now(), gotocol.NilContext, dep}
 				}
 			}
 		}
@@ -0,0 +1,21 @@
+// utility to make up a large tiered architecture with plausible service names and write out an arch_json
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/adrianco/spigo/generate"
+)
+
+func main() {
+	sp := generate.DefaultSpec
+	flag.StringVar(&sp.Name, "a", sp.Name, "architecture name, output is written to json_arch/<arch>_arch.json")
+	flag.StringVar(&sp.Theme, "theme", sp.Theme, "vocabulary for the service names, one of "+strings.Join(generate.ThemeNames(), " "))
+	flag.Int64Var(&sp.Seed, "seed", sp.Seed, "random seed, the same seed gives the same names and dependencies")
+	flag.IntVar(&sp.Services, "services", sp.Services, "number of services")
+	flag.IntVar(&sp.Tiers, "tiers", sp.Tiers, "number of tiers including the edge and data tiers, at least 3")
+	flag.IntVar(&sp.Fanout, "fanout", sp.Fanout, "most dependencies for each service in the tier below")
+	flag.Parse()
+	sp.Arch()
+}
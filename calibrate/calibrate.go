@@ -0,0 +1,154 @@
+// Package calibrate runs one caller against one service with a known latency model, and checks the response times the simulator
+// measures against the distribution it was configured with, to show how far the numbers from other runs can be trusted
+package calibrate
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/adrianco/spigo/actors/packagenames" // name definitions
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/architecture"
+	"github.com/adrianco/spigo/tooling/collect"
+)
+
+// Spec is the calibration scenario, the caller sends a request every Rate and the edge to the service adds Latency to the request
+// and Response to the response, both varied by the jitter keyval. Measured percentiles within Tolerance of the model pass
+type Spec struct {
+	Rate      time.Duration
+	Latency   time.Duration
+	Response  time.Duration
+	Tolerance float64 // fraction of the expected percentile
+}
+
+// DefaultSpec is used for anything a spec leaves out
+var DefaultSpec = Spec{10 * time.Millisecond, 20 * time.Millisecond, 5 * time.Millisecond, 0.05}
+
+// Percentiles that are checked
+var Percentiles = []float64{0.5, 0.9, 0.99}
+
+// ParseSpec reads a comma separated key=value list such as rate=5ms,latency=50ms, the keys are rate, latency, response and tolerance
+func ParseSpec(s string) (Spec, error) {
+	sp := DefaultSpec
+	for _, kv := range strings.Split(s, ",") {
+		if kv == "" {
+			continue
+		}
+		f := strings.SplitN(kv, "=", 2)
+		if len(f) != 2 {
+			return sp, fmt.Errorf("%v should be key=value", kv)
+		}
+		var err error
+		var d time.Duration
+		switch f[0] {
+		case "rate", "latency", "response":
+			if d, err = time.ParseDuration(f[1]); err == nil && d < 0 {
+				err = fmt.Errorf("%v shouldn't be negative", f[0])
+			}
+			switch f[0] {
+			case "rate":
+				if d < time.Millisecond {
+					err = fmt.Errorf("rate should be at least 1ms")
+				}
+				sp.Rate = d
+			case "latency":
+				sp.Latency = d
+			case "response":
+				sp.Response = d
+			}
+		case "tolerance":
+			if sp.Tolerance, err = strconv.ParseFloat(f[1], 64); err == nil && (sp.Tolerance <= 0 || sp.Tolerance >= 1) {
+				err = fmt.Errorf("tolerance should be a fraction between 0 and 1")
+			}
+		default:
+			err = fmt.Errorf("unknown key %v", f[0])
+		}
+		if err != nil {
+			return sp, err
+		}
+	}
+	return sp, nil
+}
+
+// Arch writes the calibration architecture to json_arch/calibrate_arch.json, a denominator calling one karyon caller that calls
+// one store target over the calibrated edge, and sets the run up to collect the response times the caller sees
+func (sp Spec) Arch() {
+	a := architecture.MakeArch("calibrate", "one caller calling one service to calibrate the simulator")
+	architecture.AddContainer(a, "target", "", "", "", "", StorePkg, 1, 1, nil)
+	architecture.AddContainer(a, "caller", "", "", "", "", KaryonPkg, 1, 1, []string{"target"})
+	architecture.AddEdge(a, "caller", "target", archaius.EdgeConfig{Latency: sp.Latency.String(), Response: sp.Response.String()})
+	architecture.AddContainer(a, "client", "", "", "", "", DenominatorPkg, 0, 0, []string{"caller"})
+	architecture.WriteFile(a, "json_arch/calibrate_arch")
+	archaius.Conf.Arch = "calibrate"
+	archaius.Conf.Population = 100
+	archaius.Conf.Regions = 1
+	archaius.Conf.Collect = true
+	archaius.Conf.Keyvals = strings.TrimSuffix("chat:"+sp.Rate.String()+","+archaius.Conf.Keyvals, ",") // first match wins
+}
+
+// Expected percentiles of the configured model, each latency varied uniformly by up to the jitter fraction either way
+func (sp Spec) Expected(jitter float64) []time.Duration {
+	const n = 100000
+	r := rand.New(rand.NewSource(1))
+	vary := func(d time.Duration) float64 { return float64(d) * (1 + jitter*(2*r.Float64()-1)) }
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = vary(sp.Latency) + vary(sp.Response)
+	}
+	sort.Float64s(samples)
+	var e []time.Duration
+	for _, p := range Percentiles {
+		e = append(e, time.Duration(samples[int(p*(n-1))]))
+	}
+	return e
+}
+
+// Report prints the measured percentiles of the caller against the model, and whether they match. The simulator's own overhead
+// makes everything a little slower, if every percentile is off the same way by more than half the tolerance it's flagged as a bias
+func (sp Spec) Report() bool {
+	jitter, _ := strconv.ParseFloat(archaius.Key(archaius.Conf, "jitter"), 64)
+	fmt.Printf("calibrate: request every %v, latency %v, response %v, jitter %v, tolerance %v%%\n", sp.Rate, sp.Latency, sp.Response, jitter, sp.Tolerance*100)
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	ok := true
+	above, below := 0, 0
+	var bias time.Duration
+	for i, e := range sp.Expected(jitter) {
+		m, n := collect.ServiceQuantile("caller", Percentiles[i])
+		if n == 0 {
+			fmt.Println("calibrate: FAIL, no responses were measured")
+			return false
+		}
+		errf := 0.0
+		if e > 0 {
+			errf = float64(m-e) / float64(e)
+		} else if m > 0 {
+			errf = 1
+		}
+		result := "ok"
+		if errf > sp.Tolerance || errf < -sp.Tolerance {
+			result, ok = "FAIL", false
+		}
+		switch {
+		case errf > sp.Tolerance/2:
+			above++
+		case errf < -sp.Tolerance/2:
+			below++
+		}
+		bias += m - e
+		fmt.Printf("calibrate: p%v expected %.3fms measured %.3fms error %+.1f%% %v, from %v responses\n", Percentiles[i]*100, ms(e), ms(m), errf*100, result, n)
+	}
+	bias /= time.Duration(len(Percentiles))
+	if above == len(Percentiles) || below == len(Percentiles) {
+		fmt.Printf("calibrate: systematic bias, the measured percentiles are %+.3fms off on average\n", ms(bias))
+	}
+	if ok {
+		fmt.Println("calibrate: PASS, the measured percentiles match the configured model")
+	} else {
+		fmt.Println("calibrate: FAIL, the measured percentiles don't match the configured model")
+	}
+	return ok
+}
@@ -0,0 +1,34 @@
+package calibrate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	sp, err := ParseSpec("rate=5ms,latency=50ms")
+	if err != nil || sp.Rate != 5*time.Millisecond || sp.Latency != 50*time.Millisecond || sp.Response != DefaultSpec.Response || sp.Tolerance != DefaultSpec.Tolerance {
+		t.Fatal(sp, err)
+	}
+	if sp, err = ParseSpec(""); err != nil || sp != DefaultSpec {
+		t.Fatal(sp, err)
+	}
+	for _, bad := range []string{"rate", "rate=100us", "latency=-1ms", "response=x", "tolerance=0", "tolerance=1", "color=blue"} {
+		if _, err := ParseSpec(bad); err == nil {
+			t.Error("accepted " + bad)
+		}
+	}
+}
+
+func TestExpected(t *testing.T) {
+	sp := Spec{Latency: 20 * time.Millisecond, Response: 5 * time.Millisecond}
+	for _, e := range sp.Expected(0) {
+		if e != 25*time.Millisecond {
+			t.Fatal("without jitter every percentile should be the latency plus the response", e)
+		}
+	}
+	e := sp.Expected(0.1)
+	if e[0] < 24*time.Millisecond || e[0] > 26*time.Millisecond || e[2] <= e[0] || e[2] > 27500*time.Microsecond {
+		t.Fatal("jittered percentiles out of range", e)
+	}
+}
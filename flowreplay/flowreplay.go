@@ -0,0 +1,120 @@
+// utility to play back the zipkin spans in a flow file with their recorded timing, faster or slower, to stdout or a zipkin collector
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// annotation and span are the parts of a spigo zipkin flow that replay needs to look at, the rest is passed through
+type annotation struct {
+	Endpoint  json.RawMessage `json:"endpoint"`
+	Timestamp int64           `json:"timestamp"` // microseconds
+	Value     string          `json:"value"`
+}
+
+type span struct {
+	Traceid           string          `json:"traceId"`
+	Name              string          `json:"name"`
+	Id                string          `json:"id"`
+	ParentId          string          `json:"parentId,omitempty"`
+	Annotations       []annotation    `json:"annotations"`
+	BinaryAnnotations json.RawMessage `json:"binaryAnnotations,omitempty"`
+}
+
+// start of a span is its earliest annotation
+func (s *span) start() int64 {
+	var t int64
+	for i, a := range s.Annotations {
+		if i == 0 || a.Timestamp < t {
+			t = a.Timestamp
+		}
+	}
+	return t
+}
+
+type byStart []*span
+
+func (a byStart) Len() int           { return len(a) }
+func (a byStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool { return a[i].start() < a[j].start() }
+
+func main() {
+	var arch, fn, zipkin string
+	var speed float64
+	var live bool
+	flag.StringVar(&arch, "a", "", "architecture name, reads json_metrics/<arch>_flow.json")
+	flag.StringVar(&fn, "file", "", "flow file to replay, instead of -a")
+	flag.Float64Var(&speed, "speed", 1, "multiplier for the replay rate, 2 is twice as fast, 0.5 half speed, 0 replays everything at once")
+	flag.StringVar(&zipkin, "zipkin", "", "zipkin collector to post each span to, e.g. http://localhost:9411, default writes the spans to stdout")
+	flag.BoolVar(&live, "live", false, "move the timestamps to the time of the replay, zipkin only accepts spans less than a day old")
+	flag.Parse()
+	if fn == "" && arch != "" {
+		fn = "json_metrics/" + arch + "_flow.json"
+	}
+	if fn == "" || speed < 0 {
+		flag.PrintDefaults()
+		return
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var spans []*span
+	if err := json.Unmarshal(data, &spans); err != nil {
+		log.Fatal(err)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	sort.Stable(byStart(spans))
+	first := spans[0].start()
+	began := time.Now()
+	for _, s := range spans {
+		offset := time.Duration(s.start()-first) * time.Microsecond
+		if speed > 0 {
+			offset = time.Duration(float64(offset) / speed)
+			if wait := offset - time.Since(began); wait > 0 {
+				time.Sleep(wait) // relative to the start so rounding doesn't add up
+			}
+		}
+		if live {
+			shift := began.Add(offset).UnixNano()/1000 - s.start()
+			for i := range s.Annotations {
+				s.Annotations[i].Timestamp += shift
+			}
+		}
+		send(s, zipkin)
+	}
+	log.Printf("flowreplay: %v spans from %v in %v\n", len(spans), fn, time.Since(began))
+}
+
+// send a span as a line of json on stdout, or post it to zipkin
+func send(s *span, zipkin string) {
+	j, err := json.Marshal([]*span{s})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if zipkin == "" {
+		os.Stdout.Write(j[1 : len(j)-1])
+		fmt.Println()
+		return
+	}
+	resp, err := http.Post(zipkin+"/api/v1/spans", "application/json", bytes.NewReader(j))
+	if err != nil {
+		log.Println("flowreplay:", err) // keep going, the collector may come back
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("flowreplay: %v from %v for span %v\n", resp.Status, zipkin, s.Id)
+	}
+}
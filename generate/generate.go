@@ -0,0 +1,280 @@
+// Package generate makes up large tiered architectures with plausible service names, so the graphs look real in demos.
+// The same seed always gives the same architecture
+package generate
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/adrianco/spigo/actors/packagenames" // name definitions
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/architecture"
+)
+
+// Theme is the vocabulary names are made from, a domain word is combined with a role word for the tier of the service
+type Theme struct {
+	Domains []string
+	Edge    []string // roles of the services that take traffic from outside
+	Mid     []string // roles of business logic services
+	Data    []string // roles of stores and caches
+	Teams   []string
+}
+
+// Themes are the built in vocabularies, netflixoss like the services in the netflixoss architecture and fsm for the pirates
+var Themes = map[string]Theme{
+	"netflixoss": {
+		Domains: []string{"subscriber", "playback", "ratings", "catalog", "billing", "member", "device", "search", "recommendation",
+			"artwork", "license", "bookmark", "viewing", "profile", "session", "payment", "signup", "country", "genre", "trailer",
+			"encoding", "drm", "metadata", "homepage", "row", "evidence", "cdn", "merch", "gps", "language"},
+		Edge:  []string{"api", "proxy", "gateway", "edge"},
+		Mid:   []string{"service", "manager", "engine", "tracker", "resolver", "lookup", "aggregator", "personalizer"},
+		Data:  []string{"store", "cache", "db", "index", "history"},
+		Teams: []string{"edge", "playback", "growth", "personalization", "platform", "commerce", "studio", "devices"},
+	},
+	"fsm": {
+		Domains: []string{"parrot", "treasure", "plank", "cutlass", "galleon", "rum", "compass", "kraken", "doubloon", "spyglass",
+			"bosun", "cannon", "lagoon", "reef", "anchor", "crowsnest", "barrel", "hook", "cove", "bounty", "brig", "mutiny",
+			"lantern", "sextant", "tide", "gangplank", "chest", "rigging", "keel", "mast"},
+		Edge:  []string{"lookout", "harbor", "gangway", "port"},
+		Mid:   []string{"crew", "captain", "quartermaster", "navigator", "gunner", "lookup", "plunderer", "trader"},
+		Data:  []string{"hold", "chest", "log", "map", "vault"},
+		Teams: []string{"blackbeard", "calico", "kidd", "morgan", "bonny", "read", "drake", "flint"},
+	},
+}
+
+// ThemeNames lists the built in themes for help and error messages
+func ThemeNames() []string {
+	var tn []string
+	for n := range Themes {
+		tn = append(tn, n)
+	}
+	sort.Strings(tn)
+	return tn
+}
+
+// Namer makes unique names from a theme, in the same order for the same seed
+type Namer struct {
+	theme Theme
+	r     *rand.Rand
+	used  map[string]bool
+}
+
+// NewNamer starts a sequence of names, it fails if the theme isn't known
+func NewNamer(theme string, seed int64) *Namer {
+	t, ok := Themes[theme]
+	if !ok {
+		log.Fatal("generate: unknown theme " + theme)
+	}
+	return &Namer{t, rand.New(rand.NewSource(seed)), make(map[string]bool)}
+}
+
+func (n *Namer) pick(words []string) string {
+	return words[n.r.Intn(len(words))]
+}
+
+// Service makes a name for a service in a tier, edge, mid or data, that hasn't been used yet
+func (n *Namer) Service(tier string) string {
+	roles := n.theme.Mid
+	switch tier {
+	case "edge":
+		roles = n.theme.Edge
+	case "data":
+		roles = n.theme.Data
+	}
+	var name string
+	for tries := 0; tries < 20; tries++ {
+		name = n.pick(n.theme.Domains) + "-" + n.pick(roles)
+		if !n.used[name] {
+			n.used[name] = true
+			return name
+		}
+	}
+	for i := 2; n.used[name]; i++ { // the vocabulary is running out, so number them
+		name = fmt.Sprintf("%v-%v", name, i)
+	}
+	n.used[name] = true
+	return name
+}
+
+// Team picks an owning team to tag a service with
+func (n *Namer) Team() string {
+	return n.pick(n.theme.Teams)
+}
+
+// Arch makes up a tiered architecture of services, an edge tier behind an elb, tiers of business logic, and a data tier.
+// Each service calls up to fanout services in the tier below, and every service below the edge is called by at least one.
+// It's written to json_arch/<name>_arch.json
+func Arch(name, theme string, seed int64, services, tiers, fanout int) {
+	n := NewNamer(theme, seed)
+	if tiers < 3 {
+		tiers = 3
+	}
+	if services < tiers {
+		services = tiers
+	}
+	if fanout < 1 {
+		fanout = 1
+	}
+	// edge and data tiers get a share of the services each, the rest are spread over the mid tiers
+	edge := services / 10
+	if edge < 1 {
+		edge = 1
+	}
+	data := services / 4
+	if data < 1 {
+		data = 1
+	}
+	mid := services - edge - data
+	if mid < tiers-2 {
+		mid = tiers - 2
+	}
+	layers := [][]string{make([]string, edge)}
+	for i := range layers[0] {
+		layers[0][i] = n.Service("edge")
+	}
+	for t := 0; t < tiers-2; t++ {
+		count := mid / (tiers - 2)
+		if t < mid%(tiers-2) {
+			count++
+		}
+		layer := make([]string, count)
+		for i := range layer {
+			layer[i] = n.Service("mid")
+		}
+		layers = append(layers, layer)
+	}
+	last := make([]string, data)
+	for i := range last {
+		last[i] = n.Service("data")
+	}
+	layers = append(layers, last)
+	// wire each tier to the one below, first making sure everything below is called by something
+	deps := make(map[string][]string)
+	for t := 0; t < len(layers)-1; t++ {
+		callers, callees := layers[t], layers[t+1]
+		for i, c := range callees {
+			caller := callers[i%len(callers)]
+			if i >= len(callers) {
+				caller = callers[n.r.Intn(len(callers))]
+			}
+			deps[caller] = append(deps[caller], c)
+		}
+		for _, caller := range callers {
+			want := 1 + n.r.Intn(fanout)
+			for _, i := range n.r.Perm(len(callees)) {
+				if len(deps[caller]) >= want {
+					break
+				}
+				if !contains(deps[caller], callees[i]) {
+					deps[caller] = append(deps[caller], callees[i])
+				}
+			}
+			sort.Strings(deps[caller])
+		}
+	}
+	a := architecture.MakeArch(name, fmt.Sprintf("generated %v architecture of %v services, seed %v", theme, services, seed))
+	zones := len(archaius.Conf.ZoneNames)
+	for t := len(layers) - 1; t >= 0; t-- { // dependencies have to be created before the services that call them
+		tier := "mid"
+		switch t {
+		case 0:
+			tier = "edge"
+		case len(layers) - 1:
+			tier = "data"
+		}
+		for _, s := range layers[t] {
+			pack := KaryonPkg
+			count := zones * (1 + n.r.Intn(3))
+			switch tier {
+			case "edge":
+				pack = ZuulPkg
+			case "data":
+				pack = StorePkg
+				count = zones
+			}
+			architecture.AddContainer(a, s, "", "", "", "", pack, 1, count, deps[s])
+			architecture.Tag(a, s, map[string]string{"tier": tier, "team": n.Team()})
+		}
+	}
+	architecture.AddContainer(a, "elb", "", "", "", "", ElbPkg, 1, 0, layers[0])
+	architecture.AddContainer(a, "www", "", "", "", "", DenominatorPkg, 0, 0, []string{"elb"})
+	architecture.WriteFile(a, "json_arch/"+name+"_arch")
+	log.Printf("generate: %v services in %v tiers written to json_arch/%v_arch.json\n", len(a.Services), len(layers), name)
+}
+
+// Spec is the size and shape of an architecture to generate
+type Spec struct {
+	Name     string
+	Theme    string
+	Seed     int64
+	Services int
+	Tiers    int
+	Fanout   int
+}
+
+// DefaultSpec is used for anything a spec leaves out
+var DefaultSpec = Spec{"generated", "netflixoss", 1, 30, 4, 3}
+
+// ParseSpec reads a comma separated key=value list such as services=500,fanout=4,tiers=3, the keys are name, theme, seed,
+// services, tiers and fanout
+func ParseSpec(s string) (Spec, error) {
+	sp := DefaultSpec
+	for _, kv := range strings.Split(s, ",") {
+		if kv == "" {
+			continue
+		}
+		f := strings.SplitN(kv, "=", 2)
+		if len(f) != 2 {
+			return sp, fmt.Errorf("%v should be key=value", kv)
+		}
+		var err error
+		var n int
+		switch f[0] {
+		case "name":
+			sp.Name = f[1]
+		case "theme":
+			if _, ok := Themes[f[1]]; !ok {
+				err = fmt.Errorf("theme should be one of %v", strings.Join(ThemeNames(), " "))
+			}
+			sp.Theme = f[1]
+		case "seed":
+			sp.Seed, err = strconv.ParseInt(f[1], 10, 64)
+		case "services", "tiers", "fanout":
+			if n, err = strconv.Atoi(f[1]); err == nil && n < 1 {
+				err = fmt.Errorf("%v should be at least 1", f[0])
+			}
+			switch f[0] {
+			case "services":
+				sp.Services = n
+			case "tiers":
+				sp.Tiers = n
+			case "fanout":
+				sp.Fanout = n
+			}
+		default:
+			err = fmt.Errorf("unknown key %v", f[0])
+		}
+		if err != nil {
+			return sp, err
+		}
+	}
+	return sp, nil
+}
+
+// Arch generates the architecture for the spec
+func (sp Spec) Arch() {
+	Arch(sp.Name, sp.Theme, sp.Seed, sp.Services, sp.Tiers, sp.Fanout)
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
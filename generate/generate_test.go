@@ -0,0 +1,41 @@
+package generate
+
+import (
+	"fmt"
+	"testing"
+)
+
+// the same seed gives the same names, and names aren't reused even when the vocabulary runs out
+func TestNamer(t *testing.T) {
+	for _, theme := range ThemeNames() {
+		n1, n2 := NewNamer(theme, 42), NewNamer(theme, 42)
+		used := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			a, b := n1.Service("mid"), n2.Service("mid")
+			if i < 5 {
+				fmt.Println(theme, a, n1.Team())
+				n2.Team()
+			}
+			if a != b || used[a] {
+				t.Fatal(theme, i, a, b)
+			}
+			used[a] = true
+		}
+	}
+}
+
+// specs fill in defaults for the keys they leave out, and reject keys and values that don't make sense
+func TestParseSpec(t *testing.T) {
+	sp, err := ParseSpec("services=500,fanout=4,tiers=3")
+	if err != nil || sp.Services != 500 || sp.Fanout != 4 || sp.Tiers != 3 || sp.Name != DefaultSpec.Name || sp.Seed != DefaultSpec.Seed {
+		t.Fatal(sp, err)
+	}
+	if sp, err = ParseSpec("name=big,theme=fsm,seed=7"); err != nil || sp.Name != "big" || sp.Theme != "fsm" || sp.Seed != 7 {
+		t.Fatal(sp, err)
+	}
+	for _, bad := range []string{"services", "services=0", "tiers=x", "color=blue", "theme=klingon"} {
+		if _, err := ParseSpec(bad); err == nil {
+			t.Error("accepted " + bad)
+		}
+	}
+}
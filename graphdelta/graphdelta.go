@@ -0,0 +1,61 @@
+// utility to diff two stepped graphjson snapshots and write out the nodes and edges that were added and removed
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/adrianco/spigo/tooling/graphjson"
+)
+
+func main() {
+	var arch, from, to, out string
+	var step1, step2 int
+	flag.StringVar(&arch, "a", "", "architecture name, reads json/<arch><step>.json for the two steps")
+	flag.IntVar(&step1, "from", 0, "step to diff from, 0 for json/<arch>.json")
+	flag.IntVar(&step2, "to", 0, "step to diff to")
+	flag.StringVar(&from, "old", "", "graphjson file to diff from, instead of -a and -from")
+	flag.StringVar(&to, "new", "", "graphjson file to diff to, instead of -a and -to")
+	flag.StringVar(&out, "o", "", "output file for the delta json, default stdout")
+	flag.Parse()
+	if arch != "" {
+		if from == "" {
+			from = stepFile(arch, step1)
+		}
+		if to == "" {
+			to = stepFile(arch, step2)
+		}
+	}
+	if from == "" || to == "" || from == to {
+		flag.PrintDefaults()
+		return
+	}
+	d := graphjson.Delta(graphjson.ReadGraph(from), graphjson.ReadGraph(to))
+	j, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	w.Write(j)
+	w.WriteString("\n")
+	log.Printf("%v: added %v nodes %v edges, removed %v nodes %v edges\n", to, len(d.Added.Nodes), len(d.Added.Edges), len(d.Removed.Nodes), len(d.Removed.Edges))
+}
+
+// stepFile names the file that spigo -s writes for a step
+func stepFile(arch string, step int) string {
+	if step == 0 {
+		return "json/" + arch + ".json"
+	}
+	return fmt.Sprintf("json/%v%v.json", arch, step)
+}
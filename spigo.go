@@ -3,27 +3,76 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/adrianco/spigo/actors/edda"          // log configuration state
+	"github.com/adrianco/spigo/calibrate"            // check the simulator against a known latency model
+	"github.com/adrianco/spigo/generate"             // make up large architectures
 	"github.com/adrianco/spigo/tooling/archaius"     // store the config for global lookup
 	"github.com/adrianco/spigo/tooling/architecture" // run an architecture from a json definition
 	"github.com/adrianco/spigo/tooling/asgard"       // tools to create an architecture
+	"github.com/adrianco/spigo/tooling/checkpoint"   // save and resume long runs
+	"github.com/adrianco/spigo/tooling/clock"        // virtual time for fast repeatable runs
 	"github.com/adrianco/spigo/tooling/collect"      // metrics to extvar
+	"github.com/adrianco/spigo/tooling/convert"      // convert graphs between formats offline
 	"github.com/adrianco/spigo/tooling/flow"         // flow logging
 	"github.com/adrianco/spigo/tooling/fsm"          // fsm and pirates
 	"github.com/adrianco/spigo/tooling/gotocol"      // message protocol spec
+	"github.com/adrianco/spigo/tooling/graphjson"    // graph json field naming profiles
 	"github.com/adrianco/spigo/tooling/migration"    // migration from LAMP to netflixoss
+	"github.com/adrianco/spigo/traces"               // architectures from trace exports
 )
 
-var addrs string
-var reload, graphmlEnabled, graphjsonEnabled, neo4jEnabled bool
+// labels collects repeated -label key=value flags
+type labels []string
+
+func (l *labels) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *labels) Set(kv string) error {
+	if !strings.Contains(kv, "=") {
+		return fmt.Errorf("label %q should be key=value", kv)
+	}
+	*l = append(*l, kv)
+	return nil
+}
+
+// calibration is the -calibrate spec, the flag can be given on its own to calibrate with the defaults
+type calibration struct {
+	on   bool
+	spec string
+}
+
+func (c *calibration) String() string {
+	return c.spec
+}
+
+func (c *calibration) Set(s string) error {
+	c.on = true
+	if s != "true" {
+		c.spec = s
+	}
+	return nil
+}
+
+func (c *calibration) IsBoolFlag() bool {
+	return true
+}
+
+var addrs, impactService, listen string
+var reload, graphmlEnabled, graphjsonEnabled, gexfEnabled, dotEnabled, htmlEnabled, neo4jEnabled, noedda, topologyEnabled, streamEnabled, terraformEnabled, riskEnabled bool
 var duration, cpucount int
 
 // main handles command line flags and starts up an architecture
@@ -31,23 +80,83 @@ func main() {
 	flag.StringVar(&archaius.Conf.Arch, "a", "netflixoss", "Architecture to create or read, fsm, migration, or read from json_arch/<arch>_arch.json")
 	flag.IntVar(&archaius.Conf.Population, "p", 100, "Pirate population for fsm or scale factor % for other architectures")
 	flag.IntVar(&duration, "d", 10, "Simulation duration in seconds")
+	flag.BoolVar(&archaius.Conf.Forever, "forever", false, "Run until interrupted instead of for -d seconds, keeping the last minute of flows if Collect is enabled")
 	flag.IntVar(&archaius.Conf.Regions, "w", 1, "Wide area regions to replicate architecture into, defaults based on 6 AWS region names")
 	flag.BoolVar(&graphmlEnabled, "g", false, "Enable GraphML logging of nodes and edges to gml/<arch>.graphml")
+	flag.BoolVar(&gexfEnabled, "gexf", false, "Enable GEXF logging of nodes and edges for Gephi to gexf/<arch>.gexf, with call counts if Collect is enabled")
+	flag.BoolVar(&dotEnabled, "dot", false, "Enable GraphViz logging of nodes and edges to dot/<arch>.dot")
+	flag.BoolVar(&htmlEnabled, "html", false, "Write a standalone page to explore the graph of nodes and edges in a browser to json/<arch>.html, with call counts if Collect is enabled")
 	flag.BoolVar(&graphjsonEnabled, "j", false, "Enable GraphJSON logging of nodes and edges to json/<arch>.json")
 	flag.BoolVar(&neo4jEnabled, "n", false, "Enable Neo4j logging of nodes and edges")
-	flag.BoolVar(&archaius.Conf.Msglog, "m", false, "Enable console logging of every message")
-	flag.BoolVar(&reload, "r", false, "Reload graph from json/<arch>.json to setup architecture")
+	flag.BoolVar(&archaius.Conf.SplitRegions, "splitregions", false, "Also write the GraphJSON of each region to json/<arch>_<region>.json, with stubs for the nodes in other regions it has edges to, implies -j")
+	flag.BoolVar(&archaius.Conf.Gzip, "gzip", false, "Compress GraphJSON and GraphML output to json/<arch>.json.gz and gml/<arch>.graphml.gz")
+	flag.StringVar(&archaius.Conf.JSONProfile, "jsonprofile", "legacy", "Field names for GraphJSON nodes and edges to suit a visualization tool, one of "+strings.Join(graphjson.ProfileNames(), " "))
+	flag.BoolVar(&noedda, "noedda", false, "Disable edda and all graph logging for minimal overhead throughput runs")
+	flag.BoolVar(&topologyEnabled, "t", false, "Serve the current topology as json via http: /topology")
+	flag.BoolVar(&streamEnabled, "stream", false, "Stream the nodes and edges as they're added and removed, and annotations such as chaos kills and autoscaling, as json over a websocket at ws://<listen>/graph")
+	flag.BoolVar(&archaius.Conf.Msglog, "m", false, "Enable console logging of every message, or a sample with -kv msglogsample:0.01 and one service with msglogservice:<name>")
+	flag.BoolVar(&reload, "r", false, "Reload graph from json/<arch>.json or json/<arch>.json.gz to setup architecture")
 	flag.BoolVar(&archaius.Conf.Collect, "c", false, "Collect metrics and flows to json_metrics csv_metrics neo4j and via http: extvars")
+	flag.StringVar(&listen, "listen", "localhost:8123", "Address for the web server of -c, -t and -stream, with /metrics for Prometheus, use :8123 to be scraped from other hosts")
 	flag.StringVar(&addrs, "k", "", "Send Zipkin spans to Kafka if Collect is enabled. Provide list of comma separated host:port addresses")
+	flag.StringVar(&archaius.Conf.OTLP, "otlp", "", "Export the flows as OpenTelemetry spans if Collect is enabled, to a gRPC host:port such as localhost:4317 or an OTLP/HTTP url such as http://localhost:4318")
+	flag.StringVar(&archaius.Conf.Zipkin, "zipkin", "", "Post batches of Zipkin spans to a collector at this url if Collect is enabled, e.g. http://localhost:9411/api/v1/spans")
 	flag.IntVar(&archaius.Conf.StopStep, "s", 0, "Sequence number to create multiple runs for ui to step through in json/<arch><s>.json")
 	flag.StringVar(&archaius.Conf.EurekaPoll, "u", "1s", "Polling interval for Eureka name service, increase for large populations")
-	flag.StringVar(&archaius.Conf.Keyvals, "kv", "", "Configuration key:value - chat:10ms sets default message insert rate")
+	flag.Int64Var(&archaius.Conf.Seed, "seed", 0, "Seed for the random choices of the run so it can be repeated byte for byte on the virtual clock, 0 seeds from the clock, overrides -kv seed")
+	flag.BoolVar(&archaius.Conf.Virtual, "virtual", false, "Run on a virtual clock that skips to the next timer or message delay as soon as the actors are idle, so -d is simulated time and runs faster than real time")
+	flag.StringVar(&archaius.Conf.Keyvals, "kv", "", "Configuration comma separated key:value list - chat:10ms sets default message insert rate, edge.<from>-><to>.latency:200ms overrides an edge")
 	flag.BoolVar(&archaius.Conf.Filter, "f", false, "Filter output names to simplify graph by collapsing instances to services")
+	flag.StringVar(&archaius.Conf.RunName, "runname", "", "Name for this run, recorded in the summary and graph outputs")
+	flag.Var((*labels)(&archaius.Conf.Labels), "label", "Label key=value recorded in the summary and graph outputs, may be repeated")
+	flag.StringVar(&archaius.Conf.Sequence, "sequence", "", "Write a trace id, or random trace, as a PlantUML sequence diagram to json_metrics/<arch>_trace<id>.puml if Collect is enabled")
+	flag.BoolVar(&archaius.Conf.CallMatrix, "callmatrix", false, "Write caller by callee service call counts to json_metrics/<arch>_matrix.csv if Collect is enabled")
+	flag.BoolVar(&archaius.Conf.Backstage, "backstage", false, "Write the services and the services they call as Backstage catalog entities to json/<arch>_catalog-info.yaml")
+	flag.BoolVar(&archaius.Conf.Animate, "animate", false, "Write the graph and the calls over each edge in time order to json/<arch>_animate.json for playback if Collect is enabled")
+	flag.BoolVar(&archaius.Conf.Hdr, "hdr", false, "Write service response times as HdrHistograms to csv_metrics/<arch>_<service>.hgrm and <arch>.hlog if Collect is enabled")
+	flag.StringVar(&archaius.Conf.Metrics, "metrics", "file", "Write histograms and the summary to file in csv_metrics and json_metrics, stdout as InfluxDB line protocol, or an InfluxDB write url such as http://localhost:8086/write?db=spigo")
+	flag.StringVar(&archaius.Conf.SQLite, "sqlite", "", "Write the flows, histograms, summary and events as tables of a SQLite database file if Collect is enabled")
+	flag.BoolVar(&archaius.Conf.ChromeTrace, "chrometrace", false, "Write flows in Chrome trace_event format to traces/<arch>_chrome.json if Collect is enabled")
+	flag.BoolVar(&archaius.Conf.CriticalPath, "criticalpath", false, "Write the critical path of each trace and the latency each service contributed to json_metrics/<arch>_critical.json if Collect is enabled")
+	flag.BoolVar(&archaius.Conf.Flame, "flame", false, "Write the latency of the calls from each entry point over all traces as folded stacks to traces/<arch>_flame.folded if Collect is enabled")
+	flag.BoolVar(&archaius.Conf.LatencyBreakdown, "latencybreakdown", false, "Write the latency each service contributed to the mean and p99 of each entry point to json_metrics/<arch>_breakdown.json if Collect is enabled")
+	flag.StringVar(&archaius.Conf.TraceIDs, "traceids", "zipkin", "Span id format for the flows, zipkin or w3c to use W3C Trace Context traceparent ids")
+	flag.StringVar(&archaius.Conf.TagFilter, "tagfilter", "", "Only write nodes from services with a key=value tag, and the edges between them, to the graphs")
+	flag.BoolVar(&archaius.Conf.TagNeighbors, "tagneighbors", false, "With -tagfilter also write nodes directly connected to matching nodes")
+	flag.StringVar(&archaius.Conf.Chaos, "chaos", "", "Run the chaos monkey every interval, e.g. 500ms, terminating a random instance of each service, also set by -kv chaos:500ms")
+	flag.StringVar(&archaius.Conf.Checkpoint, "checkpoint", "", "Save the instance set and summary so far every interval, e.g. 10m, to json_metrics/<arch>_checkpoint.json")
+	flag.BoolVar(&archaius.Conf.Cycles, "cycles", false, "Allow dependency cycles between services that pass requests on, calls are limited by -maxhops")
+	flag.StringVar(&archaius.Conf.Invariants, "invariants", "", "Fail the run, listing each violation, if the architecture expanded to instances breaks a rule in the json invariants file")
+	flag.StringVar(&archaius.Conf.RequestFile, "requestfile", "", "Send the requests listed in a file, each at its time after the traffic starts to an entry point with any key and baggage, instead of at the chat rate")
+	flag.StringVar(&archaius.Conf.TargetUtil, "targetutil", "", "Adjust the request rate to hold a service at a utilization of its concurrency, e.g. subscriber=0.7, and record the rate found in the summary")
+	flag.StringVar(&archaius.Conf.TimeSeries, "timeseries", "", "Write the requests, failures and a latency heatmap of each service in time buckets of this width, e.g. 1s, to csv_metrics/<arch>_timeseries.csv if Collect is enabled")
+	flag.StringVar(&archaius.Conf.BucketAlign, "bucketalign", "runstart", "Align the -timeseries buckets to multiples of the width from the runstart or on the wallclock, so runs can be overlaid")
+	flag.StringVar(&archaius.Conf.BucketOrigin, "bucketorigin", "", "Move the -timeseries bucket boundaries this far on from the -bucketalign, e.g. 500ms")
+	flag.IntVar(&archaius.Conf.MaxHops, "maxhops", 32, "Fail calls more than this many hops from the start of a request, 0 for no limit")
+	flag.IntVar(&archaius.Conf.MaxFlowMem, "maxflowmem", 0, "Cap the MB of raw flows kept in memory if Collect is enabled, dropping the oldest traces, 0 for no limit")
+	var eventLog = flag.String("eventlog", "", "Write every message sent and received, with logical timestamps, a line at a time to a file that can be diffed between runs")
+	var resumeFile = flag.String("resume", "", "Resume a run from a checkpoint file, with -d as the total duration including the time already run")
 	flag.IntVar(&cpucount, "cpus", runtime.NumCPU(), "Number of CPUs for Go runtime")
 	runtime.GOMAXPROCS(cpucount)
 	var cpuprofile = flag.String("cpuprofile", "", "Write cpu profile to file")
+	var memprofile = flag.String("memprofile", "", "Write heap profile to file at shutdown")
+	var bundleFile = flag.String("bundle", "", "Package the manifest, the effective config, the architecture definition and every file the run produced into a zip file such as run.zip, to share or rerun the experiment")
+	var manifestFile = flag.String("manifest", "", "Write a manifest of the files the run produced, with the path, format, size and a description of each, and the run metadata and config, to a json file such as manifest.json")
 	var confFile = flag.String("config", "", "Config file to read from json_arch/<config>_conf.json. This config overrides any other command-line arguments.")
-	var saveConfFile = flag.Bool("saveconfig", false, "Save config file to json_arch/<arch>_conf.json, using the arch name from -a.")
+	var generateSpec = flag.String("generate", "", "Generate a tiered architecture such as services=500,fanout=4,tiers=3 to json_arch/<name>_arch.json and run it, or just write it with -d 0")
+	var importFile = flag.String("import", "", "Import a Zipkin (v1 or v2) or Jaeger json trace export as json_arch/<arch>_arch.json, named after the file unless -a is given, and run it, or just write it with -d 0")
+	var cal calibration
+	flag.Var(&cal, "calibrate", "Run one caller against one service with a known latency model and check the measured percentiles, optionally set as rate=10ms,latency=20ms,response=5ms,tolerance=0.05")
+	var modelFile = flag.String("model", "", "Load the architecture, regions, population and keyvals from a model file written by -savemodel, or an architecture file")
+	var saveModel = flag.Bool("savemodel", false, "Save the complete architecture model, its services, config and instances, to json_arch/<arch>_model.json")
+	var checkFiles = flag.Bool("checkfiles", false, "Check that every file the run reads and directory it writes to is there before starting, and list all the missing ones")
+	flag.BoolVar(&terraformEnabled, "terraform", false, "Write a skeleton of Terraform resources for the services, instance counts and regions of the architecture to json/<arch>.tf")
+	flag.StringVar(&impactService, "impact", "", "Write what would break if the service disappeared, measured from the last run's flows if there are any, to json/<arch>_impact.json")
+	flag.BoolVar(&riskEnabled, "riskreport", false, "Write the single points of failure, dependencies without a fallback and services whose failure disconnects the most of the architecture to json/<arch>_risk.json")
+	var convertFile = flag.String("convert", "", "Convert a graph file written with -j, -g or -gexf to the -to format, without running a simulation")
+	var convertTo = flag.String("to", "", "Format for -convert to write, one of graphjson graphml gexf")
+	var convertOut = flag.String("o", "", "Output file for -convert, default the input file with the extension of the -to format")
+	var saveConfFile = flag.Bool("saveconfig", false, "Save config file to json_arch/<arch>_conf.json, and the architecture to json_arch/<arch>_arch.pb, using the arch name from -a.")
 	flag.Parse()
 
 	kafkaAddrs := strings.Split(addrs, ",")
@@ -57,9 +166,23 @@ func main() {
 		}
 	}
 
+	if *convertFile != "" {
+		if *convertOut == "" {
+			*convertOut = convert.OutputFile(*convertFile, *convertTo)
+		}
+		convert.Convert(*convertFile, *convertTo, *convertOut)
+		return
+	}
+	graphOutputs() // so -checkfiles looks for the directories the run will write to
+	if *checkFiles {
+		preflight(*confFile, *modelFile, *resumeFile, *importFile, *generateSpec != "" || cal.on)
+	}
 	if *confFile != "" {
 		archaius.ReadConf(*confFile)
 	}
+	if *modelFile != "" {
+		architecture.LoadModel(*modelFile) // before the keyvals are checked, as they come from the model
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -68,16 +191,137 @@ func main() {
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
+	if archaius.Conf.TagFilter != "" && !strings.Contains(archaius.Conf.TagFilter, "=") {
+		log.Fatal("spigo: -tagfilter should be key=value")
+	}
+	if _, ok := graphjson.Profiles[archaius.Conf.JSONProfile]; !ok {
+		log.Fatal("spigo: -jsonprofile should be one of " + strings.Join(graphjson.ProfileNames(), " "))
+	}
+	if archaius.Conf.TraceIDs != "zipkin" && archaius.Conf.TraceIDs != "w3c" {
+		log.Fatal("spigo: -traceids should be zipkin or w3c")
+	}
+	if archaius.Conf.MaxFlowMem < 0 {
+		log.Fatal("spigo: -maxflowmem should be MB, or 0 for no limit")
+	}
+	if j := archaius.Key(archaius.Conf, "jitter"); j != "" {
+		if f, err := strconv.ParseFloat(j, 64); err != nil || f < 0 || f >= 1 {
+			log.Fatal("spigo: -kv jitter should be a fraction of the edge latency from 0 up to 1, such as jitter:0.1")
+		}
+	}
+	if t := archaius.Key(archaius.Conf, "eurekattl"); t != "" {
+		ttl, err := time.ParseDuration(t)
+		if err != nil || ttl <= 0 {
+			log.Fatal("spigo: -kv eurekattl should be a duration such as eurekattl:5s")
+		}
+		if ep, _ := time.ParseDuration(archaius.Conf.EurekaPoll); ttl <= ep {
+			log.Printf("spigo: warning, -kv eurekattl:%v isn't longer than the -u %v poll, so instances expire before they're confirmed\n", t, archaius.Conf.EurekaPoll)
+		}
+	}
+	if s := archaius.Key(archaius.Conf, "msglogsample"); s != "" {
+		if f, err := strconv.ParseFloat(s, 64); err != nil || f < 0 || f > 1 {
+			log.Fatal("spigo: -kv msglogsample should be the fraction of messages -m prints from 0 to 1, such as msglogsample:0.01")
+		}
+	}
+	for _, kv := range strings.Split(archaius.Conf.Keyvals, ",") {
+		if f := strings.SplitN(kv, ":", 2)[0]; strings.HasPrefix(f, "serdes.") {
+			if _, _, ok := archaius.Serdes(strings.TrimPrefix(f, "serdes.")); !ok {
+				log.Fatal("spigo: -kv " + f + " should be the time to serialize and deserialize a kilobyte, such as " + f + ":5us/8us")
+			}
+		}
+	}
+	if c := archaius.Key(archaius.Conf, "chaos"); c != "" {
+		if i, err := time.ParseDuration(c); err != nil || i <= 0 {
+			log.Fatal("spigo: -kv chaos should be the interval between chaos monkey rampages, such as chaos:500ms")
+		}
+	}
+	if s := archaius.Key(archaius.Conf, "seed"); s != "" {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			log.Fatal("spigo: -kv seed should be an integer")
+		}
+	}
+	if *generateSpec != "" {
+		sp, err := generate.ParseSpec(*generateSpec)
+		if err != nil {
+			log.Fatal("spigo: -generate " + err.Error())
+		}
+		sp.Arch()
+		archaius.Conf.Arch = sp.Name
+		if duration == 0 && !archaius.Conf.Forever {
+			return
+		}
+	}
+	if *importFile != "" {
+		named := false
+		flag.Visit(func(f *flag.Flag) { named = named || f.Name == "a" })
+		if !named { // don't overwrite the default architecture
+			archaius.Conf.Arch = strings.TrimSuffix(filepath.Base(*importFile), filepath.Ext(*importFile))
+		}
+		traces.TraceArch(archaius.Conf.Arch, traces.ReadFile(*importFile))
+		if duration == 0 && !archaius.Conf.Forever {
+			return
+		}
+	}
+	var calSpec calibrate.Spec
+	if cal.on {
+		var err error
+		if calSpec, err = calibrate.ParseSpec(cal.spec); err != nil {
+			log.Fatal("spigo: -calibrate " + err.Error())
+		}
+		if duration == 0 || archaius.Conf.Forever {
+			log.Fatal("spigo: -calibrate needs a -d duration to measure over")
+		}
+		calSpec.Arch()
+	}
 	if archaius.Conf.Collect {
-		collect.Serve(8123) // start web server at port
+		collect.Sink()       // fails on a bad -metrics before the run rather than at the end
+		collect.TimeSeries() // and on bad -timeseries buckets
 	}
-	if graphjsonEnabled || graphmlEnabled || neo4jEnabled {
+	if *saveModel && (archaius.Conf.Arch == "fsm" || archaius.Conf.Arch == "migration") {
+		log.Fatal("spigo: -savemodel needs an architecture file, so can't be used with " + archaius.Conf.Arch)
+	}
+	if terraformEnabled && (archaius.Conf.Arch == "fsm" || archaius.Conf.Arch == "migration") {
+		log.Fatal("spigo: -terraform needs an architecture file, so can't be used with " + archaius.Conf.Arch)
+	}
+	if riskEnabled && (archaius.Conf.Arch == "fsm" || archaius.Conf.Arch == "migration") {
+		log.Fatal("spigo: -riskreport needs an architecture file, so can't be used with " + archaius.Conf.Arch)
+	}
+	if impactService != "" && (archaius.Conf.Arch == "fsm" || archaius.Conf.Arch == "migration") {
+		log.Fatal("spigo: -impact needs an architecture file, so can't be used with " + archaius.Conf.Arch)
+	}
+	if archaius.Conf.Forever && (archaius.Conf.Arch == "fsm" || archaius.Conf.Arch == "migration") {
+		log.Fatal("spigo: -forever can't be used with " + archaius.Conf.Arch)
+	}
+	graphOutputs() // again, a -config file can turn on -splitregions
+	if topologyEnabled {
+		edda.ServeTopology()
+	}
+	if streamEnabled {
+		edda.ServeStream()
+	}
+	if archaius.Conf.Collect || topologyEnabled || streamEnabled {
+		asgard.ServeFlags()
+		asgard.ServeControl()
+		collect.Serve(listen) // start web server at address
+	}
+	if noedda && archaius.Conf.Backstage {
+		log.Fatal("spigo: -backstage needs edda to see the dependencies, so can't be used with -noedda")
+	}
+	if graphjsonEnabled || graphmlEnabled || gexfEnabled || dotEnabled || htmlEnabled || neo4jEnabled || topologyEnabled || streamEnabled || archaius.Conf.Checkpoint != "" || archaius.Conf.Backstage {
 		if graphjsonEnabled {
 			archaius.Conf.GraphjsonFile = archaius.Conf.Arch
 		}
 		if graphmlEnabled {
 			archaius.Conf.GraphmlFile = archaius.Conf.Arch
 		}
+		if gexfEnabled {
+			archaius.Conf.GexfFile = archaius.Conf.Arch
+		}
+		if dotEnabled {
+			archaius.Conf.GraphvizFile = archaius.Conf.Arch
+		}
+		if htmlEnabled {
+			archaius.Conf.HTMLFile = archaius.Conf.Arch
+		}
 		if neo4jEnabled {
 			if archaius.Conf.Filter {
 				log.Fatal("Neo4j cannot be used with filtered names option -f")
@@ -98,15 +342,63 @@ func main() {
 		edda.Logchan = make(chan gotocol.Message, 1000)
 	}
 	archaius.Conf.RunDuration = time.Duration(duration) * time.Second
+	if archaius.Conf.Chaos != "" {
+		if i, err := time.ParseDuration(archaius.Conf.Chaos); err != nil || i <= 0 {
+			log.Fatal("spigo: bad -chaos interval " + archaius.Conf.Chaos)
+		}
+	}
+	if archaius.Conf.Checkpoint != "" {
+		if i, err := time.ParseDuration(archaius.Conf.Checkpoint); err != nil || i <= 0 {
+			log.Fatal("spigo: bad -checkpoint interval " + archaius.Conf.Checkpoint)
+		}
+		if noedda {
+			log.Fatal("spigo: -checkpoint needs edda to keep track of the nodes, so can't be used with -noedda")
+		}
+	}
+	var resume *checkpoint.CheckpointV0r0
+	if *resumeFile != "" {
+		resume = checkpoint.Read(*resumeFile)
+		archaius.Conf.Arch = resume.Arch
+		archaius.Conf.RunDuration -= checkpoint.Resumed()
+		if archaius.Conf.RunDuration <= 0 && !archaius.Conf.Forever {
+			log.Fatalf("spigo: checkpoint has already run for %v, more than -d", checkpoint.Resumed())
+		}
+	}
 
 	if *saveConfFile {
 		archaius.WriteConf()
 	}
 
+	if s := archaius.Key(archaius.Conf, "seed"); (archaius.Conf.Seed != 0 || s != "" && s != "0") && !archaius.Conf.Forever {
+		archaius.Conf.Virtual = true // wall clock times never repeat, so a seeded run is on the virtual clock to repeat byte for byte
+	}
+	if archaius.Conf.Virtual {
+		runtime.GOMAXPROCS(1)      // one goroutine runs at a time, so the actors interleave the same way on every run
+		archaius.Run()             // asks git for the commit of the architecture now, the clock can't tell a wait on git from idle
+		clock.Virtual(clock.Epoch) // before any actors start, so they all see the virtual time
+	}
+	if *eventLog != "" {
+		if err := gotocol.OpenEventLog(*eventLog); err != nil {
+			log.Fatal(err)
+		}
+		defer gotocol.CloseEventLog()
+	}
+
 	// start up the selected architecture
-	go edda.Start(archaius.Conf.Arch + ".edda") // start edda first
+	if !noedda {
+		go edda.Start(archaius.Conf.Arch + ".edda") // start edda first
+	}
 	if reload {
 		asgard.Run(asgard.Reload(archaius.Conf.Arch), "")
+	} else if resume != nil {
+		if architecture.File(archaius.Conf.Arch) != "" {
+			a, err := architecture.ReadArch(archaius.Conf.Arch)
+			if err != nil {
+				log.Fatal("spigo: can't configure -resume from architecture " + archaius.Conf.Arch + ":\n" + err.Error())
+			}
+			architecture.Configure(a) // edges, gc and so on for each service
+		}
+		asgard.Run(asgard.Restore(resume.Graph), "")
 	} else {
 		switch archaius.Conf.Arch {
 		case "fsm":
@@ -114,10 +406,25 @@ func main() {
 		case "migration":
 			migration.Start() // step by step from lamp to netflixoss
 		default:
-			a := architecture.ReadArch(archaius.Conf.Arch)
-			if a == nil {
-				log.Fatal("Architecture " + archaius.Conf.Arch + " isn't recognized")
+			a, err := architecture.ReadArch(archaius.Conf.Arch)
+			if err != nil {
+				log.Fatal("spigo: can't start architecture " + archaius.Conf.Arch + ":\n" + err.Error())
 			} else {
+				if *saveConfFile {
+					architecture.WritePB(a)
+				}
+				if *saveModel {
+					architecture.WriteModel(a)
+				}
+				if terraformEnabled {
+					architecture.WriteTerraform(a)
+				}
+				if riskEnabled {
+					architecture.WriteRiskReport(a)
+				}
+				if impactService != "" {
+					architecture.WriteImpact(a, impactService) // before this run replaces the flows
+				}
 				architecture.Start(a)
 			}
 		}
@@ -128,5 +435,169 @@ func main() {
 		close(edda.Logchan)
 	}
 	edda.Wg.Wait()
+	gotocol.CloseEventLog() // before the outputs are written, so the last line is the last message of the run
 	flow.Shutdown()
+	collect.WriteTimeline()
+	collect.WriteHdr()
+	collect.WriteTimeSeries()
+	collect.WriteSummary()
+	collect.CloseSink()
+	if *memprofile != "" {
+		writeHeapProfile(*memprofile)
+	}
+	if *manifestFile != "" || *bundleFile != "" {
+		pprof.StopCPUProfile() // so the profile is complete when it's listed
+		config := ""
+		if *confFile != "" {
+			config = "json_arch/" + *confFile + "_conf.json"
+		}
+		others := map[string]string{
+			*eventLog:            "every message sent and received, with logical timestamps",
+			*cpuprofile:          "cpu profile",
+			*memprofile:          "heap profile at shutdown",
+			archaius.Conf.SQLite: "SQLite database of the flows, histograms, summary and events",
+		}
+		if *manifestFile != "" {
+			collect.WriteManifest(*manifestFile, config, others)
+		}
+		if *bundleFile != "" { // with what the run read as well as what it wrote, to rerun it
+			others[architecture.File(archaius.Conf.Arch)] = "architecture definition"
+			others[*modelFile] = "model the run was loaded from"
+			collect.WriteBundle(*bundleFile, config, others)
+		}
+	}
+	if cal.on && !calSpec.Report() {
+		os.Exit(1)
+	}
+}
+
+// writeHeapProfile after a garbage collection so the profile only has live objects, a failure is logged as the run is already done
+func writeHeapProfile(fn string) {
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Println("spigo: can't write heap profile:", err)
+		return
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		log.Println("spigo: can't write heap profile:", err)
+	}
+}
+
+// graphOutputs turns on the graph outputs that other flags imply, and turns them all off for -noedda
+func graphOutputs() {
+	if archaius.Conf.SplitRegions {
+		graphjsonEnabled = true // the regions are split from the combined graph
+	}
+	if noedda && (graphjsonEnabled || graphmlEnabled || gexfEnabled || dotEnabled || htmlEnabled || neo4jEnabled || topologyEnabled || streamEnabled) {
+		log.Println("spigo: -noedda set, ignoring graph logging options")
+		graphjsonEnabled, graphmlEnabled, gexfEnabled, dotEnabled, htmlEnabled, neo4jEnabled, topologyEnabled, streamEnabled = false, false, false, false, false, false, false, false
+	}
+}
+
+// preflight checks that the files the run will read can be opened, and the directories it writes to are there, before anything
+// starts, and lists every one that's missing with the paths it looked at. The architecture is the one the config, model or
+// checkpoint will set, and isn't checked if it's generated
+func preflight(conf, model, resume, imported string, generated bool) {
+	var missing []string
+	need := func(what string, dir bool, paths ...string) bool {
+		for _, fn := range paths {
+			if f, err := os.Open(fn); err == nil {
+				st, err := f.Stat()
+				f.Close()
+				if err == nil && st.IsDir() == dir {
+					return true
+				}
+			}
+		}
+		var looked []string
+		for _, fn := range paths {
+			if abs, err := filepath.Abs(fn); err == nil {
+				fn = abs
+			}
+			looked = append(looked, fn)
+		}
+		missing = append(missing, what+" "+strings.Join(looked, " or "))
+		return false
+	}
+	arch := archaius.Conf.Arch
+	var v struct {
+		Arch string `json:"arch"`
+	}
+	if conf != "" {
+		fn := "json_arch/" + conf + "_conf.json"
+		if need("-config", false, fn) {
+			if data, err := ioutil.ReadFile(fn); err == nil && json.Unmarshal(data, &v) == nil && v.Arch != "" {
+				arch = v.Arch
+			}
+		}
+	}
+	if model != "" && need("-model", false, model) {
+		if data, err := ioutil.ReadFile(model); err == nil {
+			if m, err := architecture.ParseModel(data); err == nil {
+				arch = m.Arch.Arch
+			}
+		}
+	}
+	if resume != "" && need("-resume", false, resume) {
+		if data, err := ioutil.ReadFile(resume); err == nil && json.Unmarshal(data, &v) == nil {
+			arch = v.Arch
+		}
+	}
+	if imported != "" {
+		need("-import", false, imported)
+	}
+	switch {
+	case generated, imported != "", model != "", resume != "", arch == "migration":
+	case reload || arch == "fsm":
+		ss := ""
+		if archaius.Conf.StopStep > 0 {
+			ss = fmt.Sprintf("%v", archaius.Conf.StopStep)
+		}
+		need("-r", false, "json/"+arch+ss+".json", "json/"+arch+ss+".json.gz")
+	default:
+		need("-a", false, "json_arch/"+arch+"_arch.json", "json_arch/"+arch+"_arch.pb")
+	}
+	if archaius.Conf.Invariants != "" {
+		need("-invariants", false, archaius.Conf.Invariants)
+	}
+	if archaius.Conf.RequestFile != "" {
+		need("-requestfile", false, archaius.Conf.RequestFile)
+	}
+	if archaius.Conf.Collect {
+		need("-c", true, "json_metrics")
+		if archaius.Conf.Metrics == "file" {
+			need("-c", true, "csv_metrics")
+		}
+	}
+	if archaius.Conf.Checkpoint != "" {
+		need("-checkpoint", true, "json_metrics")
+	}
+	if archaius.Conf.ChromeTrace {
+		need("-chrometrace", true, "traces")
+	}
+	if archaius.Conf.Flame {
+		need("-flame", true, "traces")
+	}
+	if graphjsonEnabled || htmlEnabled || archaius.Conf.Backstage || archaius.Conf.Animate || terraformEnabled || riskEnabled || impactService != "" {
+		need("graph output", true, "json")
+	}
+	if graphmlEnabled {
+		need("-g", true, "gml")
+	}
+	if gexfEnabled {
+		need("-gexf", true, "gexf")
+	}
+	if dotEnabled {
+		need("-dot", true, "dot")
+	}
+	if len(missing) == 0 {
+		log.Println("spigo: -checkfiles found everything the run needs")
+		return
+	}
+	for _, m := range missing {
+		log.Println("spigo: missing for " + m)
+	}
+	log.Fatalf("spigo: -checkfiles found %v missing", len(missing))
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs spigo itself when TestVirtualRepeats starts the test binary as a run
+func TestMain(m *testing.M) {
+	if os.Getenv("SPIGO_TEST_RUN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// outputs are the directories a run writes to
+var outputs = []string{"json", "json_metrics", "csv_metrics"}
+
+// runDir makes a directory for a run with the output directories and the architectures, for the caller to remove
+func runDir(t *testing.T) string {
+	archs, err := filepath.Abs("json_arch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "spigo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range outputs {
+		if err := os.Mkdir(filepath.Join(dir, o), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(archs, filepath.Join(dir, "json_arch")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// spigo runs in the directory with the args, and returns what it logged
+func spigo(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SPIGO_TEST_RUN=1")
+	return cmd.CombinedOutput()
+}
+
+// run spigo with the args in a directory of its own, which is returned for the caller to remove
+func run(t *testing.T, args ...string) string {
+	dir := runDir(t)
+	if out, err := spigo(dir, args...); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("%v: %v\n%s", args, err, out)
+	}
+	return dir
+}
+
+// TestVirtualRepeats runs small architectures twice with the same seed, each in a directory of its own, and the graph, flows,
+// metrics and summary of the two runs should be the same byte for byte. A seed puts the run on the virtual clock
+func TestVirtualRepeats(t *testing.T) {
+	for _, args := range [][]string{
+		{"-a", "test", "-virtual", "-seed", "3", "-d", "5", "-c", "-j"},
+		{"-a", "fsm", "-seed", "7", "-j"},
+	} {
+		var runs [2]string
+		for i := range runs {
+			runs[i] = run(t, args...)
+			defer os.RemoveAll(runs[i])
+		}
+		files := 0
+		for _, o := range outputs {
+			first, err := filepath.Glob(filepath.Join(runs[0], o, "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range first {
+				name := filepath.Join(o, filepath.Base(f))
+				a, err := ioutil.ReadFile(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := ioutil.ReadFile(filepath.Join(runs[1], name))
+				if err != nil {
+					t.Errorf("%v: %v only written by the first run: %v", args, name, err)
+					continue
+				}
+				if !bytes.Equal(a, b) {
+					t.Errorf("%v: %v differs between two runs with the same seed", args, name)
+				}
+				files++
+			}
+		}
+		if files == 0 {
+			t.Errorf("%v: the runs didn't write anything", args)
+		}
+	}
+}
+
+// TestNoEdda checks a run with -noedda writes no graph even when one is asked for, and still collects the metrics
+func TestNoEdda(t *testing.T) {
+	dir := run(t, "-a", "test", "-virtual", "-d", "2", "-c", "-j", "-noedda")
+	defer os.RemoveAll(dir)
+	if graphs, _ := filepath.Glob(filepath.Join(dir, "json", "*")); len(graphs) != 0 {
+		t.Errorf("graph written without edda: %v", graphs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "json_metrics", "test_summary.json")); err != nil {
+		t.Errorf("no summary: %v", err)
+	}
+}
+
+// TestCheckFiles checks -checkfiles looks for the graph directory that -splitregions implies, and not for the one -noedda
+// won't write to
+func TestCheckFiles(t *testing.T) {
+	dir := runDir(t)
+	defer os.RemoveAll(dir)
+	if err := os.Remove(filepath.Join(dir, "json")); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := spigo(dir, "-a", "test", "-virtual", "-d", "1", "-splitregions", "-checkfiles"); err == nil || !bytes.Contains(out, []byte("missing for graph output")) {
+		t.Errorf("-splitregions without a json directory: %v\n%s", err, out)
+	}
+	if out, err := spigo(dir, "-a", "test", "-virtual", "-d", "1", "-j", "-noedda", "-checkfiles"); err != nil || !bytes.Contains(out, []byte("found everything")) {
+		t.Errorf("-noedda without a json directory: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,175 @@
+// Package summaries reads run summaries from json_metrics/<arch>_summary.json and compares them side by side
+package summaries
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Variant is one run, with every numeric value in its summary flattened to a dotted metric name
+// such as services.homepage.p99ms or autoscale.homepage.final
+type Variant struct {
+	Name    string             `json:"name"`
+	File    string             `json:"file"`
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// Flatten adds the numeric leaves of a decoded json value to metrics, named by their path from prefix
+func Flatten(v interface{}, prefix string, metrics map[string]float64) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			Flatten(e, k, metrics)
+		}
+	case float64:
+		metrics[prefix] = t
+	}
+}
+
+// variantName picks the run name, or the arch and labels, to identify a run
+func variantName(run map[string]interface{}, fn string) string {
+	if n, ok := run["name"].(string); ok && n != "" {
+		return n
+	}
+	name, _ := run["arch"].(string)
+	if name == "" {
+		return strings.TrimSuffix(filepath.Base(fn), "_summary.json")
+	}
+	if labels, ok := run["labels"].(map[string]interface{}); ok {
+		var kv []string
+		for k, v := range labels {
+			kv = append(kv, fmt.Sprintf("%v=%v", k, v))
+		}
+		sort.Strings(kv)
+		if len(kv) > 0 {
+			name += "[" + strings.Join(kv, ",") + "]"
+		}
+	}
+	return name
+}
+
+// ReadFile reads one summary file
+func ReadFile(fn string) Variant {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var s map[string]interface{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		log.Fatal("summaries: " + fn + ": " + err.Error())
+	}
+	run, _ := s["run"].(map[string]interface{})
+	delete(s, "run") // metadata, not metrics
+	v := Variant{Name: variantName(run, fn), File: fn, Metrics: make(map[string]float64)}
+	Flatten(s, "", v.Metrics)
+	return v
+}
+
+// ReadGlob reads all the summary files that match a glob pattern
+func ReadGlob(pattern string) []Variant {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(files) == 0 {
+		log.Fatal("summaries: no files match " + pattern)
+	}
+	sort.Strings(files)
+	vs := make([]Variant, 0, len(files))
+	for _, fn := range files {
+		vs = append(vs, ReadFile(fn))
+	}
+	return vs
+}
+
+// byMetric sorts variants by one metric, variants that don't have it go last
+type byMetric struct {
+	vs         []Variant
+	metric     string
+	descending bool
+}
+
+func (a byMetric) Len() int      { return len(a.vs) }
+func (a byMetric) Swap(i, j int) { a.vs[i], a.vs[j] = a.vs[j], a.vs[i] }
+func (a byMetric) Less(i, j int) bool {
+	mi, oki := a.vs[i].Metrics[a.metric]
+	mj, okj := a.vs[j].Metrics[a.metric]
+	if oki != okj {
+		return oki
+	}
+	if a.descending {
+		return mi > mj
+	}
+	return mi < mj
+}
+
+// Rank sorts the variants best first by a metric, lowest first unless descending is set
+func Rank(vs []Variant, metric string, descending bool) {
+	sort.Stable(byMetric{vs, metric, descending})
+}
+
+// Columns lists the union of all the metric names, sorted
+func Columns(vs []Variant) []string {
+	seen := make(map[string]bool)
+	var cols []string
+	for _, v := range vs {
+		for m := range v.Metrics {
+			if !seen[m] {
+				seen[m] = true
+				cols = append(cols, m)
+			}
+		}
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// WriteCSV writes one row per variant in rank order, with a column per metric, blank where a run doesn't have one
+func WriteCSV(w io.Writer, vs []Variant, metric string) {
+	cols := Columns(vs)
+	if metric != "" {
+		cols = append([]string{metric}, cols...) // ranking metric first as well as in its place
+	}
+	c := csv.NewWriter(w)
+	c.Write(append([]string{"rank", "variant"}, cols...))
+	for i, v := range vs {
+		row := []string{fmt.Sprintf("%v", i+1), v.Name}
+		for _, m := range cols {
+			row = append(row, value(v, m))
+		}
+		c.Write(row)
+	}
+	c.Flush()
+}
+
+// value formats a metric, blank if the variant doesn't have it
+func value(v Variant, m string) string {
+	if f, ok := v.Metrics[m]; ok {
+		return fmt.Sprintf("%v", f)
+	}
+	return ""
+}
+
+// WriteJSON writes the ranked variants as json
+func WriteJSON(w io.Writer, vs []Variant, metric string) {
+	j, err := json.MarshalIndent(struct {
+		Rank     string    `json:"rank"`
+		Metrics  []string  `json:"metrics"`
+		Variants []Variant `json:"variants"`
+	}{metric, Columns(vs), vs}, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Write(j)
+	io.WriteString(w, "\n")
+}
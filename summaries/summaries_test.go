@@ -0,0 +1,28 @@
+package summaries
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRank(t *testing.T) {
+	vs := []Variant{
+		{Name: "slow", Metrics: map[string]float64{"services.homepage.p99ms": 40, "flow.traces": 100}},
+		{Name: "none", Metrics: map[string]float64{"flow.traces": 10}},
+		{Name: "fast", Metrics: map[string]float64{"services.homepage.p99ms": 4}},
+	}
+	Rank(vs, "services.homepage.p99ms", false)
+	var b bytes.Buffer
+	WriteCSV(&b, vs, "services.homepage.p99ms")
+	fmt.Print(b.String())
+	if vs[0].Name != "fast" || vs[1].Name != "slow" || vs[2].Name != "none" {
+		t.Fail()
+	}
+	m := make(map[string]float64)
+	Flatten(map[string]interface{}{"a": map[string]interface{}{"b": 1.0, "c": "text"}}, "", m)
+	fmt.Println(m)
+	if len(m) != 1 || m["a.b"] != 1 {
+		t.Fail()
+	}
+}
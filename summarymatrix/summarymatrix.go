@@ -0,0 +1,39 @@
+// utility to compare run summaries side by side and rank them by a metric
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/adrianco/spigo/summaries"
+)
+
+func main() {
+	var pattern, rank, out string
+	var descending bool
+	flag.StringVar(&pattern, "files", "json_metrics/*_summary.json", "glob pattern for the summary files to compare")
+	flag.StringVar(&rank, "rank", "", "metric to rank by, e.g. services.homepage.p99ms")
+	flag.BoolVar(&descending, "desc", false, "rank highest first, default is lowest first")
+	flag.StringVar(&out, "o", "", "output file, .json for json, otherwise csv, default csv to stdout")
+	flag.Parse()
+	vs := summaries.ReadGlob(pattern)
+	if rank != "" {
+		summaries.Rank(vs, rank, descending)
+	}
+	w := os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if strings.HasSuffix(out, ".json") {
+		summaries.WriteJSON(w, vs, rank)
+	} else {
+		summaries.WriteCSV(w, vs, rank)
+	}
+}
@@ -4,11 +4,19 @@ package archaius
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"os"
+	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/adrianco/spigo/tooling/clock"
 )
 
 // Configuration information for spigo
@@ -22,12 +30,24 @@ type Configuration struct {
 	// GraphjsonFile is set to a filename to turn on GraphML logging
 	GraphjsonFile string `json:"graphjsonfile"`
 
+	// GexfFile is set to a filename to turn on GEXF logging for Gephi
+	GexfFile string `json:"gexffile"`
+
+	// GraphvizFile is set to a filename to turn on GraphViz dot logging
+	GraphvizFile string `json:"graphvizfile"`
+
+	// HTMLFile is set to a filename to turn on writing a standalone html page of the graph
+	HTMLFile string `json:"htmlfile"`
+
 	// Neo4jURL is pointed at a database instance to turn on GraphML logging
 	Neo4jURL string `json:"neo4jurl"`
 
 	// RunDuration is the time in seconds to let the microservices chat
 	RunDuration time.Duration `json:"runduration"`
 
+	// Forever keeps the microservices chatting until spigo is interrupted, instead of for RunDuration
+	Forever bool `json:"forever"`
+
 	// Dunbar is a population scale factor
 	Dunbar int `json:"dunbar"`
 
@@ -55,6 +75,12 @@ type Configuration struct {
 	// Kafka turns on Zipkin compatible Flow export if array of host:port strings is not empty
 	Kafka []string `json:"kafka"`
 
+	// Zipkin turns on Flow export by posting batches of Zipkin spans to a collector at this url if not empty
+	Zipkin string `json:"zipkin"`
+
+	// OTLP turns on Flow export as OpenTelemetry spans to a collector at this gRPC host:port or OTLP/HTTP url if not empty
+	OTLP string `json:"otlp"`
+
 	// StopStep stops building new microservices at this step, 0 means don't stop
 	StopStep int `json:"stopstep"`
 
@@ -66,6 +92,1683 @@ type Configuration struct {
 
 	// Keys and values for configuring services, passed in as one string
 	Keyvals string `json:"keyvals"`
+
+	// Seed for the random choices made in the run, so it can be repeated, zero seeds from the clock
+	Seed int64 `json:"seed"`
+
+	// Virtual runs on a discrete event clock that jumps to the next thing due to happen, instead of waiting in real time
+	Virtual bool `json:"virtual"`
+
+	// RunName identifies this run in all the outputs
+	RunName string `json:"runname"`
+
+	// Labels are key=value items recorded in all the outputs
+	Labels []string `json:"labels"`
+
+	// Gzip compresses the graph json and graphml files written by edda
+	Gzip bool `json:"gzip"`
+
+	// SplitRegions also writes the graph json of each region to its own file
+	SplitRegions bool `json:"splitregions"`
+
+	// JSONProfile names the field naming profile for graph json, to match the tool that will read it
+	JSONProfile string `json:"jsonprofile"`
+
+	// Sequence picks a trace id, or random, to write as a PlantUML sequence diagram
+	Sequence string `json:"sequence"`
+
+	// CallMatrix writes the service by service call counts from the flows
+	CallMatrix bool `json:"callmatrix"`
+
+	// ChromeTrace writes the flows in the Chrome trace_event format
+	ChromeTrace bool `json:"chrometrace"`
+
+	// CriticalPath writes the spans that determined the latency of each trace, and what each service contributed
+	CriticalPath bool `json:"criticalpath"`
+
+	// Flame writes the latency of the calls made from each entry point, totalled over all the traces, as folded stacks
+	Flame bool `json:"flame"`
+
+	// LatencyBreakdown writes what each service contributed to the mean and p99 latency of each entry point
+	LatencyBreakdown bool `json:"latencybreakdown"`
+
+	// Backstage writes the services and their dependencies as Backstage catalog entities
+	Backstage bool `json:"backstage"`
+
+	// Animate writes the graph joined with the calls over each edge in time order, for playback
+	Animate bool `json:"animate"`
+
+	// Hdr writes the response times of each service as HdrHistograms
+	Hdr bool `json:"hdr"`
+
+	// Metrics is where histograms and the summary are written, file, stdout for InfluxDB line protocol, or an InfluxDB write url
+	Metrics string `json:"metrics"`
+
+	// SQLite is a database file the flows, histograms, summary and events are written to as well, to query with SQL
+	SQLite string `json:"sqlite"`
+
+	// TraceIDs is the id format for the spans in the flows, zipkin or w3c for W3C Trace Context traceparent ids
+	TraceIDs string `json:"traceids"`
+
+	// TagFilter is a key=value service tag, only matching nodes are written to the graphs
+	TagFilter string `json:"tagfilter"`
+
+	// TagNeighbors also writes the nodes that are directly connected to matching nodes
+	TagNeighbors bool `json:"tagneighbors"`
+
+	// Chaos is the interval between chaos monkey rampages, e.g. 500ms, which schedules one if the architecture doesn't
+	Chaos string `json:"chaos"`
+
+	// Checkpoint is the interval between saving the instance set and summary while running, e.g. 10m
+	Checkpoint string `json:"checkpoint"`
+
+	// Cycles allows dependency cycles between services that pass requests on, otherwise the architecture is rejected
+	Cycles bool `json:"cycles"`
+
+	// MaxHops fails calls that are more than this many hops from the start of the request, zero for no limit
+	MaxHops int `json:"maxhops"`
+
+	// Invariants is a file of structural rules the architecture has to keep once it's expanded into instances, or the run fails
+	Invariants string `json:"invariants"`
+
+	// RequestFile is a script of the requests to send, each at a time after the traffic starts, instead of at a rate
+	RequestFile string `json:"requestfile"`
+
+	// TargetUtil is a service=utilization target, such as subscriber=0.7, that the injector rate is adjusted to hold
+	TargetUtil string `json:"targetutil"`
+
+	// TimeSeries is the width of the time buckets the response times of each service are written in, e.g. 1s
+	TimeSeries string `json:"timeseries"`
+
+	// BucketAlign puts the time series bucket boundaries at multiples of the width from the runstart or the wallclock
+	BucketAlign string `json:"bucketalign"`
+
+	// BucketOrigin moves the time series bucket boundaries this far on from the alignment, e.g. 500ms
+	BucketOrigin string `json:"bucketorigin"`
+
+	// MaxFlowMem caps the MB of raw flow annotations kept in memory, the oldest traces are dropped to stay under it, zero for no limit
+	MaxFlowMem int `json:"maxflowmem"`
+}
+
+// RunInfo describes a run, so that outputs can be identified later
+type RunInfo struct {
+	Name        string            `json:"name,omitempty"`
+	Arch        string            `json:"arch"`
+	Description string            `json:"description,omitempty"`
+	Commit      string            `json:"commit,omitempty"` // git commit of the architecture file
+	Labels      map[string]string `json:"labels,omitempty"`
+	Args        string            `json:"args"`
+	Date        string            `json:"date"`
+}
+
+var started = time.Now()
+var archInfo struct {
+	sync.Once
+	description, commit string
+}
+
+// RunStarted is when the run started, outputs written since then are from this run
+func RunStarted() time.Time {
+	return started
+}
+
+// Run returns the metadata for this run
+func Run() RunInfo {
+	fn := "json_arch/" + Conf.Arch + "_arch.json"
+	archInfo.Do(func() {
+		var a struct {
+			Description string `json:"description"`
+		}
+		if data, err := ioutil.ReadFile(fn); err == nil {
+			json.Unmarshal(data, &a)
+			archInfo.description = a.Description
+		}
+		if out, err := exec.Command("git", "log", "-1", "--format=%H", "--", fn).Output(); err == nil {
+			archInfo.commit = strings.TrimSpace(string(out))
+		}
+	})
+	r := RunInfo{
+		Name:        Conf.RunName,
+		Arch:        Conf.Arch,
+		Description: archInfo.description,
+		Commit:      archInfo.commit,
+		Args:        fmt.Sprintf("%v", os.Args),
+		Date:        started.Format(time.RFC3339Nano),
+	}
+	if Conf.Virtual { // the run happens at the epoch of the virtual clock, so the outputs of the same run repeat
+		r.Date = clock.Epoch.Format(time.RFC3339Nano)
+	}
+	for _, l := range Conf.Labels {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) == 2 {
+			if r.Labels == nil {
+				r.Labels = make(map[string]string)
+			}
+			r.Labels[kv[0]] = kv[1]
+		}
+	}
+	return r
+}
+
+// ServiceConfig holds optional per service behavior read from the architecture definition
+type ServiceConfig struct {
+	// Baggage lists key=value items, one of which is attached to each new request started by this service
+	Baggage []string `json:"baggage,omitempty"`
+
+	// Sessions is how many users the new requests started by this service come from, each carries one of them picked at
+	// random in its session baggage item, so sticky dependencies keep sending a user to the same instance
+	Sessions int `json:"sessions,omitempty"`
+
+	// Deadline for each new request started by this service, e.g. 250ms
+	Deadline string `json:"deadline,omitempty"`
+
+	// Apdex is the target response time T the apdex score of this service counts its callers as satisfied within, and
+	// tolerating within 4T, e.g. 50ms, default -kv apdex for all services or no score
+	Apdex string `json:"apdex,omitempty"`
+
+	// SLA is the availability this service is meant to deliver, as the percentage of its requests that succeed, e.g. 99.9,
+	// and end to end as its clients see it if it's an entry point, default -kv sla for all services or none
+	SLA float64 `json:"sla,omitempty"`
+
+	// Edges configures calls to each dependency by service name
+	Edges map[string]EdgeConfig `json:"edges,omitempty"`
+
+	// Autoscale adjusts the instance count to hold a response time target
+	Autoscale *AutoscaleConfig `json:"autoscale,omitempty"`
+
+	// GC models stop the world garbage collection pauses for JVM-like services
+	GC *GCConfig `json:"gc,omitempty"`
+
+	// Queue configures the delivery of work items by a workqueue service
+	Queue *QueueConfig `json:"queue,omitempty"`
+
+	// Version tags the instances of a service, e.g. v1 and v2 of a canary deployment, and is recorded in the flows
+	Version string `json:"version,omitempty"`
+
+	// Errors is the fraction of requests this service fails with an error response, e.g. 0.05
+	Errors float64 `json:"errors,omitempty"`
+
+	// Think is the processing time between getting a response from one dependency and calling the next one, e.g. 2ms
+	Think string `json:"think,omitempty"`
+
+	// Tags are key value attributes such as tier or team, written to the graph nodes and used by -tagfilter
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Replication chooses how a store service copies writes to the other instances of the service
+	Replication *ReplicationConfig `json:"replication,omitempty"`
+
+	// Dedup is how long this service remembers the idempotency keys of requests it has done, so retries get the cached result, e.g. 10s
+	Dedup string `json:"dedup,omitempty"`
+
+	// Sidecar models a service mesh proxy next to each instance, that every call in and out of the service goes through
+	Sidecar *SidecarConfig `json:"sidecar,omitempty"`
+
+	// Memory models a footprint that grows with requests, and out of memory restarts when it goes over the limit
+	Memory *MemoryConfig `json:"memory,omitempty"`
+
+	// Coalesce makes identical requests that arrive while one is in flight wait for its response, like an API gateway
+	Coalesce *CoalesceConfig `json:"coalesce,omitempty"`
+
+	// Caching chooses how a cache service keeps in step with the origin services it depends on
+	Caching *CachingConfig `json:"caching,omitempty"`
+
+	// Health combines signals about each instance into a score that health balanced edges and autoscaling can use
+	Health *HealthConfig `json:"health,omitempty"`
+
+	// Leader elects one instance of a clustered store service to take all the writes
+	Leader *LeaderConfig `json:"leader,omitempty"`
+
+	// Startup models instances that serve slowly while their pools and caches warm up after they start
+	Startup *StartupConfig `json:"startup,omitempty"`
+
+	// External models a third party API such as a payment gateway, with its own rate limit, latency and outages
+	External *ExternalConfig `json:"external,omitempty"`
+
+	// Saga is the ordered steps of a distributed transaction run by a saga service, and the compensations that undo them
+	Saga *SagaConfig `json:"saga,omitempty"`
+
+	// TwoPhase is the types of distributed transaction a twophase coordinator commits across the services it depends on
+	TwoPhase *TwoPhaseConfig `json:"twophase,omitempty"`
+
+	// DNS models resolving the hostname of each dependency before calling it, cached by each instance until the ttl runs out
+	DNS *DNSConfig `json:"dns,omitempty"`
+
+	// Sizes mixes instance types in the service, each a fraction of the instances with its own latency and concurrency
+	Sizes []InstanceSize `json:"sizes,omitempty"`
+
+	// WAL makes each write to a store service durable by fsyncing a write ahead log before it's applied
+	WAL *WALConfig `json:"wal,omitempty"`
+
+	// Lock is the locks a lock service grants and how long their holders keep them
+	Lock *LockConfig `json:"lock,omitempty"`
+
+	// KeyAccess picks which of the keys put so far the gets started by a denominator are for
+	KeyAccess *KeyAccessConfig `json:"keyaccess,omitempty"`
+
+	// Cost is what an instance of this service costs an hour, e.g. 0.096, it's counted for the time each instance is running
+	Cost float64 `json:"cost,omitempty"`
+
+	// Shed models instances that work on a few calls at a time when they're overloaded, and the queue discipline that picks
+	// which waiting call they serve next and which they shed
+	Shed *ShedConfig `json:"shed,omitempty"`
+
+	// Admission turns calls away as they arrive to keep the time they queue for an instance near a target, it needs the
+	// concurrency of a shed config or of the sizes of the service for there to be a queue
+	Admission *AdmissionConfig `json:"admission,omitempty"`
+
+	// Drain takes instances that autoscaling or a deployment removes out of service gracefully, rather than abruptly
+	Drain *DrainConfig `json:"drain,omitempty"`
+
+	// Latency is the response time model of the service, drawn for each call to it and added to the latency of the call, so
+	// the percentiles of its callers have a realistic tail
+	Latency *LatencyConfig `json:"latency,omitempty"`
+
+	// Topic configures the partitions and retention of a topic service, and how far behind its consumers fetch
+	Topic *TopicConfig `json:"topic,omitempty"`
+}
+
+// TopicConfig configures a Kafka style topic service
+type TopicConfig struct {
+	// Partitions each instance splits the messages published to it into, each consumer group gets the messages of a partition
+	// from one of its members in order, one at a time, default 1
+	Partitions int `json:"partitions,omitempty"`
+
+	// Depth is how many messages a partition keeps, once it's full the oldest is dropped even if a group hasn't had it yet,
+	// default no limit
+	Depth int `json:"depth,omitempty"`
+
+	// Lag is how often the consumers fetch, a message published to an idle partition waits for the next fetch to be delivered,
+	// default 100ms
+	Lag string `json:"lag,omitempty"`
+
+	// Session is how long a consumer has to acknowledge a delivery before it's taken out of its group and its partitions go to
+	// the other members, it joins again if it answers later, default 10s
+	Session string `json:"session,omitempty"`
+
+	// Retries is how many times a message that failed is delivered again before the group skips it as a dead letter, default 3
+	Retries int `json:"retries,omitempty"`
+}
+
+// LatencyConfig is the distribution the response time of each call to a service is drawn from
+type LatencyConfig struct {
+	// Distribution is lognormal, pareto for a heavy tail, or bimodal for a fast path and a slow one such as a cache miss
+	Distribution string `json:"distribution"`
+
+	// Median of the response time, or of the fast path of bimodal, e.g. 20ms
+	Median string `json:"median"`
+
+	// Sigma is the spread of lognormal, the standard deviation of the log of the response time, default 0.5, and of each path
+	// of bimodal, default 0.25
+	Sigma float64 `json:"sigma,omitempty"`
+
+	// Alpha is the tail index of pareto, the smaller it is the heavier the tail, default 2, and it has to be over 1
+	Alpha float64 `json:"alpha,omitempty"`
+
+	// Slow is the median of the slow path of bimodal, e.g. 200ms, and SlowFraction the fraction of calls that take it, e.g. 0.05
+	Slow         string  `json:"slow,omitempty"`
+	SlowFraction float64 `json:"slowfraction,omitempty"`
+
+	// Max caps each response time, so a heavy tail doesn't outlast the run, e.g. 5s, default no cap
+	Max string `json:"max,omitempty"`
+}
+
+// DrainConfig is how an instance is removed on purpose, deregistered from the instances that call it and the name service
+// straight away, then terminated once it has finished the requests it has in flight
+type DrainConfig struct {
+	// Delay is how long to wait after deregistering for the requests already on their way to arrive, default 100ms
+	Delay string `json:"delay,omitempty"`
+
+	// Timeout is the longest to wait after deregistering for the requests in flight to finish, default 5s, any still in
+	// flight then are dropped
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// AdmissionConfig is the adaptive admission controller of a service, fed with the queue sojourn time of each call that's
+// admitted and deciding for each call that arrives whether to admit it or reject it with a failure
+type AdmissionConfig struct {
+	// Controller is codel, which rejects calls that arrive while the sojourn is over the target once it has stayed over it
+	// for an interval, or pid, which rejects a fraction of the calls set by a PID controller on the sojourn
+	Controller string `json:"controller"`
+
+	// Target is the queue sojourn time the controller aims for, default 5ms
+	Target string `json:"target,omitempty"`
+
+	// Interval is how long codel lets the sojourn stay over the target before rejecting, default 100ms
+	Interval string `json:"interval,omitempty"`
+
+	// Kp, Ki and Kd are the proportional, integral and derivative gains of pid, on the sojourn over the target as a fraction
+	// of the target, default 0.1, 1 and 0
+	Kp float64 `json:"kp,omitempty"`
+	Ki float64 `json:"ki,omitempty"`
+	Kd float64 `json:"kd,omitempty"`
+}
+
+// ShedConfig is the queue of calls waiting for each instance of a service, and how it's served and shed under load
+type ShedConfig struct {
+	// Concurrency is how many calls an instance works on at a time, the rest queue, the concurrency of its size if it has one
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Queue is how many calls can wait for an instance, more are shed with a failure, default no limit
+	Queue int `json:"queue,omitempty"`
+
+	// Discipline is fifo, the default, lifo which serves the newest call first and sheds the oldest when the queue is full, as
+	// its caller is the most likely to have given up, codel which serves in order but sheds the calls at the head of the
+	// queue that have waited too long, or wfq which shares the instance between the tenants of the queued calls by weight
+	Discipline string `json:"discipline,omitempty"`
+
+	// Weights are the shares of the instance wfq gives each tenant, by the value of the tenant baggage item of a call, a
+	// tenant that isn't listed has a weight of 1
+	Weights map[string]float64 `json:"weights,omitempty"`
+
+	// Tenant is the baggage item wfq tells the tenants apart by, default tenant
+	Tenant string `json:"tenant,omitempty"`
+
+	// Target is how long codel lets a call wait once the queue hasn't emptied for an interval, default 5ms
+	Target string `json:"target,omitempty"`
+
+	// Interval is how long codel lets a call wait while the queue is emptying now and then, default 100ms
+	Interval string `json:"interval,omitempty"`
+
+	// Backpressure holds a call that finds the queue full at its caller until there's room, instead of shedding it, and the
+	// caller instance doesn't start any of the calls sent to it meanwhile, so they queue for it in turn
+	Backpressure bool `json:"backpressure,omitempty"`
+}
+
+// KeyAccessConfig is the popularity of the keys in the workload, which sets the hit ratio a cache with a capacity can get
+type KeyAccessConfig struct {
+	// Distribution is uniform, the default, or zipf where a few keys get most of the gets, the ones put first the most
+	Distribution string `json:"distribution"`
+
+	// Skew is the exponent of the zipf distribution, more than 1 and higher for a more skewed workload, default 1.1
+	Skew float64 `json:"skew,omitempty"`
+}
+
+// LockConfig is the locks of a lock service, each one is held by one caller at a time across all the instances
+type LockConfig struct {
+	// Keys are the locks, each acquire is for one of them
+	Keys []LockKey `json:"keys"`
+
+	// Timeout fails an acquire that has waited this long for its lock, default it waits as long as it takes
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// LockKey is a lock and the critical section of its holders
+type LockKey struct {
+	Name string `json:"name"`
+
+	// Hold is the mean time a holder keeps the lock before it releases it, e.g. 20ms
+	Hold string `json:"hold"`
+
+	// Distribution of the hold times, fixed, uniform (0 to twice the mean) or exponential, the default
+	Distribution string `json:"distribution,omitempty"`
+
+	// Weight is the share of the acquires that are for this lock, default 1
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// WALConfig is the write ahead log of a store service, each instance fsyncs one batch of writes at a time
+type WALConfig struct {
+	// Fsync is the mean time to fsync the log, e.g. 2ms
+	Fsync string `json:"fsync"`
+
+	// Distribution of the fsync times, fixed, uniform (0 to twice the mean) or exponential, the default
+	Distribution string `json:"distribution,omitempty"`
+
+	// Group commit collects the writes that arrive within this window of the first, e.g. 5ms, and fsyncs them together.
+	// Without it each write gets an fsync of its own, one after another
+	Group string `json:"group,omitempty"`
+}
+
+// InstanceSize is one of the instance types of a service with a mixed fleet
+type InstanceSize struct {
+	Name string `json:"name"`
+
+	// Fraction of the instances that are this size, the fractions of the sizes add up to 1
+	Fraction float64 `json:"fraction"`
+
+	// Latency added to each call to an instance of this size, e.g. 10ms for a small one
+	Latency string `json:"latency,omitempty"`
+
+	// Concurrency is how many calls an instance of this size works on at a time, the rest wait for one to finish. Zero is no limit
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Cost is what an instance of this size costs an hour, instead of the cost of the service
+	Cost float64 `json:"cost,omitempty"`
+}
+
+// DNSConfig is the name resolution of the calls out of a service, separate from the instances eureka finds for it
+type DNSConfig struct {
+	// Latency of a lookup that isn't cached, added to the first call to each dependency and the first after the ttl, e.g. 20ms.
+	// Without one there are no lookups
+	Latency string `json:"latency,omitempty"`
+
+	// TTL is how long a lookup is cached by an instance, e.g. 5s, it's cached for the rest of the run if it's empty, and 0s
+	// looks up every call
+	TTL string `json:"ttl,omitempty"`
+}
+
+// SagaConfig is a distributed transaction made of a local transaction on each of the services it depends on, one after another
+type SagaConfig struct {
+	// Steps are done in order, if one fails the compensations of the ones before it are called in reverse order
+	Steps []SagaStep `json:"steps"`
+
+	// Timeout fails a step or compensation that hasn't responded in time, default 1s
+	Timeout string `json:"timeout,omitempty"`
+
+	// Retries is how many more times a failed compensation is called before the saga is left stuck, default 3
+	Retries int `json:"retries,omitempty"`
+}
+
+// SagaStep is a call to a service to do one step of a saga and the call that undoes it
+type SagaStep struct {
+	Service string `json:"service"`
+
+	// Request is the body of the call that does the step, default the body the saga was started with, e.g. reserve
+	Request string `json:"request,omitempty"`
+
+	// Compensation is the body of the call that undoes the step, e.g. release, empty if there's nothing to undo, like a read
+	Compensation string `json:"compensation,omitempty"`
+
+	// Errors is the fraction of calls to the step that are declined, like a card being refused, which fails the saga
+	Errors float64 `json:"errors,omitempty"`
+}
+
+// TwoPhaseConfig is a coordinator that asks every participant of a transaction to prepare, and commits if they all vote yes
+// or aborts if any of them votes no or doesn't answer, so the caller waits for the slowest participant in each phase
+type TwoPhaseConfig struct {
+	// Transactions are picked by the body of the request that starts one
+	Transactions []TwoPhaseTransaction `json:"transactions"`
+
+	// Timeout aborts a transaction when a participant hasn't voted in time, and resends a commit that hasn't been acknowledged,
+	// default 1s
+	Timeout string `json:"timeout,omitempty"`
+
+	// Retries is how many more times a commit is sent before the transaction is left blocked, holding the locks of the
+	// participants that haven't committed, default 3
+	Retries int `json:"retries,omitempty"`
+}
+
+// TwoPhaseTransaction is a type of transaction and the services that take part in it
+type TwoPhaseTransaction struct {
+	// Name is the body of the requests that start this type of transaction, empty for any body no other type has
+	Name string `json:"name,omitempty"`
+
+	// Participants are the services that vote, each one a dependency of the coordinator
+	Participants []string `json:"participants"`
+
+	// Aborts is the fraction of prepares a participant votes no to, like a constraint the transaction would break
+	Aborts float64 `json:"aborts,omitempty"`
+}
+
+// ExternalConfig is how an external service behaves, outside the control of the architecture that calls it
+type ExternalConfig struct {
+	// Rate limits the requests per second the API accepts across all its instances, the rest fail with a 429, zero for no limit
+	Rate float64 `json:"rate,omitempty"`
+
+	// Burst is how many requests over the rate can be accepted at once after a quiet spell, default a second's worth
+	Burst int `json:"burst,omitempty"`
+
+	// Latency is the mean response time of the API, e.g. 80ms
+	Latency string `json:"latency,omitempty"`
+
+	// Distribution of response times, fixed, uniform (0 to twice the mean) or exponential, default exponential
+	Distribution string `json:"distribution,omitempty"`
+
+	// Outages are the times the API is down, when every request to it fails with a 503
+	Outages []ExternalOutage `json:"outages,omitempty"`
+}
+
+// ExternalOutage is an outage of an external service that Starts after the architecture starts running and lasts for a Duration
+type ExternalOutage struct {
+	Start    string `json:"start"`
+	Duration string `json:"duration"`
+}
+
+// StartupConfig is the cold start of each instance of a service, at the start of the run or when autoscaling or a deployment adds it
+type StartupConfig struct {
+	// Latency added to calls to an instance as soon as it has started, e.g. 50ms
+	Latency string `json:"latency"`
+
+	// Warm is how long it takes the added latency to fall steadily to nothing, e.g. 10s
+	Warm string `json:"warm"`
+}
+
+// HealthConfig weights the signals that make up the health score of each instance of a service, from 0 for healthy to 1
+type HealthConfig struct {
+	// Latency, Errors and Inflight are the weights of the recent response time, fraction of failures and requests in flight
+	Latency  float64 `json:"latency,omitempty"`
+	Errors   float64 `json:"errors,omitempty"`
+	Inflight float64 `json:"inflight,omitempty"`
+
+	// Target is the response time that counts as fully unhealthy in the latency signal, e.g. 50ms
+	Target string `json:"target,omitempty"`
+
+	// Concurrency is the number of requests in flight that count as fully unhealthy, default 10
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Window the latency and errors are averaged over, default 1s
+	Window string `json:"window,omitempty"`
+}
+
+// CachingConfig is the caching pattern of a cache service
+type CachingConfig struct {
+	// Pattern is aside, where callers go to the origin themselves after a miss, readthrough, where the cache fetches a miss from
+	// its origin, or writebehind, which also reads through and takes the writes, flushing them to the origin later
+	Pattern string `json:"pattern"`
+
+	// Flush is how long a write behind waits before it's written to the origin, later writes to the same key in that time
+	// replace it, default 100ms. Writes that haven't been flushed are lost if the instance fails
+	Flush string `json:"flush,omitempty"`
+
+	// Capacity is how many keys each instance holds, a new key evicts one once it's full, default no limit
+	Capacity int `json:"capacity,omitempty"`
+
+	// Eviction picks the key to evict, lru the least recently used, lfu the least frequently used or fifo the first cached,
+	// default lru
+	Eviction string `json:"eviction,omitempty"`
+
+	// Warm is how long an instance takes to warm up once it's cold, after it starts, restarts after running out of memory or is
+	// flushed, when it holds no keys. While it warms a get for a key it holds only hits with the fraction of the warm period
+	// that has gone, as the rest of its working set is still being loaded, default the hit ratio only comes from the keys
+	Warm string `json:"warm,omitempty"`
+
+	// Flushes are times after the architecture starts running that every instance drops all its keys, as a deployment or a
+	// flush of the cache would
+	Flushes []string `json:"flushes,omitempty"`
+}
+
+// LeaderConfig is the cluster that elects a leader, writes to any other instance are passed on to the leader
+type LeaderConfig struct {
+	// Size is how many instances vote, electing a leader needs a majority of them, default the most the cluster has had
+	Size int `json:"size,omitempty"`
+
+	// Election is how long it takes to elect a new leader after the leader fails, default 1s
+	Election string `json:"election,omitempty"`
+
+	// Writes that arrive while there's no leader fail, or block until there is one, default fail
+	Writes string `json:"writes,omitempty"`
+}
+
+// CoalesceConfig is what makes requests identical, and how long one in flight can be waited for
+type CoalesceConfig struct {
+	// Key is a baggage item whose value identifies identical requests, or the request body if it's empty
+	Key string `json:"key,omitempty"`
+
+	// Window is how long after a request is passed on that identical ones wait for it, default 1s
+	Window string `json:"window,omitempty"`
+}
+
+// MemoryConfig is the modeled memory limit of each instance of a service, and how its footprint grows
+type MemoryConfig struct {
+	// Limit of the footprint in MB, going over it fails every request in flight and restarts the instance
+	Limit float64 `json:"limit"`
+
+	// Request is the MB each request adds to the footprint
+	Request float64 `json:"request"`
+
+	// Model is inflight for a footprint of the requests currently in flight, or cumulative for one that leaks every request
+	// until the instance restarts, default inflight
+	Model string `json:"model,omitempty"`
+
+	// Restart is the cold start time after running out of memory, requests fail until it's up again, default 1s
+	Restart string `json:"restart,omitempty"`
+
+	// Slowdown is the latency a cumulative footprint adds to each call to the instance once it reaches the limit, in
+	// proportion to the footprint below it, as a leaking instance spends more and more time collecting garbage, e.g. 50ms
+	Slowdown string `json:"slowdown,omitempty"`
+}
+
+// SidecarConfig is the overhead of a service mesh proxy, and the resilience it adds to the calls out of a service
+type SidecarConfig struct {
+	// Latency added each time a call or its response passes through the proxy, e.g. 1ms
+	Latency string `json:"latency,omitempty"`
+
+	// Handshake is the mTLS cost of the first call from an instance to each instance it calls, e.g. 5ms
+	Handshake string `json:"handshake,omitempty"`
+
+	// Retries is how many times the proxy retries a failed call, on another instance of the dependency if there is one
+	Retries int `json:"retries,omitempty"`
+
+	// Breaker is how many failures in a row from an instance open the circuit to it, so calls go to other instances
+	Breaker int `json:"breaker,omitempty"`
+
+	// Open is how long a circuit stays open before the instance is tried again, default 5s
+	Open string `json:"open,omitempty"`
+
+	// Budget limits retries to a fraction of the calls made over the last Window, e.g. 0.2, so retries can't pile on
+	// to an overloaded dependency. Zero lets every failed call retry
+	Budget float64 `json:"budget,omitempty"`
+
+	// Window the retry budget is counted over, default 10s
+	Window string `json:"window,omitempty"`
+}
+
+// GCConfig is the time between pauses and how long each pause lasts
+type GCConfig struct {
+	// Interval between the start of each pause, e.g. 2s
+	Interval string `json:"interval"`
+
+	// Pause is the mean pause duration, e.g. 50ms
+	Pause string `json:"pause"`
+
+	// Distribution of pause durations, fixed, uniform (0 to twice the mean) or exponential, default exponential
+	Distribution string `json:"distribution,omitempty"`
+}
+
+// AutoscaleConfig is a target tracking policy driven by the p99 response time, request rate or concurrency of a service group
+type AutoscaleConfig struct {
+	// Target p99 response time, scale up when it is exceeded, scale down when below half of it, e.g. 50ms
+	Target string `json:"target"`
+
+	// Min and Max instance counts, default to the initial count and four times the initial count
+	Min int `json:"min,omitempty"`
+	Max int `json:"max,omitempty"`
+
+	// Interval between scaling decisions, default 1s
+	Interval string `json:"interval,omitempty"`
+
+	// Up and Down are the consecutive intervals needed before scaling in each direction, default 2 and 4
+	Up   int `json:"up,omitempty"`
+	Down int `json:"down,omitempty"`
+
+	// Queue names a workqueue service to scale on the Depth of, instead of the response time Target
+	Queue string `json:"queue,omitempty"`
+	Depth int    `json:"depth,omitempty"`
+
+	// Health is a mean health score of the group to scale on instead, for a service with a health config, e.g. 0.5
+	Health float64 `json:"health,omitempty"`
+
+	// RPS is a target request rate per instance to scale on instead, in requests per second, e.g. 50
+	RPS float64 `json:"rps,omitempty"`
+
+	// Concurrency is a target for the requests each instance has in flight to scale on instead, e.g. 4
+	Concurrency float64 `json:"concurrency,omitempty"`
+
+	// Cooldown after each scaling event before the next one, so new instances have time to take load, e.g. 5s
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+// QueueConfig configures a workqueue service
+type QueueConfig struct {
+	// Visibility is how long a consumer has to process an item before it is given to another consumer, default 1s
+	Visibility string `json:"visibility,omitempty"`
+
+	// Retries is how many times a failed item is requeued before going to the dead letters, default 3
+	Retries int `json:"retries,omitempty"`
+
+	// Concurrency is the number of items each consumer instance processes at a time, default 1
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// ReplicationConfig configures the replicas of a store service, which lists itself as a dependency to find them
+type ReplicationConfig struct {
+	// Mode is sync, a write completes when every replica has acknowledged it, async, a write completes straight away, or
+	// quorum, a write completes when W of the N copies have it and a read when R of them have replied
+	Mode string `json:"mode"`
+
+	// Replicas is how many other instances each write is copied to, default all of them
+	Replicas int `json:"replicas,omitempty"`
+
+	// Lag delays each async copy, e.g. 50ms, as if replication was batched
+	Lag string `json:"lag,omitempty"`
+
+	// W is how many copies a quorum write waits for, counting the one this instance keeps, default a majority of the N,
+	// which is the replicas and this instance
+	W int `json:"w,omitempty"`
+
+	// R is how many copies a quorum read waits for, counting the one this instance keeps, default a majority of the N
+	R int `json:"r,omitempty"`
+}
+
+// EdgeConfig holds optional behavior for calls from a service to one of its dependencies
+type EdgeConfig struct {
+	// When only routes calls to this dependency if the request baggage contains this key=value item
+	When string `json:"when,omitempty"`
+
+	// Flag only routes calls to this dependency while the feature flag is on, or while it's off if it starts with !, e.g. !newrecs
+	Flag string `json:"flag,omitempty"`
+
+	// Latency is added to each call, and it fails fast if less than this remains before the request deadline
+	Latency string `json:"latency,omitempty"`
+
+	// Response latency is added to the reply separately from the request Latency, e.g. for large response payloads
+	Response string `json:"response,omitempty"`
+
+	// Timeout returns a failure if there is no response to a call in time
+	Timeout string `json:"timeout,omitempty"`
+
+	// Weight splits the traffic between the dependencies that have one, e.g. 90 and 10 for a canary
+	Weight int `json:"weight,omitempty"`
+
+	// Connections limits the calls each instance has in flight to this dependency, excess calls wait for a free connection
+	Connections int `json:"connections,omitempty"`
+
+	// Balance is adaptive to pick the faster of two random instances, by recent latency and calls in flight, instead of any instance,
+	// or sticky to send each session to the same instance for as long as the instances stay the same
+	Balance string `json:"balance,omitempty"`
+
+	// Rehome is added to the first call of a session after it moves to another instance of a sticky dependency, e.g. 50ms
+	// to reload its state and re-authenticate
+	Rehome string `json:"rehome,omitempty"`
+
+	// Window is how quickly the adaptive latency average forgets old responses, default 1s
+	Window string `json:"window,omitempty"`
+
+	// Fallback is the degraded response given instead of failing when a call to this dependency fails or times out, e.g. stale data
+	Fallback string `json:"fallback,omitempty"`
+
+	// Format is the serialization format of the messages, json, protobuf, avro or thrift, or one with a serdes.<format> keyval,
+	// each message costs the sender time to serialize it and the receiver time to deserialize it, scaled by its payload
+	Format string `json:"format,omitempty"`
+
+	// Payload is the size of each request in bytes, default 1024 when there's a Format
+	Payload int `json:"payload,omitempty"`
+
+	// ResponsePayload is the size of each response in bytes, default the same as Payload
+	ResponsePayload int `json:"responsepayload,omitempty"`
+
+	// Mirror is a shadow service, also a dependency, that gets a copy of the calls to this one and whose responses are discarded
+	Mirror string `json:"mirror,omitempty"`
+
+	// MirrorFraction of the calls are copied to the Mirror, default all of them
+	MirrorFraction float64 `json:"mirrorfraction,omitempty"`
+
+	// Fanout is how many calls each request routed to this dependency makes to it in parallel, the response waits for all of them
+	Fanout int `json:"fanout,omitempty"`
+
+	// Warmup is the extra latency of the first call over a new connection to an instance of this dependency, it halves with each
+	// call after that until the connection is warm, as in TCP slow start
+	Warmup string `json:"warmup,omitempty"`
+
+	// WarmupCalls is how many calls it takes to warm up a connection, default 4
+	WarmupCalls int `json:"warmupcalls,omitempty"`
+
+	// KeepAlive is how long an idle connection is kept open, after that the next call opens a new one that has to warm up again,
+	// default forever
+	KeepAlive string `json:"keepalive,omitempty"`
+
+	// Backoff is how long a sidecar waits before retrying a failed call to this dependency, fixed at the base, exponential doubling
+	// from the base each retry, jitter for a random wait up to the exponential one, or decorrelated for a random wait from the base
+	// up to three times the last one. Every wait is limited to the cap. Without a backoff retries are made straight away
+	Backoff string `json:"backoff,omitempty"`
+
+	// BackoffBase and BackoffCap are the shortest and longest backoff, default 10ms and 1s
+	BackoffBase string `json:"backoffbase,omitempty"`
+	BackoffCap  string `json:"backoffcap,omitempty"`
+
+	// Pages is how many calls one request to this dependency takes, made one after another as each page of the result comes back,
+	// default 1 for no pagination
+	Pages int `json:"pages,omitempty"`
+
+	// PageLatency is added to every page call, e.g. 5ms for a dependency that is slow to fetch each page
+	PageLatency string `json:"pagelatency,omitempty"`
+
+	// Batch is the most calls to this dependency that are sent together as one batch call, like a multi-get, default 1 for no batching
+	Batch int `json:"batch,omitempty"`
+
+	// BatchWindow is how long a batch waits for more calls to join it after the first, default 5ms
+	BatchWindow string `json:"batchwindow,omitempty"`
+
+	// BatchMaxWait is the most the first call waits for its batch to go, e.g. 20ms, once it's set each call that joins the
+	// batch holds it open for another batchwindow, so a steady trickle of calls batches more at the cost of more latency
+	BatchMaxWait string `json:"batchmaxwait,omitempty"`
+
+	// BatchScale is the power of the number of calls the edge latency of a batch is scaled by, from 0 for a batch that costs
+	// no more than one call to 1 for no saving, default 0.5
+	BatchScale float64 `json:"batchscale,omitempty"`
+
+	// Speculate is the fraction of the calls to this dependency that also start a speculative call to another instance of it,
+	// which is cancelled once the primary call succeeds and answers instead if it fails
+	Speculate float64 `json:"speculate,omitempty"`
+
+	// SpeculateAfter only starts the speculative call if the primary hasn't answered by then, default straight away
+	SpeculateAfter string `json:"speculateafter,omitempty"`
+
+	// CancelWork is the fraction of a speculative call's work the dependency has already done when it's cancelled, which is
+	// wasted, default 0.5
+	CancelWork float64 `json:"cancelwork,omitempty"`
+
+	// Breaker is the fraction of the calls from an instance to this dependency over the last BreakerWindow that have to fail to
+	// open its circuit breaker, e.g. 0.5. As in Hystrix, calls then fail fast without being sent until BreakerSleep is up, and
+	// a trial call is let through that closes the circuit if it succeeds and opens it again if it fails
+	Breaker float64 `json:"breaker,omitempty"`
+
+	// BreakerVolume is the fewest calls in the window before the breaker can open, default 20
+	BreakerVolume int `json:"breakervolume,omitempty"`
+
+	// BreakerWindow the failures are counted over, default 10s, and BreakerSleep how long the circuit stays open, default 5s
+	BreakerWindow string `json:"breakerwindow,omitempty"`
+	BreakerSleep  string `json:"breakersleep,omitempty"`
+
+	// Group makes the dependency, which has to be a topic, one the service consumes from as a member of the named consumer
+	// group, instead of publishing to it. Every group gets each message, shared between the instances in the group
+	Group string `json:"group,omitempty"`
+}
+
+// EdgeKey is an override from keyvals of the form edge.<from>-><to>.<param>:value
+type EdgeKey struct {
+	From, To, Param, Value string
+}
+
+// Set changes one parameter of an edge to a keyval value, the way edge.<from>-><to>.<param>:value keyvals do
+func (e *EdgeConfig) Set(param, value string) error {
+	switch param {
+	case "latency":
+		e.Latency = value
+	case "response":
+		e.Response = value
+	case "timeout":
+		e.Timeout = value
+	case "when":
+		e.When = value
+	case "flag":
+		e.Flag = value
+	case "weight":
+		w, err := strconv.Atoi(value)
+		if err != nil || w < 0 {
+			return fmt.Errorf("bad weight %v", value)
+		}
+		e.Weight = w
+	case "connections":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad connections %v", value)
+		}
+		e.Connections = n
+	case "balance":
+		e.Balance = value
+	case "rehome":
+		e.Rehome = value
+	case "window":
+		e.Window = value
+	case "fallback":
+		e.Fallback = value
+	case "format":
+		e.Format = value
+	case "mirror":
+		e.Mirror = value
+	case "mirrorfraction":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 1 {
+			return fmt.Errorf("bad mirrorfraction %v", value)
+		}
+		e.MirrorFraction = f
+	case "fanout":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad fanout %v", value)
+		}
+		e.Fanout = n
+	case "warmup":
+		e.Warmup = value
+	case "warmupcalls":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad warmupcalls %v", value)
+		}
+		e.WarmupCalls = n
+	case "keepalive":
+		e.KeepAlive = value
+	case "backoff":
+		e.Backoff = value
+	case "backoffbase":
+		e.BackoffBase = value
+	case "backoffcap":
+		e.BackoffCap = value
+	case "pages":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad pages %v", value)
+		}
+		e.Pages = n
+	case "pagelatency":
+		e.PageLatency = value
+	case "batch":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad batch %v", value)
+		}
+		e.Batch = n
+	case "batchwindow":
+		e.BatchWindow = value
+	case "batchmaxwait":
+		e.BatchMaxWait = value
+	case "batchscale":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 1 {
+			return fmt.Errorf("bad batchscale %v", value)
+		}
+		e.BatchScale = f
+	case "speculate", "cancelwork":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 1 {
+			return fmt.Errorf("bad %v %v", param, value)
+		}
+		if param == "speculate" {
+			e.Speculate = f
+		} else {
+			e.CancelWork = f
+		}
+	case "speculateafter":
+		e.SpeculateAfter = value
+	case "breaker":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 1 {
+			return fmt.Errorf("bad breaker %v", value)
+		}
+		e.Breaker = f
+	case "breakervolume":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad breakervolume %v", value)
+		}
+		e.BreakerVolume = n
+	case "breakerwindow":
+		e.BreakerWindow = value
+	case "breakersleep":
+		e.BreakerSleep = value
+	case "group":
+		e.Group = value
+	case "payload", "responsepayload":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad %v %v", param, value)
+		}
+		if param == "payload" {
+			e.Payload = n
+		} else {
+			e.ResponsePayload = n
+		}
+	default:
+		return fmt.Errorf("unknown edge parameter %v", param)
+	}
+	return nil
+}
+
+// services maps service names to their config, updated while the architecture is being created
+var services = make(map[string]ServiceConfig)
+var serviceLock sync.RWMutex
+
+// SetService saves the config for a service
+func SetService(name string, s ServiceConfig) {
+	serviceLock.Lock()
+	services[name] = s
+	serviceLock.Unlock()
+}
+
+// Service finds the config for a service, returns an empty config if there isn't one
+func Service(name string) ServiceConfig {
+	serviceLock.RLock()
+	defer serviceLock.RUnlock()
+	return services[name]
+}
+
+// Edge finds the config for calls from one service to another
+func Edge(from, to string) EdgeConfig {
+	return Service(from).Edges[to]
+}
+
+// Partition cuts the network between groups of regions for a while, a region in one group can't reach a region in
+// another group, regions that aren't listed in any group are unaffected
+type Partition struct {
+	// Groups of region names, e.g. [["us-east-1"], ["us-west-2", "eu-west-1"]]
+	Groups [][]string `json:"groups"`
+
+	// Start time after the architecture starts running, and Duration of the partition, e.g. 2s and 3s
+	Start    string `json:"start"`
+	Duration string `json:"duration"`
+
+	start, end time.Duration
+}
+
+// partitions are counted from when they are set
+var partitions []Partition
+var partitioned time.Time
+var partitionLock sync.RWMutex
+
+// SetPartitions saves the partition schedule and starts its clock
+func SetPartitions(p []Partition) {
+	partitionLock.Lock()
+	defer partitionLock.Unlock()
+	partitions = make([]Partition, len(p))
+	for i := range p {
+		partitions[i] = p[i]
+		partitions[i].start, _ = time.ParseDuration(p[i].Start)
+		d, _ := time.ParseDuration(p[i].Duration)
+		partitions[i].end = partitions[i].start + d
+	}
+	partitioned = clock.Now()
+}
+
+// Partitions is the schedule of network partitions
+func Partitions() []Partition {
+	partitionLock.RLock()
+	defer partitionLock.RUnlock()
+	return partitions
+}
+
+// group finds the group a region is in, or -1 if it isn't in any group
+func (p Partition) group(region string) int {
+	for i, g := range p.Groups {
+		for _, r := range g {
+			if r == region {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Partitioned is true if there is currently a network partition between two regions
+func Partitioned(from, to string) bool {
+	if from == to {
+		return false
+	}
+	partitionLock.RLock()
+	defer partitionLock.RUnlock()
+	if len(partitions) == 0 {
+		return false
+	}
+	now := clock.Since(partitioned)
+	for _, p := range partitions {
+		if now < p.start || now >= p.end {
+			continue
+		}
+		f, t := p.group(from), p.group(to)
+		if f >= 0 && t >= 0 && f != t {
+			return true
+		}
+	}
+	return false
+}
+
+// Journey is a named sequence of requests a user makes one after another, like browse then play, each started at its own rate
+type Journey struct {
+	Name  string        `json:"name"`
+	Rate  string        `json:"rate"` // time between starting each journey, e.g. 100ms
+	Steps []JourneyStep `json:"steps"`
+}
+
+// JourneyStep is one request in a journey, made when the response to the step before it arrives
+type JourneyStep struct {
+	Service string `json:"service,omitempty"` // entry point service to send it to, any of them if it's empty
+	Request string `json:"request,omitempty"` // body of the request
+}
+
+var journeys []Journey
+var journeyLock sync.RWMutex
+
+// SetJourneys saves the journeys that drive traffic into the architecture instead of the uniform chat rate
+func SetJourneys(j []Journey) {
+	journeyLock.Lock()
+	defer journeyLock.Unlock()
+	journeys = j
+}
+
+// Journeys are the user journeys in the architecture, nil if traffic is sent at the chat rate
+func Journeys() []Journey {
+	journeyLock.RLock()
+	defer journeyLock.RUnlock()
+	return journeys
+}
+
+// Probe is a synthetic monitor that sends the same Request to an entry point Service every Interval, as a canary whose
+// latency is recorded apart from the organic traffic
+type Probe struct {
+	Name     string `json:"name"`
+	Service  string `json:"service,omitempty"` // entry point service to send it to, any of them if it's empty
+	Request  string `json:"request,omitempty"` // body of the request, the same every time, default probe
+	Interval string `json:"interval"`          // time between probes, e.g. 1s
+}
+
+var probes []Probe
+var probeLock sync.RWMutex
+
+// SetProbes saves the synthetic monitors of the architecture
+func SetProbes(p []Probe) {
+	probeLock.Lock()
+	defer probeLock.Unlock()
+	probes = p
+}
+
+// Probes are the synthetic monitors of the architecture, nil if it doesn't have any
+func Probes() []Probe {
+	probeLock.RLock()
+	defer probeLock.RUnlock()
+	return probes
+}
+
+// Entrypoint is a service the external traffic enters the architecture at, sent a request every Rate, e.g. 20ms, or at the
+// chat rate if it's empty. Ephemeral is the fraction of its requests from short lived clients, like serverless functions
+// or mobile apps, that open a new connection for each request and pay its Setup, the TCP and TLS handshakes, default 20ms.
+// Adaptive clients slow down when the entry point says it's overloaded, without it they keep sending at the rate regardless
+type Entrypoint struct {
+	Service   string  `json:"service"`
+	Rate      string  `json:"rate,omitempty"`
+	Ephemeral float64 `json:"ephemeral,omitempty"`
+	Setup     string  `json:"setup,omitempty"`
+	Adaptive  *AIMD   `json:"adaptive,omitempty"`
+}
+
+// AIMD is how adaptive clients change the rate they send at, an additive increase while their gets succeed, up to the rate of
+// the entry point, and a multiplicative decrease when one is rejected with a 429, 503 or by load shedding
+type AIMD struct {
+	Increase float64 `json:"increase,omitempty"` // requests per second the rate goes up by each second, default 1
+	Decrease float64 `json:"decrease,omitempty"` // the rate is multiplied by on a rejection, default 0.5
+	Min      string  `json:"min,omitempty"`      // slowest interval between requests, default 1s
+	Window   string  `json:"window,omitempty"`   // rejections this soon after a decrease don't decrease it again, default 100ms
+}
+
+var entrypoints []Entrypoint
+var entrypointLock sync.RWMutex
+
+// SetEntrypoints saves the services the traffic enters at
+func SetEntrypoints(e []Entrypoint) {
+	entrypointLock.Lock()
+	defer entrypointLock.Unlock()
+	entrypoints = e
+}
+
+// Entrypoints are the services the traffic enters at, nil if it's sent to any dependency of the last service in the architecture
+func Entrypoints() []Entrypoint {
+	entrypointLock.RLock()
+	defer entrypointLock.RUnlock()
+	return entrypoints
+}
+
+var ingress map[string]int
+var ingressLock sync.RWMutex
+
+// SetIngress saves the weight of the external traffic that lands in each region
+func SetIngress(i map[string]int) {
+	ingressLock.Lock()
+	defer ingressLock.Unlock()
+	ingress = i
+}
+
+// Ingress is the weight of the external traffic landing in each region, nil if it's spread evenly over the entry points
+func Ingress() map[string]int {
+	ingressLock.RLock()
+	defer ingressLock.RUnlock()
+	return ingress
+}
+
+// Zones sets up the availability zones in each region, and when whole zones fail
+type Zones struct {
+	// Count of zones in each region, up to the number of ZoneNames, default all of them
+	Count int `json:"count,omitempty"`
+
+	// Latency added to calls between instances in different zones of the same region, e.g. 1ms
+	Latency string `json:"latency,omitempty"`
+
+	// Outages terminate every instance in a zone
+	Outages []ZoneOutage `json:"outages,omitempty"`
+}
+
+// ZoneOutage fails a zone at Start after the architecture starts running, in one Region or in every region if it's empty
+type ZoneOutage struct {
+	Zone   string `json:"zone"`
+	Region string `json:"region,omitempty"`
+	Start  string `json:"start"`
+}
+
+var zoneLatency time.Duration
+var zoneOutages []ZoneOutage
+
+// SetZones limits the zones instances are placed in to the count, and saves the cross zone latency and outages
+func SetZones(z *Zones) {
+	if z == nil {
+		return
+	}
+	if z.Count > 0 && z.Count < len(Conf.ZoneNames) {
+		Conf.ZoneNames = Conf.ZoneNames[:z.Count]
+		for i := range Conf.IPRanges {
+			Conf.IPRanges[i] = Conf.IPRanges[i][:z.Count]
+		}
+	}
+	zoneLatency, _ = time.ParseDuration(z.Latency)
+	zoneOutages = z.Outages
+}
+
+// ZoneLatency is added to calls between zones of the same region
+func ZoneLatency() time.Duration {
+	return zoneLatency
+}
+
+// Outages is the schedule of zone failures
+func Outages() []ZoneOutage {
+	return zoneOutages
+}
+
+// WAN sets the latency of the network between regions
+type WAN struct {
+	// Latency one way between a pair of regions, keyed by the two region names either way round, e.g. {"us-east-1 eu-west-1": "40ms"},
+	// pairs that aren't listed use the defaults between the AWS regions, and 0 turns the latency off
+	Latency map[string]string `json:"latency,omitempty"`
+}
+
+// DefaultRegionLatency is the one way latency between each pair of the default RegionNames, about half the round trip time
+// between the AWS regions
+var DefaultRegionLatency = map[string]time.Duration{
+	"us-east-1 us-west-2":           35 * time.Millisecond,
+	"us-east-1 eu-west-1":           35 * time.Millisecond,
+	"us-east-1 eu-central-1":        45 * time.Millisecond,
+	"us-east-1 ap-southeast-1":      110 * time.Millisecond,
+	"us-east-1 ap-southeast-2":      100 * time.Millisecond,
+	"us-west-2 eu-west-1":           65 * time.Millisecond,
+	"us-west-2 eu-central-1":        75 * time.Millisecond,
+	"us-west-2 ap-southeast-1":      85 * time.Millisecond,
+	"us-west-2 ap-southeast-2":      70 * time.Millisecond,
+	"eu-west-1 eu-central-1":        12 * time.Millisecond,
+	"eu-west-1 ap-southeast-1":      85 * time.Millisecond,
+	"eu-west-1 ap-southeast-2":      130 * time.Millisecond,
+	"eu-central-1 ap-southeast-1":   80 * time.Millisecond,
+	"eu-central-1 ap-southeast-2":   125 * time.Millisecond,
+	"ap-southeast-1 ap-southeast-2": 45 * time.Millisecond,
+}
+
+var regionLatency map[string]time.Duration // overrides of the defaults, by both orders of the region names
+var regionLatencyLock sync.RWMutex
+
+// SetWAN saves the latencies between regions that replace the defaults
+func SetWAN(w *WAN) {
+	regionLatencyLock.Lock()
+	defer regionLatencyLock.Unlock()
+	regionLatency = nil
+	if w == nil {
+		return
+	}
+	regionLatency = make(map[string]time.Duration, 2*len(w.Latency))
+	for k, v := range w.Latency {
+		var from, to string
+		fmt.Sscanf(k, "%s%s", &from, &to)
+		d, _ := time.ParseDuration(v)
+		regionLatency[from+" "+to] = d
+		regionLatency[to+" "+from] = d
+	}
+}
+
+// RegionLatency is the one way latency of the network between two regions, zero within a region
+func RegionLatency(from, to string) time.Duration {
+	if from == to {
+		return 0
+	}
+	regionLatencyLock.RLock()
+	d, ok := regionLatency[from+" "+to]
+	regionLatencyLock.RUnlock()
+	if ok {
+		return d
+	}
+	if d, ok := DefaultRegionLatency[from+" "+to]; ok {
+		return d
+	}
+	return DefaultRegionLatency[to+" "+from]
+}
+
+// Evacuation moves the external traffic out of a Region from Start after the architecture starts running for Duration, e.g.
+// to fail over from a region that is in trouble. The instances keep running and still take calls from other regions
+type Evacuation struct {
+	Region   string `json:"region"`
+	Start    string `json:"start"`
+	Duration string `json:"duration"`
+
+	start, end time.Duration
+}
+
+// evacuations are counted from when they are set
+var evacuations []Evacuation
+var evacuated time.Time
+var evacuationLock sync.RWMutex
+
+// SetEvacuations saves the evacuation schedule and starts its clock
+func SetEvacuations(e []Evacuation) {
+	evacuationLock.Lock()
+	defer evacuationLock.Unlock()
+	evacuations = make([]Evacuation, len(e))
+	for i := range e {
+		evacuations[i] = e[i]
+		evacuations[i].start, _ = time.ParseDuration(e[i].Start)
+		d, _ := time.ParseDuration(e[i].Duration)
+		evacuations[i].end = evacuations[i].start + d
+	}
+	evacuated = clock.Now()
+}
+
+// Evacuations is the schedule of region evacuations
+func Evacuations() []Evacuation {
+	evacuationLock.RLock()
+	defer evacuationLock.RUnlock()
+	return evacuations
+}
+
+// Evacuated is true if the external traffic is currently being kept out of a region
+func Evacuated(region string) bool {
+	evacuationLock.RLock()
+	defer evacuationLock.RUnlock()
+	if len(evacuations) == 0 {
+		return false
+	}
+	now := clock.Since(evacuated)
+	for _, e := range evacuations {
+		if e.Region == region && now >= e.start && now < e.end {
+			return true
+		}
+	}
+	return false
+}
+
+// Correlation groups instances that fail together because they share something, a rack, a noisy neighbor or a dependency,
+// and schedules the events that slow down part of each group all at once
+type Correlation struct {
+	Groups []CorrelationGroup `json:"groups"`
+	Events []CorrelatedEvent  `json:"events"`
+}
+
+// CorrelationGroup is the Fraction of the instances of each of the Services that are hit by the same event, default 1
+type CorrelationGroup struct {
+	Name     string   `json:"name"`
+	Services []string `json:"services"`
+	Fraction float64  `json:"fraction,omitempty"`
+}
+
+// CorrelatedEvent adds Latency to calls to the instances it picks from a Group, from Start after the architecture
+// starts running for Duration, e.g. 200ms from 2s for 3s
+type CorrelatedEvent struct {
+	Group    string `json:"group"`
+	Start    string `json:"start"`
+	Duration string `json:"duration"`
+	Latency  string `json:"latency"`
+}
+
+var correlation *Correlation
+var degraded = make(map[string]time.Duration) // latency added to calls to each instance by the events that are happening
+var degradedLock sync.RWMutex
+
+// SetCorrelation saves the correlation groups and event schedule
+func SetCorrelation(c *Correlation) {
+	correlation = c
+}
+
+// CorrelatedEvents is the schedule of correlated latency events
+func CorrelatedEvents() []CorrelatedEvent {
+	if correlation == nil {
+		return nil
+	}
+	return correlation.Events
+}
+
+// CorrelationGroupOf finds a correlation group by name
+func CorrelationGroupOf(name string) (CorrelationGroup, bool) {
+	if correlation != nil {
+		for _, g := range correlation.Groups {
+			if g.Name == name {
+				return g, true
+			}
+		}
+	}
+	return CorrelationGroup{}, false
+}
+
+// Degrade adds latency to calls to the instances until Recover takes it away again, overlapping events add up
+func Degrade(instances []string, latency time.Duration) {
+	degradedLock.Lock()
+	defer degradedLock.Unlock()
+	for _, n := range instances {
+		degraded[n] += latency
+	}
+}
+
+// Recover ends the latency that Degrade added to the instances
+func Recover(instances []string, latency time.Duration) {
+	degradedLock.Lock()
+	defer degradedLock.Unlock()
+	for _, n := range instances {
+		degraded[n] -= latency
+		if degraded[n] <= 0 {
+			delete(degraded, n)
+		}
+	}
+}
+
+// Degraded is the latency currently added to calls to an instance
+func Degraded(name string) time.Duration {
+	degradedLock.RLock()
+	defer degradedLock.RUnlock()
+	return degraded[name]
+}
+
+var instanceStarts = make(map[string]time.Time) // when each instance was started
+var instanceSizes = make(map[string]int)        // index of the size of each instance of a service with sizes
+var sizeCounts = make(map[string][]int)         // instances started of each size, by service
+var instanceStartsLock sync.RWMutex
+
+// Started records that an instance has just started, so calls to it can be cold while it warms up
+func Started(instance string) {
+	instanceStartsLock.Lock()
+	defer instanceStartsLock.Unlock()
+	instanceStarts[instance] = clock.Now()
+}
+
+// PickSize picks the size of a new instance of a service with a mix of them, the size furthest behind its fraction of the
+// instances started so far, so the mix holds as instances are replaced or added
+func PickSize(instance, s string) {
+	sizes := Service(s).Sizes
+	if len(sizes) == 0 {
+		return
+	}
+	instanceStartsLock.Lock()
+	defer instanceStartsLock.Unlock()
+	if _, ok := instanceSizes[instance]; ok {
+		return // restarted, it's the same instance type
+	}
+	if sizeCounts[s] == nil {
+		sizeCounts[s] = make([]int, len(sizes))
+	}
+	total := 0
+	for _, n := range sizeCounts[s] {
+		total += n
+	}
+	pick, behind := 0, math.Inf(-1)
+	for i, z := range sizes {
+		if b := z.Fraction*float64(total+1) - float64(sizeCounts[s][i]); b > behind {
+			pick, behind = i, b
+		}
+	}
+	sizeCounts[s][pick]++
+	instanceSizes[instance] = pick
+}
+
+// Size of an instance of a service, nil if the service doesn't have a mix of sizes
+func Size(instance, s string) *InstanceSize {
+	instanceStartsLock.RLock()
+	i, ok := instanceSizes[instance]
+	instanceStartsLock.RUnlock()
+	sizes := Service(s).Sizes
+	if !ok || i >= len(sizes) {
+		return nil
+	}
+	return &sizes[i]
+}
+
+// StartedAt is when an instance was started, zero if it never was
+func StartedAt(name string) time.Time {
+	instanceStartsLock.RLock()
+	defer instanceStartsLock.RUnlock()
+	return instanceStarts[name]
+}
+
+// Deployment rolls a new Version of a Service out over its instances from Start after the architecture starts running, replacing
+// Batch of them at a time, default 1, and waiting Bake between batches, default 1s. Calls to the instances of the new version get
+// Latency added, and they fail at the Errors rate instead of the rate of the service, so the mix of versions can be seen in the flows.
+// A Strategy of bluegreen starts a whole green environment of the new version at Start instead, alongside the blue one, and
+// switches all the traffic to it at once after Bake. If more than the Rollback fraction of the responses of the service fail
+// within Watch of the cutover, default 5s, the traffic switches straight back to blue, otherwise blue is shut down after it
+type Deployment struct {
+	Service  string  `json:"service"`
+	Version  string  `json:"version"`
+	Start    string  `json:"start"`
+	Batch    int     `json:"batch,omitempty"`
+	Bake     string  `json:"bake,omitempty"`
+	Latency  string  `json:"latency,omitempty"`
+	Errors   float64 `json:"errors,omitempty"`
+	Strategy string  `json:"strategy,omitempty"`
+	Rollback float64 `json:"rollback,omitempty"`
+	Watch    string  `json:"watch,omitempty"`
+}
+
+var deployments []Deployment
+var deployed = make(map[string]*Deployment) // the deployment that started each instance of a new version
+var standby = make(map[string]bool)         // instances of a green environment that have no traffic yet
+var deployedLock sync.RWMutex
+
+// SetDeployments saves the schedule of rolling deployments
+func SetDeployments(d []Deployment) {
+	deployments = d
+}
+
+// Deployments is the schedule of rolling deployments
+func Deployments() []Deployment {
+	return deployments
+}
+
+// Deploy records that an instance was started with the new version of a deployment
+func Deploy(instance string, d *Deployment) {
+	deployedLock.Lock()
+	defer deployedLock.Unlock()
+	deployed[instance] = d
+}
+
+// Standby puts an instance on standby before it starts, so it doesn't register with the service registry, or takes it off
+func Standby(instance string, on bool) {
+	deployedLock.Lock()
+	defer deployedLock.Unlock()
+	standby[instance] = on
+}
+
+// OnStandby is true for an instance that shouldn't register with the service registry yet
+func OnStandby(instance string) bool {
+	deployedLock.RLock()
+	defer deployedLock.RUnlock()
+	return standby[instance]
+}
+
+// Deployed is the deployment that started an instance, or nil if it runs the version the service started with
+func Deployed(name string) *Deployment {
+	deployedLock.RLock()
+	defer deployedLock.RUnlock()
+	return deployed[name]
+}
+
+// Flag is a feature flag held by the featureflag services of the architecture, that edges with a flag route their calls by.
+// It starts On or off, and each change in its Schedule turns it on or off at a time after the architecture starts running
+type Flag struct {
+	Name     string       `json:"name"`
+	On       bool         `json:"on,omitempty"`
+	Schedule []FlagChange `json:"schedule,omitempty"`
+}
+
+// FlagChange turns a flag on or off At a time after the architecture starts running, e.g. 5s
+type FlagChange struct {
+	At string `json:"at"`
+	On bool   `json:"on"`
+}
+
+var flags []Flag
+var flagState = make(map[string]bool)
+var flagLock sync.RWMutex
+
+// SetFlags saves the feature flags and their schedules, and sets each one to its starting state
+func SetFlags(f []Flag) {
+	flagLock.Lock()
+	defer flagLock.Unlock()
+	flags = f
+	flagState = make(map[string]bool, len(f))
+	for _, fl := range f {
+		flagState[fl.Name] = fl.On
+	}
+}
+
+// Flags are the feature flags of the architecture
+func Flags() []Flag {
+	flagLock.RLock()
+	defer flagLock.RUnlock()
+	return flags
+}
+
+// SetFlag turns a feature flag on or off, and returns false if there's no such flag
+func SetFlag(name string, on bool) bool {
+	flagLock.Lock()
+	defer flagLock.Unlock()
+	if _, ok := flagState[name]; !ok {
+		return false
+	}
+	flagState[name] = on
+	return true
+}
+
+// FlagOn is the current state of a feature flag, and whether there is such a flag
+func FlagOn(name string) (on, ok bool) {
+	flagLock.RLock()
+	defer flagLock.RUnlock()
+	on, ok = flagState[name]
+	return on, ok
+}
+
+// KeyChange sets a keyval Key to a new Value At a time after the architecture starts running, e.g. chat to 5ms at 30s
+type KeyChange struct {
+	At    string `json:"at"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+var schedule []KeyChange
+var changedKeys = make(map[string]string) // keyvals set while running, which Key finds before the ones in the config
+var keyLock sync.RWMutex
+
+// SetSchedule saves the keyval changes of the architecture
+func SetSchedule(s []KeyChange) {
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	schedule = s
+}
+
+// Schedule is the keyval changes of the architecture
+func Schedule() []KeyChange {
+	keyLock.RLock()
+	defer keyLock.RUnlock()
+	return schedule
+}
+
+// SetKey changes a keyval while the architecture is running. An edge.<from>-><to>.<param> key changes the config of the
+// edge straight away, other keys are seen by the next Key that looks them up
+func SetKey(k, v string) error {
+	if strings.HasPrefix(k, "edge.") {
+		ek := EdgeKeys(Configuration{Keyvals: k + ":" + v})
+		if len(ek) != 1 {
+			return fmt.Errorf("badly formed edge key %v", k)
+		}
+		serviceLock.Lock()
+		defer serviceLock.Unlock()
+		s, ok := services[ek[0].From]
+		if !ok {
+			return fmt.Errorf("unknown service %v", ek[0].From)
+		}
+		edges := make(map[string]EdgeConfig, len(s.Edges)+1) // a copy, callers may be reading the old one
+		for to, e := range s.Edges {
+			edges[to] = e
+		}
+		e := edges[ek[0].To]
+		if err := e.Set(ek[0].Param, v); err != nil {
+			return err
+		}
+		edges[ek[0].To] = e
+		s.Edges = edges
+		services[ek[0].From] = s
+	}
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	changedKeys[k] = v
+	return nil
 }
 
 // Conf data instance
@@ -98,18 +1801,134 @@ func verifyConfig() {
 	}
 }
 
-// Key finds a value given a key
+// Seed is the -seed of the run, or -kv seed if that isn't set, and from the clock if neither is, for a different run every time
+func Seed() int64 {
+	if Conf.Seed != 0 {
+		return Conf.Seed
+	}
+	if seed, err := strconv.ParseInt(Key(Conf, "seed"), 10, 64); err == nil && seed != 0 {
+		return seed
+	}
+	return clockSeed
+}
+
+// lockedSource lets the random numbers of a node be drawn from timers as well as its own goroutine
+type lockedSource struct {
+	lock sync.Mutex
+	src  rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.src.Seed(seed)
+}
+
+var rands = make(map[string]*rand.Rand)
+var randLock sync.Mutex
+var clockSeed = time.Now().UnixNano()
+
+// Rand is the random numbers of a node or a feature, seeded from its name and -seed, or -kv seed, so it makes the same choices
+// every run however the goroutines are scheduled, or from the clock if neither is set
+func Rand(name string) *rand.Rand {
+	randLock.Lock()
+	defer randLock.Unlock()
+	r := rands[name]
+	if r == nil {
+		seed := Seed()
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		r = rand.New(&lockedSource{src: rand.NewSource(seed ^ int64(h.Sum64()))})
+		rands[name] = r
+	}
+	return r
+}
+
+// Key finds a value given a key, keyvals is a comma separated list of key:value pairs, and a key changed by SetKey while
+// the architecture is running has its new value
 func Key(c Configuration, k string) string {
+	keyLock.RLock()
+	v, ok := changedKeys[k]
+	keyLock.RUnlock()
+	if ok {
+		return v
+	}
 	if c.Keyvals == "" {
 		return ""
 	}
-	kv := strings.Split(c.Keyvals, ":")
-	if len(kv) == 2 && kv[0] == k {
-		return kv[1]
+	for _, kv := range strings.Split(c.Keyvals, ",") {
+		p := strings.SplitN(kv, ":", 2)
+		if len(p) == 2 && p[0] == k {
+			return p[1]
+		}
 	}
 	return ""
 }
 
+// EurekaTTL is how long an instance learned from eureka is used for without eureka confirming it again,
+// from the eurekattl keyval, zero if cached instances never expire
+func EurekaTTL() time.Duration {
+	ttl, _ := time.ParseDuration(Key(Conf, "eurekattl"))
+	return ttl
+}
+
+// serdesCosts is the default time to serialize and deserialize a kilobyte in each format, text formats cost more than binary ones
+var serdesCosts = map[string][2]time.Duration{
+	"json":     {10 * time.Microsecond, 20 * time.Microsecond},
+	"protobuf": {2 * time.Microsecond, 3 * time.Microsecond},
+	"avro":     {3 * time.Microsecond, 4 * time.Microsecond},
+	"thrift":   {2 * time.Microsecond, 4 * time.Microsecond},
+}
+
+// Serdes is the time to serialize and deserialize a kilobyte in a format, the serdes.<format>:<serialize>/<deserialize> keyval
+// overrides the defaults or adds a format, e.g. serdes.json:5us/8us. It's false if the format is unknown or the keyval is badly formed
+func Serdes(format string) (serialize, deserialize time.Duration, ok bool) {
+	if kv := Key(Conf, "serdes."+format); kv != "" {
+		p := strings.SplitN(kv, "/", 2)
+		if len(p) != 2 {
+			return 0, 0, false
+		}
+		s, serr := time.ParseDuration(p[0])
+		d, derr := time.ParseDuration(p[1])
+		if serr != nil || derr != nil || s < 0 || d < 0 {
+			return 0, 0, false
+		}
+		return s, d, true
+	}
+	c, ok := serdesCosts[format]
+	return c[0], c[1], ok
+}
+
+// EdgeKeys finds all the edge.<from>-><to>.<param>:value overrides in keyvals
+func EdgeKeys(c Configuration) (edges []EdgeKey) {
+	if c.Keyvals == "" {
+		return nil
+	}
+	for _, kv := range strings.Split(c.Keyvals, ",") {
+		p := strings.SplitN(kv, ":", 2)
+		if len(p) != 2 || !strings.HasPrefix(p[0], "edge.") {
+			continue
+		}
+		ft := strings.SplitN(strings.TrimPrefix(p[0], "edge."), "->", 2) // from->to.param
+		dot := -1
+		if len(ft) == 2 {
+			dot = strings.LastIndex(ft[1], ".")
+		}
+		if dot < 0 {
+			log.Printf("archaius: ignoring badly formed edge key %v, expected edge.<from>-><to>.<param>\n", p[0])
+			continue
+		}
+		edges = append(edges, EdgeKey{ft[0], ft[1][:dot], ft[1][dot+1:], p[1]})
+	}
+	return edges
+}
+
 // ReadConf parses json from a file
 func ReadConf(config string) {
 	fn := "json_arch/" + config + "_conf.json"
@@ -147,5 +1966,5 @@ func FromJson(confJSON []byte) {
 
 // return formatted as string
 func (Configuration) String() string {
-	return fmt.Sprintf("Arch:       %v\nGraphML:    %v\nGraphJSON:  %v\nNeo4jURL:   %v\nRunDuration:%v\nDunbar:     %v\nPopulation: %v\nMsglog:     %v\nRegions:    %v\nRegionNames:%v\nZoneNames:  %v\nIPRanges:   %v\nCollect:    %v\nKafka:      %v\nStopStep:   %v\nEurekaPoll: %v\nKeyvals:    %v\n", Conf.Arch, Conf.GraphmlFile, Conf.GraphjsonFile, Conf.Neo4jURL, Conf.RunDuration, Conf.Dunbar, Conf.Population, Conf.Msglog, Conf.Regions, Conf.RegionNames, Conf.ZoneNames, Conf.IPRanges, Conf.Collect, Conf.Kafka, Conf.StopStep, Conf.EurekaPoll, Conf.Keyvals)
+	return fmt.Sprintf("Arch:       %v\nGraphML:    %v\nGraphJSON:  %v\nNeo4jURL:   %v\nRunDuration:%v\nDunbar:     %v\nPopulation: %v\nMsglog:     %v\nRegions:    %v\nRegionNames:%v\nZoneNames:  %v\nIPRanges:   %v\nCollect:    %v\nKafka:      %v\nZipkin:     %v\nOTLP:       %v\nStopStep:   %v\nEurekaPoll: %v\nKeyvals:    %v\nRunName:    %v\nLabels:     %v\n", Conf.Arch, Conf.GraphmlFile, Conf.GraphjsonFile, Conf.Neo4jURL, Conf.RunDuration, Conf.Dunbar, Conf.Population, Conf.Msglog, Conf.Regions, Conf.RegionNames, Conf.ZoneNames, Conf.IPRanges, Conf.Collect, Conf.Kafka, Conf.Zipkin, Conf.OTLP, Conf.StopStep, Conf.EurekaPoll, Conf.Keyvals, Conf.RunName, Conf.Labels)
 }
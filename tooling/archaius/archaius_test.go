@@ -26,4 +26,58 @@ func TestConf(t *testing.T) {
 	FromJson(AsJson())
 	fmt.Println(Conf)
 	fmt.Println("chat = " + Key(Conf, "chat"))
+	Conf.Keyvals = "chat:0.01s,edge.homepage->subscriber.latency:200ms,edge.bad:1s"
+	if Key(Conf, "chat") != "0.01s" {
+		t.Fail()
+	}
+	fmt.Println(EdgeKeys(Conf))
+	if e := EdgeKeys(Conf); len(e) != 1 || e[0] != (EdgeKey{"homepage", "subscriber", "latency", "200ms"}) {
+		t.Fail()
+	}
+}
+
+func TestSetKey(t *testing.T) {
+	SetService("app", ServiceConfig{Edges: map[string]EdgeConfig{"store": {Timeout: "1s"}}})
+	before := Service("app").Edges
+	if err := SetKey("edge.app->store.timeout", "50ms"); err != nil || Edge("app", "store").Timeout != "50ms" || before["store"].Timeout != "1s" {
+		t.Fatal(err, Edge("app", "store"))
+	}
+	if SetKey("edge.app->store.weight", "-1") == nil || SetKey("edge.nosuch->store.timeout", "1s") == nil {
+		t.Error("bad edge keys should fail")
+	}
+	Conf.Keyvals = "deadline:1s"
+	SetKey("deadline", "200ms")
+	if Key(Conf, "deadline") != "200ms" {
+		t.Error(Key(Conf, "deadline"))
+	}
+}
+
+func TestRand(t *testing.T) {
+	Conf.Seed = 42
+	defer func() { Conf.Seed = 0 }()
+	a, b := Rand("seeded.a").Int63(), Rand("seeded.b").Int63()
+	delete(rands, "seeded.a")
+	if Rand("seeded.a").Int63() != a {
+		t.Error("the same seed and name should give the same numbers")
+	}
+	if a == b {
+		t.Error("different names should give different numbers")
+	}
+	if Seed() != 42 {
+		t.Error(Seed())
+	}
+}
+
+func TestRegionLatency(t *testing.T) {
+	defer SetWAN(nil)
+	if RegionLatency("eu-west-1", "us-east-1") != 35*time.Millisecond || RegionLatency("us-east-1", "us-east-1") != 0 {
+		t.Error("default latency either way round", RegionLatency("eu-west-1", "us-east-1"))
+	}
+	SetWAN(&WAN{Latency: map[string]string{"us-east-1 eu-west-1": "40ms", "us-west-2 us-east-1": "0s"}})
+	if RegionLatency("eu-west-1", "us-east-1") != 40*time.Millisecond || RegionLatency("us-east-1", "us-west-2") != 0 {
+		t.Error("latency not overridden", RegionLatency("eu-west-1", "us-east-1"), RegionLatency("us-east-1", "us-west-2"))
+	}
+	if RegionLatency("us-east-1", "ap-southeast-2") != 100*time.Millisecond || RegionLatency("us-east-1", "mars-1") != 0 {
+		t.Error("pairs that aren't overridden use the defaults", RegionLatency("us-east-1", "ap-southeast-2"))
+	}
 }
@@ -3,25 +3,43 @@
 package architecture
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/adrianco/spigo/actors/packagenames" // name definitions
 	"github.com/adrianco/spigo/tooling/archaius"    // global configuration
 	"github.com/adrianco/spigo/tooling/asgard"      // tools to create an architecture
+	"github.com/adrianco/spigo/tooling/chaosmonkey" // terminate instances at random
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
+	"strings"
 	"time"
 )
 
 type archV0r1 struct {
-	Arch        string          `json:"arch"`
-	Version     string          `json:"version"`
-	Description string          `json:"description,omitempty"`
-	Args        string          `json:"args,omitempty"`
-	Date        string          `json:"date,omitempty"`
-	Victim      string          `json:"victim,omitempty"`
-	Services    []containerV0r0 `json:"services"`
+	Arch        string                  `json:"arch"`
+	Version     string                  `json:"version"`
+	Description string                  `json:"description,omitempty"`
+	Args        string                  `json:"args,omitempty"`
+	Date        string                  `json:"date,omitempty"`
+	Victim      string                  `json:"victim,omitempty"`
+	Partitions  []archaius.Partition    `json:"partitions,omitempty"`
+	Chaos       *chaosmonkey.Config     `json:"chaos,omitempty"`
+	Zones       *archaius.Zones         `json:"zones,omitempty"`
+	WAN         *archaius.WAN           `json:"wan,omitempty"` // latency between regions, instead of the defaults
+	Correlated  *archaius.Correlation   `json:"correlated,omitempty"`
+	Sidecar     *archaius.SidecarConfig `json:"sidecar,omitempty"` // for every service that doesn't have its own
+	DNS         *archaius.DNSConfig     `json:"dns,omitempty"`     // for every service that doesn't have its own
+	Journeys    []archaius.Journey      `json:"journeys,omitempty"`
+	Ingress     map[string]int          `json:"ingress,omitempty"` // weight of the external traffic landing in each region
+	Deployments []archaius.Deployment   `json:"deployments,omitempty"`
+	Flags       []archaius.Flag         `json:"flags,omitempty"`
+	Entrypoints []archaius.Entrypoint   `json:"entrypoints,omitempty"` // where the traffic enters, instead of every dependency of the last service
+	Schedule    []archaius.KeyChange    `json:"schedule,omitempty"`    // keyval changes while the architecture is running
+	Probes      []archaius.Probe        `json:"probes,omitempty"`      // synthetic monitors sent from the last service
+	Services    []containerV0r0         `json:"services"`
 }
 
 type serviceV0r0 struct {
@@ -42,6 +60,7 @@ type containerV0r0 struct {
 	Regions      int      `json:"regions,omitempty"`
 	Count        int      `json:"count"`
 	Dependencies []string `json:"dependencies"`
+	archaius.ServiceConfig
 }
 
 // Start architecture
@@ -52,14 +71,110 @@ func Start(a *archV0r1) {
 	} else {
 		log.Printf("architecture: scaling to %v%%", archaius.Conf.Population)
 	}
+	if archaius.Conf.Invariants != "" {
+		CheckInvariants(a, archaius.Conf.Invariants)
+	}
+	Configure(a)
 	asgard.CreateChannels()
 	asgard.CreateEureka() // service registries for each zone
-
 	for _, s := range a.Services {
 		log.Printf("Starting: %v\n", s)
 		r = asgard.Create(s.Name, s.Gopackage, s.Regions*archaius.Conf.Regions, s.Count*archaius.Conf.Population/100, s.Dependencies...)
 	}
-	asgard.Run(r, a.Victim) // run the last service in the list, and point chaos monkey at the victim
+	chaos := chaosSchedule(a.Chaos)
+	partitions := append(append([]archaius.Partition{}, a.Partitions...), chaosPartitions(chaos)...)
+	archaius.SetPartitions(partitions) // the schedule starts once everything has been created
+	archaius.SetEvacuations(chaosEvacuations(chaos))
+	chaosmonkey.Schedule(chaos) // and so does the chaos monkey
+	asgard.Run(r, a.Victim)     // run the last service in the list, and point chaos monkey at the victim
+}
+
+// untilTheEnd is how long a chaos event lasts if it doesn't have a duration, longer than any run
+const untilTheEnd = 100 * 365 * 24 * time.Hour
+
+// chaosPartitions are the partition events of the chaos monkey, each one cutting its region off from the other regions
+func chaosPartitions(c *chaosmonkey.Config) []archaius.Partition {
+	if c == nil {
+		return nil
+	}
+	var ps []archaius.Partition
+	for _, e := range c.Events {
+		if e.Action != "partition" {
+			continue
+		}
+		var others []string
+		for _, r := range archaius.Conf.RegionNames[:archaius.Conf.Regions] {
+			if r != e.Region {
+				others = append(others, r)
+			}
+		}
+		d := e.Duration
+		if d == "" {
+			d = untilTheEnd.String()
+		}
+		ps = append(ps, archaius.Partition{Groups: [][]string{{e.Region}, others}, Start: e.At, Duration: d})
+	}
+	return ps
+}
+
+// chaosEvacuations are the evacuate events of the chaos monkey, each one moving the external traffic out of its region
+func chaosEvacuations(c *chaosmonkey.Config) []archaius.Evacuation {
+	if c == nil {
+		return nil
+	}
+	var es []archaius.Evacuation
+	for _, e := range c.Events {
+		if e.Action != "evacuate" {
+			continue
+		}
+		d := e.Duration
+		if d == "" {
+			d = untilTheEnd.String()
+		}
+		es = append(es, archaius.Evacuation{Region: e.Region, Start: e.At, Duration: d})
+	}
+	return es
+}
+
+// chaosSchedule is the chaos monkey of the architecture with its interval from -chaos, or -kv chaos, if either is set, which
+// runs one with the defaults if the architecture doesn't have one
+func chaosSchedule(c *chaosmonkey.Config) *chaosmonkey.Config {
+	i := archaius.Conf.Chaos
+	if i == "" {
+		i = archaius.Key(archaius.Conf, "chaos")
+	}
+	if i == "" {
+		return c
+	}
+	s := chaosmonkey.Config{}
+	if c != nil {
+		s = *c
+	}
+	s.Interval = i
+	return &s
+}
+
+// Configure saves the zones and the config of every service in the architecture without creating any instances
+func Configure(a *archV0r1) {
+	archaius.SetZones(a.Zones)
+	archaius.SetWAN(a.WAN)
+	archaius.SetCorrelation(a.Correlated)
+	archaius.SetJourneys(a.Journeys)
+	archaius.SetIngress(a.Ingress)
+	archaius.SetDeployments(a.Deployments)
+	archaius.SetFlags(a.Flags)
+	archaius.SetEntrypoints(a.Entrypoints)
+	archaius.SetSchedule(a.Schedule)
+	archaius.SetProbes(a.Probes)
+	for _, s := range a.Services {
+		if s.Sidecar == nil {
+			s.Sidecar = a.Sidecar
+		}
+		if s.DNS == nil {
+			s.DNS = a.DNS
+		}
+		archaius.SetService(s.Name, s.ServiceConfig)
+	}
 }
 
 // Connection
@@ -77,53 +192,1099 @@ func ListDependencies(a *archV0r1, nodes *[]string, dependencies *[]Connection)
 	}
 }
 
-// ReadArch parses archjson
-func ReadArch(arch string) *archV0r1 {
-	fn := "json_arch/" + arch + "_arch.json"
+// File finds the architecture definition, json_arch/<arch>_arch.json or json_arch/<arch>_arch.pb, or returns "" if there isn't one
+func File(arch string) string {
+	for _, fn := range []string{"json_arch/" + arch + "_arch.json", "json_arch/" + arch + "_arch.pb"} {
+		if _, err := os.Stat(fn); err == nil {
+			return fn
+		}
+	}
+	return ""
+}
+
+// ReadArch parses archjson, or json_arch/<arch>_arch.pb if there's no json version, or returns the architecture of a model loaded by LoadModel.
+// If it can't be read, or Validate finds problems with it, the error lists all of them
+func ReadArch(arch string) (*archV0r1, error) {
+	if model != nil && model.Arch.Arch == arch {
+		return model.Arch, nil
+	}
+	fn := File(arch)
+	if fn == "" {
+		return nil, fmt.Errorf("architecture %v isn't recognized, there's no json_arch/%v_arch.json or json_arch/%v_arch.pb", arch, arch, arch)
+	}
+	pb := strings.HasSuffix(fn, ".pb")
 	log.Println("Loading architecture from " + fn)
 	data, err := ioutil.ReadFile(fn)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	a := new(archV0r1)
-	e := json.Unmarshal(data, a)
-	if e == nil {
-		names := make(map[string]bool)
-		names[packagenames.EurekaPkg] = true // special case to allow cross region references
-		packs := make(map[string]bool)
-		for _, p := range packagenames.Packages {
-			packs[p] = true
+	if pb {
+		a, err = UnmarshalPB(data)
+	} else {
+		err = json.Unmarshal(data, a)
+	}
+	if err != nil {
+		if line := jsonLine(data, err); line > 0 {
+			return nil, fmt.Errorf("%v:%v: %v", fn, line, err)
+		}
+		return nil, fmt.Errorf("%v: %v", fn, err)
+	}
+	if errs := Validate(a); len(errs) > 0 {
+		var lines []int
+		if !pb {
+			lines = serviceLines(data)
+		}
+		for _, e := range errs {
+			e.File = fn
+			if e.Index >= 0 && e.Index < len(lines) {
+				e.Line = lines[e.Index]
+			}
+		}
+		return nil, errs
+	}
+	validate(a)
+	log.Printf("Architecture: %v %v\n", a.Arch, a.Description)
+	return a, nil
+}
+
+// ArchError is a problem with a service in an architecture definition, found by Validate
+type ArchError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"` // where the service starts in a json file, 0 if it isn't known
+	Index   int    `json:"index"`          // of the service in the list, -1 for the architecture as a whole
+	Service string `json:"service"`
+	Problem string `json:"problem"`
+}
+
+func (e *ArchError) Error() string {
+	if e.Index < 0 {
+		return strings.TrimPrefix(e.File+": ", ": ") + e.Problem
+	}
+	where := fmt.Sprintf("services[%v]", e.Index)
+	if e.File != "" && e.Line > 0 {
+		where = fmt.Sprintf("%v:%v", e.File, e.Line)
+	} else if e.File != "" {
+		where = e.File + " " + where
+	}
+	return fmt.Sprintf("%v: service %q: %v", where, e.Service, e.Problem)
+}
+
+// ArchErrors is every problem Validate found, one per line
+type ArchErrors []*ArchError
+
+func (errs ArchErrors) Error() string {
+	s := make([]string, len(errs))
+	for i, e := range errs {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Validate checks that every service has a unique name, a package asgard knows how to start, and only depends on services
+// that are declared, and returns all the problems it finds rather than stopping at the first one
+func Validate(a *archV0r1) ArchErrors {
+	var errs ArchErrors
+	bad := func(i int, s containerV0r0, problem string) {
+		errs = append(errs, &ArchError{Index: i, Service: s.Name, Problem: problem})
+	}
+	if len(a.Services) == 0 {
+		errs = append(errs, &ArchError{Index: -1, Problem: "there are no services"})
+	}
+	packs := make(map[string]bool)
+	for _, p := range packagenames.Packages {
+		packs[p] = true
+	}
+	first := make(map[string]int)      // index of the first service with each name
+	first[packagenames.EurekaPkg] = -1 // special case to allow cross region references
+	for i, s := range a.Services {
+		if s.Name == "" {
+			bad(i, s, "has no name")
+		} else if j, ok := first[s.Name]; ok && j >= 0 {
+			bad(i, s, fmt.Sprintf("duplicate name, also used by services[%v]", j))
+		} else if !ok {
+			first[s.Name] = i
+		}
+		if !packs[s.Gopackage] {
+			bad(i, s, fmt.Sprintf("unknown package %q, should be one of %v", s.Gopackage, strings.Join(packagenames.Packages, " ")))
+		}
+	}
+	for i, s := range a.Services {
+		for _, d := range s.Dependencies {
+			if _, ok := first[d]; !ok {
+				bad(i, s, fmt.Sprintf("unknown dependency %q", d))
+			}
+		}
+	}
+	return errs
+}
+
+// serviceLines finds the line each service starts on in an architecture json file, as far as it can
+func serviceLines(data []byte) []int {
+	var raw struct {
+		Services []json.RawMessage `json:"services"`
+	}
+	if json.Unmarshal(data, &raw) != nil {
+		return nil
+	}
+	var lines []int
+	at := 0
+	for _, s := range raw.Services {
+		i := bytes.Index(data[at:], s) // the raw json of each service is copied from the file, in order
+		if i < 0 {
+			break
+		}
+		lines = append(lines, 1+bytes.Count(data[:at+i], []byte("\n")))
+		at += i + len(s)
+	}
+	return lines
+}
+
+// jsonLine is the line of a json syntax or type error, or 0 for any other error
+func jsonLine(data []byte, err error) int {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// validate applies the -kv edge overrides to an architecture that passed Validate and checks the rest of it, failing on anything that wouldn't run
+func validate(a *archV0r1) {
+	names := make(map[string]bool)
+	names[packagenames.EurekaPkg] = true // special case to allow cross region references
+	healthy := make(map[string]bool)     // services with a health config
+	topics := make(map[string]bool)      // topic services, that consumers can subscribe to
+	flags := make(map[string]bool)
+	for _, f := range a.Flags {
+		flags[f.Name] = true
+	}
+	// map all the service names, Validate has already checked they are unique and the packages and dependencies exist
+	for _, s := range a.Services {
+		names[s.Name] = true
+		healthy[s.Name] = s.Health != nil
+		topics[s.Name] = s.Gopackage == packagenames.TopicPkg
+	}
+	for _, c := range cycles(a) {
+		path := strings.Join(c, " -> ")
+		if !archaius.Conf.Cycles {
+			log.Fatal("Dependency cycle in architecture: " + path + ", use -cycles to allow it")
+		}
+		log.Printf("architecture: allowing dependency cycle %v, calls are limited to %v hops\n", path, archaius.Conf.MaxHops)
+	}
+	applyEdgeKeys(a)
+	// check any optional durations parse
+	for _, s := range a.Services {
+		if s.Sessions < 0 {
+			log.Println(s)
+			log.Fatal("Bad sessions in architecture: " + s.Name)
+		}
+		if t, err := time.ParseDuration(s.Apdex); s.Apdex != "" && (err != nil || t <= 0) {
+			log.Println(s)
+			log.Fatal("Bad apdex in architecture: " + s.Apdex)
 		}
-		// map all the service names and check packages exist
-		for _, s := range a.Services {
-			if names[s.Name] == true {
-				log.Println(names)
+		if s.SLA < 0 || s.SLA > 100 {
+			log.Println(s)
+			log.Fatal("Bad sla in architecture, it should be a percentage: " + s.Name)
+		}
+		if d := s.Drain; d != nil {
+			delay, derr := time.ParseDuration(d.Delay)
+			timeout, terr := time.ParseDuration(d.Timeout)
+			if (d.Delay != "" && (derr != nil || delay < 0)) || (d.Timeout != "" && (terr != nil || timeout <= 0)) || (d.Delay != "" && d.Timeout != "" && delay > timeout) {
 				log.Println(s)
-				log.Fatal("Duplicate service name in architecture: " + s.Name)
-			} else {
-				names[s.Name] = true
+				log.Fatal("Bad drain in architecture, needs a delay that isn't negative and isn't longer than the timeout: " + s.Name)
 			}
-			if packs[s.Gopackage] != true {
-				log.Println(packs)
+		}
+		if l := s.Latency; l != nil {
+			median, err1 := time.ParseDuration(l.Median)
+			slow, err2 := time.ParseDuration(l.Slow)
+			max, err3 := time.ParseDuration(l.Max)
+			if (l.Distribution != "lognormal" && l.Distribution != "pareto" && l.Distribution != "bimodal") || err1 != nil || median <= 0 || l.Sigma < 0 ||
+				(l.Alpha != 0 && l.Alpha <= 1) || (l.Max != "" && (err3 != nil || max < median)) ||
+				(l.Distribution == "bimodal" && (err2 != nil || slow < median || l.SlowFraction <= 0 || l.SlowFraction >= 1)) {
 				log.Println(s)
-				log.Fatal("Unknown package name in architecture: " + s.Gopackage)
+				log.Fatal("Bad latency in architecture, needs a lognormal, pareto or bimodal distribution and a median, an alpha over 1, a max that isn't under the median, and for bimodal a slow path that isn't faster with a slowfraction between 0 and 1: " + s.Name)
 			}
 		}
-		// check all the dependencies
-		for _, s := range a.Services {
-			for _, d := range s.Dependencies {
-				if names[d] == false {
-					log.Println(names)
+		if s.Deadline != "" {
+			if _, err := time.ParseDuration(s.Deadline); err != nil {
+				log.Println(s)
+				log.Fatal("Bad deadline in architecture: " + s.Deadline)
+			}
+		}
+		if s.Think != "" {
+			if t, err := time.ParseDuration(s.Think); err != nil || t < 0 {
+				log.Println(s)
+				log.Fatal("Bad think time in architecture: " + s.Think)
+			}
+		}
+		if s.Dedup != "" {
+			if w, err := time.ParseDuration(s.Dedup); err != nil || w <= 0 {
+				log.Println(s)
+				log.Fatal("Bad dedup window in architecture: " + s.Dedup)
+			}
+		}
+		if s.Sidecar != nil {
+			checkSidecar(s.Sidecar)
+		}
+		if s.DNS != nil {
+			checkDNS(s.DNS)
+		}
+		if len(s.Sizes) > 0 {
+			checkSizes(s)
+		}
+		if h := s.Health; h != nil {
+			t, err1 := time.ParseDuration(h.Target)
+			w, err2 := time.ParseDuration(h.Window)
+			if h.Latency < 0 || h.Errors < 0 || h.Inflight < 0 || h.Latency+h.Errors+h.Inflight <= 0 || h.Concurrency < 0 ||
+				(h.Latency > 0 && (err1 != nil || t <= 0)) || (h.Window != "" && (err2 != nil || w <= 0)) {
+				log.Println(s)
+				log.Fatal("Bad health in architecture, needs weights that aren't negative and add up to more than 0, and a target for the latency weight")
+			}
+		}
+		if c := s.Caching; c != nil {
+			f, err := time.ParseDuration(c.Flush)
+			if (c.Pattern != "aside" && c.Pattern != "readthrough" && c.Pattern != "writebehind") || (c.Flush != "" && (err != nil || f < 0)) {
+				log.Println(s)
+				log.Fatal("Bad caching in architecture, pattern should be aside, readthrough or writebehind and flush a duration")
+			}
+			if c.Capacity < 0 || (c.Eviction != "" && c.Eviction != "lru" && c.Eviction != "lfu" && c.Eviction != "fifo") {
+				log.Println(s)
+				log.Fatal("Bad caching in architecture, capacity can't be negative and eviction should be lru, lfu or fifo")
+			}
+			w, err := time.ParseDuration(c.Warm)
+			bad := c.Warm != "" && (err != nil || w < 0)
+			for _, fl := range c.Flushes {
+				t, err := time.ParseDuration(fl)
+				bad = bad || err != nil || t <= 0
+			}
+			if bad {
+				log.Println(s)
+				log.Fatal("Bad caching in architecture, warm should be a duration and flushes times after the start")
+			}
+			if s.Gopackage != packagenames.CachePkg && s.Gopackage != packagenames.StorePkg && s.Gopackage != packagenames.VolumePkg {
+				log.Println(s)
+				log.Fatal("Bad caching in architecture, only cache, store and volume services can have a caching pattern: " + s.Name)
+			}
+		}
+		if w := s.WAL; w != nil {
+			f, err1 := time.ParseDuration(w.Fsync)
+			g, err2 := time.ParseDuration(w.Group)
+			if err1 != nil || f <= 0 || (w.Group != "" && (err2 != nil || g < 0)) || (w.Distribution != "" && w.Distribution != "fixed" && w.Distribution != "uniform" && w.Distribution != "exponential") {
+				log.Println(s)
+				log.Fatal("Bad wal in architecture, needs an fsync time, a group commit window that isn't negative and a distribution of fixed, uniform or exponential")
+			}
+			if s.Gopackage != packagenames.CachePkg && s.Gopackage != packagenames.StorePkg && s.Gopackage != packagenames.VolumePkg {
+				log.Println(s)
+				log.Fatal("Bad wal in architecture, only cache, store and volume services can have a write ahead log: " + s.Name)
+			}
+		}
+		if l := s.Leader; l != nil {
+			e, err := time.ParseDuration(l.Election)
+			if l.Size < 0 || (l.Election != "" && (err != nil || e <= 0)) || (l.Writes != "" && l.Writes != "fail" && l.Writes != "block") {
+				log.Println(s)
+				log.Fatal("Bad leader in architecture, size can't be negative, election should be a duration and writes fail or block")
+			}
+			if s.Gopackage != packagenames.StorePkg {
+				log.Println(s)
+				log.Fatal("Bad leader in architecture, only store services can elect a leader: " + s.Name)
+			}
+		}
+		if c := s.Coalesce; c != nil && c.Window != "" {
+			if w, err := time.ParseDuration(c.Window); err != nil || w <= 0 {
+				log.Println(s)
+				log.Fatal("Bad coalesce window in architecture: " + c.Window)
+			}
+		}
+		if st := s.Startup; st != nil {
+			l, err1 := time.ParseDuration(st.Latency)
+			w, err2 := time.ParseDuration(st.Warm)
+			if err1 != nil || err2 != nil || l < 0 || w <= 0 {
+				log.Println(s)
+				log.Fatal("Bad startup in architecture, latency and warm should be durations: " + st.Latency + " " + st.Warm)
+			}
+		}
+		if x := s.External; x != nil {
+			l, err := time.ParseDuration(x.Latency)
+			if x.Rate < 0 || x.Burst < 0 || (x.Latency != "" && (err != nil || l < 0)) || (x.Distribution != "" && x.Distribution != "fixed" && x.Distribution != "uniform" && x.Distribution != "exponential") {
+				log.Println(s)
+				log.Fatal("Bad external in architecture, rate and burst can't be negative, latency should be a duration and distribution fixed, uniform or exponential")
+			}
+			for _, o := range x.Outages {
+				st, err1 := time.ParseDuration(o.Start)
+				d, err2 := time.ParseDuration(o.Duration)
+				if err1 != nil || err2 != nil || st < 0 || d <= 0 {
+					log.Println(s)
+					log.Fatal("Bad external outage in architecture, start and duration should be durations: " + o.Start + " " + o.Duration)
+				}
+			}
+			if s.Gopackage != packagenames.ExternalPkg {
+				log.Println(s)
+				log.Fatal("Bad external in architecture, only external services can have an external config: " + s.Name)
+			}
+		}
+		if sg := s.Saga; sg != nil {
+			if t, err := time.ParseDuration(sg.Timeout); len(sg.Steps) == 0 || sg.Retries < 0 || (sg.Timeout != "" && (err != nil || t <= 0)) {
+				log.Println(s)
+				log.Fatal("Bad saga in architecture, it needs steps, a timeout should be a duration and retries can't be negative")
+			}
+			for _, st := range sg.Steps {
+				dep := false
+				for _, d := range s.Dependencies {
+					dep = dep || d == st.Service
+				}
+				if !dep || st.Errors < 0 || st.Errors > 1 {
+					log.Println(s)
+					log.Fatal("Bad saga step in architecture, the service should be a dependency and errors a fraction: " + st.Service)
+				}
+			}
+			if s.Gopackage != packagenames.SagaPkg {
+				log.Println(s)
+				log.Fatal("Bad saga in architecture, only saga services can have a saga config: " + s.Name)
+			}
+		}
+		if tp := s.TwoPhase; tp != nil {
+			if t, err := time.ParseDuration(tp.Timeout); len(tp.Transactions) == 0 || tp.Retries < 0 || (tp.Timeout != "" && (err != nil || t <= 0)) {
+				log.Println(s)
+				log.Fatal("Bad twophase in architecture, it needs transactions, a timeout should be a duration and retries can't be negative")
+			}
+			seen := make(map[string]bool)
+			for _, tx := range tp.Transactions {
+				if len(tx.Participants) == 0 || seen[tx.Name] || tx.Aborts < 0 || tx.Aborts > 1 {
+					log.Println(s)
+					log.Fatal("Bad twophase transaction in architecture, it needs participants, a name of its own and aborts should be a fraction: " + tx.Name)
+				}
+				seen[tx.Name] = true
+				for _, p := range tx.Participants {
+					dep := false
+					for _, d := range s.Dependencies {
+						dep = dep || d == p
+					}
+					if !dep {
+						log.Println(s)
+						log.Fatal("Bad twophase participant in architecture, it should be a dependency: " + p)
+					}
+				}
+			}
+			if s.Gopackage != packagenames.TwoPhasePkg {
+				log.Println(s)
+				log.Fatal("Bad twophase in architecture, only twophase services can have a twophase config: " + s.Name)
+			}
+		}
+		if lc := s.Lock; lc != nil {
+			if t, err := time.ParseDuration(lc.Timeout); len(lc.Keys) == 0 || (lc.Timeout != "" && (err != nil || t <= 0)) {
+				log.Println(s)
+				log.Fatal("Bad lock in architecture, it needs keys and a timeout should be a duration")
+			}
+			seen := make(map[string]bool)
+			for _, k := range lc.Keys {
+				h, err := time.ParseDuration(k.Hold)
+				if k.Name == "" || seen[k.Name] || err != nil || h < 0 || k.Weight < 0 ||
+					(k.Distribution != "" && k.Distribution != "fixed" && k.Distribution != "uniform" && k.Distribution != "exponential") {
+					log.Println(s)
+					log.Fatal("Bad lock key in architecture, it needs a unique name, hold should be a duration, weight can't be negative and distribution fixed, uniform or exponential: " + k.Name)
+				}
+				seen[k.Name] = true
+			}
+			if s.Gopackage != packagenames.LockPkg {
+				log.Println(s)
+				log.Fatal("Bad lock in architecture, only lock services can have a lock config: " + s.Name)
+			}
+		}
+		if s.Cost < 0 {
+			log.Println(s)
+			log.Fatal("Bad cost in architecture, an hourly cost can't be negative: " + s.Name)
+		}
+		if sh := s.Shed; sh != nil {
+			t, err1 := time.ParseDuration(sh.Target)
+			i, err2 := time.ParseDuration(sh.Interval)
+			if sh.Concurrency < 0 || (sh.Concurrency == 0 && len(s.Sizes) == 0) || sh.Queue < 0 ||
+				(sh.Discipline != "" && sh.Discipline != "fifo" && sh.Discipline != "lifo" && sh.Discipline != "codel" && sh.Discipline != "wfq") ||
+				(sh.Target != "" && (err1 != nil || t <= 0)) || (sh.Interval != "" && (err2 != nil || i <= 0)) || (sh.Backpressure && sh.Queue == 0) {
+				log.Println(s)
+				log.Fatal("Bad shed in architecture, needs a concurrency unless the service has sizes, a queue that isn't negative, and one for backpressure, a discipline of fifo, lifo, codel or wfq, and a target and interval that are durations")
+			}
+			for t, w := range sh.Weights {
+				if w <= 0 || sh.Discipline != "wfq" {
+					log.Println(s)
+					log.Fatal("Bad shed weight in architecture, weights are for wfq and need to be more than 0: " + t)
+				}
+			}
+		}
+		if ad := s.Admission; ad != nil {
+			t, err1 := time.ParseDuration(ad.Target)
+			i, err2 := time.ParseDuration(ad.Interval)
+			queued := s.Shed != nil && s.Shed.Concurrency > 0
+			for _, z := range s.Sizes {
+				queued = queued || z.Concurrency > 0
+			}
+			if !queued || (ad.Controller != "codel" && ad.Controller != "pid") || (ad.Target != "" && (err1 != nil || t <= 0)) ||
+				(ad.Interval != "" && (err2 != nil || i <= 0)) || ad.Kp < 0 || ad.Ki < 0 || ad.Kd < 0 {
+				log.Println(s)
+				log.Fatal("Bad admission in architecture, needs a concurrency from shed or sizes, a controller of codel or pid, a target and interval that are durations and gains that aren't negative")
+			}
+		}
+		if k := s.KeyAccess; k != nil {
+			if (k.Distribution != "" && k.Distribution != "uniform" && k.Distribution != "zipf") || (k.Skew != 0 && k.Skew <= 1) {
+				log.Println(s)
+				log.Fatal("Bad keyaccess in architecture, distribution should be uniform or zipf and skew more than 1")
+			}
+			if s.Gopackage != packagenames.DenominatorPkg {
+				log.Println(s)
+				log.Fatal("Bad keyaccess in architecture, only denominator services start the gets it picks keys for: " + s.Name)
+			}
+		}
+		if m := s.Memory; m != nil {
+			if m.Limit <= 0 || m.Request <= 0 || (m.Model != "" && m.Model != "inflight" && m.Model != "cumulative") {
+				log.Println(s)
+				log.Fatal("Bad memory in architecture, limit and request should be MB and model inflight or cumulative")
+			}
+			if r, err := time.ParseDuration(m.Restart); m.Restart != "" && (err != nil || r < 0) {
+				log.Println(s)
+				log.Fatal("Bad memory restart in architecture: " + m.Restart)
+			}
+			if sd, err := time.ParseDuration(m.Slowdown); m.Slowdown != "" && (err != nil || sd < 0 || m.Model != "cumulative") {
+				log.Println(s)
+				log.Fatal("Bad memory slowdown in architecture, needs to be a duration for a cumulative model: " + m.Slowdown)
+			}
+		}
+		if s.GC != nil {
+			i, err1 := time.ParseDuration(s.GC.Interval)
+			p, err2 := time.ParseDuration(s.GC.Pause)
+			if err1 != nil || err2 != nil || i <= 0 || p < 0 || p >= i {
+				log.Println(s)
+				log.Fatal("Bad gc interval or pause in architecture: " + s.GC.Interval + " " + s.GC.Pause)
+			}
+		}
+		if s.Errors < 0 || s.Errors > 1 {
+			log.Println(s)
+			log.Fatal("Bad error rate in architecture, must be between 0 and 1: " + s.Name)
+		}
+		if r := s.Replication; r != nil {
+			lag, err := time.ParseDuration(r.Lag)
+			if s.Gopackage != "store" || (r.Mode != "sync" && r.Mode != "async" && r.Mode != "quorum") || r.Replicas < 0 || (r.Lag != "" && (err != nil || lag < 0)) {
+				log.Println(s)
+				log.Fatal("Bad replication in architecture, needs a store package, a sync, async or quorum mode, and a lag that isn't negative: " + s.Name)
+			}
+			if r.W < 0 || r.R < 0 || ((r.W > 0 || r.R > 0) && r.Mode != "quorum") || (r.Replicas > 0 && (r.W > r.Replicas+1 || r.R > r.Replicas+1)) {
+				log.Println(s)
+				log.Fatal("Bad replication quorum in architecture, w and r need quorum mode and can't be more than the replicas and this instance: " + s.Name)
+			}
+		}
+		if s.Queue != nil && s.Queue.Visibility != "" {
+			if v, err := time.ParseDuration(s.Queue.Visibility); err != nil || v <= 0 {
+				log.Println(s)
+				log.Fatal("Bad queue visibility timeout in architecture: " + s.Queue.Visibility)
+			}
+		}
+		if t := s.Topic; t != nil {
+			lag, err1 := time.ParseDuration(t.Lag)
+			session, err2 := time.ParseDuration(t.Session)
+			if s.Gopackage != packagenames.TopicPkg || t.Partitions < 0 || t.Depth < 0 || t.Retries < 0 || (t.Lag != "" && (err1 != nil || lag <= 0)) ||
+				(t.Session != "" && (err2 != nil || session <= 0)) {
+				log.Println(s)
+				log.Fatal("Bad topic in architecture, needs a topic package, partitions, depth and retries that aren't negative, and a lag and session that are durations: " + s.Name)
+			}
+		}
+		if s.Autoscale != nil && s.Autoscale.Queue != "" && names[s.Autoscale.Queue] == false {
+			log.Println(s)
+			log.Fatal("Unknown autoscale queue name in architecture: " + s.Autoscale.Queue)
+		}
+		for d, e := range s.Edges {
+			if names[d] == false {
+				log.Println(s)
+				log.Fatal("Unknown edge name in architecture: " + d)
+			}
+			if e.Latency != "" {
+				if _, err := time.ParseDuration(e.Latency); err != nil {
+					log.Println(s)
+					log.Fatal("Bad edge latency in architecture: " + e.Latency)
+				}
+			}
+			if e.Weight < 0 {
+				log.Println(s)
+				log.Fatal("Bad edge weight in architecture: " + d)
+			}
+			if e.Connections < 0 {
+				log.Println(s)
+				log.Fatal("Bad edge connections in architecture: " + d)
+			}
+			if e.Balance != "" && e.Balance != "adaptive" && e.Balance != "health" && e.Balance != "sticky" {
+				log.Println(s)
+				log.Fatal("Unknown edge balance in architecture: " + e.Balance)
+			}
+			if rh, err := time.ParseDuration(e.Rehome); e.Rehome != "" && (err != nil || rh < 0 || e.Balance != "sticky") {
+				log.Println(s)
+				log.Fatal("Bad edge rehome in architecture, it's a duration for sticky balance: " + d)
+			}
+			if e.Balance == "health" && !healthy[d] {
+				log.Println(s)
+				log.Fatal("Edge balance health in architecture needs a health config on: " + d)
+			}
+			if w, err := time.ParseDuration(e.Window); e.Window != "" && (err != nil || w <= 0) {
+				log.Println(s)
+				log.Fatal("Bad edge window in architecture: " + e.Window)
+			}
+			if e.Response != "" {
+				if _, err := time.ParseDuration(e.Response); err != nil {
+					log.Println(s)
+					log.Fatal("Bad edge response latency in architecture: " + e.Response)
+				}
+			}
+			if e.Timeout != "" {
+				if _, err := time.ParseDuration(e.Timeout); err != nil {
 					log.Println(s)
-					log.Fatal("Unknown dependency name in architecture: " + d)
+					log.Fatal("Bad edge timeout in architecture: " + e.Timeout)
+				}
+			}
+			if _, _, ok := archaius.Serdes(e.Format); e.Format != "" && !ok {
+				log.Println(s)
+				log.Fatal("Unknown edge format in architecture, needs a serdes." + e.Format + " keyval: " + e.Format)
+			}
+			if e.Payload < 0 || e.ResponsePayload < 0 {
+				log.Println(s)
+				log.Fatal("Bad edge payload in architecture: " + d)
+			}
+			if e.Mirror != "" && (e.Mirror == d || !dependsOn(s, e.Mirror)) {
+				log.Println(s)
+				log.Fatal("Edge mirror in architecture should be another dependency of " + s.Name + ": " + e.Mirror)
+			}
+			if e.MirrorFraction < 0 || e.MirrorFraction > 1 {
+				log.Println(s)
+				log.Fatal("Bad edge mirrorfraction in architecture, should be from 0 to 1: " + d)
+			}
+			if e.Fanout < 0 {
+				log.Println(s)
+				log.Fatal("Bad edge fanout in architecture: " + d)
+			}
+			if w, err := time.ParseDuration(e.Warmup); e.Warmup != "" && (err != nil || w < 0) {
+				log.Println(s)
+				log.Fatal("Bad edge warmup in architecture: " + e.Warmup)
+			}
+			if e.WarmupCalls < 0 {
+				log.Println(s)
+				log.Fatal("Bad edge warmupcalls in architecture: " + d)
+			}
+			if k, err := time.ParseDuration(e.KeepAlive); e.KeepAlive != "" && (err != nil || k <= 0) {
+				log.Println(s)
+				log.Fatal("Bad edge keepalive in architecture: " + e.KeepAlive)
+			}
+			if e.Backoff != "" && e.Backoff != "fixed" && e.Backoff != "exponential" && e.Backoff != "jitter" && e.Backoff != "decorrelated" {
+				log.Println(s)
+				log.Fatal("Bad edge backoff in architecture, should be fixed, exponential, jitter or decorrelated: " + e.Backoff)
+			}
+			base, err1 := time.ParseDuration(e.BackoffBase)
+			limit, err2 := time.ParseDuration(e.BackoffCap)
+			if (e.BackoffBase != "" && (err1 != nil || base <= 0)) || (e.BackoffCap != "" && (err2 != nil || limit <= 0)) ||
+				(e.BackoffBase != "" && e.BackoffCap != "" && limit < base) {
+				log.Println(s)
+				log.Fatal("Bad edge backoffbase or backoffcap in architecture, should be durations with the cap no less than the base: " + d)
+			}
+			if e.Pages < 0 || (e.Pages > 1 && e.Fanout > 1) {
+				log.Println(s)
+				log.Fatal("Bad edge pages in architecture, can't be negative or used with a fanout: " + d)
+			}
+			if p, err := time.ParseDuration(e.PageLatency); e.PageLatency != "" && (err != nil || p < 0) {
+				log.Println(s)
+				log.Fatal("Bad edge pagelatency in architecture: " + e.PageLatency)
+			}
+			if w, err := time.ParseDuration(e.BatchWindow); e.Batch < 0 || e.BatchScale < 0 || e.BatchScale > 1 || (e.BatchWindow != "" && (err != nil || w <= 0)) ||
+				(e.Batch > 1 && (e.Fanout > 1 || e.Pages > 1)) {
+				log.Println(s)
+				log.Fatal("Bad edge batch in architecture, batchwindow should be a duration and batchscale from 0 to 1, and it can't be used with a fanout or pages: " + d)
+			}
+			window, err := time.ParseDuration(e.BatchWindow)
+			if err != nil {
+				window = 5 * time.Millisecond
+			}
+			if m, err := time.ParseDuration(e.BatchMaxWait); e.BatchMaxWait != "" && (err != nil || m < window) {
+				log.Println(s)
+				log.Fatal("Bad edge batchmaxwait in architecture, it should be a duration at least as long as the batchwindow: " + d)
+			}
+			if a, err := time.ParseDuration(e.SpeculateAfter); e.Speculate < 0 || e.Speculate > 1 || e.CancelWork < 0 || e.CancelWork > 1 ||
+				(e.SpeculateAfter != "" && (err != nil || a < 0)) {
+				log.Println(s)
+				log.Fatal("Bad edge speculate in architecture, speculate and cancelwork should be from 0 to 1 and speculateafter a duration: " + d)
+			}
+			bw, err1 := time.ParseDuration(e.BreakerWindow)
+			bs, err2 := time.ParseDuration(e.BreakerSleep)
+			if e.Breaker < 0 || e.Breaker > 1 || e.BreakerVolume < 0 || (e.BreakerWindow != "" && (err1 != nil || bw <= 0)) || (e.BreakerSleep != "" && (err2 != nil || bs <= 0)) {
+				log.Println(s)
+				log.Fatal("Bad edge breaker in architecture, breaker should be from 0 to 1, breakervolume can't be negative and breakerwindow and breakersleep should be durations: " + d)
+			}
+			if e.Group != "" && (!topics[d] || !dependsOn(s, d)) {
+				log.Println(s)
+				log.Fatal("Edge group in architecture should be on a dependency that is a topic: " + d)
+			}
+			if e.Flag != "" && !flags[strings.TrimPrefix(e.Flag, "!")] {
+				log.Println(s)
+				log.Fatal("Unknown edge flag in architecture, needs to be one of the flags: " + e.Flag)
+			}
+		}
+	}
+	for _, p := range a.Partitions {
+		s, err1 := time.ParseDuration(p.Start)
+		d, err2 := time.ParseDuration(p.Duration)
+		if err1 != nil || err2 != nil || s < 0 || d <= 0 || len(p.Groups) < 2 {
+			log.Println(p)
+			log.Fatal("Bad partition in architecture, needs two or more groups, a start and a duration")
+		}
+	}
+	checkEntrypoints(a)
+	checkSchedule(a)
+	if len(a.Journeys) > 0 {
+		checkJourneys(a)
+	}
+	if len(a.Probes) > 0 {
+		checkProbes(a)
+	}
+	checkIngress(a)
+	if c := a.Chaos; c != nil {
+		i, err := time.ParseDuration(c.Interval)
+		if ((c.Interval != "" || len(c.Events) == 0) && (err != nil || i <= 0)) || c.Probability < 0 || c.Probability > 1 || c.Max < 0 {
+			log.Println(c)
+			log.Fatal("Bad chaos in architecture, needs an interval or events, a probability between 0 and 1 and a max that isn't negative")
+		}
+		for _, e := range c.Events {
+			at, err1 := time.ParseDuration(e.At)
+			d, err2 := time.ParseDuration(e.Duration)
+			if err1 != nil || at < 0 || (e.Duration != "" && (err2 != nil || d <= 0)) || e.Count < 0 || e.Percent < 0 || e.Percent > 100 {
+				log.Println(e)
+				log.Fatal("Bad chaos event in architecture, needs an at time, a duration if it has one, a count that isn't negative or a percent up to 100")
+			}
+			switch e.Action {
+			case "kill", "latency":
+				if names[e.Service] == false || e.Service == packagenames.EurekaPkg {
+					log.Fatal("Unknown chaos event service name in architecture: " + e.Service)
+				}
+				if l, err := time.ParseDuration(e.Latency); e.Action == "latency" && (err != nil || l <= 0) {
+					log.Fatal("Bad chaos latency event in architecture, needs a latency: " + e.Service)
+				}
+			case "partition", "evacuate":
+				running := archaius.Conf.RegionNames[:archaius.Conf.Regions]
+				known := false
+				for _, r := range running {
+					known = known || r == e.Region
+				}
+				if !known || len(running) < 2 {
+					log.Fatal("Bad chaos " + e.Action + " event in architecture, needs -w 2 or more and a region that is running, one of " + strings.Join(running, " ") + ": " + e.Region)
+				}
+			default:
+				log.Fatal("Unknown chaos event action in architecture, should be one of " + strings.Join(chaosmonkey.Actions, " ") + ": " + e.Action)
+			}
+		}
+		if cs, err := time.ParseDuration(c.ColdStart); c.ColdStart != "" && (err != nil || cs < 0) {
+			log.Fatal("Bad chaos coldstart in architecture: " + c.ColdStart)
+		}
+		for _, s := range c.Services {
+			if names[s] == false {
+				log.Fatal("Unknown chaos service name in architecture: " + s)
+			}
+		}
+	}
+	if z := a.Zones; z != nil {
+		l, err := time.ParseDuration(z.Latency)
+		if z.Count < 0 || z.Count > len(archaius.Conf.ZoneNames) || (z.Latency != "" && (err != nil || l < 0)) {
+			log.Println(z)
+			log.Fatal(fmt.Sprintf("Bad zones in architecture, needs a count up to %v and a latency that isn't negative", len(archaius.Conf.ZoneNames)))
+		}
+		zones := archaius.Conf.ZoneNames
+		if z.Count > 0 {
+			zones = zones[:z.Count]
+		}
+		for _, o := range z.Outages {
+			known := false
+			for _, n := range zones {
+				known = known || n == o.Zone
+			}
+			if o.Region != "" {
+				region := false
+				for _, r := range archaius.Conf.RegionNames {
+					region = region || r == o.Region
+				}
+				known = known && region
+			}
+			if s, err := time.ParseDuration(o.Start); !known || err != nil || s < 0 {
+				log.Println(o)
+				log.Fatal("Bad zone outage in architecture, needs one of the zones " + strings.Join(zones, ",") + ", a known region if any, and a start")
+			}
+		}
+	}
+	if w := a.WAN; w != nil {
+		for k, v := range w.Latency {
+			var from, to string
+			fmt.Sscanf(k, "%s%s", &from, &to)
+			f, t := false, false
+			for _, r := range archaius.Conf.RegionNames {
+				f, t = f || r == from, t || r == to
+			}
+			if l, err := time.ParseDuration(v); !f || !t || from == to || err != nil || l < 0 {
+				log.Println(w)
+				log.Fatal("Bad wan latency in architecture, needs two different regions out of " + strings.Join(archaius.Conf.RegionNames, " ") + " and a latency that isn't negative: " + k)
+			}
+		}
+	}
+	if a.Sidecar != nil {
+		checkSidecar(a.Sidecar)
+	}
+	if a.DNS != nil {
+		checkDNS(a.DNS)
+	}
+	if c := a.Correlated; c != nil {
+		groups := make(map[string]bool)
+		for _, g := range c.Groups {
+			if g.Name == "" || groups[g.Name] || len(g.Services) == 0 || g.Fraction < 0 || g.Fraction > 1 {
+				log.Println(g)
+				log.Fatal("Bad correlation group in architecture, needs a unique name, some services and a fraction between 0 and 1")
+			}
+			groups[g.Name] = true
+			for _, s := range g.Services {
+				if names[s] == false {
+					log.Fatal("Unknown correlation group service name in architecture: " + s)
 				}
 			}
 		}
-		log.Printf("Architecture: %v %v\n", a.Arch, a.Description)
-		return a
+		for _, e := range c.Events {
+			s, err1 := time.ParseDuration(e.Start)
+			d, err2 := time.ParseDuration(e.Duration)
+			l, err3 := time.ParseDuration(e.Latency)
+			if !groups[e.Group] || err1 != nil || err2 != nil || err3 != nil || s < 0 || d <= 0 || l <= 0 {
+				log.Println(e)
+				log.Fatal("Bad correlated event in architecture, needs a known group, a start, a duration and a latency")
+			}
+		}
+	}
+	for _, d := range a.Deployments {
+		s, err1 := time.ParseDuration(d.Start)
+		b, err2 := time.ParseDuration(d.Bake)
+		l, err3 := time.ParseDuration(d.Latency)
+		if !names[d.Service] || d.Version == "" || err1 != nil || s < 0 || d.Batch < 0 || (d.Bake != "" && (err2 != nil || b < 0)) ||
+			(d.Latency != "" && (err3 != nil || l < 0)) || d.Errors < 0 || d.Errors > 1 {
+			log.Println(d)
+			log.Fatal("Bad deployment in architecture, needs a known service, a version and a start, a batch, bake and latency that aren't negative and errors between 0 and 1")
+		}
+		if w, err := time.ParseDuration(d.Watch); (d.Strategy != "" && d.Strategy != "rolling" && d.Strategy != "bluegreen") ||
+			d.Rollback < 0 || d.Rollback > 1 || (d.Watch != "" && (err != nil || w <= 0)) {
+			log.Println(d)
+			log.Fatal("Bad deployment in architecture, the strategy is rolling or bluegreen, rollback is between 0 and 1 and watch a duration")
+		}
+	}
+	held := false // by a featureflag service
+	for _, s := range a.Services {
+		held = held || s.Gopackage == packagenames.FeatureflagPkg
+	}
+	seen := make(map[string]bool)
+	for _, f := range a.Flags {
+		if f.Name == "" || strings.ContainsAny(f.Name, "=!, ") || seen[f.Name] || !held {
+			log.Println(f)
+			log.Fatal("Bad flag in architecture, needs a unique name without = ! , or spaces, and a featureflag service to hold it")
+		}
+		seen[f.Name] = true
+		for _, c := range f.Schedule {
+			if t, err := time.ParseDuration(c.At); err != nil || t < 0 {
+				log.Println(f)
+				log.Fatal("Bad flag schedule in architecture: " + c.At)
+			}
+		}
+	}
+}
+
+// checkSidecar validates a sidecar config
+func checkSidecar(sc *archaius.SidecarConfig) {
+	for _, d := range []string{sc.Latency, sc.Handshake, sc.Open, sc.Window} {
+		if t, err := time.ParseDuration(d); d != "" && (err != nil || t < 0) {
+			log.Println(sc)
+			log.Fatal("Bad sidecar duration in architecture: " + d)
+		}
+	}
+	if sc.Retries < 0 || sc.Breaker < 0 {
+		log.Println(sc)
+		log.Fatal("Bad sidecar in architecture, retries and breaker can't be negative")
+	}
+	if sc.Budget < 0 || sc.Budget > 1 {
+		log.Println(sc)
+		log.Fatal("Bad sidecar retry budget in architecture, should be a fraction of the requests between 0 and 1")
+	}
+}
+
+// checkSizes validates the mix of instance sizes of a service, the fractions have to add up to the whole fleet
+func checkSizes(s containerV0r0) {
+	seen := make(map[string]bool)
+	total := 0.0
+	for _, z := range s.Sizes {
+		if l, err := time.ParseDuration(z.Latency); z.Name == "" || seen[z.Name] || z.Fraction <= 0 || z.Concurrency < 0 || z.Cost < 0 || z.Latency != "" && (err != nil || l < 0) {
+			log.Println(z)
+			log.Fatal("Bad size in architecture, needs a name of its own, a fraction, and a latency, concurrency and cost that aren't negative: " + s.Name)
+		}
+		seen[z.Name] = true
+		total += z.Fraction
+	}
+	if math.Abs(total-1) > 1e-6 {
+		log.Println(s.Sizes)
+		log.Fatal("Bad sizes in architecture, the fractions should add up to 1: " + s.Name)
+	}
+}
+
+// checkDNS validates a dns config
+func checkDNS(d *archaius.DNSConfig) {
+	l, err1 := time.ParseDuration(d.Latency)
+	t, err2 := time.ParseDuration(d.TTL)
+	if d.Latency != "" && (err1 != nil || l <= 0) || d.TTL != "" && (err2 != nil || t < 0) {
+		log.Println(d)
+		log.Fatal("Bad dns in architecture, needs a lookup latency, or none to turn it off, and a ttl that isn't negative")
+	}
+}
+
+// dependsOn is true if the service has the dependency
+func dependsOn(s containerV0r0, dep string) bool {
+	for _, d := range s.Dependencies {
+		if d == dep {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIngress validates the region weights, they have to be regions the architecture runs in and share out some traffic
+func checkIngress(a *archV0r1) {
+	if a.Ingress == nil {
+		return
+	}
+	total := 0
+	for r, w := range a.Ingress {
+		known := false
+		for _, rn := range archaius.Conf.RegionNames[:archaius.Conf.Regions] {
+			known = known || rn == r
+		}
+		if !known || w < 0 {
+			log.Fatalf("Bad ingress in architecture, %v should be one of the %v regions run with -w and have a weight that isn't negative\n", r, archaius.Conf.Regions)
+		}
+		total += w
+	}
+	if total == 0 {
+		log.Fatal("Bad ingress in architecture, the region weights add up to 0")
+	}
+}
+
+// checkSchedule validates the keyval changes, each needs a time and a key, an edge key has to be for an edge of the
+// architecture and a value the edge can take, and a chat rate has to be one the denominator will take
+func checkSchedule(a *archV0r1) {
+	deps := make(map[string]bool)
+	for _, s := range a.Services {
+		for _, d := range s.Dependencies {
+			deps[s.Name+"->"+d] = true
+		}
+	}
+	for _, kc := range a.Schedule {
+		t, err := time.ParseDuration(kc.At)
+		bad := err != nil || t < 0 || kc.Key == "" || strings.ContainsAny(kc.Key, ":,") || strings.Contains(kc.Value, ",")
+		if strings.HasPrefix(kc.Key, "edge.") {
+			ek := archaius.EdgeKeys(archaius.Configuration{Keyvals: kc.Key + ":" + kc.Value})
+			var e archaius.EdgeConfig
+			bad = bad || len(ek) != 1 || !deps[ek[0].From+"->"+ek[0].To] || e.Set(ek[0].Param, kc.Value) != nil
+		}
+		if kc.Key == "chat" {
+			d, err := time.ParseDuration(kc.Value)
+			bad = bad || err != nil || d < time.Millisecond || d > time.Hour
+		}
+		if bad {
+			log.Println(kc)
+			log.Fatal("Bad schedule in architecture, each change needs a time, a key and a value, edge keys for an edge of the architecture and chat between 1ms and 1h")
+		}
+	}
+}
+
+// checkEntrypoints validates the services the traffic enters at, they have to be services that lead into the rest of the
+// architecture and the last service, which sends the traffic, has to be a denominator. They're added to its dependencies if they
+// aren't already there, so it can find them
+func checkEntrypoints(a *archV0r1) {
+	if a.Entrypoints == nil {
+		return
+	}
+	if len(a.Entrypoints) == 0 || len(a.Services) == 0 || a.Services[len(a.Services)-1].Gopackage != packagenames.DenominatorPkg {
+		log.Fatal("Bad entrypoints in architecture, there has to be at least one, and the last service has to be a denominator to send them traffic")
+	}
+	source := &a.Services[len(a.Services)-1]
+	deps := make(map[string][]string) // only the dependencies that are services
+	for _, s := range a.Services {
+		deps[s.Name] = nil
+	}
+	for _, s := range a.Services {
+		for _, d := range s.Dependencies {
+			if _, ok := deps[d]; ok {
+				deps[s.Name] = append(deps[s.Name], d)
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	var entries []string
+	for _, e := range a.Entrypoints {
+		_, known := deps[e.Service]
+		if r, err := time.ParseDuration(e.Rate); !known || e.Service == source.Name || seen[e.Service] || (e.Rate != "" && (err != nil || r < time.Millisecond)) {
+			log.Println(e)
+			log.Fatal("Bad entrypoint in architecture, needs to be a service other than the last one, listed once, with a rate of at least 1ms: " + e.Service)
+		}
+		if s, err := time.ParseDuration(e.Setup); e.Ephemeral < 0 || e.Ephemeral > 1 || (e.Setup != "" && (err != nil || s <= 0)) {
+			log.Println(e)
+			log.Fatal("Bad entrypoint in architecture, ephemeral is a fraction from 0 to 1 and setup a duration: " + e.Service)
+		}
+		if ad := e.Adaptive; ad != nil {
+			m, merr := time.ParseDuration(ad.Min)
+			w, werr := time.ParseDuration(ad.Window)
+			if ad.Increase < 0 || ad.Decrease < 0 || ad.Decrease >= 1 || (ad.Min != "" && (merr != nil || m <= 0)) || (ad.Window != "" && (werr != nil || w < 0)) {
+				log.Println(e)
+				log.Fatal("Bad adaptive entrypoint in architecture, decrease is a fraction under 1, increase can't be negative and min and window are durations: " + e.Service)
+			}
+		}
+		seen[e.Service] = true
+		entries = append(entries, e.Service)
+		if !dependsOn(*source, e.Service) {
+			source.Dependencies = append(source.Dependencies, e.Service)
+		}
+	}
+	for _, e := range entries {
+		if len(a.Services) > 2 && len(reachable([]string{e}, deps, source.Name)) < 2 {
+			log.Fatal("Bad entrypoint in architecture, it doesn't lead to any other service: " + e)
+		}
+	}
+	reached := reachable(entries, deps, source.Name) // not back through the traffic source
+	var unreached []string
+	for _, s := range a.Services[:len(a.Services)-1] {
+		if !reached[s.Name] {
+			unreached = append(unreached, s.Name)
+		}
+	}
+	if len(unreached) > 0 {
+		log.Printf("architecture: warning, no traffic from the entrypoints reaches %v\n", strings.Join(unreached, " "))
+	}
+}
+
+// checkJourneys validates the user journeys, steps go to the services the last service in the list sends traffic to
+func checkJourneys(a *archV0r1) {
+	entry := make(map[string]bool)
+	if len(a.Services) > 0 {
+		for _, d := range a.Services[len(a.Services)-1].Dependencies {
+			entry[d] = true
+		}
+	}
+	seen := make(map[string]bool)
+	for _, j := range a.Journeys {
+		if r, err := time.ParseDuration(j.Rate); j.Name == "" || seen[j.Name] || err != nil || r < time.Millisecond || len(j.Steps) == 0 {
+			log.Println(j)
+			log.Fatal("Bad journey in architecture, needs a unique name, a rate of at least 1ms and one or more steps")
+		}
+		seen[j.Name] = true
+		for _, s := range j.Steps {
+			if s.Service != "" && !entry[s.Service] {
+				log.Println(j)
+				log.Fatal("Journey step service in architecture isn't an entry point: " + s.Service)
+			}
+		}
+	}
+}
+
+// checkProbes validates the synthetic monitors, they're sent from the last service in the list, which has to be a
+// denominator, to the services it sends traffic to
+func checkProbes(a *archV0r1) {
+	if len(a.Services) == 0 || a.Services[len(a.Services)-1].Gopackage != packagenames.DenominatorPkg {
+		log.Fatal("Bad probes in architecture, the last service has to be a denominator to send them")
+	}
+	entry := make(map[string]bool)
+	for _, d := range a.Services[len(a.Services)-1].Dependencies {
+		entry[d] = true
+	}
+	seen := make(map[string]bool)
+	for _, p := range a.Probes {
+		if i, err := time.ParseDuration(p.Interval); p.Name == "" || seen[p.Name] || err != nil || i < time.Millisecond || (p.Service != "" && !entry[p.Service]) {
+			log.Println(p)
+			log.Fatal("Bad probe in architecture, needs a unique name, an interval of at least 1ms and an entry point service: " + p.Name)
+		}
+		seen[p.Name] = true
+	}
+}
+
+// cycles finds the loops in the dependencies of services that pass requests on, each as the path around it.
+// Stores that depend on themselves only talk to their peers so they aren't loops, and a cycle is reported once for each way back into it
+func cycles(a *archV0r1) [][]string {
+	forwards := make(map[string]bool)
+	for _, p := range packagenames.Forwarders {
+		forwards[p] = true
+	}
+	deps := make(map[string][]string)
+	for _, s := range a.Services {
+		if forwards[s.Gopackage] {
+			deps[s.Name] = s.Dependencies
+		}
+	}
+	var found [][]string
+	var path []string
+	state := make(map[string]int) // 1 while on the path, 2 when done
+	var visit func(n string)
+	visit = func(n string) {
+		state[n] = 1
+		path = append(path, n)
+		for _, d := range deps[n] {
+			switch state[d] {
+			case 0:
+				visit(d)
+			case 1:
+				for i := range path {
+					if path[i] == d {
+						found = append(found, append(append([]string{}, path[i:]...), d))
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[n] = 2
+	}
+	for _, s := range a.Services {
+		if state[s.Name] == 0 {
+			visit(s.Name)
+		}
+	}
+	return found
+}
+
+// applyEdgeKeys overrides edge config with any edge.<from>-><to>.<param>:value keyvals from the command line
+func applyEdgeKeys(a *archV0r1) {
+	for _, k := range archaius.EdgeKeys(archaius.Conf) {
+		found := false
+		for i, s := range a.Services {
+			if s.Name != k.From {
+				continue
+			}
+			for _, d := range s.Dependencies {
+				if d == k.To {
+					found = true
+				}
+			}
+			if !found {
+				break
+			}
+			if s.Edges == nil {
+				a.Services[i].Edges = make(map[string]archaius.EdgeConfig)
+			}
+			e := a.Services[i].Edges[k.To]
+			if err := e.Set(k.Param, k.Value); err != nil {
+				log.Printf("architecture: warning, %v for %v->%v\n", err, k.From, k.To)
+				continue
+			}
+			a.Services[i].Edges[k.To] = e
+			log.Printf("architecture: edge %v->%v %v set to %v\n", k.From, k.To, k.Param, k.Value)
+		}
+		if !found {
+			log.Printf("architecture: warning, keyval refers to unknown edge %v->%v\n", k.From, k.To)
+		}
 	}
-	log.Fatal(e)
-	return nil
 }
 
 // MakeArch returns a new architecture object
@@ -153,6 +1314,39 @@ func AddContainer(a *archV0r1, name, machine, instance, container, process, gopa
 	a.Services = append(a.Services, c)
 }
 
+// AddEdge configures the calls from one service to a dependency
+func AddEdge(a *archV0r1, from, to string, e archaius.EdgeConfig) {
+	for i, s := range a.Services {
+		if s.Name == from {
+			if s.Edges == nil {
+				a.Services[i].Edges = make(map[string]archaius.EdgeConfig)
+			}
+			a.Services[i].Edges[to] = e
+			return
+		}
+	}
+}
+
+// Tag sets the tags of a service
+func Tag(a *archV0r1, name string, tags map[string]string) {
+	for i, s := range a.Services {
+		if s.Name == name {
+			a.Services[i].Tags = tags
+			return
+		}
+	}
+}
+
+// SetLatency sets the latency model of a service
+func SetLatency(a *archV0r1, name string, l *archaius.LatencyConfig) {
+	for i, s := range a.Services {
+		if s.Name == name {
+			a.Services[i].Latency = l
+			return
+		}
+	}
+}
+
 // Write coverts the architecture to json and writes to stdout
 func Write(a *archV0r1) {
 	b, err := json.Marshal(a)
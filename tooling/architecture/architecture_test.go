@@ -2,9 +2,12 @@
 package architecture
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius" // global configuration
+	"math"
+	"strings"
 	"testing"
 	"time"
 )
@@ -55,10 +58,10 @@ func TestGraph(t *testing.T) {
 	//archaius.Conf.StopStep = 0
 	archaius.Conf.EurekaPoll = "1s"
 	try(testJSONarchV0r1)
-	//ReadArch("testDuplicate") // these three are designed to fail, uncomment one at a time to check
-	//ReadArch("testMissingDep")
-	//ReadArch("testBadPackage")
-	a := ReadArch("test")
+	a, err := ReadArch("test")
+	if err != nil {
+		t.Fatal(err)
+	}
 	fmt.Println(a)
 	var services []string
 	var deps []Connection
@@ -68,3 +71,288 @@ func TestGraph(t *testing.T) {
 	fmt.Println(deps)
 	Start(a)
 }
+
+// round trip through protobuf should give back the same json
+func TestProtobuf(t *testing.T) {
+	testJSON := `
+		{
+		"arch":"pbtest",
+		"version":"arch-0.1",
+		"description":"every field set",
+		"args":"[spigo -a pbtest]",
+		"date":"2016-05-01T10:00:00Z",
+		"victim":"app",
+		"partitions":[ { "groups":[["us-east-1"],["us-west-2","eu-west-1"]], "start":"1s", "duration":"2s" } ],
+		"chaos":{ "interval":"2s", "probability":0.5, "max":2, "services":["app"], "coldstart":"500ms", "events":[ { "action":"kill", "at":"5s", "service":"app", "count":3 }, { "action":"latency", "at":"1s", "service":"store", "percent":50, "latency":"200ms", "duration":"2s" }, { "action":"partition", "at":"2s", "region":"us-west-2" }, { "action":"evacuate", "at":"3s", "region":"us-east-1", "duration":"4s" } ] },
+		"zones":{ "count":2, "latency":"1ms", "outages":[ { "zone":"zoneA", "start":"3s" }, { "zone":"zoneB", "region":"us-west-2", "start":"4s" } ] },
+		"correlated":{ "groups":[ { "name":"rack1", "services":["app","store"], "fraction":0.5 } ], "events":[ { "group":"rack1", "start":"1s", "duration":"2s", "latency":"200ms" } ] },
+		"sidecar":{ "latency":"1ms", "handshake":"5ms" },
+		"dns":{ "latency":"20ms", "ttl":"5s" },
+		"journeys":[ { "name":"browse", "rate":"100ms", "steps":[ { "service":"app", "request":"home" }, { "request":"row" } ] } ],
+		"ingress":{ "us-east-1":60, "eu-west-1":40 },
+		"wan":{ "latency":{ "us-east-1 eu-west-1":"40ms", "us-east-1 us-west-2":"0s" } },
+		"deployments":[ { "service":"app", "version":"v2", "start":"2s", "batch":2, "bake":"500ms", "latency":"5ms", "errors":0.01 }, { "service":"store", "version":"v3", "start":"4s", "bake":"1s", "strategy":"bluegreen", "rollback":0.05, "watch":"3s" } ],
+		"flags":[ { "name":"newrecs", "schedule":[ { "at":"5s", "on":true }, { "at":"10s" } ] }, { "name":"dark", "on":true } ],
+		"entrypoints":[ { "service":"app", "rate":"20ms", "ephemeral":0.3, "setup":"40ms" }, { "service":"store", "adaptive":{ "increase":5, "decrease":0.7, "min":"500ms", "window":"50ms" } } ],
+		"schedule":[ { "at":"30s", "key":"chat", "value":"5ms" }, { "at":"60s", "key":"edge.app->store.timeout", "value":"50ms" } ],
+		"probes":[ { "name":"canary", "service":"app", "request":"home", "interval":"1s" } ],
+		"services":[
+		{ "name":"store", "machine":"m3.xlarge", "instance":"db", "container":"mysql", "process":"mysqld", "package":"store", "regions":1, "count":2, "dependencies":["store"],
+		  "replication":{ "mode":"quorum", "replicas":2, "lag":"50ms", "w":2, "r":1 },
+		  "gc":{ "interval":"5s", "pause":"20ms", "distribution":"exponential" },
+		  "memory":{ "limit":512, "request":0.5, "model":"cumulative", "restart":"2s", "slowdown":"50ms" },
+		  "caching":{ "pattern":"writebehind", "flush":"50ms", "capacity":1000, "eviction":"lfu", "warm":"2s", "flushes":["3s","6s"] },
+		  "health":{ "latency":0.5, "errors":0.3, "inflight":0.2, "target":"20ms", "concurrency":4, "window":"2s" },
+		  "leader":{ "size":3, "election":"500ms", "writes":"block" },
+		  "startup":{ "latency":"50ms", "warm":"10s" },
+		  "wal":{ "fsync":"2ms", "distribution":"uniform", "group":"5ms" },
+		  "lock":{ "keys":[ { "name":"leader", "hold":"20ms", "distribution":"fixed", "weight":3 }, { "name":"config", "hold":"5ms" } ], "timeout":"1s" },
+		  "keyaccess":{ "distribution":"zipf", "skew":1.2 },
+		  "saga":{ "steps":[ { "service":"store", "request":"reserve", "compensation":"release", "errors":0.1 }, { "service":"cache" } ], "timeout":"500ms", "retries":2 },
+		  "twophase":{ "transactions":[ { "name":"transfer", "participants":["store", "cache"], "aborts":0.05 }, { "participants":["store"] } ], "timeout":"300ms", "retries":1 },
+		  "external":{ "rate":50, "burst":10, "latency":"80ms", "distribution":"uniform", "outages":[ { "start":"2s", "duration":"1s" } ] } },
+		{ "name":"app", "package":"karyon", "regions":1, "count":3, "dependencies":["store", "cache"], "baggage":["user", ""], "sessions":100, "deadline":"1s", "apdex":"50ms", "sla":99.9, "drain":{ "delay":"100ms", "timeout":"2s" },
+		  "latency":{ "distribution":"bimodal", "median":"5ms", "sigma":0.1, "slow":"80ms", "slowfraction":0.05, "max":"2s" },
+		  "topic":{ "partitions":4, "depth":1000, "lag":"50ms", "session":"5s", "retries":2 },
+		  "edges":{ "store":{ "when":"always", "latency":"2ms", "response":"5ms", "timeout":"100ms", "weight":3, "connections":4, "balance":"adaptive", "window":"2s", "fallback":"stale", "format":"json", "payload":2048, "responsepayload":8192, "mirror":"cache", "mirrorfraction":0.25, "fanout":3, "warmup":"10ms", "warmupcalls":3, "keepalive":"30s", "backoff":"jitter", "backoffbase":"20ms", "backoffcap":"500ms", "breaker":0.5, "breakervolume":10, "breakerwindow":"5s", "breakersleep":"2s", "group":"billing" }, "cache":{ "weight":1, "balance":"sticky", "rehome":"20ms", "pages":3, "pagelatency":"5ms", "flag":"!newrecs", "batch":10, "batchwindow":"2ms", "batchmaxwait":"8ms", "batchscale":0.3, "speculate":0.5, "speculateafter":"10ms", "cancelwork":0.25 } },
+		  "autoscale":{ "target":"latency", "min":1, "max":10, "interval":"1s", "up":2, "down":1, "queue":"q", "depth":-1, "health":0.5, "rps":50, "concurrency":4, "cooldown":"5s" },
+		  "queue":{ "visibility":"1s", "retries":3, "concurrency":2 }, "version":"v2", "errors":0.01, "think":"10ms", "dedup":"10s",
+		  "coalesce":{ "key":"user", "window":"200ms" },
+		  "sidecar":{ "latency":"500us", "handshake":"2ms", "retries":2, "breaker":5, "open":"3s", "budget":0.2, "window":"5s" },
+		  "dns":{ "latency":"5ms" },
+		  "sizes":[ { "name":"large", "fraction":0.25, "concurrency":8, "cost":0.4 }, { "name":"small", "fraction":0.75, "latency":"4ms", "concurrency":2 } ],
+		  "cost":0.1, "shed":{ "concurrency":4, "queue":20, "discipline":"codel", "target":"10ms", "interval":"200ms", "backpressure":true, "weights":{ "gold":4, "silver":1 } },
+		  "admission":{ "controller":"pid", "target":"20ms", "interval":"50ms", "kp":0.2, "ki":1.5, "kd":0.01 },
+		  "tags":{ "tier":"frontend", "team":"" } }
+		]
+		}`
+	a := new(archV0r1)
+	err := json.Unmarshal([]byte(testJSON), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := MarshalPB(a)
+	b, err := UnmarshalPB(pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := json.Marshal(a)
+	after, _ := json.Marshal(b)
+	fmt.Printf("%v bytes of json, %v bytes of protobuf\n", len(before), len(pb))
+	if string(before) != string(after) {
+		t.Error("protobuf round trip changed the architecture\n" + string(before) + "\n" + string(after))
+	}
+}
+
+// loops through services that pass requests on are found, stores that talk to their peers aren't
+func TestCycles(t *testing.T) {
+	a := MakeArch("cycles", "a cycle")
+	AddContainer(a, "db", "", "", "", "", "store", 1, 2, []string{"db", "app"})
+	AddContainer(a, "app", "", "", "", "", "karyon", 1, 2, []string{"api", "db"})
+	AddContainer(a, "api", "", "", "", "", "karyon", 1, 2, []string{"app"})
+	c := cycles(a)
+	fmt.Println("Cycles: ", c)
+	if len(c) != 1 || len(c[0]) != 3 || c[0][0] != "app" || c[0][2] != "app" {
+		t.Fail()
+	}
+}
+
+// a model should load back exactly as it was saved, and an architecture file should migrate up from schema 1
+func TestModel(t *testing.T) {
+	archaius.Conf.Regions = 1
+	archaius.Conf.Population = 100
+	a := MakeArch("modeltest", "a model")
+	AddContainer(a, "store", "", "", "", "", "store", 1, 3, []string{})
+	AddContainer(a, "app", "", "", "", "", "karyon", 1, 2, []string{"store"})
+	AddEdge(a, "app", "store", archaius.EdgeConfig{Latency: "2ms"})
+	AddContainer(a, "elb", "", "", "", "", "elb", 1, 0, []string{"app"})
+	AddContainer(a, "www", "", "", "", "", "denominator", 0, 0, []string{"elb"})
+	before, _ := json.Marshal(NewModel(a))
+	m, err := ParseModel(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := json.Marshal(m)
+	if string(before) != string(after) {
+		t.Error("model round trip changed it\n" + string(before) + "\n" + string(after))
+	}
+	if len(m.Instances) != 7 || m.Instances[6].Region != "*" {
+		t.Error("wrong instances in model", m.Instances)
+	}
+	arch, _ := json.Marshal(a)
+	m, err = ParseModel(arch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, _ = json.Marshal(m); m.Schema != ModelSchema || string(before) != string(after) {
+		t.Error("architecture file didn't migrate to the same model\n" + string(after))
+	}
+	if _, err = ParseModel([]byte(`{"schema":99}`)); err == nil {
+		t.Error("newer schema should fail")
+	}
+}
+
+func TestTerraform(t *testing.T) {
+	a := MakeArch("tftest", "a terraform skeleton")
+	AddContainer(a, "store", "", "", "", "", "store", 1, 3, []string{})
+	AddContainer(a, "app", "", "", "", "", "karyon", 1, 2, []string{"store"})
+	AddContainer(a, "elb", "", "", "", "", "elb", 1, 0, []string{"app"})
+	AddContainer(a, "www", "", "", "", "", "denominator", 0, 0, []string{"elb"})
+	var b bytes.Buffer
+	terraform(&b, a, 2, 100)
+	tf := b.String()
+	for _, want := range []string{
+		`resource "aws_autoscaling_group" "store_us-west-2"`,
+		`desired_capacity   = 3`,
+		`availability_zones = ["us-east-1a", "us-east-1b", "us-east-1c"]`,
+		`resource "aws_lb" "elb_us-east-1"`,
+		`records = [aws_lb.elb_us-east-1.dns_name, aws_lb.elb_us-west-2.dns_name]`,
+	} {
+		if !strings.Contains(tf, want) {
+			t.Errorf("terraform is missing %v\n%v", want, tf)
+		}
+	}
+}
+
+// single instances and zones, services that cut off the rest and dependencies without a fallback are reported, riskiest first
+func TestRisk(t *testing.T) {
+	a := MakeArch("risktest", "a risk report")
+	AddContainer(a, "store", "", "", "", "", "store", 1, 1, []string{"eureka"})
+	AddContainer(a, "cache", "", "", "", "", "store", 1, 3, []string{"cache"})
+	AddContainer(a, "app", "", "", "", "", "karyon", 1, 2, []string{"store", "cache"})
+	a.Services[2].Edges = map[string]archaius.EdgeConfig{"cache": {Fallback: "stale"}}
+	AddContainer(a, "elb", "", "", "", "", "elb", 1, 0, []string{"app"})
+	AddContainer(a, "www", "", "", "", "", "denominator", 0, 0, []string{"elb"})
+	r := risks(a, 1, 100)
+	var order []string
+	for _, s := range r.Services {
+		order = append(order, s.Service)
+	}
+	if strings.Join(order, " ") != "elb app www store cache" || strings.Join(r.SinglePoints, " ") != "store" {
+		t.Errorf("wrong order %v or single points %v", order, r.SinglePoints)
+	}
+	if app := r.Services[1]; app.Disconnects != 0.5 || math.Abs(app.Betweenness-1.0/3) > 1e-9 || app.Zones != 2 || len(app.Risks) != 2 {
+		t.Errorf("app %+v", app)
+	}
+	if store := r.Services[3]; store.Disconnects != 0 || store.Risks[0] != "single instance" {
+		t.Errorf("store %+v", store)
+	}
+	if len(r.NoFallback) != 3 || r.NoFallback[0] != (EdgeRisk{"app", "store", true}) {
+		t.Errorf("dependencies without a fallback %v", r.NoFallback)
+	}
+}
+
+// losing a service cuts off what's only behind it, and the traffic impacted comes from the flows when there are some
+func TestImpact(t *testing.T) {
+	a := MakeArch("impacttest", "an impact report")
+	AddContainer(a, "store", "", "", "", "", "store", 1, 1, []string{"eureka"})
+	AddContainer(a, "cache", "", "", "", "", "store", 1, 3, []string{})
+	AddContainer(a, "app", "", "", "", "", "karyon", 1, 2, []string{"store", "cache"})
+	AddContainer(a, "admin", "", "", "", "", "karyon", 1, 1, []string{"store"})
+	AddContainer(a, "www", "", "", "", "", "denominator", 0, 0, []string{"app", "admin"})
+	a.Entrypoints = []archaius.Entrypoint{{Service: "app", Rate: "10ms"}, {Service: "admin", Rate: "30ms"}}
+	r := impact(a, "app", nil)
+	if strings.Join(r.Unreachable, " ") != "cache" || math.Abs(r.Traffic-0.75) > 1e-9 || len(r.Entrypoints) != 2 || r.Entrypoints[0].Affected || !r.Entrypoints[1].Affected {
+		t.Errorf("static impact %+v", r)
+	}
+	var spans []flowSpan
+	if err := json.Unmarshal([]byte(`[
+		{"traceId":"1","id":"1","annotations":[{"endpoint":{"serviceName":"impacttest.*.*..www00...www.denominator"},"value":"cs"},{"endpoint":{"serviceName":"impacttest.us-east-1.zoneA..admin0...admin.karyon"},"value":"sr"}]},
+		{"traceId":"1","id":"2","parentId":"1","annotations":[{"endpoint":{"serviceName":"impacttest.us-east-1.zoneA..store0...store.store"},"value":"sr"}]},
+		{"traceId":"2","id":"3","annotations":[{"endpoint":{"serviceName":"impacttest.*.*..www00...www.denominator"},"value":"cs"},{"endpoint":{"serviceName":"impacttest.us-east-1.zoneA..app0...app.karyon"},"value":"sr"}]},
+		{"traceId":"2","id":"4","parentId":"3","annotations":[{"endpoint":{"serviceName":"impacttest.us-east-1.zoneA..cache0...cache.store"},"value":"sr"}]},
+		{"traceId":"3","id":"5","annotations":[{"endpoint":{"serviceName":"impacttest.us-east-1.zoneA..app1...app.karyon"},"value":"sr"}]}]`), &spans); err != nil {
+		t.Fatal(err)
+	}
+	if r := impact(a, "store", spans); r.Traces != 2 || r.Traffic != 0.5 || r.Entrypoints[0].Impacted != 1 || r.Entrypoints[1].Impacted != 0 {
+		t.Errorf("flow impact %+v", r)
+	}
+}
+
+// every limit of an invariant is checked against the expanded instances, and only for the services the rule selects
+func TestInvariants(t *testing.T) {
+	archaius.Conf.Regions = 1
+	archaius.Conf.Population = 100
+	a := MakeArch("invtest", "invariants")
+	AddContainer(a, "store", "", "", "", "", "store", 1, 3, []string{})
+	AddContainer(a, "cache", "", "", "", "", "store", 1, 1, []string{})
+	AddContainer(a, "app", "", "", "", "", "karyon", 1, 2, []string{"store", "cache", "store"})
+	Tag(a, "app", map[string]string{"tier": "frontend"})
+	AddContainer(a, "elb", "", "", "", "", "elb", 1, 0, []string{"app"})
+	AddContainer(a, "www", "", "", "", "", "denominator", 0, 0, []string{"elb"})
+	rules := []Invariant{
+		{Rule: "frontends", Tag: "tier=frontend", MinInstances: 3, MinZones: 3},
+		{Rule: "fanout", MaxFanout: 1},
+		{Rule: "stores", Services: []string{"*e"}, Package: "store", MinZones: 2, MinRegions: 2},
+		{Rule: "elb", Services: []string{"elb"}, MinZones: 3, MaxFanin: 0, MinInstances: 1},
+	}
+	v := violations(a, rules, 1, 100)
+	want := []string{
+		`"frontends": app has 2 instances, needs at least 3`,
+		`"frontends": app is in 2 zones of us-east-1, needs at least 3`,
+		`"fanout": app depends on 2 services, more than 1`,
+		`"stores": store is in 1 regions, needs at least 2`,
+		`"stores": cache is in 1 regions, needs at least 2`,
+		`"stores": cache is in 1 zones of us-east-1, needs at least 2`,
+	}
+	if strings.Join(v, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong violations\n%v", strings.Join(v, "\n"))
+	}
+	if v := violations(a, rules[2:], 2, 100); len(v) != 2 || v[1] != `"stores": cache is in 1 zones of us-west-2, needs at least 2` {
+		t.Errorf("wrong violations in two regions %v", v)
+	}
+}
+
+// each malformed definition reports all its problems with where they are, rather than failing part way through starting it
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		arch string
+		want []string
+	}{
+		{"testDuplicate", []string{`json_arch/testDuplicate_arch.json:12: service "signup-node": duplicate name, also used by services[2]`}},
+		{"testMissingDep", []string{`json_arch/testMissingDep_arch.json:12: service "www-proxy": unknown dependency "missing"`}},
+		{"testBadPackage", []string{`json_arch/testBadPackage_arch.json:11: service "signup-node": unknown package "unknown", should be one of `}},
+		{"testSyntax", []string{`json_arch/testSyntax_arch.json:11: invalid character '"' after object key:value pair`}},
+		{"testNothing", []string{`architecture testNothing isn't recognized`}},
+	} {
+		_, err := ReadArch(c.arch)
+		if err == nil {
+			t.Errorf("%v: no error", c.arch)
+			continue
+		}
+		got := strings.Split(err.Error(), "\n")
+		if len(got) != len(c.want) {
+			t.Errorf("%v: got %q, want %q", c.arch, got, c.want)
+			continue
+		}
+		for i := range got {
+			if !strings.HasPrefix(got[i], c.want[i]) {
+				t.Errorf("%v: got %q, want %q", c.arch, got[i], c.want[i])
+			}
+		}
+	}
+	a := &archV0r1{Services: []containerV0r0{
+		{Name: "web", Gopackage: "karyon", Dependencies: []string{"db", "cache"}},
+		{Name: "web", Gopackage: "nosuch"},
+		{Gopackage: "store"},
+	}}
+	errs := Validate(a)
+	want := []string{
+		`services[1]: service "web": duplicate name, also used by services[0]`,
+		`services[1]: service "web": unknown package "nosuch"`,
+		`services[2]: service "": has no name`,
+		`services[0]: service "web": unknown dependency "db"`,
+		`services[0]: service "web": unknown dependency "cache"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %v, want %q", errs, want)
+	}
+	for i, e := range errs {
+		if !strings.HasPrefix(e.Error(), want[i]) {
+			t.Errorf("got %q, want %q", e.Error(), want[i])
+		}
+	}
+	if errs := Validate(&archV0r1{}); len(errs) != 1 || errs.Error() != "there are no services" {
+		t.Errorf("got %v for an empty architecture", errs)
+	}
+}
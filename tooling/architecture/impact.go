@@ -0,0 +1,235 @@
+package architecture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/adrianco/spigo/actors/packagenames"
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// ImpactReport is what would break if a service disappeared, assuming none of the calls to it have a fallback
+type ImpactReport struct {
+	Arch        string        `json:"arch"`
+	Service     string        `json:"service"`
+	Flows       string        `json:"flows,omitempty"`  // flow file of an earlier run the traffic was measured from
+	Traces      int           `json:"traces,omitempty"` // requests in the flows
+	Traffic     float64       `json:"traffic"`          // fraction of the requests that would fail
+	Entrypoints []EntryImpact `json:"entrypoints"`
+	Unreachable []string      `json:"unreachable"` // other services that no requests would get to
+}
+
+// EntryImpact is how much of the traffic into an entry point would fail
+type EntryImpact struct {
+	Service  string  `json:"service"`
+	Affected bool    `json:"affected"`         // the service is the entry point or is reachable from it
+	Share    float64 `json:"share"`            // of the traffic coming in at this entry point
+	Traces   int     `json:"traces,omitempty"` // requests into the entry point in the flows
+	Impacted int     `json:"impacted,omitempty"`
+}
+
+// flowSpan is what the impact report needs of a span in a flow file
+type flowSpan struct {
+	TraceID     string `json:"traceId"`
+	ParentID    string `json:"parentId"`
+	Annotations []struct {
+		Endpoint struct {
+			ServiceName string `json:"serviceName"`
+		} `json:"endpoint"`
+		Value string `json:"value"`
+	} `json:"annotations"`
+}
+
+// server is the service that received a span, empty if it wasn't received
+func (s flowSpan) server() string {
+	for _, a := range s.Annotations {
+		if a.Value == "sr" {
+			return names.Service(a.Endpoint.ServiceName)
+		}
+	}
+	return ""
+}
+
+// sent is true if a span was sent by a client, rather than started by the service itself
+func (s flowSpan) sent() bool {
+	for _, a := range s.Annotations {
+		if a.Value == "cs" {
+			return true
+		}
+	}
+	return false
+}
+
+// entrypoints of the traffic, the configured ones, or the dependencies of a denominator at the end of the services, or the
+// services nothing depends on
+func entrypoints(a *archV0r1, deps map[string][]string) []string {
+	var entries []string
+	for _, e := range a.Entrypoints {
+		entries = append(entries, e.Service)
+	}
+	if entries != nil || len(a.Services) == 0 {
+		return entries
+	}
+	if last := a.Services[len(a.Services)-1]; last.Gopackage == packagenames.DenominatorPkg {
+		return deps[last.Name]
+	}
+	calledBy := make(map[string]bool)
+	for _, ds := range deps {
+		for _, d := range ds {
+			calledBy[d] = true
+		}
+	}
+	for _, s := range a.Services {
+		if !calledBy[s.Name] {
+			entries = append(entries, s.Name)
+		}
+	}
+	return entries
+}
+
+// impact works out what breaks without the service from the dependency graph, with the traffic spread over the entry points
+// by their rates, or measured from the spans of an earlier run if there are any
+func impact(a *archV0r1, service string, spans []flowSpan) ImpactReport {
+	r := ImpactReport{Arch: a.Arch, Service: service, Entrypoints: []EntryImpact{}, Unreachable: []string{}}
+	known := make(map[string]bool)
+	for _, s := range a.Services {
+		known[s.Name] = true
+	}
+	deps := make(map[string][]string) // without self dependencies or names that aren't services, like eureka
+	for _, s := range a.Services {
+		for _, d := range s.Dependencies {
+			if known[d] && d != s.Name {
+				deps[s.Name] = append(deps[s.Name], d)
+			}
+		}
+	}
+	entries := entrypoints(a, deps)
+	source := ""
+	if len(a.Services) > 0 && a.Services[len(a.Services)-1].Gopackage == packagenames.DenominatorPkg {
+		source = a.Services[len(a.Services)-1].Name // sends the traffic, so it's not a way round the missing service
+	}
+	reached := reachable(entries, deps, source)
+	without := reachable(entries, deps, service)
+	for _, s := range a.Services {
+		if s.Name != service && s.Name != source && reached[s.Name] && !without[s.Name] {
+			r.Unreachable = append(r.Unreachable, s.Name)
+		}
+	}
+	chat, err := time.ParseDuration(archaius.Key(archaius.Conf, "chat"))
+	if err != nil || chat <= 0 {
+		chat = 10 * time.Millisecond
+	}
+	rates := make(map[string]float64) // requests per second into each entry point, at the chat rate unless they have their own
+	for _, e := range entries {
+		rates[e] = float64(time.Second) / float64(chat)
+	}
+	for _, e := range a.Entrypoints {
+		if d, err := time.ParseDuration(e.Rate); err == nil && d > 0 {
+			rates[e.Service] = float64(time.Second) / float64(d)
+		}
+	}
+	var total float64
+	for _, e := range entries {
+		total += rates[e]
+	}
+	for _, e := range entries {
+		ei := EntryImpact{Service: e, Affected: reachable([]string{e}, deps, source)[service]}
+		if total > 0 {
+			ei.Share = rates[e] / total
+		}
+		if ei.Affected {
+			r.Traffic += ei.Share
+		}
+		r.Entrypoints = append(r.Entrypoints, ei)
+	}
+	if len(spans) == 0 {
+		sort.Sort(byEntryName(r.Entrypoints))
+		return r
+	}
+	into := make(map[string]string) // entry point of each trace, from its root span
+	hit := make(map[string]bool)    // traces that went through the service
+	for _, s := range spans {
+		if s.ParentID == "" && s.sent() {
+			into[s.TraceID] = s.server()
+		}
+		if s.server() == service {
+			hit[s.TraceID] = true
+		}
+	}
+	traces := make(map[string]int) // by entry point
+	impacted := make(map[string]int)
+	for t, e := range into {
+		traces[e]++
+		if hit[t] || e == service {
+			impacted[e]++
+		}
+	}
+	listed := make(map[string]bool)
+	for _, ei := range r.Entrypoints {
+		listed[ei.Service] = true
+	}
+	for e := range traces { // traffic went somewhere the graph doesn't have as an entry point
+		if !listed[e] {
+			r.Entrypoints = append(r.Entrypoints, EntryImpact{Service: e})
+		}
+	}
+	r.Traces = len(into)
+	r.Traffic = 0
+	for i := range r.Entrypoints {
+		ei := &r.Entrypoints[i]
+		ei.Traces, ei.Impacted = traces[ei.Service], impacted[ei.Service]
+		ei.Affected = ei.Affected || ei.Impacted > 0
+		if r.Traces > 0 {
+			ei.Share = float64(ei.Traces) / float64(r.Traces)
+			r.Traffic += float64(ei.Impacted) / float64(r.Traces)
+		}
+	}
+	sort.Sort(byEntryName(r.Entrypoints))
+	return r
+}
+
+type byEntryName []EntryImpact
+
+func (e byEntryName) Len() int           { return len(e) }
+func (e byEntryName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byEntryName) Less(i, j int) bool { return e[i].Service < e[j].Service }
+
+// WriteImpact saves the impact of the service disappearing to json/<arch>_impact.json, measuring the traffic from the flows of
+// an earlier run in json_metrics/<arch>_flow.json if there is one
+func WriteImpact(a *archV0r1, service string) {
+	found := false
+	for _, s := range a.Services {
+		found = found || s.Name == service
+	}
+	if !found {
+		log.Fatal("impact: " + service + " isn't a service in " + a.Arch)
+	}
+	var spans []flowSpan
+	ff := "json_metrics/" + a.Arch + "_flow.json"
+	if data, err := ioutil.ReadFile(ff); err == nil {
+		if err := json.Unmarshal(data, &spans); err != nil {
+			log.Println("impact: ignoring flows that can't be read in " + ff + ": " + err.Error())
+			spans = nil
+		}
+	} else if !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	r := impact(a, service, spans)
+	if r.Traces > 0 {
+		r.Flows = ff
+	}
+	fn := "json/" + a.Arch + "_impact.json"
+	log.Printf("Writing impact of losing %v, %.0f%% of the traffic and %v services unreachable, to %v\n", service, 100*r.Traffic, len(r.Unreachable), fn)
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fn, append(j, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,176 @@
+package architecture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// Invariant is a rule about the structure of an architecture once its services have been expanded into instances at the -w and -p
+// of the run. It applies to the services that match all of its selectors, and each limit that's set has to hold for every one of them
+type Invariant struct {
+	Rule         string   `json:"rule"`               // what it's for, shown with each violation
+	Services     []string `json:"services,omitempty"` // names or patterns such as "*store", every service if empty
+	Package      string   `json:"package,omitempty"`
+	Tag          string   `json:"tag,omitempty"` // key=value
+	MinInstances int      `json:"mininstances,omitempty"`
+	MaxInstances int      `json:"maxinstances,omitempty"`
+	MinZones     int      `json:"minzones,omitempty"` // in every region it runs in, cross zone services are in all of them
+	MinRegions   int      `json:"minregions,omitempty"`
+	MaxFanout    int      `json:"maxfanout,omitempty"` // services it depends on
+	MaxFanin     int      `json:"maxfanin,omitempty"`  // services that depend on it
+}
+
+// ReadInvariants parses a file of invariants, {"invariants":[...]}, failing on rules that don't select or check anything sensible
+func ReadInvariants(fn string) []Invariant {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var f struct {
+		Invariants []Invariant `json:"invariants"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Fatal(fn + ": " + err.Error())
+	}
+	for _, r := range f.Invariants {
+		if r.Tag != "" && !strings.Contains(r.Tag, "=") {
+			log.Fatalf("Bad invariant %q in %v, tag %v should be key=value\n", r.Rule, fn, r.Tag)
+		}
+		for _, p := range r.Services {
+			if _, err := path.Match(p, ""); err != nil {
+				log.Fatalf("Bad invariant %q in %v, service pattern %v: %v\n", r.Rule, fn, p, err)
+			}
+		}
+		if r.MinInstances == 0 && r.MaxInstances == 0 && r.MinZones == 0 && r.MinRegions == 0 && r.MaxFanout == 0 && r.MaxFanin == 0 {
+			log.Fatalf("Bad invariant %q in %v, it doesn't set any limits\n", r.Rule, fn)
+		}
+	}
+	return f.Invariants
+}
+
+// selects is true if the service matches every selector of the rule
+func (r Invariant) selects(s containerV0r0) bool {
+	if r.Package != "" && r.Package != s.Gopackage {
+		return false
+	}
+	if r.Tag != "" {
+		kv := strings.SplitN(r.Tag, "=", 2)
+		if v, ok := s.Tags[kv[0]]; !ok || v != kv[1] {
+			return false
+		}
+	}
+	if len(r.Services) == 0 {
+		return true
+	}
+	for _, p := range r.Services {
+		if ok, _ := path.Match(p, s.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// violations checks the invariants against the instances the architecture expands to at the regions and population, and
+// returns a line for each service that breaks a rule
+func violations(a *archV0r1, rules []Invariant, regions, population int) []string {
+	zones := archaius.Conf.ZoneNames
+	if a.Zones != nil && a.Zones.Count > 0 && a.Zones.Count < len(zones) {
+		zones = zones[:a.Zones.Count]
+	}
+	count := make(map[string]int)
+	inRegions := make(map[string]map[string]map[string]bool) // zones of each service in each region
+	for _, i := range instances(a, regions, population) {
+		count[i.Service]++
+		if inRegions[i.Service] == nil {
+			inRegions[i.Service] = make(map[string]map[string]bool)
+		}
+		rs := []string{i.Region}
+		if i.Region == "*" {
+			rs = archaius.Conf.RegionNames[:regions]
+		}
+		for _, r := range rs {
+			if inRegions[i.Service][r] == nil {
+				inRegions[i.Service][r] = make(map[string]bool)
+			}
+			zs := []string{i.Zone}
+			if i.Zone == "*" {
+				zs = zones
+			}
+			for _, z := range zs {
+				inRegions[i.Service][r][z] = true
+			}
+		}
+	}
+	fanout := make(map[string]int)
+	fanin := make(map[string]int)
+	for _, s := range a.Services {
+		seen := make(map[string]bool)
+		for _, d := range s.Dependencies {
+			if !seen[d] && d != s.Name {
+				seen[d] = true
+				fanout[s.Name]++
+				fanin[d]++
+			}
+		}
+	}
+	var v []string
+	for _, r := range rules {
+		matched := false
+		for _, s := range a.Services {
+			if !r.selects(s) {
+				continue
+			}
+			matched = true
+			fail := func(format string, args ...interface{}) {
+				v = append(v, fmt.Sprintf("%q: %v ", r.Rule, s.Name)+fmt.Sprintf(format, args...))
+			}
+			n := count[s.Name]
+			if r.MinInstances > 0 && n < r.MinInstances {
+				fail("has %v instances, needs at least %v", n, r.MinInstances)
+			}
+			if r.MaxInstances > 0 && n > r.MaxInstances {
+				fail("has %v instances, more than %v", n, r.MaxInstances)
+			}
+			if r.MinRegions > 0 && len(inRegions[s.Name]) < r.MinRegions {
+				fail("is in %v regions, needs at least %v", len(inRegions[s.Name]), r.MinRegions)
+			}
+			if r.MinZones > 0 {
+				for _, rn := range archaius.Conf.RegionNames[:regions] {
+					if zs, ok := inRegions[s.Name][rn]; ok && len(zs) < r.MinZones {
+						fail("is in %v zones of %v, needs at least %v", len(zs), rn, r.MinZones)
+					}
+				}
+			}
+			if r.MaxFanout > 0 && fanout[s.Name] > r.MaxFanout {
+				fail("depends on %v services, more than %v", fanout[s.Name], r.MaxFanout)
+			}
+			if r.MaxFanin > 0 && fanin[s.Name] > r.MaxFanin {
+				fail("is a dependency of %v services, more than %v", fanin[s.Name], r.MaxFanin)
+			}
+		}
+		if !matched {
+			log.Printf("Invariant %q doesn't select any services of %v\n", r.Rule, a.Arch)
+		}
+	}
+	return v
+}
+
+// CheckInvariants fails the run, listing every violation, if the expanded architecture breaks any of the invariants in the file
+func CheckInvariants(a *archV0r1, fn string) {
+	rules := ReadInvariants(fn)
+	v := violations(a, rules, archaius.Conf.Regions, archaius.Conf.Population)
+	if len(v) == 0 {
+		log.Printf("Architecture %v keeps the %v invariants in %v\n", a.Arch, len(rules), fn)
+		return
+	}
+	for _, s := range v {
+		log.Println("Invariant violated " + s)
+	}
+	log.Fatalf("Bad architecture %v, %v invariant violations\n", a.Arch, len(v))
+}
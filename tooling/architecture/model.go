@@ -0,0 +1,156 @@
+package architecture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// ModelSchema is the version of the model written by WriteModel, models with older versions are migrated up to it when they're read
+const ModelSchema = 2
+
+// ModelV2 is the complete architecture model that a run is created from, the services with their dependencies and every configured
+// latency and policy, the settings that scale and spread them, and the instances that come out of that. Unlike the graph outputs it
+// loads back exactly, so it's the format to exchange architectures with other tools
+type ModelV2 struct {
+	Schema     int             `json:"schema"`
+	Arch       *archV0r1       `json:"arch"`
+	Regions    int             `json:"regions"`
+	Population int             `json:"population"`        // scale factor % applied to the service counts
+	Keyvals    string          `json:"keyvals,omitempty"` // defaults and edge overrides for every service
+	Instances  []ModelInstance `json:"instances"`         // checked against the services when the model is read
+}
+
+// ModelInstance is an instance created from a service
+type ModelInstance struct {
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	Region  string `json:"region"`
+	Zone    string `json:"zone"`
+}
+
+// migrations upgrade a model from the schema version they're keyed by to the next one
+var migrations = map[int]func([]byte) ([]byte, error){
+	1: migrateV1,
+}
+
+// migrateV1 upgrades a schema 1 model, which is a plain architecture file, to schema 2 at the -w and -p it's being run with
+func migrateV1(data []byte) ([]byte, error) {
+	a := new(archV0r1)
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, err
+	}
+	return json.Marshal(NewModel(a))
+}
+
+// NewModel saves an architecture in the current schema, with the current regions, population and keyvals
+func NewModel(a *archV0r1) *ModelV2 {
+	return &ModelV2{ModelSchema, a, archaius.Conf.Regions, archaius.Conf.Population, archaius.Conf.Keyvals, instances(a, archaius.Conf.Regions, archaius.Conf.Population)}
+}
+
+// instances lists the instances asgard creates from the services of an architecture, in the order it creates them
+func instances(a *archV0r1, regions, population int) []ModelInstance {
+	var is []ModelInstance
+	zones := archaius.Conf.ZoneNames
+	if a.Zones != nil && a.Zones.Count > 0 && a.Zones.Count < len(zones) {
+		zones = zones[:a.Zones.Count]
+	}
+	add := func(r, z string, s containerV0r0, i int) {
+		is = append(is, ModelInstance{names.Make(a.Arch, r, z, s.Name, s.Gopackage, i), s.Name, r, z})
+	}
+	for _, s := range a.Services {
+		rs, count := s.Regions*regions, s.Count*population/100
+		if rs == 0 { // dns that isn't in a region or zone
+			add("*", "*", s, 0)
+		}
+		for r := 0; r < rs; r++ {
+			if count == 0 { // cross zone like elb
+				add(archaius.Conf.RegionNames[r], "*", s, 0)
+			}
+			for i := r * count; i < (r+1)*count; i++ {
+				add(archaius.Conf.RegionNames[r], zones[i%len(zones)], s, i)
+			}
+		}
+	}
+	return is
+}
+
+// ParseModel reads a model of any schema version, migrating it up to the current one
+func ParseModel(data []byte) (*ModelV2, error) {
+	var v struct {
+		Schema int `json:"schema"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	schema := v.Schema
+	if schema == 0 { // architecture files don't have a schema
+		schema = 1
+	}
+	if schema > ModelSchema {
+		return nil, fmt.Errorf("model schema %v is newer than %v, the latest this spigo understands", schema, ModelSchema)
+	}
+	for ; schema < ModelSchema; schema++ {
+		var err error
+		if data, err = migrations[schema](data); err != nil {
+			return nil, fmt.Errorf("migrating model schema %v: %v", schema, err)
+		}
+	}
+	m := new(ModelV2)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Arch == nil {
+		return nil, fmt.Errorf("model has no architecture")
+	}
+	return m, nil
+}
+
+// model loaded by LoadModel, returned by ReadArch in place of the architecture file
+var model *ModelV2
+
+// LoadModel reads a model and sets the architecture name, regions, population and keyvals from it, so the run is created exactly as it was saved
+func LoadModel(fn string) {
+	log.Println("Loading architecture model from " + fn)
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := ParseModel(data)
+	if err != nil {
+		log.Fatal(fn + ": " + err.Error())
+	}
+	if m.Regions < 1 || m.Regions > len(archaius.Conf.RegionNames) || m.Population < 1 {
+		log.Fatal(fmt.Sprintf("Bad model, needs 1 to %v regions and a population of at least 1", len(archaius.Conf.RegionNames)))
+	}
+	archaius.Conf.Arch = m.Arch.Arch
+	archaius.Conf.Regions = m.Regions
+	archaius.Conf.Population = m.Population
+	archaius.Conf.Keyvals = m.Keyvals
+	if errs := Validate(m.Arch); len(errs) > 0 {
+		log.Fatal(fn + ":\n" + errs.Error())
+	}
+	validate(m.Arch)
+	want, _ := json.Marshal(instances(m.Arch, m.Regions, m.Population))
+	if got, _ := json.Marshal(m.Instances); m.Instances != nil && string(got) != string(want) {
+		log.Fatal("Bad model, the instances don't match the ones its services, regions and population create")
+	}
+	model = m
+}
+
+// WriteModel saves the architecture with the current settings to json_arch/<arch>_model.json
+func WriteModel(a *archV0r1) {
+	fn := "json_arch/" + a.Arch + "_model.json"
+	log.Println("Saving architecture model to " + fn)
+	j, err := json.MarshalIndent(NewModel(a), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fn, append(j, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,1744 @@
+package architecture
+
+// Protocol Buffers encoding of architectures, using the schema in arch.proto.
+// The wire format is simple enough to write directly, so there's no generated code or extra dependency.
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/chaosmonkey"
+)
+
+// protobuf wire types
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+)
+
+// pbuf accumulates an encoded message
+type pbuf []byte
+
+func (b *pbuf) uvarint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *pbuf) key(field, wire int) {
+	b.uvarint(uint64(field<<3 | wire))
+}
+
+func (b *pbuf) bytes(field int, v []byte) {
+	b.key(field, pbBytes)
+	b.uvarint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+// str skips empty strings, like proto3 does for default values
+func (b *pbuf) str(field int, s string) {
+	if s != "" {
+		b.bytes(field, []byte(s))
+	}
+}
+
+// strs keeps every entry of a repeated string, including empty ones
+func (b *pbuf) strs(field int, ss []string) {
+	for _, s := range ss {
+		b.bytes(field, []byte(s))
+	}
+}
+
+func (b *pbuf) int(field int, v int) {
+	if v != 0 {
+		b.key(field, pbVarint)
+		b.uvarint(uint64(int64(v)))
+	}
+}
+
+func (b *pbuf) boolean(field int, v bool) {
+	if v {
+		b.int(field, 1)
+	}
+}
+
+func (b *pbuf) double(field int, f float64) {
+	if f != 0 {
+		b.key(field, pbFixed64)
+		var d [8]byte
+		binary.LittleEndian.PutUint64(d[:], math.Float64bits(f))
+		*b = append(*b, d[:]...)
+	}
+}
+
+// pbfield is one decoded field, v holds varint and fixed64 values, b holds length delimited ones
+type pbfield struct {
+	num, wire int
+	v         uint64
+	b         []byte
+}
+
+var errPB = errors.New("architecture: bad protobuf encoding")
+
+func (f pbfield) str() string   { return string(f.b) }
+func (f pbfield) int() int      { return int(int64(f.v)) }
+func (f pbfield) boolean() bool { return f.v != 0 }
+func (f pbfield) double() float64 {
+	return math.Float64frombits(f.v)
+}
+
+// pbfields splits a message into its fields
+func pbfields(data []byte) ([]pbfield, error) {
+	var fs []pbfield
+	for len(data) > 0 {
+		k, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errPB
+		}
+		data = data[n:]
+		f := pbfield{num: int(k >> 3), wire: int(k & 7)}
+		switch f.wire {
+		case pbVarint:
+			f.v, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errPB
+			}
+			data = data[n:]
+		case pbFixed64:
+			if len(data) < 8 {
+				return nil, errPB
+			}
+			f.v = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case pbBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return nil, errPB
+			}
+			f.b = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return nil, errPB
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// MarshalPB encodes an architecture as a protobuf Arch message
+func MarshalPB(a *archV0r1) []byte {
+	var b pbuf
+	b.str(1, a.Arch)
+	b.str(2, a.Version)
+	b.str(3, a.Description)
+	b.str(4, a.Args)
+	b.str(5, a.Date)
+	b.str(6, a.Victim)
+	for _, p := range a.Partitions {
+		var pb pbuf
+		for _, g := range p.Groups {
+			var gb pbuf
+			gb.strs(1, g)
+			pb.bytes(1, gb)
+		}
+		pb.str(2, p.Start)
+		pb.str(3, p.Duration)
+		b.bytes(7, pb)
+	}
+	if c := a.Chaos; c != nil {
+		var cb pbuf
+		cb.str(1, c.Interval)
+		cb.double(2, c.Probability)
+		cb.int(3, c.Max)
+		cb.strs(4, c.Services)
+		cb.str(5, c.ColdStart)
+		for _, e := range c.Events {
+			var eb pbuf
+			eb.str(1, e.Action)
+			eb.str(2, e.At)
+			eb.str(3, e.Service)
+			eb.int(4, e.Count)
+			eb.double(5, e.Percent)
+			eb.str(6, e.Latency)
+			eb.str(7, e.Region)
+			eb.str(8, e.Duration)
+			cb.bytes(6, eb)
+		}
+		b.bytes(8, cb)
+	}
+	for _, s := range a.Services {
+		b.bytes(9, marshalService(s))
+	}
+	if z := a.Zones; z != nil {
+		var zb pbuf
+		zb.int(1, z.Count)
+		zb.str(2, z.Latency)
+		for _, o := range z.Outages {
+			var ob pbuf
+			ob.str(1, o.Zone)
+			ob.str(2, o.Region)
+			ob.str(3, o.Start)
+			zb.bytes(3, ob)
+		}
+		b.bytes(10, zb)
+	}
+	if c := a.Correlated; c != nil {
+		var cb pbuf
+		for _, g := range c.Groups {
+			var gb pbuf
+			gb.str(1, g.Name)
+			gb.strs(2, g.Services)
+			gb.double(3, g.Fraction)
+			cb.bytes(1, gb)
+		}
+		for _, e := range c.Events {
+			var eb pbuf
+			eb.str(1, e.Group)
+			eb.str(2, e.Start)
+			eb.str(3, e.Duration)
+			eb.str(4, e.Latency)
+			cb.bytes(2, eb)
+		}
+		b.bytes(11, cb)
+	}
+	if sc := a.Sidecar; sc != nil {
+		b.bytes(12, marshalSidecar(sc))
+	}
+	if d := a.DNS; d != nil {
+		b.bytes(18, marshalDNS(d))
+	}
+	for _, j := range a.Journeys {
+		var jb pbuf
+		jb.str(1, j.Name)
+		jb.str(2, j.Rate)
+		for _, s := range j.Steps {
+			var sb pbuf
+			sb.str(1, s.Service)
+			sb.str(2, s.Request)
+			jb.bytes(3, sb)
+		}
+		b.bytes(13, jb)
+	}
+	var regions []string
+	for r := range a.Ingress {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+	for _, r := range regions {
+		var entry pbuf
+		entry.str(1, r)
+		entry.int(2, a.Ingress[r])
+		b.bytes(14, entry)
+	}
+	for _, d := range a.Deployments {
+		var db pbuf
+		db.str(1, d.Service)
+		db.str(2, d.Version)
+		db.str(3, d.Start)
+		db.int(4, d.Batch)
+		db.str(5, d.Bake)
+		db.str(6, d.Latency)
+		db.double(7, d.Errors)
+		db.str(8, d.Strategy)
+		db.double(9, d.Rollback)
+		db.str(10, d.Watch)
+		b.bytes(15, db)
+	}
+	for _, fl := range a.Flags {
+		var fb pbuf
+		fb.str(1, fl.Name)
+		fb.boolean(2, fl.On)
+		for _, c := range fl.Schedule {
+			var cb pbuf
+			cb.str(1, c.At)
+			cb.boolean(2, c.On)
+			fb.bytes(3, cb)
+		}
+		b.bytes(16, fb)
+	}
+	for _, e := range a.Entrypoints {
+		var eb pbuf
+		eb.str(1, e.Service)
+		eb.str(2, e.Rate)
+		eb.double(3, e.Ephemeral)
+		eb.str(4, e.Setup)
+		if ad := e.Adaptive; ad != nil {
+			var ab pbuf
+			ab.double(1, ad.Increase)
+			ab.double(2, ad.Decrease)
+			ab.str(3, ad.Min)
+			ab.str(4, ad.Window)
+			eb.bytes(5, ab)
+		}
+		b.bytes(17, eb)
+	}
+	for _, kc := range a.Schedule {
+		var kb pbuf
+		kb.str(1, kc.At)
+		kb.str(2, kc.Key)
+		kb.str(3, kc.Value)
+		b.bytes(19, kb)
+	}
+	for _, p := range a.Probes {
+		var pb pbuf
+		pb.str(1, p.Name)
+		pb.str(2, p.Service)
+		pb.str(3, p.Request)
+		pb.str(4, p.Interval)
+		b.bytes(20, pb)
+	}
+	if w := a.WAN; w != nil {
+		var wb pbuf
+		var pairs []string
+		for k := range w.Latency {
+			pairs = append(pairs, k)
+		}
+		sort.Strings(pairs)
+		for _, k := range pairs {
+			var entry pbuf
+			entry.str(1, k)
+			entry.str(2, w.Latency[k])
+			wb.bytes(1, entry)
+		}
+		b.bytes(21, wb)
+	}
+	return b
+}
+
+func marshalService(s containerV0r0) []byte {
+	var b pbuf
+	b.str(1, s.Name)
+	b.str(2, s.Machine)
+	b.str(3, s.Instance)
+	b.str(4, s.Container)
+	b.str(5, s.Process)
+	b.str(6, s.Gopackage)
+	b.int(7, s.Regions)
+	b.int(8, s.Count)
+	b.strs(9, s.Dependencies)
+	b.strs(10, s.Baggage)
+	b.str(11, s.Deadline)
+	var deps []string
+	for d := range s.Edges {
+		deps = append(deps, d)
+	}
+	sort.Strings(deps) // same bytes every time
+	for _, d := range deps {
+		e := s.Edges[d]
+		var eb, entry pbuf
+		eb.str(1, e.When)
+		eb.str(2, e.Latency)
+		eb.str(3, e.Response)
+		eb.str(4, e.Timeout)
+		eb.int(5, e.Weight)
+		eb.int(6, e.Connections)
+		eb.str(7, e.Balance)
+		eb.str(8, e.Window)
+		eb.str(9, e.Fallback)
+		eb.str(10, e.Format)
+		eb.int(11, e.Payload)
+		eb.int(12, e.ResponsePayload)
+		eb.str(13, e.Mirror)
+		eb.double(14, e.MirrorFraction)
+		eb.int(15, e.Fanout)
+		eb.str(16, e.Warmup)
+		eb.int(17, e.WarmupCalls)
+		eb.str(18, e.KeepAlive)
+		eb.str(19, e.Backoff)
+		eb.str(20, e.BackoffBase)
+		eb.str(21, e.BackoffCap)
+		eb.int(22, e.Pages)
+		eb.str(23, e.PageLatency)
+		eb.str(24, e.Flag)
+		eb.int(25, e.Batch)
+		eb.str(26, e.BatchWindow)
+		eb.double(27, e.BatchScale)
+		eb.double(28, e.Speculate)
+		eb.str(29, e.SpeculateAfter)
+		eb.double(30, e.CancelWork)
+		eb.str(31, e.BatchMaxWait)
+		eb.str(32, e.Rehome)
+		eb.double(33, e.Breaker)
+		eb.int(34, e.BreakerVolume)
+		eb.str(35, e.BreakerWindow)
+		eb.str(36, e.BreakerSleep)
+		eb.str(37, e.Group)
+		entry.str(1, d)
+		entry.bytes(2, eb)
+		b.bytes(12, entry)
+	}
+	if as := s.Autoscale; as != nil {
+		var ab pbuf
+		ab.str(1, as.Target)
+		ab.int(2, as.Min)
+		ab.int(3, as.Max)
+		ab.str(4, as.Interval)
+		ab.int(5, as.Up)
+		ab.int(6, as.Down)
+		ab.str(7, as.Queue)
+		ab.int(8, as.Depth)
+		ab.double(9, as.Health)
+		ab.double(10, as.RPS)
+		ab.double(11, as.Concurrency)
+		ab.str(12, as.Cooldown)
+		b.bytes(13, ab)
+	}
+	if gc := s.GC; gc != nil {
+		var gb pbuf
+		gb.str(1, gc.Interval)
+		gb.str(2, gc.Pause)
+		gb.str(3, gc.Distribution)
+		b.bytes(14, gb)
+	}
+	if q := s.Queue; q != nil {
+		var qb pbuf
+		qb.str(1, q.Visibility)
+		qb.int(2, q.Retries)
+		qb.int(3, q.Concurrency)
+		b.bytes(15, qb)
+	}
+	b.str(16, s.Version)
+	b.double(17, s.Errors)
+	b.str(18, s.Think)
+	var keys []string
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry pbuf
+		entry.str(1, k)
+		entry.str(2, s.Tags[k])
+		b.bytes(19, entry)
+	}
+	if r := s.Replication; r != nil {
+		var rb pbuf
+		rb.str(1, r.Mode)
+		rb.int(2, r.Replicas)
+		rb.str(3, r.Lag)
+		rb.int(4, r.W)
+		rb.int(5, r.R)
+		b.bytes(20, rb)
+	}
+	b.str(21, s.Dedup)
+	if sc := s.Sidecar; sc != nil {
+		b.bytes(22, marshalSidecar(sc))
+	}
+	if m := s.Memory; m != nil {
+		var mb pbuf
+		mb.double(1, m.Limit)
+		mb.double(2, m.Request)
+		mb.str(3, m.Model)
+		mb.str(4, m.Restart)
+		mb.str(5, m.Slowdown)
+		b.bytes(23, mb)
+	}
+	if c := s.Coalesce; c != nil {
+		var cb pbuf
+		cb.str(1, c.Key)
+		cb.str(2, c.Window)
+		b.bytes(24, cb)
+	}
+	if c := s.Caching; c != nil {
+		var cb pbuf
+		cb.str(1, c.Pattern)
+		cb.str(2, c.Flush)
+		cb.int(3, c.Capacity)
+		cb.str(4, c.Eviction)
+		cb.str(5, c.Warm)
+		cb.strs(6, c.Flushes)
+		b.bytes(25, cb)
+	}
+	if h := s.Health; h != nil {
+		var hb pbuf
+		hb.double(1, h.Latency)
+		hb.double(2, h.Errors)
+		hb.double(3, h.Inflight)
+		hb.str(4, h.Target)
+		hb.int(5, h.Concurrency)
+		hb.str(6, h.Window)
+		b.bytes(26, hb)
+	}
+	if l := s.Leader; l != nil {
+		var lb pbuf
+		lb.int(1, l.Size)
+		lb.str(2, l.Election)
+		lb.str(3, l.Writes)
+		b.bytes(27, lb)
+	}
+	if st := s.Startup; st != nil {
+		var sb pbuf
+		sb.str(1, st.Latency)
+		sb.str(2, st.Warm)
+		b.bytes(28, sb)
+	}
+	if x := s.External; x != nil {
+		var xb pbuf
+		xb.double(1, x.Rate)
+		xb.int(2, x.Burst)
+		xb.str(3, x.Latency)
+		xb.str(4, x.Distribution)
+		for _, o := range x.Outages {
+			var ob pbuf
+			ob.str(1, o.Start)
+			ob.str(2, o.Duration)
+			xb.bytes(5, ob)
+		}
+		b.bytes(29, xb)
+	}
+	if sg := s.Saga; sg != nil {
+		var gb pbuf
+		for _, st := range sg.Steps {
+			var sb pbuf
+			sb.str(1, st.Service)
+			sb.str(2, st.Request)
+			sb.str(3, st.Compensation)
+			sb.double(4, st.Errors)
+			gb.bytes(1, sb)
+		}
+		gb.str(2, sg.Timeout)
+		gb.int(3, sg.Retries)
+		b.bytes(30, gb)
+	}
+	if d := s.DNS; d != nil {
+		b.bytes(31, marshalDNS(d))
+	}
+	for _, z := range s.Sizes {
+		var zb pbuf
+		zb.str(1, z.Name)
+		zb.double(2, z.Fraction)
+		zb.str(3, z.Latency)
+		zb.int(4, z.Concurrency)
+		zb.double(5, z.Cost)
+		b.bytes(32, zb)
+	}
+	if w := s.WAL; w != nil {
+		var wb pbuf
+		wb.str(1, w.Fsync)
+		wb.str(2, w.Distribution)
+		wb.str(3, w.Group)
+		b.bytes(33, wb)
+	}
+	if lc := s.Lock; lc != nil {
+		var lb pbuf
+		for _, k := range lc.Keys {
+			var kb pbuf
+			kb.str(1, k.Name)
+			kb.str(2, k.Hold)
+			kb.str(3, k.Distribution)
+			kb.double(4, k.Weight)
+			lb.bytes(1, kb)
+		}
+		lb.str(2, lc.Timeout)
+		b.bytes(34, lb)
+	}
+	if k := s.KeyAccess; k != nil {
+		var kb pbuf
+		kb.str(1, k.Distribution)
+		kb.double(2, k.Skew)
+		b.bytes(35, kb)
+	}
+	b.double(36, s.Cost)
+	if sh := s.Shed; sh != nil {
+		var sb pbuf
+		sb.int(1, sh.Concurrency)
+		sb.int(2, sh.Queue)
+		sb.str(3, sh.Discipline)
+		sb.str(4, sh.Target)
+		sb.str(5, sh.Interval)
+		sb.boolean(6, sh.Backpressure)
+		var tenants []string
+		for t := range sh.Weights {
+			tenants = append(tenants, t)
+		}
+		sort.Strings(tenants)
+		for _, t := range tenants {
+			var entry pbuf
+			entry.str(1, t)
+			entry.double(2, sh.Weights[t])
+			sb.bytes(7, entry)
+		}
+		sb.str(8, sh.Tenant)
+		b.bytes(37, sb)
+	}
+	if ad := s.Admission; ad != nil {
+		var ab pbuf
+		ab.str(1, ad.Controller)
+		ab.str(2, ad.Target)
+		ab.str(3, ad.Interval)
+		ab.double(4, ad.Kp)
+		ab.double(5, ad.Ki)
+		ab.double(6, ad.Kd)
+		b.bytes(38, ab)
+	}
+	b.int(39, s.Sessions)
+	b.str(40, s.Apdex)
+	if d := s.Drain; d != nil {
+		var db pbuf
+		db.str(1, d.Delay)
+		db.str(2, d.Timeout)
+		b.bytes(41, db)
+	}
+	if tp := s.TwoPhase; tp != nil {
+		var tb pbuf
+		for _, tx := range tp.Transactions {
+			var xb pbuf
+			xb.str(1, tx.Name)
+			xb.strs(2, tx.Participants)
+			xb.double(3, tx.Aborts)
+			tb.bytes(1, xb)
+		}
+		tb.str(2, tp.Timeout)
+		tb.int(3, tp.Retries)
+		b.bytes(42, tb)
+	}
+	b.double(43, s.SLA)
+	if l := s.Latency; l != nil {
+		var lb pbuf
+		lb.str(1, l.Distribution)
+		lb.str(2, l.Median)
+		lb.double(3, l.Sigma)
+		lb.double(4, l.Alpha)
+		lb.str(5, l.Slow)
+		lb.double(6, l.SlowFraction)
+		lb.str(7, l.Max)
+		b.bytes(44, lb)
+	}
+	if t := s.Topic; t != nil {
+		var tb pbuf
+		tb.int(1, t.Partitions)
+		tb.int(2, t.Depth)
+		tb.str(3, t.Lag)
+		tb.str(4, t.Session)
+		tb.int(5, t.Retries)
+		b.bytes(45, tb)
+	}
+	return b
+}
+
+func marshalDNS(d *archaius.DNSConfig) []byte {
+	var b pbuf
+	b.str(1, d.Latency)
+	b.str(2, d.TTL)
+	return b
+}
+
+func unmarshalDNS(data []byte) (*archaius.DNSConfig, error) {
+	d := new(archaius.DNSConfig)
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			d.Latency = f.str()
+		case 2:
+			d.TTL = f.str()
+		}
+	})
+	return d, err
+}
+
+func marshalSidecar(sc *archaius.SidecarConfig) []byte {
+	var b pbuf
+	b.str(1, sc.Latency)
+	b.str(2, sc.Handshake)
+	b.int(3, sc.Retries)
+	b.int(4, sc.Breaker)
+	b.str(5, sc.Open)
+	b.double(6, sc.Budget)
+	b.str(7, sc.Window)
+	return b
+}
+
+func unmarshalSidecar(data []byte) (*archaius.SidecarConfig, error) {
+	sc := new(archaius.SidecarConfig)
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			sc.Latency = f.str()
+		case 2:
+			sc.Handshake = f.str()
+		case 3:
+			sc.Retries = f.int()
+		case 4:
+			sc.Breaker = f.int()
+		case 5:
+			sc.Open = f.str()
+		case 6:
+			sc.Budget = f.double()
+		case 7:
+			sc.Window = f.str()
+		}
+	})
+	return sc, err
+}
+
+// UnmarshalPB decodes a protobuf Arch message, unknown fields are skipped so newer files can still be read
+func UnmarshalPB(data []byte) (*archV0r1, error) {
+	fs, err := pbfields(data)
+	if err != nil {
+		return nil, err
+	}
+	a := new(archV0r1)
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			a.Arch = f.str()
+		case 2:
+			a.Version = f.str()
+		case 3:
+			a.Description = f.str()
+		case 4:
+			a.Args = f.str()
+		case 5:
+			a.Date = f.str()
+		case 6:
+			a.Victim = f.str()
+		case 7:
+			p, err := unmarshalPartition(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.Partitions = append(a.Partitions, p)
+		case 8:
+			c, err := unmarshalChaos(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.Chaos = c
+		case 9:
+			s, err := unmarshalService(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.Services = append(a.Services, s)
+		case 10:
+			z, err := unmarshalZones(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.Zones = z
+		case 11:
+			c, err := unmarshalCorrelation(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.Correlated = c
+		case 12:
+			sc, err := unmarshalSidecar(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.Sidecar = sc
+		case 13:
+			j, err := unmarshalJourney(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.Journeys = append(a.Journeys, j)
+		case 14:
+			var r string
+			var w int
+			if err := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					r = f.str()
+				case 2:
+					w = f.int()
+				}
+			}); err != nil {
+				return nil, err
+			}
+			if a.Ingress == nil {
+				a.Ingress = make(map[string]int)
+			}
+			a.Ingress[r] = w
+		case 15:
+			var d archaius.Deployment
+			if err := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					d.Service = f.str()
+				case 2:
+					d.Version = f.str()
+				case 3:
+					d.Start = f.str()
+				case 4:
+					d.Batch = f.int()
+				case 5:
+					d.Bake = f.str()
+				case 6:
+					d.Latency = f.str()
+				case 7:
+					d.Errors = f.double()
+				case 8:
+					d.Strategy = f.str()
+				case 9:
+					d.Rollback = f.double()
+				case 10:
+					d.Watch = f.str()
+				}
+			}); err != nil {
+				return nil, err
+			}
+			a.Deployments = append(a.Deployments, d)
+		case 16:
+			fl, err := unmarshalFlag(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.Flags = append(a.Flags, fl)
+		case 17:
+			var e archaius.Entrypoint
+			if err := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					e.Service = f.str()
+				case 2:
+					e.Rate = f.str()
+				case 3:
+					e.Ephemeral = f.double()
+				case 4:
+					e.Setup = f.str()
+				case 5:
+					e.Adaptive = new(archaius.AIMD)
+					unmarshalFields(f.b, func(f pbfield) {
+						switch f.num {
+						case 1:
+							e.Adaptive.Increase = f.double()
+						case 2:
+							e.Adaptive.Decrease = f.double()
+						case 3:
+							e.Adaptive.Min = f.str()
+						case 4:
+							e.Adaptive.Window = f.str()
+						}
+					})
+				}
+			}); err != nil {
+				return nil, err
+			}
+			a.Entrypoints = append(a.Entrypoints, e)
+		case 18:
+			d, err := unmarshalDNS(f.b)
+			if err != nil {
+				return nil, err
+			}
+			a.DNS = d
+		case 19:
+			var kc archaius.KeyChange
+			if err := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					kc.At = f.str()
+				case 2:
+					kc.Key = f.str()
+				case 3:
+					kc.Value = f.str()
+				}
+			}); err != nil {
+				return nil, err
+			}
+			a.Schedule = append(a.Schedule, kc)
+		case 20:
+			var p archaius.Probe
+			if err := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					p.Name = f.str()
+				case 2:
+					p.Service = f.str()
+				case 3:
+					p.Request = f.str()
+				case 4:
+					p.Interval = f.str()
+				}
+			}); err != nil {
+				return nil, err
+			}
+			a.Probes = append(a.Probes, p)
+		case 21:
+			w := new(archaius.WAN)
+			var entryErr error
+			if err := unmarshalFields(f.b, func(f pbfield) {
+				if f.num != 1 {
+					return
+				}
+				var k, v string
+				if err := unmarshalFields(f.b, func(f pbfield) {
+					switch f.num {
+					case 1:
+						k = f.str()
+					case 2:
+						v = f.str()
+					}
+				}); err != nil {
+					entryErr = err
+					return
+				}
+				if w.Latency == nil {
+					w.Latency = make(map[string]string)
+				}
+				w.Latency[k] = v
+			}); err != nil {
+				return nil, err
+			}
+			if entryErr != nil {
+				return nil, entryErr
+			}
+			a.WAN = w
+		}
+	}
+	return a, nil
+}
+
+func unmarshalExternal(data []byte) (*archaius.ExternalConfig, error) {
+	x := new(archaius.ExternalConfig)
+	var outageErr error
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			x.Rate = f.double()
+		case 2:
+			x.Burst = f.int()
+		case 3:
+			x.Latency = f.str()
+		case 4:
+			x.Distribution = f.str()
+		case 5:
+			var o archaius.ExternalOutage
+			if e := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					o.Start = f.str()
+				case 2:
+					o.Duration = f.str()
+				}
+			}); e != nil {
+				outageErr = e
+			}
+			x.Outages = append(x.Outages, o)
+		}
+	})
+	if err == nil {
+		err = outageErr
+	}
+	return x, err
+}
+
+func unmarshalLock(data []byte) (*archaius.LockConfig, error) {
+	lc := new(archaius.LockConfig)
+	var keyErr error
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			var k archaius.LockKey
+			if e := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					k.Name = f.str()
+				case 2:
+					k.Hold = f.str()
+				case 3:
+					k.Distribution = f.str()
+				case 4:
+					k.Weight = f.double()
+				}
+			}); e != nil {
+				keyErr = e
+			}
+			lc.Keys = append(lc.Keys, k)
+		case 2:
+			lc.Timeout = f.str()
+		}
+	})
+	if err == nil {
+		err = keyErr
+	}
+	return lc, err
+}
+
+func unmarshalSaga(data []byte) (*archaius.SagaConfig, error) {
+	sg := new(archaius.SagaConfig)
+	var stepErr error
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			var st archaius.SagaStep
+			if e := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					st.Service = f.str()
+				case 2:
+					st.Request = f.str()
+				case 3:
+					st.Compensation = f.str()
+				case 4:
+					st.Errors = f.double()
+				}
+			}); e != nil {
+				stepErr = e
+			}
+			sg.Steps = append(sg.Steps, st)
+		case 2:
+			sg.Timeout = f.str()
+		case 3:
+			sg.Retries = f.int()
+		}
+	})
+	if err == nil {
+		err = stepErr
+	}
+	return sg, err
+}
+
+func unmarshalTwoPhase(data []byte) (*archaius.TwoPhaseConfig, error) {
+	tp := new(archaius.TwoPhaseConfig)
+	var txErr error
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			var tx archaius.TwoPhaseTransaction
+			if e := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					tx.Name = f.str()
+				case 2:
+					tx.Participants = append(tx.Participants, f.str())
+				case 3:
+					tx.Aborts = f.double()
+				}
+			}); e != nil {
+				txErr = e
+			}
+			tp.Transactions = append(tp.Transactions, tx)
+		case 2:
+			tp.Timeout = f.str()
+		case 3:
+			tp.Retries = f.int()
+		}
+	})
+	if err == nil {
+		err = txErr
+	}
+	return tp, err
+}
+
+func unmarshalFlag(data []byte) (archaius.Flag, error) {
+	var fl archaius.Flag
+	var changeErr error
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			fl.Name = f.str()
+		case 2:
+			fl.On = f.boolean()
+		case 3:
+			var c archaius.FlagChange
+			if e := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					c.At = f.str()
+				case 2:
+					c.On = f.boolean()
+				}
+			}); e != nil {
+				changeErr = e
+			}
+			fl.Schedule = append(fl.Schedule, c)
+		}
+	})
+	if err == nil {
+		err = changeErr
+	}
+	return fl, err
+}
+
+func unmarshalJourney(data []byte) (archaius.Journey, error) {
+	var j archaius.Journey
+	var stepErr error
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			j.Name = f.str()
+		case 2:
+			j.Rate = f.str()
+		case 3:
+			var s archaius.JourneyStep
+			if e := unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Service = f.str()
+				case 2:
+					s.Request = f.str()
+				}
+			}); e != nil {
+				stepErr = e
+			}
+			j.Steps = append(j.Steps, s)
+		}
+	})
+	if err == nil {
+		err = stepErr
+	}
+	return j, err
+}
+
+func unmarshalCorrelation(data []byte) (*archaius.Correlation, error) {
+	fs, err := pbfields(data)
+	if err != nil {
+		return nil, err
+	}
+	c := new(archaius.Correlation)
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			var g archaius.CorrelationGroup
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					g.Name = f.str()
+				case 2:
+					g.Services = append(g.Services, f.str())
+				case 3:
+					g.Fraction = f.double()
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			c.Groups = append(c.Groups, g)
+		case 2:
+			var e archaius.CorrelatedEvent
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					e.Group = f.str()
+				case 2:
+					e.Start = f.str()
+				case 3:
+					e.Duration = f.str()
+				case 4:
+					e.Latency = f.str()
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			c.Events = append(c.Events, e)
+		}
+	}
+	return c, nil
+}
+
+func unmarshalZones(data []byte) (*archaius.Zones, error) {
+	fs, err := pbfields(data)
+	if err != nil {
+		return nil, err
+	}
+	z := new(archaius.Zones)
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			z.Count = f.int()
+		case 2:
+			z.Latency = f.str()
+		case 3:
+			var o archaius.ZoneOutage
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					o.Zone = f.str()
+				case 2:
+					o.Region = f.str()
+				case 3:
+					o.Start = f.str()
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			z.Outages = append(z.Outages, o)
+		}
+	}
+	return z, nil
+}
+
+func unmarshalPartition(data []byte) (archaius.Partition, error) {
+	var p archaius.Partition
+	fs, err := pbfields(data)
+	if err != nil {
+		return p, err
+	}
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			gs, err := pbfields(f.b)
+			if err != nil {
+				return p, err
+			}
+			var g []string
+			for _, r := range gs {
+				if r.num == 1 {
+					g = append(g, r.str())
+				}
+			}
+			p.Groups = append(p.Groups, g)
+		case 2:
+			p.Start = f.str()
+		case 3:
+			p.Duration = f.str()
+		}
+	}
+	return p, nil
+}
+
+func unmarshalChaos(data []byte) (*chaosmonkey.Config, error) {
+	fs, err := pbfields(data)
+	if err != nil {
+		return nil, err
+	}
+	c := new(chaosmonkey.Config)
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			c.Interval = f.str()
+		case 2:
+			c.Probability = f.double()
+		case 3:
+			c.Max = f.int()
+		case 4:
+			c.Services = append(c.Services, f.str())
+		case 5:
+			c.ColdStart = f.str()
+		case 6:
+			var e chaosmonkey.Event
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					e.Action = f.str()
+				case 2:
+					e.At = f.str()
+				case 3:
+					e.Service = f.str()
+				case 4:
+					e.Count = f.int()
+				case 5:
+					e.Percent = f.double()
+				case 6:
+					e.Latency = f.str()
+				case 7:
+					e.Region = f.str()
+				case 8:
+					e.Duration = f.str()
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			c.Events = append(c.Events, e)
+		}
+	}
+	return c, nil
+}
+
+func unmarshalService(data []byte) (containerV0r0, error) {
+	var s containerV0r0
+	fs, err := pbfields(data)
+	if err != nil {
+		return s, err
+	}
+	s.Dependencies = []string{} // json always has a dependencies list
+	for _, f := range fs {
+		switch f.num {
+		case 1:
+			s.Name = f.str()
+		case 2:
+			s.Machine = f.str()
+		case 3:
+			s.Instance = f.str()
+		case 4:
+			s.Container = f.str()
+		case 5:
+			s.Process = f.str()
+		case 6:
+			s.Gopackage = f.str()
+		case 7:
+			s.Regions = f.int()
+		case 8:
+			s.Count = f.int()
+		case 9:
+			s.Dependencies = append(s.Dependencies, f.str())
+		case 10:
+			s.Baggage = append(s.Baggage, f.str())
+		case 11:
+			s.Deadline = f.str()
+		case 12:
+			d, e, err := unmarshalEdge(f.b)
+			if err != nil {
+				return s, err
+			}
+			if s.Edges == nil {
+				s.Edges = make(map[string]archaius.EdgeConfig)
+			}
+			s.Edges[d] = e
+		case 13:
+			s.Autoscale = new(archaius.AutoscaleConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Autoscale.Target = f.str()
+				case 2:
+					s.Autoscale.Min = f.int()
+				case 3:
+					s.Autoscale.Max = f.int()
+				case 4:
+					s.Autoscale.Interval = f.str()
+				case 5:
+					s.Autoscale.Up = f.int()
+				case 6:
+					s.Autoscale.Down = f.int()
+				case 7:
+					s.Autoscale.Queue = f.str()
+				case 8:
+					s.Autoscale.Depth = f.int()
+				case 9:
+					s.Autoscale.Health = f.double()
+				case 10:
+					s.Autoscale.RPS = f.double()
+				case 11:
+					s.Autoscale.Concurrency = f.double()
+				case 12:
+					s.Autoscale.Cooldown = f.str()
+				}
+			})
+		case 14:
+			s.GC = new(archaius.GCConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.GC.Interval = f.str()
+				case 2:
+					s.GC.Pause = f.str()
+				case 3:
+					s.GC.Distribution = f.str()
+				}
+			})
+		case 15:
+			s.Queue = new(archaius.QueueConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Queue.Visibility = f.str()
+				case 2:
+					s.Queue.Retries = f.int()
+				case 3:
+					s.Queue.Concurrency = f.int()
+				}
+			})
+		case 16:
+			s.Version = f.str()
+		case 17:
+			s.Errors = f.double()
+		case 18:
+			s.Think = f.str()
+		case 19:
+			var k, v string
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					k = f.str()
+				case 2:
+					v = f.str()
+				}
+			})
+			if s.Tags == nil {
+				s.Tags = make(map[string]string)
+			}
+			s.Tags[k] = v
+		case 20:
+			s.Replication = new(archaius.ReplicationConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Replication.Mode = f.str()
+				case 2:
+					s.Replication.Replicas = f.int()
+				case 3:
+					s.Replication.Lag = f.str()
+				case 4:
+					s.Replication.W = f.int()
+				case 5:
+					s.Replication.R = f.int()
+				}
+			})
+		case 21:
+			s.Dedup = f.str()
+		case 22:
+			s.Sidecar, err = unmarshalSidecar(f.b)
+		case 23:
+			s.Memory = new(archaius.MemoryConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Memory.Limit = f.double()
+				case 2:
+					s.Memory.Request = f.double()
+				case 3:
+					s.Memory.Model = f.str()
+				case 4:
+					s.Memory.Restart = f.str()
+				case 5:
+					s.Memory.Slowdown = f.str()
+				}
+			})
+		case 24:
+			s.Coalesce = new(archaius.CoalesceConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Coalesce.Key = f.str()
+				case 2:
+					s.Coalesce.Window = f.str()
+				}
+			})
+		case 25:
+			s.Caching = new(archaius.CachingConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Caching.Pattern = f.str()
+				case 2:
+					s.Caching.Flush = f.str()
+				case 3:
+					s.Caching.Capacity = f.int()
+				case 4:
+					s.Caching.Eviction = f.str()
+				case 5:
+					s.Caching.Warm = f.str()
+				case 6:
+					s.Caching.Flushes = append(s.Caching.Flushes, f.str())
+				}
+			})
+		case 26:
+			s.Health = new(archaius.HealthConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Health.Latency = f.double()
+				case 2:
+					s.Health.Errors = f.double()
+				case 3:
+					s.Health.Inflight = f.double()
+				case 4:
+					s.Health.Target = f.str()
+				case 5:
+					s.Health.Concurrency = f.int()
+				case 6:
+					s.Health.Window = f.str()
+				}
+			})
+		case 27:
+			s.Leader = new(archaius.LeaderConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Leader.Size = f.int()
+				case 2:
+					s.Leader.Election = f.str()
+				case 3:
+					s.Leader.Writes = f.str()
+				}
+			})
+		case 28:
+			s.Startup = new(archaius.StartupConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Startup.Latency = f.str()
+				case 2:
+					s.Startup.Warm = f.str()
+				}
+			})
+		case 29:
+			s.External, err = unmarshalExternal(f.b)
+		case 30:
+			s.Saga, err = unmarshalSaga(f.b)
+		case 31:
+			s.DNS, err = unmarshalDNS(f.b)
+		case 32:
+			var z archaius.InstanceSize
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					z.Name = f.str()
+				case 2:
+					z.Fraction = f.double()
+				case 3:
+					z.Latency = f.str()
+				case 4:
+					z.Concurrency = f.int()
+				case 5:
+					z.Cost = f.double()
+				}
+			})
+			s.Sizes = append(s.Sizes, z)
+		case 33:
+			s.WAL = new(archaius.WALConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.WAL.Fsync = f.str()
+				case 2:
+					s.WAL.Distribution = f.str()
+				case 3:
+					s.WAL.Group = f.str()
+				}
+			})
+		case 34:
+			s.Lock, err = unmarshalLock(f.b)
+		case 35:
+			s.KeyAccess = new(archaius.KeyAccessConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.KeyAccess.Distribution = f.str()
+				case 2:
+					s.KeyAccess.Skew = f.double()
+				}
+			})
+		case 36:
+			s.Cost = f.double()
+		case 37:
+			s.Shed = new(archaius.ShedConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Shed.Concurrency = f.int()
+				case 2:
+					s.Shed.Queue = f.int()
+				case 3:
+					s.Shed.Discipline = f.str()
+				case 4:
+					s.Shed.Target = f.str()
+				case 5:
+					s.Shed.Interval = f.str()
+				case 6:
+					s.Shed.Backpressure = f.boolean()
+				case 7:
+					var t string
+					var w float64
+					unmarshalFields(f.b, func(f pbfield) {
+						switch f.num {
+						case 1:
+							t = f.str()
+						case 2:
+							w = f.double()
+						}
+					})
+					if s.Shed.Weights == nil {
+						s.Shed.Weights = make(map[string]float64)
+					}
+					s.Shed.Weights[t] = w
+				case 8:
+					s.Shed.Tenant = f.str()
+				}
+			})
+		case 38:
+			s.Admission = new(archaius.AdmissionConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Admission.Controller = f.str()
+				case 2:
+					s.Admission.Target = f.str()
+				case 3:
+					s.Admission.Interval = f.str()
+				case 4:
+					s.Admission.Kp = f.double()
+				case 5:
+					s.Admission.Ki = f.double()
+				case 6:
+					s.Admission.Kd = f.double()
+				}
+			})
+		case 39:
+			s.Sessions = f.int()
+		case 40:
+			s.Apdex = f.str()
+		case 41:
+			s.Drain = new(archaius.DrainConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Drain.Delay = f.str()
+				case 2:
+					s.Drain.Timeout = f.str()
+				}
+			})
+		case 42:
+			s.TwoPhase, err = unmarshalTwoPhase(f.b)
+		case 43:
+			s.SLA = f.double()
+		case 44:
+			s.Latency = new(archaius.LatencyConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Latency.Distribution = f.str()
+				case 2:
+					s.Latency.Median = f.str()
+				case 3:
+					s.Latency.Sigma = f.double()
+				case 4:
+					s.Latency.Alpha = f.double()
+				case 5:
+					s.Latency.Slow = f.str()
+				case 6:
+					s.Latency.SlowFraction = f.double()
+				case 7:
+					s.Latency.Max = f.str()
+				}
+			})
+		case 45:
+			s.Topic = new(archaius.TopicConfig)
+			err = unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					s.Topic.Partitions = f.int()
+				case 2:
+					s.Topic.Depth = f.int()
+				case 3:
+					s.Topic.Lag = f.str()
+				case 4:
+					s.Topic.Session = f.str()
+				case 5:
+					s.Topic.Retries = f.int()
+				}
+			})
+		}
+		if err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// unmarshalEdge decodes an entry of the edges map
+func unmarshalEdge(data []byte) (string, archaius.EdgeConfig, error) {
+	var d string
+	var e archaius.EdgeConfig
+	err := unmarshalFields(data, func(f pbfield) {
+		switch f.num {
+		case 1:
+			d = f.str()
+		case 2:
+			unmarshalFields(f.b, func(f pbfield) {
+				switch f.num {
+				case 1:
+					e.When = f.str()
+				case 2:
+					e.Latency = f.str()
+				case 3:
+					e.Response = f.str()
+				case 4:
+					e.Timeout = f.str()
+				case 5:
+					e.Weight = f.int()
+				case 6:
+					e.Connections = f.int()
+				case 7:
+					e.Balance = f.str()
+				case 8:
+					e.Window = f.str()
+				case 9:
+					e.Fallback = f.str()
+				case 10:
+					e.Format = f.str()
+				case 11:
+					e.Payload = f.int()
+				case 12:
+					e.ResponsePayload = f.int()
+				case 13:
+					e.Mirror = f.str()
+				case 14:
+					e.MirrorFraction = f.double()
+				case 15:
+					e.Fanout = f.int()
+				case 16:
+					e.Warmup = f.str()
+				case 17:
+					e.WarmupCalls = f.int()
+				case 18:
+					e.KeepAlive = f.str()
+				case 19:
+					e.Backoff = f.str()
+				case 20:
+					e.BackoffBase = f.str()
+				case 21:
+					e.BackoffCap = f.str()
+				case 22:
+					e.Pages = f.int()
+				case 23:
+					e.PageLatency = f.str()
+				case 24:
+					e.Flag = f.str()
+				case 25:
+					e.Batch = f.int()
+				case 26:
+					e.BatchWindow = f.str()
+				case 27:
+					e.BatchScale = f.double()
+				case 28:
+					e.Speculate = f.double()
+				case 29:
+					e.SpeculateAfter = f.str()
+				case 30:
+					e.CancelWork = f.double()
+				case 31:
+					e.BatchMaxWait = f.str()
+				case 32:
+					e.Rehome = f.str()
+				case 33:
+					e.Breaker = f.double()
+				case 34:
+					e.BreakerVolume = f.int()
+				case 35:
+					e.BreakerWindow = f.str()
+				case 36:
+					e.BreakerSleep = f.str()
+				case 37:
+					e.Group = f.str()
+				}
+			})
+		}
+	})
+	return d, e, err
+}
+
+// unmarshalFields calls set for each field of a message
+func unmarshalFields(data []byte, set func(pbfield)) error {
+	fs, err := pbfields(data)
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		set(f)
+	}
+	return nil
+}
+
+// WritePB saves the architecture to json_arch/<arch>_arch.pb
+func WritePB(a *archV0r1) {
+	fn := "json_arch/" + a.Arch + "_arch.pb"
+	log.Println("Saving architecture to " + fn)
+	if err := ioutil.WriteFile(fn, MarshalPB(a), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,228 @@
+package architecture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// riskFraction of the other services cut off from the entry points, or of the shortest paths between services going through
+// one, that makes a service a risk
+const riskFraction = 0.25
+
+// RiskReport is a static analysis of the resilience of an architecture expanded to instances at the -w and -p of the run
+type RiskReport struct {
+	Arch         string        `json:"arch"`
+	Regions      int           `json:"regions"`
+	Population   int           `json:"population"`
+	SinglePoints []string      `json:"singlepoints"` // services that one instance or zone failing takes out, riskiest first
+	Services     []ServiceRisk `json:"services"`     // riskiest first
+	NoFallback   []EdgeRisk    `json:"nofallback"`   // dependencies that fail their callers when they fail
+}
+
+// ServiceRisk is how exposed the architecture is to a service failing
+type ServiceRisk struct {
+	Service     string   `json:"service"`
+	Package     string   `json:"package"`
+	Instances   int      `json:"instances"` // zero for cross zone services like elbs and dns
+	Zones       int      `json:"zones"`     // fewest in any region it runs in
+	Regions     int      `json:"regions"`
+	Betweenness float64  `json:"betweenness"` // fraction of the shortest dependency paths between other services that go through it
+	Disconnects float64  `json:"disconnects"` // fraction of the other services that can't be reached from an entry point without it
+	Risks       []string `json:"risks,omitempty"`
+}
+
+// EdgeRisk is a dependency without a fallback, Critical if the service it calls is a risk itself
+type EdgeRisk struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Critical bool   `json:"critical,omitempty"`
+}
+
+// risks analyzes the dependency graph of the services and their instances at the regions and population
+func risks(a *archV0r1, regions, population int) RiskReport {
+	r := RiskReport{Arch: a.Arch, Regions: regions, Population: population, SinglePoints: []string{}, NoFallback: []EdgeRisk{}}
+	count := make(map[string]int)
+	zones := make(map[string]map[string]map[string]bool) // zones of each service in each region
+	for _, i := range instances(a, regions, population) {
+		if i.Zone != "*" {
+			count[i.Service]++
+		}
+		if zones[i.Service] == nil {
+			zones[i.Service] = make(map[string]map[string]bool)
+		}
+		if zones[i.Service][i.Region] == nil {
+			zones[i.Service][i.Region] = make(map[string]bool)
+		}
+		zones[i.Service][i.Region][i.Zone] = true
+	}
+	known := make(map[string]bool)
+	for _, s := range a.Services {
+		known[s.Name] = true
+	}
+	deps := make(map[string][]string) // without self dependencies, duplicates or names that aren't services, like eureka
+	calledBy := make(map[string]int)
+	for _, s := range a.Services {
+		seen := make(map[string]bool)
+		for _, d := range s.Dependencies {
+			if known[d] && d != s.Name && !seen[d] {
+				seen[d] = true
+				deps[s.Name] = append(deps[s.Name], d)
+				calledBy[d]++
+			}
+		}
+	}
+	var entries []string // the configured entry points, or services nothing depends on, where requests come in
+	for _, e := range a.Entrypoints {
+		entries = append(entries, e.Service)
+	}
+	for _, s := range a.Services {
+		if calledBy[s.Name] == 0 && a.Entrypoints == nil {
+			entries = append(entries, s.Name)
+		}
+	}
+	between := betweenness(a, deps)
+	n := len(a.Services)
+	reached := reachable(entries, deps, "")
+	for _, s := range a.Services {
+		sr := ServiceRisk{Service: s.Name, Package: s.Gopackage, Instances: count[s.Name], Regions: len(zones[s.Name])}
+		for rn, zs := range zones[s.Name] {
+			if (sr.Zones == 0 || len(zs) < sr.Zones) && rn != "*" && !zs["*"] {
+				sr.Zones = len(zs)
+			}
+		}
+		if n > 2 {
+			sr.Betweenness = between[s.Name] / float64((n-1)*(n-2))
+		}
+		if n > 1 {
+			without := reachable(entries, deps, s.Name)
+			sr.Disconnects = float64(len(reached)-len(without)-1) / float64(n-1)
+			if !reached[s.Name] || sr.Disconnects < 0 {
+				sr.Disconnects = 0
+			}
+		}
+		if sr.Instances == 1 {
+			sr.Risks = append(sr.Risks, "single instance")
+		} else if sr.Instances > 1 && sr.Zones == 1 {
+			sr.Risks = append(sr.Risks, "single zone")
+		}
+		if sr.Disconnects >= riskFraction {
+			sr.Risks = append(sr.Risks, fmt.Sprintf("failure disconnects %.0f%% of the services", 100*sr.Disconnects))
+		}
+		if sr.Betweenness >= riskFraction {
+			sr.Risks = append(sr.Risks, "high betweenness")
+		}
+		r.Services = append(r.Services, sr)
+	}
+	sort.Sort(byRisk(r.Services))
+	risky := make(map[string]bool)
+	for _, sr := range r.Services {
+		risky[sr.Service] = len(sr.Risks) > 0
+		if sr.Instances == 1 || sr.Instances > 1 && sr.Zones == 1 {
+			r.SinglePoints = append(r.SinglePoints, sr.Service)
+		}
+	}
+	for _, s := range a.Services {
+		for _, d := range deps[s.Name] {
+			if s.Edges[d].Fallback == "" {
+				r.NoFallback = append(r.NoFallback, EdgeRisk{s.Name, d, risky[d]})
+			}
+		}
+	}
+	return r
+}
+
+// byRisk sorts services by how many risks they have, then how much they disconnect and how central they are
+type byRisk []ServiceRisk
+
+func (s byRisk) Len() int      { return len(s) }
+func (s byRisk) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byRisk) Less(i, j int) bool {
+	if len(s[i].Risks) != len(s[j].Risks) {
+		return len(s[i].Risks) > len(s[j].Risks)
+	}
+	if s[i].Disconnects != s[j].Disconnects {
+		return s[i].Disconnects > s[j].Disconnects
+	}
+	if s[i].Betweenness != s[j].Betweenness {
+		return s[i].Betweenness > s[j].Betweenness
+	}
+	return s[i].Service < s[j].Service
+}
+
+// reachable are the services that can be reached from the entry points by following dependencies, without going through skip
+func reachable(entries []string, deps map[string][]string, skip string) map[string]bool {
+	seen := make(map[string]bool)
+	var visit func(s string)
+	visit = func(s string) {
+		if s == skip || seen[s] {
+			return
+		}
+		seen[s] = true
+		for _, d := range deps[s] {
+			visit(d)
+		}
+	}
+	for _, e := range entries {
+		visit(e)
+	}
+	return seen
+}
+
+// betweenness of each service, the number of shortest dependency paths between pairs of other services that go through it,
+// shared between the paths when there's more than one, using Brandes' algorithm
+func betweenness(a *archV0r1, deps map[string][]string) map[string]float64 {
+	b := make(map[string]float64)
+	for _, src := range a.Services {
+		s := src.Name
+		var stack []string
+		preds := make(map[string][]string)
+		paths := map[string]float64{s: 1}
+		dist := map[string]int{s: 0}
+		queue := []string{s}
+		for len(queue) > 0 {
+			v := queue[0]
+			queue = queue[1:]
+			stack = append(stack, v)
+			for _, w := range deps[v] {
+				if _, ok := dist[w]; !ok {
+					dist[w] = dist[v] + 1
+					queue = append(queue, w)
+				}
+				if dist[w] == dist[v]+1 {
+					paths[w] += paths[v]
+					preds[w] = append(preds[w], v)
+				}
+			}
+		}
+		delta := make(map[string]float64)
+		for i := len(stack) - 1; i >= 0; i-- {
+			w := stack[i]
+			for _, v := range preds[w] {
+				delta[v] += paths[v] / paths[w] * (1 + delta[w])
+			}
+			if w != s {
+				b[w] += delta[w]
+			}
+		}
+	}
+	return b
+}
+
+// WriteRiskReport saves the risk analysis of the architecture at the current regions and population to json/<arch>_risk.json
+func WriteRiskReport(a *archV0r1) {
+	r := risks(a, archaius.Conf.Regions, archaius.Conf.Population)
+	fn := "json/" + a.Arch + "_risk.json"
+	log.Printf("Writing risk report with %v single points of failure and %v dependencies without a fallback to %v\n", len(r.SinglePoints), len(r.NoFallback), fn)
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fn, append(j, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
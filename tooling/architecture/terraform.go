@@ -0,0 +1,171 @@
+package architecture
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/adrianco/spigo/actors/packagenames" // name definitions
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+var badTerraformChars = regexp.MustCompile("[^a-zA-Z0-9_-]+")
+
+// tfName is a terraform identifier for a service, or a service in a region
+func tfName(parts ...string) string {
+	n := badTerraformChars.ReplaceAllString(strings.Join(parts, "_"), "_")
+	if n == "" || (n[0] >= '0' && n[0] <= '9') {
+		n = "_" + n
+	}
+	return n
+}
+
+// tfZone is the availability zone a spigo zone stands in for, zoneA in us-east-1 is us-east-1a
+func tfZone(region, zone string) string {
+	if len(zone) == 5 && strings.HasPrefix(zone, "zone") {
+		return region + strings.ToLower(zone[4:])
+	}
+	return zone
+}
+
+// tfPlacement is where the instances of a service are, the count and zones in each region it's in
+type tfPlacement struct {
+	regions []string
+	count   map[string]int
+	zones   map[string][]string
+}
+
+// terraform writes a skeleton of Terraform resources for the architecture, a provider for each region, an autoscaling group and
+// launch template for each service in each region it runs in, sized to the instances it starts with and its autoscale limits,
+// a load balancer for each elb, a queue for each workqueue and a dns record for each denominator pointing at the elbs it calls.
+// Stores and caches are autoscaling groups too, tagged with their package. Placeholders are variables, so it won't apply as is
+func terraform(w io.Writer, a *archV0r1, regions, population int) {
+	placed := make(map[string]*tfPlacement)
+	for _, i := range instances(a, regions, population) {
+		p := placed[i.Service]
+		if p == nil {
+			p = &tfPlacement{count: make(map[string]int), zones: make(map[string][]string)}
+			placed[i.Service] = p
+		}
+		if _, ok := p.count[i.Region]; !ok {
+			p.regions = append(p.regions, i.Region)
+		}
+		p.count[i.Region]++
+		z := tfZone(i.Region, i.Zone)
+		seen := false
+		for _, have := range p.zones[i.Region] {
+			seen = seen || have == z
+		}
+		if !seen && i.Zone != "*" {
+			p.zones[i.Region] = append(p.zones[i.Region], z)
+		}
+	}
+	packages := make(map[string]string)
+	for _, s := range a.Services {
+		packages[s.Name] = s.Gopackage
+	}
+	fmt.Fprintf(w, "# Terraform skeleton for the %v architecture, written by spigo -terraform\n", a.Arch)
+	if a.Description != "" {
+		fmt.Fprintf(w, "# %v\n", a.Description)
+	}
+	fmt.Fprintf(w, "\nvariable \"ami\" {}\n\nvariable \"instance_type\" {\n  default = \"m5.large\"\n}\n\nvariable \"subnets\" {\n  type = map(list(string))\n}\n\nvariable \"zone_id\" {}\n")
+	for _, r := range archaius.Conf.RegionNames[:regions] {
+		fmt.Fprintf(w, "\nprovider \"aws\" {\n  alias  = %q\n  region = %q\n}\n", r, r)
+	}
+	for _, s := range a.Services {
+		p := placed[s.Name]
+		if p == nil {
+			continue // scaled down to nothing
+		}
+		tags := map[string]string{"spigo:arch": a.Arch, "spigo:package": s.Gopackage, "spigo:service": s.Name}
+		if s.Version != "" {
+			tags["spigo:version"] = s.Version
+		}
+		for k, v := range s.Tags {
+			tags[k] = v
+		}
+		var keys []string
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		width := 0
+		for _, k := range keys {
+			if len(fmt.Sprintf("%q", k)) > width {
+				width = len(fmt.Sprintf("%q", k))
+			}
+		}
+		fmt.Fprintf(w, "\n# %v is a %v", s.Name, s.Gopackage)
+		if len(s.Dependencies) > 0 {
+			fmt.Fprintf(w, " that calls %v", strings.Join(s.Dependencies, ", "))
+		}
+		fmt.Fprintf(w, "\n")
+		switch s.Gopackage {
+		case packagenames.DenominatorPkg:
+			var records []string
+			for _, d := range s.Dependencies {
+				if packages[d] != packagenames.ElbPkg || placed[d] == nil {
+					continue
+				}
+				for _, r := range placed[d].regions {
+					records = append(records, fmt.Sprintf("aws_lb.%v.dns_name", tfName(d, r)))
+				}
+			}
+			fmt.Fprintf(w, "resource \"aws_route53_record\" %q {\n  zone_id = var.zone_id\n  name    = %q\n  type    = \"CNAME\"\n  ttl     = 60\n  records = [%v]\n}\n",
+				tfName(s.Name), s.Name, strings.Join(records, ", "))
+			continue
+		}
+		for _, r := range p.regions {
+			n := tfName(s.Name, r)
+			switch s.Gopackage {
+			case packagenames.ElbPkg:
+				fmt.Fprintf(w, "resource \"aws_lb\" %q {\n  provider           = aws.%v\n  name               = %q\n  load_balancer_type = \"application\"\n  subnets            = var.subnets[%q]\n",
+					n, r, s.Name, r)
+			case packagenames.WorkqueuePkg:
+				fmt.Fprintf(w, "resource \"aws_sqs_queue\" %q {\n  provider = aws.%v\n  name     = %q\n", n, r, s.Name)
+			default:
+				min, max, count := p.count[r], p.count[r], p.count[r]
+				if as := s.Autoscale; as != nil {
+					max = 4 * count
+					if as.Min > 0 {
+						min = as.Min
+					}
+					if as.Max > 0 {
+						max = as.Max
+					}
+				}
+				fmt.Fprintf(w, "resource \"aws_launch_template\" %q {\n  provider      = aws.%v\n  name_prefix   = \"%v-\"\n  image_id      = var.ami\n  instance_type = var.instance_type\n}\n\n",
+					n, r, s.Name)
+				fmt.Fprintf(w, "resource \"aws_autoscaling_group\" %q {\n  provider           = aws.%v\n  name               = %q\n  min_size           = %v\n  max_size           = %v\n  desired_capacity   = %v\n",
+					n, r, s.Name, min, max, count)
+				fmt.Fprintf(w, "  availability_zones = [\"%v\"]\n\n  launch_template {\n    id      = aws_launch_template.%v.id\n    version = \"$Latest\"\n  }\n", strings.Join(p.zones[r], "\", \""), n)
+				for _, k := range keys {
+					fmt.Fprintf(w, "\n  tag {\n    key                 = %q\n    value               = %q\n    propagate_at_launch = true\n  }\n", k, tags[k])
+				}
+				fmt.Fprintf(w, "}\n")
+				continue
+			}
+			fmt.Fprintf(w, "\n  tags = {\n")
+			for _, k := range keys {
+				fmt.Fprintf(w, "    %-*q = %q\n", width, k, tags[k])
+			}
+			fmt.Fprintf(w, "  }\n}\n")
+		}
+	}
+}
+
+// WriteTerraform saves a skeleton of Terraform resources for the architecture at the current regions and population to json/<arch>.tf
+func WriteTerraform(a *archV0r1) {
+	fn := "json/" + a.Arch + ".tf"
+	log.Println("Writing Terraform skeleton to " + fn)
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	terraform(f, a, archaius.Conf.Regions, archaius.Conf.Population)
+}
@@ -6,22 +6,37 @@ import (
 	"github.com/adrianco/spigo/actors/denominator"    // DNS service
 	"github.com/adrianco/spigo/actors/elb"            // elastic load balancer
 	"github.com/adrianco/spigo/actors/eureka"         // service and attribute registry
+	"github.com/adrianco/spigo/actors/external"       // third party API
+	"github.com/adrianco/spigo/actors/featureflag"    // feature flag service
 	"github.com/adrianco/spigo/actors/karyon"         // business logic microservice
+	"github.com/adrianco/spigo/actors/lock"           // distributed lock service
 	"github.com/adrianco/spigo/actors/monolith"       // business logic monolith
 	. "github.com/adrianco/spigo/actors/packagenames" // name definitions
 	"github.com/adrianco/spigo/actors/pirate"         // random end user network
 	"github.com/adrianco/spigo/actors/priamCassandra" // Priam managed Cassandra cluster
+	"github.com/adrianco/spigo/actors/saga"           // distributed transaction orchestrator
 	"github.com/adrianco/spigo/actors/staash"         // storage tier as a service http - data access layer
 	"github.com/adrianco/spigo/actors/store"          // generic storage service
+	"github.com/adrianco/spigo/actors/topic"          // Kafka style pub/sub topic
+	"github.com/adrianco/spigo/actors/twophase"       // two phase commit coordinator
+	"github.com/adrianco/spigo/actors/workqueue"      // SQS style work queue
 	"github.com/adrianco/spigo/actors/zuul"           // API proxy microservice router
 	"github.com/adrianco/spigo/tooling/archaius"      // global configuration
+	"github.com/adrianco/spigo/tooling/autoscale"     // response time target tracking
 	"github.com/adrianco/spigo/tooling/chaosmonkey"   // delete nodes at random
+	"github.com/adrianco/spigo/tooling/checkpoint"    // save the instance set while running
+	"github.com/adrianco/spigo/tooling/clock"         // wall or virtual time
 	"github.com/adrianco/spigo/tooling/collect"       // metrics collector
+	"github.com/adrianco/spigo/tooling/flow"          // rolling window of flows when running forever
 	"github.com/adrianco/spigo/tooling/gotocol"
 	"github.com/adrianco/spigo/tooling/graphjson"
 	"github.com/adrianco/spigo/tooling/handlers"
 	"github.com/adrianco/spigo/tooling/names" // manage service name hierarchy
 	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
 	"time"
 )
 
@@ -30,13 +45,45 @@ var (
 	eurekachan map[string]chan gotocol.Message // eureka for each region and zone
 	// noodles channels mapped by microservice name connects netflixoss to everyone
 	noodles map[string]chan gotocol.Message
+	// autoscaled service groups
+	scaled []*scaledGroup
+	// gone remembers nodes that have been terminated by chaosmonkey or autoscale
+	gone map[string]bool
+	// chaos monkey terminations and replacements by service
+	terminated, replaced map[string]int
+	// zones that have had an outage, by region and zone
+	down map[string]bool
+	// instances slowed by correlated events, by correlation group and service
+	slowed map[string]map[string]int
+	// dependencies of each service, to start more instances of it
+	serviceDependencies map[string][]string
 )
 
+// scaledGroup remembers how to create more instances of an autoscaled service
+type scaledGroup struct {
+	*autoscale.Group
+	packagename  string
+	regions      int
+	dependencies []string
+	instances    []string  // names of running instances, newest last
+	next         int       // index for the next instance name
+	last         time.Time // time of the last scaling decision
+	area         float64   // requests in flight integrated up to the last decision
+	ups, downs   int       // count of scaling events
+}
+
 // CreateChannels makes the maps of channels
 func CreateChannels() {
 	listener = make(chan gotocol.Message) // listener for architecture
+	gotocol.Actor(listener, "asgard")
 	noodles = make(map[string]chan gotocol.Message, archaius.Conf.Population)
 	eurekachan = make(map[string]chan gotocol.Message, len(archaius.Conf.ZoneNames)*archaius.Conf.Regions)
+	gone = make(map[string]bool)
+	terminated = make(map[string]int)
+	slowed = make(map[string]map[string]int)
+	replaced = make(map[string]int)
+	down = make(map[string]bool)
+	serviceDependencies = make(map[string][]string)
 }
 
 type mapchan map[string]chan gotocol.Message
@@ -47,6 +94,7 @@ func Create(servicename, packagename string, regions, count int, dependencies ..
 	arch := archaius.Conf.Arch
 	rnames := archaius.Conf.RegionNames
 	znames := archaius.Conf.ZoneNames
+	serviceDependencies[servicename] = dependencies
 	if regions == 0 { // for dns that isn't in a region or zone
 		//log.Printf("Create cross region: " + servicename)
 		name = names.Make(arch, "*", "*", servicename, packagename, 0)
@@ -60,10 +108,23 @@ func Create(servicename, packagename string, regions, count int, dependencies ..
 		} else {
 			//log.Printf("Create service: " + servicename)
 			cass := make(map[string]mapchan) // for token distribution
+			var sg *scaledGroup
+			if r == 0 {
+				if g := autoscale.NewGroup(servicename, regions*count); g != nil {
+					sg = &scaledGroup{Group: g, packagename: packagename, regions: regions, dependencies: dependencies, next: regions * count}
+					scaled = append(scaled, sg)
+					collect.Watch(servicename)
+				}
+			} else if len(scaled) > 0 && scaled[len(scaled)-1].Service == servicename {
+				sg = scaled[len(scaled)-1]
+			}
 			for i := r * count; i < (r+1)*count; i++ {
 				name = names.Make(arch, rnames[r], znames[i%len(archaius.Conf.ZoneNames)], servicename, packagename, i)
 				//log.Println(dependencies)
 				StartNode(name, dependencies...)
+				if sg != nil {
+					sg.instances = append(sg.instances, name)
+				}
 				if packagename == "priamCassandra" {
 					rz := names.RegionZone(name)
 					if cass[rz] == nil {
@@ -85,11 +146,15 @@ func Create(servicename, packagename string, regions, count int, dependencies ..
 
 // Reload the network from a file
 func Reload(arch string) string {
+	return Restore(graphjson.ReadArch(arch).Graph)
+}
+
+// Restore starts the nodes and makes the connections in a list of graph elements, returns the root denominator
+func Restore(graph []graphjson.ElementV0r4) string {
 	root := ""
-	g := graphjson.ReadArch(arch)
 	archaius.Conf.Population = 0 // just to make sure
 	// count how many nodes there are
-	for _, element := range g.Graph {
+	for _, element := range graph {
 		if element.Node != "" {
 			archaius.Conf.Population++
 		}
@@ -99,7 +164,7 @@ func Reload(arch string) string {
 	// eureka and edda aren't recorded in the json file to simplify the graph
 	// Start all the services
 	cass := make(map[string]chan gotocol.Message) // for token distribution
-	for _, element := range g.Graph {
+	for _, element := range graph {
 		if element.Node != "" {
 			name := element.Node
 			StartNode(name, "")
@@ -115,7 +180,7 @@ func Reload(arch string) string {
 		priamCassandra.Distribute(cass) // returns a string if it needs logging
 	}
 	// Make all the connections
-	for _, element := range g.Graph {
+	for _, element := range graph {
 		if element.Edge != "" && element.Source != "" && element.Target != "" {
 			Connect(element.Source, element.Target)
 		}
@@ -130,7 +195,7 @@ func Reload(arch string) string {
 // Connect tells a source node how to connect to a target node directly by name, only used when Eureka can't be used
 func Connect(source, target string) {
 	if noodles[source] != nil && noodles[target] != nil {
-		gotocol.Send(noodles[source], gotocol.Message{gotocol.NameDrop, noodles[target], time.Now(), handlers.DebugContext(gotocol.NilContext), target})
+		gotocol.Send(noodles[source], gotocol.Message{gotocol.NameDrop, noodles[target], clock.Now(), handlers.DebugContext(gotocol.NilContext), target})
 		//log.Println("Link " + source + " > " + target)
 	} else {
 		log.Fatal("Asgard can't link " + source + " > " + target)
@@ -150,10 +215,14 @@ func SendToName(name string, msg gotocol.Message) {
 func StartNode(name string, dependencies ...string) {
 	if names.Package(name) == EurekaPkg {
 		eurekachan[name] = make(chan gotocol.Message, archaius.Conf.Population/len(archaius.Conf.ZoneNames)) // buffer sized to a zone
+		gotocol.Actor(eurekachan[name], name)
 		go eureka.Start(eurekachan[name], name)
 		return
 	}
 	noodles[name] = make(chan gotocol.Message)
+	gotocol.Actor(noodles[name], name)
+	archaius.Started(name)
+	archaius.PickSize(name, names.Service(name))
 	// start the service and tell it it's name
 	switch names.Package(name) {
 	case PiratePkg:
@@ -180,10 +249,24 @@ func StartNode(name string, dependencies ...string) {
 		fallthrough // fake disk volume using store
 	case StorePkg:
 		go store.Start(noodles[name])
+	case WorkqueuePkg:
+		go workqueue.Start(noodles[name])
+	case FeatureflagPkg:
+		go featureflag.Start(noodles[name])
+	case ExternalPkg:
+		go external.Start(noodles[name])
+	case SagaPkg:
+		go saga.Start(noodles[name])
+	case LockPkg:
+		go lock.Start(noodles[name])
+	case TwoPhasePkg:
+		go twophase.Start(noodles[name])
+	case TopicPkg:
+		go topic.Start(noodles[name])
 	default:
 		log.Fatal("asgard: unknown package: " + names.Package(name))
 	}
-	noodles[name] <- gotocol.Message{gotocol.Hello, listener, time.Now(), handlers.DebugContext(gotocol.NilContext), name}
+	noodles[name] <- gotocol.Message{gotocol.Hello, listener, clock.Now(), handlers.DebugContext(gotocol.NilContext), name}
 	// there is a eureka service registry in each zone, so in-zone services just get to talk to their local registry
 	// elb are cross zone, so need to see all registries in a region
 	// denominator are cross region so need to see all registries globally
@@ -194,18 +277,19 @@ func StartNode(name string, dependencies ...string) {
 			crossregion = true
 		}
 	}
-	for n, ch := range eurekachan {
+	for _, n := range inOrder(eurekachan) {
+		ch := eurekachan[n]
 		if names.Region(name) == "*" || crossregion {
 			// need to know every eureka in all zones and regions
-			gotocol.Send(noodles[name], gotocol.Message{gotocol.Inform, ch, time.Now(), handlers.DebugContext(gotocol.NilContext), n})
+			gotocol.Send(noodles[name], gotocol.Message{gotocol.Inform, ch, clock.Now(), handlers.DebugContext(gotocol.NilContext), n})
 		} else {
 			if names.Zone(name) == "*" && names.Region(name) == names.Region(n) {
 				// need every eureka in my region
-				gotocol.Send(noodles[name], gotocol.Message{gotocol.Inform, ch, time.Now(), handlers.DebugContext(gotocol.NilContext), n})
+				gotocol.Send(noodles[name], gotocol.Message{gotocol.Inform, ch, clock.Now(), handlers.DebugContext(gotocol.NilContext), n})
 			} else {
 				if names.RegionZone(name) == names.RegionZone(n) {
 					// just the eureka in this specific zone
-					gotocol.Send(noodles[name], gotocol.Message{gotocol.Inform, ch, time.Now(), handlers.DebugContext(gotocol.NilContext), n})
+					gotocol.Send(noodles[name], gotocol.Message{gotocol.Inform, ch, clock.Now(), handlers.DebugContext(gotocol.NilContext), n})
 				}
 			}
 		}
@@ -215,33 +299,33 @@ func StartNode(name string, dependencies ...string) {
 	for _, dep := range dependencies {
 		if dep != "" && dep != "eureka" { // ignore special case of eureka in dependency list
 			//log.Println(name + " depends on " + dep)
-			gotocol.Send(noodles[name], gotocol.Message{gotocol.NameDrop, nil, time.Now(), handlers.DebugContext(gotocol.NilContext), dep})
+			gotocol.Send(noodles[name], gotocol.Message{gotocol.NameDrop, nil, clock.Now(), handlers.DebugContext(gotocol.NilContext), dep})
 		}
 	}
+	clock.Settle() // on a virtual clock the node has registered and looked up its dependencies before the next one starts
+}
+
+// inOrder lists the names of a set of channels sorted, so messages go out to them in the same order every run
+func inOrder(chans map[string]chan gotocol.Message) []string {
+	ns := make([]string, 0, len(chans))
+	for n := range chans {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
 }
 
 // CreateEureka service registries in each zone
 func CreateEureka() {
-	// setup name service and cross zone replication links
-	znames := archaius.Conf.ZoneNames
+	// setup name service and cross zone replication links to the other zones in the region
 	Create("eureka", EurekaPkg, archaius.Conf.Regions, len(archaius.Conf.ZoneNames))
-	for n, ch := range eurekachan {
-		var n1, n2 string
-		switch names.Zone(n) {
-		case znames[0]:
-			n1 = znames[1]
-			n2 = znames[2]
-		case znames[1]:
-			n1 = znames[0]
-			n2 = znames[2]
-		case znames[2]:
-			n1 = znames[0]
-			n2 = znames[1]
-		}
-		for nn, cch := range eurekachan {
-			if names.Region(nn) == names.Region(n) && (names.Zone(nn) == n1 || names.Zone(nn) == n2) {
+	for _, n := range inOrder(eurekachan) {
+		ch := eurekachan[n]
+		for _, nn := range inOrder(eurekachan) {
+			cch := eurekachan[nn]
+			if names.Region(nn) == names.Region(n) && names.Zone(nn) != names.Zone(n) {
 				//log.Println("Eureka cross connect from: " + n + " to " + nn)
-				gotocol.Send(ch, gotocol.Message{gotocol.NameDrop, cch, time.Now(), handlers.DebugContext(gotocol.NilContext), nn})
+				gotocol.Send(ch, gotocol.Message{gotocol.NameDrop, cch, clock.Now(), handlers.DebugContext(gotocol.NilContext), nn})
 			}
 		}
 	}
@@ -249,8 +333,9 @@ func CreateEureka() {
 
 // ConnectEveryEureka service in every region
 func ConnectEveryEureka(name string) {
-	for n, ch := range eurekachan {
-		gotocol.Send(noodles[name], gotocol.Message{gotocol.Inform, ch, time.Now(), handlers.DebugContext(gotocol.NilContext), n})
+	for _, n := range inOrder(eurekachan) {
+		ch := eurekachan[n]
+		gotocol.Send(noodles[name], gotocol.Message{gotocol.Inform, ch, clock.Now(), handlers.DebugContext(gotocol.NilContext), n})
 	}
 }
 
@@ -262,31 +347,369 @@ func Run(rootservice, victim string) {
 		delay = fmt.Sprintf("%dms", 10)
 	}
 	log.Println(rootservice+" activity rate ", delay)
-	SendToName(rootservice, gotocol.Message{gotocol.Chat, nil, time.Now(), handlers.DebugContext(gotocol.NilContext), delay})
+	SendToName(rootservice, gotocol.Message{gotocol.Chat, nil, clock.Now(), handlers.DebugContext(gotocol.NilContext), delay})
+	util := startThrottle(rootservice, delay)
 	// wait until the delay has finished
-	if archaius.Conf.RunDuration >= time.Millisecond {
-		time.Sleep(archaius.Conf.RunDuration / 2)
-		chaosmonkey.Delete(&noodles, victim) // kill a random victim half way through
-		time.Sleep(archaius.Conf.RunDuration / 2)
+	if archaius.Conf.RunDuration >= time.Millisecond || archaius.Conf.Forever {
+		half := clock.After(archaius.Conf.RunDuration / 2)
+		re := newDeadline(archaius.Conf.RunDuration) // the control api can move it
+		var end <-chan time.Time = re.end
+		var roll <-chan time.Time // nil unless running forever with collect
+		if archaius.Conf.Forever {
+			half = nil // never half way through
+			re.timer.Stop()
+			re = nil
+			end = untilSignaled()
+			if archaius.Conf.Collect {
+				ticker := clock.NewTicker(flow.RollWindow)
+				defer ticker.Stop()
+				roll = ticker.C
+			}
+		}
+		var tick <-chan time.Time // nil channel never fires if nothing is autoscaled
+		if len(scaled) > 0 {
+			interval := scaled[0].Interval
+			for _, sg := range scaled {
+				if sg.Interval < interval {
+					interval = sg.Interval
+				}
+				sg.last, sg.area = clock.Now(), collect.InFlightArea(sg.Service)
+			}
+			ticker := clock.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		var throttled <-chan time.Time // nil unless -targetutil is set
+		if util != nil {
+			ticker := clock.NewTicker(throttleInterval)
+			defer ticker.Stop()
+			throttled = ticker.C
+		}
+		var chaos <-chan time.Time // nil unless a chaos monkey is scheduled
+		if i := chaosmonkey.Interval(); i > 0 {
+			ticker := clock.NewTicker(i)
+			defer ticker.Stop()
+			chaos = ticker.C
+		}
+		replace := make(chan *scaledGroup) // autoscaled groups that are due a replacement after a cold start
+		var save <-chan time.Time          // nil unless -checkpoint is set
+		if i := checkpoint.Interval(); i > 0 {
+			ticker := clock.NewTicker(i)
+			defer ticker.Stop()
+			save = ticker.C
+		}
+		outage := make(chan archaius.ZoneOutage) // zones that are due to fail
+		for _, o := range archaius.Outages() {
+			o := o
+			s, _ := time.ParseDuration(o.Start)
+			clock.AfterFunc(s, func() {
+				select {
+				case outage <- o:
+				case <-end:
+				}
+			})
+		}
+		correlated := make(chan archaius.CorrelatedEvent) // correlated latency events that are due to start
+		for _, e := range archaius.CorrelatedEvents() {
+			e := e
+			s, _ := time.ParseDuration(e.Start)
+			clock.AfterFunc(s, func() {
+				select {
+				case correlated <- e:
+				case <-end:
+				}
+			})
+		}
+		for _, p := range archaius.Partitions() {
+			s, _ := time.ParseDuration(p.Start)
+			d, _ := time.ParseDuration(p.Duration)
+			groups := fmt.Sprint(p.Groups)
+			clock.AfterFunc(s, func() { collect.Mark("partition", groups) })
+			clock.AfterFunc(s+d, func() { collect.Mark("healed", groups) })
+		}
+		for _, e := range archaius.Evacuations() {
+			s, _ := time.ParseDuration(e.Start)
+			d, _ := time.ParseDuration(e.Duration)
+			region := e.Region
+			clock.AfterFunc(s, func() {
+				log.Printf("asgard: evacuating %v\n", region)
+				collect.Mark("evacuate", region)
+			})
+			clock.AfterFunc(s+d, func() { collect.Mark("returned", region) })
+		}
+		marked := make(map[string]bool) // external services with their outages on the timeline
+		for _, name := range inOrder(noodles) {
+			service := names.Service(name)
+			if x := archaius.Service(service).External; x != nil && !marked[service] {
+				marked[service] = true
+				for _, o := range x.Outages {
+					s, _ := time.ParseDuration(o.Start)
+					d, _ := time.ParseDuration(o.Duration)
+					clock.AfterFunc(s, func() { collect.Mark("externaldown", service) })
+					clock.AfterFunc(s+d, func() { collect.Mark("externalup", service) })
+				}
+			}
+			if c := archaius.Service(service).Caching; c != nil && !marked[service] {
+				marked[service] = true
+				for _, f := range c.Flushes {
+					t, _ := time.ParseDuration(f)
+					clock.AfterFunc(t, func() { collect.Mark("cacheflush", service) })
+				}
+			}
+		}
+		deploy := startDeployments(end) // rolling deployments that are due their next batch
+		scheduleFlags(end)              // feature flag changes are sent on flips when they're due
+		scheduleKeys(end)               // and keyval changes on keyChanges
+		scheduleChaos(end)              // and chaos events on chaosEvents
+		collect.StartTimeline()
+		start := clock.Now()
+		runStart = start
+	running:
+		for {
+			select {
+			case <-half:
+				chaosmonkey.Delete(&noodles, victim) // kill a random victim half way through
+			case <-tick:
+				Autoscale()
+			case <-throttled:
+				util.adjust()
+			case <-chaos:
+				killed(chaosmonkey.Rampage(noodles, gone), replace, end)
+			case e := <-chaosEvents:
+				chaosEvent(e, replace, end)
+			case o := <-outage:
+				for _, name := range chaosmonkey.Outage(noodles, gone, o.Region, o.Zone) {
+					terminated[names.Service(name)]++
+					if sg := scaledGroupOf(name); sg != nil {
+						sg.remove(name) // not replaced, autoscaling adds capacity in the zones that are left
+					}
+				}
+				for _, r := range archaius.Conf.RegionNames {
+					if o.Region == "" || o.Region == r {
+						down[r+"."+o.Zone] = true
+					}
+				}
+			case e := <-correlated:
+				g, _ := archaius.CorrelationGroupOf(e.Group)
+				victims := chaosmonkey.Correlate(noodles, gone, g.Services, g.Fraction)
+				latency, _ := time.ParseDuration(e.Latency)
+				duration, _ := time.ParseDuration(e.Duration)
+				archaius.Degrade(victims, latency)
+				collect.Mark("correlated", fmt.Sprintf("%v %v on %v instances", e.Group, latency, len(victims)))
+				group := e.Group
+				clock.AfterFunc(duration, func() {
+					archaius.Recover(victims, latency)
+					collect.Mark("recovered", group)
+				})
+				log.Printf("chaosmonkey correlated: %v added %v to %v instances for %v\n", e.Group, latency, len(victims), duration)
+				if slowed[e.Group] == nil {
+					slowed[e.Group] = make(map[string]int)
+				}
+				for _, name := range victims {
+					slowed[e.Group][names.Service(name)]++
+				}
+			case sg := <-replace:
+				name := sg.scaleUp()
+				log.Println("chaosmonkey replace: " + name)
+				collect.Mark("replaced", name)
+				replaced[sg.Service]++
+			case r := <-deploy:
+				if wait, more := r.step(start); more {
+					clock.AfterFunc(wait, func() {
+						select {
+						case deploy <- r:
+						case <-end:
+						}
+					})
+				}
+			case ff := <-flips:
+				flip(ff)
+			case kc := <-keyChanges:
+				changeKey(kc, rootservice)
+			case <-save:
+				checkpoint.Write(clock.Since(start))
+			case <-roll:
+				flow.Roll(flow.RollWindow)
+			case c := <-controls:
+				if runControl(c, re, rootservice, start) {
+					break running
+				}
+			case <-end:
+				break running
+			}
+		}
 	}
+	clock.Stop() // a virtual clock stays at the end of the run while it shuts down, so the times in the summary repeat
+	summarizeAutoscale()
+	summarizeThrottle(util)
+	summarizeChaos()
+	summarizeChaosEvents()
+	summarizeControl()
+	summarizeCorrelated()
+	summarizeDeployments()
+	handlers.SummarizeBalance()
+	handlers.SummarizeHealth()
 	log.Println("asgard: Shutdown")
 	ShutdownNodes()
+	summarizeCost()
 	ShutdownEureka()
 	collect.Save()
 }
 
+// untilSignaled returns a channel that is closed when spigo is interrupted or terminated, to end a run that goes on forever
+func untilSignaled() <-chan time.Time {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	end := make(chan time.Time)
+	start := clock.Now()
+	go func() {
+		log.Println("asgard: running until interrupted")
+		s := <-sig
+		signal.Stop(sig)
+		log.Printf("asgard: %v after %v\n", s, clock.Since(start))
+		close(end)
+	}()
+	return end
+}
+
+// Autoscale makes a scaling decision for each autoscaled service group that is due
+func Autoscale() {
+	for _, sg := range scaled {
+		elapsed := clock.Since(sg.last)
+		if elapsed < sg.Interval {
+			continue
+		}
+		sg.last = clock.Now()
+		area := collect.InFlightArea(sg.Service)
+		inflight := (area - sg.area) / float64(elapsed)
+		sg.area = area
+		var decision int
+		if sg.Queue != "" {
+			decision = sg.DecideDepth(collect.ServiceGauge(sg.Queue), len(sg.instances))
+		} else if sg.Health > 0 {
+			decision = sg.DecideHealth(handlers.ServiceHealth(sg.Service), len(sg.instances))
+		} else if sg.RPS > 0 {
+			_, requests := collect.WindowQuantile(sg.Service, 0.99)
+			decision = sg.DecideRPS(requests, elapsed, len(sg.instances))
+		} else if sg.Concurrency > 0 {
+			decision = sg.DecideConcurrency(inflight, len(sg.instances))
+		} else {
+			p99, requests := collect.WindowQuantile(sg.Service, 0.99)
+			decision = sg.Decide(p99, requests, len(sg.instances))
+		}
+		switch decision {
+		case 1:
+			collect.Mark("scaleup", sg.scaleUp())
+			sg.ups++
+		case -1:
+			name := sg.instances[len(sg.instances)-1]
+			sg.instances = sg.instances[:len(sg.instances)-1]
+			gone[name] = true
+			retire(name, "autoscale")
+			log.Println("autoscale delete: " + name)
+			collect.Mark("scaledown", name)
+			sg.downs++
+		}
+	}
+}
+
+// scaleUp starts a new instance in the next region and zone of the group, skipping zones that are down, and returns its name
+func (sg *scaledGroup) scaleUp() string {
+	var name string
+	for tries := 0; tries <= sg.regions*len(archaius.Conf.ZoneNames); tries++ {
+		region, zone := archaius.Conf.RegionNames[sg.next%sg.regions], archaius.Conf.ZoneNames[sg.next%len(archaius.Conf.ZoneNames)]
+		name = names.Make(archaius.Conf.Arch, region, zone, sg.Service, sg.packagename, sg.next)
+		sg.next++
+		if !down[region+"."+zone] {
+			break
+		}
+	}
+	StartNode(name, sg.dependencies...)
+	sg.instances = append(sg.instances, name)
+	return name
+}
+
+// remove forgets an instance that has been terminated, and returns false if it wasn't in the group
+func (sg *scaledGroup) remove(name string) bool {
+	for i, n := range sg.instances {
+		if n == name {
+			sg.instances = append(sg.instances[:i], sg.instances[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// scaledGroupOf finds the autoscaled group an instance belongs to, or nil
+func scaledGroupOf(name string) *scaledGroup {
+	for _, sg := range scaled {
+		if sg.Service == names.Service(name) {
+			return sg
+		}
+	}
+	return nil
+}
+
+// summarizeChaos records the chaos monkey terminations and replacements for each service in the run summary
+func summarizeChaos() {
+	if len(terminated) == 0 {
+		return
+	}
+	type result struct {
+		Terminated int `json:"terminated"`
+		Replaced   int `json:"replaced"`
+	}
+	results := make(map[string]result)
+	for s, n := range terminated {
+		results[s] = result{n, replaced[s]}
+	}
+	collect.Summarize("chaosmonkey", results)
+}
+
+// summarizeCorrelated records how many instances of each service were slowed by the events of each correlation group
+func summarizeCorrelated() {
+	if len(slowed) == 0 {
+		return
+	}
+	collect.Summarize("correlated", slowed)
+}
+
+// summarizeAutoscale records the outcome for each autoscaled service group in the run summary
+func summarizeAutoscale() {
+	if len(scaled) == 0 {
+		return
+	}
+	type result struct {
+		Target string `json:"target"`
+		Min    int    `json:"min"`
+		Max    int    `json:"max"`
+		Final  int    `json:"final"`
+		Ups    int    `json:"ups"`
+		Downs  int    `json:"downs"`
+	}
+	results := make(map[string]result)
+	for _, sg := range scaled {
+		results[sg.Service] = result{sg.Describe(), sg.Min, sg.Max, len(sg.instances), sg.ups, sg.downs}
+	}
+	collect.Summarize("autoscale", results)
+}
+
 // ShutdownNodes - shut down the nodes and wait for them to go away
 func ShutdownNodes() {
-	for _, noodle := range noodles {
-		gotocol.Message{gotocol.Goodbye, nil, time.Now(), handlers.DebugContext(gotocol.NilContext), "shutdown"}.GoSend(noodle)
+	runEnd = clock.Now()
+	for _, n := range inOrder(noodles) {
+		noodle := noodles[n]
+		gotocol.Message{gotocol.Goodbye, nil, clock.Now(), handlers.DebugContext(gotocol.NilContext), "shutdown"}.GoSend(noodle)
 	}
 	for len(noodles) > 0 {
 		msg := <-listener
+		gotocol.Received(msg, "asgard")
 		if archaius.Conf.Msglog {
 			log.Printf("asgard: %v\n", msg)
 		}
 		switch msg.Imposition {
 		case gotocol.Goodbye:
+			stopped(msg.Intention, msg.Sent) // when it stopped, which is earlier if it was terminated during the run
 			delete(noodles, msg.Intention)
 			if archaius.Conf.Msglog {
 				log.Printf("asgard: %v shutdown, population: %v    \n", msg.Intention, len(noodles))
@@ -299,8 +722,9 @@ func ShutdownNodes() {
 func ShutdownEureka() {
 	// shutdown eureka and wait to catch eureka reply
 	//log.Println(eurekachan)
-	for _, ch := range eurekachan {
-		gotocol.Message{gotocol.Goodbye, listener, time.Now(), handlers.DebugContext(gotocol.NilContext), "shutdown"}.GoSend(ch)
+	for _, n := range inOrder(eurekachan) {
+		ch := eurekachan[n]
+		gotocol.Message{gotocol.Goodbye, listener, clock.Now(), handlers.DebugContext(gotocol.NilContext), "shutdown"}.GoSend(ch)
 	}
 	for range eurekachan {
 		<-listener
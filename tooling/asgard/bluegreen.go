@@ -0,0 +1,131 @@
+package asgard
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// checkEvery is how often the errors of a blue green deployment are checked after the cutover, and it doesn't roll back on
+// fewer than minResponses
+const (
+	checkEvery   = 100 * time.Millisecond
+	minResponses = 10
+)
+
+// bluegreen runs the next stage of a blue green deployment, starting the green environment, cutting the traffic over to it,
+// then watching its errors until the blue environment is shut down, or the traffic goes straight back to it
+func (r *rollout) bluegreen(start time.Time) (time.Duration, bool) {
+	if r.started.IsZero() {
+		r.up(start)
+		return r.bake, true
+	}
+	if r.cutover.IsZero() {
+		r.cutover = clock.Now()
+		r.cutoverAt = float64(clock.Since(start)) / float64(time.Millisecond)
+		r.count, r.fails = collect.Served(r.Service)
+		r.switchTo(r.green, r.blue)
+		log.Printf("asgard deploy: %v cut over to %v on %v instances\n", r.Service, r.Version, len(r.green))
+		collect.Mark("cutover", r.Service+" "+r.Version)
+		return checkEvery, true
+	}
+	count, fails := collect.Served(r.Service)
+	count, fails = count-r.count, fails-r.fails
+	if count > 0 {
+		r.errors = float64(fails) / float64(count)
+	}
+	if r.Rollback > 0 && count >= minResponses && r.errors > r.Rollback {
+		r.switchTo(r.blue, r.green)
+		r.shutdown(r.green, "rollback")
+		r.rolledBack = true
+		r.mix = append(r.mix, versionMix{float64(clock.Since(start)) / float64(time.Millisecond), len(r.blue), 0})
+		log.Printf("asgard deploy: %v rolled back from %v after %v with %.1f%% errors\n", r.Service, r.Version, clock.Since(r.cutover), 100*r.errors)
+		collect.Mark("rollback", fmt.Sprintf("%v %v %.1f%% errors", r.Service, r.Version, 100*r.errors))
+		return 0, false
+	}
+	if clock.Since(r.cutover) < r.watch {
+		return checkEvery, true
+	}
+	r.shutdown(r.blue, "deploy")
+	r.finished = clock.Since(r.started)
+	r.mix = append(r.mix, versionMix{float64(clock.Since(start)) / float64(time.Millisecond), 0, len(r.green)})
+	collect.Mark("deployed", r.Service+" "+r.Version)
+	return 0, false
+}
+
+// up starts a green instance of the new version in the same zone as each running blue one, on standby so it doesn't get any
+// traffic until the cutover
+func (r *rollout) up(start time.Time) {
+	r.started = clock.Now()
+	for n := range noodles {
+		if !gone[n] && names.Service(n) == r.Service && archaius.Deployed(n) != r.Deployment {
+			r.blue = append(r.blue, n)
+		}
+	}
+	sort.Strings(r.blue)
+	for _, b := range r.blue {
+		next := 0 // instances are never taken out of noodles, so counting them gives an unused index
+		for n := range noodles {
+			if names.Service(n) == r.Service {
+				next++
+			}
+		}
+		if sg := scaledGroupOf(b); sg != nil {
+			next = sg.next
+			sg.next++
+		}
+		g := names.Make(archaius.Conf.Arch, names.Region(b), names.Zone(b), r.Service, names.Package(b), next)
+		archaius.Deploy(g, r.Deployment)
+		archaius.Standby(g, true) // before it starts, so it doesn't register
+		StartNode(g, serviceDependencies[r.Service]...)
+		r.green = append(r.green, g)
+	}
+	r.batches, r.replaced = 1, len(r.green)
+	r.mix = append(r.mix, versionMix{float64(clock.Since(start)) / float64(time.Millisecond), len(r.blue), len(r.green)})
+	log.Printf("asgard deploy: %v %v green environment of %v instances started\n", r.Service, r.Version, len(r.green))
+	collect.Mark("green", fmt.Sprintf("%v %v %v instances", r.Service, r.Version, len(r.green)))
+}
+
+// switchTo moves all the traffic from one environment to the other at once, registering the instances that are taking over
+// and deregistering the others, which stay up with nothing to do in case the traffic comes back
+func (r *rollout) switchTo(on, off []string) {
+	for _, n := range on {
+		if gone[n] {
+			continue
+		}
+		archaius.Standby(n, false)
+		register(n)
+		if sg := scaledGroupOf(n); sg != nil {
+			sg.instances = append(sg.instances, n)
+		}
+	}
+	for _, n := range off {
+		if gone[n] {
+			continue
+		}
+		deregister(n)
+		if sg := scaledGroupOf(n); sg != nil {
+			sg.remove(n)
+		}
+	}
+}
+
+// shutdown terminates the instances of the environment that no longer has the traffic
+func (r *rollout) shutdown(env []string, why string) {
+	for _, n := range env {
+		if gone[n] {
+			continue
+		}
+		gone[n] = true
+		if sg := scaledGroupOf(n); sg != nil {
+			sg.remove(n)
+		}
+		retire(n, why)
+	}
+}
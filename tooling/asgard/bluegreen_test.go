@@ -0,0 +1,80 @@
+package asgard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+)
+
+// TestBlueGreen checks the green environment starts on standby beside the blue one, takes all the traffic at the cutover,
+// and the blue one is shut down once the watch is over
+func TestBlueGreen(t *testing.T) {
+	blue := fleet("bluegreendb", 2)
+	r := &rollout{Deployment: &archaius.Deployment{Service: "bluegreendb", Version: "v2", Strategy: "bluegreen"}, bake: time.Second}
+	start := time.Now()
+	if wait, more := r.step(start); !more || wait != time.Second {
+		t.Fatalf("green started, then waited %v, more %v", wait, more)
+	}
+	if len(r.green) != 2 || len(running("bluegreendb")) != 4 {
+		t.Fatalf("%v green instances, %v running", len(r.green), len(running("bluegreendb")))
+	}
+	for _, g := range r.green {
+		if !archaius.OnStandby(g) || archaius.Deployed(g) != r.Deployment {
+			t.Errorf("green %v isn't on standby with the new version", g)
+		}
+	}
+	if _, more := r.step(start); !more || r.cutover.IsZero() {
+		t.Fatal("no cutover")
+	}
+	for _, g := range r.green {
+		if archaius.OnStandby(g) {
+			t.Errorf("green %v still on standby after the cutover", g)
+		}
+	}
+	if _, more := r.step(start); more { // no watch, so straight on
+		t.Fatal("deployment not over after the watch")
+	}
+	for _, b := range blue {
+		if !gone[b] {
+			t.Errorf("blue %v still running", b)
+		}
+	}
+	if r.rolledBack || r.finished <= 0 || len(running("bluegreendb")) != 2 {
+		t.Errorf("rolled back %v, took %v, %v running", r.rolledBack, r.finished, len(running("bluegreendb")))
+	}
+}
+
+// TestBlueGreenRollback checks the traffic goes straight back to blue and green is shut down when too many of the responses
+// after the cutover fail
+func TestBlueGreenRollback(t *testing.T) {
+	blue := fleet("rollbackdb", 2)
+	r := &rollout{Deployment: &archaius.Deployment{Service: "rollbackdb", Version: "v2", Strategy: "bluegreen", Rollback: 0.5},
+		bake: time.Second, watch: time.Hour}
+	start := time.Now()
+	r.step(start)
+	r.step(start) // cutover
+	if _, more := r.step(start); !more {
+		t.Fatal("rolled back without any responses")
+	}
+	for i := 0; i < minResponses; i++ {
+		collect.MeasureService("rollbackdb", time.Millisecond, i > 0)
+	}
+	if _, more := r.step(start); more || !r.rolledBack {
+		t.Fatalf("didn't roll back with %v errors", r.errors)
+	}
+	for _, g := range r.green {
+		if !gone[g] {
+			t.Errorf("green %v still running", g)
+		}
+	}
+	for _, b := range blue {
+		if gone[b] || archaius.OnStandby(b) {
+			t.Errorf("blue %v didn't get the traffic back", b)
+		}
+	}
+	if r.errors != 0.9 || r.finished != 0 {
+		t.Errorf("errors %v, took %v", r.errors, r.finished)
+	}
+}
@@ -0,0 +1,105 @@
+package asgard
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/chaosmonkey"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// chaosEvents are the kill and latency events that are due, picked up by the run loop, partitions are cut by archaius on the
+// same schedule as the ones in the architecture, and evacuations are kept by archaius too
+var chaosEvents = make(chan chaosmonkey.Event)
+
+// chaosResult is what a chaos event did, for the summary
+type chaosResult struct {
+	At        string   `json:"at"`
+	Action    string   `json:"action"`
+	Service   string   `json:"service,omitempty"`
+	Region    string   `json:"region,omitempty"`
+	Latency   string   `json:"latency,omitempty"`
+	Duration  string   `json:"duration,omitempty"`
+	Instances []string `json:"instances,omitempty"`
+}
+
+var chaosResults []chaosResult
+
+type byAt []chaosResult
+
+func (b byAt) Len() int      { return len(b) }
+func (b byAt) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byAt) Less(i, j int) bool {
+	ti, _ := time.ParseDuration(b[i].At)
+	tj, _ := time.ParseDuration(b[j].At)
+	return ti < tj
+}
+
+// scheduleChaos sends each kill and latency event on chaosEvents when it's due, and records the partitions and evacuations
+func scheduleChaos(end <-chan time.Time) {
+	chaosResults = nil
+	for _, e := range chaosmonkey.Events() {
+		if e.Action == "partition" || e.Action == "evacuate" {
+			chaosResults = append(chaosResults, chaosResult{At: e.At, Action: e.Action, Region: e.Region, Duration: e.Duration})
+			continue
+		}
+		e := e
+		t, _ := time.ParseDuration(e.At)
+		clock.AfterFunc(t, func() {
+			select {
+			case chaosEvents <- e:
+			case <-end:
+			}
+		})
+	}
+}
+
+// chaosEvent kills the instances an event hits, or slows them down until its duration is over
+func chaosEvent(e chaosmonkey.Event, replace chan *scaledGroup, end <-chan time.Time) {
+	victims := chaosmonkey.Hit(noodles, gone, e)
+	chaosResults = append(chaosResults, chaosResult{e.At, e.Action, e.Service, "", e.Latency, e.Duration, victims})
+	if e.Action == "kill" {
+		killed(victims, replace, end)
+		return
+	}
+	latency, _ := time.ParseDuration(e.Latency)
+	archaius.Degrade(victims, latency)
+	collect.Mark("chaoslatency", fmt.Sprintf("%v %v on %v instances", e.Service, latency, len(victims)))
+	if d, err := time.ParseDuration(e.Duration); err == nil {
+		service := e.Service
+		clock.AfterFunc(d, func() {
+			archaius.Recover(victims, latency)
+			collect.Mark("recovered", service)
+			log.Printf("chaosmonkey latency: %v recovered\n", service)
+		})
+	}
+}
+
+// killed counts the instances the chaos monkey terminated, and replaces autoscaled ones after a cold start
+func killed(victims []string, replace chan *scaledGroup, end <-chan time.Time) {
+	for _, name := range victims {
+		terminated[names.Service(name)]++
+		if sg := scaledGroupOf(name); sg != nil && sg.remove(name) {
+			clock.AfterFunc(chaosmonkey.ColdStart(), func() {
+				select { // don't wait if the run has finished
+				case replace <- sg:
+				case <-end:
+				}
+			})
+		}
+	}
+}
+
+// summarizeChaosEvents records what each chaos event did, in the order they happened
+func summarizeChaosEvents() {
+	if len(chaosResults) == 0 {
+		return
+	}
+	sort.Stable(byAt(chaosResults))
+	collect.Summarize("chaosevents", chaosResults)
+}
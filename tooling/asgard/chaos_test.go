@@ -0,0 +1,53 @@
+package asgard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/chaosmonkey"
+)
+
+// TestChaosEvents checks the events of a chaos config fire when they're due, a kill terminates the count of instances it
+// asks for, a latency event slows a percent of the instances until its duration is over, and the summary is in time order
+func TestChaosEvents(t *testing.T) {
+	fleet("chaosdb", 4)
+	chaosmonkey.Schedule(&chaosmonkey.Config{Events: []chaosmonkey.Event{
+		{Action: "latency", At: "20ms", Service: "chaosdb", Percent: 50, Latency: "5ms", Duration: "20ms"},
+		{Action: "kill", At: "10ms", Service: "chaosdb", Count: 2},
+		{Action: "partition", At: "5ms", Region: "us-east-1", Duration: "1s"},
+	}})
+	end := make(chan time.Time)
+	defer close(end)
+	replace := make(chan *scaledGroup)
+	scheduleChaos(end)
+	due := func(action string) chaosmonkey.Event {
+		select {
+		case e := <-chaosEvents:
+			if e.Action != action {
+				t.Fatalf("%v event came before %v", e.Action, action)
+			}
+			return e
+		case <-time.After(time.Second):
+			t.Fatalf("%v event didn't fire", action)
+		}
+		return chaosmonkey.Event{}
+	}
+	chaosEvent(due("kill"), replace, end)
+	if n := len(running("chaosdb")); n != 2 || terminated["chaosdb"] != 2 {
+		t.Errorf("%v running and %v terminated after the kill", n, terminated["chaosdb"])
+	}
+	chaosEvent(due("latency"), replace, end)
+	slow := chaosResults[len(chaosResults)-1].Instances
+	if len(slow) != 1 || archaius.Degraded(slow[0]) != 5*time.Millisecond {
+		t.Fatalf("latency event slowed %v", slow)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if d := archaius.Degraded(slow[0]); d != 0 {
+		t.Errorf("%v still slowed by %v after the duration", slow[0], d)
+	}
+	summarizeChaosEvents()
+	if len(chaosResults) != 3 || chaosResults[0].Action != "partition" || chaosResults[1].Action != "kill" || len(chaosResults[1].Instances) != 2 {
+		t.Errorf("results %+v", chaosResults)
+	}
+}
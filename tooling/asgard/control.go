@@ -0,0 +1,304 @@
+package asgard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	. "github.com/adrianco/spigo/actors/packagenames"
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// control is a change asked for on the control api, made by the run loop so it's the only one starting and stopping
+// instances, the reply is the state afterwards or what went wrong
+type control struct {
+	action  string // status, scale, chat, extend or stop
+	service string
+	count   int
+	value   string
+	reply   chan controlReply
+}
+
+type controlReply struct {
+	status int // http status
+	body   interface{}
+}
+
+// controls are picked up by the run loop
+var controls = make(chan control)
+
+// controlResult is a change made on the control api, for the summary
+type controlResult struct {
+	At      float64 `json:"atms"`
+	Action  string  `json:"action"`
+	Service string  `json:"service,omitempty"`
+	Value   string  `json:"value"`
+}
+
+var controlResults []controlResult
+
+// deadline is when the run finishes, end is closed then, and the control api can move it or stop the run now
+type deadline struct {
+	end   chan time.Time
+	timer *clock.Timer
+	at    time.Time
+}
+
+func newDeadline(d time.Duration) *deadline {
+	r := &deadline{end: make(chan time.Time), at: clock.Now().Add(d)}
+	r.timer = clock.AfterFunc(d, func() { close(r.end) })
+	return r
+}
+
+// extend moves the end of the run later, false if it has already ended
+func (r *deadline) extend(by time.Duration) bool {
+	if !r.timer.Stop() {
+		return false
+	}
+	r.at = r.at.Add(by)
+	r.timer.Reset(clock.Until(r.at))
+	return true
+}
+
+// stop ends the run now if it hasn't already
+func (r *deadline) stop() {
+	if r.timer.Stop() {
+		close(r.end)
+	}
+}
+
+// runControl makes a change from the control api, and returns true if the run should stop. Without a deadline the run goes
+// on until it's interrupted, so it can't be extended
+func runControl(c control, re *deadline, rootservice string, start time.Time) bool {
+	reply := func(status int, body interface{}) {
+		c.reply <- controlReply{status, body}
+	}
+	done := func(value string) {
+		controlResults = append(controlResults, controlResult{float64(clock.Since(start)) / float64(time.Millisecond), c.action, c.service, value})
+		collect.Mark("control", fmt.Sprintf("%v %v", c.action, value))
+	}
+	switch c.action {
+	case "status":
+		remaining, chat := "", archaius.Key(archaius.Conf, "chat")
+		if chat == "" {
+			chat = "10ms" // the default in Run
+		}
+		if re != nil {
+			remaining = clock.Until(re.at).String()
+		}
+		reply(http.StatusOK, map[string]interface{}{"arch": archaius.Conf.Arch, "elapsed": clock.Since(start).String(), "remaining": remaining,
+			"chat": chat, "services": runningCounts()})
+	case "scale":
+		from, err := scaleTo(c.service, c.count)
+		if err != nil {
+			reply(http.StatusBadRequest, err.Error())
+			return false
+		}
+		log.Printf("asgard: control scales %v from %v to %v instances\n", c.service, from, c.count)
+		done(fmt.Sprintf("%v to %v", from, c.count))
+		reply(http.StatusOK, map[string]int{c.service: c.count})
+	case "chat":
+		changeKey(archaius.KeyChange{Key: "chat", Value: c.value}, rootservice)
+		done(c.value)
+		reply(http.StatusOK, map[string]string{"chat": c.value})
+	case "extend":
+		by, _ := time.ParseDuration(c.value)
+		if re == nil || !re.extend(by) {
+			reply(http.StatusConflict, "the run can't be extended, it's running until it's interrupted or has ended")
+			return false
+		}
+		log.Printf("asgard: control extends the run by %v\n", by)
+		done(c.value)
+		reply(http.StatusOK, map[string]string{"remaining": clock.Until(re.at).String()})
+	case "stop":
+		log.Printf("asgard: control stops the run after %v\n", clock.Since(start))
+		done(clock.Since(start).String())
+		reply(http.StatusOK, map[string]string{"elapsed": clock.Since(start).String()})
+		if re != nil {
+			re.stop()
+		}
+		return true
+	}
+	return false
+}
+
+// runningCounts is the number of running instances of each service
+func runningCounts() map[string]int {
+	counts := make(map[string]int)
+	for n := range noodles {
+		if !gone[n] {
+			counts[names.Service(n)]++
+		}
+	}
+	return counts
+}
+
+// scaleTo starts or stops instances of a service until it has count running, and returns how many it had. New instances
+// go in the region and zone with the fewest, skipping zones that are down, and the newest instances are stopped first.
+// Autoscaled services use their group, and carry on autoscaling from there
+func scaleTo(service string, count int) (int, error) {
+	var all, running []string // instances are never taken out of noodles, so all of them gives an unused index
+	for n := range noodles {
+		if names.Service(n) == service {
+			all = append(all, n)
+			if !gone[n] {
+				running = append(running, n)
+			}
+		}
+	}
+	if len(all) == 0 {
+		return 0, fmt.Errorf("no service %v", service)
+	}
+	sort.Strings(all)
+	template := all[0]
+	if names.Zone(template) == "*" || names.Package(template) == EurekaPkg {
+		return 0, fmt.Errorf("%v isn't in zones, so it can't be scaled", service)
+	}
+	if count < 0 {
+		return 0, errors.New("count can't be negative")
+	}
+	from := len(running)
+	sg := scaledGroupOf(template)
+	for n := from; n < count; n++ {
+		if sg != nil {
+			collect.Mark("scaleup", sg.scaleUp())
+			continue
+		}
+		name := leastUsed(service, names.Package(template), all, running)
+		StartNode(name, serviceDependencies[service]...)
+		collect.Mark("scaleup", name)
+		all, running = append(all, name), append(running, name)
+	}
+	if count < from {
+		if sg == nil {
+			sort.Sort(byIndex(running)) // newest last
+		} else {
+			running = append([]string{}, sg.instances...)
+		}
+		for _, name := range running[count:] {
+			gone[name] = true
+			if sg != nil {
+				sg.remove(name)
+			}
+			retire(name, "control")
+			collect.Mark("scaledown", name)
+		}
+	}
+	return from, nil
+}
+
+// byIndex sorts instances in the order they were created, the index on the end of the name is zero padded but can grow longer
+type byIndex []string
+
+func (b byIndex) Len() int      { return len(b) }
+func (b byIndex) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byIndex) Less(i, j int) bool {
+	ii, ij := names.Instance(b[i]), names.Instance(b[j])
+	if len(ii) != len(ij) {
+		return len(ii) < len(ij)
+	}
+	return ii < ij
+}
+
+// leastUsed names a new instance in the region and zone, out of the ones the service is in, with the fewest running
+func leastUsed(service, pkg string, all, running []string) string {
+	type place struct{ region, zone string }
+	n := make(map[place]int) // running instances in each region and zone
+	var places []place
+	for _, i := range all {
+		p := place{names.Region(i), names.Zone(i)}
+		if _, ok := n[p]; !ok {
+			places = append(places, p)
+			n[p] = 0
+		}
+	}
+	for _, i := range running {
+		n[place{names.Region(i), names.Zone(i)}]++
+	}
+	best := places[0] // all is in name order, so this is too
+	for _, p := range places {
+		if down[best.region+"."+best.zone] || (!down[p.region+"."+p.zone] && n[p] < n[best]) {
+			best = p
+		}
+	}
+	return names.Make(archaius.Conf.Arch, best.region, best.zone, service, pkg, len(all))
+}
+
+// summarizeControl records the changes made on the control api
+func summarizeControl() {
+	if len(controlResults) == 0 {
+		return
+	}
+	collect.Summarize("control", controlResults)
+}
+
+// ServeControl adds the control api to the collect web server, to change the architecture while it's running. GET on
+// /api/v1/status returns the running instances of each service, the chat rate and the time left, and a POST to
+// /api/v1/scale with a service and count, /api/v1/chat with a rate, /api/v1/extend with a duration to add to the run, or
+// /api/v1/stop changes it, e.g. curl -d service=homepage -d count=12 localhost:8123/api/v1/scale
+func ServeControl() {
+	http.Handle("/api/v1/", controlHandler())
+}
+
+// controlHandler serves the control api, a bad request is turned away before it gets to the run loop
+func controlHandler() *http.ServeMux {
+	mux := http.NewServeMux()
+	handle := func(path string, method string, parse func(r *http.Request) (control, error)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != method {
+				http.Error(w, method, http.StatusMethodNotAllowed)
+				return
+			}
+			c, err := parse(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c.reply = make(chan controlReply, 1)
+			select {
+			case controls <- c:
+			case <-time.After(time.Second):
+				http.Error(w, "architecture isn't running", http.StatusServiceUnavailable)
+				return
+			}
+			cr := <-c.reply
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(cr.status)
+			json.NewEncoder(w).Encode(cr.body)
+		})
+	}
+	handle("/api/v1/status", "GET", func(r *http.Request) (control, error) {
+		return control{action: "status"}, nil
+	})
+	handle("/api/v1/scale", "POST", func(r *http.Request) (control, error) {
+		count, err := strconv.Atoi(r.FormValue("count"))
+		if err != nil || r.FormValue("service") == "" {
+			return control{}, errors.New("needs a service and a count")
+		}
+		return control{action: "scale", service: r.FormValue("service"), count: count}, nil
+	})
+	handle("/api/v1/chat", "POST", func(r *http.Request) (control, error) {
+		if d, err := time.ParseDuration(r.FormValue("rate")); err != nil || d <= 0 {
+			return control{}, errors.New("needs a rate, e.g. 10ms")
+		}
+		return control{action: "chat", value: r.FormValue("rate")}, nil
+	})
+	handle("/api/v1/extend", "POST", func(r *http.Request) (control, error) {
+		if d, err := time.ParseDuration(r.FormValue("by")); err != nil || d <= 0 {
+			return control{}, errors.New("needs a duration to extend by, e.g. 30s")
+		}
+		return control{action: "extend", value: r.FormValue("by")}, nil
+	})
+	handle("/api/v1/stop", "POST", func(r *http.Request) (control, error) {
+		return control{action: "stop"}, nil
+	})
+	return mux
+}
@@ -0,0 +1,144 @@
+package asgard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// runLoop makes the changes asked for on the control api like the run loop does, until one stops the run
+func runLoop(re *deadline, rootservice string) chan bool {
+	stopped := make(chan bool)
+	go func() {
+		for !runControl(<-controls, re, rootservice, clock.Now()) {
+		}
+		close(stopped)
+	}()
+	return stopped
+}
+
+// call the control api, and return the status and the body decoded into reply
+func call(t *testing.T, srv *httptest.Server, method, path string, form url.Values, reply interface{}) int {
+	var resp *http.Response
+	var err error
+	if method == "GET" {
+		resp, err = http.Get(srv.URL + path)
+	} else {
+		resp, err = http.PostForm(srv.URL+path, form)
+	}
+	if err != nil {
+		t.Fatalf("%v %v: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if reply != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+			t.Errorf("%v %v reply: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestControl checks each verb of the control api changes the running architecture and replies with the state afterwards
+func TestControl(t *testing.T) {
+	fleet("controldb", 2)
+	root := names.Make("test", "us-east-1", "zoneA", "controlweb", "karyon", 0)
+	rootch := make(chan gotocol.Message, 1)
+	noodles[root] = rootch
+	re := newDeadline(time.Hour)
+	stopped := runLoop(re, root)
+	srv := httptest.NewServer(controlHandler())
+	defer srv.Close()
+
+	var status struct {
+		Chat     string         `json:"chat"`
+		Services map[string]int `json:"services"`
+	}
+	if s := call(t, srv, "GET", "/api/v1/status", nil, &status); s != http.StatusOK || status.Services["controldb"] != 2 || status.Chat == "" {
+		t.Errorf("status %v %+v", s, status)
+	}
+	for _, count := range []int{3, 1} {
+		var scaled map[string]int
+		if s := call(t, srv, "POST", "/api/v1/scale", url.Values{"service": {"controldb"}, "count": {strconv.Itoa(count)}}, &scaled); s != http.StatusOK || scaled["controldb"] != count {
+			t.Errorf("scale to %v: %v %v", count, s, scaled)
+		}
+		if n := len(running("controldb")); n != count {
+			t.Errorf("%v running after scaling to %v", n, count)
+		}
+	}
+	if s := call(t, srv, "POST", "/api/v1/chat", url.Values{"rate": {"5ms"}}, nil); s != http.StatusOK {
+		t.Errorf("chat %v", s)
+	}
+	select {
+	case m := <-rootch:
+		if m.Imposition != gotocol.Chat || m.Intention != "5ms" {
+			t.Errorf("root service got %v %v", m.Imposition, m.Intention)
+		}
+	case <-time.After(time.Second):
+		t.Error("the new chat rate wasn't sent to the root service")
+	}
+	at := re.at
+	if s := call(t, srv, "POST", "/api/v1/extend", url.Values{"by": {"30s"}}, nil); s != http.StatusOK || re.at.Sub(at) != 30*time.Second {
+		t.Errorf("extend %v moved the end by %v", s, re.at.Sub(at))
+	}
+	if s := call(t, srv, "POST", "/api/v1/stop", nil, nil); s != http.StatusOK {
+		t.Errorf("stop %v", s)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the run didn't stop")
+	}
+	select {
+	case <-re.end:
+	default:
+		t.Error("the deadline is still running after the stop")
+	}
+}
+
+// TestControlBadRequest checks bad input is turned away with a 400, or a 405 for the wrong method, and the run carries on
+func TestControlBadRequest(t *testing.T) {
+	fleet("badcontroldb", 1)
+	stopped := runLoop(nil, "")
+	srv := httptest.NewServer(controlHandler())
+	defer srv.Close()
+	for _, c := range []struct {
+		method, path string
+		form         url.Values
+		want         int
+	}{
+		{"POST", "/api/v1/status", nil, http.StatusMethodNotAllowed},
+		{"GET", "/api/v1/scale", nil, http.StatusMethodNotAllowed},
+		{"POST", "/api/v1/scale", url.Values{"service": {"badcontroldb"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/scale", url.Values{"service": {"badcontroldb"}, "count": {"lots"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/scale", url.Values{"count": {"2"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/scale", url.Values{"service": {"nosuchdb"}, "count": {"2"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/scale", url.Values{"service": {"badcontroldb"}, "count": {"-1"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/chat", nil, http.StatusBadRequest},
+		{"POST", "/api/v1/chat", url.Values{"rate": {"fast"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/chat", url.Values{"rate": {"-1s"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/extend", url.Values{"by": {"soon"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/extend", url.Values{"by": {"0s"}}, http.StatusBadRequest},
+		{"POST", "/api/v1/extend", url.Values{"by": {"30s"}}, http.StatusConflict}, // no deadline
+	} {
+		if s := call(t, srv, c.method, c.path, c.form, nil); s != c.want {
+			t.Errorf("%v %v %v: %v, want %v", c.method, c.path, c.form, s, c.want)
+		}
+	}
+	if n := len(running("badcontroldb")); n != 1 {
+		t.Errorf("%v running after the bad requests", n)
+	}
+	call(t, srv, "POST", "/api/v1/stop", nil, nil)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the run didn't stop")
+	}
+}
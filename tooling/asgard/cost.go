@@ -0,0 +1,85 @@
+package asgard
+
+import (
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// hoursPerMonth projects the cost of a run to a month of running the same way
+const hoursPerMonth = 730
+
+// serviceCost is what the instances of a service cost while they were running, including the ones that were terminated,
+// scaled down or replaced during the run
+type serviceCost struct {
+	Instances int     `json:"instances"`     // that ran during the run
+	Hours     float64 `json:"instancehours"` // summed over the instances
+	Hourly    float64 `json:"hourly"`        // mean cost of an instance an hour, over its sizes
+	Cost      float64 `json:"cost"`
+	PerMonth  float64 `json:"permonth"` // at the mean number of instances the run had
+}
+
+var costs = make(map[string]*serviceCost) // by service name
+var runStart, runEnd time.Time
+
+// hourly is what an instance costs an hour, by its size if that has a cost of its own, zero if it doesn't have a cost
+func hourly(name string) float64 {
+	service := names.Service(name)
+	if z := archaius.Size(name, service); z != nil && z.Cost > 0 {
+		return z.Cost
+	}
+	return archaius.Service(service).Cost
+}
+
+// stopped adds the cost of an instance from when it started, or the run started if it was started before that, until it said goodbye
+func stopped(name string, at time.Time) {
+	h := hourly(name)
+	if h == 0 {
+		return
+	}
+	from := archaius.StartedAt(name)
+	if from.Before(runStart) {
+		from = runStart
+	}
+	hours := 0.0
+	if at.After(from) {
+		hours = at.Sub(from).Hours()
+	}
+	c := costs[names.Service(name)]
+	if c == nil {
+		c = &serviceCost{}
+		costs[names.Service(name)] = c
+	}
+	c.Instances++
+	c.Hours += hours
+	c.Cost += hours * h
+}
+
+// summarizeCost records the cost of each service with a cost and the total in the run summary, once every instance has
+// said goodbye, with what running that way for a month would cost
+func summarizeCost() {
+	if len(costs) == 0 {
+		return
+	}
+	run := runEnd.Sub(runStart).Hours()
+	var result struct {
+		Cost     float64                `json:"cost"`
+		PerMonth float64                `json:"permonth"`
+		Services map[string]serviceCost `json:"services"`
+	}
+	result.Services = make(map[string]serviceCost, len(costs))
+	for s, c := range costs {
+		if c.Hours > 0 {
+			c.Hourly = c.Cost / c.Hours
+		}
+		if run > 0 {
+			c.PerMonth = c.Cost / run * hoursPerMonth
+		}
+		result.Cost += c.Cost
+		result.PerMonth += c.PerMonth
+		result.Services[s] = *c
+	}
+	collect.Summarize("cost", result)
+}
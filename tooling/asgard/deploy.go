@@ -0,0 +1,166 @@
+package asgard
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// rollout is a rolling or blue green deployment in progress
+type rollout struct {
+	*archaius.Deployment
+	bake     time.Duration
+	batches  int
+	replaced int
+	started  time.Time
+	mix      []versionMix
+	finished time.Duration
+	// blue green deployments only
+	watch        time.Duration
+	blue, green  []string  // instances of each environment
+	cutover      time.Time // when the traffic moved to green
+	cutoverAt    float64   // ms since the run started
+	count, fails int       // responses of the service before the cutover
+	errors       float64   // fraction of the responses since the cutover that failed
+	rolledBack   bool
+}
+
+// versionMix is how many instances of a service run each version after a batch of a deployment
+type versionMix struct {
+	At  float64 `json:"ms"` // since the run started
+	Old int     `json:"old"`
+	New int     `json:"new"`
+}
+
+var rollouts []*rollout
+
+// startDeployments schedules the deployments, sending each one on the returned channel when its next batch or stage is due
+func startDeployments(end <-chan time.Time) chan *rollout {
+	deploy := make(chan *rollout)
+	rollouts = nil
+	ds := archaius.Deployments()
+	for i := range ds {
+		r := &rollout{Deployment: &ds[i], bake: time.Second, watch: 5 * time.Second}
+		if ds[i].Bake != "" {
+			r.bake, _ = time.ParseDuration(ds[i].Bake)
+		}
+		if ds[i].Watch != "" {
+			r.watch, _ = time.ParseDuration(ds[i].Watch)
+		}
+		rollouts = append(rollouts, r)
+		s, _ := time.ParseDuration(ds[i].Start)
+		clock.AfterFunc(s, func() {
+			select {
+			case deploy <- r:
+			case <-end:
+			}
+		})
+	}
+	return deploy
+}
+
+// step runs the next batch of a rolling deployment or the next stage of a blue green one, and returns how long to wait before
+// the one after, or false when the deployment is over
+func (r *rollout) step(start time.Time) (time.Duration, bool) {
+	if r.Strategy == "bluegreen" {
+		return r.bluegreen(start)
+	}
+	return r.bake, r.batch(start)
+}
+
+// batch replaces the next batch of instances that still run the old version with new ones in the same zones, and returns false
+// when none are left, so the deployment is complete
+func (r *rollout) batch(start time.Time) bool {
+	if r.batches == 0 {
+		r.started = clock.Now()
+	}
+	var old []string
+	running := 0
+	for n := range noodles {
+		if gone[n] || names.Service(n) != r.Service {
+			continue
+		}
+		running++
+		if archaius.Deployed(n) != r.Deployment {
+			old = append(old, n)
+		}
+	}
+	sort.Strings(old)
+	size := r.Batch
+	if size <= 0 {
+		size = 1
+	}
+	if size > len(old) {
+		size = len(old)
+	}
+	for _, name := range old[:size] {
+		r.replace(name)
+	}
+	r.batches++
+	r.replaced += size
+	left := len(old) - size
+	r.mix = append(r.mix, versionMix{float64(clock.Since(start)) / float64(time.Millisecond), left, running - left})
+	log.Printf("asgard deploy: %v %v batch %v, %v of %v instances updated\n", r.Service, r.Version, r.batches, running-left, running)
+	collect.Mark("deploy", fmt.Sprintf("%v %v batch %v %v/%v", r.Service, r.Version, r.batches, running-left, running))
+	if left == 0 {
+		r.finished = clock.Since(r.started)
+		collect.Mark("deployed", r.Service+" "+r.Version)
+		return false
+	}
+	return true
+}
+
+// replace shuts down an instance the same way autoscale does, and starts one with the new version in its place
+func (r *rollout) replace(name string) {
+	gone[name] = true
+	retire(name, "deploy")
+	next := 0 // instances are never taken out of noodles, so counting them gives an unused index
+	for n := range noodles {
+		if names.Service(n) == r.Service {
+			next++
+		}
+	}
+	sg := scaledGroupOf(name)
+	if sg != nil {
+		sg.remove(name)
+		next = sg.next
+		sg.next++
+	}
+	n := names.Make(archaius.Conf.Arch, names.Region(name), names.Zone(name), r.Service, names.Package(name), next)
+	archaius.Deploy(n, r.Deployment) // before it starts, so its first calls have the new behavior
+	StartNode(n, serviceDependencies[r.Service]...)
+	if sg != nil {
+		sg.instances = append(sg.instances, n)
+	}
+}
+
+// summarizeDeployments records the progress of each deployment and the version mix after each batch in the run summary
+func summarizeDeployments() {
+	if len(rollouts) == 0 {
+		return
+	}
+	type result struct {
+		Version    string       `json:"version"`
+		Strategy   string       `json:"strategy,omitempty"`
+		Batches    int          `json:"batches"`
+		Replaced   int          `json:"replaced"`
+		Complete   bool         `json:"complete"`
+		Took       float64      `json:"tookms,omitempty"` // from the first batch to the last
+		Mix        []versionMix `json:"mix"`
+		Cutover    float64      `json:"cutoverms,omitempty"` // since the run started
+		Errors     float64      `json:"errors,omitempty"`    // fraction of the responses after the cutover that failed
+		RolledBack bool         `json:"rolledback,omitempty"`
+	}
+	results := make(map[string][]result)
+	for _, r := range rollouts {
+		results[r.Service] = append(results[r.Service], result{r.Version, r.Strategy, r.batches, r.replaced, r.finished > 0,
+			float64(r.finished) / float64(time.Millisecond), r.mix, r.cutoverAt, r.errors, r.rolledBack})
+	}
+	collect.Summarize("deployments", results)
+}
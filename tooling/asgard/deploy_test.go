@@ -0,0 +1,78 @@
+package asgard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// fleet starts instances of a store service across the zones of one region, without a name service, and returns their names
+func fleet(service string, n int) []string {
+	archaius.Conf.Arch = "test"
+	archaius.Conf.EurekaPoll = "1h"
+	archaius.Conf.Regions = 1
+	archaius.Conf.RegionNames = []string{"us-east-1"}
+	archaius.Conf.ZoneNames = []string{"zoneA", "zoneB", "zoneC"}
+	if noodles == nil {
+		CreateChannels()
+	}
+	var ns []string
+	for i := 0; i < n; i++ {
+		name := names.Make("test", "us-east-1", archaius.Conf.ZoneNames[i%3], service, "store", i)
+		StartNode(name)
+		ns = append(ns, name)
+	}
+	return ns
+}
+
+// running instances of a service
+func running(service string) []string {
+	var ns []string
+	for _, n := range inOrder(noodles) {
+		if !gone[n] && names.Service(n) == service {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+// TestRollingDeploy checks each batch replaces the next instances still on the old version with new ones in the same zones,
+// the version mix is recorded after each batch, and the deployment completes once none are left
+func TestRollingDeploy(t *testing.T) {
+	old := fleet("rollingdb", 3)
+	r := &rollout{Deployment: &archaius.Deployment{Service: "rollingdb", Version: "v2", Batch: 2}, bake: time.Second}
+	start := time.Now()
+	if !r.batch(start) {
+		t.Fatal("deployment complete after the first batch")
+	}
+	if !gone[old[0]] || !gone[old[1]] || gone[old[2]] {
+		t.Errorf("first batch replaced %v %v %v", gone[old[0]], gone[old[1]], gone[old[2]])
+	}
+	if r.batch(start) {
+		t.Fatal("deployment not complete after every instance was replaced")
+	}
+	zones := make(map[string]int)
+	for _, n := range old {
+		zones[names.Zone(n)]++
+	}
+	now := running("rollingdb")
+	for _, n := range now {
+		if archaius.Deployed(n) != r.Deployment {
+			t.Errorf("%v doesn't run the new version", n)
+		}
+		zones[names.Zone(n)]--
+	}
+	for z, c := range zones {
+		if c != 0 {
+			t.Errorf("zone %v has %v fewer instances than before the deployment", z, c)
+		}
+	}
+	if len(now) != 3 || r.batches != 2 || r.replaced != 3 || r.finished <= 0 {
+		t.Errorf("%v running, %v batches replaced %v, took %v", len(now), r.batches, r.replaced, r.finished)
+	}
+	if len(r.mix) != 2 || r.mix[0].Old != 1 || r.mix[0].New != 2 || r.mix[1].Old != 0 || r.mix[1].New != 3 {
+		t.Errorf("version mix %+v", r.mix)
+	}
+}
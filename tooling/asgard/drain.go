@@ -0,0 +1,139 @@
+package asgard
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// DrainStats counts the instances of a service that autoscaling and deployments took out of service gracefully
+type DrainStats struct {
+	Drained   int     `json:"drained"`
+	Clean     int     `json:"clean"` // finished everything they had in flight before they were terminated
+	TimedOut  int     `json:"timedout"`
+	Dropped   int     `json:"dropped"` // requests still in flight when a drain timed out
+	MeanDrain float64 `json:"meandrainms"`
+	MaxDrain  float64 `json:"maxdrainms"`
+	total     time.Duration
+}
+
+var drainStats = make(map[string]*DrainStats) // by service name
+var drainLock sync.Mutex
+
+func summarizeDrains() {
+	summary := make(map[string]DrainStats, len(drainStats))
+	for k, v := range drainStats {
+		summary[k] = *v
+	}
+	collect.Summarize("drain", summary)
+}
+
+// retire terminates an instance that autoscaling or a deployment no longer needs, the same way chaosmonkey does unless its
+// service has a drain config, then it's deregistered and drained first. ShutdownNodes collects the goodbye either way
+func retire(name, why string) {
+	ch := noodles[name]
+	d := archaius.Service(names.Service(name)).Drain
+	if d == nil {
+		gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NewTrace(), why}.GoSend(ch)
+		collect.InFlight(name, 0)
+		return
+	}
+	delay, err := time.ParseDuration(d.Delay)
+	if err != nil {
+		delay = 100 * time.Millisecond
+	}
+	timeout, err := time.ParseDuration(d.Timeout)
+	if err != nil {
+		timeout = 5 * time.Second
+	}
+	deregister(name)
+	collect.Mark("draining", name)
+	go drain(name, why, ch, delay, timeout)
+}
+
+// deregister tells the instances that call the service of an instance to stop sending to it, as a load balancer does when
+// an instance is taken out, and the name service to stop handing it out
+func deregister(name string) {
+	service := names.Service(name)
+	for _, n := range inOrder(noodles) {
+		ch := noodles[n]
+		if gone[n] {
+			continue
+		}
+		for _, dep := range serviceDependencies[names.Service(n)] {
+			if dep == service {
+				gotocol.Message{gotocol.Forget, nil, clock.Now(), gotocol.NilContext, name}.GoSend(ch)
+				break
+			}
+		}
+	}
+	for _, n := range inOrder(eurekachan) {
+		gotocol.Message{gotocol.Delete, nil, clock.Now(), gotocol.NilContext, name}.GoSend(eurekachan[n])
+	}
+}
+
+// register puts an instance in the name service and tells the instances that call its service about it straight away, the
+// way deregister takes one out
+func register(name string) {
+	service, ch := names.Service(name), noodles[name]
+	for _, n := range inOrder(eurekachan) {
+		gotocol.Message{gotocol.Put, ch, clock.Now(), gotocol.NilContext, name}.GoSend(eurekachan[n])
+	}
+	for _, n := range inOrder(noodles) {
+		c := noodles[n]
+		if gone[n] || n == name {
+			continue
+		}
+		for _, dep := range serviceDependencies[names.Service(n)] {
+			if dep == service {
+				gotocol.Message{gotocol.NameDrop, ch, clock.Now(), gotocol.NilContext, name}.GoSend(c)
+				break
+			}
+		}
+	}
+}
+
+// drain waits out the delay for the requests already on their way to a deregistered instance, then for the requests it has
+// in flight to finish, up to the timeout, and terminates it
+func drain(name, why string, ch chan gotocol.Message, delay, timeout time.Duration) {
+	start := clock.Now()
+	clock.Sleep(delay)
+	ticker := clock.NewTicker(time.Millisecond)
+	for handlers.InFlight(name) > 0 && clock.Since(start) < timeout {
+		<-ticker.C
+	}
+	ticker.Stop()
+	left := handlers.InFlight(name)
+	gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NewTrace(), why}.GoSend(ch)
+	collect.InFlight(name, 0)
+	took := clock.Since(start)
+	log.Printf("asgard drain: %v after %v with %v requests in flight\n", name, took, left)
+	collect.Mark("drained", name)
+	drainLock.Lock()
+	defer drainLock.Unlock()
+	s := drainStats[names.Service(name)]
+	if s == nil {
+		s = &DrainStats{}
+		drainStats[names.Service(name)] = s
+	}
+	s.Drained++
+	if left == 0 {
+		s.Clean++
+	} else {
+		s.TimedOut++
+		s.Dropped += left
+	}
+	s.total += took
+	s.MeanDrain = float64(s.total) / float64(s.Drained) / float64(time.Millisecond)
+	if ms := float64(took) / float64(time.Millisecond); ms > s.MaxDrain {
+		s.MaxDrain = ms
+	}
+	summarizeDrains()
+}
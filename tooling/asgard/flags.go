@@ -0,0 +1,88 @@
+package asgard
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	. "github.com/adrianco/spigo/actors/packagenames"
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// flagFlip is a change to a feature flag that's due, from its schedule or the /flags endpoint
+type flagFlip struct {
+	name string
+	on   bool
+	by   string
+}
+
+// flips are picked up by the run loop, which tells the featureflag services
+var flips = make(chan flagFlip)
+
+// scheduleFlags sends each change in the schedule of each feature flag on flips when it's due
+func scheduleFlags(end <-chan time.Time) {
+	for _, f := range archaius.Flags() {
+		for _, c := range f.Schedule {
+			ff := flagFlip{f.Name, c.On, "schedule"}
+			t, _ := time.ParseDuration(c.At)
+			clock.AfterFunc(t, func() {
+				select {
+				case flips <- ff:
+				case <-end:
+				}
+			})
+		}
+	}
+}
+
+// flip a feature flag by telling every running instance of the featureflag services to write it
+func flip(ff flagFlip) {
+	state := "off"
+	if ff.on {
+		state = "on"
+	}
+	log.Printf("asgard: %v turns flag %v %v\n", ff.by, ff.name, state)
+	for _, name := range inOrder(noodles) {
+		ch := noodles[name]
+		if names.Package(name) == FeatureflagPkg && !gone[name] {
+			gotocol.Message{gotocol.Put, nil, clock.Now(), gotocol.NewTrace(), ff.name + "=" + state}.GoSend(ch)
+		}
+	}
+}
+
+// ServeFlags adds /flags to the collect web server, a GET returns the state of each feature flag, and a POST or PUT with a
+// name and on=true or on=false flips one while the architecture is running, e.g. curl -d name=newrecs -d on=true localhost:8123/flags
+func ServeFlags() {
+	http.HandleFunc("/flags", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			state := make(map[string]bool)
+			for _, f := range archaius.Flags() {
+				state[f.Name], _ = archaius.FlagOn(f.Name)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(state)
+		case "POST", "PUT":
+			name, on := r.FormValue("name"), r.FormValue("on")
+			if _, ok := archaius.FlagOn(name); !ok {
+				http.Error(w, "no flag "+name, http.StatusNotFound)
+				return
+			}
+			if on != "true" && on != "false" {
+				http.Error(w, "on needs to be true or false", http.StatusBadRequest)
+				return
+			}
+			select {
+			case flips <- flagFlip{name, on == "true", "/flags"}:
+			case <-time.After(time.Second):
+				http.Error(w, "architecture isn't running", http.StatusServiceUnavailable)
+			}
+		default:
+			http.Error(w, "GET, POST or PUT", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package asgard
+
+import (
+	"log"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+)
+
+// keyChanges are the keyval changes in the schedule that are due, picked up by the run loop
+var keyChanges = make(chan archaius.KeyChange)
+
+// scheduleKeys sends each keyval change in the schedule on keyChanges when it's due
+func scheduleKeys(end <-chan time.Time) {
+	for _, kc := range archaius.Schedule() {
+		kc := kc
+		t, _ := time.ParseDuration(kc.At)
+		clock.AfterFunc(t, func() {
+			select {
+			case keyChanges <- kc:
+			case <-end:
+			}
+		})
+	}
+}
+
+// changeKey sets a keyval from the schedule and marks it on the timeline. A new chat rate is sent to the root service, other
+// keys take effect the next time they're looked up
+func changeKey(kc archaius.KeyChange, rootservice string) {
+	if err := archaius.SetKey(kc.Key, kc.Value); err != nil {
+		log.Printf("asgard: warning, schedule can't set %v: %v\n", kc.Key, err)
+		return
+	}
+	log.Printf("asgard: schedule sets %v to %v\n", kc.Key, kc.Value)
+	collect.Mark("keyval", kc.Key+":"+kc.Value)
+	if kc.Key == "chat" {
+		SendToName(rootservice, gotocol.Message{gotocol.Chat, nil, clock.Now(), handlers.DebugContext(gotocol.NilContext), kc.Value})
+	}
+}
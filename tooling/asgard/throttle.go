@@ -0,0 +1,88 @@
+package asgard
+
+import (
+	"log"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/autoscale"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/handlers"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// throttleInterval is how often the injector rate is adjusted, long enough for a change to settle into the requests in flight
+const throttleInterval = time.Second
+
+// throttle is the injector being held at a target utilization of a service, by changing the chat rate of the root service
+type throttle struct {
+	*autoscale.Throttle
+	root string
+	area float64 // reading of the requests in flight at the last adjustment
+	at   time.Time
+}
+
+// startThrottle sets up -targetutil for the root service chatting every delay, or returns nil if it isn't set
+func startThrottle(rootservice, delay string) *throttle {
+	if archaius.Conf.TargetUtil == "" {
+		return nil
+	}
+	if len(archaius.Journeys()) > 0 {
+		log.Fatal("targetutil: can't adjust the rate of journeys, each one starts at its own rate")
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil || d < autoscale.MinInterval || d > autoscale.MaxInterval {
+		log.Fatal("targetutil: bad chat rate " + delay)
+	}
+	t := &throttle{Throttle: autoscale.NewThrottle(archaius.Conf.TargetUtil, d), root: rootservice}
+	if instancesOf(t.Service) == 0 {
+		log.Fatal("targetutil: no instances of " + t.Service)
+	}
+	t.area, t.at = collect.InFlightArea(t.Service), clock.Now()
+	return t
+}
+
+// instancesOf counts the instances of a service that are still running
+func instancesOf(service string) int {
+	n := 0
+	for name := range noodles {
+		if names.Service(name) == service && !gone[name] {
+			n++
+		}
+	}
+	return n
+}
+
+// adjust the chat rate of the root service from the mean requests in flight across the service since the last adjustment
+func (t *throttle) adjust() {
+	now, area := clock.Now(), collect.InFlightArea(t.Service)
+	mean := (area - t.area) / float64(now.Sub(t.at))
+	t.area, t.at = area, now
+	before := t.Interval
+	if i := t.Adjust(mean, instancesOf(t.Service)); i != before {
+		SendToName(t.root, gotocol.Message{gotocol.Chat, nil, clock.Now(), handlers.DebugContext(gotocol.NilContext), i.String()})
+	}
+}
+
+// summarizeThrottle records the rate the injector found for the target utilization in the run summary
+func summarizeThrottle(t *throttle) {
+	if t == nil {
+		return
+	}
+	interval, settled := t.Sustained()
+	if !settled {
+		log.Printf("targetutil: %v didn't settle at utilization %v, ended at %.3f every %v\n", t.Service, t.Target, t.Utilization, t.Interval)
+	}
+	collect.Summarize("targetutil", struct {
+		Service     string  `json:"service"`
+		Target      float64 `json:"target"`
+		Utilization float64 `json:"utilization"` // in the last interval
+		Interval    string  `json:"interval"`    // between requests that held the target, as a chat rate
+		PerSecond   float64 `json:"persecond"`
+		Settled     bool    `json:"settled"` // held near the target for a few intervals in a row, otherwise the rate it ended at
+		Adjustments int     `json:"adjustments"`
+		Reversals   int     `json:"reversals"`
+	}{t.Service, t.Target, t.Utilization, interval.String(), float64(time.Second) / float64(interval), settled, t.Adjustments, t.Reversals})
+}
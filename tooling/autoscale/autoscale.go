@@ -0,0 +1,185 @@
+// Package autoscale implements a target tracking autoscaler policy driven by service group response time, load or work queue depth
+package autoscale
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+)
+
+// Group is the autoscaling state for one service group
+type Group struct {
+	Service     string
+	Target      time.Duration // p99 response time target
+	Queue       string        // work queue service to track the depth of instead of response time
+	Depth       int64         // queue depth target
+	Health      float64       // mean health score target, instead of response time
+	RPS         float64       // requests per second per instance target, instead of response time
+	Concurrency float64       // requests in flight per instance target, instead of response time
+	Interval    time.Duration // time between decisions
+	Cooldown    time.Duration // time after a scaling event before the next one
+	Min, Max    int           // instance count limits
+	Up, Down    int           // consecutive intervals needed before scaling up or down
+	over        int           // consecutive intervals over target
+	under       int           // consecutive intervals under the scale down threshold
+	changed     time.Time     // of the last scaling event
+}
+
+// NewGroup makes a group from the service config, or returns nil if the service isn't autoscaled
+func NewGroup(service string, count int) *Group {
+	c := archaius.Service(service).Autoscale
+	if c == nil {
+		return nil
+	}
+	g := &Group{Service: service, Queue: c.Queue, Depth: int64(c.Depth), Health: c.Health, RPS: c.RPS, Concurrency: c.Concurrency, Interval: time.Second, Min: count, Max: 4 * count, Up: 2, Down: 4}
+	var err error
+	if c.Queue != "" {
+		if c.Depth <= 0 {
+			log.Fatal("autoscale: bad queue depth target for " + service)
+		}
+	} else if c.Health != 0 {
+		if c.Health < 0 || c.Health > 1 || archaius.Service(service).Health == nil {
+			log.Fatal("autoscale: bad health target for " + service + ", needs a score between 0 and 1 and a health config")
+		}
+	} else if c.RPS != 0 || c.Concurrency != 0 {
+		if c.RPS < 0 || c.Concurrency < 0 || c.RPS > 0 && c.Concurrency > 0 {
+			log.Fatal("autoscale: bad load target for " + service + ", needs one of rps or concurrency above zero")
+		}
+	} else {
+		g.Target, err = time.ParseDuration(c.Target)
+		if err != nil || g.Target <= 0 {
+			log.Fatal("autoscale: bad target for " + service + ": " + c.Target)
+		}
+	}
+	if c.Interval != "" {
+		g.Interval, err = time.ParseDuration(c.Interval)
+		if err != nil || g.Interval <= 0 {
+			log.Fatal("autoscale: bad interval for " + service + ": " + c.Interval)
+		}
+	}
+	if c.Cooldown != "" {
+		g.Cooldown, err = time.ParseDuration(c.Cooldown)
+		if err != nil || g.Cooldown < 0 {
+			log.Fatal("autoscale: bad cooldown for " + service + ": " + c.Cooldown)
+		}
+	}
+	if c.Min > 0 {
+		g.Min = c.Min
+	}
+	if c.Max > 0 {
+		g.Max = c.Max
+	}
+	if g.Max < g.Min {
+		g.Max = g.Min
+	}
+	if c.Up > 0 {
+		g.Up = c.Up
+	}
+	if c.Down > 0 {
+		g.Down = c.Down
+	}
+	if g.Queue != "" {
+		log.Printf("autoscale: %v target %v depth %v, %v to %v instances every %v\n", service, g.Queue, g.Depth, g.Min, g.Max, g.Interval)
+	} else if g.Health > 0 {
+		log.Printf("autoscale: %v target health %v, %v to %v instances every %v\n", service, g.Health, g.Min, g.Max, g.Interval)
+	} else if g.RPS > 0 {
+		log.Printf("autoscale: %v target %v rps per instance, %v to %v instances every %v\n", service, g.RPS, g.Min, g.Max, g.Interval)
+	} else if g.Concurrency > 0 {
+		log.Printf("autoscale: %v target %v in flight per instance, %v to %v instances every %v\n", service, g.Concurrency, g.Min, g.Max, g.Interval)
+	} else {
+		log.Printf("autoscale: %v target p99 %v, %v to %v instances every %v\n", service, g.Target, g.Min, g.Max, g.Interval)
+	}
+	return g
+}
+
+// Decide how to change the instance count given the p99 response time and number of requests in the last interval.
+// Returns +1 to scale up, -1 to scale down or 0. Hysteresis comes from needing several intervals in a row
+// over target to scale up, or under half the target to scale down, and counts restart after each change.
+func (g *Group) Decide(p99 time.Duration, requests, instances int) int {
+	if requests == 0 {
+		return 0 // nothing observed, so no evidence either way
+	}
+	return g.decide(p99 > g.Target, p99 < g.Target/2, fmt.Sprintf("p99 %v target %v (%v requests)", p99, g.Target, requests), instances)
+}
+
+// DecideDepth is the same as Decide but driven by the depth of the work queue the group consumes from
+func (g *Group) DecideDepth(depth int64, instances int) int {
+	return g.decide(depth > g.Depth, depth < g.Depth/2, fmt.Sprintf("%v depth %v target %v", g.Queue, depth, g.Depth), instances)
+}
+
+// DecideHealth is the same as Decide but driven by the mean health score of the instances in the group
+func (g *Group) DecideHealth(score float64, instances int) int {
+	return g.decide(score > g.Health, score < g.Health/2, fmt.Sprintf("health %.2f target %v", score, g.Health), instances)
+}
+
+// DecideRPS is the same as Decide but driven by the rate of requests each instance handled over the elapsed interval
+func (g *Group) DecideRPS(requests int, elapsed time.Duration, instances int) int {
+	if elapsed <= 0 || instances == 0 {
+		return 0
+	}
+	rps := float64(requests) / elapsed.Seconds() / float64(instances)
+	return g.decide(rps > g.RPS, rps < g.RPS/2, fmt.Sprintf("%.1f rps per instance target %v", rps, g.RPS), instances)
+}
+
+// DecideConcurrency is the same as Decide but driven by the requests each instance has in flight, out of a total for the group
+func (g *Group) DecideConcurrency(inflight float64, instances int) int {
+	if instances == 0 {
+		return 0
+	}
+	c := inflight / float64(instances)
+	return g.decide(c > g.Concurrency, c < g.Concurrency/2, fmt.Sprintf("%.2f in flight per instance target %v", c, g.Concurrency), instances)
+}
+
+// Describe is the target the group tracks, for the run summary
+func (g *Group) Describe() string {
+	switch {
+	case g.Queue != "":
+		return fmt.Sprintf("%v depth %v", g.Queue, g.Depth)
+	case g.Health > 0:
+		return fmt.Sprintf("health %v", g.Health)
+	case g.RPS > 0:
+		return fmt.Sprintf("%v rps", g.RPS)
+	case g.Concurrency > 0:
+		return fmt.Sprintf("%v in flight", g.Concurrency)
+	}
+	return g.Target.String()
+}
+
+// decide applies the hysteresis to one interval that was over target, under half the target, or in between. During the
+// cooldown after a scaling event the counts carry on, but the group holds until it's over
+func (g *Group) decide(over, under bool, observed string, instances int) int {
+	switch {
+	case over:
+		g.over++
+		g.under = 0
+	case under:
+		g.under++
+		g.over = 0
+	default:
+		g.over, g.under = 0, 0
+	}
+	cooling := g.Cooldown > 0 && !g.changed.IsZero() && clock.Since(g.changed) < g.Cooldown
+	if cooling && (g.over >= g.Up && instances < g.Max || g.under >= g.Down && instances > g.Min) {
+		if archaius.Conf.Msglog {
+			log.Printf("autoscale: %v %v, holding at %v for the cooldown\n", g.Service, observed, instances)
+		}
+		return 0
+	}
+	if g.over >= g.Up && instances < g.Max {
+		log.Printf("autoscale: %v %v, over target for %v intervals, scaling up from %v to %v\n", g.Service, observed, g.over, instances, instances+1)
+		g.over, g.changed = 0, clock.Now()
+		return 1
+	}
+	if g.under >= g.Down && instances > g.Min {
+		log.Printf("autoscale: %v %v, under half the target for %v intervals, scaling down from %v to %v\n", g.Service, observed, g.under, instances, instances-1)
+		g.under, g.changed = 0, clock.Now()
+		return -1
+	}
+	if archaius.Conf.Msglog {
+		log.Printf("autoscale: %v %v, holding at %v\n", g.Service, observed, instances)
+	}
+	return 0
+}
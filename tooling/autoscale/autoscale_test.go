@@ -0,0 +1,125 @@
+// tests for autoscale
+package autoscale
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDecide(t *testing.T) {
+	g := &Group{Service: "test", Target: 10 * time.Millisecond, Interval: time.Second, Min: 2, Max: 3, Up: 2, Down: 2}
+	n := 2
+	// two intervals over target scale up once, then max stops it
+	for _, p99 := range []time.Duration{20, 20, 20, 20} {
+		n += g.Decide(p99*time.Millisecond, 10, n)
+		fmt.Println("p99:", p99, "instances:", n)
+	}
+	if n != 3 {
+		t.Fail()
+	}
+	// no requests makes no decision, in between holds, well under target scales down to min
+	for _, p99 := range []time.Duration{0, 7, 1, 1, 1, 1} {
+		n += g.Decide(p99*time.Millisecond, int(p99), n)
+		fmt.Println("p99:", p99, "instances:", n)
+	}
+	if n != 2 {
+		t.Fail()
+	}
+}
+
+func TestDecideDepth(t *testing.T) {
+	g := &Group{Service: "test", Queue: "jobs", Depth: 20, Interval: time.Second, Min: 1, Max: 4, Up: 1, Down: 2}
+	n := 1
+	for _, depth := range []int64{50, 50, 15, 5, 5, 5} {
+		n += g.DecideDepth(depth, n)
+		fmt.Println("depth:", depth, "instances:", n)
+	}
+	if n != 2 {
+		t.Fail()
+	}
+}
+
+func TestDecideHealth(t *testing.T) {
+	g := &Group{Service: "test", Health: 0.5, Interval: time.Second, Min: 1, Max: 4, Up: 1, Down: 2}
+	n := 1
+	for _, score := range []float64{0.9, 0.7, 0.4, 0.1, 0.1, 0.1} {
+		n += g.DecideHealth(score, n)
+		fmt.Println("health:", score, "instances:", n)
+	}
+	if n != 2 {
+		t.Fail()
+	}
+}
+
+func TestDecideLoad(t *testing.T) {
+	g := &Group{Service: "test", RPS: 50, Interval: time.Second, Min: 1, Max: 4, Up: 1, Down: 2}
+	n := 1
+	// the rate is shared between the instances, so 120 requests a second is over target for two and under it for three
+	for _, requests := range []int{120, 120, 120, 40, 40} {
+		n += g.DecideRPS(requests, time.Second, n)
+		fmt.Println("requests:", requests, "instances:", n)
+	}
+	if n != 2 {
+		t.Error("rps ended with", n, "instances")
+	}
+	g = &Group{Service: "test", Concurrency: 2, Interval: time.Second, Min: 1, Max: 4, Up: 1, Down: 1, Cooldown: time.Hour}
+	n = 1
+	// the cooldown after the first scale up holds the group however far over target it goes
+	for _, inflight := range []float64{5, 5, 5, 0} {
+		n += g.DecideConcurrency(inflight, n)
+		fmt.Println("in flight:", inflight, "instances:", n)
+	}
+	if n != 2 {
+		t.Error("concurrency ended with", n, "instances during the cooldown")
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	g := &Group{Service: "test", RPS: 10, Interval: time.Second, Min: 1, Max: 4, Up: 1, Down: 1, Cooldown: 20 * time.Millisecond}
+	n := 1
+	// over target scales up once, then holds until the cooldown is over and scales up again
+	for _, requests := range []int{100, 100, 100} {
+		n += g.DecideRPS(requests, time.Second, n)
+		fmt.Println("requests:", requests, "instances:", n)
+	}
+	if n != 2 {
+		t.Error("scaled up to", n, "instances during the cooldown")
+	}
+	time.Sleep(25 * time.Millisecond)
+	if n += g.DecideRPS(100, time.Second, n); n != 3 {
+		t.Error("didn't scale up after the cooldown, at", n, "instances")
+	}
+	// the cooldown after a scale up holds off a scale down too
+	if n += g.DecideRPS(0, time.Second, n); n != 3 {
+		t.Error("scaled down to", n, "instances during the cooldown")
+	}
+	time.Sleep(25 * time.Millisecond)
+	if n += g.DecideRPS(0, time.Second, n); n != 2 {
+		t.Error("didn't scale down after the cooldown, at", n, "instances")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := &Throttle{Service: "test", Target: 0.7, Capacity: 10, Interval: 10 * time.Millisecond, step: maxStep}
+	latency := 20 * time.Millisecond
+	// by Little's law two instances have rate times latency in flight between them, so 14 in flight holds the target
+	for i := 0; i < 40; i++ {
+		inflight := float64(latency) / float64(th.Interval)
+		th.Adjust(inflight, 2)
+		fmt.Println("interval:", th.Interval, "utilization:", th.Utilization)
+	}
+	interval, settled := th.Sustained()
+	want := latency / 14
+	if !settled || interval < want*95/100 || interval > want*105/100 || th.Reversals > 4 {
+		t.Error("throttle found", interval, "settled", settled, "with", th.Reversals, "reversals, want about", want)
+	}
+	// nothing in flight speeds up, but no faster than the injector can go
+	th = &Throttle{Service: "test", Target: 0.5, Capacity: 10, Interval: 2 * time.Millisecond, step: maxStep}
+	for i := 0; i < 10; i++ {
+		th.Adjust(0, 1)
+	}
+	if th.Interval != MinInterval {
+		t.Error("throttle with nothing in flight ended at", th.Interval)
+	}
+}
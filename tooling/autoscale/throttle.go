@@ -0,0 +1,116 @@
+package autoscale
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// Limits of the time between the requests the injector sends, the denominator ignores chat rates outside them
+const (
+	MinInterval = time.Millisecond
+	MaxInterval = time.Hour
+)
+
+// Throttle is a closed loop controller for the rate the injector sends requests at, that holds a service at a target utilization
+type Throttle struct {
+	Service     string
+	Target      float64       // fraction of the concurrency of each instance to keep in flight
+	Capacity    float64       // requests in flight in an instance that count as fully utilized
+	Interval    time.Duration // between the requests sent by the injector
+	Utilization float64       // measured in the last interval
+	Found       time.Duration // the last interval that held the target for a few intervals in a row, or zero
+	Adjustments int
+	Reversals   int     // times the controller went from speeding up to slowing down or back
+	step        float64 // largest fraction the interval can change by at once, halved at each reversal
+	last        int     // direction of the last adjustment, +1 slower or -1 faster
+	steady      int     // consecutive intervals within the deadband of the target
+}
+
+const (
+	deadband = 0.05 // of the target, close enough to hold the rate
+	maxStep  = 0.5
+	minStep  = 0.02
+	settled  = 3 // intervals in the deadband in a row before the rate counts as found
+)
+
+// NewThrottle parses a service=utilization target such as subscriber=0.7, starting from the injector's interval. The capacity of
+// each instance is the concurrency of the service's health config, which defaults to 10 requests in flight
+func NewThrottle(spec string, interval time.Duration) *Throttle {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		log.Fatal("targetutil: bad target " + spec + ", should be service=utilization")
+	}
+	target, err := strconv.ParseFloat(kv[1], 64)
+	if err != nil || target <= 0 || target > 1 {
+		log.Fatal("targetutil: bad utilization for " + kv[0] + ": " + kv[1] + ", needs to be more than 0 and at most 1")
+	}
+	t := &Throttle{Service: kv[0], Target: target, Capacity: 10, Interval: interval, step: maxStep}
+	if h := archaius.Service(kv[0]).Health; h != nil && h.Concurrency > 0 {
+		t.Capacity = float64(h.Concurrency)
+	}
+	log.Printf("targetutil: %v target utilization %v of %v in flight per instance, starting every %v\n", t.Service, t.Target, t.Capacity, t.Interval)
+	return t
+}
+
+// Adjust the interval between requests given the mean requests in flight across the instances of the service in the last interval.
+// Utilization goes up in proportion to the rate, so the interval is scaled by how far it is from the target, but only by up to the
+// step at a time, and the step is halved each time the direction reverses, so the rate closes in on the target without oscillating.
+// Nothing in flight at all speeds up by the whole step
+func (t *Throttle) Adjust(inflight float64, instances int) time.Duration {
+	if instances <= 0 {
+		return t.Interval // nothing to measure
+	}
+	t.Utilization = inflight / float64(instances) / t.Capacity
+	ratio := t.Utilization / t.Target
+	if math.Abs(ratio-1) <= deadband {
+		if t.steady++; t.steady >= settled && t.Found != t.Interval {
+			t.Found = t.Interval
+			log.Printf("targetutil: %v held at utilization %.3f target %v every %v, %.1f requests per second\n", t.Service, t.Utilization, t.Target, t.Interval, t.PerSecond())
+		}
+		return t.Interval
+	}
+	t.steady = 0
+	dir := 1
+	if ratio < 1 {
+		dir = -1
+	}
+	if t.last != 0 && dir != t.last {
+		t.step = math.Max(minStep, t.step/2)
+		t.Reversals++
+	}
+	t.last = dir
+	f := math.Min(math.Max(ratio, 1/(1+t.step)), 1+t.step)
+	i := time.Duration(float64(t.Interval) * f)
+	if i < MinInterval {
+		i = MinInterval
+	}
+	if i > MaxInterval {
+		i = MaxInterval
+	}
+	if archaius.Conf.Msglog {
+		log.Printf("targetutil: %v utilization %.3f target %v, interval %v to %v\n", t.Service, t.Utilization, t.Target, t.Interval, i)
+	}
+	if i != t.Interval {
+		t.Adjustments++
+	}
+	t.Interval = i
+	return i
+}
+
+// Sustained is the interval the controller found that holds the target, or the one it ended up at if it never settled
+func (t *Throttle) Sustained() (interval time.Duration, settled bool) {
+	if t.Found > 0 {
+		return t.Found, true
+	}
+	return t.Interval, false
+}
+
+// PerSecond is the rate the injector is sending requests at
+func (t *Throttle) PerSecond() float64 {
+	return float64(time.Second) / float64(t.Interval)
+}
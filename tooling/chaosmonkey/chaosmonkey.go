@@ -2,21 +2,256 @@
 package chaosmonkey
 
 import (
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
 	"github.com/adrianco/spigo/tooling/names"
 	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
 	"time"
 )
 
-// Delete a single node from the given service
+// Config schedules a chaos monkey that terminates random instances while the architecture runs
+type Config struct {
+	// Interval between rampages, e.g. 2s, it can be left out if there are only events
+	Interval string `json:"interval,omitempty"`
+
+	// Probability that each service loses instances in an interval, default 1
+	Probability float64 `json:"probability,omitempty"`
+
+	// Max instances terminated in each service per interval, the blast radius, default 1
+	Max int `json:"max,omitempty"`
+
+	// Services to pick victims from, default every service that runs in zones
+	Services []string `json:"services,omitempty"`
+
+	// ColdStart delay before an autoscaled service replaces a terminated instance, default 1s
+	ColdStart string `json:"coldstart,omitempty"`
+
+	// Events that happen once, at a time after the architecture starts running
+	Events []Event `json:"events,omitempty"`
+}
+
+// Event is a failure at a point in the run, kill terminates instances of a Service, latency adds Latency to the calls to
+// instances of a Service for a Duration, partition cuts a Region off from the other regions for a Duration, and evacuate
+// moves the external traffic out of a Region to the nearest other regions for a Duration, e.g.
+// {"service":"priamCassandra","action":"kill","count":3,"at":"5s"}
+type Event struct {
+	Action   string  `json:"action"`
+	At       string  `json:"at"`
+	Service  string  `json:"service,omitempty"`
+	Count    int     `json:"count,omitempty"`   // instances hit, default 1 for kill and every instance for latency
+	Percent  float64 `json:"percent,omitempty"` // of the running instances, instead of a count
+	Latency  string  `json:"latency,omitempty"`
+	Region   string  `json:"region,omitempty"`
+	Duration string  `json:"duration,omitempty"` // default until the end of the run
+}
+
+// Actions an event can take
+var Actions = []string{"kill", "latency", "partition", "evacuate"}
+
+var config *Config
+
+// picker chooses the victims of the rampages and correlated events, seeded by -seed or the seed keyval, or 1 if neither is set,
+// so a run picks the same instances each time. It's only used by asgard, from one goroutine
+var picker *rand.Rand
+
+// Schedule sets up the chaos monkey, nil turns it off
+func Schedule(c *Config) {
+	config = c
+	picker = rand.New(rand.NewSource(archaius.Seed()))
+}
+
+// Interval between rampages, zero if the chaos monkey isn't scheduled
+func Interval() time.Duration {
+	if config == nil {
+		return 0
+	}
+	i, _ := time.ParseDuration(config.Interval)
+	return i
+}
+
+// Events is the schedule of one off chaos events
+func Events() []Event {
+	if config == nil {
+		return nil
+	}
+	return config.Events
+}
+
+// ColdStart is the delay before a terminated instance is replaced
+func ColdStart() time.Duration {
+	if config == nil || config.ColdStart == "" {
+		return time.Second
+	}
+	c, _ := time.ParseDuration(config.ColdStart)
+	return c
+}
+
+// terminate a node the same way as a shutdown, recorded in the flows as a call from chaosmonkey
+func terminate(node string, ch chan gotocol.Message) {
+	msg := gotocol.Message{gotocol.Goodbye, nil, clock.Now(), gotocol.NewTrace(), "chaosmonkey"}
+	flow.AnnotateSend(msg, "chaosmonkey")
+	msg.GoSend(ch)
+	log.Println("chaosmonkey delete: " + node)
+	collect.Mark("killed", node)
+	collect.InFlight(node, 0) // its requests go with it
+}
+
+// Delete a single node from the given service, the first by name so it's the same one each run
 func Delete(noodles *map[string]chan gotocol.Message, service string) {
-	if service != "" {
-		for node, ch := range *noodles {
-			if names.Service(node) == service {
-				gotocol.Message{gotocol.Goodbye, nil, time.Now(), gotocol.NewTrace(), "chaosmonkey"}.GoSend(ch)
-				log.Println("chaosmonkey delete: " + node)
-				return
-			}
+	victim := ""
+	for node := range *noodles {
+		if service != "" && names.Service(node) == service && (victim == "" || node < victim) {
+			victim = node
+		}
+	}
+	if victim != "" {
+		terminate(victim, (*noodles)[victim])
+	}
+}
+
+// Rampage terminates up to Max random instances in each service, skipping nodes that are already gone,
+// and returns the names of the nodes it terminated. The last running instance of a service is always left alone. Services and
+// instances are taken in name order so the same seed picks the same victims
+func Rampage(noodles map[string]chan gotocol.Message, gone map[string]bool) []string {
+	if config == nil {
+		return nil
+	}
+	pick := make(map[string]bool)
+	for _, s := range config.Services {
+		pick[s] = true
+	}
+	running := make(map[string][]string) // instances by service
+	for node := range noodles {
+		if gone[node] || names.Zone(node) == "*" { // cross zone services like elb and denominator aren't instances
+			continue
+		}
+		s := names.Service(node)
+		if len(pick) == 0 || pick[s] {
+			running[s] = append(running[s], node)
+		}
+	}
+	probability, max := config.Probability, config.Max
+	if probability == 0 {
+		probability = 1
+	}
+	if max == 0 {
+		max = 1
+	}
+	var services []string
+	for s := range running {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	var victims []string
+	for _, s := range services {
+		nodes := running[s]
+		sort.Strings(nodes)
+		if picker.Float64() >= probability {
+			continue
+		}
+		for n := 0; n < max && len(nodes) > 1; n++ {
+			i := picker.Intn(len(nodes))
+			terminate(nodes[i], noodles[nodes[i]])
+			gone[nodes[i]] = true
+			victims = append(victims, nodes[i])
+			nodes = append(nodes[:i], nodes[i+1:]...)
+		}
+	}
+	return victims
+}
+
+// Hit picks the running instances of the service that an event hits, Count or Percent of them at random, and returns their
+// names. A kill event terminates them, latency events are slowed down by the caller
+func Hit(noodles map[string]chan gotocol.Message, gone map[string]bool, e Event) []string {
+	var nodes []string
+	for node := range noodles {
+		if !gone[node] && names.Service(node) == e.Service {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes) // so the same seed picks the same victims
+	n := e.Count
+	if e.Percent > 0 {
+		n = int(math.Ceil(e.Percent / 100 * float64(len(nodes))))
+	}
+	if n == 0 && e.Action == "kill" {
+		n = 1
+	}
+	if n == 0 || n > len(nodes) {
+		n = len(nodes)
+	}
+	var victims []string
+	for _, i := range picker.Perm(len(nodes))[:n] {
+		victims = append(victims, nodes[i])
+	}
+	sort.Strings(victims)
+	if e.Action == "kill" {
+		for _, v := range victims {
+			terminate(v, noodles[v])
+			gone[v] = true
+		}
+	}
+	log.Printf("chaosmonkey %v at %v: %v of %v %v instances\n", e.Action, e.At, len(victims), len(nodes), e.Service)
+	return victims
+}
+
+// Outage terminates every instance in a zone, in one region or in all of them if region is empty, like a chaos gorilla,
+// and returns the names of the nodes it terminated in name order, so the terminations are logged the same way each run.
+// Cross zone services like elb keep running
+func Outage(noodles map[string]chan gotocol.Message, gone map[string]bool, region, zone string) []string {
+	var victims []string
+	for node := range noodles {
+		if gone[node] || names.Zone(node) != zone || (region != "" && names.Region(node) != region) {
+			continue
+		}
+		victims = append(victims, node)
+	}
+	sort.Strings(victims)
+	for _, node := range victims {
+		terminate(node, noodles[node])
+		gone[node] = true
+	}
+	log.Printf("chaosmonkey zone outage: %v %v terminated %v instances\n", region, zone, len(victims))
+	collect.Mark("zoneoutage", strings.TrimPrefix(region+" "+zone, " "))
+	return victims
+}
+
+// Correlate picks the fraction of the running instances of each service that a correlated event hits, at least one of each, and
+// returns their names. Nothing is terminated, the instances are slowed down together
+func Correlate(noodles map[string]chan gotocol.Message, gone map[string]bool, services []string, fraction float64) []string {
+	if fraction <= 0 {
+		fraction = 1
+	}
+	pick := make(map[string]bool)
+	for _, s := range services {
+		pick[s] = true
+	}
+	running := make(map[string][]string) // instances by service
+	for node := range noodles {
+		if !gone[node] && pick[names.Service(node)] {
+			running[names.Service(node)] = append(running[names.Service(node)], node)
+		}
+	}
+	var ss []string
+	for s := range running {
+		ss = append(ss, s)
+	}
+	sort.Strings(ss)
+	var victims []string
+	for _, s := range ss {
+		nodes := running[s]
+		sort.Strings(nodes)
+		n := int(math.Ceil(fraction * float64(len(nodes))))
+		for _, i := range picker.Perm(len(nodes))[:n] {
+			victims = append(victims, nodes[i])
 		}
 	}
+	return victims
 }
@@ -0,0 +1,98 @@
+package chaosmonkey
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// instances makes a fake channel for each instance of the services, spread over two zones
+func instances(counts map[string]int) map[string]chan gotocol.Message {
+	noodles := make(map[string]chan gotocol.Message)
+	for s, n := range counts {
+		for i := 0; i < n; i++ {
+			noodles[names.Make("test", "us-east-1", []string{"zoneA", "zoneB"}[i%2], s, "store", i)] = make(chan gotocol.Message, 1)
+		}
+	}
+	return noodles
+}
+
+// byService counts the victims of each service
+func byService(victims []string) map[string]int {
+	n := make(map[string]int)
+	for _, v := range victims {
+		n[names.Service(v)]++
+	}
+	return n
+}
+
+// TestVictims checks a rampage keeps to the blast radius and leaves the last running instance of a service alone, an event's
+// Percent rounds up to a whole instance, and only kills terminate their victims
+func TestVictims(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config Config
+		event  *Event // hit by an event instead of a rampage
+		counts map[string]int
+		want   map[string]int
+	}{
+		{"blast radius", Config{Max: 1}, nil, map[string]int{"a": 3, "b": 2}, map[string]int{"a": 1, "b": 1}},
+		{"last instance left alone", Config{Max: 5}, nil, map[string]int{"a": 3, "b": 1}, map[string]int{"a": 2}},
+		{"only the services picked", Config{Max: 1, Services: []string{"b"}}, nil, map[string]int{"a": 3, "b": 2}, map[string]int{"b": 1}},
+		{"percent rounds up", Config{}, &Event{Action: "kill", Service: "a", Percent: 30}, map[string]int{"a": 5}, map[string]int{"a": 2}},
+		{"percent under one instance", Config{}, &Event{Action: "kill", Service: "a", Percent: 1}, map[string]int{"a": 5}, map[string]int{"a": 1}},
+		{"count over the running instances", Config{}, &Event{Action: "kill", Service: "a", Count: 9}, map[string]int{"a": 3, "b": 1}, map[string]int{"a": 3}},
+		{"latency hits every instance", Config{}, &Event{Action: "latency", Service: "b"}, map[string]int{"a": 3, "b": 2}, map[string]int{"b": 2}},
+	} {
+		Schedule(&c.config)
+		noodles, gone := instances(c.counts), make(map[string]bool)
+		var victims []string
+		if c.event != nil {
+			victims = Hit(noodles, gone, *c.event)
+		} else {
+			victims = Rampage(noodles, gone)
+		}
+		if got := byService(victims); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: victims %v, want %v", c.name, got, c.want)
+		}
+		for node := range gone {
+			select {
+			case m := <-noodles[node]:
+				if m.Imposition != gotocol.Goodbye {
+					t.Errorf("%v: %v got %v", c.name, node, m.Imposition)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%v: %v gone without a goodbye", c.name, node)
+			}
+		}
+		if c.event != nil && c.event.Action == "latency" && len(gone) != 0 {
+			t.Errorf("%v: latency event terminated %v", c.name, gone)
+		}
+	}
+}
+
+// TestSameVictims checks a fixed seed picks the same victims each time, and a zone outage terminates them in name order
+func TestSameVictims(t *testing.T) {
+	archaius.Conf.Seed = 7
+	defer func() { archaius.Conf.Seed = 0 }()
+	counts := map[string]int{"a": 6, "b": 6, "c": 6}
+	pick := func() []string {
+		Schedule(&Config{Max: 2})
+		return Rampage(instances(counts), make(map[string]bool))
+	}
+	first := pick()
+	for i := 0; i < 5; i++ {
+		if again := pick(); !reflect.DeepEqual(first, again) {
+			t.Fatalf("picked %v then %v with the same seed", first, again)
+		}
+	}
+	victims := Outage(instances(counts), make(map[string]bool), "us-east-1", "zoneA")
+	if len(victims) != 9 || !sort.StringsAreSorted(victims) {
+		t.Errorf("outage terminated %v", victims)
+	}
+}
@@ -0,0 +1,89 @@
+// Package checkpoint saves the instance set of a running simulation and the metrics so far, so a long run can be resumed later
+package checkpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/adrianco/spigo/actors/edda"
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/graphjson"
+)
+
+// CheckpointV0r0 is the file format, the graph uses the same elements as graphjson.
+// Messages that are in flight when the checkpoint is taken, and the histograms behind the summary, are not saved
+type CheckpointV0r0 struct {
+	Arch    string                  `json:"arch"`
+	Version string                  `json:"version"`
+	Date    string                  `json:"date"`
+	Elapsed string                  `json:"elapsed"` // simulated time so far, including any earlier runs it was resumed from
+	Graph   []graphjson.ElementV0r4 `json:"graph"`
+	Summary json.RawMessage         `json:"summary"`
+}
+
+// resumed is the elapsed time of the checkpoint this run was resumed from
+var resumed time.Duration
+
+// Interval between checkpoints, zero if -checkpoint isn't set
+func Interval() time.Duration {
+	i, _ := time.ParseDuration(archaius.Conf.Checkpoint)
+	return i
+}
+
+// Write saves a checkpoint to json_metrics/<arch>_checkpoint.json, given the time this run has been going.
+// It's written to a temporary file and renamed, so an interruption part way through leaves the last checkpoint intact
+func Write(running time.Duration) {
+	c := CheckpointV0r0{
+		Arch:    archaius.Conf.Arch,
+		Version: "spigo-checkpoint-0.1",
+		Date:    time.Now().Format(time.RFC3339Nano),
+		Elapsed: (resumed + running).String(),
+		Graph:   edda.Graph(),
+		Summary: collect.SummarySoFar(),
+	}
+	j, err := json.Marshal(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fn := "json_metrics/" + archaius.Conf.Arch + "_checkpoint.json"
+	if err := ioutil.WriteFile(fn+".tmp", j, 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(fn+".tmp", fn); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("checkpoint: %v nodes and edges after %v written to %v\n", len(c.Graph), c.Elapsed, fn)
+}
+
+// Read loads a checkpoint to resume from, and records where it left off in the summary of this run
+func Read(fn string) *CheckpointV0r0 {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := new(CheckpointV0r0)
+	if err := json.Unmarshal(data, c); err != nil {
+		log.Fatal(err)
+	}
+	resumed, err = time.ParseDuration(c.Elapsed)
+	if err != nil {
+		log.Fatal("Bad elapsed time in checkpoint: " + c.Elapsed)
+	}
+	log.Printf("Resuming %v from %v after %v\n", c.Arch, fn, c.Elapsed)
+	collect.Summarize("resumed", struct {
+		File    string          `json:"file"`
+		Date    string          `json:"date"`
+		Elapsed string          `json:"elapsed"`
+		Summary json.RawMessage `json:"summary"`
+	}{fn, c.Date, c.Elapsed, c.Summary})
+	return c
+}
+
+// Resumed is the simulated time already run before this resumed run started
+func Resumed() time.Duration {
+	return resumed
+}
@@ -0,0 +1,83 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/actors/edda"
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// log a graph of a web node calling two store nodes, one of them gone, through edda
+func logGraph() {
+	web := names.Make("test", "us-east-1", "zoneA", "web", "karyon", 0)
+	db0 := names.Make("test", "us-east-1", "zoneA", "db", "store", 0)
+	db1 := names.Make("test", "us-east-1", "zoneB", "db", "store", 1)
+	edda.Logchan = make(chan gotocol.Message, 10)
+	done := make(chan bool)
+	go func() {
+		edda.Start("edda")
+		close(done)
+	}()
+	for _, m := range []struct {
+		imp gotocol.Impositions
+		in  string
+	}{{gotocol.Put, web}, {gotocol.Put, db0}, {gotocol.Put, db1}, {gotocol.Inform, web + " " + db0}, {gotocol.Inform, web + " " + db1}, {gotocol.Delete, db1}} {
+		edda.Logchan <- gotocol.Message{m.imp, nil, time.Now(), gotocol.NewTrace(), m.in}
+	}
+	close(edda.Logchan)
+	<-done
+}
+
+// TestRoundTrip checks a checkpoint reads back with the graph and summary it was written with, and the elapsed time of a
+// resumed run carries on from where the checkpoint left off
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.Mkdir("json_metrics", 0755)
+	archaius.Conf.Arch = "test"
+	archaius.Conf.Checkpoint = "10m"
+	if i := Interval(); i != 10*time.Minute {
+		t.Errorf("interval %v", i)
+	}
+	logGraph()
+	graph := edda.Graph()
+	if len(graph) != 3 {
+		t.Fatalf("graph %+v, the deleted node and its edge should be gone", graph)
+	}
+	collect.Summarize("checkpointtest", map[string]int{"calls": 42})
+	Write(5 * time.Second)
+	if _, err := os.Stat("json_metrics/test_checkpoint.json.tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file left behind")
+	}
+	c := Read("json_metrics/test_checkpoint.json")
+	if c.Arch != "test" || c.Elapsed != "5s" || Resumed() != 5*time.Second {
+		t.Errorf("read %v after %v, resumed %v", c.Arch, c.Elapsed, Resumed())
+	}
+	if !reflect.DeepEqual(c.Graph, graph) {
+		t.Errorf("graph read back as %+v, written as %+v", c.Graph, graph)
+	}
+	var summary struct {
+		Test map[string]int `json:"checkpointtest"`
+	}
+	if err := json.Unmarshal(c.Summary, &summary); err != nil || summary.Test["calls"] != 42 {
+		t.Errorf("summary read back as %s, %v", c.Summary, err)
+	}
+	Write(3 * time.Second) // after running for 3s more
+	if c := Read("json_metrics/test_checkpoint.json"); c.Elapsed != "8s" {
+		t.Errorf("resumed checkpoint elapsed %v", c.Elapsed)
+	}
+}
@@ -0,0 +1,306 @@
+// Package clock tells the time in the simulation. It's the wall clock, or after Virtual a discrete event clock that jumps
+// to the next timer as soon as the actors have nothing left to do, so a run takes as long as the work in it rather than
+// its duration
+package clock
+
+import (
+	"container/heap"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Epoch is where a virtual clock starts, the same for every run so the times in the outputs repeat
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	virtual bool
+	stopped int32 // once the run is over
+	now     int64 // virtual time in unix nanoseconds
+	lock    sync.Mutex
+	events  eventHeap
+	seq     uint64
+	wake    = make(chan struct{}, 1)
+	waiting []chan struct{} // goroutines in Settle, let go before the next event fires
+)
+
+// sched is what the Go scheduler says the other goroutines are doing, the clock only moves when none of them can run
+var sched = []metrics.Sample{{Name: "/sched/goroutines/runnable:goroutines"}, {Name: "/sched/goroutines/not-in-go:goroutines"}}
+
+// event is a timer or ticker waiting for the virtual clock to get to it
+type event struct {
+	at     int64
+	seq    uint64 // timers due at the same time fire in the order they were set
+	period time.Duration
+	index  int // in the heap, -1 when it isn't waiting
+	fire   func(t time.Time)
+}
+
+type eventHeap []*event
+
+func (h eventHeap) Len() int { return len(h) }
+func (h eventHeap) Less(i, j int) bool {
+	return h[i].at < h[j].at || h[i].at == h[j].at && h[i].seq < h[j].seq
+}
+func (h eventHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *eventHeap) Push(x interface{}) {
+	e := x.(*event)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h, e.index = old[:len(old)-1], -1
+	return e
+}
+
+// Virtual switches to a virtual clock that starts at start, it has to be called before the clock is used and with GOMAXPROCS
+// set to 1, so the goroutine running the clock is the only one that can be running when it looks at the others
+func Virtual(start time.Time) {
+	virtual = true
+	now = start.UnixNano()
+	go run()
+}
+
+// IsVirtual is true if the clock is virtual
+func IsVirtual() bool {
+	return virtual
+}
+
+// Stop holds a virtual clock where it is, the timers still waiting never fire
+func Stop() {
+	atomic.StoreInt32(&stopped, 1)
+}
+
+// Now is the current time
+func Now() time.Time {
+	if !virtual {
+		return time.Now()
+	}
+	return time.Unix(0, atomic.LoadInt64(&now))
+}
+
+// Since is the time elapsed since t
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// Until is the time until t
+func Until(t time.Time) time.Duration {
+	return t.Sub(Now())
+}
+
+// Sleep pauses the goroutine for d
+func Sleep(d time.Duration) {
+	if !virtual {
+		time.Sleep(d)
+		return
+	}
+	if d > 0 {
+		<-After(d)
+	}
+}
+
+// Settle waits on a virtual clock until every other goroutine is blocked, so whatever the caller set off has run its course
+// the same way every run before it carries on. It returns straight away on the wall clock, or once a virtual clock has stopped
+func Settle() {
+	if !virtual {
+		return
+	}
+	c := make(chan struct{})
+	lock.Lock()
+	if atomic.LoadInt32(&stopped) == 1 {
+		lock.Unlock()
+		return
+	}
+	waiting = append(waiting, c)
+	lock.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	<-c
+}
+
+// After sends the time on the channel once d has passed
+func After(d time.Duration) <-chan time.Time {
+	return NewTimer(d).C
+}
+
+// Timer is a time.Timer on the clock, C is nil for one made with AfterFunc
+type Timer struct {
+	C <-chan time.Time
+	t *time.Timer
+	e *event
+}
+
+// NewTimer sends the time on its channel once d has passed
+func NewTimer(d time.Duration) *Timer {
+	if !virtual {
+		t := time.NewTimer(d)
+		return &Timer{C: t.C, t: t}
+	}
+	c := make(chan time.Time, 1)
+	t := &Timer{C: c, e: &event{index: -1, fire: func(t time.Time) {
+		select {
+		case c <- t:
+		default:
+		}
+	}}}
+	t.e.schedule(d)
+	return t
+}
+
+// AfterFunc calls f in its own goroutine once d has passed
+func AfterFunc(d time.Duration, f func()) *Timer {
+	if !virtual {
+		return &Timer{t: time.AfterFunc(d, f)}
+	}
+	t := &Timer{e: &event{index: -1, fire: func(time.Time) { go f() }}}
+	t.e.schedule(d)
+	return t
+}
+
+// Stop stops the timer, false if it had already fired or been stopped
+func (t *Timer) Stop() bool {
+	if t.t != nil {
+		return t.t.Stop()
+	}
+	return t.e.stop()
+}
+
+// Reset sets the timer to fire after d, true if it hadn't fired yet
+func (t *Timer) Reset(d time.Duration) bool {
+	if t.t != nil {
+		return t.t.Reset(d)
+	}
+	waiting := t.e.stop()
+	t.e.schedule(d)
+	return waiting
+}
+
+// Ticker is a time.Ticker on the clock, a tick is dropped if the last one is still waiting to be read
+type Ticker struct {
+	C <-chan time.Time
+	t *time.Ticker
+	e *event
+}
+
+// NewTicker sends the time on its channel every d
+func NewTicker(d time.Duration) *Ticker {
+	if !virtual {
+		t := time.NewTicker(d)
+		return &Ticker{C: t.C, t: t}
+	}
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	c := make(chan time.Time, 1)
+	t := &Ticker{C: c, e: &event{index: -1, period: d, fire: func(t time.Time) {
+		select {
+		case c <- t:
+		default:
+		}
+	}}}
+	t.e.schedule(d)
+	return t
+}
+
+// Stop stops the ticker
+func (t *Ticker) Stop() {
+	if t.t != nil {
+		t.t.Stop()
+		return
+	}
+	t.e.stop()
+}
+
+// schedule puts an event on the heap to fire once d has passed
+func (e *event) schedule(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	lock.Lock()
+	e.at = atomic.LoadInt64(&now) + int64(d)
+	e.seq = seq
+	seq++
+	heap.Push(&events, e)
+	lock.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop takes an event off the heap, false if it wasn't on it
+func (e *event) stop() bool {
+	lock.Lock()
+	defer lock.Unlock()
+	if e.index < 0 {
+		return false
+	}
+	heap.Remove(&events, e.index)
+	return true
+}
+
+// run moves the virtual clock on to the next event each time the actors settle and fires it, tickers go back on the heap for
+// their next tick. Events due at the same time fire one after another, each once the last has settled, so what they set off
+// doesn't interleave differently from one run to the next
+func run() {
+	for {
+		settle()
+		lock.Lock()
+		if len(waiting) > 0 { // a goroutine in Settle goes before the next event, one at a time
+			w := waiting[0]
+			waiting = waiting[1:]
+			lock.Unlock()
+			close(w)
+			continue
+		}
+		if atomic.LoadInt32(&stopped) == 1 {
+			lock.Unlock()
+			return
+		}
+		if len(events) == 0 {
+			lock.Unlock()
+			<-wake
+			continue
+		}
+		e := heap.Pop(&events).(*event)
+		at := e.at
+		atomic.StoreInt64(&now, at)
+		if e.period > 0 {
+			e.at, e.seq = at+int64(e.period), seq
+			seq++
+			heap.Push(&events, e)
+		}
+		lock.Unlock()
+		e.fire(time.Unix(0, at))
+	}
+}
+
+// settle gives the other goroutines turns until every one of them is blocked, waiting on a channel, a lock or a timer, and
+// none is ready to run or in a system call such as a write to a log or a file. Every actor woken by the last event has
+// finished with it by then, however long that took in real time
+func settle() {
+	for {
+		runtime.Gosched()
+		if idle() {
+			return
+		}
+	}
+}
+
+// idle is true if no other goroutine can run. With one P the scheduler's counts are exact while this goroutine has it, the
+// run queues only change when it lets something else run or a system call returns, and a goroutine in a system call is counted.
+// One waiting on a pipe or the network looks blocked, so a virtual run shouldn't wait on anything outside it
+func idle() bool {
+	metrics.Read(sched)
+	return sched[0].Value.Uint64() == 0 && sched[1].Value.Uint64() == 0
+}
@@ -0,0 +1,94 @@
+// Tests for the virtual clock
+package clock
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// the clock can only be switched to virtual once, so everything runs in one test
+func TestVirtual(t *testing.T) {
+	runtime.GOMAXPROCS(1)
+	Virtual(Epoch)
+	if !IsVirtual() || !Now().Equal(Epoch) {
+		t.Fatalf("virtual clock starts at %v, not %v", Now(), Epoch)
+	}
+	begin := time.Now()
+	Sleep(time.Hour)
+	if Since(Epoch) != time.Hour {
+		t.Errorf("slept for %v not an hour", Since(Epoch))
+	}
+	if time.Since(begin) > 10*time.Second {
+		t.Errorf("an hour of virtual time took %v", time.Since(begin))
+	}
+	// timers fire in time order, and in the order they were set for the same time
+	fired := make(chan string, 4)
+	start := Now()
+	AfterFunc(30*time.Millisecond, func() { fired <- "c" })
+	AfterFunc(10*time.Millisecond, func() { fired <- "a" })
+	AfterFunc(10*time.Millisecond, func() { fired <- "b" })
+	stopped := AfterFunc(20*time.Millisecond, func() { fired <- "stopped" })
+	if !stopped.Stop() {
+		t.Error("timer that hadn't fired didn't stop")
+	}
+	var order string
+	for i := 0; i < 3; i++ {
+		order += <-fired
+	}
+	if order != "abc" {
+		t.Errorf("timers fired in order %v", order)
+	}
+	if Since(start) != 30*time.Millisecond {
+		t.Errorf("last timer fired at %v", Since(start))
+	}
+	// a ticker ticks every interval until it's stopped
+	ticker := NewTicker(time.Second)
+	start = Now()
+	for i := 1; i <= 3; i++ {
+		tick := <-ticker.C
+		if tick.Sub(start) != time.Duration(i)*time.Second {
+			t.Errorf("tick %v at %v", i, tick.Sub(start))
+		}
+	}
+	ticker.Stop()
+	select {
+	case <-ticker.C:
+		t.Error("stopped ticker ticked")
+	case <-After(5 * time.Second):
+	}
+	// a reset timer fires after the new time
+	timer := NewTimer(time.Minute)
+	start = Now()
+	timer.Reset(time.Second)
+	<-timer.C
+	if Since(start) != time.Second {
+		t.Errorf("reset timer fired after %v", Since(start))
+	}
+	// settle waits for a chain of goroutines passing a message along to finish, without the clock moving
+	hops := make(chan int, 1)
+	var relay func(n int)
+	relay = func(n int) {
+		if n > 0 {
+			go relay(n - 1)
+			return
+		}
+		hops <- n
+	}
+	start = Now()
+	timer = NewTimer(time.Millisecond)
+	go relay(100)
+	Settle()
+	select {
+	case <-hops:
+	default:
+		t.Error("settled before the goroutines had finished")
+	}
+	if !Now().Equal(start) {
+		t.Errorf("the clock moved %v while settling", Since(start))
+	}
+	<-timer.C
+	// once the clock has stopped settle doesn't wait for it
+	Stop()
+	Settle()
+}
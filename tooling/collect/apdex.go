@@ -0,0 +1,88 @@
+package collect
+
+import (
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// apdex counts the responses of a service group against its target T, satisfied up to T and tolerating up to 4T, the rest
+// and the failures are frustrated
+type apdex struct {
+	target                time.Duration
+	satisfied, tolerating int
+}
+
+// ApdexScore is the satisfaction of the callers of a service group, from 0 when they're all frustrated to 1 when they're all
+// satisfied, counting the tolerating ones as half
+type ApdexScore struct {
+	Target     string  `json:"target,omitempty"`
+	Satisfied  int     `json:"satisfied"`
+	Tolerating int     `json:"tolerating"`
+	Frustrated int     `json:"frustrated"`
+	Score      float64 `json:"score"`
+}
+
+// ApdexSummary is the score of each service group with a target, and of all their responses together
+type ApdexSummary struct {
+	Overall  ApdexScore            `json:"overall"`
+	Services map[string]ApdexScore `json:"services"`
+}
+
+// apdexTarget of a service group from its config, or -kv apdex for all of them, zero if it isn't scored
+func apdexTarget(service string) time.Duration {
+	a := archaius.Service(service).Apdex
+	if a == "" {
+		a = archaius.Key(archaius.Conf, "apdex")
+	}
+	t, err := time.ParseDuration(a)
+	if err != nil || t < 0 {
+		return 0
+	}
+	return t
+}
+
+func (a *apdex) observe(d time.Duration, failed bool) {
+	if a.target <= 0 || failed {
+		return
+	}
+	if d <= a.target {
+		a.satisfied++
+	} else if d <= 4*a.target {
+		a.tolerating++
+	}
+}
+
+// score of the count responses observed
+func (a apdex) score(count int) ApdexScore {
+	s := ApdexScore{Target: a.target.String(), Satisfied: a.satisfied, Tolerating: a.tolerating, Frustrated: count - a.satisfied - a.tolerating}
+	if count > 0 {
+		s.Score = (float64(a.satisfied) + float64(a.tolerating)/2) / float64(count)
+	}
+	return s
+}
+
+// summarizeApdex adds the scores of the service groups with a target to the summary, the caller holds windowLock
+func summarizeApdex() {
+	var sum ApdexSummary
+	var all apdex
+	count := 0
+	for s, t := range totals {
+		if t.apdex.target <= 0 {
+			continue
+		}
+		if sum.Services == nil {
+			sum.Services = make(map[string]ApdexScore)
+		}
+		sum.Services[s] = t.apdex.score(t.count)
+		all.satisfied += t.apdex.satisfied
+		all.tolerating += t.apdex.tolerating
+		count += t.count
+	}
+	if sum.Services == nil {
+		return
+	}
+	sum.Overall = all.score(count)
+	sum.Overall.Target = "" // each service has its own
+	summary["apdex"] = sum
+}
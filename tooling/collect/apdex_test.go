@@ -0,0 +1,29 @@
+package collect
+
+import (
+	"testing"
+	"time"
+)
+
+// TestApdex checks the responses are counted against the target, with failures frustrated however fast they were
+func TestApdex(t *testing.T) {
+	a := apdex{target: 100 * time.Millisecond}
+	count := 0
+	for _, r := range []struct {
+		ms     int
+		failed bool
+	}{{50, false}, {100, false}, {20, false}, {80, false}, {60, false}, {10, false}, {300, false}, {400, false}, {401, false}, {10, true}} {
+		a.observe(time.Duration(r.ms)*time.Millisecond, r.failed)
+		count++
+	}
+	s := a.score(count)
+	if s.Satisfied != 6 || s.Tolerating != 2 || s.Frustrated != 2 || s.Target != "100ms" {
+		t.Errorf("counted %+v", s)
+	}
+	if s.Score != 0.7 {
+		t.Errorf("score is %v, not 0.7", s.Score)
+	}
+	if s := (apdex{target: time.Second}).score(0); s.Score != 0 || s.Frustrated != 0 {
+		t.Errorf("no responses scored %+v", s)
+	}
+}
@@ -0,0 +1,91 @@
+package collect
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// AvailabilityScore is the share of the requests to a service group that succeeded, against its SLA if it has one
+type AvailabilityScore struct {
+	SLA          float64 `json:"sla,omitempty"` // target percentage
+	Requests     int     `json:"requests"`
+	Failures     int     `json:"failures"`
+	Availability float64 `json:"availability"` // percentage that succeeded
+	Missed       bool    `json:"missed,omitempty"`
+}
+
+// AvailabilitySummary is the availability of each service group, and end to end as the clients saw each entry point
+type AvailabilitySummary struct {
+	Services    map[string]AvailabilityScore `json:"services"`
+	Entrypoints map[string]AvailabilityScore `json:"entrypoints,omitempty"`
+	Missed      []string                     `json:"missed"` // services that missed their SLA, as entrypoint:service if it was end to end
+}
+
+// entry point requests from the clients and the ones that failed or were never answered, by service
+var entries = make(map[string]*tally)
+
+// MeasureEntry counts a request a client sent to an entry point, and whether it failed, if collect is enabled
+func MeasureEntry(service string, failed bool) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	e := entries[service]
+	if e == nil {
+		e = &tally{}
+		entries[service] = e
+	}
+	e.count++
+	if failed {
+		e.failures++
+	}
+}
+
+// slaTarget of a service group from its config, or -kv sla for all of them, zero if it doesn't have one
+func slaTarget(service string) float64 {
+	if sla := archaius.Service(service).SLA; sla > 0 {
+		return sla
+	}
+	sla, err := strconv.ParseFloat(archaius.Key(archaius.Conf, "sla"), 64)
+	if err != nil || sla <= 0 || sla > 100 {
+		return 0
+	}
+	return sla
+}
+
+// availability of the requests against the sla, counting a service with no requests as available
+func availability(sla float64, requests, failures int) AvailabilityScore {
+	a := AvailabilityScore{SLA: sla, Requests: requests, Failures: failures, Availability: 100}
+	if requests > 0 {
+		a.Availability = 100 * float64(requests-failures) / float64(requests)
+	}
+	a.Missed = sla > 0 && a.Availability < sla
+	return a
+}
+
+// summarizeAvailability adds the availability of every service group and entry point to the summary, the caller holds windowLock
+func summarizeAvailability() {
+	sum := AvailabilitySummary{Services: make(map[string]AvailabilityScore, len(totals)), Missed: []string{}}
+	for s, t := range totals {
+		a := availability(slaTarget(s), t.count, t.failures)
+		sum.Services[s] = a
+		if a.Missed {
+			sum.Missed = append(sum.Missed, s)
+		}
+	}
+	for s, e := range entries {
+		if sum.Entrypoints == nil {
+			sum.Entrypoints = make(map[string]AvailabilityScore, len(entries))
+		}
+		a := availability(slaTarget(s), e.count, e.failures)
+		sum.Entrypoints[s] = a
+		if a.Missed {
+			sum.Missed = append(sum.Missed, "entrypoint:"+s)
+		}
+	}
+	sort.Strings(sum.Missed)
+	summary["availability"] = sum
+}
@@ -0,0 +1,19 @@
+package collect
+
+import "testing"
+
+// TestAvailability checks the percentage of successful requests is compared with the SLA, and that there's no SLA to miss without one
+func TestAvailability(t *testing.T) {
+	if a := availability(99.9, 2000, 1); a.Availability != 99.95 || a.Missed {
+		t.Errorf("one failure in 2000 scored %+v", a)
+	}
+	if a := availability(99.9, 1000, 2); a.Availability != 99.8 || !a.Missed {
+		t.Errorf("two failures in 1000 scored %+v", a)
+	}
+	if a := availability(0, 10, 5); a.Availability != 50 || a.Missed {
+		t.Errorf("no sla scored %+v", a)
+	}
+	if a := availability(99, 0, 0); a.Availability != 100 || a.Missed {
+		t.Errorf("no requests scored %+v", a)
+	}
+}
@@ -0,0 +1,82 @@
+package collect
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WriteBundle packages everything needed to understand and rerun an experiment in a zip file, the manifest of the run as
+// manifest.json, the effective config as conf.json, and every artifact in the manifest at its path, including the -config
+// file. The others are passed on to the manifest, and should include the architecture definition so it's always in the bundle
+func WriteBundle(fn, config string, others map[string]string) {
+	read := map[string]string{config: "config the run was started with"} // read by the run, so it isn't found with what it wrote
+	for path, description := range others {
+		read[path] = description
+	}
+	m := manifest(fn, config, read)
+	log.Printf("Writing a bundle of %v artifacts with the manifest and config to %v\n", len(m.Artifacts), fn)
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	z := zip.NewWriter(f)
+	add := func(name string, v interface{}) {
+		h := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		h.SetModTime(time.Now())
+		w, err := z.CreateHeader(h)
+		if err != nil {
+			log.Fatal(err)
+		}
+		j, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		w.Write(append(j, '\n'))
+	}
+	add("manifest.json", m)
+	add("conf.json", m.Conf)
+	for _, a := range m.Artifacts {
+		if err := bundleFile(z, a.Path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// bundleFile copies a file into the zip at its path, or just its name if it's outside the working directory, keeping its
+// modification time
+func bundleFile(z *zip.Writer, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	h, err := zip.FileInfoHeader(st)
+	if err != nil {
+		return err
+	}
+	h.Name = filepath.ToSlash(filepath.Clean(path))
+	if filepath.IsAbs(path) || strings.HasPrefix(h.Name, "../") {
+		h.Name = filepath.Base(path)
+	}
+	h.Method = zip.Deflate
+	w, err := z.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
@@ -2,7 +2,6 @@
 package collect
 
 import (
-	"fmt"
 	. "github.com/adrianco/goguesstimate/guesstimate"
 	"github.com/adrianco/spigo/tooling/archaius"
 	"github.com/adrianco/spigo/tooling/names"
@@ -18,6 +17,7 @@ import (
 	"io/ioutil"
 	"strings"
 	"encoding/json"
+	"sort"
 )
 
 const (
@@ -72,21 +72,17 @@ func Measure(h *generic.Histogram, d time.Duration) {
 		s := sampleMap[h]
 		if s != nil && len(s) < sampleCount {
 			sampleMap[h] = append(s, int64(d))
-			sampleLock.Unlock()
 		}
+		sampleLock.Unlock()
 	}
 }
 
-// SaveHist passes in name because metrics.Histogram blocks expvar.Histogram.Name()
+// SaveHist passes in name because metrics.Histogram blocks expvar.Histogram.Name(), it's written to the -metrics sink
 func SaveHist(h *generic.Histogram, name, suffix string) {
 	if archaius.Conf.Collect {
-		file, err := os.Create("csv_metrics/" + names.Arch(name) + "_" + names.Instance(name) + suffix + ".csv")
-		if err != nil {
-			log.Fatal("Save histogram %v: %v\n", name, err)
+		if err := Sink().Histogram(name, suffix, h); err != nil {
+			log.Fatalf("Save histogram %v: %v\n", name, err)
 		}
-		//metrics.PrintDistribution(file, h)
-		h.Print(file)
-		file.Close()
 	}
 }
 
@@ -124,7 +120,13 @@ func SaveAllGuesses(name string) {
 	row := 1
 	col := 1
 	seq := []string{"", "A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M", "N", "O", "P", "Q", "R", "S", "T", "U", "V", "W", "X", "Y", "Z"}
-	for h, data := range sampleMap {
+	hists := make([]*generic.Histogram, 0, len(sampleMap)) // in name order, so the same run lays out the same guesses
+	for h := range sampleMap {
+		hists = append(hists, h)
+	}
+	sort.Slice(hists, func(i, j int) bool { return hists[i].Name < hists[j].Name })
+	for _, h := range hists {
+		data := sampleMap[h]
 
 		UseCustomGuesstimate := false
 		GuesstimateType := "DATA"
@@ -181,14 +183,15 @@ func Save() {
 	//	}
 }
 
-// Serve on a port
-func Serve(port int) {
-	sock, err := net.Listen("tcp", fmt.Sprintf("localhost:%v", port))
+// Serve on an address such as localhost:8123, the expvars and the service metrics for Prometheus
+func Serve(addr string) {
+	http.HandleFunc("/metrics", metricsHandler)
+	sock, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatal(err)
 	}
 	go func() {
-		log.Printf("HTTP metrics now available at localhost:%v/debug/vars", port)
+		log.Printf("HTTP metrics now available at %v/debug/vars and %v/metrics", sock.Addr(), sock.Addr())
 		http.Serve(sock, nil)
 	}()
 }
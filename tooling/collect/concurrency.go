@@ -0,0 +1,84 @@
+package collect
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// concurrency of a service group, the requests in flight across its instances and their integral over time since the first one
+type concurrency struct {
+	current int
+	peak    int
+	area    float64 // request nanoseconds
+	first   time.Time
+	last    time.Time
+}
+
+var inflight = make(map[string]int) // by instance name
+var concurrencies = make(map[string]*concurrency)
+var concurrencyLock sync.Mutex
+
+// ConcurrencySummary is the time average and peak of the requests in flight across the instances of a service group
+type ConcurrencySummary struct {
+	Mean float64 `json:"mean"`
+	Peak int     `json:"peak"`
+}
+
+// InFlight records the number of requests an instance has accepted and not yet responded to, if collect is enabled, the
+// injector is holding a target utilization or its service group is being watched
+func InFlight(name string, n int) {
+	if !archaius.Conf.Collect && archaius.Conf.TargetUtil == "" && !watched(names.Service(name)) {
+		return
+	}
+	now := clock.Now()
+	concurrencyLock.Lock()
+	defer concurrencyLock.Unlock()
+	service := names.Service(name)
+	c := concurrencies[service]
+	if c == nil {
+		c = &concurrency{first: now, last: now}
+		concurrencies[service] = c
+	}
+	c.area += float64(c.current) * float64(now.Sub(c.last))
+	c.last = now
+	c.current += n - inflight[name]
+	inflight[name] = n
+	if c.current > c.peak {
+		c.peak = c.current
+	}
+}
+
+// InFlightArea is the integral over time of the requests in flight across the instances of a service group up to now, in request
+// nanoseconds, so the mean over an interval is the difference between two readings divided by its length
+func InFlightArea(service string) float64 {
+	concurrencyLock.Lock()
+	defer concurrencyLock.Unlock()
+	c := concurrencies[service]
+	if c == nil {
+		return 0
+	}
+	return c.area + float64(c.current)*float64(clock.Since(c.last))
+}
+
+// summarizeConcurrency adds the concurrency of each service group so far to the summary, the caller holds summaryLock
+func summarizeConcurrency() {
+	concurrencyLock.Lock()
+	defer concurrencyLock.Unlock()
+	if len(concurrencies) == 0 {
+		return
+	}
+	now := clock.Now()
+	services := make(map[string]ConcurrencySummary, len(concurrencies))
+	for s, c := range concurrencies {
+		cs := ConcurrencySummary{Peak: c.peak}
+		if d := now.Sub(c.first); d > 0 {
+			cs.Mean = (c.area + float64(c.current)*float64(now.Sub(c.last))) / float64(d)
+		}
+		services[s] = cs
+	}
+	summary["concurrency"] = services
+}
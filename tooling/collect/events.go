@@ -0,0 +1,114 @@
+package collect
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+)
+
+// TimelineEvent marks something that was done to the architecture while it ran, so latency changes can be explained later
+type TimelineEvent struct {
+	Time   string  `json:"timestamp"`
+	Offset float64 `json:"offsetms"` // since the timeline started
+	Kind   string  `json:"kind"`     // e.g. killed, scaleup, partition
+	Detail string  `json:"detail"`   // the instance, service or group it happened to
+	at     time.Time
+}
+
+var timeline []TimelineEvent
+
+const timelineMax = 10000 // most recent events kept when running forever
+var timelineStart = clock.Now()
+var timelineStarted bool // once the architecture is running
+var timelineLock sync.Mutex
+var watchers []func(TimelineEvent)
+
+// StartTimeline resets the timeline offsets to count from now, when the architecture starts running
+func StartTimeline() {
+	timelineLock.Lock()
+	timelineStart = clock.Now()
+	timelineStarted = true
+	timelineLock.Unlock()
+}
+
+// Elapsed is the time since the run started, that the timeline offsets are from
+func Elapsed() time.Duration {
+	timelineLock.Lock()
+	defer timelineLock.Unlock()
+	return clock.Since(timelineStart)
+}
+
+// WatchTimeline calls f with every event marked from now on, whether or not collect is enabled
+func WatchTimeline(f func(TimelineEvent)) {
+	timelineLock.Lock()
+	watchers = append(watchers, f)
+	timelineLock.Unlock()
+}
+
+// Mark adds an event to the timeline if collect is enabled, and passes it to the watchers
+func Mark(kind, detail string) {
+	timelineLock.Lock()
+	w := watchers
+	if !archaius.Conf.Collect && len(w) == 0 {
+		timelineLock.Unlock()
+		return
+	}
+	now := clock.Now()
+	offset := float64(now.Sub(timelineStart)) / float64(time.Millisecond)
+	e := TimelineEvent{now.Format(time.RFC3339Nano), offset, kind, detail, now}
+	if archaius.Conf.Collect {
+		timeline = append(timeline, e)
+		if archaius.Conf.Forever && len(timeline) > timelineMax {
+			timeline = append([]TimelineEvent(nil), timeline[len(timeline)-timelineMax:]...)
+		}
+	}
+	timelineLock.Unlock()
+	for _, f := range w {
+		f(e)
+	}
+}
+
+// Timeline returns a copy of the events so far, in the order they happened
+func Timeline() []TimelineEvent {
+	timelineLock.Lock()
+	defer timelineLock.Unlock()
+	t := make([]TimelineEvent, len(timeline))
+	copy(t, timeline)
+	return t
+}
+
+// At is when the event happened
+func (e TimelineEvent) At() time.Time {
+	return e.at
+}
+
+// WriteTimeline saves the events to json_metrics/<arch>_events.json, and counts them by kind in the summary
+func WriteTimeline() {
+	t := Timeline()
+	if !archaius.Conf.Collect || len(t) == 0 {
+		return
+	}
+	fn := "json_metrics/" + archaius.Conf.Arch + "_events.json"
+	file, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	log.Printf("Writing %v timeline events to %v\n", len(t), fn)
+	j, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	file.Write(j)
+	file.WriteString("\n")
+	kinds := make(map[string]int)
+	for _, e := range t {
+		kinds[e.Kind]++
+	}
+	Summarize("timeline", kinds)
+}
@@ -0,0 +1,45 @@
+package collect
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// gauges by instance name, also published at /debug/vars when the http server is started
+var gauges = make(map[string]int64)
+var gaugeLock sync.Mutex
+var gaugeVars = expvar.NewMap("gauges")
+
+// SetGauge records the current value of a gauge such as queue depth for an instance
+func SetGauge(name string, v int64) {
+	gaugeLock.Lock()
+	gauges[name] = v
+	gaugeLock.Unlock()
+	iv := new(expvar.Int)
+	iv.Set(v)
+	gaugeVars.Set(name, iv)
+}
+
+// DeleteGauge forgets the gauge for an instance that has gone away
+func DeleteGauge(name string) {
+	gaugeLock.Lock()
+	delete(gauges, name)
+	gaugeLock.Unlock()
+	iv := new(expvar.Int)
+	gaugeVars.Set(name, iv) // expvar maps can't remove entries, so zero it
+}
+
+// ServiceGauge adds up the gauges for all the instances of a service
+func ServiceGauge(service string) int64 {
+	gaugeLock.Lock()
+	defer gaugeLock.Unlock()
+	var t int64
+	for n, v := range gauges {
+		if names.Service(n) == service {
+			t += v
+		}
+	}
+	return t
+}
@@ -0,0 +1,259 @@
+package collect
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+)
+
+// HdrHistogram layout for response times in nanoseconds from 1ns to an hour, to three significant digits.
+// The encoding matches the V2 format of the Java and Go HdrHistogram libraries so their tools can read and merge the files
+const (
+	hdrHighest        = int64(time.Hour)
+	hdrDigits         = 3
+	hdrSubBucketBits  = 11 // 2048 sub buckets are enough for three digits
+	hdrSubBucketCount = 1 << hdrSubBucketBits
+	hdrSubBucketHalf  = hdrSubBucketCount / 2
+	hdrSubBucketMask  = int64(hdrSubBucketCount - 1)
+	hdrCookie         = 0x1c849303 | 0x10
+	hdrCompressed     = 0x1c849304 | 0x10
+	hdrTicks          = 5 // percentile reporting ticks per half distance, as HdrHistogram prints by default
+)
+
+// hdr is a high dynamic range histogram of response times
+type hdr struct {
+	counts   []int64
+	total    int64
+	min, max int64
+}
+
+func newHdr() *hdr {
+	buckets := 1
+	for smallest := int64(hdrSubBucketCount); smallest <= hdrHighest; smallest <<= 1 {
+		buckets++
+	}
+	return &hdr{counts: make([]int64, (buckets+1)*hdrSubBucketHalf), min: math.MaxInt64}
+}
+
+// hdrBucket is the power of two bucket for a value, each one covers twice the range of the last at half the resolution
+func hdrBucket(v int64) int {
+	bits := 0
+	for x := uint64(v | hdrSubBucketMask); x != 0; x >>= 1 {
+		bits++
+	}
+	return bits - hdrSubBucketBits
+}
+
+// hdrIndex is the counts index for a value
+func hdrIndex(v int64) int {
+	b := hdrBucket(v)
+	sub := int(v >> uint(b))
+	return (b+1)<<(hdrSubBucketBits-1) + sub - hdrSubBucketHalf
+}
+
+// hdrValue is the lowest value counted at an index
+func hdrValue(i int) int64 {
+	b := i>>(hdrSubBucketBits-1) - 1
+	sub := int64(i&(hdrSubBucketHalf-1) + hdrSubBucketHalf)
+	if b < 0 {
+		sub -= hdrSubBucketHalf
+		b = 0
+	}
+	return sub << uint(b)
+}
+
+// hdrRange is the number of values that are counted at the same index as v
+func hdrRange(v int64) int64 {
+	b := hdrBucket(v)
+	if v>>uint(b) >= hdrSubBucketCount {
+		b++
+	}
+	return 1 << uint(b)
+}
+
+func (h *hdr) record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	if v > hdrHighest {
+		v = hdrHighest
+	}
+	h.counts[hdrIndex(v)]++
+	h.total++
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// valueAt is the highest value equivalent to the one at a percentile, so a single measurement is reported as itself
+func (h *hdr) valueAt(percentile float64) (int64, int64) {
+	at := int64(math.Min(percentile, 100)/100*float64(h.total) + 0.5)
+	if at < 1 {
+		at = 1
+	}
+	var sum int64
+	for i, c := range h.counts {
+		sum += c
+		if sum >= at {
+			v := hdrValue(i)
+			if percentile == 0 {
+				return v, sum
+			}
+			return v + hdrRange(v) - 1, sum
+		}
+	}
+	return h.max, h.total
+}
+
+func (h *hdr) meanStdDev() (float64, float64) {
+	if h.total == 0 {
+		return 0, 0
+	}
+	var sum, squares float64
+	for i, c := range h.counts {
+		if c > 0 {
+			v := hdrValue(i)
+			mid := float64(v + hdrRange(v)/2)
+			sum += mid * float64(c)
+			squares += mid * mid * float64(c)
+		}
+	}
+	mean := sum / float64(h.total)
+	return mean, math.Sqrt(math.Max(squares/float64(h.total)-mean*mean, 0))
+}
+
+// writePercentiles writes the percentile distribution text that HdrHistogram prints and plotFiles draws, in milliseconds
+func (h *hdr) writePercentiles(w io.Writer) {
+	ms := func(v int64) float64 { return float64(v) / float64(time.Millisecond) }
+	fmt.Fprintf(w, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	for level := 0.0; ; {
+		v, count := h.valueAt(level)
+		if count >= h.total {
+			fmt.Fprintf(w, "%12.3f %2.12f %10d\n", ms(v), 1.0, count)
+			break
+		}
+		fmt.Fprintf(w, "%12.3f %2.12f %10d %14.2f\n", ms(v), level/100, count, 1/(1-level/100))
+		ticks := hdrTicks * math.Pow(2, math.Floor(math.Log2(100/(100-level)))+1)
+		level += 100 / ticks
+	}
+	mean, sd := h.meanStdDev()
+	fmt.Fprintf(w, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", mean/float64(time.Millisecond), sd/float64(time.Millisecond))
+	fmt.Fprintf(w, "#[Max     = %12.3f, Total count    = %12d]\n", ms(h.max), h.total)
+	fmt.Fprintf(w, "#[Buckets = %12d, SubBuckets     = %12d]\n", len(h.counts)/hdrSubBucketHalf-1, hdrSubBucketCount)
+}
+
+// encode the histogram in the compressed V2 format, the counts are zigzag varints with runs of zeros as negative lengths
+func (h *hdr) encode() []byte {
+	var payload bytes.Buffer
+	put := func(v int64) {
+		var b [binary.MaxVarintLen64]byte
+		payload.Write(b[:binary.PutVarint(b[:], v)])
+	}
+	if h.total > 0 {
+		last := hdrIndex(h.max)
+		for i := 0; i <= last; i++ {
+			if h.counts[i] != 0 {
+				put(h.counts[i])
+				continue
+			}
+			zeros := int64(1)
+			for i+1 <= last && h.counts[i+1] == 0 {
+				zeros++
+				i++
+			}
+			if zeros > 1 {
+				put(-zeros)
+			} else {
+				put(0)
+			}
+		}
+	}
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.BigEndian, struct {
+		Cookie, Length, Offset, Digits int32
+		Lowest, Highest                int64
+		Ratio                          float64
+	}{hdrCookie, int32(payload.Len()), 0, hdrDigits, 1, hdrHighest, 1})
+	raw.Write(payload.Bytes())
+	var deflated bytes.Buffer
+	z := zlib.NewWriter(&deflated)
+	z.Write(raw.Bytes())
+	z.Close()
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, []int32{hdrCompressed, int32(deflated.Len())})
+	out.Write(deflated.Bytes())
+	return out.Bytes()
+}
+
+var hdrs = make(map[string]*hdr) // by service, guarded by windowLock
+
+// measureHdr adds a response time to the HdrHistogram for a service, the caller holds windowLock
+func measureHdr(service string, d time.Duration) {
+	h := hdrs[service]
+	if h == nil {
+		h = newHdr()
+		hdrs[service] = h
+	}
+	h.record(d)
+}
+
+// WriteHdr saves the response times of each service over the whole run as an HdrHistogram percentile distribution
+// in csv_metrics/<arch>_<service>.hgrm for plotFiles, and all of them as tagged compressed histograms in a log
+// csv_metrics/<arch>.hlog that HdrHistogram's log processor can merge with other runs
+func WriteHdr() {
+	if !archaius.Conf.Collect || !archaius.Conf.Hdr {
+		return
+	}
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	if len(hdrs) == 0 {
+		return
+	}
+	var services []string
+	for s := range hdrs {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	prefix := "csv_metrics/" + archaius.Conf.Arch
+	log.Printf("Writing %v HdrHistograms to %v.hlog\n", len(services), prefix)
+	timelineLock.Lock()
+	start := timelineStart
+	timelineLock.Unlock()
+	hlog, err := os.Create(prefix + ".hlog")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer hlog.Close()
+	secs := func(t time.Time) float64 { return float64(t.UnixNano()) / float64(time.Second) }
+	fmt.Fprintf(hlog, "#[Logged with spigo %v]\n", archaius.Conf.Arch)
+	fmt.Fprintf(hlog, "#[Histogram log format version 1.3]\n")
+	fmt.Fprintf(hlog, "#[StartTime: %.3f (seconds since epoch), %v]\n", secs(start), start.Format(time.RFC1123))
+	fmt.Fprintf(hlog, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n")
+	length := clock.Since(start).Seconds()
+	for _, s := range services {
+		h := hdrs[s]
+		fmt.Fprintf(hlog, "Tag=%v,%.3f,%.3f,%.3f,%v\n", s, 0.0, length, float64(h.max)/float64(time.Millisecond),
+			base64.StdEncoding.EncodeToString(h.encode()))
+		file, err := os.Create(prefix + "_" + s + ".hgrm")
+		if err != nil {
+			log.Fatal(err)
+		}
+		h.writePercentiles(file)
+		file.Close()
+	}
+}
@@ -0,0 +1,65 @@
+package collect
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// TestHdr checks values land in buckets that hold them to three digits, and the encoding has the HdrHistogram V2 cookies
+func TestHdr(t *testing.T) {
+	for _, v := range []int64{0, 1, 1023, 2047, 2048, 4097, 123456789, int64(time.Hour)} {
+		low := hdrValue(hdrIndex(v))
+		if low > v || v >= low+hdrRange(v) {
+			t.Errorf("%v is counted at %v with range %v", v, low, hdrRange(v))
+		}
+		if v > 0 && float64(hdrRange(v))/float64(v) > 0.001 && v > 2047 {
+			t.Errorf("%v is only counted to within %v", v, hdrRange(v))
+		}
+	}
+	h := newHdr()
+	for i := 1; i <= 1000; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	if p50, _ := h.valueAt(50); p50/int64(time.Millisecond) != 500 {
+		t.Errorf("p50 is %v", time.Duration(p50))
+	}
+	if p99, _ := h.valueAt(99); p99/int64(time.Millisecond) != 990 {
+		t.Errorf("p99 is %v", time.Duration(p99))
+	}
+	enc := h.encode()
+	if cookie := binary.BigEndian.Uint32(enc); cookie != hdrCompressed {
+		t.Fatalf("compressed cookie is %x", cookie)
+	}
+	z, err := zlib.NewReader(bytes.NewReader(enc[8:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := binary.BigEndian.Uint32(raw); cookie != hdrCookie {
+		t.Errorf("cookie is %x", cookie)
+	}
+	if length := int(binary.BigEndian.Uint32(raw[4:])); length != len(raw)-40 {
+		t.Errorf("payload length is %v of %v", length, len(raw)-40)
+	}
+	// decode the counts back and check they add up
+	var total int64
+	for r := bytes.NewReader(raw[40:]); r.Len() > 0; {
+		c, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c > 0 {
+			total += c
+		}
+	}
+	if total != h.total {
+		t.Errorf("decoded %v of %v counts", total, h.total)
+	}
+}
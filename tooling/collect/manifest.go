@@ -0,0 +1,132 @@
+package collect
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// Artifact is a file a run produced
+type Artifact struct {
+	Path        string `json:"path"`
+	Format      string `json:"format"`
+	Size        int64  `json:"size"`
+	Description string `json:"description,omitempty"`
+}
+
+// Manifest lists the files a run produced, with the run it came from and the config it ran with
+type Manifest struct {
+	Run       archaius.RunInfo       `json:"run"`
+	Config    string                 `json:"config,omitempty"` // file the config was read from with -config
+	Conf      archaius.Configuration `json:"conf"`
+	Artifacts []Artifact             `json:"artifacts"`
+}
+
+// outputDirs are where the run writes its outputs, json_arch has architectures, configs and models saved by the run
+var outputDirs = []string{"json", "json_metrics", "csv_metrics", "traces", "gml", "gexf", "dot", "json_arch"}
+
+// artifactKinds describe the outputs by the directory they are in and the end of their name, the first match wins
+var artifactKinds = []struct {
+	dir, suffix, format, description string
+}{
+	{"json_metrics", "_summary.json", "json", "run metadata and summary sections"},
+	{"json_metrics", "_flow.json", "json", "Zipkin spans of the request flows"},
+	{"json_metrics", "_events.json", "json", "timeline of events such as chaos, deployments and outages"},
+	{"json_metrics", "_checkpoint.json", "json", "instances and summary saved part way through the run for -resume"},
+	{"json_metrics", "_critical.json", "json", "critical path of each trace and the latency each service contributed"},
+	{"json_metrics", "_flame.json", "json", "latency of the calls from each entry point as a tree"},
+	{"json_metrics", "_breakdown.json", "json", "latency each service contributed to the mean and p99 of each entry point"},
+	{"json_metrics", "_matrix.csv", "csv", "caller by callee service call counts"},
+	{"json_metrics", ".puml", "plantuml", "sequence diagram of a trace"},
+	{"json_metrics", ".json", "json", "Guesstimate model built from samples of the histograms"},
+	{"csv_metrics", "_timeseries.csv", "csv", "requests, failures and a latency heatmap of each service in each time bucket"},
+	{"csv_metrics", ".hlog", "hlog", "HdrHistogram log of the response times of each service"},
+	{"csv_metrics", ".hgrm", "hgrm", "HdrHistogram percentile distribution of a service"},
+	{"csv_metrics", ".csv", "csv", "histogram of a measurement of an instance"},
+	{"traces", "_chrome.json", "json", "flows as Chrome trace events"},
+	{"traces", "_flame.folded", "folded", "latency of the calls from each entry point as folded stacks"},
+	{"json", "_animate.json", "json", "instances starting and stopping and the calls between them, for animation"},
+	{"json", "_catalog-info.yaml", "yaml", "Backstage catalog entities for the services"},
+	{"json", "_risk.json", "json", "single points of failure and dependencies without a fallback"},
+	{"json", ".tf", "terraform", "skeleton of Terraform resources for the architecture"},
+	{"json", ".html", "html", "standalone page to explore the graph of the instances and their dependencies in a browser"},
+	{"json", ".json.gz", "json+gzip", "GraphJSON graph of the instances and their dependencies"},
+	{"json", ".json", "json", "GraphJSON graph of the instances and their dependencies"},
+	{"gml", ".graphml.gz", "graphml+gzip", "GraphML graph of the instances and their dependencies"},
+	{"gml", ".graphml", "graphml", "GraphML graph of the instances and their dependencies"},
+	{"gexf", ".gexf", "gexf", "GEXF graph of the instances and their dependencies"},
+	{"dot", ".dot", "dot", "GraphViz digraph of the instances and their dependencies"},
+	{"json_arch", "_conf.json", "json", "config saved with -saveconfig"},
+	{"json_arch", "_arch.pb", "protobuf", "architecture saved with -saveconfig"},
+	{"json_arch", "_model.json", "json", "architecture, regions, population and keyvals saved with -savemodel"},
+	{"json_arch", "_arch.json", "json", "architecture generated with -generate"},
+}
+
+// artifact describes a file in one of the output directories
+func artifact(path string, size int64) Artifact {
+	a := Artifact{Path: path, Size: size, Format: strings.TrimPrefix(filepath.Ext(path), ".")}
+	dir := filepath.Dir(path)
+	for _, k := range artifactKinds {
+		if dir == k.dir && strings.HasSuffix(path, k.suffix) {
+			a.Format, a.Description = k.format, k.description
+			break
+		}
+	}
+	return a
+}
+
+// WriteManifest lists every file in the output directories that was written since the run started, and the other files passed
+// in by path with what they are, such as profiles and the event log, with their format and size, to fn as json
+func WriteManifest(fn, config string, others map[string]string) {
+	m := manifest(fn, config, others)
+	log.Printf("Writing a manifest of %v artifacts to %v\n", len(m.Artifacts), fn)
+	j, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fn, append(j, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// manifest finds the artifacts of the run, leaving out fn, which is being written, and others that are already listed
+func manifest(fn, config string, others map[string]string) Manifest {
+	m := Manifest{Run: archaius.Run(), Config: config, Conf: archaius.Conf, Artifacts: []Artifact{}}
+	since := archaius.RunStarted()
+	listed := make(map[string]bool)
+	for _, dir := range outputDirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue // not used by this run
+		}
+		for _, f := range files {
+			path := filepath.Join(dir, f.Name())
+			if f.IsDir() || f.ModTime().Before(since) || path == filepath.Clean(fn) {
+				continue
+			}
+			m.Artifacts = append(m.Artifacts, artifact(path, f.Size()))
+			listed[path] = true
+		}
+	}
+	for path, description := range others {
+		if st, err := os.Stat(path); err == nil && !st.IsDir() && !listed[filepath.Clean(path)] {
+			listed[filepath.Clean(path)] = true
+			m.Artifacts = append(m.Artifacts, Artifact{path, strings.TrimPrefix(filepath.Ext(path), "."), st.Size(), description})
+		}
+	}
+	sort.Sort(byPath(m.Artifacts))
+	return m
+}
+
+// byPath sorts artifacts by path
+type byPath []Artifact
+
+func (a byPath) Len() int           { return len(a) }
+func (a byPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
@@ -0,0 +1,84 @@
+package collect
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// promHist is the response times of a service group in cumulative buckets with the same bounds as the time series heatmap
+type promHist struct {
+	bins []int // one more than tsBins, for anything slower
+	sum  time.Duration
+}
+
+var promHists = make(map[string]*promHist) // by service group, guarded by windowLock
+
+// measurePrometheus adds a response time to the histogram of a service group served on /metrics, the caller holds windowLock
+func measurePrometheus(service string, d time.Duration) {
+	ph := promHists[service]
+	if ph == nil {
+		ph = &promHist{bins: make([]int, len(tsBins)+1)}
+		promHists[service] = ph
+	}
+	ph.bins[sort.Search(len(tsBins), func(i int) bool { return d <= tsBins[i]*time.Millisecond })]++
+	ph.sum += d
+}
+
+// promEscape escapes a label value for the Prometheus text format
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// writePrometheus writes the requests, failures and response time histogram of every service group measured so far in the
+// Prometheus text exposition format, labelled with the arch and the service group name used in the summary
+func writePrometheus(w io.Writer) {
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	var services []string
+	for s := range totals {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	labels := func(s string) string {
+		return fmt.Sprintf(`arch="%v",service="%v"`, promEscape(archaius.Conf.Arch), promEscape(s))
+	}
+	fmt.Fprintln(w, "# HELP spigo_flow_requests_total Requests served by the service.")
+	fmt.Fprintln(w, "# TYPE spigo_flow_requests_total counter")
+	for _, s := range services {
+		fmt.Fprintf(w, "spigo_flow_requests_total{%v} %v\n", labels(s), totals[s].count)
+	}
+	fmt.Fprintln(w, "# HELP spigo_flow_failures_total Requests to the service that failed.")
+	fmt.Fprintln(w, "# TYPE spigo_flow_failures_total counter")
+	for _, s := range services {
+		fmt.Fprintf(w, "spigo_flow_failures_total{%v} %v\n", labels(s), totals[s].failures)
+	}
+	fmt.Fprintln(w, "# HELP spigo_flow_response_seconds Response time of the service.")
+	fmt.Fprintln(w, "# TYPE spigo_flow_response_seconds histogram")
+	for _, s := range services {
+		ph := promHists[s]
+		if ph == nil {
+			continue
+		}
+		n := 0
+		for i, b := range tsBins {
+			n += ph.bins[i]
+			fmt.Fprintf(w, "spigo_flow_response_seconds_bucket{%v,le=\"%v\"} %v\n", labels(s), (b * time.Millisecond).Seconds(), n)
+		}
+		n += ph.bins[len(tsBins)]
+		fmt.Fprintf(w, "spigo_flow_response_seconds_bucket{%v,le=\"+Inf\"} %v\n", labels(s), n)
+		fmt.Fprintf(w, "spigo_flow_response_seconds_sum{%v} %v\n", labels(s), ph.sum.Seconds())
+		fmt.Fprintf(w, "spigo_flow_response_seconds_count{%v} %v\n", labels(s), n)
+	}
+}
+
+// metricsHandler serves /metrics for Prometheus to scrape
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writePrometheus(w)
+}
@@ -0,0 +1,40 @@
+package collect
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// TestPrometheus checks the counters and the cumulative histogram buckets of a service group on /metrics
+func TestPrometheus(t *testing.T) {
+	archaius.Conf.Collect = true
+	archaius.Conf.Arch = "test"
+	defer func() { archaius.Conf.Collect = false }()
+	MeasureService("prom", 1500*time.Microsecond, false)
+	MeasureService("prom", 3*time.Millisecond, false)
+	MeasureService("prom", 2*time.Second, true)
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE spigo_flow_requests_total counter",
+		`spigo_flow_requests_total{arch="test",service="prom"} 3`,
+		`spigo_flow_failures_total{arch="test",service="prom"} 1`,
+		"# TYPE spigo_flow_response_seconds histogram",
+		`spigo_flow_response_seconds_bucket{arch="test",service="prom",le="0.001"} 0`,
+		`spigo_flow_response_seconds_bucket{arch="test",service="prom",le="0.002"} 1`,
+		`spigo_flow_response_seconds_bucket{arch="test",service="prom",le="0.004"} 2`,
+		`spigo_flow_response_seconds_bucket{arch="test",service="prom",le="1.024"} 2`,
+		`spigo_flow_response_seconds_bucket{arch="test",service="prom",le="+Inf"} 3`,
+		`spigo_flow_response_seconds_sum{arch="test",service="prom"} 2.0045`,
+		`spigo_flow_response_seconds_count{arch="test",service="prom"} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %v in\n%v", line, body)
+		}
+	}
+}
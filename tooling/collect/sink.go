@@ -0,0 +1,232 @@
+package collect
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// MetricsSink is a backend the metrics of a run are written to, picked by the -metrics flag
+type MetricsSink interface {
+	// Histogram writes the distribution of a measurement of an instance, name is the instance and suffix the measurement, e.g. _resp
+	Histogram(name, suffix string, h *generic.Histogram) error
+	// Summary writes the run summary as json at the end of the run
+	Summary(j []byte) error
+	// Close flushes anything buffered when the run is done
+	Close() error
+}
+
+// fileSink is the original output, a csv file for each histogram and the summary as json
+type fileSink struct{}
+
+func (fileSink) Histogram(name, suffix string, h *generic.Histogram) error {
+	file, err := os.Create("csv_metrics/" + names.Arch(name) + "_" + names.Instance(name) + suffix + ".csv")
+	if err != nil {
+		return err
+	}
+	h.Print(file)
+	return file.Close()
+}
+
+func (fileSink) Summary(j []byte) error {
+	fn := "json_metrics/" + archaius.Conf.Arch + "_summary.json"
+	log.Printf("Writing summary to %v\n", fn)
+	return ioutil.WriteFile(fn, append(j, '\n'), 0644)
+}
+
+func (fileSink) Close() error {
+	return nil
+}
+
+// influxPercentiles are written for each histogram
+var influxPercentiles = []float64{0.5, 0.9, 0.99}
+
+// influxBatch is how many lines are buffered before they're posted to an InfluxDB write url
+const influxBatch = 5000
+
+// influxSink writes InfluxDB line protocol, to stdout or posted in batches to a write url such as http://localhost:8086/write?db=spigo.
+// Every line is tagged with the arch, and run if the run is named, and timestamped with when the sink was made
+type influxSink struct {
+	w     io.Writer // stdout, or nil to post to url
+	url   string
+	tags  string
+	at    int64
+	lines bytes.Buffer
+	n     int
+	lock  sync.Mutex
+}
+
+func newInfluxSink(w io.Writer, url string) *influxSink {
+	tags := ",arch=" + influxEscape(archaius.Conf.Arch)
+	if archaius.Conf.RunName != "" {
+		tags += ",run=" + influxEscape(archaius.Conf.RunName)
+	}
+	return &influxSink{w: w, url: url, tags: tags, at: clock.Now().UnixNano()}
+}
+
+// influxEscape escapes the characters InfluxDB line protocol gives a meaning to in measurement names and tags
+func influxEscape(s string) string {
+	return strings.NewReplacer(",", "\\,", " ", "\\ ", "=", "\\=").Replace(s)
+}
+
+// line adds a measurement with extra tags and sorted fields, it's dropped if there are no fields
+func (s *influxSink) line(measurement, tags string, fields map[string]float64) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var f []string
+	for _, k := range keys {
+		f = append(f, fmt.Sprintf("%v=%v", influxEscape(k), fields[k]))
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fmt.Fprintf(&s.lines, "%v%v%v %v %v\n", influxEscape(measurement), s.tags, tags, strings.Join(f, ","), s.at)
+	if s.n++; s.n >= influxBatch {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered lines, the caller holds the lock
+func (s *influxSink) flush() error {
+	if s.lines.Len() == 0 {
+		return nil
+	}
+	defer func() { s.lines.Reset(); s.n = 0 }()
+	if s.w != nil {
+		_, err := s.w.Write(s.lines.Bytes())
+		return err
+	}
+	resp, err := http.Post(s.url, "text/plain", bytes.NewReader(s.lines.Bytes()))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%v returned %v", s.url, resp.Status)
+	}
+	return nil
+}
+
+// Histogram writes spigo_histogram,instance=<name>,metric=<suffix> with the p50, p90 and p99 in milliseconds
+func (s *influxSink) Histogram(name, suffix string, h *generic.Histogram) error {
+	fields := make(map[string]float64)
+	for _, q := range influxPercentiles {
+		fields[fmt.Sprintf("p%vms", q*100)] = h.Quantile(q) / float64(time.Millisecond)
+	}
+	return s.line("spigo_histogram", ",service="+influxEscape(names.Service(name))+",instance="+influxEscape(names.Instance(name))+
+		",metric="+influxEscape(strings.TrimPrefix(suffix, "_")), fields)
+}
+
+// Summary writes a spigo_<section> line for each section of the summary, with its numbers as fields. A section that is keyed by
+// service, edge or anything else gets a line for each key, tagged with key=<key>
+func (s *influxSink) Summary(j []byte) error {
+	var summary map[string]interface{}
+	if err := json.Unmarshal(j, &summary); err != nil {
+		return err
+	}
+	var sections []string
+	for k := range summary {
+		sections = append(sections, k)
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		m, ok := summary[section].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fields := make(map[string]float64)
+		var keys []string
+		for k, v := range m {
+			switch v := v.(type) {
+			case float64:
+				fields[k] = v
+			case bool:
+				if v {
+					fields[k] = 1
+				} else {
+					fields[k] = 0
+				}
+			case map[string]interface{}:
+				keys = append(keys, k)
+			}
+		}
+		if err := s.line("spigo_"+section, "", fields); err != nil {
+			return err
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			kf := make(map[string]float64)
+			for f, v := range m[k].(map[string]interface{}) {
+				if n, ok := v.(float64); ok {
+					kf[f] = n
+				}
+			}
+			if err := s.line("spigo_"+section, ",key="+influxEscape(k), kf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *influxSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.flush()
+}
+
+var sink MetricsSink
+var sinkOnce sync.Once
+
+// Sink is the metrics backend picked by -metrics, file for csv_metrics and json_metrics, stdout for InfluxDB line protocol on
+// stdout, or an InfluxDB write url, and the -sqlite database as well if there is one
+func Sink() MetricsSink {
+	sinkOnce.Do(func() {
+		m := archaius.Conf.Metrics
+		switch {
+		case m == "" || m == "file":
+			sink = fileSink{}
+		case m == "stdout":
+			sink = newInfluxSink(os.Stdout, "")
+		case strings.HasPrefix(m, "http://") || strings.HasPrefix(m, "https://"):
+			sink = newInfluxSink(nil, m)
+		default:
+			log.Fatal("collect: -metrics should be file, stdout or an InfluxDB write url, not " + m)
+		}
+		if archaius.Conf.SQLite != "" {
+			sqliteDB = newSQLiteSink(archaius.Conf.SQLite)
+			sink = teeSink{sink, sqliteDB}
+		}
+	})
+	return sink
+}
+
+// CloseSink flushes the metrics backend at the end of the run
+func CloseSink() {
+	if !archaius.Conf.Collect {
+		return
+	}
+	if err := Sink().Close(); err != nil {
+		log.Println("collect: can't write metrics:", err)
+	}
+}
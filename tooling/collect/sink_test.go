@@ -0,0 +1,48 @@
+package collect
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// TestInfluxSink checks histograms and summary sections come out as tagged line protocol
+func TestInfluxSink(t *testing.T) {
+	archaius.Conf.Arch = "test"
+	archaius.Conf.RunName = "run 1"
+	var out bytes.Buffer
+	s := newInfluxSink(&out, "")
+	h := generic.NewHistogram("h", 100)
+	for i := 1; i <= 100; i++ {
+		h.Observe(float64(time.Duration(i) * time.Millisecond))
+	}
+	if err := s.Histogram(names.Make("test", "us-east-1", "zoneA", "app", "karyon", 0), "_resp", h); err != nil {
+		t.Fatal(err)
+	}
+	j := []byte(`{"run":{"arch":"test","duration":10,"collect":true},"services":{"app":{"requests":5,"p50ms":1.5,"version":"v2"}},"timeline":{}}`)
+	if err := s.Summary(j); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatal("written before Close")
+	}
+	s.Close()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatal(lines)
+	}
+	if !strings.HasPrefix(lines[0], "spigo_histogram,arch=test,run=run\\ 1,service=app,instance=app00,metric=resp p50ms=") {
+		t.Error(lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "spigo_run,arch=test,run=run\\ 1 collect=1,duration=10 ") {
+		t.Error(lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "spigo_services,arch=test,run=run\\ 1,key=app p50ms=1.5,requests=5 ") {
+		t.Error(lines[2])
+	}
+}
@@ -0,0 +1,178 @@
+package collect
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// sqliteSchema is the tables of the -sqlite database, the names of the tables and columns are kept stable so queries written
+// against one run keep working on the next. Offsets are milliseconds from the start of the run, so flows can be joined to events
+var sqliteSchema = []struct{ name, columns string }{
+	{"run", "name TEXT, arch TEXT, description TEXT, archcommit TEXT, args TEXT, date TEXT"},
+	{"flows", "trace INTEGER, span INTEGER, parent INTEGER, name TEXT, service TEXT, instance TEXT, value TEXT, ts INTEGER, " +
+		"offsetms REAL, intention TEXT, baggage TEXT, sidecar TEXT, dedup TEXT, degraded TEXT, page TEXT, flag TEXT, saga TEXT, batch TEXT, phase TEXT, breaker TEXT, topic TEXT"},
+	{"histograms", "service TEXT, instance TEXT, metric TEXT, p50ms REAL, p90ms REAL, p99ms REAL"},
+	{"summary", "section TEXT, key TEXT, field TEXT, number REAL, string TEXT"},
+	{"events", "timestamp TEXT, offsetms REAL, kind TEXT, detail TEXT"},
+}
+
+// FlowAnnotation is an annotation of a span in the flows, as it goes in the flows table of the -sqlite database
+type FlowAnnotation struct {
+	Trace, Span, Parent                                                      int64
+	Name                                                                     string // of the request, e.g. GetRequest
+	Host                                                                     string // instance that made the annotation
+	Value                                                                    string // cs, sr, ss, cr or ff
+	Timestamp                                                                int64  // unix nanoseconds
+	Intention                                                                string
+	Baggage                                                                  string
+	Sidecar, Dedup, Degraded, Page, Flag, Saga, Batch, Phase, Breaker, Topic string // the binaryAnnotations of the same names in the flow file
+}
+
+// sqliteSink collects the rows of the tables while the run goes on, and writes the database when it's closed
+type sqliteSink struct {
+	fn     string
+	tables map[string][][]interface{}
+	lock   sync.Mutex
+}
+
+var sqliteDB *sqliteSink // nil without -sqlite
+
+func newSQLiteSink(fn string) *sqliteSink {
+	return &sqliteSink{fn: fn, tables: make(map[string][][]interface{})}
+}
+
+func (s *sqliteSink) add(table string, row ...interface{}) {
+	s.lock.Lock()
+	s.tables[table] = append(s.tables[table], row)
+	s.lock.Unlock()
+}
+
+// Histogram adds a row with the p50, p90 and p99 in milliseconds, like the InfluxDB sink
+func (s *sqliteSink) Histogram(name, suffix string, h *generic.Histogram) error {
+	ms := func(q float64) float64 { return h.Quantile(q) / float64(time.Millisecond) }
+	s.add("histograms", names.Service(name), names.Instance(name), strings.TrimPrefix(suffix, "_"), ms(0.5), ms(0.9), ms(0.99))
+	return nil
+}
+
+// Summary adds a row for every value in the summary, with the section, the keys it's nested under joined by / and its field,
+// numbers and booleans as a number and everything else as a string
+func (s *sqliteSink) Summary(j []byte) error {
+	var summary map[string]interface{}
+	if err := json.Unmarshal(j, &summary); err != nil {
+		return err
+	}
+	var sections []string
+	for k := range summary {
+		sections = append(sections, k)
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		s.flatten(section, nil, summary[section])
+	}
+	return nil
+}
+
+func (s *sqliteSink) flatten(section string, path []string, v interface{}) {
+	key, field := "", ""
+	if n := len(path); n > 0 {
+		key, field = strings.Join(path[:n-1], "/"), path[n-1]
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.flatten(section, append(path[:len(path):len(path)], k), v[k])
+		}
+	case []interface{}:
+		for i, e := range v {
+			s.flatten(section, append(path[:len(path):len(path)], strconv.Itoa(i)), e)
+		}
+	case float64:
+		s.add("summary", section, key, field, v, nil)
+	case bool:
+		n := 0.0
+		if v {
+			n = 1
+		}
+		s.add("summary", section, key, field, n, nil)
+	case string:
+		s.add("summary", section, key, field, nil, v)
+	}
+}
+
+// Close adds the run and the events on the timeline, and writes the database
+func (s *sqliteSink) Close() error {
+	r := archaius.Run()
+	s.add("run", r.Name, r.Arch, r.Description, r.Commit, r.Args, r.Date)
+	timelineLock.Lock()
+	for _, e := range timeline {
+		s.add("events", e.Time, e.Offset, e.Kind, e.Detail)
+	}
+	timelineLock.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var tables []sqliteTable
+	for _, t := range sqliteSchema {
+		tables = append(tables, sqliteTable{t.name, t.columns, s.tables[t.name]})
+	}
+	log.Printf("Writing %v flow annotations, %v histograms, %v summary values and %v events to %v\n",
+		len(s.tables["flows"]), len(s.tables["histograms"]), len(s.tables["summary"]), len(s.tables["events"]), s.fn)
+	return writeSQLite(s.fn, tables)
+}
+
+// SQLiteFlows adds the annotations of the flows to the -sqlite database, if there is one
+func SQLiteFlows(as []FlowAnnotation) {
+	if Sink(); sqliteDB == nil {
+		return
+	}
+	timelineLock.Lock()
+	start := timelineStart.UnixNano()
+	timelineLock.Unlock()
+	for _, a := range as {
+		sqliteDB.add("flows", a.Trace, a.Span, a.Parent, a.Name, names.Service(a.Host), names.Instance(a.Host), a.Value, a.Timestamp,
+			float64(a.Timestamp-start)/float64(time.Millisecond), a.Intention, a.Baggage, a.Sidecar, a.Dedup, a.Degraded, a.Page, a.Flag, a.Saga, a.Batch, a.Phase, a.Breaker, a.Topic)
+	}
+}
+
+// teeSink writes the metrics to more than one sink
+type teeSink []MetricsSink
+
+func (t teeSink) Histogram(name, suffix string, h *generic.Histogram) error {
+	for _, s := range t {
+		if err := s.Histogram(name, suffix, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t teeSink) Summary(j []byte) error {
+	for _, s := range t {
+		if err := s.Summary(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t teeSink) Close() error {
+	for _, s := range t {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package collect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// TestSQLiteSink checks histograms and flattened summary values become rows of their tables
+func TestSQLiteSink(t *testing.T) {
+	s := newSQLiteSink("")
+	h := generic.NewHistogram("h", 100)
+	for i := 1; i <= 100; i++ {
+		h.Observe(float64(time.Duration(i) * time.Millisecond))
+	}
+	s.Histogram(names.Make("test", "us-east-1", "zoneA", "app", "karyon", 0), "_resp", h)
+	r := s.tables["histograms"]
+	if len(r) != 1 || r[0][0] != "app" || r[0][1] != "app00" || r[0][2] != "resp" || r[0][3].(float64) < 49 || r[0][3].(float64) > 51 {
+		t.Fatal(r)
+	}
+	if err := s.Summary([]byte(`{"services":{"app":{"requests":5,"version":"v2"}},"run":{"collect":true}}`)); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		{"run", "", "collect", 1.0, nil},
+		{"services", "app", "requests", 5.0, nil},
+		{"services", "app", "version", nil, "v2"},
+	}
+	r = s.tables["summary"]
+	if len(r) != len(want) {
+		t.Fatal(r)
+	}
+	for i, w := range want {
+		for j := range w {
+			if r[i][j] != w[j] {
+				t.Error(i, r[i], w)
+			}
+		}
+	}
+}
+
+// TestWriteSQLite checks the file header and the schema and data pages of a table too big for one page
+func TestWriteSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var rows [][]interface{}
+	for i := 0; i < 1000; i++ {
+		rows = append(rows, []interface{}{int64(i), float64(i) / 2, "row", nil})
+	}
+	rows = append(rows, []interface{}{int64(-1), 0.0, string(make([]byte, 3*sqlitePage)), nil}) // overflows
+	fn := filepath.Join(dir, "test.db")
+	if err := writeSQLite(fn, []sqliteTable{{"t", "i INTEGER, f REAL, s TEXT, n TEXT", rows}, {"empty", "x TEXT", nil}}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("SQLite format 3\x00")) || len(b)%sqlitePage != 0 {
+		t.Fatal("not a database file of whole pages")
+	}
+	if n := binary.BigEndian.Uint32(b[28:]); int(n) != len(b)/sqlitePage {
+		t.Error("header has", n, "pages, file has", len(b)/sqlitePage)
+	}
+	if b[100] != 0x0d || binary.BigEndian.Uint16(b[103:]) != 2 {
+		t.Error("schema page should be a leaf with two tables")
+	}
+}
+
+// TestVarint checks the SQLite varint encoding at the byte boundaries
+func TestVarint(t *testing.T) {
+	for v, w := range map[uint64][]byte{0: {0}, 127: {0x7f}, 128: {0x81, 0}, 16383: {0xff, 0x7f}, 16384: {0x81, 0x80, 0}} {
+		if b := appendVarint(nil, v); !bytes.Equal(b, w) {
+			t.Error(v, b, w)
+		}
+	}
+}
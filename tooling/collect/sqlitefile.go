@@ -0,0 +1,231 @@
+package collect
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+// sqliteTable is a table of a database written by writeSQLite, rows hold int64, float64, string or nil values in the order of
+// the columns of the create statement
+type sqliteTable struct {
+	name    string
+	columns string // e.g. service TEXT, p50ms REAL
+	rows    [][]interface{}
+}
+
+// sqlitePage is the page size of the database file, and all of it is usable
+const sqlitePage = 4096
+
+// sqliteFile is a database file being built a page at a time, page n is pages[n-1]
+type sqliteFile struct {
+	pages [][]byte
+}
+
+func (f *sqliteFile) alloc() int {
+	f.pages = append(f.pages, make([]byte, sqlitePage))
+	return len(f.pages)
+}
+
+// sqliteNode is a b-tree page that hasn't been written yet, the rowids in it and its children go up to max
+type sqliteNode struct {
+	cells [][]byte
+	right int // right most child of an interior page, zero for a leaf
+	max   int64
+}
+
+// writeSQLite writes the tables to a new SQLite 3 database file that the sqlite3 shell or any driver can open, each table is
+// a b-tree of table pages with its rows in rowid order from 1, built bottom up as nothing is updated once it's written
+func writeSQLite(fn string, tables []sqliteTable) error {
+	f := &sqliteFile{}
+	f.alloc() // the first page has the file header and the schema
+	var schema [][]byte
+	for i, t := range tables {
+		root := f.tree(t.rows)
+		sql := "CREATE TABLE " + t.name + "(" + t.columns + ")"
+		schema = append(schema, f.cell(int64(i+1), []interface{}{"table", t.name, t.name, int64(root), sql}))
+	}
+	if n := len(schema); 100+8+len(schema)*2+cellBytes(schema) > sqlitePage {
+		return fmt.Errorf("sqlite: the schema of %v tables doesn't fit on the first page", n)
+	}
+	f.write(1, sqliteNode{cells: schema})
+	h := f.pages[0]
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], sqlitePage)
+	h[18], h[19] = 1, 1                   // legacy journal, not wal
+	h[21], h[22], h[23] = 64, 32, 32      // payload fractions, fixed by the file format
+	binary.BigEndian.PutUint32(h[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(f.pages)))
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // utf-8
+	binary.BigEndian.PutUint32(h[92:], 1) // version valid for the change counter
+	binary.BigEndian.PutUint32(h[96:], 3008000)
+	var out []byte
+	for _, p := range f.pages {
+		out = append(out, p...)
+	}
+	return ioutil.WriteFile(fn, out, 0644)
+}
+
+// tree writes the b-tree of a table and returns its root page
+func (f *sqliteFile) tree(rows [][]interface{}) int {
+	var level []sqliteNode
+	var leaf sqliteNode
+	used := 0
+	for i, r := range rows {
+		c := f.cell(int64(i+1), r)
+		if used+len(c)+2 > sqlitePage-8 && len(leaf.cells) > 0 {
+			level = append(level, leaf)
+			leaf, used = sqliteNode{}, 0
+		}
+		leaf.cells = append(leaf.cells, c)
+		leaf.max = int64(i + 1)
+		used += len(c) + 2
+	}
+	level = append(level, leaf)
+	for len(level) > 1 {
+		// interior cells are a child page and a rowid varint, at most 4+9 bytes and a cell pointer, spread evenly so
+		// every parent has at least two children
+		per := (sqlitePage - 12) / 15
+		parents := (len(level) + per - 1) / per
+		var up []sqliteNode
+		for p, i := 0, 0; p < parents; p++ {
+			n := len(level) / parents
+			if p < len(level)%parents {
+				n++
+			}
+			var parent sqliteNode
+			for _, child := range level[i : i+n-1] {
+				c := make([]byte, 4, 13)
+				binary.BigEndian.PutUint32(c, uint32(f.place(child)))
+				parent.cells = append(parent.cells, appendVarint(c, uint64(child.max)))
+			}
+			last := level[i+n-1]
+			parent.right, parent.max = f.place(last), last.max
+			up = append(up, parent)
+			i += n
+		}
+		level = up
+	}
+	return f.place(level[0])
+}
+
+// place a node on a new page and return the page
+func (f *sqliteFile) place(n sqliteNode) int {
+	p := f.alloc()
+	f.write(p, n)
+	return p
+}
+
+// write a node to its page, after the file header on the first page, with the cells packed at the end of the page
+func (f *sqliteFile) write(page int, n sqliteNode) {
+	b := f.pages[page-1]
+	at := 0
+	if page == 1 {
+		at = 100
+	}
+	hdr := 8
+	b[at] = 0x0d // table leaf
+	if n.right != 0 {
+		hdr = 12
+		b[at] = 0x05 // table interior
+		binary.BigEndian.PutUint32(b[at+8:], uint32(n.right))
+	}
+	binary.BigEndian.PutUint16(b[at+3:], uint16(len(n.cells)))
+	end := sqlitePage
+	for i, c := range n.cells {
+		end -= len(c)
+		copy(b[end:], c)
+		binary.BigEndian.PutUint16(b[at+hdr+2*i:], uint16(end))
+	}
+	if end == sqlitePage {
+		end = 0 // an empty page starts its cell content at zero, which means 65536
+	}
+	binary.BigEndian.PutUint16(b[at+5:], uint16(end))
+}
+
+// cell of a table leaf for a row, the payload size, the rowid and the record, with what doesn't fit on the page in a chain
+// of overflow pages
+func (f *sqliteFile) cell(rowid int64, row []interface{}) []byte {
+	r := sqliteRecord(row)
+	c := appendVarint(appendVarint(nil, uint64(len(r))), uint64(rowid))
+	const u = sqlitePage
+	max, min := u-35, (u-12)*32/255-23
+	if len(r) <= max {
+		return append(c, r...)
+	}
+	local := min + (len(r)-min)%(u-4)
+	if local > max {
+		local = min
+	}
+	p := f.alloc()
+	var first [4]byte
+	binary.BigEndian.PutUint32(first[:], uint32(p))
+	c = append(append(c, r[:local]...), first[:]...)
+	for rest := r[local:]; ; {
+		n := copy(f.pages[p-1][4:], rest)
+		if rest = rest[n:]; len(rest) == 0 {
+			return c
+		}
+		next := f.alloc()
+		binary.BigEndian.PutUint32(f.pages[p-1], uint32(next)) // each overflow page starts with the next one, zero on the last
+		p = next
+	}
+}
+
+// sqliteRecord encodes a row in the record format, a header of serial types and then the values
+func sqliteRecord(row []interface{}) []byte {
+	var types, body []byte
+	for _, v := range row {
+		switch v := v.(type) {
+		case int64:
+			switch v {
+			case 0:
+				types = appendVarint(types, 8)
+			case 1:
+				types = appendVarint(types, 9)
+			default:
+				types = appendVarint(types, 6)
+				body = append(body, make([]byte, 8)...)
+				binary.BigEndian.PutUint64(body[len(body)-8:], uint64(v))
+			}
+		case float64:
+			types = appendVarint(types, 7)
+			body = append(body, make([]byte, 8)...)
+			binary.BigEndian.PutUint64(body[len(body)-8:], math.Float64bits(v))
+		case string:
+			types = appendVarint(types, uint64(2*len(v)+13))
+			body = append(body, v...)
+		default:
+			types = appendVarint(types, 0) // null
+		}
+	}
+	size := len(types) + 1 // the header size includes its own varint
+	for len(types)+len(appendVarint(nil, uint64(size))) != size {
+		size = len(types) + len(appendVarint(nil, uint64(size)))
+	}
+	return append(append(appendVarint(nil, uint64(size)), types...), body...)
+}
+
+// appendVarint appends a SQLite varint, big endian 7 bits a byte with the top bit set on all but the last, which is enough
+// for the sizes and rowids written here, up to 2^56
+func appendVarint(b []byte, v uint64) []byte {
+	var tmp [8]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+func cellBytes(cells [][]byte) int {
+	n := 0
+	for _, c := range cells {
+		n += len(c)
+	}
+	return n
+}
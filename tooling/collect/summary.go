@@ -0,0 +1,44 @@
+package collect
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// summary sections by name, each is marshalled as json at the end of the run
+var summary = make(map[string]interface{})
+var summaryLock sync.Mutex
+
+// Summarize adds or replaces a named section of the run summary
+func Summarize(section string, value interface{}) {
+	summaryLock.Lock()
+	summary[section] = value
+	summaryLock.Unlock()
+}
+
+// SummarySoFar returns the run metadata and all the summary sections as json, it can be called while the run is going
+func SummarySoFar() []byte {
+	summaryLock.Lock()
+	defer summaryLock.Unlock()
+	summary["run"] = archaius.Run()
+	summarizeServices()
+	summarizeConcurrency()
+	j, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return j
+}
+
+// WriteSummary saves the run metadata and all the summary sections to the -metrics sink, json_metrics/<arch>_summary.json by default
+func WriteSummary() {
+	if !archaius.Conf.Collect {
+		return
+	}
+	if err := Sink().Summary(SummarySoFar()); err != nil {
+		log.Fatal(err)
+	}
+}
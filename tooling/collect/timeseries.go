@@ -0,0 +1,190 @@
+package collect
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+)
+
+// Buckets are the time buckets of the time series, Width long with boundaries at Origin after the start of the run, or with
+// Wall after the Unix epoch so they fall on wall clock multiples of the width, e.g. every second on the second
+type Buckets struct {
+	Width  time.Duration
+	Origin time.Duration
+	Wall   bool
+}
+
+// ParseBuckets checks a -timeseries bucket width, -bucketalign runstart or wallclock, and a -bucketorigin offset,
+// e.g. 1s, wallclock and 250ms. An empty alignment is runstart and an empty origin zero
+func ParseBuckets(width, align, origin string) (Buckets, error) {
+	var b Buckets
+	var err error
+	if b.Width, err = time.ParseDuration(width); err != nil || b.Width < time.Millisecond {
+		return b, fmt.Errorf("bucket width %q should be a duration of at least 1ms", width)
+	}
+	switch align {
+	case "", "runstart":
+	case "wallclock":
+		b.Wall = true
+	default:
+		return b, fmt.Errorf("bucket alignment %q should be runstart or wallclock", align)
+	}
+	if origin != "" {
+		if b.Origin, err = time.ParseDuration(origin); err != nil || b.Origin < 0 {
+			return b, fmt.Errorf("bucket origin %q should be a duration that isn't negative", origin)
+		}
+	}
+	return b, nil
+}
+
+// Start of the bucket a time falls in, for a run that started at runStart
+func (b Buckets) Start(t, runStart time.Time) time.Time {
+	base := runStart
+	if b.Wall {
+		base = time.Unix(0, 0)
+	}
+	base = base.Add(b.Origin)
+	k := t.Sub(base) / b.Width
+	if t.Before(base.Add(k * b.Width)) { // round down before the origin as well as after it
+		k--
+	}
+	return base.Add(k * b.Width)
+}
+
+// tsBins are the upper bounds of the latency heatmap bins, doubling from 1ms, with one more bin for anything slower
+var tsBins = []time.Duration{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024}
+
+// tsBucket is the requests to a service in one time bucket
+type tsBucket struct {
+	requests, failures int
+	total, max         time.Duration
+	heat               []int
+}
+
+var series map[string]map[int64]*tsBucket // by service and bucket start in nanoseconds since the epoch, guarded by windowLock
+var seriesBuckets *Buckets
+var seriesOnce sync.Once
+
+// TimeSeries parses the buckets set by -timeseries the first time it's called and returns them, or nil if there's no time
+// series, and fails on bad options so that can be checked before the run
+func TimeSeries() *Buckets {
+	seriesOnce.Do(func() {
+		if archaius.Conf.TimeSeries == "" {
+			return
+		}
+		b, err := ParseBuckets(archaius.Conf.TimeSeries, archaius.Conf.BucketAlign, archaius.Conf.BucketOrigin)
+		if err != nil {
+			log.Fatal("timeseries: " + err.Error())
+		}
+		seriesBuckets = &b
+		series = make(map[string]map[int64]*tsBucket)
+	})
+	return seriesBuckets
+}
+
+// measureSeries adds a response time to the time bucket it ends in for a service, the caller holds windowLock. Requests
+// before the architecture starts running aren't counted, as the buckets can't be lined up with the start of the run yet
+func measureSeries(service string, d time.Duration, failed bool) {
+	b := TimeSeries()
+	if b == nil {
+		return
+	}
+	timelineLock.Lock()
+	start, started := timelineStart, timelineStarted
+	timelineLock.Unlock()
+	if !started {
+		return
+	}
+	at := b.Start(clock.Now(), start).UnixNano()
+	if series[service] == nil {
+		series[service] = make(map[int64]*tsBucket)
+	}
+	tb := series[service][at]
+	if tb == nil {
+		tb = &tsBucket{heat: make([]int, len(tsBins)+1)}
+		series[service][at] = tb
+	}
+	tb.requests++
+	if failed {
+		tb.failures++
+	}
+	tb.total += d
+	if d > tb.max {
+		tb.max = d
+	}
+	i := sort.Search(len(tsBins), func(i int) bool { return d <= tsBins[i]*time.Millisecond })
+	tb.heat[i]++
+}
+
+// WriteTimeSeries saves the requests, failures, mean and max response time and a latency heatmap of each service in every
+// time bucket from the first to the last to csv_metrics/<arch>_timeseries.csv. Each bucket has its wall clock start and its
+// offset in seconds from the start of the run, so runs with the same alignment can be overlaid, and services with nothing in a
+// bucket get a row of zeros so every service has the same rows
+func WriteTimeSeries() {
+	b := TimeSeries()
+	if !archaius.Conf.Collect || b == nil {
+		return
+	}
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	if len(series) == 0 {
+		return
+	}
+	var services []string
+	var first, last int64
+	seen := false
+	for s, buckets := range series {
+		services = append(services, s)
+		for at := range buckets {
+			if !seen || at < first {
+				first = at
+			}
+			if !seen || at > last {
+				last = at
+			}
+			seen = true
+		}
+	}
+	sort.Strings(services)
+	timelineLock.Lock()
+	start := timelineStart
+	timelineLock.Unlock()
+	fn := "csv_metrics/" + archaius.Conf.Arch + "_timeseries.csv"
+	file, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	log.Printf("Writing %v services in %v buckets to %v\n", len(services), (last-first)/int64(b.Width)+1, fn)
+	file.WriteString("start,offset,service,requests,failures,meanms,maxms")
+	for _, bin := range tsBins {
+		fmt.Fprintf(file, ",le%vms", int64(bin))
+	}
+	fmt.Fprintf(file, ",over%vms\n", int64(tsBins[len(tsBins)-1]))
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	for at := first; at <= last; at += int64(b.Width) {
+		t := time.Unix(0, at)
+		for _, s := range services {
+			fmt.Fprintf(file, "%v,%.3f,%v", t.UTC().Format(time.RFC3339Nano), t.Sub(start).Seconds(), s)
+			tb := series[s][at]
+			if tb == nil {
+				tb = &tsBucket{heat: make([]int, len(tsBins)+1)}
+			}
+			mean := 0.0
+			if tb.requests > 0 {
+				mean = ms(tb.total) / float64(tb.requests)
+			}
+			fmt.Fprintf(file, ",%v,%v,%.3f,%.3f", tb.requests, tb.failures, mean, ms(tb.max))
+			for _, n := range tb.heat {
+				fmt.Fprintf(file, ",%v", n)
+			}
+			file.WriteString("\n")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package collect
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBuckets checks bucket boundaries line up with the run start or the wall clock, moved on by the origin
+func TestBuckets(t *testing.T) {
+	run := time.Date(2016, 4, 20, 11, 35, 16, 300*int(time.Millisecond), time.UTC)
+	for _, c := range []struct {
+		align, origin string
+		at, start     time.Duration // after the run start
+	}{
+		{"runstart", "", 2500 * time.Millisecond, 2 * time.Second},
+		{"runstart", "500ms", 2200 * time.Millisecond, 1500 * time.Millisecond},
+		{"runstart", "500ms", 100 * time.Millisecond, -500 * time.Millisecond}, // before the origin rounds down
+		{"wallclock", "", 2500 * time.Millisecond, 1700 * time.Millisecond},    // 11:35:18 on the second
+		{"wallclock", "250ms", 2500 * time.Millisecond, 1950 * time.Millisecond},
+	} {
+		b, err := ParseBuckets("1s", c.align, c.origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := b.Start(run.Add(c.at), run); s.Sub(run) != c.start {
+			t.Errorf("%v %v: %v is in the bucket at %v, not %v", c.align, c.origin, c.at, s.Sub(run), c.start)
+		}
+	}
+	for _, bad := range [][]string{{"0s", "runstart", ""}, {"1s", "sometimes", ""}, {"1s", "wallclock", "-1s"}, {"fast", "", ""}} {
+		if _, err := ParseBuckets(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("%v should be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package collect
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+// window of response times for a service group, only kept for services that something is watching
+type window struct {
+	hist  *generic.Histogram
+	count int
+}
+
+var windows = make(map[string]*window)
+var windowLock sync.Mutex
+
+// Watch starts collecting response times for a service group, independent of the -c collect option
+func Watch(service string) {
+	windowLock.Lock()
+	if windows[service] == nil {
+		windows[service] = &window{hist: generic.NewHistogram(service, 100)}
+	}
+	windowLock.Unlock()
+}
+
+// watched is true if something is watching a service group
+func watched(service string) bool {
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	return windows[service] != nil
+}
+
+// totals of response times and failures for each service group over the whole run, kept for the summary
+type total struct {
+	hist            *generic.Histogram
+	count, failures int
+	apdex           apdex
+}
+
+var totals = make(map[string]*total)
+
+// tally of the responses of a service group and how many of them failed
+type tally struct{ count, failures int }
+
+// served is the tally of each service group over the whole run, kept whether or not collect is enabled
+var served = make(map[string]*tally)
+
+// MeasureService adds a response time measurement for a service group if it is being watched,
+// and to the run totals if collect is enabled
+func MeasureService(service string, d time.Duration, failed bool) {
+	windowLock.Lock()
+	w := windows[service]
+	if w != nil {
+		w.hist.Observe(float64(d))
+		w.count++
+	}
+	s := served[service]
+	if s == nil {
+		s = &tally{}
+		served[service] = s
+	}
+	s.count++
+	if failed {
+		s.failures++
+	}
+	if archaius.Conf.Collect {
+		t := totals[service]
+		if t == nil {
+			t = &total{hist: generic.NewHistogram(service, 100), apdex: apdex{target: apdexTarget(service)}}
+			totals[service] = t
+		}
+		t.hist.Observe(float64(d))
+		t.count++
+		t.apdex.observe(d, failed)
+		if failed {
+			t.failures++
+		}
+		if archaius.Conf.Hdr {
+			measureHdr(service, d)
+		}
+		measureSeries(service, d, failed)
+		measurePrometheus(service, d)
+	}
+	windowLock.Unlock()
+}
+
+// ServiceSummary is the response time seen by callers of a service group over the whole run
+type ServiceSummary struct {
+	Version  string  `json:"version,omitempty"`
+	Requests int     `json:"requests"`
+	Failures int     `json:"failures"`
+	P50      float64 `json:"p50ms"`
+	P99      float64 `json:"p99ms"`
+}
+
+// summarizeServices adds the run totals to the summary
+func summarizeServices() {
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	if len(totals) == 0 {
+		return
+	}
+	ms := func(ns float64) float64 { return ns / float64(time.Millisecond) }
+	services := make(map[string]ServiceSummary, len(totals))
+	for s, t := range totals {
+		services[s] = ServiceSummary{archaius.Service(s).Version, t.count, t.failures, ms(t.hist.Quantile(0.5)), ms(t.hist.Quantile(0.99))}
+	}
+	summary["services"] = services
+	summarizeApdex()
+	summarizeAvailability()
+}
+
+// Served returns the responses of a service group so far and how many of them failed
+func Served(service string) (count, failures int) {
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	if s := served[service]; s != nil {
+		return s.count, s.failures
+	}
+	return 0, 0
+}
+
+// WindowQuantile returns a response time quantile and count of measurements for a service group
+// since the last call, and starts a new window
+func WindowQuantile(service string, q float64) (time.Duration, int) {
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	w := windows[service]
+	if w == nil || w.count == 0 {
+		return 0, 0
+	}
+	windows[service] = &window{hist: generic.NewHistogram(service, 100)}
+	return time.Duration(w.hist.Quantile(q)), w.count
+}
+
+// ServiceQuantile returns a response time quantile and count of measurements for a service group over the whole run, if collect is enabled
+func ServiceQuantile(service string, q float64) (time.Duration, int) {
+	windowLock.Lock()
+	defer windowLock.Unlock()
+	t := totals[service]
+	if t == nil || t.count == 0 {
+		return 0, 0
+	}
+	return time.Duration(t.hist.Quantile(q)), t.count
+}
@@ -0,0 +1,338 @@
+// Package convert reads a graph that spigo wrote as GraphJSON, GraphML or GEXF and writes it out in one of the other formats,
+// so a different view of a run doesn't need the simulation to be run again
+package convert
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/graphgexf"
+	"github.com/adrianco/spigo/tooling/graphjson"
+	"github.com/adrianco/spigo/tooling/graphml"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// Formats that can be read and written, with the file extension for each
+var Formats = map[string]string{"graphjson": ".json", "graphml": ".graphml", "gexf": ".gexf"}
+
+// node and the attributes that are kept across formats, those a format doesn't have are left empty
+type node struct {
+	name, pack, service, region, zone string
+	tags                              map[string]string
+	t                                 time.Time
+}
+
+// edge given a space separated from and to name, and when it was made if the format has it
+type edge struct {
+	fromTo string
+	t      time.Time
+}
+
+// graph read from a file
+type graph struct {
+	arch  string
+	nodes []node
+	edges []edge
+	calls map[string]map[string]int // along each edge, from gexf written with -c, nil otherwise
+}
+
+// Format works out the format of a file from its extension, ignoring .gz, and returns "" if it isn't one of the Formats
+func Format(fn string) string {
+	fn = strings.TrimSuffix(fn, ".gz")
+	for f, ext := range Formats {
+		if strings.HasSuffix(fn, ext) {
+			return f
+		}
+	}
+	return ""
+}
+
+// OutputFile is the input file name with the extension of the format, compressed if -gzip is set and the format allows it
+func OutputFile(in, to string) string {
+	in = strings.TrimSuffix(in, ".gz")
+	fn := strings.TrimSuffix(in, Formats[Format(in)]) + Formats[to]
+	if archaius.Conf.Gzip && to != "gexf" {
+		fn += ".gz"
+	}
+	return fn
+}
+
+// Convert reads the graph in file in and writes it to file out in format to
+func Convert(in, to, out string) {
+	from := Format(in)
+	if from == "" {
+		log.Fatalf("convert: can't tell the format of %v, it should end in .json, .graphml or .gexf\n", in)
+	}
+	if _, ok := Formats[to]; !ok {
+		log.Fatalf("convert: -to %v should be graphjson, graphml or gexf\n", to)
+	}
+	if to == "gexf" && strings.HasSuffix(out, ".gz") {
+		log.Fatal("convert: gexf can't be written compressed")
+	}
+	var g *graph
+	switch from {
+	case "graphjson":
+		g = readGraphJSON(in)
+	case "graphml":
+		g = readGraphML(in)
+	case "gexf":
+		g = readGEXF(in)
+	}
+	log.Printf("Converting %v nodes and %v edges of %v from %v to %v in %v\n", len(g.nodes), len(g.edges), g.arch, from, to, out)
+	write(g, to, out)
+}
+
+// fill in the service, region and zone of a node from its name if the format didn't have them. Names are arch.region.zone.instance
+// unless they were collapsed with -f, and the service is the instance with its number taken off
+func (n *node) fill() {
+	parts := strings.Split(n.name, ".")
+	if len(parts) == 4 {
+		if n.region == "" {
+			n.region = names.Region(n.name)
+		}
+		if n.zone == "" {
+			n.zone = names.Zone(n.name)
+		}
+	}
+	if n.service == "" {
+		n.service = strings.TrimRight(parts[len(parts)-1], "0123456789")
+	}
+}
+
+// splitTags undoes the key=value,key=value tags of GraphML and GEXF
+func splitTags(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	tags := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if p := strings.SplitN(kv, "=", 2); len(p) == 2 {
+			tags[p[0]] = p[1]
+		}
+	}
+	return tags
+}
+
+// readGraphJSON replays a GraphJSON file to the nodes and edges left at the end, as graphdelta does, so forgotten edges and
+// nodes that are done, with their edges, aren't carried over into formats that can't remove them
+func readGraphJSON(fn string) *graph {
+	gj := graphjson.ReadGraph(fn)
+	s := graphjson.MakeSnapshot(gj)
+	g := &graph{arch: gj.Arch}
+	var ns []string
+	for n := range s.Nodes {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	for _, n := range ns {
+		nd := s.Nodes[n]
+		t, _ := time.Parse(time.RFC3339Nano, nd.Tstamp)
+		g.nodes = append(g.nodes, node{name: n, pack: nd.Package, tags: nd.Tags, t: t})
+	}
+	var es []string
+	for e := range s.Edges {
+		es = append(es, e)
+	}
+	sort.Strings(es)
+	for _, e := range es {
+		t, _ := time.Parse(time.RFC3339Nano, s.Edges[e].Tstamp)
+		g.edges = append(g.edges, edge{e, t})
+	}
+	return g
+}
+
+type xmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlFile struct {
+	Graph struct {
+		Data  []xmlData `xml:"data"`
+		Nodes []struct {
+			ID   string    `xml:"id,attr"`
+			Data []xmlData `xml:"data"`
+		} `xml:"node"`
+		Edges []struct {
+			Source string    `xml:"source,attr"`
+			Target string    `xml:"target,attr"`
+			Data   []xmlData `xml:"data"`
+		} `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// readGraphML reads the nodes and edges of a GraphML file, the package of each node is in its service data, and the times they
+// were made are kept if the file has them
+func readGraphML(fn string) *graph {
+	data, err := graphjson.ReadFile(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var gf graphmlFile
+	if err := xml.Unmarshal(data, &gf); err != nil {
+		log.Fatal(fn, ": ", err)
+	}
+	g := &graph{}
+	for _, d := range gf.Graph.Data {
+		if d.Key == "run" {
+			var run archaius.RunInfo
+			json.Unmarshal([]byte(d.Value), &run)
+			g.arch = run.Arch
+		}
+	}
+	for _, n := range gf.Graph.Nodes {
+		nd := node{name: n.ID}
+		for _, d := range n.Data {
+			switch d.Key {
+			case "service":
+				nd.pack = d.Value
+			case "tags":
+				nd.tags = splitTags(d.Value)
+			case "timestamp":
+				nd.t, _ = time.Parse(time.RFC3339Nano, d.Value)
+			}
+		}
+		g.nodes = append(g.nodes, nd)
+	}
+	for _, e := range gf.Graph.Edges {
+		ed := edge{fromTo: e.Source + " " + e.Target}
+		for _, d := range e.Data {
+			if d.Key == "timestamp" {
+				ed.t, _ = time.Parse(time.RFC3339Nano, d.Value)
+			}
+		}
+		g.edges = append(g.edges, ed)
+	}
+	return g
+}
+
+type attvalue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfFile struct {
+	Description string `xml:"meta>description"`
+	Nodes       []struct {
+		ID        string     `xml:"id,attr"`
+		Attvalues []attvalue `xml:"attvalues>attvalue"`
+	} `xml:"graph>nodes>node"`
+	Edges []struct {
+		Source    string     `xml:"source,attr"`
+		Target    string     `xml:"target,attr"`
+		Attvalues []attvalue `xml:"attvalues>attvalue"`
+	} `xml:"graph>edges>edge"`
+}
+
+// readGEXF reads the nodes, their attributes and the edges of a GEXF file, with the times they were made and the calls along the
+// edges if it has them
+func readGEXF(fn string) *graph {
+	data, err := graphjson.ReadFile(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var gf gexfFile
+	if err := xml.Unmarshal(data, &gf); err != nil {
+		log.Fatal(fn, ": ", err)
+	}
+	g := &graph{arch: gf.Description}
+	for _, n := range gf.Nodes {
+		nd := node{name: n.ID}
+		for _, a := range n.Attvalues {
+			switch a.For {
+			case "service":
+				nd.service = a.Value
+			case "package":
+				nd.pack = a.Value
+			case "region":
+				nd.region = a.Value
+			case "zone":
+				nd.zone = a.Value
+			case "tags":
+				nd.tags = splitTags(a.Value)
+			case "timestamp":
+				nd.t, _ = time.Parse(time.RFC3339Nano, a.Value)
+			}
+		}
+		g.nodes = append(g.nodes, nd)
+	}
+	for _, e := range gf.Edges {
+		ed := edge{fromTo: e.Source + " " + e.Target}
+		for _, a := range e.Attvalues {
+			if a.For == "timestamp" {
+				ed.t, _ = time.Parse(time.RFC3339Nano, a.Value)
+			}
+			if a.For != "calls" {
+				continue
+			}
+			if g.calls == nil {
+				g.calls = make(map[string]map[string]int)
+			}
+			if g.calls[e.Source] == nil {
+				g.calls[e.Source] = make(map[string]int)
+			}
+			var n int
+			fmt.Sscanf(a.Value, "%d", &n)
+			g.calls[e.Source][e.Target] = n
+		}
+		g.edges = append(g.edges, ed)
+	}
+	return g
+}
+
+// write the graph with the writer for the format, nodes first so every edge joins nodes that are already there
+func write(g *graph, to, out string) {
+	archaius.Conf.Arch = g.arch // recorded in the run metadata and the gexf description
+	now := time.Now()
+	switch to {
+	case "graphjson":
+		graphjson.SetupFile(out, g.arch)
+	case "graphml":
+		graphml.SetupFile(out)
+	case "gexf":
+		graphgexf.SetupFile(out)
+	}
+	for _, n := range g.nodes {
+		n.fill()
+		switch to {
+		case "graphjson":
+			t := n.t
+			if t.IsZero() {
+				t = now
+			}
+			graphjson.WriteNode(n.name+" "+n.pack, n.tags, t)
+		case "graphml":
+			graphml.WriteNode(n.name+" "+n.pack, n.tags, n.t)
+		case "gexf":
+			graphgexf.WriteNode(n.name, n.service, n.pack, n.region, n.zone, n.tags, n.t)
+		}
+	}
+	for _, e := range g.edges {
+		switch to {
+		case "graphjson":
+			t := e.t
+			if t.IsZero() {
+				t = now
+			}
+			graphjson.WriteEdge(e.fromTo, t)
+		case "graphml":
+			graphml.WriteEdge(e.fromTo, e.t)
+		case "gexf":
+			graphgexf.WriteEdge(e.fromTo, e.t)
+		}
+	}
+	switch to {
+	case "graphjson":
+		graphjson.Close()
+	case "graphml":
+		graphml.Close()
+	case "gexf":
+		graphgexf.Close(g.calls)
+	}
+}
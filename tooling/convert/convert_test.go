@@ -0,0 +1,77 @@
+package convert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testJSON = `{
+  "arch":"test",
+  "version":"spigo-0.4",
+  "args":"[spigo -j]",
+  "graph":[
+    {"node":"test.us-east-1.zoneA.web00","package":"karyon","timestamp":"2016-04-20T11:35:16.2225Z","tags":{"team":"edge"}},
+    {"node":"test.us-east-1.zoneB.store01","package":"staash","timestamp":"2016-04-20T11:35:16.2226Z"},
+    {"node":"test.us-east-1.zoneC.old02","package":"karyon","timestamp":"2016-04-20T11:35:16.2227Z"},
+    {"node":"test.us-east-1.zoneC.gone03","package":"staash","timestamp":"2016-04-20T11:35:16.2227Z"},
+    {"edge":"e1","source":"test.us-east-1.zoneA.web00","target":"test.us-east-1.zoneB.store01","timestamp":"2016-04-20T11:35:16.2228Z"},
+    {"edge":"e2","source":"test.us-east-1.zoneA.web00","target":"test.us-east-1.zoneC.old02","timestamp":"2016-04-20T11:35:16.2229Z"},
+    {"forget":"e2","source":"test.us-east-1.zoneA.web00","target":"test.us-east-1.zoneC.old02","timestamp":"2016-04-20T11:35:16.2230Z"},
+    {"edge":"e3","source":"test.us-east-1.zoneA.web00","target":"test.us-east-1.zoneC.gone03","timestamp":"2016-04-20T11:35:16.2230Z"},
+    {"done":"test.us-east-1.zoneC.old02","exit":"normal","timestamp":"2016-04-20T11:35:16.2231Z"},
+    {"done":"test.us-east-1.zoneC.gone03","exit":"normal","timestamp":"2016-04-20T11:35:16.2232Z"}
+  ]
+}`
+
+// convert the graph through every format and back, checking what's left at the end of the run survives with its attributes and
+// times, and a done node takes the edges it wasn't told to forget with it
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "test.json")
+	ioutil.WriteFile(in, []byte(testJSON), 0644)
+	if Format(in+".gz") != "graphjson" || Format("x.graphml") != "graphml" || Format("x.gexf") != "gexf" || Format("x.csv") != "" {
+		t.Error("formats not recognized")
+	}
+	if fn := OutputFile(in, "graphml"); fn != filepath.Join(dir, "test.graphml") {
+		t.Error("output file", fn)
+	}
+	gml := filepath.Join(dir, "test.graphml.gz")
+	Convert(in, "graphml", gml)
+	gexf := filepath.Join(dir, "test.gexf")
+	Convert(gml, "gexf", gexf)
+	out := filepath.Join(dir, "out.json")
+	Convert(gexf, "graphjson", out)
+	for _, g := range []*graph{readGraphML(gml), readGEXF(gexf), readGraphJSON(out)} {
+		if g.arch != "test" {
+			t.Errorf("arch %q", g.arch)
+		}
+		if len(g.nodes) != 2 || len(g.edges) != 1 {
+			t.Fatalf("%v nodes and %v edges, the forgotten edge and done nodes should be gone", len(g.nodes), len(g.edges))
+		}
+		web := g.nodes[0]
+		if web.name != "test.us-east-1.zoneA.web00" || web.pack != "karyon" || web.tags["team"] != "edge" {
+			t.Errorf("node %+v", web)
+		}
+		if g.edges[0].fromTo != "test.us-east-1.zoneA.web00 test.us-east-1.zoneB.store01" {
+			t.Errorf("edge %v", g.edges[0].fromTo)
+		}
+		if web.t.Format("15:04:05.0000") != "11:35:16.2225" || g.edges[0].t.Format("15:04:05.0000") != "11:35:16.2228" {
+			t.Errorf("node made at %v and edge at %v", web.t, g.edges[0].t)
+		}
+	}
+	g := readGEXF(gexf)
+	if n := g.nodes[1]; n.service != "store" || n.region != "us-east-1" || n.zone != "zoneB" {
+		t.Errorf("gexf attributes %+v", n)
+	}
+	again := filepath.Join(dir, "again.json")
+	Convert(in, "graphjson", again)
+	if g := readGraphJSON(again); g.nodes[0].t.Format("15:04:05.0000") != "11:35:16.2225" {
+		t.Error("node timestamp", g.nodes[0].t)
+	}
+}
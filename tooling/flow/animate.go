@@ -0,0 +1,145 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/graphjson"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// bySend sortable activations
+type bySend []graphjson.ActivationV0r1
+
+func (a bySend) Len() int           { return len(a) }
+func (a bySend) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySend) Less(i, j int) bool { return a[i].Send < a[j].Send }
+
+// animation joins the spans of every trace to the edges between the hosts that made and answered each call
+func animation() graphjson.AnimationV0r1 {
+	a := graphjson.AnimationV0r1{Arch: archaius.Conf.Arch, Version: "animate-0.1", Args: fmt.Sprintf("%v", os.Args)}
+	run := archaius.Run()
+	a.Run = &run
+	var start, end int64
+	for _, trace := range flowmap {
+		for _, s := range trace {
+			if start == 0 || s.Timestamp < start {
+				start = s.Timestamp
+			}
+			if s.Timestamp > end {
+				end = s.Timestamp
+			}
+		}
+	}
+	ms := func(ns int64) float64 { return float64(ns-start) / float64(time.Millisecond) }
+	nodes := make(map[string]string) // a host for each node, named as they are in the graph outputs
+	edges := make(map[string]string) // edge id by space separated source and target
+	var traces []int
+	for t := range flowmap {
+		traces = append(traces, int(t))
+	}
+	sort.Ints(traces) // same edge ids for the same flows
+	for _, t := range traces {
+		trace := make([]*spannotype, len(flowmap[gotocol.TraceContextType(t)]))
+		copy(trace, flowmap[gotocol.TraceContextType(t)])
+		sort.Sort(ByCtx(trace))
+		spans := make(map[string]map[string]*spannotype) // annotations by value, by span context
+		var order []string
+		for _, s := range trace {
+			if spans[s.Ctx] == nil {
+				spans[s.Ctx] = make(map[string]*spannotype)
+				order = append(order, s.Ctx)
+			}
+			if spans[s.Ctx][s.Value] == nil {
+				spans[s.Ctx][s.Value] = s
+			}
+		}
+		for _, ctx := range order {
+			s := spans[ctx]
+			cs, sr := s[CS.String()], s[SR.String()]
+			if cs == nil || sr == nil {
+				continue // not a call between two nodes
+			}
+			source, target := names.FilterNode(cs.Host), names.FilterNode(sr.Host)
+			nodes[source], nodes[target] = cs.Host, sr.Host
+			id, ok := edges[source+" "+target]
+			if !ok {
+				id = fmt.Sprintf("e%v", len(edges))
+				edges[source+" "+target] = id
+			}
+			e := graphjson.ActivationV0r1{Edge: id, Trace: fmt.Sprintf("%v", t), Send: ms(cs.Timestamp), Arrive: ms(sr.Timestamp)}
+			if ss := s[SS.String()]; ss != nil {
+				e.Reply = ms(ss.Timestamp)
+			}
+			if cr := s[CR.String()]; cr != nil {
+				e.Return = ms(cr.Timestamp)
+				e.Latency = e.Return - e.Send
+				e.Failed = gotocol.Failed(cr.Intent)
+			}
+			a.Events = append(a.Events, e)
+		}
+	}
+	sort.Stable(bySend(a.Events))
+	var ns []string
+	for n := range nodes {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	for _, n := range ns {
+		host := nodes[n]
+		a.Nodes = append(a.Nodes, graphjson.NodeV0r4{Node: n, Package: names.Package(host), Tags: archaius.Service(names.Service(host)).Tags})
+	}
+	var es []string
+	for st := range edges {
+		es = append(es, st)
+	}
+	sort.Strings(es)
+	for _, st := range es {
+		var e graphjson.EdgeV0r4
+		fmt.Sscanf(st, "%s%s", &e.Source, &e.Target)
+		e.Edge = edges[st]
+		a.Edges = append(a.Edges, e)
+	}
+	if start > 0 {
+		a.Start = time.Unix(0, start).Format(time.RFC3339Nano)
+		a.Duration = ms(end)
+		for _, m := range collect.Timeline() {
+			a.Marks = append(a.Marks, graphjson.MarkV0r1{At: ms(m.At().UnixNano()), Kind: m.Kind, Detail: m.Detail})
+		}
+	}
+	return a
+}
+
+// WriteAnimation writes the graph and the calls over it to json/<arch>_animate.json, the caller holds flowlock
+func WriteAnimation() {
+	if !archaius.Conf.Animate {
+		return
+	}
+	a := animation()
+	fn := "json/" + archaius.Conf.Arch + "_animate.json"
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	log.Printf("Writing animation of %v calls over %v edges to %v\n", len(a.Events), len(a.Edges), fn)
+	j, err := json.Marshal(a)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f.Write(j)
+	f.WriteString("\n")
+	collect.Summarize("animate", struct {
+		File   string `json:"file"`
+		Nodes  int    `json:"nodes"`
+		Edges  int    `json:"edges"`
+		Events int    `json:"events"`
+	}{fn, len(a.Nodes), len(a.Edges), len(a.Events)})
+}
@@ -0,0 +1,129 @@
+package flow
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+)
+
+// BreakdownSegment is the latency a service added to the requests to an entry point on their critical path, in milliseconds,
+// the time spent between the instances is a segment called network
+type BreakdownSegment struct {
+	Service string  `json:"service"`
+	Mean    float64 `json:"meanms"`
+	P99     float64 `json:"p99ms"` // share of the p99 from the slowest 1% of the requests
+}
+
+// Breakdown of the end to end latency of an entry point into what each service contributed, as two stacked bars, the segments
+// add up to the mean and to the p99, largest mean first
+type Breakdown struct {
+	Entry    string             `json:"entry"`
+	Traces   int                `json:"traces"`
+	Mean     float64            `json:"meanms"`
+	P99      float64            `json:"p99ms"`
+	Services []BreakdownSegment `json:"services"`
+}
+
+type bySegment []BreakdownSegment
+
+func (b bySegment) Len() int      { return len(b) }
+func (b bySegment) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b bySegment) Less(i, j int) bool {
+	if b[i].Mean != b[j].Mean {
+		return b[i].Mean > b[j].Mean
+	}
+	return b[i].Service < b[j].Service
+}
+
+type byCriticalTotal []CriticalPath
+
+func (b byCriticalTotal) Len() int           { return len(b) }
+func (b byCriticalTotal) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byCriticalTotal) Less(i, j int) bool { return b[i].Total < b[j].Total }
+
+// breakdown works out the critical path of every completed trace and averages what each service contributed for each entry
+// point, the service of the root span. For the p99 the contributions are averaged over the traces at or above it instead,
+// and scaled so they add up to it
+func breakdown(traces map[gotocol.TraceContextType][]*spannotype) []Breakdown {
+	paths := make(map[string][]CriticalPath) // by entry point
+	for t, trace := range traces {
+		cp, ok := criticalPath(t, trace)
+		if !ok {
+			continue
+		}
+		paths[cp.Path[0].Service] = append(paths[cp.Path[0].Service], cp)
+	}
+	var entries []string
+	for e := range paths {
+		entries = append(entries, e)
+	}
+	sort.Strings(entries)
+	bd := []Breakdown{}
+	for _, e := range entries {
+		cps := paths[e]
+		sort.Sort(byCriticalTotal(cps))
+		p99 := cps[int(math.Ceil(0.99*float64(len(cps))))-1].Total
+		mean := make(map[string]float64)
+		tail := make(map[string]float64)
+		var total, tailTotal float64
+		for _, cp := range cps {
+			total += cp.Total
+			for svc, d := range cp.Services {
+				mean[svc] += d
+			}
+			mean["network"] += cp.Network
+			if cp.Total < p99 {
+				continue
+			}
+			tailTotal += cp.Total
+			for svc, d := range cp.Services {
+				tail[svc] += d
+			}
+			tail["network"] += cp.Network
+		}
+		b := Breakdown{Entry: e, Traces: len(cps), Mean: total / float64(len(cps)), P99: p99}
+		for svc, d := range mean {
+			s := BreakdownSegment{Service: svc, Mean: d / float64(len(cps))}
+			if tailTotal > 0 {
+				s.P99 = tail[svc] / tailTotal * p99
+			}
+			b.Services = append(b.Services, s)
+		}
+		sort.Sort(bySegment(b.Services))
+		bd = append(bd, b)
+	}
+	return bd
+}
+
+// WriteBreakdown writes how much each service contributed to the mean and p99 latency of each entry point, across all the
+// completed traces, to json_metrics/<arch>_breakdown.json, and to the breakdown section of the summary
+func WriteBreakdown() {
+	if !archaius.Conf.LatencyBreakdown {
+		return
+	}
+	bd := breakdown(flowmap)
+	traces := 0
+	for _, b := range bd {
+		traces += b.Traces
+	}
+	fn := "json_metrics/" + archaius.Conf.Arch + "_breakdown.json"
+	log.Printf("Writing latency breakdown of %v traces from %v entry points to %v\n", traces, len(bd), fn)
+	j, err := json.Marshal(bd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fn, append(j, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+	collect.Summarize("breakdown", struct {
+		File        string      `json:"file"`
+		Traces      int         `json:"traces"`
+		Entrypoints []Breakdown `json:"entrypoints"`
+	}{fn, traces, bd})
+}
@@ -0,0 +1,108 @@
+package flow
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/dhcp"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// Call is a call over an edge that got a response, as the callbacks registered for the edge see it when its flow is written
+type Call struct {
+	Span     string        // context of the span, e.g. t1p2s3
+	Caller   string        // instance names
+	Callee   string        // empty if the callee didn't annotate the span, e.g. an answer from the idempotency cache
+	Start    time.Time     // client send
+	Latency  time.Duration // client send to client receive
+	Service  time.Duration // server receive to server send, zero if the callee didn't annotate both
+	Response string        // body of the response
+	Failed   bool
+	Baggage  string
+}
+
+// Callback derives custom attributes from a call, such as a latency bucket or a computed tag, as key value pairs that are added
+// to its span in the flows as binary annotations
+type Callback func(c Call) map[string]string
+
+// callback registered for the calls between two services that succeed or fail
+type callback struct {
+	from, to string
+	failed   bool
+	cb       Callback
+}
+
+var callbacks []callback
+var callbackLock sync.RWMutex
+
+// OnSuccess registers a callback for the calls from one service to another that succeed, either can be * for any service.
+// Callbacks run as the flows are written at the end of the run, or each time a run that goes on forever rolls them, so they
+// never hold up the messages, and they see every call that is still in the flows at that point
+func OnSuccess(from, to string, cb Callback) {
+	register(callback{from, to, false, cb})
+}
+
+// OnError registers a callback for the calls from one service to another that fail, either can be * for any service
+func OnError(from, to string, cb Callback) {
+	register(callback{from, to, true, cb})
+}
+
+func register(c callback) {
+	callbackLock.Lock()
+	callbacks = append(callbacks, c)
+	callbackLock.Unlock()
+}
+
+// called runs the callbacks registered for the call the annotations of a span are for, and returns what they added sorted by
+// key, with the caller as the endpoint. Spans without a client send and receive didn't get a response, so they're left alone
+func called(span []*spannotype) []zipkinbinaryannotation {
+	callbackLock.RLock()
+	defer callbackLock.RUnlock()
+	if len(callbacks) == 0 {
+		return nil
+	}
+	var cs, sr, ss, cr *spannotype
+	for _, a := range span { // the first of each, as in the chrome trace
+		switch {
+		case a.Value == CS.String() && cs == nil:
+			cs = a
+		case a.Value == SR.String() && sr == nil:
+			sr = a
+		case a.Value == SS.String() && ss == nil:
+			ss = a
+		case a.Value == CR.String() && cr == nil:
+			cr = a
+		}
+	}
+	if cs == nil || cr == nil {
+		return nil
+	}
+	c := Call{Span: cs.Ctx, Caller: cs.Host, Start: time.Unix(0, cs.Timestamp), Latency: time.Duration(cr.Timestamp - cs.Timestamp),
+		Response: cr.Intent, Failed: gotocol.Failed(cr.Intent), Baggage: cs.Baggage}
+	if sr != nil {
+		c.Callee = sr.Host
+		if ss != nil {
+			c.Service = time.Duration(ss.Timestamp - sr.Timestamp)
+		}
+	}
+	attrs := make(map[string]string)
+	for _, r := range callbacks {
+		if r.failed == c.Failed && (r.from == "*" || r.from == names.Service(c.Caller)) && (r.to == "*" || c.Callee != "" && r.to == names.Service(c.Callee)) {
+			for k, v := range r.cb(c) {
+				attrs[k] = v
+			}
+		}
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var bas []zipkinbinaryannotation
+	for _, k := range keys {
+		bas = append(bas, zipkinbinaryannotation{k, attrs[k], zipkinendpoint{c.Caller, dhcp.Lookup(c.Caller), 8080}})
+	}
+	return bas
+}
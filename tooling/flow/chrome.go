@@ -0,0 +1,141 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// chromeEvent is one entry in the Chrome trace_event format, times are in microseconds
+type chromeEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat,omitempty"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	S    string            `json:"s,omitempty"` // scope of instant events
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// chromeTracks maps each service to a process and each instance to a thread in that process
+type chromeTracks struct {
+	pids     map[string]int
+	tids     map[string]int
+	metadata []chromeEvent
+}
+
+func (c *chromeTracks) track(host string) (int, int) {
+	service := names.Service(host)
+	if service == "" {
+		service = host
+	}
+	pid, ok := c.pids[service]
+	if !ok {
+		pid = len(c.pids) + 1
+		c.pids[service] = pid
+		c.metadata = append(c.metadata, chromeEvent{Name: "process_name", Ph: "M", Pid: pid, Args: map[string]string{"name": service}})
+	}
+	tid, ok := c.tids[host]
+	if !ok {
+		tid = len(c.tids) + 1
+		c.tids[host] = tid
+		c.metadata = append(c.metadata, chromeEvent{Name: "thread_name", Ph: "M", Pid: pid, Tid: tid, Args: map[string]string{"name": participant(host)}})
+	}
+	return pid, tid
+}
+
+// chromeEvents turns the flows into a complete event for the client side, cs to cr, and the server side, sr to ss, of each span.
+// Fail fast annotations and spans that never finished are instant events, and the timeline events are global instant events across every track
+func chromeEvents() []chromeEvent {
+	var traces []int
+	var start int64
+	for t, trace := range flowmap {
+		traces = append(traces, int(t))
+		for _, a := range trace {
+			if start == 0 || a.Timestamp < start {
+				start = a.Timestamp
+			}
+		}
+	}
+	sort.Ints(traces) // same layout for the same flows
+	us := func(ns int64) float64 { return float64(ns-start) / 1000 }
+	tracks := &chromeTracks{make(map[string]int), make(map[string]int), nil}
+	var events []chromeEvent
+	for _, t := range traces {
+		trace := make([]*spannotype, len(flowmap[gotocol.TraceContextType(t)]))
+		copy(trace, flowmap[gotocol.TraceContextType(t)])
+		sort.Sort(ByCtx(trace))
+		spans := make(map[string]map[string]*spannotype) // annotations by value, by span context
+		var order []string
+		for _, a := range trace {
+			if spans[a.Ctx] == nil {
+				spans[a.Ctx] = make(map[string]*spannotype)
+				order = append(order, a.Ctx)
+			}
+			if spans[a.Ctx][a.Value] == nil { // keep the first of each
+				spans[a.Ctx][a.Value] = a
+			}
+		}
+		for _, ctx := range order {
+			s := spans[ctx]
+			args := map[string]string{"trace": fmt.Sprintf("%v", t), "span": ctx}
+			for _, side := range [][2]Values{{CS, CR}, {SR, SS}} {
+				begin, end := s[side[0].String()], s[side[1].String()]
+				if begin == nil {
+					continue
+				}
+				pid, tid := tracks.track(begin.Host)
+				e := chromeEvent{Name: begin.Imp, Cat: side[0].String(), Ts: us(begin.Timestamp), Pid: pid, Tid: tid, Args: args}
+				if end != nil && end.Timestamp >= begin.Timestamp {
+					e.Ph = "X"
+					e.Dur = us(end.Timestamp) - e.Ts
+				} else {
+					e.Ph, e.S = "i", "t"
+				}
+				events = append(events, e)
+			}
+			if ff := s[FF.String()]; ff != nil {
+				pid, tid := tracks.track(ff.Host)
+				events = append(events, chromeEvent{Name: "failfast " + ff.Imp, Cat: FF.String(), Ph: "i", Ts: us(ff.Timestamp), Pid: pid, Tid: tid, S: "t", Args: args})
+			}
+		}
+	}
+	for _, e := range collect.Timeline() {
+		events = append(events, chromeEvent{Name: e.Kind + " " + e.Detail, Cat: "timeline", Ph: "i", Ts: us(e.At().UnixNano()), S: "g"})
+	}
+	return append(tracks.metadata, events...)
+}
+
+// WriteChrome writes the flows to traces/<arch>_chrome.json in the trace_event format for chrome://tracing and Perfetto
+func WriteChrome() {
+	if !archaius.Conf.ChromeTrace {
+		return
+	}
+	events := chromeEvents()
+	fn := "traces/" + archaius.Conf.Arch + "_chrome.json"
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	log.Printf("Writing chrome trace to %v\n", fn)
+	j, err := json.Marshal(events)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f.Write(j)
+	f.WriteString("\n")
+	collect.Summarize("chrometrace", struct {
+		File   string `json:"file"`
+		Events int    `json:"events"`
+	}{fn, len(events)})
+}
@@ -0,0 +1,88 @@
+package flow
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultZipkinPath is where a Zipkin collector accepts the v1 json spans that the flows are written in, used if the url
+// doesn't have a path
+const defaultZipkinPath = "/api/v1/spans"
+
+// httpBatch is the number of spans posted in each request, and httpTimeout how long to wait for the collector to answer
+const (
+	httpBatch   = 100
+	httpTimeout = 10 * time.Second
+)
+
+// HTTPCollector implements Collector by posting batches of spans to a Zipkin
+// compatible collector over HTTP.
+type HTTPCollector struct {
+	url    string
+	client *http.Client
+	batch  [][]byte // json of each span without the array brackets
+	posted int
+	failed int
+}
+
+// NewHTTPCollector returns a new HTTP-backed Collector. addr is the url of
+// the collector, such as "http://localhost:9411/api/v1/spans".
+func NewHTTPCollector(addr string) (*HTTPCollector, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultZipkinPath
+	}
+	return &HTTPCollector{
+		url:    u.String(),
+		client: &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+// Collect implements Collector, it's given a json array of spans and posts
+// them when there is a full batch.
+func (c *HTTPCollector) Collect(s []byte) {
+	if len(s) < 2 {
+		return
+	}
+	c.batch = append(c.batch, s[1:len(s)-1])
+	if len(c.batch) >= httpBatch {
+		c.post()
+	}
+}
+
+// post sends the batch as one json array, a failure is logged and the spans
+// are dropped rather than stopping the run
+func (c *HTTPCollector) post() {
+	if len(c.batch) == 0 {
+		return
+	}
+	n := len(c.batch)
+	body := append(append([]byte("["), bytes.Join(c.batch, []byte(","))...), ']')
+	c.batch = c.batch[:0]
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Zipkin Error: %v spans not sent to %v: %v\n", n, c.url, err)
+		c.failed += n
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Zipkin Error: %v from %v for %v spans\n", resp.Status, c.url, n)
+		c.failed += n
+		return
+	}
+	c.posted += n
+}
+
+// Close implements Collector, posting the last partial batch.
+func (c *HTTPCollector) Close() error {
+	c.post()
+	log.Printf("Posted %v spans to %v, %v failed\n", c.posted, c.url, c.failed)
+	return nil
+}
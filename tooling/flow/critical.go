@@ -0,0 +1,204 @@
+package flow
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// CriticalSegment is a span on the critical path of a trace, times are in milliseconds
+type CriticalSegment struct {
+	Service  string  `json:"service"`
+	Instance string  `json:"instance"`
+	Span     string  `json:"span"`
+	Self     float64 `json:"selfms"`    // time the called instance spent working rather than waiting for a call on the path
+	Network  float64 `json:"networkms"` // time the request and response of the span spent between the caller and the called instance
+}
+
+// CriticalPath is the sequence of spans that determined the end to end latency of a trace, root first
+type CriticalPath struct {
+	Trace    gotocol.TraceContextType `json:"trace"`
+	Total    float64                  `json:"totalms"`
+	Network  float64                  `json:"networkms"`
+	Services map[string]float64       `json:"services"` // milliseconds of the total contributed by each service
+	Path     []CriticalSegment        `json:"path"`
+}
+
+// CriticalStats is how much a service contributed to the critical paths of all the traces
+type CriticalStats struct {
+	Traces int     `json:"traces"` // on the critical path of
+	Total  float64 `json:"totalms"`
+	Share  float64 `json:"share"` // fraction of the critical path time of every trace
+}
+
+// criticalSpan is the annotations of one span, and the spans it called
+type criticalSpan struct {
+	ctx            string
+	cs, sr, ss, cr *spannotype
+	children       []*criticalSpan
+}
+
+// spanParent splits a span context tXpYsZ into the span id Z and parent id Y
+func spanParent(ctx string) (span, parent string) {
+	s := strings.SplitAfter(ctx, "s")                              // tXpYsZ -> [tXpYs, Z]
+	p := strings.TrimSuffix(strings.SplitAfter(s[0], "p")[1], "s") // tXpYs -> [tXp, Ys] -> Ys -> Y
+	return s[1], p
+}
+
+// criticalTree joins the annotations of a trace into spans with their children, and returns the root span, or nil if the
+// trace has no root that was answered. Only the first of each annotation value in a span is used, as in the chrome trace
+func criticalTree(trace []*spannotype) *criticalSpan {
+	spans := make(map[string]*criticalSpan) // by span id
+	var order []*criticalSpan
+	for _, a := range trace {
+		id, _ := spanParent(a.Ctx)
+		s := spans[id]
+		if s == nil {
+			s = &criticalSpan{ctx: a.Ctx}
+			spans[id] = s
+			order = append(order, s)
+		}
+		switch a.Value {
+		case CS.String():
+			if s.cs == nil {
+				s.cs = a
+			}
+		case SR.String():
+			if s.sr == nil {
+				s.sr = a
+			}
+		case SS.String():
+			if s.ss == nil {
+				s.ss = a
+			}
+		case CR.String():
+			if s.cr == nil {
+				s.cr = a
+			}
+		}
+	}
+	var root *criticalSpan
+	for _, s := range order {
+		_, p := spanParent(s.ctx)
+		if p == "0" {
+			root = s
+		} else if parent := spans[p]; parent != nil && s.cs != nil && s.sr != nil && s.ss != nil && s.cr != nil {
+			parent.children = append(parent.children, s)
+		}
+	}
+	if root == nil || root.sr == nil || root.ss == nil {
+		return nil
+	}
+	return root
+}
+
+// walk adds a span to the critical path, then goes back from the end of its work through the call that returned last before
+// then, and on from the start of that call, so the calls on the path don't overlap and everything else is self time
+func (s *criticalSpan) walk(path []CriticalSegment) []CriticalSegment {
+	ms := func(ns int64) float64 { return float64(ns) / 1e6 }
+	i := len(path)
+	path = append(path, CriticalSegment{Service: names.Service(s.sr.Host), Instance: participant(s.sr.Host), Span: s.ctx})
+	if s.cs != nil && s.cr != nil {
+		path[i].Network = ms(s.sr.Timestamp - s.cs.Timestamp + s.cr.Timestamp - s.ss.Timestamp)
+	}
+	var self int64
+	cursor := s.ss.Timestamp
+	for {
+		var last *criticalSpan
+		for _, c := range s.children {
+			if c.cr.Timestamp <= cursor && c.cs.Timestamp >= s.sr.Timestamp && (last == nil || c.cr.Timestamp > last.cr.Timestamp) {
+				last = c
+			}
+		}
+		if last == nil {
+			break
+		}
+		self += cursor - last.cr.Timestamp
+		path = last.walk(path)
+		cursor = last.cs.Timestamp
+	}
+	if cursor > s.sr.Timestamp {
+		self += cursor - s.sr.Timestamp
+	}
+	path[i].Self = ms(self)
+	return path
+}
+
+// criticalPath works out the critical path of a trace, it returns false if the trace never completed
+func criticalPath(t gotocol.TraceContextType, trace []*spannotype) (CriticalPath, bool) {
+	root := criticalTree(trace)
+	if root == nil {
+		return CriticalPath{}, false
+	}
+	cp := CriticalPath{Trace: t, Services: make(map[string]float64), Path: root.walk(nil)}
+	for _, seg := range cp.Path {
+		cp.Services[seg.Service] += seg.Self
+		cp.Network += seg.Network
+		cp.Total += seg.Self + seg.Network
+	}
+	return cp, true
+}
+
+// WriteCritical writes the critical path of every completed trace to json_metrics/<arch>_critical.json, and how much each
+// service contributed to them to the critical section of the summary
+func WriteCritical() {
+	if !archaius.Conf.CriticalPath {
+		return
+	}
+	var traces []int
+	for t := range flowmap {
+		traces = append(traces, int(t))
+	}
+	sort.Ints(traces)
+	paths := []CriticalPath{}
+	stats := make(map[string]CriticalStats)
+	var total, network float64
+	for _, t := range traces {
+		cp, ok := criticalPath(gotocol.TraceContextType(t), flowmap[gotocol.TraceContextType(t)])
+		if !ok {
+			continue
+		}
+		paths = append(paths, cp)
+		total += cp.Total
+		network += cp.Network
+		for svc, d := range cp.Services {
+			s := stats[svc]
+			s.Traces++
+			s.Total += d
+			stats[svc] = s
+		}
+	}
+	for svc, s := range stats {
+		if total > 0 {
+			s.Share = s.Total / total
+		}
+		stats[svc] = s
+	}
+	fn := "json_metrics/" + archaius.Conf.Arch + "_critical.json"
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	log.Printf("Writing critical paths of %v traces to %v\n", len(paths), fn)
+	j, err := json.Marshal(paths)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f.Write(j)
+	f.WriteString("\n")
+	collect.Summarize("critical", struct {
+		File     string                   `json:"file"`
+		Traces   int                      `json:"traces"`
+		Total    float64                  `json:"totalms"`
+		Network  float64                  `json:"networkms"`
+		Services map[string]CriticalStats `json:"services"`
+	}{fn, len(paths), total, network, stats})
+}
@@ -0,0 +1,160 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// FlameNode is a service in the calls made from an entry point, totalled over all the traces that reached it by the same
+// sequence of calls, times are in milliseconds
+type FlameNode struct {
+	Service  string       `json:"service"`
+	Calls    int          `json:"calls"`
+	Self     float64      `json:"selfms"`    // time the service spent working rather than waiting for the calls it made
+	Network  float64      `json:"networkms"` // time the calls to it spent between the caller and the service
+	Total    float64      `json:"totalms"`   // from the calls being sent to their responses arriving, or the work of the entry point
+	Children []*FlameNode `json:"children,omitempty"`
+	byName   map[string]*FlameNode
+}
+
+// child finds or adds the node for calls made to a service from this one
+func (f *FlameNode) child(service string) *FlameNode {
+	if f.byName == nil {
+		f.byName = make(map[string]*FlameNode)
+	}
+	c := f.byName[service]
+	if c == nil {
+		c = &FlameNode{Service: service}
+		f.byName[service] = c
+		f.Children = append(f.Children, c)
+	}
+	return c
+}
+
+// add a span and the spans it called to the node. Self time is what's left of the work of the span once the calls it made are
+// taken off, and calls made in parallel can add up to more than the work, so it doesn't go below zero
+func (f *FlameNode) add(s *criticalSpan) {
+	ms := func(ns int64) float64 { return float64(ns) / 1e6 }
+	work := s.ss.Timestamp - s.sr.Timestamp
+	f.Calls++
+	if s.cs != nil && s.cr != nil {
+		f.Network += ms(s.cr.Timestamp - s.cs.Timestamp - work)
+		f.Total += ms(s.cr.Timestamp - s.cs.Timestamp)
+	} else {
+		f.Total += ms(work)
+	}
+	self := work
+	for _, c := range s.children {
+		self -= c.cr.Timestamp - c.cs.Timestamp
+		f.child(names.Service(c.sr.Host)).add(c)
+	}
+	if self > 0 {
+		f.Self += ms(self)
+	}
+}
+
+type byTotal []*FlameNode
+
+func (b byTotal) Len() int      { return len(b) }
+func (b byTotal) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byTotal) Less(i, j int) bool {
+	if b[i].Total != b[j].Total {
+		return b[i].Total > b[j].Total
+	}
+	return b[i].Service < b[j].Service
+}
+
+// sort the children by the time they took, longest first
+func (f *FlameNode) sort() {
+	sort.Sort(byTotal(f.Children))
+	for _, c := range f.Children {
+		c.sort()
+	}
+}
+
+// fold writes a line for the self time and the network time of each node as stacks of service names from the entry point, with
+// the time in microseconds as the count, the folded format read by flamegraph.pl and speedscope
+func (f *FlameNode) fold(stack []string, lines []string) []string {
+	stack = append(stack, f.Service)
+	frames := strings.Join(stack, ";")
+	if us := int64(f.Self * 1000); us > 0 {
+		lines = append(lines, fmt.Sprintf("%v %v", frames, us))
+	}
+	if us := int64(f.Network * 1000); us > 0 {
+		lines = append(lines, fmt.Sprintf("%v;network %v", frames, us))
+	}
+	for _, c := range f.Children {
+		lines = c.fold(stack, lines)
+	}
+	return lines
+}
+
+// flameGraph totals the completed traces into a tree of calls for each entry point service, the service of the root span
+func flameGraph(traces map[gotocol.TraceContextType][]*spannotype) map[string]*FlameNode {
+	roots := make(map[string]*FlameNode)
+	for _, trace := range traces {
+		root := criticalTree(trace)
+		if root == nil {
+			continue
+		}
+		entry := names.Service(root.sr.Host)
+		if roots[entry] == nil {
+			roots[entry] = &FlameNode{Service: entry}
+		}
+		roots[entry].add(root)
+	}
+	for _, r := range roots {
+		r.sort()
+	}
+	return roots
+}
+
+// WriteFlame totals the latency of the calls made from each entry point across all the completed traces, and writes it as folded
+// stacks to traces/<arch>_flame.folded and as a tree to json_metrics/<arch>_flame.json
+func WriteFlame() {
+	if !archaius.Conf.Flame {
+		return
+	}
+	roots := flameGraph(flowmap)
+	var entries []string
+	for e := range roots {
+		entries = append(entries, e)
+	}
+	sort.Strings(entries)
+	var lines []string
+	tree := []*FlameNode{}
+	calls := 0
+	for _, e := range entries {
+		lines = roots[e].fold(nil, lines)
+		tree = append(tree, roots[e])
+		calls += roots[e].Calls
+	}
+	fn := "traces/" + archaius.Conf.Arch + "_flame.folded"
+	log.Printf("Writing flame graph of %v traces from %v entry points to %v\n", calls, len(entries), fn)
+	if err := ioutil.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	jfn := "json_metrics/" + archaius.Conf.Arch + "_flame.json"
+	j, err := json.Marshal(tree)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(jfn, append(j, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+	collect.Summarize("flame", struct {
+		Folded  string   `json:"folded"`
+		File    string   `json:"file"`
+		Traces  int      `json:"traces"`
+		Entries []string `json:"entries"`
+	}{fn, jfn, calls, entries})
+}
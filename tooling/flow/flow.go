@@ -7,15 +7,18 @@ import (
 	"log"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/dhcp"
 	"github.com/adrianco/spigo/tooling/gotocol"
 	"github.com/adrianco/spigo/tooling/graphneo4j"
+	"github.com/adrianco/spigo/tooling/names"
 	"github.com/go-kit/kit/metrics/generic"
 )
 
@@ -28,6 +31,7 @@ const (
 	SR                    // server receive
 	SS                    // server send
 	CR                    // client receive
+	FF                    // fail fast, call skipped as it would exceed the request deadline or cross a partition
 	Unknown               // something went wrong
 )
 
@@ -42,6 +46,8 @@ func (v Values) String() string {
 		return "ss"
 	case CR:
 		return "cr"
+	case FF:
+		return "ff"
 	default:
 		return "unknown"
 	}
@@ -52,26 +58,46 @@ type flowmaptype map[gotocol.TraceContextType][]*spannotype
 
 // Annotation information for each step in the span
 type spannotype struct {
-	Ctx       string `json:"ctx"`        // Context as string
-	Host      string `json:"host"`       // host name
-	Imp       string `json:"imposition"` // protocol request type
-	Intent    string `json:"intention"`  // request body
-	Timestamp int64  `json:"ts"`         // unix nanotimestamp
-	Value     string `json:"value"`      // direction of span
+	Ctx       string `json:"ctx"`                // Context as string
+	Host      string `json:"host"`               // host name
+	Imp       string `json:"imposition"`         // protocol request type
+	Intent    string `json:"intention"`          // request body
+	Timestamp int64  `json:"ts"`                 // unix nanotimestamp
+	Value     string `json:"value"`              // direction of span
+	Baggage   string `json:"baggage,omitempty"`  // propagated key=value items
+	Dedup     string `json:"dedup,omitempty"`    // how a duplicate request was answered, from the idempotency cache or coalesced
+	Mesh      string `json:"mesh,omitempty"`     // overhead and retries of a call made through a service mesh sidecar
+	Degraded  string `json:"degraded,omitempty"` // dependency whose failure was replaced by a fallback response
+	Page      string `json:"page,omitempty"`     // which page of a paginated call the span fetched, e.g. 2/3
+	Flag      string `json:"flag,omitempty"`     // feature flag the call was routed by and its state, e.g. newrecs=on
+	Saga      string `json:"saga,omitempty"`     // step of a saga the call does or compensates, e.g. compensate 1/3
+	Batch     string `json:"batch,omitempty"`    // calls a batch call carries, or the batch call a call went in
+	Phase     string `json:"phase,omitempty"`    // phase of a two phase commit the call is, e.g. prepare 2/3
+	Breaker   string `json:"breaker,omitempty"`  // state of the circuit breaker of the edge the call was made on, e.g. open
+	Topic     string `json:"topic,omitempty"`    // where a message is in a topic, and the consumer group it's delivered to, e.g. 2:37 billing
 }
 
 // ByCtx sortable spans
 type ByCtx []*spannotype
 
-func (a ByCtx) Len() int             { return len(a) }
-func (a ByCtx) Swap(i, j int)        { a[i], a[j] = a[j], a[i] }
-func (a ByCtx) Less(i, j int) bool { // sort by span first then time
-	if a[i].Ctx == a[j].Ctx {
+func (a ByCtx) Len() int      { return len(a) }
+func (a ByCtx) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByCtx) Less(i, j int) bool { // sort by span first then time, then in the order of a call for the same time, then host
+	if a[i].Ctx != a[j].Ctx {
+		return a[i].Ctx < a[j].Ctx
+	}
+	if a[i].Timestamp != a[j].Timestamp {
 		return a[i].Timestamp < a[j].Timestamp
 	}
-	return a[i].Ctx < a[j].Ctx
+	if valueOrder[a[i].Value] != valueOrder[a[j].Value] {
+		return valueOrder[a[i].Value] < valueOrder[a[j].Value]
+	}
+	return a[i].Host < a[j].Host
 }
 
+// valueOrder is the order of the annotations of a span, on a virtual clock they often have the same time
+var valueOrder = map[string]int{CS.String(): 0, FF.String(): 0, SR.String(): 1, SS.String(): 2, CR.String(): 3}
+
 var flowmap flowmaptype
 
 var flowlock sync.Mutex // lock changes to the maps
@@ -79,7 +105,68 @@ var flowlock sync.Mutex // lock changes to the maps
 // file to log flow data to
 var file *os.File
 
-var collector *KafkaCollector
+// Collector is somewhere the zipkin spans are sent as they are flushed, as well as the flow file
+type Collector interface {
+	Collect(s []byte) // a json array of spans
+	Close() error
+}
+
+var collectors []Collector
+
+// memory held by the raw annotations, estimated from their strings, and the traces by age so the oldest can be dropped first
+var flowBytes int64
+var traceOrder []gotocol.TraceContextType
+var dropped struct {
+	Traces      int `json:"traces"`
+	Annotations int `json:"annotations"`
+}
+
+// annotationOverhead is roughly what an annotation takes in memory besides the contents of its strings
+const annotationOverhead = 200
+
+func annotationBytes(a *spannotype) int64 {
+	return int64(annotationOverhead + len(a.Ctx) + len(a.Host) + len(a.Imp) + len(a.Intent) + len(a.Value) + len(a.Baggage) +
+		len(a.Dedup) + len(a.Mesh) + len(a.Degraded) + len(a.Page) + len(a.Flag) + len(a.Saga) + len(a.Batch) + len(a.Phase) + len(a.Breaker) + len(a.Topic))
+}
+
+// add an annotation to a trace, and if that takes the raw annotations over -maxflowmem drop the oldest traces until they're
+// back under 90% of it. Response time histograms are measured as flows end, so only the traces written out at the end lose the
+// dropped ones. The caller holds flowlock
+func add(t gotocol.TraceContextType, a *spannotype) {
+	if len(flowmap[t]) == 0 { // annotate may have made it already
+		traceOrder = append(traceOrder, t)
+	}
+	flowmap[t] = append(flowmap[t], a)
+	flowBytes += annotationBytes(a)
+	limit := int64(archaius.Conf.MaxFlowMem) << 20
+	if limit <= 0 || flowBytes <= limit {
+		return
+	}
+	if dropped.Traces == 0 {
+		log.Printf("flow: raw flows reached the -maxflowmem limit of %vMB, dropping the oldest traces\n", archaius.Conf.MaxFlowMem)
+	}
+	for len(traceOrder) > 1 && flowBytes > limit/10*9 {
+		old := traceOrder[0]
+		traceOrder = traceOrder[1:]
+		if old == t {
+			traceOrder = append(traceOrder, t) // keep the trace being added to
+			continue
+		}
+		if trace, ok := flowmap[old]; ok {
+			dropTrace(old, trace)
+			dropped.Traces++
+			dropped.Annotations += len(trace)
+		}
+	}
+}
+
+// dropTrace frees a trace and the memory accounted to it, the caller holds flowlock
+func dropTrace(t gotocol.TraceContextType, trace []*spannotype) {
+	for _, a := range trace {
+		flowBytes -= annotationBytes(a)
+	}
+	delete(flowmap, t)
+}
 
 // Common Annotation code
 func annotate(msg gotocol.Message, name string, t time.Time, resp, others Values) *spannotype {
@@ -96,6 +183,7 @@ func annotate(msg gotocol.Message, name string, t time.Time, resp, others Values
 	annotation.Imp = msg.Imposition.String()
 	annotation.Intent = msg.Intention
 	annotation.Timestamp = t.UnixNano()
+	annotation.Baggage = msg.Ctx.Baggage
 	if msg.Imposition == gotocol.GetResponse {
 		annotation.Value = resp.String()
 	} else {
@@ -110,7 +198,7 @@ func AnnotateReceive(msg gotocol.Message, name string, received time.Time) {
 		return
 	}
 	flowlock.Lock()
-	flowmap[msg.Ctx.Trace] = append(flowmap[msg.Ctx.Trace], annotate(msg, name, received, CR, SR))
+	add(msg.Ctx.Trace, annotate(msg, name, received, CR, SR))
 	flowlock.Unlock()
 	if graphneo4j.Enabled {
 		trace := flowmap[msg.Ctx.Trace]
@@ -127,7 +215,197 @@ func AnnotateSend(msg gotocol.Message, name string) {
 		return
 	}
 	flowlock.Lock()
-	flowmap[msg.Ctx.Trace] = append(flowmap[msg.Ctx.Trace], annotate(msg, name, msg.Sent, SS, CS))
+	add(msg.Ctx.Trace, annotate(msg, name, msg.Sent, SS, CS))
+	flowlock.Unlock()
+	return
+}
+
+// AnnotateDedup records the response to a retry that was answered from the idempotency cache instead of being done again
+func AnnotateDedup(msg gotocol.Message, name, outcome string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	a := annotate(msg, name, msg.Sent, SS, CS)
+	a.Dedup = outcome
+	flowlock.Lock()
+	add(msg.Ctx.Trace, a)
+	flowlock.Unlock()
+	return
+}
+
+// AnnotateDegraded records a response that is a fallback for a failed call to a dependency, so it succeeds with less quality
+func AnnotateDegraded(msg gotocol.Message, name, dependency string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	a := annotate(msg, name, msg.Sent, SS, CS)
+	a.Degraded = dependency
+	flowlock.Lock()
+	add(msg.Ctx.Trace, a)
+	flowlock.Unlock()
+	return
+}
+
+// AnnotateMesh records a call sent through a service mesh sidecar, with the overhead the proxies added and the retry attempt,
+// the same as AnnotateSend if mesh is empty
+func AnnotateMesh(msg gotocol.Message, name, mesh string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	a := annotate(msg, name, msg.Sent, SS, CS)
+	a.Mesh = mesh
+	flowlock.Lock()
+	add(msg.Ctx.Trace, a)
+	flowlock.Unlock()
+	return
+}
+
+// NoteMesh adds to the sidecar note of the last annotation an instance made for a span, to record what its sidecar did with a response
+func NoteMesh(msg gotocol.Message, name, note string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	ctx := msg.Ctx.String()
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	trace := flowmap[msg.Ctx.Trace]
+	for i := len(trace) - 1; i >= 0; i-- {
+		if a := trace[i]; a.Ctx == ctx && a.Host == name {
+			a.Mesh = strings.TrimPrefix(a.Mesh+" "+note, " ")
+			return
+		}
+	}
+}
+
+// NotePage records which page of a paginated call the last annotation an instance made for a span is for
+func NotePage(msg gotocol.Message, name, page string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	ctx := msg.Ctx.String()
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	trace := flowmap[msg.Ctx.Trace]
+	for i := len(trace) - 1; i >= 0; i-- {
+		if a := trace[i]; a.Ctx == ctx && a.Host == name {
+			a.Page = page
+			return
+		}
+	}
+}
+
+// NoteFlag records the feature flag state that routed the call the last annotation an instance made for a span is for
+func NoteFlag(msg gotocol.Message, name, flag string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	ctx := msg.Ctx.String()
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	trace := flowmap[msg.Ctx.Trace]
+	for i := len(trace) - 1; i >= 0; i-- {
+		if a := trace[i]; a.Ctx == ctx && a.Host == name {
+			a.Flag = flag
+			return
+		}
+	}
+}
+
+// NoteSaga records which step of a saga the last annotation an instance made for a span does or compensates
+func NoteSaga(msg gotocol.Message, name, step string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	ctx := msg.Ctx.String()
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	trace := flowmap[msg.Ctx.Trace]
+	for i := len(trace) - 1; i >= 0; i-- {
+		if a := trace[i]; a.Ctx == ctx && a.Host == name {
+			a.Saga = step
+			return
+		}
+	}
+}
+
+// NoteBreaker records what the circuit breaker of an edge did with the last annotation an instance made for a span, open for a
+// call that failed fast, or the change a call made to the state of the breaker
+func NoteBreaker(msg gotocol.Message, name, state string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	ctx := msg.Ctx.String()
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	trace := flowmap[msg.Ctx.Trace]
+	for i := len(trace) - 1; i >= 0; i-- {
+		if a := trace[i]; a.Ctx == ctx && a.Host == name {
+			a.Breaker = state
+			return
+		}
+	}
+}
+
+// NoteTopic records the partition and offset of a message in a topic against the last annotation an instance made for the span
+// that published it or delivered it, with the consumer group of a delivery
+func NoteTopic(msg gotocol.Message, name, topic string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	ctx := msg.Ctx.String()
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	trace := flowmap[msg.Ctx.Trace]
+	for i := len(trace) - 1; i >= 0; i-- {
+		if a := trace[i]; a.Ctx == ctx && a.Host == name {
+			a.Topic = topic
+			return
+		}
+	}
+}
+
+// NotePhase records which phase of a two phase commit the last annotation an instance made for a span is part of
+func NotePhase(msg gotocol.Message, name, phase string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	ctx := msg.Ctx.String()
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	trace := flowmap[msg.Ctx.Trace]
+	for i := len(trace) - 1; i >= 0; i-- {
+		if a := trace[i]; a.Ctx == ctx && a.Host == name {
+			a.Phase = phase
+			return
+		}
+	}
+}
+
+// NoteBatch records the batch the last annotation an instance made for a span sent or went in, as a call can be noted after it was
+// annotated, when its batch is sent
+func NoteBatch(msg gotocol.Message, name, batch string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	ctx := msg.Ctx.String()
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	trace := flowmap[msg.Ctx.Trace]
+	for i := len(trace) - 1; i >= 0; i-- {
+		if a := trace[i]; a.Ctx == ctx && a.Host == name {
+			a.Batch = batch
+			return
+		}
+	}
+}
+
+// AnnotateFailFast records a call that was skipped because it would exceed the request deadline or cross a network partition
+func AnnotateFailFast(msg gotocol.Message, name string) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	flowlock.Lock()
+	add(msg.Ctx.Trace, annotate(msg, name, clock.Now(), FF, FF))
 	flowlock.Unlock()
 	return
 }
@@ -173,17 +451,110 @@ func Shutdown() {
 	// Try to add Kafka collector if configured to do so
 	if len(archaius.Conf.Kafka) > 0 {
 		log.Printf("Flushing flows to Kafka Collector %#v\n", archaius.Conf.Kafka)
-		var err error
-		collector, err = NewKafkaCollector(archaius.Conf.Kafka)
+		collector, err := NewKafkaCollector(archaius.Conf.Kafka)
 		if err != nil {
 			log.Printf("Unable to start Kafka Collector: %#v\n", err)
 		} else {
-			defer collector.Close()
+			collectors = append(collectors, collector)
+		}
+	}
+	if archaius.Conf.Zipkin != "" {
+		log.Printf("Flushing flows to Zipkin Collector %v\n", archaius.Conf.Zipkin)
+		collector, err := NewHTTPCollector(archaius.Conf.Zipkin)
+		if err != nil {
+			log.Printf("Unable to start Zipkin Collector: %v\n", err)
+		} else {
+			collectors = append(collectors, collector)
+		}
+	}
+	defer func() {
+		for _, c := range collectors {
+			c.Close()
+		}
+		collectors = nil
+	}()
+
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	WriteSequence()
+	WriteMatrix()
+	WriteChrome()
+	WriteCritical()
+	WriteFlame()
+	WriteBreakdown()
+	WriteAnimation()
+	WriteOTLP()
+	writeFlows()
+	if archaius.Conf.SQLite != "" {
+		collect.SQLiteFlows(sqliteFlows())
+	}
+}
+
+// sqliteFlows are the annotations of every trace for the -sqlite database, in trace and span order, the caller holds flowlock
+func sqliteFlows() []collect.FlowAnnotation {
+	var traces []int
+	for t := range flowmap {
+		traces = append(traces, int(t))
+	}
+	sort.Ints(traces)
+	var as []collect.FlowAnnotation
+	for _, t := range traces {
+		trace := flowmap[gotocol.TraceContextType(t)]
+		sort.Sort(ByCtx(trace))
+		for _, a := range trace {
+			s, p := spanParent(a.Ctx)
+			span, _ := strconv.ParseInt(s, 10, 64)
+			parent, _ := strconv.ParseInt(p, 10, 64)
+			as = append(as, collect.FlowAnnotation{int64(t), span, parent, a.Imp, a.Host, a.Value, a.Timestamp, a.Intent, a.Baggage,
+				a.Mesh, a.Dedup, a.Degraded, a.Page, a.Flag, a.Saga, a.Batch, a.Phase, a.Breaker, a.Topic})
 		}
 	}
+	return as
+}
+
+// RollWindow is how long traces are kept when running forever
+const RollWindow = time.Minute
 
+// Roll drops the traces that haven't been added to for longer than the window and rewrites the flow file with the ones that
+// are left, so a run that goes on forever keeps a rolling window of flows for a display to pick up instead of growing
+func Roll(window time.Duration) {
+	if !archaius.Conf.Collect {
+		return
+	}
+	cutoff := clock.Now().Add(-window).UnixNano()
 	flowlock.Lock()
 	defer flowlock.Unlock()
+	for t, trace := range flowmap {
+		latest := int64(0)
+		for _, a := range trace {
+			if a.Timestamp > latest {
+				latest = a.Timestamp
+			}
+		}
+		if latest < cutoff {
+			dropTrace(t, trace)
+		}
+	}
+	kept := traceOrder[:0]
+	for _, t := range traceOrder {
+		if flowmap[t] != nil {
+			kept = append(kept, t)
+		}
+	}
+	traceOrder = kept
+	writeFlows()
+}
+
+// droppedSummary is what -maxflowmem dropped, or nil if it didn't have to
+func droppedSummary() interface{} {
+	if dropped.Traces == 0 {
+		return nil
+	}
+	return dropped
+}
+
+// writeFlows writes every trace to the flow file, the caller holds flowlock
+func writeFlows() {
 	f, err := os.Create("json_metrics/" + archaius.Conf.Arch + "_flow.json")
 	if err != nil {
 		log.Fatal(err)
@@ -192,17 +563,25 @@ func Shutdown() {
 	}
 	log.Printf("Flushing flows to %v\n", file.Name())
 	file.WriteString("[\n")
-	comma := false
-	for c, f := range flowmap {
-		if comma {
+	var traces []int
+	for t := range flowmap {
+		traces = append(traces, int(t))
+	}
+	sort.Ints(traces) // so the same run writes the same file
+	for i, t := range traces {
+		if i > 0 {
 			file.WriteString(",\n")
-		} else {
-			comma = true
 		}
-		Flush(c, f)
+		Flush(gotocol.TraceContextType(t), flowmap[gotocol.TraceContextType(t)])
 	}
 	file.WriteString("\n]\n")
 	file.Close()
+	collect.Summarize("flow", struct {
+		File    string      `json:"file"`
+		Traces  int         `json:"traces"`
+		MB      float64     `json:"mb"` // estimated memory held by the raw flows
+		Dropped interface{} `json:"dropped,omitempty"`
+	}{file.Name(), len(flowmap), float64(flowBytes) / (1 << 20), droppedSummary()})
 }
 
 /* example: Zipkin format is an array of these
@@ -262,13 +641,21 @@ type zipkinannotation struct {
 	Value     string         `json:"value"`
 }
 
+// binary annotation for zipkin, used to export baggage items
+type zipkinbinaryannotation struct {
+	Key      string         `json:"key"`
+	Value    string         `json:"value"`
+	Endpoint zipkinendpoint `json:"endpoint"`
+}
+
 // trace for zipkin
 type zipkinspan struct {
-	Traceid     string             `json:"traceId"`
-	Name        string             `json:"name"`
-	Id          string             `json:"id"`
-	ParentId    string             `json:"parentId,omitempty"`
-	Annotations []zipkinannotation `json:"annotations"`
+	Traceid           string                   `json:"traceId"`
+	Name              string                   `json:"name"`
+	Id                string                   `json:"id"`
+	ParentId          string                   `json:"parentId,omitempty"`
+	Annotations       []zipkinannotation       `json:"annotations"`
+	BinaryAnnotations []zipkinbinaryannotation `json:"binaryAnnotations,omitempty"`
 }
 
 // WriteZip stores zipkin as json
@@ -277,36 +664,114 @@ func WriteZip(zip zipkinspan) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	if collector != nil {
-		collector.Collect(j)
+	for _, c := range collectors {
+		c.Collect(j)
 	}
 	file.Write(j[1 : len(j)-1])
 }
 
+// spanIDs formats the trace, span and parent ids of a span, zipkin style by default as 16 decimal digits,
+// or for -traceids w3c as a 32 hex digit trace id and 16 hex digit span ids as used in a W3C traceparent header.
+// The parent id is empty for the root span of a trace
+func spanIDs(t gotocol.TraceContextType, span, parent string) (traceID, id, parentID string) {
+	if archaius.Conf.TraceIDs == "w3c" {
+		s, _ := strconv.ParseUint(span, 10, 64)
+		p, _ := strconv.ParseUint(parent, 10, 64)
+		traceID, id = fmt.Sprintf("%032x", uint64(t)), fmt.Sprintf("%016x", s)
+		if p != 0 {
+			parentID = fmt.Sprintf("%016x", p)
+		}
+		return
+	}
+	traceID = fmt.Sprintf("%016d", t)               // pad id's to 16 characters to keep zipkin happy
+	id = "000000000000000"[0:(16-len(span))] + span // pad id's to 16 characters to keep zipkin happy
+	if parent != "0" {
+		parentID = "000000000000000"[0:(16-len(parent))] + parent // pad id's to 16 characters to keep zipkin happy
+	}
+	return
+}
+
+// traceparent is the W3C Trace Context header for a call, version 00 and sampled
+func traceparent(traceID, id string) string {
+	return "00-" + traceID + "-" + id + "-01"
+}
+
 // Flush the spans for a request in zipkin format
 func Flush(t gotocol.TraceContextType, trace []*spannotype) {
 	var zip zipkinspan
 	var ctx string
 	n := -1
+	start := 0 // of the annotations of the span
 	sort.Sort(ByCtx(trace))
-	for _, a := range trace {
+	for i, a := range trace {
 		//fmt.Println(*a)
 		if ctx != a.Ctx { // new span
 			if ctx != "" { // not the first
+				zip.BinaryAnnotations = append(zip.BinaryAnnotations, called(trace[start:i])...)
 				WriteZip(zip)
 				file.WriteString(",\n")
 				zip.Annotations = nil
+				zip.BinaryAnnotations = nil
 			}
 			n++
-			zip.Traceid = fmt.Sprintf("%016d", t) // pad id's to 16 characters to keep zipkin happy
+			start = i
 			zip.Name = a.Imp
-			s := strings.SplitAfter(a.Ctx, "s")                            // tXpYsZ -> [tXpYs, Z]
-			p := strings.TrimSuffix(strings.SplitAfter(s[0], "p")[1], "s") // tXpYs -> [tXp, Ys] -> Ys -> Y
-			zip.Id = "000000000000000"[0:(16-len(s[1]))] + s[1]            // pad id's to 16 characters to keep zipkin happy
-			if p != "0" {
-				zip.ParentId = "000000000000000"[0:(16-len(p))] + p // pad id's to 16 characters to keep zipkin happy
-			}
+			s, p := spanParent(a.Ctx)
+			zip.Traceid, zip.Id, zip.ParentId = spanIDs(t, s, p)
 			ctx = a.Ctx
+			if archaius.Conf.TraceIDs == "w3c" { // the header a real service would have been sent, so traces can be correlated with it
+				zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"traceparent", traceparent(zip.Traceid, zip.Id), zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+			}
+			if a.Baggage != "" { // baggage is the same on every annotation in a span, so record it once
+				for _, kv := range strings.Split(a.Baggage, ",") {
+					b := strings.SplitN(kv, "=", 2)
+					if len(b) == 2 {
+						zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{b[0], b[1], zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+					}
+				}
+			}
+		}
+		if a.Value == SR.String() { // tag the server side of the span with the version of the service, or the instance if a deployment started it
+			v := archaius.Service(names.Service(a.Host)).Version
+			if d := archaius.Deployed(a.Host); d != nil {
+				v = d.Version
+			}
+			if v != "" {
+				zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"version", v, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+			}
+		}
+		if a.Mesh != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"sidecar", a.Mesh, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+		}
+		if a.Dedup != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"dedup", a.Dedup, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+		}
+		if a.Degraded != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"degraded", a.Degraded, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+		}
+		if a.Page != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"page", a.Page, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+		}
+		if a.Flag != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"flag", a.Flag, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+		}
+		if a.Saga != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"saga", a.Saga, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+		}
+		if a.Batch != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"batch", a.Batch, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+		}
+		if a.Phase != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"phase", a.Phase, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+		}
+		if a.Breaker != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"breaker", a.Breaker, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+			if a.Breaker == "open" { // zipkin shows a span with an error tag as failed
+				zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"error", "circuit breaker open", zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
+			}
+		}
+		if a.Topic != "" {
+			zip.BinaryAnnotations = append(zip.BinaryAnnotations, zipkinbinaryannotation{"topic", a.Topic, zipkinendpoint{a.Host, dhcp.Lookup(a.Host), 8080}})
 		}
 		var ann zipkinannotation
 		ann.Endpoint.Servicename = a.Host
@@ -316,14 +781,16 @@ func Flush(t gotocol.TraceContextType, trace []*spannotype) {
 		ann.Value = a.Value
 		zip.Annotations = append(zip.Annotations, ann)
 	}
+	zip.BinaryAnnotations = append(zip.BinaryAnnotations, called(trace[start:])...)
 	WriteZip(zip)
 }
 
 // Instrument common code for requests
 func Instrument(msg gotocol.Message, name string, hist *generic.Histogram) {
-	received := time.Now()
+	received := clock.Now()
 	collect.Measure(hist, received.Sub(msg.Sent))
-	if archaius.Conf.Msglog {
+	gotocol.Received(msg, name)
+	if Msglog(name) {
 		log.Printf("%v: %v\n", name, msg)
 	}
 	if msg.Ctx != gotocol.NilContext {
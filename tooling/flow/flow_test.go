@@ -2,9 +2,16 @@
 package flow
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
 	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -49,3 +56,288 @@ func TestFlow(t *testing.T) {
 	fmt.Println("\nWrite all remaining flows in order to file")
 	Shutdown()
 }
+
+func TestCriticalPath(t *testing.T) {
+	a := func(ctx, host, value string, ms int64) *spannotype {
+		return &spannotype{Ctx: ctx, Host: host, Value: value, Timestamp: ms * int64(time.Millisecond)}
+	}
+	// web calls fast and slow in parallel, slow is on the critical path and fast isn't
+	trace := []*spannotype{
+		a("t1p0s1", "client", "cs", 0), a("t1p0s1", "web", "sr", 1),
+		a("t1p1s2", "web", "cs", 2), a("t1p1s2", "fast", "sr", 3), a("t1p1s2", "fast", "ss", 5), a("t1p1s2", "web", "cr", 6),
+		a("t1p1s3", "web", "cs", 2), a("t1p1s3", "slow", "sr", 3), a("t1p1s3", "slow", "ss", 10), a("t1p1s3", "web", "cr", 11),
+		a("t1p0s1", "web", "ss", 12), a("t1p0s1", "client", "cr", 13),
+	}
+	cp, ok := criticalPath(1, trace)
+	if !ok {
+		t.Fatal("trace should be complete")
+	}
+	if len(cp.Path) != 2 || cp.Path[0].Span != "t1p0s1" || cp.Path[1].Span != "t1p1s3" {
+		t.Fatalf("wrong path %+v", cp.Path)
+	}
+	if cp.Total != 13 || cp.Network != 4 || cp.Path[0].Self != 2 || cp.Path[1].Self != 7 {
+		t.Errorf("wrong times %+v", cp)
+	}
+	if _, ok := criticalPath(1, trace[:len(trace)-2]); ok {
+		t.Error("trace without a response shouldn't have a critical path")
+	}
+}
+
+func TestFlameGraph(t *testing.T) {
+	a := func(ctx, host, value string, ms int64) *spannotype {
+		return &spannotype{Ctx: ctx, Host: host, Value: value, Timestamp: ms * int64(time.Millisecond)}
+	}
+	web, store, cache := names.Make("test", "us-east-1", "zoneA", "web", "karyon", 0),
+		names.Make("test", "us-east-1", "zoneA", "store", "staash", 0), names.Make("test", "us-east-1", "zoneA", "cache", "store", 0)
+	// two traces into web, the first calls store twice, the second calls it once and cache once
+	traces := map[gotocol.TraceContextType][]*spannotype{
+		1: {
+			a("t1p0s1", "client", "cs", 0), a("t1p0s1", web, "sr", 1),
+			a("t1p1s2", web, "cs", 2), a("t1p1s2", store, "sr", 3),
+			a("t1p1s2", store, "ss", 5), a("t1p1s2", web, "cr", 6),
+			a("t1p1s3", web, "cs", 6), a("t1p1s3", store, "sr", 7),
+			a("t1p1s3", store, "ss", 9), a("t1p1s3", web, "cr", 10),
+			a("t1p0s1", web, "ss", 11), a("t1p0s1", "client", "cr", 12),
+		},
+		2: {
+			a("t2p0s1", "client", "cs", 0), a("t2p0s1", web, "sr", 1),
+			a("t2p1s2", web, "cs", 1), a("t2p1s2", store, "sr", 2),
+			a("t2p1s2", store, "ss", 4), a("t2p1s2", web, "cr", 5),
+			a("t2p1s3", web, "cs", 5), a("t2p1s3", cache, "sr", 5),
+			a("t2p1s3", cache, "ss", 6), a("t2p1s3", web, "cr", 6),
+			a("t2p0s1", web, "ss", 7),
+		},
+		3: {a("t3p0s1", "client", "cs", 0), a("t3p0s1", web, "sr", 1)},
+	}
+	roots := flameGraph(traces)
+	w := roots["web"]
+	if len(roots) != 1 || w == nil {
+		t.Fatalf("wrong entry points %v", roots)
+	}
+	if w.Calls != 2 || w.Total != 18 || w.Self != 3 || w.Network != 2 || len(w.Children) != 2 {
+		t.Fatalf("wrong web %+v", w)
+	}
+	s, c := w.Children[0], w.Children[1]
+	if s.Service != "store" || s.Calls != 3 || s.Total != 12 || s.Self != 6 || s.Network != 6 {
+		t.Errorf("wrong store %+v", s)
+	}
+	if c.Service != "cache" || c.Calls != 1 || c.Total != 1 || c.Self != 1 || c.Network != 0 {
+		t.Errorf("wrong cache %+v", c)
+	}
+	folded := strings.Join(w.fold(nil, nil), "\n")
+	if folded != "web 3000\nweb;network 2000\nweb;store 6000\nweb;store;network 6000\nweb;cache 1000" {
+		t.Errorf("wrong folded stacks\n%v", folded)
+	}
+}
+
+func TestBreakdown(t *testing.T) {
+	a := func(ctx, host, value string, ms int64) *spannotype {
+		return &spannotype{Ctx: ctx, Host: host, Value: value, Timestamp: ms * int64(time.Millisecond)}
+	}
+	web, store := names.Make("test", "us-east-1", "zoneA", "web", "karyon", 0), names.Make("test", "us-east-1", "zoneA", "store", "staash", 0)
+	// a slow trace into web that calls store, and a fast one that doesn't
+	traces := map[gotocol.TraceContextType][]*spannotype{
+		1: {
+			a("t1p0s1", "client", "cs", 0), a("t1p0s1", web, "sr", 1),
+			a("t1p1s2", web, "cs", 2), a("t1p1s2", store, "sr", 3),
+			a("t1p1s2", store, "ss", 7), a("t1p1s2", web, "cr", 8),
+			a("t1p0s1", web, "ss", 9), a("t1p0s1", "client", "cr", 10),
+		},
+		2: {a("t2p0s1", "client", "cs", 0), a("t2p0s1", web, "sr", 1), a("t2p0s1", web, "ss", 3), a("t2p0s1", "client", "cr", 4)},
+		3: {a("t3p0s1", "client", "cs", 0), a("t3p0s1", web, "sr", 1)},
+	}
+	bd := breakdown(traces)
+	if len(bd) != 1 || bd[0].Entry != "web" || bd[0].Traces != 2 || bd[0].Mean != 7 || bd[0].P99 != 10 {
+		t.Fatalf("wrong breakdown %+v", bd)
+	}
+	want := []BreakdownSegment{{"network", 3, 4}, {"store", 2, 4}, {"web", 2, 2}}
+	if fmt.Sprint(bd[0].Services) != fmt.Sprint(want) {
+		t.Errorf("wrong segments %v, want %v", bd[0].Services, want)
+	}
+}
+
+func TestMaxFlowMem(t *testing.T) {
+	archaius.Conf.Collect = true
+	archaius.Conf.MaxFlowMem = 1
+	defer func() { archaius.Conf.MaxFlowMem = 0 }()
+	flowmap, flowBytes, traceOrder = nil, 0, nil // without the flows of the other tests
+	body := strings.Repeat("x", 1000)
+	var last gotocol.Message
+	for i := 0; i < 2000; i++ { // about 2.4MB of annotations
+		last = gotocol.Message{gotocol.GetRequest, nil, time.Now(), gotocol.NewTrace(), body}
+		AnnotateSend(last, "requestor")
+	}
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	if flowBytes > 1<<20 {
+		t.Errorf("flows take %v bytes, over the 1MB cap", flowBytes)
+	}
+	if dropped.Traces == 0 || dropped.Annotations != dropped.Traces {
+		t.Errorf("dropped %+v, should be some traces of one annotation each", dropped)
+	}
+	if flowmap[last.Ctx.Trace] == nil {
+		t.Error("the newest trace was dropped")
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	defer func() { callbacks = nil }()
+	a := func(ctx, host, value, intent string, ms int64) *spannotype {
+		return &spannotype{Ctx: ctx, Host: host, Value: value, Intent: intent, Timestamp: ms * int64(time.Millisecond)}
+	}
+	web, store := names.Make("test", "us-east-1", "zoneA", "web", "karyon", 0), names.Make("test", "us-east-1", "zoneA", "store", "staash", 0)
+	OnSuccess("web", "*", func(c Call) map[string]string {
+		if c.Latency > 5*time.Millisecond {
+			return map[string]string{"latency": "slow"}
+		}
+		return map[string]string{"latency": "fast"}
+	})
+	OnError("*", "store", func(c Call) map[string]string {
+		return map[string]string{"error": c.Response, "caller": names.Service(c.Caller)}
+	})
+	ok := []*spannotype{a("t1p1s2", web, "cs", "", 2), a("t1p1s2", store, "sr", "", 3), a("t1p1s2", store, "ss", "ok", 9), a("t1p1s2", web, "cr", "ok", 10)}
+	if b := called(ok); len(b) != 1 || b[0].Key != "latency" || b[0].Value != "slow" || b[0].Endpoint.Servicename != web {
+		t.Errorf("wrong annotations for a slow call %+v", b)
+	}
+	failed := []*spannotype{a("t1p1s3", web, "cs", "", 2), a("t1p1s3", store, "sr", "", 3), a("t1p1s3", store, "ss", gotocol.Failure("timeout"), 4), a("t1p1s3", web, "cr", gotocol.Failure("timeout"), 4)}
+	if b := called(failed); len(b) != 2 || b[0].Key != "caller" || b[0].Value != "web" || b[1].Key != "error" || b[1].Value != gotocol.Failure("timeout") {
+		t.Errorf("wrong annotations for a failed call %+v", b)
+	}
+	if b := called(ok[:2]); b != nil {
+		t.Errorf("a call without a response shouldn't be annotated %+v", b)
+	}
+}
+
+func TestHTTPCollector(t *testing.T) {
+	var posts, spans int
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var zs []zipkinspan
+		if err := json.NewDecoder(r.Body).Decode(&zs); err != nil {
+			t.Errorf("bad batch %v", err)
+		}
+		path = r.URL.Path
+		posts++
+		spans += len(zs)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	c, err := NewHTTPCollector(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < httpBatch+1; i++ {
+		j, _ := json.Marshal([]*zipkinspan{{Traceid: "1", Name: "get", Id: fmt.Sprint(i)}})
+		c.Collect(j)
+	}
+	if posts != 1 || spans != httpBatch {
+		t.Errorf("%v posts of %v spans before close, want one full batch", posts, spans)
+	}
+	c.Close()
+	if posts != 2 || spans != httpBatch+1 || path != defaultZipkinPath {
+		t.Errorf("%v posts of %v spans to %v after close", posts, spans, path)
+	}
+	bad, _ := NewHTTPCollector("http://127.0.0.1:1/api/v1/spans")
+	bad.Collect([]byte(`[{"traceId":"1"}]`))
+	bad.Close() // logged, not fatal
+	if bad.failed != 1 {
+		t.Errorf("%v failed spans, want 1", bad.failed)
+	}
+}
+
+// pbFields returns the length delimited fields of a protobuf message with the field number, skipping the other wire types
+func pbFields(t *testing.T, b []byte, field uint64) [][]byte {
+	var fs [][]byte
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		b = b[n:]
+		switch tag & 7 {
+		case 0:
+			_, n = binary.Uvarint(b)
+		case 1:
+			n = 8
+		case 2:
+			l, ln := binary.Uvarint(b)
+			if tag>>3 == field {
+				fs = append(fs, b[ln:ln+int(l)])
+			}
+			n = ln + int(l)
+		default:
+			t.Fatalf("wire type %v", tag&7)
+		}
+		b = b[n:]
+	}
+	return fs
+}
+
+// otlpCount is the number of resources and spans in an export request
+func otlpCount(t *testing.T, req []byte) (resources, spans int) {
+	for _, rs := range pbFields(t, req, 1) {
+		resources++
+		for _, ss := range pbFields(t, rs, 2) {
+			spans += len(pbFields(t, ss, 2))
+		}
+	}
+	return
+}
+
+func TestOTLPExporter(t *testing.T) {
+	saved := flowmap
+	defer func() { flowmap = saved }()
+	a := func(ctx, host, value, intent string, ms int64) *spannotype {
+		return &spannotype{Ctx: ctx, Host: host, Imp: "GetRequest", Value: value, Intent: intent, Timestamp: ms * int64(time.Millisecond)}
+	}
+	web, store := names.Make("test", "us-east-1", "zoneA", "web", "karyon", 0), names.Make("test", "us-east-1", "zoneA", "store", "staash", 0)
+	flowmap = flowmaptype{7: {a("t7p0s1", web, "sr", "", 1), a("t7p1s2", web, "cs", "", 2), a("t7p1s2", store, "sr", "", 3),
+		a("t7p1s2", store, "ss", gotocol.Failure("timeout"), 4), a("t7p1s2", web, "cr", gotocol.Failure("timeout"), 5), a("t7p0s1", web, "ss", "ok", 6)}}
+	spans := otlpSpans()
+	if len(spans) != 3 {
+		t.Fatalf("%v spans, want the root server span, and the client and server spans of the call", len(spans))
+	}
+	if spans[1].kind != otlpClient || spans[1].parent != 1 || spans[2].kind != otlpServer || spans[2].parent != clientID(2) || !spans[2].failed {
+		t.Errorf("wrong spans for the call %+v", spans[1:])
+	}
+	if r, n := otlpCount(t, encodeOTLP(spans)); r != 2 || n != 3 {
+		t.Errorf("%v resources with %v spans, want a resource for each instance", r, n)
+	}
+	var path, ctype string
+	var got int
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		path, ctype = r.URL.Path, r.Header.Get("Content-Type")
+		_, got = otlpCount(t, body)
+	}))
+	defer h.Close()
+	e, _ := NewOTLPExporter(h.URL)
+	e.Export(spans)
+	if e.sent != 3 || got != 3 || path != "/v1/traces" || ctype != "application/x-protobuf" {
+		t.Errorf("OTLP/HTTP sent %v, got %v spans on %v as %v", e.sent, got, path, ctype)
+	}
+	g := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		path, ctype = r.URL.Path, r.Header.Get("Content-Type")
+		if r.ProtoMajor != 2 || len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+			t.Errorf("bad gRPC frame over HTTP/%v", r.ProtoMajor)
+			return
+		}
+		_, got = otlpCount(t, body[5:])
+		w.Header().Set("Trailer", "grpc-status")
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("grpc-status", "0")
+	}))
+	g.Config.Protocols = new(http.Protocols)
+	g.Config.Protocols.SetUnencryptedHTTP2(true)
+	g.Start()
+	defer g.Close()
+	e, _ = NewOTLPExporter(strings.TrimPrefix(g.URL, "http://"))
+	e.Export(spans)
+	if e.sent != 3 || got != 3 || path != "/opentelemetry.proto.collector.trace.v1.TraceService/Export" || ctype != "application/grpc" {
+		t.Errorf("OTLP/gRPC sent %v, got %v spans on %v as %v", e.sent, got, path, ctype)
+	}
+	bad, _ := NewOTLPExporter("127.0.0.1:1")
+	bad.Export(spans) // logged, not fatal
+	if bad.failed != 3 {
+		t.Errorf("%v failed spans, want 3", bad.failed)
+	}
+}
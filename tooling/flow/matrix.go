@@ -0,0 +1,154 @@
+package flow
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// matrixName is the row or column a host is counted in, its service name, or the filtered name with -f
+func matrixName(host string) string {
+	if archaius.Conf.Filter {
+		return names.FilterNode(host)
+	}
+	return names.Service(host)
+}
+
+// callMatrix counts calls from caller to callee, matching the client send and server receive of each span, with hosts named by name
+func callMatrix(name func(string) string) map[string]map[string]int {
+	m := make(map[string]map[string]int)
+	for _, trace := range flowmap {
+		cs := make(map[string]string) // caller by span context
+		sr := make(map[string]string) // callee by span context
+		for _, a := range trace {
+			switch a.Value {
+			case CS.String():
+				cs[a.Ctx] = a.Host
+			case SR.String():
+				sr[a.Ctx] = a.Host
+			}
+		}
+		for ctx, caller := range cs {
+			callee, ok := sr[ctx]
+			if !ok {
+				continue // never arrived
+			}
+			from := name(caller)
+			if m[from] == nil {
+				m[from] = make(map[string]int)
+			}
+			m[from][name(callee)]++
+		}
+	}
+	return m
+}
+
+// Calls counts the calls between the nodes of the graphs so far, named as edda names them, or nil if Collect isn't enabled
+func Calls() map[string]map[string]int {
+	if !archaius.Conf.Collect {
+		return nil
+	}
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	return callMatrix(names.FilterNode)
+}
+
+// CallLatencies are the round trip times of the calls between each pair of instances so far, from client send to client receive,
+// by full caller and callee names, or nil if Collect isn't enabled
+func CallLatencies() map[string]map[string][]time.Duration {
+	if !archaius.Conf.Collect {
+		return nil
+	}
+	flowlock.Lock()
+	defer flowlock.Unlock()
+	m := make(map[string]map[string][]time.Duration)
+	for _, trace := range flowmap {
+		cs := make(map[string]*spannotype) // client send by span context
+		cr := make(map[string]*spannotype)
+		sr := make(map[string]string) // callee by span context
+		for _, a := range trace {
+			switch a.Value {
+			case CS.String():
+				cs[a.Ctx] = a
+			case CR.String():
+				cr[a.Ctx] = a
+			case SR.String():
+				sr[a.Ctx] = a.Host
+			}
+		}
+		for ctx, s := range cs {
+			callee, ok := sr[ctx]
+			r, done := cr[ctx]
+			if !ok || !done {
+				continue // never arrived or never came back
+			}
+			if m[s.Host] == nil {
+				m[s.Host] = make(map[string][]time.Duration)
+			}
+			m[s.Host][callee] = append(m[s.Host][callee], time.Duration(r.Timestamp-s.Timestamp))
+		}
+	}
+	return m
+}
+
+// WriteMatrix writes the caller by callee call counts to json_metrics/<arch>_matrix.csv, with totals for fan out and fan in
+func WriteMatrix() {
+	if !archaius.Conf.CallMatrix {
+		return
+	}
+	m := callMatrix(matrixName)
+	var callers, callees []string
+	seen := make(map[string]bool)
+	in := make(map[string]int)
+	calls := 0
+	for from, row := range m {
+		callers = append(callers, from)
+		for to, n := range row {
+			if !seen[to] {
+				seen[to] = true
+				callees = append(callees, to)
+			}
+			in[to] += n
+			calls += n
+		}
+	}
+	sort.Strings(callers)
+	sort.Strings(callees)
+	fn := "json_metrics/" + archaius.Conf.Arch + "_matrix.csv"
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	log.Printf("Writing call matrix to %v\n", fn)
+	w := csv.NewWriter(f)
+	w.Write(append(append([]string{"caller"}, callees...), "total"))
+	for _, from := range callers {
+		row := []string{from}
+		out := 0
+		for _, to := range callees {
+			row = append(row, fmt.Sprintf("%v", m[from][to]))
+			out += m[from][to]
+		}
+		w.Write(append(row, fmt.Sprintf("%v", out)))
+	}
+	total := []string{"total"}
+	for _, to := range callees {
+		total = append(total, fmt.Sprintf("%v", in[to]))
+	}
+	w.Write(append(total, fmt.Sprintf("%v", calls)))
+	w.Flush()
+	collect.Summarize("callmatrix", struct {
+		File    string `json:"file"`
+		Callers int    `json:"callers"`
+		Callees int    `json:"callees"`
+		Calls   int    `json:"calls"`
+	}{fn, len(callers), len(callees), calls})
+}
@@ -0,0 +1,41 @@
+package flow
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+var msglogRand *rand.Rand // its own source so sampling doesn't change the random numbers the simulation gets from math/rand
+var msglogSample float64
+var msglogService string
+var msglogOnce sync.Once
+var msglogLock sync.Mutex
+
+// Msglog is true if -m is set and a message received by the named instance should be printed. The msglogsample:fraction keyval
+// prints a random sample of the messages, and msglogservice:name only the ones received by instances of that service
+func Msglog(name string) bool {
+	if !archaius.Conf.Msglog {
+		return false
+	}
+	msglogOnce.Do(func() {
+		msglogSample = 1
+		if s, err := strconv.ParseFloat(archaius.Key(archaius.Conf, "msglogsample"), 64); err == nil {
+			msglogSample = s
+		}
+		msglogService = archaius.Key(archaius.Conf, "msglogservice")
+		msglogRand = rand.New(rand.NewSource(archaius.Seed()))
+	})
+	if msglogService != "" && names.Service(name) != msglogService {
+		return false
+	}
+	if msglogSample >= 1 {
+		return true
+	}
+	msglogLock.Lock()
+	defer msglogLock.Unlock()
+	return msglogRand.Float64() < msglogSample
+}
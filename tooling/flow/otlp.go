@@ -0,0 +1,350 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/dhcp"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// otlpBatch is the number of spans sent in each export request
+const otlpBatch = 512
+
+// OpenTelemetry span kinds and status codes
+const (
+	otlpServer      = 2
+	otlpClient      = 3
+	otlpProducer    = 4
+	otlpConsumer    = 5
+	otlpStatusError = 2
+)
+
+// otlpSpan is the client or server side of a span in the flows as an OpenTelemetry span, started by an instance
+type otlpSpan struct {
+	host       string
+	trace      gotocol.TraceContextType
+	id, parent uint64
+	name       string
+	kind       int
+	start, end int64
+	attributes [][2]string
+	failed     bool
+	response   string
+}
+
+// clientID is the span id of the client side of a span, the server side has the id that's in the flows, so the two don't clash
+func clientID(span uint64) uint64 {
+	return span | 1<<63
+}
+
+// otlpSpans turns the flows into a client span, cs to cr, started by the caller, and a server span, sr to ss, that's its
+// child, for each span in the flows. A side that never finished ends where it was last seen
+func otlpSpans() []otlpSpan {
+	var traces []int
+	for t := range flowmap {
+		traces = append(traces, int(t))
+	}
+	sort.Ints(traces)
+	var spans []otlpSpan
+	for _, t := range traces {
+		trace := flowmap[gotocol.TraceContextType(t)]
+		sort.Sort(ByCtx(trace))
+		byCtx := make(map[string]map[string]*spannotype) // the first annotation of each value, by span context
+		var order []string
+		for _, a := range trace {
+			if byCtx[a.Ctx] == nil {
+				byCtx[a.Ctx] = make(map[string]*spannotype)
+				order = append(order, a.Ctx)
+			}
+			if byCtx[a.Ctx][a.Value] == nil {
+				byCtx[a.Ctx][a.Value] = a
+			}
+		}
+		for _, ctx := range order {
+			as := byCtx[ctx]
+			s, p := spanParent(ctx)
+			id, _ := strconv.ParseUint(s, 10, 64)
+			parent, _ := strconv.ParseUint(p, 10, 64)
+			side := func(from, to *spannotype, kind int, id, parent uint64) otlpSpan {
+				o := otlpSpan{host: from.Host, trace: gotocol.TraceContextType(t), id: id, parent: parent, name: from.Imp, kind: kind,
+					start: from.Timestamp, end: from.Timestamp, attributes: otlpAttributes(from)}
+				if to != nil {
+					o.end, o.response, o.failed = to.Timestamp, to.Intent, gotocol.Failed(to.Intent)
+				}
+				return o
+			}
+			cs := as[CS.String()]
+			if cs == nil {
+				cs = as[FF.String()] // a call that failed fast without being sent still gets its failure back
+			}
+			if cs != nil {
+				kind := otlpClient
+				if cs.Imp == gotocol.Publish.String() {
+					kind = otlpProducer
+				}
+				spans = append(spans, side(cs, as[CR.String()], kind, clientID(id), parent))
+				parent = clientID(id)
+			}
+			if sr := as[SR.String()]; sr != nil {
+				kind := otlpServer
+				if sr.Imp == gotocol.Deliver.String() {
+					kind = otlpConsumer // the processing of a message a consumer was delivered
+				}
+				spans = append(spans, side(sr, as[SS.String()], kind, id, parent))
+			}
+		}
+	}
+	return spans
+}
+
+// otlpAttributes are the baggage items and the tags of an annotation, the same ones the zipkin flows have as binaryAnnotations
+func otlpAttributes(a *spannotype) [][2]string {
+	var attrs [][2]string
+	if a.Baggage != "" {
+		for _, kv := range strings.Split(a.Baggage, ",") {
+			if b := strings.SplitN(kv, "=", 2); len(b) == 2 {
+				attrs = append(attrs, [2]string{b[0], b[1]})
+			}
+		}
+	}
+	for _, t := range [][2]string{{"sidecar", a.Mesh}, {"dedup", a.Dedup}, {"degraded", a.Degraded}, {"page", a.Page}, {"flag", a.Flag},
+		{"saga", a.Saga}, {"batch", a.Batch}, {"phase", a.Phase}, {"breaker", a.Breaker},
+		{"topic", a.Topic}} {
+		if t[1] != "" {
+			attrs = append(attrs, [2]string{"spigo." + t[0], t[1]})
+		}
+	}
+	return attrs
+}
+
+// otlpbuf builds protobuf wire format for the OTLP messages
+type otlpbuf []byte
+
+func (b *otlpbuf) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	*b = append(*b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (b *otlpbuf) bytes(field int, v []byte) {
+	b.uvarint(uint64(field<<3 | 2))
+	b.uvarint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *otlpbuf) str(field int, s string) {
+	if s != "" {
+		b.bytes(field, []byte(s))
+	}
+}
+
+func (b *otlpbuf) int(field, v int) {
+	if v != 0 {
+		b.uvarint(uint64(field << 3))
+		b.uvarint(uint64(v))
+	}
+}
+
+func (b *otlpbuf) fixed64(field int, v uint64) {
+	b.uvarint(uint64(field<<3 | 1))
+	*b = append(*b, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64((*b)[len(*b)-8:], v)
+}
+
+// keyValue is an attribute with a string value
+func keyValue(k, v string) otlpbuf {
+	var av, kv otlpbuf
+	av.str(1, v)
+	kv.str(1, k)
+	kv.bytes(2, av)
+	return kv
+}
+
+// encodeSpan is a Span message
+func encodeSpan(s otlpSpan) otlpbuf {
+	var b otlpbuf
+	trace := make([]byte, 16) // the same 128 bit id as -traceids w3c, the 32 bit trace id at the end
+	binary.BigEndian.PutUint64(trace[8:], uint64(s.trace))
+	b.bytes(1, trace)
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, s.id)
+	b.bytes(2, id)
+	if s.parent != 0 {
+		parent := make([]byte, 8)
+		binary.BigEndian.PutUint64(parent, s.parent)
+		b.bytes(4, parent)
+	}
+	b.str(5, s.name)
+	b.int(6, s.kind)
+	b.fixed64(7, uint64(s.start))
+	b.fixed64(8, uint64(s.end))
+	for _, a := range s.attributes {
+		b.bytes(9, keyValue(a[0], a[1]))
+	}
+	if s.failed {
+		var st otlpbuf
+		st.str(2, strings.TrimPrefix(s.response, "!"))
+		st.int(3, otlpStatusError)
+		b.bytes(15, st)
+	}
+	return b
+}
+
+// encodeOTLP is an ExportTraceServiceRequest with a ResourceSpans for each instance, its service.name is the service and its
+// service.instance.id the instance
+func encodeOTLP(spans []otlpSpan) []byte {
+	var hosts []string
+	byHost := make(map[string][]otlpSpan)
+	for _, s := range spans {
+		if byHost[s.host] == nil {
+			hosts = append(hosts, s.host)
+		}
+		byHost[s.host] = append(byHost[s.host], s)
+	}
+	var req otlpbuf
+	for _, h := range hosts {
+		service := names.Service(h)
+		if service == "" {
+			service = h
+		}
+		var res, scope, ss, rs otlpbuf
+		res.bytes(1, keyValue("service.name", service))
+		res.bytes(1, keyValue("service.instance.id", h))
+		if ip := dhcp.Lookup(h); ip != "" {
+			res.bytes(1, keyValue("host.ip", ip))
+		}
+		res.bytes(1, keyValue("spigo.arch", archaius.Conf.Arch))
+		scope.str(1, "spigo")
+		ss.bytes(1, scope)
+		for _, s := range byHost[h] {
+			ss.bytes(2, encodeSpan(s))
+		}
+		rs.bytes(1, res)
+		rs.bytes(2, ss)
+		req.bytes(1, rs)
+	}
+	return req
+}
+
+// OTLPExporter sends batches of spans to an OpenTelemetry collector, over gRPC to a host:port, or over HTTP to a url
+type OTLPExporter struct {
+	url    string
+	grpc   bool
+	client *http.Client
+	sent   int
+	failed int
+}
+
+// NewOTLPExporter returns an exporter for addr, a host:port such as "localhost:4317" for OTLP/gRPC, or a url such as
+// "http://localhost:4318" for OTLP/HTTP, which posts to /v1/traces if it doesn't have a path
+func NewOTLPExporter(addr string) (*OTLPExporter, error) {
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/traces"
+		}
+		return &OTLPExporter{url: u.String(), client: &http.Client{Timeout: httpTimeout}}, nil
+	}
+	if !strings.Contains(addr, ":") {
+		return nil, fmt.Errorf("otlp needs a host:port for gRPC or an http url, not %v", addr)
+	}
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true) // gRPC without TLS, as a local collector listens
+	return &OTLPExporter{url: "http://" + addr + "/opentelemetry.proto.collector.trace.v1.TraceService/Export", grpc: true,
+		client: &http.Client{Transport: tr, Timeout: httpTimeout}}, nil
+}
+
+// Export sends one request with the spans, a failure is logged and the spans are dropped rather than stopping the run
+func (e *OTLPExporter) Export(spans []otlpSpan) {
+	msg := encodeOTLP(spans)
+	var req *http.Request
+	var err error
+	if e.grpc {
+		frame := make([]byte, 5, 5+len(msg)) // uncompressed, then the length
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+		req, err = http.NewRequest("POST", e.url, bytes.NewReader(append(frame, msg...)))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/grpc")
+			req.Header.Set("TE", "trailers")
+		}
+	} else {
+		req, err = http.NewRequest("POST", e.url, bytes.NewReader(msg))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-protobuf")
+		}
+	}
+	if err == nil {
+		var resp *http.Response
+		resp, err = e.client.Do(req)
+		if err == nil {
+			ioutil.ReadAll(resp.Body) // the trailers are there once the body has been read
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("%v", resp.Status)
+			} else if status := grpcStatus(resp); e.grpc && status != "0" {
+				err = fmt.Errorf("grpc-status %v %v", status, resp.Trailer.Get("grpc-message")+resp.Header.Get("grpc-message"))
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("OTLP Error: %v spans not sent to %v: %v\n", len(spans), e.url, err)
+		e.failed += len(spans)
+		return
+	}
+	e.sent += len(spans)
+}
+
+// grpcStatus is in the trailers, or in the headers of a response that only has trailers
+func grpcStatus(resp *http.Response) string {
+	if s := resp.Trailer.Get("grpc-status"); s != "" {
+		return s
+	}
+	return resp.Header.Get("grpc-status")
+}
+
+// WriteOTLP exports every span in the flows to the -otlp collector in batches, and records what was sent in the summary
+func WriteOTLP() {
+	if archaius.Conf.OTLP == "" {
+		return
+	}
+	e, err := NewOTLPExporter(archaius.Conf.OTLP)
+	if err != nil {
+		log.Printf("Unable to start OTLP exporter: %v\n", err)
+		return
+	}
+	spans := otlpSpans()
+	start := time.Now()
+	for i := 0; i < len(spans); i += otlpBatch {
+		end := i + otlpBatch
+		if end > len(spans) {
+			end = len(spans)
+		}
+		e.Export(spans[i:end])
+	}
+	log.Printf("Exported %v spans to %v in %v, %v failed\n", e.sent, e.url, time.Since(start), e.failed)
+	protocol := "http/protobuf"
+	if e.grpc {
+		protocol = "grpc"
+	}
+	collect.Summarize("otlp", struct {
+		Endpoint string `json:"endpoint"`
+		Protocol string `json:"protocol"`
+		Sent     int    `json:"sent"`
+		Failed   int    `json:"failed"`
+	}{e.url, protocol, e.sent, e.failed})
+}
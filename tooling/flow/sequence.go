@@ -0,0 +1,114 @@
+package flow
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// ByTime sortable annotations
+type ByTime []*spannotype
+
+func (a ByTime) Len() int           { return len(a) }
+func (a ByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByTime) Less(i, j int) bool { return a[i].Timestamp < a[j].Timestamp }
+
+// pickSequence finds the trace selected by archaius.Conf.Sequence, a trace id or "random"
+func pickSequence() (gotocol.TraceContextType, bool) {
+	if archaius.Conf.Sequence == "random" {
+		if len(flowmap) == 0 {
+			return 0, false
+		}
+		var traces []int
+		for t := range flowmap {
+			traces = append(traces, int(t))
+		}
+		sort.Ints(traces)
+		return gotocol.TraceContextType(traces[archaius.Rand("sequence").Intn(len(traces))]), true
+	}
+	id, err := strconv.ParseUint(strings.TrimLeft(archaius.Conf.Sequence, "t0"), 10, 32)
+	if err != nil {
+		log.Printf("Sequence trace id %v isn't a number or random\n", archaius.Conf.Sequence)
+		return 0, false
+	}
+	t := gotocol.TraceContextType(id)
+	return t, flowmap[t] != nil
+}
+
+// participant name for a host, shortened to the instance name when there is one
+func participant(host string) string {
+	if i := names.Instance(host); i != "" {
+		return i
+	}
+	return host
+}
+
+// WriteSequence writes one sampled trace as a PlantUML sequence diagram to json_metrics/<arch>_trace<id>.puml
+func WriteSequence() {
+	if archaius.Conf.Sequence == "" {
+		return
+	}
+	t, ok := pickSequence()
+	if !ok {
+		log.Printf("Sequence trace %v not found in flows\n", archaius.Conf.Sequence)
+		return
+	}
+	trace := make([]*spannotype, len(flowmap[t]))
+	copy(trace, flowmap[t])
+	sort.Sort(ByTime(trace))
+	fn := fmt.Sprintf("json_metrics/%v_trace%v.puml", archaius.Conf.Arch, t)
+	f, err := os.Create(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	log.Printf("Writing sequence diagram for trace %v to %v\n", t, fn)
+	f.WriteString(fmt.Sprintf("@startuml\ntitle %v trace %v\n", archaius.Conf.Arch, t))
+	// declare participants in the order they first appear
+	seen := make(map[string]bool)
+	for _, a := range trace {
+		p := participant(a.Host)
+		if !seen[p] {
+			seen[p] = true
+			f.WriteString(fmt.Sprintf("participant %q\n", p))
+		}
+	}
+	cs := make(map[string]*spannotype) // client send by span context
+	ss := make(map[string]*spannotype) // server send by span context
+	for _, a := range trace {
+		switch a.Value {
+		case CS.String():
+			cs[a.Ctx] = a
+		case SS.String():
+			ss[a.Ctx] = a
+		case SR.String():
+			if c := cs[a.Ctx]; c != nil {
+				f.WriteString(fmt.Sprintf("%q -> %q : %v %v\n", participant(c.Host), participant(a.Host), a.Imp, a.Intent))
+				f.WriteString(fmt.Sprintf("note right : %v\n", time.Duration(a.Timestamp-c.Timestamp)))
+			} else {
+				f.WriteString(fmt.Sprintf("[-> %q : %v %v\n", participant(a.Host), a.Imp, a.Intent))
+			}
+		case CR.String():
+			if s := ss[a.Ctx]; s != nil {
+				f.WriteString(fmt.Sprintf("%q --> %q : %v %v\n", participant(s.Host), participant(a.Host), a.Imp, a.Intent))
+				f.WriteString(fmt.Sprintf("note right : %v response\n", time.Duration(a.Timestamp-s.Timestamp)))
+			} else {
+				f.WriteString(fmt.Sprintf("[--> %q : %v %v\n", participant(a.Host), a.Imp, a.Intent))
+			}
+			if c := cs[a.Ctx]; c != nil {
+				f.WriteString(fmt.Sprintf("note right : %v round trip\n", time.Duration(a.Timestamp-c.Timestamp)))
+			}
+		case FF.String():
+			f.WriteString(fmt.Sprintf("note over %q : fail fast %v %v\n", participant(a.Host), a.Imp, a.Intent))
+		}
+	}
+	f.WriteString("@enduml\n")
+}
@@ -0,0 +1,95 @@
+package gotocol
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// The event log written by -eventlog has a line for every message sent with Send, GoSend and GoSendAfter, and every message
+// received by an actor that records it with Received. Each line has a logical timestamp, the sequence number of the event in
+// the run, then the actor and the count of events it has had, so the nth event of an actor can be found in another run even when
+// the actors interleave differently, what happened, the other actor, the message type, context and body. There are no wall
+// clock times, so two runs of the same scenario can be diffed to find where they diverge
+var eventLogging bool // set before any actors start, so it can be read without the lock
+var eventFile *os.File
+var eventWriter *bufio.Writer
+var eventLock sync.Mutex
+var eventSeq uint64
+var actorEvents = make(map[string]uint64)
+var actorNames = make(map[chan<- Message]string)
+
+// OpenEventLog starts writing the event log to a file, it has to be called before any actors start
+func OpenEventLog(fn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	eventFile = f
+	eventWriter = bufio.NewWriter(f)
+	eventLogging = true
+	return nil
+}
+
+// CloseEventLog flushes the event log and closes it, anything sent after that isn't logged
+func CloseEventLog() {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	if eventWriter == nil {
+		return
+	}
+	eventWriter.Flush()
+	eventFile.Close()
+	eventWriter = nil
+}
+
+// Actor names the actor that listens on a channel in the event log
+func Actor(ch chan Message, name string) {
+	if !eventLogging {
+		return
+	}
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	actorNames[ch] = name
+}
+
+// Received records that an actor has received a message
+func Received(msg Message, name string) {
+	if eventLogging {
+		logEvent("recv", name, msg.ResponseChan, msg)
+	}
+}
+
+// sent records a message being sent to a channel by the actor its response channel belongs to
+func sent(to chan<- Message, msg Message) {
+	if eventLogging {
+		logEvent("send", "", to, msg)
+	}
+}
+
+// actorName is the name of the actor on a channel, - for nil and ? for a channel that wasn't named, called with the lock held
+func actorName(ch chan<- Message) string {
+	if ch == nil {
+		return "-"
+	}
+	if n, ok := actorNames[ch]; ok {
+		return n
+	}
+	return "?"
+}
+
+// logEvent writes a line for an event at an actor, the sender of a message is the actor its response channel belongs to
+func logEvent(what, name string, peer chan<- Message, msg Message) {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	if eventWriter == nil {
+		return
+	}
+	if name == "" {
+		name = actorName(msg.ResponseChan)
+	}
+	eventSeq++
+	actorEvents[name]++
+	fmt.Fprintf(eventWriter, "%v %v#%v %v %v %v %v %q\n", eventSeq, name, actorEvents[name], what, actorName(peer), msg.Imposition, msg.Ctx, msg.Intention)
+}
@@ -4,8 +4,11 @@ package gotocol
 
 import (
 	"fmt"
+	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/adrianco/spigo/tooling/clock"
 )
 
 // Impositions is the promise theory term for requests made to a service
@@ -35,6 +38,12 @@ const (
 	Forget
 	// Delete - key Remove key and value
 	Delete
+	// Publish FromChan message Fire and forget a message to a topic
+	Publish
+	// Subscribe FromChan "group name" Join a consumer group of a topic
+	Subscribe
+	// Deliver FromChan message Topic gives a message to a consumer, acknowledged with GetResponse
+	Deliver
 	// Goodbye - name // tell FSM and exit
 	Goodbye // test assumes this is the last and exits
 	numOfImpositions
@@ -65,6 +74,12 @@ func (imps Impositions) String() string {
 		return "Forget"
 	case Delete:
 		return "Delete"
+	case Publish:
+		return "Publish"
+	case Subscribe:
+		return "Subscribe"
+	case Deliver:
+		return "Deliver"
 	case Goodbye:
 		return "Goodbye"
 	}
@@ -77,6 +92,11 @@ type TraceContextType uint32 // needs to match type conversions in func incremen
 // Context for capturing dapper/zipkin style traces
 type Context struct {
 	Trace, Parent, Span TraceContextType
+	Baggage             string           // comma separated key=value items, copied to every child span
+	Deadline            int64            // unix nanosecond time the whole request must complete by, zero for no deadline
+	Response            int64            // nanoseconds the response to this span is delayed by, set by the caller
+	Request             TraceContextType // idempotency key, the same for every retry of a call, zero if the caller doesn't set one
+	Hops                int              // calls between the start of the request and this span
 }
 
 // string formatter for context
@@ -92,6 +112,9 @@ func (ctx Context) Route() string {
 // fast hack for generating unique-enough contexts
 var spanner TraceContextType
 
+// idempotency keys are counted separately from spans
+var requester TraceContextType
+
 // return uniquely incremented TraceContextType
 func increment(tc *TraceContextType) TraceContextType {
 	return TraceContextType(atomic.AddUint32((*uint32)(tc), 1))
@@ -116,9 +139,82 @@ func (ctx Context) AddSpan() Context {
 // NewParent sets up the parent by promoting incoming span id, and get a new spanid
 func (ctx Context) NewParent() Context {
 	ctx.Parent = ctx.Span
+	ctx.Response = 0 // belongs to the parent span
+	ctx.Request = 0  // so does the idempotency key
+	ctx.Hops++
 	return ctx.AddSpan()
 }
 
+// WithBaggage returns a context carrying key=value, replacing any existing value for the key
+func (ctx Context) WithBaggage(key, value string) Context {
+	items := []string{key + "=" + value}
+	if ctx.Baggage != "" {
+		for _, kv := range strings.Split(ctx.Baggage, ",") {
+			if !strings.HasPrefix(kv, key+"=") {
+				items = append(items, kv)
+			}
+		}
+	}
+	ctx.Baggage = strings.Join(items, ",")
+	return ctx
+}
+
+// BaggageItem returns the value for a key in the propagated baggage, or "" if it isn't there
+func (ctx Context) BaggageItem(key string) string {
+	if ctx.Baggage == "" {
+		return ""
+	}
+	for _, kv := range strings.Split(ctx.Baggage, ",") {
+		if strings.HasPrefix(kv, key+"=") {
+			return strings.TrimPrefix(kv, key+"=")
+		}
+	}
+	return ""
+}
+
+// HasBaggage checks for a key=value item in the propagated baggage
+func (ctx Context) HasBaggage(item string) bool {
+	kv := strings.SplitN(item, "=", 2)
+	return len(kv) == 2 && ctx.BaggageItem(kv[0]) == kv[1]
+}
+
+// WithDeadline returns a context that must complete within d from now
+func (ctx Context) WithDeadline(d time.Duration) Context {
+	ctx.Deadline = clock.Now().Add(d).UnixNano()
+	return ctx
+}
+
+// Remaining time before the deadline, negative if it has passed, ok is false if there is no deadline
+func (ctx Context) Remaining() (d time.Duration, ok bool) {
+	if ctx.Deadline == 0 {
+		return 0, false
+	}
+	return time.Unix(0, ctx.Deadline).Sub(clock.Now()), true
+}
+
+// Exceeds is true if a call expected to take this long would miss the deadline
+func (ctx Context) Exceeds(expected time.Duration) bool {
+	r, ok := ctx.Remaining()
+	return ok && r < expected
+}
+
+// WithResponse returns a context whose response is delayed by d, modeling a slower response direction
+func (ctx Context) WithResponse(d time.Duration) Context {
+	ctx.Response = int64(d)
+	return ctx
+}
+
+// NewRequest returns a new idempotency key, to be reused by every retry of a call
+func NewRequest() TraceContextType {
+	return increment(&requester)
+}
+
+// WithRequest returns a context carrying an idempotency key, so the service that gets it can detect a retry it has already done
+func (ctx Context) WithRequest(id TraceContextType) Context {
+	ctx.Request = id
+	return ctx
+}
+
 // NilContext makes an empty context, I can't figure out how to make this a const
 var NilContext Context
 
@@ -131,15 +227,26 @@ type Message struct {
 	Intention    string       // payload
 }
 
+// Failure marks a response payload as a failed request, with the reason
+func Failure(reason string) string {
+	return "!" + reason
+}
+
+// Failed checks if a response payload is a failure
+func Failed(intention string) bool {
+	return strings.HasPrefix(intention, "!")
+}
+
 func (msg Message) String() string {
-	return fmt.Sprintf("gotocol: %v %v %v %v", time.Since(msg.Sent), msg.Ctx, msg.Imposition, msg.Intention)
+	return fmt.Sprintf("gotocol: %v %v %v %v", clock.Since(msg.Sent), msg.Ctx, msg.Imposition, msg.Intention)
 }
 
 // Routetype information from a message
 type Routetype struct {
 	Ctx          Context
 	ResponseChan chan Message
-	State        int // state machine for managing responses
+	State        int       // state machine for managing responses
+	Sent         time.Time // time at which the request was sent, to measure response time
 }
 
 // Route extracts routing information from a message
@@ -147,6 +254,7 @@ func (msg Message) Route() Routetype {
 	var r Routetype
 	r.Ctx = msg.Ctx
 	r.ResponseChan = msg.ResponseChan
+	r.Sent = msg.Sent
 	return r
 }
 
@@ -157,6 +265,7 @@ func PickRoute(rmap map[string]Routetype, msg Message) Routetype {
 
 // Send a synchronous message
 func Send(to chan<- Message, msg Message) {
+	sent(to, msg)
 	if to != nil {
 		to <- msg
 	}
@@ -164,7 +273,28 @@ func Send(to chan<- Message, msg Message) {
 
 // GoSend asynchronous message send, parks it on a new goroutine until it completes
 func (msg Message) GoSend(to chan Message) {
+	sent(to, msg)
+	go func(c chan Message, m Message) {
+		if c != nil {
+			c <- m
+		}
+	}(to, msg)
+}
+
+// GoRespond asynchronous response send, delayed by the response latency of the request context
+func (msg Message) GoRespond(to chan Message) {
+	msg.GoSendAfter(to, time.Duration(msg.Ctx.Response))
+}
+
+// GoSendAfter asynchronous message send after a delay, parks it on a new goroutine until it completes
+func (msg Message) GoSendAfter(to chan Message, d time.Duration) {
+	if d <= 0 {
+		msg.GoSend(to)
+		return
+	}
+	sent(to, msg)
 	go func(c chan Message, m Message) {
+		clock.Sleep(d)
 		if c != nil {
 			c <- m
 		}
@@ -3,6 +3,9 @@ package gotocol
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -37,6 +40,9 @@ func pirateListen(listener chan Message) {
 		case Put:
 		case Forget:
 		case Delete:
+		case Publish:
+		case Subscribe:
+		case Deliver:
 		case Goodbye:
 			return
 		}
@@ -69,3 +75,93 @@ func TestImpose(t *testing.T) {
 	fmt.Println("closed len(p2p): ", len(p2p))
 
 }
+
+func TestBaggage(t *testing.T) {
+	ctx := NewTrace().WithBaggage("tenant", "gold").WithBaggage("beta", "on")
+	child := ctx.NewParent().WithBaggage("tenant", "silver")
+	fmt.Println("Baggage: ", ctx.Baggage, child.Baggage)
+	if ctx.BaggageItem("tenant") != "gold" || child.BaggageItem("tenant") != "silver" {
+		t.Fail()
+	}
+	if !child.HasBaggage("beta=on") || child.HasBaggage("beta=off") || NilContext.HasBaggage("beta=on") {
+		t.Fail()
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	ctx := NewTrace()
+	if ctx.Exceeds(time.Hour) {
+		t.Fail() // no deadline set
+	}
+	ctx = ctx.WithDeadline(100 * time.Millisecond).NewParent()
+	r, ok := ctx.Remaining()
+	fmt.Println("Remaining: ", r, ok)
+	if !ok || ctx.Exceeds(time.Millisecond) || !ctx.Exceeds(time.Second) {
+		t.Fail()
+	}
+	if !Failed(Failure("deadline")) || Failed("why?") {
+		t.Fail()
+	}
+}
+
+func TestResponse(t *testing.T) {
+	ctx := NewTrace().WithResponse(20 * time.Millisecond)
+	fmt.Println("Response: ", time.Duration(ctx.Response))
+	if ctx.AddSpan().Response != ctx.Response || ctx.NewParent().Response != 0 {
+		t.Fail() // forwarded spans keep the caller's response latency, child spans don't
+	}
+	c := make(chan Message)
+	start := time.Now()
+	Message{GetResponse, nil, start, ctx, "done"}.GoRespond(c)
+	<-c
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fail()
+	}
+}
+
+func TestRequest(t *testing.T) {
+	id := NewRequest()
+	ctx := NewTrace().WithRequest(id)
+	retry := NewTrace().NewParent().WithRequest(id) // a retry is a new span with the same key
+	fmt.Println("Request: ", ctx, ctx.Request, retry.Request)
+	if retry.Request != ctx.Request || ctx.NewParent().Request != 0 || NewRequest() == id {
+		t.Fail()
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	f, err := ioutil.TempFile("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := OpenEventLog(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	a, b := make(chan Message), make(chan Message)
+	Actor(a, "alice")
+	Actor(b, "bob")
+	Message{GetRequest, a, time.Now(), NilContext, "ahoy"}.GoSend(b)
+	Received(<-b, "bob")
+	Message{GetResponse, b, time.Now(), NilContext, "arr"}.GoSendAfter(a, time.Millisecond)
+	Received(<-a, "alice")
+	CloseEventLog()
+	Message{Put, a, time.Now(), NilContext, "too late"}.GoSend(nil) // after the log is closed
+	data, _ := ioutil.ReadFile(f.Name())
+	var events []string
+	for _, l := range strings.Split(string(data), "\n") {
+		if strings.Contains(l, "alice") { // other tests may still have messages in flight
+			events = append(events, l[strings.Index(l, " ")+1:])
+		}
+	}
+	want := []string{
+		`alice#1 send bob GetRequest t0p0s0 "ahoy"`,
+		`bob#1 recv alice GetRequest t0p0s0 "ahoy"`,
+		`bob#2 send alice GetResponse t0p0s0 "arr"`,
+		`alice#2 recv bob GetResponse t0p0s0 "arr"`,
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong events\n%v", string(data))
+	}
+}
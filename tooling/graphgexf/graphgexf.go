@@ -1,70 +1,186 @@
-// Package graphgexf writes nodes and edges to spigo.graphml in the XML based GEXFformat used by
-// the freely available Gephi tool
+// Package graphgexf writes nodes and edges to gexf/<arch>.gexf in the XML based GEXF format used by
+// the freely available Gephi tool, with the service type, region and call counts as attributes for its layouts and filters
 package graphgexf
 
 import (
+	"bytes"
+	"encoding/xml"
 	"fmt"
-	"github.com/adrianco/spigo/tooling/archaius"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
 )
 
-// Enabled is set by command line flags to turn on graphml logging
+// Enabled is set by command line flags to turn on gexf logging
 var Enabled bool
 
-var file *os.File
-var edgeid int // unique id for each edge to keep graphml happy
+var filename string
 
-// Setup opens the file and and writes the header
-func Setup(filename string) {
-	Enabled = true
+// node attributes, Gephi needs the same nodes written once with all their attributes, so they're kept until Close
+type node struct {
+	service, pack, region, zone, tags, t string
+}
+
+var nodes = make(map[string]node)
+var edges = make(map[string]string) // space separated from and to names, to the time the edge was made
+
+// node and edge attribute ids and types, declared in the file header
+var nodeAttributes = [][2]string{{"service", "string"}, {"package", "string"}, {"region", "string"}, {"zone", "string"}, {"tags", "string"}, {"timestamp", "string"}, {"callsin", "integer"}, {"callsout", "integer"}}
+var edgeAttributes = [][2]string{{"calls", "integer"}, {"timestamp", "string"}}
+
+// Setup remembers the file name, nothing is written until Close
+func Setup(name string) {
 	ss := ""
 	if archaius.Conf.StopStep > 0 {
 		ss = fmt.Sprintf("%v", archaius.Conf.StopStep)
 	}
-	file, _ = os.Create("gml/" + filename + ss + ".graphml")
-	file.WriteString(
-		"<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n  <graphml xmlns=\"http://graphml.graphdrawing.org/xmlns/graphml\"\n   xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\"\n   xsi:schemaLocation=\"http://graphml.graphdrawing.org/xmlns/graphml http://www.yworks.com/xml/schema/graphml/1.0/ygraphml.xsd\"\n    xmlns:y=\"http://www.yworks.com/xml/graphml\">\n    <key id=\"d0\" for=\"node\" yfiles.type=\"nodegraphics\"/>\n    <key id=\"d1\" for=\"edge\" yfiles.type=\"edgegraphics\"/>\n    <key id=\"d2\" for=\"node\" attr.name=\"Text\" attr.type=\"string\"/>\n    <graph id=\"spigo\" edgedefault=\"directed\">\n")
+	SetupFile("gexf/" + name + ss + ".gexf")
 }
 
-// WriteNode logs a node in the file given a space separated name and service type
-func WriteNode(nameService string) {
+// SetupFile remembers the full file name, and its directory is made at Close
+func SetupFile(fn string) {
+	Enabled = true
+	filename = fn
+}
+
+// WriteNode records a node given its name, the service and package it runs and where it is, tags are written as key=value,key=value,
+// and the time it was made unless it's zero
+func WriteNode(name, service, pack, region, zone string, tags map[string]string, t time.Time) {
 	if Enabled == false {
 		return
 	}
-	var name, service string
-	fmt.Sscanf(nameService, "%s%s", &name, &service) // space delimited
-	// node name should be unique and service indicates service type
-	file.WriteString(fmt.Sprintf("      <node id=\"%v\"><data key=\"service\">%v</data></node>\n", name, service))
+	var kv []string
+	for k, v := range tags {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+	nodes[name] = node{service, pack, region, zone, strings.Join(kv, ","), timestamp(t)}
 }
 
-func edge(from, to string) string {
+// WriteEdge records an edge given a space separated from and to name, and the time it was made unless it's zero
+func WriteEdge(fromTo string, t time.Time) {
 	if Enabled == false {
+		return
+	}
+	var from, to string
+	fmt.Sscanf(fromTo, "%s%s", &from, &to) // two space delimited names
+	edges[from+" "+to] = timestamp(t)
+}
+
+// timestamp of a node or edge, empty if the time isn't known
+func timestamp(t time.Time) string {
+	if t.IsZero() {
 		return ""
 	}
-	edgeid = edgeid + 1
-	return fmt.Sprintf("      <edge id=\"e%v\" source=\"%v\" target=\"%v\"/>\n", edgeid, from, to)
+	return t.Format(time.RFC3339Nano)
 }
 
-// Write a string to the file
-func Write(str string) {
-	file.WriteString(str)
+func escape(s string) string {
+	var esc bytes.Buffer
+	xml.EscapeText(&esc, []byte(s))
+	return esc.String()
 }
 
-// WriteEdge logs and edge in the file given a space separated from and to name
-func WriteEdge(fromTo string) {
-	if Enabled == false {
-		return
+func attributes(class string, attrs [][2]string) string {
+	s := fmt.Sprintf("    <attributes class=\"%v\">\n", class)
+	for _, a := range attrs {
+		s += fmt.Sprintf("      <attribute id=\"%v\" title=\"%v\" type=\"%v\"/>\n", a[0], a[0], a[1])
 	}
-	var from, to string
-	fmt.Sscanf(fromTo, "%s%s", &from, &to) // two space delimited names
-	file.WriteString(edge(from, to))
+	return s + "    </attributes>\n"
 }
 
-// Close finishes off the file footer and closes it
-func Close() {
+// Close writes the file, calls counts the calls along each edge from the flows, and is nil if they weren't collected
+func Close(calls map[string]map[string]int) {
 	if Enabled == false {
 		return
 	}
-	file.WriteString("    </graph>\n  </graphml>\n")
-	file.Close()
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Fatal(err)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	log.Printf("Writing %v nodes and %v edges to %v\n", len(nodes), len(edges), filename)
+	in := make(map[string]int)
+	out := make(map[string]int)
+	for from, row := range calls {
+		for to, n := range row {
+			out[from] += n
+			in[to] += n
+		}
+	}
+	file.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<gexf xmlns=\"http://www.gexf.net/1.2draft\" version=\"1.2\">\n")
+	file.WriteString(fmt.Sprintf("  <meta lastmodifieddate=\"%v\">\n    <creator>spigo</creator>\n    <description>%v</description>\n  </meta>\n",
+		time.Now().Format("2006-01-02"), escape(archaius.Conf.Arch)))
+	file.WriteString("  <graph mode=\"static\" defaultedgetype=\"directed\">\n")
+	file.WriteString(attributes("node", nodeAttributes))
+	file.WriteString(attributes("edge", edgeAttributes))
+	var ns []string
+	for n := range nodes {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	file.WriteString(fmt.Sprintf("    <nodes count=\"%v\">\n", len(ns)))
+	for _, n := range ns {
+		nd := nodes[n]
+		file.WriteString(fmt.Sprintf("      <node id=\"%v\" label=\"%v\">\n        <attvalues>\n", escape(n), escape(n)))
+		values := []string{nd.service, nd.pack, nd.region, nd.zone, nd.tags, nd.t}
+		if calls != nil {
+			values = append(values, fmt.Sprint(in[n]), fmt.Sprint(out[n]))
+		}
+		for i, v := range values {
+			if v != "" {
+				file.WriteString(fmt.Sprintf("          <attvalue for=\"%v\" value=\"%v\"/>\n", nodeAttributes[i][0], escape(v)))
+			}
+		}
+		file.WriteString("        </attvalues>\n      </node>\n")
+	}
+	file.WriteString("    </nodes>\n")
+	var es []string
+	for e := range edges {
+		var from, to string
+		fmt.Sscanf(e, "%s%s", &from, &to)
+		if _, ok := nodes[from]; ok { // edges to nodes that were filtered out would stop Gephi loading the file
+			if _, ok := nodes[to]; ok {
+				es = append(es, e)
+			}
+		}
+	}
+	sort.Strings(es)
+	file.WriteString(fmt.Sprintf("    <edges count=\"%v\">\n", len(es)))
+	for i, e := range es {
+		var from, to string
+		fmt.Sscanf(e, "%s%s", &from, &to)
+		n := calls[from][to]
+		weight := 1 // Gephi sizes edges by weight, and the layouts pull busy edges tighter
+		if n > 0 {
+			weight = n
+		}
+		var values string
+		if calls != nil {
+			values += fmt.Sprintf("          <attvalue for=\"calls\" value=\"%v\"/>\n", n)
+		}
+		if t := edges[e]; t != "" {
+			values += fmt.Sprintf("          <attvalue for=\"timestamp\" value=\"%v\"/>\n", t)
+		}
+		switch {
+		case values == "":
+			file.WriteString(fmt.Sprintf("      <edge id=\"e%v\" source=\"%v\" target=\"%v\"/>\n", i, escape(from), escape(to)))
+		case calls == nil:
+			file.WriteString(fmt.Sprintf("      <edge id=\"e%v\" source=\"%v\" target=\"%v\">\n", i, escape(from), escape(to)))
+		default:
+			file.WriteString(fmt.Sprintf("      <edge id=\"e%v\" source=\"%v\" target=\"%v\" weight=\"%v\">\n", i, escape(from), escape(to), weight))
+		}
+		if values != "" {
+			file.WriteString("        <attvalues>\n" + values + "        </attvalues>\n      </edge>\n")
+		}
+	}
+	file.WriteString("    </edges>\n  </graph>\n</gexf>\n")
 }
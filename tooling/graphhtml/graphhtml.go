@@ -0,0 +1,377 @@
+// Package graphhtml writes nodes and edges to json/<arch>.html, a standalone page with the graph inlined as json and a small
+// force directed layout, so it can be opened and explored in a browser without a server or any other files
+package graphhtml
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// Enabled is set by command line flags to turn on html logging
+var Enabled bool
+
+var filename string
+
+// Node is written once with everything that's known about it, when the file is written at Close
+type Node struct {
+	Name     string `json:"name"`
+	Service  string `json:"service"`
+	Package  string `json:"package"`
+	Region   string `json:"region,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Tags     string `json:"tags,omitempty"`
+	CallsIn  int    `json:"callsin,omitempty"`
+	CallsOut int    `json:"callsout,omitempty"`
+}
+
+// Edge is a dependency between two nodes, with the calls along it if the flows were collected
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Calls  int    `json:"calls,omitempty"`
+}
+
+// Graph is inlined in the page
+type Graph struct {
+	Arch  string `json:"arch"`
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+var nodes = make(map[string]Node)
+var edges = make(map[string]bool) // space separated from and to names
+
+// Setup remembers the file name, nothing is written until Close
+func Setup(name string) {
+	ss := ""
+	if archaius.Conf.StopStep > 0 {
+		ss = fmt.Sprintf("%v", archaius.Conf.StopStep)
+	}
+	SetupFile("json/" + name + ss + ".html")
+}
+
+// SetupFile remembers the full file name, and its directory is made at Close
+func SetupFile(fn string) {
+	Enabled = true
+	filename = fn
+}
+
+// WriteNode records a node given its name, the service and package it runs and where it is, tags are written as key=value,key=value
+func WriteNode(name, service, pack, region, zone string, tags map[string]string) {
+	if Enabled == false {
+		return
+	}
+	var kv []string
+	for k, v := range tags {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+	nodes[name] = Node{Name: name, Service: service, Package: pack, Region: region, Zone: zone, Tags: strings.Join(kv, ",")}
+}
+
+// WriteEdge records an edge given a space separated from and to name
+func WriteEdge(fromTo string) {
+	if Enabled == false {
+		return
+	}
+	var from, to string
+	fmt.Sscanf(fromTo, "%s%s", &from, &to) // two space delimited names
+	edges[from+" "+to] = true
+}
+
+// graph sorts the nodes and the edges between them, and adds the calls if they were counted
+func graph(calls map[string]map[string]int) Graph {
+	g := Graph{Arch: archaius.Conf.Arch, Nodes: []Node{}, Edges: []Edge{}}
+	var ns []string
+	for n := range nodes {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	in := make(map[string]int)
+	out := make(map[string]int)
+	for from, row := range calls {
+		for to, n := range row {
+			out[from] += n
+			in[to] += n
+		}
+	}
+	for _, n := range ns {
+		nd := nodes[n]
+		nd.CallsIn, nd.CallsOut = in[n], out[n]
+		g.Nodes = append(g.Nodes, nd)
+	}
+	var es []string
+	for e := range edges {
+		es = append(es, e)
+	}
+	sort.Strings(es)
+	for _, e := range es {
+		var from, to string
+		fmt.Sscanf(e, "%s%s", &from, &to)
+		if _, ok := nodes[from]; !ok { // edges to nodes that were filtered out can't be drawn
+			continue
+		}
+		if _, ok := nodes[to]; !ok {
+			continue
+		}
+		g.Edges = append(g.Edges, Edge{from, to, calls[from][to]})
+	}
+	return g
+}
+
+// Close writes the file, calls counts the calls along each edge from the flows, and is nil if they weren't collected
+func Close(calls map[string]map[string]int) {
+	if Enabled == false {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Fatal(err)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	g := graph(calls)
+	log.Printf("Writing %v nodes and %v edges to %v\n", len(g.Nodes), len(g.Edges), filename)
+	if err := page.Execute(file, g); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// page escapes the graph as json inside the script, so names can't break out of it
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>spigo {{.Arch}}</title>
+<style>
+body { margin: 0; font: 12px sans-serif; overflow: hidden; }
+svg { display: block; width: 100vw; height: 100vh; cursor: move; }
+line { stroke: #999; stroke-opacity: 0.6; }
+circle { stroke: #fff; stroke-width: 1.5px; cursor: pointer; }
+.faded { opacity: 0.1; }
+#panel { position: absolute; top: 8px; left: 8px; max-width: 320px; padding: 6px 8px; background: rgba(255,255,255,0.9); border: 1px solid #ccc; }
+#panel h1 { margin: 0 0 4px; font-size: 14px; }
+#panel table { border-collapse: collapse; }
+#panel td { padding: 0 6px 0 0; vertical-align: top; }
+#legend span { display: inline-block; margin-right: 8px; }
+#legend i { display: inline-block; width: 10px; height: 10px; margin-right: 3px; border-radius: 5px; }
+</style>
+</head>
+<body>
+<svg id="graph"></svg>
+<div id="panel">
+<h1>{{.Arch}}</h1>
+<div id="counts"></div>
+<div id="legend"></div>
+<input id="find" placeholder="find a node">
+<div id="detail">Drag the nodes, drag the background to pan, scroll to zoom, and click a node to see it and its neighbors.</div>
+</div>
+<script>
+var graph = {{.}};
+</script>
+<script>
+(function() {
+	var svgns = "http://www.w3.org/2000/svg";
+	var svg = document.getElementById("graph");
+	var view = document.createElementNS(svgns, "g");
+	svg.appendChild(view);
+	var width = window.innerWidth, height = window.innerHeight;
+	var colors = ["#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"];
+	var color = {}, byName = {}, nodes = graph.nodes, edges = graph.edges;
+	var k = Math.sqrt(width * height / Math.max(1, nodes.length)) / 2; // natural distance between nodes
+	var alpha = 1, drag = null, pan = null, selected = null;
+	var zoom = { x: 0, y: 0, s: 1 };
+
+	function el(name, attrs, parent) {
+		var e = document.createElementNS(svgns, name);
+		for (var a in attrs) {
+			e.setAttribute(a, attrs[a]);
+		}
+		parent.appendChild(e);
+		return e;
+	}
+
+	function tooltip(e, text) {
+		el("title", {}, e).textContent = text;
+	}
+
+	nodes.forEach(function(n, i) {
+		byName[n.name] = n;
+		if (!(n.package in color)) {
+			color[n.package] = colors[Object.keys(color).length % colors.length];
+		}
+		var a = 2 * Math.PI * i / nodes.length; // start on a circle so the layout is the same every time
+		n.x = width / 2 + width / 4 * Math.cos(a);
+		n.y = height / 2 + height / 4 * Math.sin(a);
+		n.neighbors = {};
+	});
+	var most = 1;
+	edges.forEach(function(e) {
+		e.s = byName[e.source];
+		e.t = byName[e.target];
+		e.s.neighbors[e.t.name] = true;
+		e.t.neighbors[e.s.name] = true;
+		most = Math.max(most, e.calls || 0);
+	});
+	edges.forEach(function(e) {
+		e.line = el("line", { "stroke-width": 1 + 4 * (e.calls || 0) / most }, view);
+		tooltip(e.line, e.source + " -> " + e.target + (e.calls ? ", " + e.calls + " calls" : ""));
+	});
+	nodes.forEach(function(n) {
+		n.circle = el("circle", { r: 4 + Math.min(8, Object.keys(n.neighbors).length), fill: color[n.package] }, view);
+		tooltip(n.circle, n.name);
+		n.circle.addEventListener("mousedown", function(ev) {
+			drag = n;
+			ev.stopPropagation();
+		});
+		n.circle.addEventListener("click", function() {
+			select(n);
+		});
+	});
+
+	document.getElementById("counts").textContent = nodes.length + " nodes, " + edges.length + " edges";
+	var legend = document.getElementById("legend");
+	Object.keys(color).sort().forEach(function(p) {
+		var s = document.createElement("span");
+		s.innerHTML = "<i></i>";
+		s.firstChild.style.background = color[p];
+		s.appendChild(document.createTextNode(p));
+		legend.appendChild(s);
+	});
+
+	// select shows the details of a node and fades everything that isn't it or a neighbor, selecting it again clears it
+	function select(n) {
+		selected = selected === n ? null : n;
+		nodes.forEach(function(m) {
+			m.circle.setAttribute("class", selected && m !== selected && !selected.neighbors[m.name] ? "faded" : "");
+		});
+		edges.forEach(function(e) {
+			e.line.setAttribute("class", selected && e.s !== selected && e.t !== selected ? "faded" : "");
+		});
+		var detail = document.getElementById("detail");
+		detail.textContent = "";
+		if (!selected) {
+			return;
+		}
+		var table = document.createElement("table");
+		["name", "service", "package", "region", "zone", "tags", "callsin", "callsout"].forEach(function(f) {
+			if (selected[f]) {
+				var row = table.insertRow();
+				row.insertCell().textContent = f;
+				row.insertCell().textContent = selected[f];
+			}
+		});
+		var row = table.insertRow();
+		row.insertCell().textContent = "neighbors";
+		row.insertCell().textContent = Object.keys(selected.neighbors).sort().join(" ");
+		detail.appendChild(table);
+	}
+
+	document.getElementById("find").addEventListener("change", function() {
+		var q = this.value;
+		for (var i = 0; i < nodes.length; i++) {
+			if (q && nodes[i].name.indexOf(q) >= 0) {
+				selected = null;
+				select(nodes[i]);
+				return;
+			}
+		}
+	});
+
+	// step moves the nodes apart and pulls connected ones together, by less each time as the layout cools
+	function step() {
+		nodes.forEach(function(n) {
+			n.dx = (width / 2 - n.x) * 0.01; // gravity keeps disconnected parts in view
+			n.dy = (height / 2 - n.y) * 0.01;
+		});
+		for (var i = 0; i < nodes.length; i++) {
+			for (var j = i + 1; j < nodes.length; j++) {
+				var a = nodes[i], b = nodes[j], x = a.x - b.x, y = a.y - b.y, d2 = Math.max(x * x + y * y, 0.01), f = k * k / d2;
+				a.dx += x * f;
+				a.dy += y * f;
+				b.dx -= x * f;
+				b.dy -= y * f;
+			}
+		}
+		edges.forEach(function(e) {
+			var x = e.t.x - e.s.x, y = e.t.y - e.s.y, f = Math.sqrt(x * x + y * y) / k;
+			e.s.dx += x * f;
+			e.s.dy += y * f;
+			e.t.dx -= x * f;
+			e.t.dy -= y * f;
+		});
+		var limit = k * alpha;
+		nodes.forEach(function(n) {
+			var d = Math.sqrt(n.dx * n.dx + n.dy * n.dy);
+			if (n === drag || d === 0) {
+				return;
+			}
+			n.x += n.dx / d * Math.min(d, limit);
+			n.y += n.dy / d * Math.min(d, limit);
+		});
+		alpha *= 0.98;
+	}
+
+	function draw() {
+		view.setAttribute("transform", "translate(" + zoom.x + "," + zoom.y + ") scale(" + zoom.s + ")");
+		edges.forEach(function(e) {
+			e.line.setAttribute("x1", e.s.x);
+			e.line.setAttribute("y1", e.s.y);
+			e.line.setAttribute("x2", e.t.x);
+			e.line.setAttribute("y2", e.t.y);
+		});
+		nodes.forEach(function(n) {
+			n.circle.setAttribute("cx", n.x);
+			n.circle.setAttribute("cy", n.y);
+		});
+	}
+
+	function tick() {
+		if (alpha > 0.01) {
+			step();
+			draw();
+		}
+		window.requestAnimationFrame(tick);
+	}
+
+	svg.addEventListener("mousedown", function(ev) {
+		pan = { x: ev.clientX - zoom.x, y: ev.clientY - zoom.y };
+	});
+	window.addEventListener("mousemove", function(ev) {
+		if (drag) {
+			drag.x = (ev.clientX - zoom.x) / zoom.s;
+			drag.y = (ev.clientY - zoom.y) / zoom.s;
+			alpha = Math.max(alpha, 0.1); // the neighbors follow it
+		} else if (pan) {
+			zoom.x = ev.clientX - pan.x;
+			zoom.y = ev.clientY - pan.y;
+		}
+		draw();
+	});
+	window.addEventListener("mouseup", function() {
+		drag = null;
+		pan = null;
+	});
+	svg.addEventListener("wheel", function(ev) {
+		ev.preventDefault();
+		var s = Math.max(0.1, Math.min(10, zoom.s * (ev.deltaY < 0 ? 1.1 : 1 / 1.1)));
+		zoom.x = ev.clientX - (ev.clientX - zoom.x) * s / zoom.s; // zoom about the pointer
+		zoom.y = ev.clientY - (ev.clientY - zoom.y) * s / zoom.s;
+		zoom.s = s;
+		draw();
+	});
+	tick();
+})();
+</script>
+</body>
+</html>
+`))
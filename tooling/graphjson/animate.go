@@ -0,0 +1,38 @@
+package graphjson
+
+import "github.com/adrianco/spigo/tooling/archaius"
+
+// AnimationV0r1 is a graph and the calls made over its edges in time order, written with -animate so a player can
+// move traffic across the topology without joining the flows to the graph itself. Times are milliseconds from Start
+type AnimationV0r1 struct {
+	Arch     string            `json:"arch"`
+	Version  string            `json:"version"` // animate-0.1
+	Args     string            `json:"args"`
+	Run      *archaius.RunInfo `json:"run,omitempty"`
+	Start    string            `json:"start"`      // timestamp of the first call
+	Duration float64           `json:"durationms"` // until the last call ended
+	Nodes    []NodeV0r4        `json:"nodes"`
+	Edges    []EdgeV0r4        `json:"edges"` // every edge that carried at least one call
+	Events   []ActivationV0r1  `json:"events"`
+	Marks    []MarkV0r1        `json:"marks,omitempty"`
+}
+
+// ActivationV0r1 is one call over an edge, from the client send to the client receive. The server receive and send are
+// there so the request and response can be drawn moving separately, and the ones that never happened are left out
+type ActivationV0r1 struct {
+	Edge    string  `json:"edge"`
+	Trace   string  `json:"trace"`
+	Send    float64 `json:"sendms"`
+	Arrive  float64 `json:"arrivems,omitempty"`
+	Reply   float64 `json:"replyms,omitempty"`
+	Return  float64 `json:"returnms,omitempty"`
+	Latency float64 `json:"latencyms,omitempty"` // round trip seen by the caller
+	Failed  bool    `json:"failed,omitempty"`
+}
+
+// MarkV0r1 is a timeline event such as a chaos monkey kill, to show on the playback
+type MarkV0r1 struct {
+	At     float64 `json:"atms"`
+	Kind   string  `json:"kind"`
+	Detail string  `json:"detail"`
+}
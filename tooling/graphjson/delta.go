@@ -0,0 +1,110 @@
+package graphjson
+
+import (
+	"sort"
+)
+
+// Snapshot is the state of a graph at the end of a file, nodes that are still running and edges that haven't been forgotten
+type Snapshot struct {
+	Nodes map[string]NodeV0r4 // by node name
+	Edges map[string]EdgeV0r4 // by "source target"
+}
+
+// DeltaV0r4 lists what was added and removed between two stepped snapshots of the same architecture
+type DeltaV0r4 struct {
+	Arch    string    `json:"arch"`
+	Version string    `json:"version"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Added   GraphDiff `json:"added"`
+	Removed GraphDiff `json:"removed"`
+}
+
+// GraphDiff holds the nodes and edges on one side of a delta
+type GraphDiff struct {
+	Nodes []NodeV0r4 `json:"nodes"`
+	Edges []EdgeV0r4 `json:"edges"`
+}
+
+// MakeSnapshot replays the elements of a graph to find what is left at the end, a done node takes its edges with it
+func MakeSnapshot(g *GraphV0r4) Snapshot {
+	s := Snapshot{make(map[string]NodeV0r4), make(map[string]EdgeV0r4)}
+	for _, e := range g.Graph {
+		switch {
+		case e.Node != "":
+			pkg := e.Package
+			if pkg == "" {
+				pkg = e.Service // version 0.3
+			}
+			s.Nodes[e.Node] = NodeV0r4{e.Node, pkg, e.Tstamp, e.Metadata, e.Tags}
+		case e.Edge != "":
+			s.Edges[e.Source+" "+e.Target] = EdgeV0r4{e.Edge, e.Source, e.Target, e.Tstamp}
+		case e.Forget != "":
+			delete(s.Edges, e.Source+" "+e.Target)
+		case e.Done != "":
+			delete(s.Nodes, e.Done)
+			for k, edge := range s.Edges { // edges aren't always forgotten before a node is done
+				if edge.Source == e.Done || edge.Target == e.Done {
+					delete(s.Edges, k)
+				}
+			}
+		}
+	}
+	return s
+}
+
+// Delta compares the end state of two graphs, nodes are matched by name and edges by source and target
+func Delta(from, to *GraphV0r4) *DeltaV0r4 {
+	f := MakeSnapshot(from)
+	t := MakeSnapshot(to)
+	d := &DeltaV0r4{Arch: to.Arch, Version: "spigo-delta-0.1", From: from.Date, To: to.Date}
+	// empty lists rather than null, so animations can iterate without checking
+	d.Added = GraphDiff{[]NodeV0r4{}, []EdgeV0r4{}}
+	d.Removed = GraphDiff{[]NodeV0r4{}, []EdgeV0r4{}}
+	for n, node := range t.Nodes {
+		if _, ok := f.Nodes[n]; !ok {
+			d.Added.Nodes = append(d.Added.Nodes, node)
+		}
+	}
+	for n, node := range f.Nodes {
+		if _, ok := t.Nodes[n]; !ok {
+			d.Removed.Nodes = append(d.Removed.Nodes, node)
+		}
+	}
+	for k, edge := range t.Edges {
+		if _, ok := f.Edges[k]; !ok {
+			d.Added.Edges = append(d.Added.Edges, edge)
+		}
+	}
+	for k, edge := range f.Edges {
+		if _, ok := t.Edges[k]; !ok {
+			d.Removed.Edges = append(d.Removed.Edges, edge)
+		}
+	}
+	d.Added.sort()
+	d.Removed.sort()
+	return d
+}
+
+type byNode []NodeV0r4
+
+func (a byNode) Len() int           { return len(a) }
+func (a byNode) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byNode) Less(i, j int) bool { return a[i].Node < a[j].Node }
+
+type byEdge []EdgeV0r4
+
+func (a byEdge) Len() int      { return len(a) }
+func (a byEdge) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byEdge) Less(i, j int) bool {
+	if a[i].Source != a[j].Source {
+		return a[i].Source < a[j].Source
+	}
+	return a[i].Target < a[j].Target
+}
+
+// sort so that deltas are repeatable
+func (g *GraphDiff) sort() {
+	sort.Sort(byNode(g.Nodes))
+	sort.Sort(byEdge(g.Edges))
+}
@@ -2,13 +2,18 @@
 package graphjson
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/dhcp"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -16,15 +21,19 @@ import (
 var Enabled bool
 
 var file *os.File
+var zip *gzip.Writer          // wraps file when -gzip is set
+var out io.Writer             // file or zip
 var edgeid int                // unique id for each edge
 var edgemap map[string]string // remember which edge was which
+var profile Profile           // field naming for the tool that will read the file
 
 // NodeV0r4 defines a node for version 0.4, used to make json nodes for writing
 type NodeV0r4 struct {
-	Node     string `json:"node"`
-	Package  string `json:"package"`             // name changed from 0.3 to 0.4
-	Tstamp   string `json:"timestamp,omitempty"` // 0.4
-	Metadata string `json:"metadata,omitempty"`  // added to 0.4
+	Node     string            `json:"node"`
+	Package  string            `json:"package"`             // name changed from 0.3 to 0.4
+	Tstamp   string            `json:"timestamp,omitempty"` // 0.4
+	Metadata string            `json:"metadata,omitempty"`  // added to 0.4
+	Tags     map[string]string `json:"tags,omitempty"`
 }
 
 // EdgeV0r4 defines an edge for version 0.4, used to make json edges for writing
@@ -52,26 +61,29 @@ type DoneV0r4 struct {
 
 // ElementV0r4 defines a way to read either a node, edge or done in the graph for version 0.3 or 0.4
 type ElementV0r4 struct {
-	Node     string `json:"node,omitempty"`
-	Package  string `json:"package,omitempty"`
-	Service  string `json:"service,omitempty"` // name changed from service 0.3 to package 0.4
-	Edge     string `json:"edge,omitempty"`
-	Source   string `json:"source,omitempty"`
-	Target   string `json:"target,omitempty"`
-	Forget   string `json:"forget"`
-	Done     string `json:"target,omitempty"`
-	Exit     string `json:"exit,omitempty"`
-	Metadata string `json:"metadata,omitempty"` // added to 0.4
-	Tstamp   string `json:"timestamp,omitempty"`
+	Node     string            `json:"node,omitempty"`
+	Package  string            `json:"package,omitempty"`
+	Service  string            `json:"service,omitempty"` // name changed from service 0.3 to package 0.4
+	Edge     string            `json:"edge,omitempty"`
+	Source   string            `json:"source,omitempty"`
+	Target   string            `json:"target,omitempty"`
+	Forget   string            `json:"forget"`
+	Done     string            `json:"done,omitempty"`
+	Exit     string            `json:"exit,omitempty"`
+	Metadata string            `json:"metadata,omitempty"` // added to 0.4
+	Tstamp   string            `json:"timestamp,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
 }
 
 // GraphV0r4 defines version 0.4 of the graphjson file format with an array of elements
 type GraphV0r4 struct {
-	Arch    string        `json:"arch"`
-	Version string        `json:"version"`
-	Args    string        `json:"args"`
-	Date    string        `json:"date,omitempty"` // 0.4
-	Graph   []ElementV0r4 `json:"graph"`
+	Arch    string            `json:"arch"`
+	Version string            `json:"version"`
+	Args    string            `json:"args"`
+	Date    string            `json:"date,omitempty"`    // 0.4
+	Run     *archaius.RunInfo `json:"run,omitempty"`     // metadata for the run that created the graph
+	Profile string            `json:"profile,omitempty"` // field naming profile the graph elements were written with
+	Graph   []ElementV0r4     `json:"graph"`
 }
 
 // GraphVersion extracts the version so it can be checked
@@ -84,20 +96,52 @@ var comma bool
 // Setup by opening the "arch".json file and writing a header, noting the generated architecture
 // type, version and args for the run
 func Setup(arch string) {
-	Enabled = true
 	ss := ""
 	if archaius.Conf.StopStep > 0 {
 		ss = fmt.Sprintf("%v", archaius.Conf.StopStep)
 	}
-	file, _ = os.Create("json/" + arch + ss + ".json")
-	Write(fmt.Sprintf("{\n  %q:%q,\n  %q:%q,\n  %q:\"%v\",\n  %q:%q,\n  %q:[", "arch", arch, "version", "spigo-0.4", "args", os.Args, "date", time.Now().Format(time.RFC3339Nano), "graph"))
+	fn := "json/" + arch + ss + ".json"
+	if archaius.Conf.Gzip {
+		fn += ".gz"
+	}
+	SetupFile(fn, arch)
+	if archaius.Conf.SplitRegions {
+		split = &splitter{base: "json/" + arch + ss, arch: arch, gzip: archaius.Conf.Gzip, packages: make(map[string]string)}
+	}
+}
+
+// SetupFile opens the named file for the architecture, compressed if the name ends in .gz, and writes the header
+func SetupFile(fn, arch string) {
+	Enabled = true
+	file, _ = os.Create(fn)
+	out = file
+	if strings.HasSuffix(fn, ".gz") {
+		zip = gzip.NewWriter(file)
+		out = zip
+	}
+	profile = Profiles[archaius.Conf.JSONProfile]
+	Write(header(arch))
 	comma = false
 	edgemap = make(map[string]string, archaius.Conf.Population)
 }
 
+// header of a graph file for the architecture, up to the start of the graph elements
+func header(arch string) string {
+	run, _ := json.Marshal(archaius.Run())
+	pf := ""
+	if archaius.Conf.JSONProfile != "" && archaius.Conf.JSONProfile != "legacy" {
+		pf = fmt.Sprintf("\n  %q:%q,", "profile", archaius.Conf.JSONProfile)
+	}
+	date := clock.Now()
+	if archaius.Conf.Virtual { // the graph starts with the run, not when edda got to set it up
+		date = clock.Epoch
+	}
+	return fmt.Sprintf("{\n  %q:%q,\n  %q:%q,\n  %q:\"%v\",\n  %q:%q,\n  %q:%v,%v\n  %q:[", "arch", arch, "version", "spigo-0.4", "args", os.Args, "date", date.Format(time.RFC3339Nano), "run", string(run), pf, "graph")
+}
+
 // Write a string to the file
 func Write(str string) {
-	file.WriteString(str)
+	io.WriteString(out, str)
 }
 
 // decide whether to write a comma before the newline or not
@@ -109,18 +153,24 @@ func commaNewline() string {
 	return "\n"
 }
 
-// WriteNode writes the node to a file given a space separated name and service type
-func WriteNode(nameService string, t time.Time) {
+// WriteNode writes the node to a file given a space separated name and service type, and the tags of its service
+func WriteNode(nameService string, tags map[string]string, t time.Time) {
 	if Enabled == false {
 		return
 	}
 	var node NodeV0r4
 	fmt.Sscanf(nameService, "%s%s", &node.Node, &node.Package) // space delimited
 	node.Tstamp = t.Format(time.RFC3339Nano)
+	node.Tags = tags
 	// node id should be unique and service indicates service type
 	node.Metadata = fmt.Sprintf("IP/%v", dhcp.Lookup(node.Node))
 	nodeJSON, _ := json.Marshal(node)
-	Write(fmt.Sprintf("%v    %v", commaNewline(), string(nodeJSON)))
+	line := string(profile.apply(nodeJSON))
+	Write(fmt.Sprintf("%v    %v", commaNewline(), line))
+	if split != nil {
+		split.packages[node.Node] = node.Package
+		split.add(splitElement{line: line, node: node.Node})
+	}
 }
 
 // WriteDone records that a node has gone away normally
@@ -133,7 +183,9 @@ func WriteDone(name string, t time.Time) {
 	done.Exit = "normal"
 	done.Tstamp = t.Format(time.RFC3339Nano)
 	nodeJSON, _ := json.Marshal(done)
-	Write(fmt.Sprintf("%v    %v", commaNewline(), string(nodeJSON)))
+	line := string(profile.apply(nodeJSON))
+	Write(fmt.Sprintf("%v    %v", commaNewline(), line))
+	split.add(splitElement{line: line, node: name})
 }
 
 // WriteEdge writes the edge to a file given a space separated from and to node name
@@ -148,7 +200,9 @@ func WriteEdge(fromTo string, t time.Time) {
 	edgemap[fromTo] = edge.Edge // remember the named edge so it can be forgotten later
 	edge.Tstamp = t.Format(time.RFC3339Nano)
 	edgeJSON, _ := json.Marshal(edge)
-	Write(fmt.Sprintf("%v    %v", commaNewline(), string(edgeJSON)))
+	line := string(profile.apply(edgeJSON))
+	Write(fmt.Sprintf("%v    %v", commaNewline(), line))
+	split.add(splitElement{line: line, source: edge.Source, target: edge.Target})
 }
 
 // WriteForget writes the forgotten edge to a file given a space separated edge id, from and to node names
@@ -161,7 +215,9 @@ func WriteForget(fromTo string, t time.Time) {
 	forget.Forget = edgemap[fromTo]
 	forget.Tstamp = t.Format(time.RFC3339Nano)
 	forgetJSON, _ := json.Marshal(forget)
-	Write(fmt.Sprintf("%v    %v", commaNewline(), string(forgetJSON)))
+	line := string(profile.apply(forgetJSON))
+	Write(fmt.Sprintf("%v    %v", commaNewline(), line))
+	split.add(splitElement{line: line, source: forget.Source, target: forget.Target})
 }
 
 // Close completes the json file format and closes the file
@@ -170,7 +226,12 @@ func Close() {
 		return
 	}
 	Write("\n  ]\n}\n")
+	if zip != nil {
+		zip.Close()
+	}
 	file.Close()
+	split.write()
+	split = nil
 }
 
 // ReadArch parses graphjson
@@ -181,7 +242,16 @@ func ReadArch(arch string) *GraphV0r4 {
 	}
 	fn := "json/" + arch + ss + ".json"
 	log.Println("Reloading from " + fn)
-	data, err := ioutil.ReadFile(fn)
+	return ReadGraph(fn)
+}
+
+// ReadGraph parses a graphjson file, falling back to a gzipped copy if the file doesn't exist
+func ReadGraph(fn string) *GraphV0r4 {
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		fn += ".gz"
+		log.Println("Reading compressed " + fn)
+	}
+	data, err := ReadFile(fn)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -198,9 +268,51 @@ func ReadArch(arch string) *GraphV0r4 {
 		g := new(GraphV0r4)
 		json.Unmarshal(data, g)
 		log.Println("Architecture: ", g.Arch)
+		if g.Profile != "" && g.Profile != "legacy" {
+			g.Graph = unprofile(data, g.Profile)
+		}
 		return g
 	default:
 		log.Fatal("Uknown version ", v.Version)
 		return nil
 	}
 }
+
+// unprofile reads the graph elements back from a file written with a field naming profile
+func unprofile(data []byte, name string) []ElementV0r4 {
+	p, ok := Profiles[name]
+	if !ok {
+		log.Fatal("Unknown json profile ", name)
+	}
+	var raw struct {
+		Graph []json.RawMessage `json:"graph"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		log.Fatal(err)
+	}
+	graph := make([]ElementV0r4, len(raw.Graph))
+	for i, r := range raw.Graph {
+		j, err := p.undo(r)
+		if err == nil {
+			err = json.Unmarshal(j, &graph[i])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return graph
+}
+
+// ReadFile reads a whole file, decompressing it if it is gzipped
+func ReadFile(fn string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b { // gzip magic number
+		return data, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
@@ -4,6 +4,7 @@ package graphjson
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -97,3 +98,99 @@ func TestGraph(t *testing.T) {
 	try(testJSONstringV0r3)
 	try(testJSONstringV0r4)
 }
+
+// delta between two small steps, one node goes away with its edge and another arrives
+func TestDelta(t *testing.T) {
+	step1 := `{"arch":"d","version":"spigo-0.4","graph":[
+		{"node":"a","package":"pirate"},
+		{"node":"b","package":"pirate"},
+		{"edge":"e1","source":"a","target":"b"}
+		]}`
+	step2 := `{"arch":"d","version":"spigo-0.4","graph":[
+		{"node":"a","package":"pirate"},
+		{"node":"b","package":"pirate"},
+		{"edge":"e1","source":"a","target":"b"},
+		{"node":"c","package":"pirate"},
+		{"edge":"e2","source":"a","target":"c"},
+		{"forget":"e1","source":"a","target":"b"},
+		{"done":"b","exit":"normal"}
+		]}`
+	g1 := new(GraphV0r4)
+	g2 := new(GraphV0r4)
+	json.Unmarshal([]byte(step1), g1)
+	json.Unmarshal([]byte(step2), g2)
+	d := Delta(g1, g2)
+	j, _ := json.Marshal(d)
+	fmt.Println(string(j))
+	if len(d.Added.Nodes) != 1 || d.Added.Nodes[0].Node != "c" || len(d.Removed.Nodes) != 1 || d.Removed.Nodes[0].Node != "b" {
+		t.Fail()
+	}
+	if len(d.Added.Edges) != 1 || len(d.Removed.Edges) != 1 || d.Removed.Edges[0].Target != "b" {
+		t.Fail()
+	}
+	// c is done without its edge being forgotten first, the edge goes with it
+	step3 := strings.Replace(step2, `{"done":"b","exit":"normal"}`, `{"done":"b","exit":"normal"},
+		{"done":"c","exit":"normal"}`, 1)
+	g3 := new(GraphV0r4)
+	json.Unmarshal([]byte(step3), g3)
+	if s := MakeSnapshot(g3); len(s.Nodes) != 1 || len(s.Edges) != 0 {
+		t.Errorf("snapshot kept %v nodes and edges %v", len(s.Nodes), s.Edges)
+	}
+	d = Delta(g2, g3)
+	if len(d.Added.Nodes) != 0 || len(d.Added.Edges) != 0 || len(d.Removed.Nodes) != 1 || d.Removed.Nodes[0].Node != "c" ||
+		len(d.Removed.Edges) != 1 || d.Removed.Edges[0].Target != "c" {
+		t.Errorf("delta after a done node with an edge %+v", d)
+	}
+}
+
+// each profile renames the fields it is written with, and reads back the same elements
+func TestProfiles(t *testing.T) {
+	node, _ := json.Marshal(NodeV0r4{Node: "a", Package: "pirate"})
+	edge, _ := json.Marshal(EdgeV0r4{Edge: "e1", Source: "a", Target: "b"})
+	for _, n := range ProfileNames() {
+		p := Profiles[n]
+		for _, j := range [][]byte{node, edge} {
+			out := p.apply(j)
+			fmt.Println(n, string(out))
+			back, err := p.undo(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var was, is ElementV0r4
+			json.Unmarshal(j, &was)
+			json.Unmarshal(back, &is)
+			if was.Node != is.Node || was.Edge != is.Edge || was.Package != is.Package || was.Source != is.Source || was.Target != is.Target {
+				t.Fail()
+			}
+		}
+	}
+}
+
+// each region gets its own nodes and edges, with stubs for the nodes at the other end of the edges that leave it
+func TestSplitRegions(t *testing.T) {
+	s := &splitter{packages: map[string]string{"a.us-east-1.zoneA.app0": "karyon", "a.eu-west-1.zoneA.app0": "karyon", "a.*.*.www0": "denominator"}}
+	s.add(splitElement{line: "east", node: "a.us-east-1.zoneA.app0"})
+	s.add(splitElement{line: "west", node: "a.eu-west-1.zoneA.app0"})
+	s.add(splitElement{line: "www", node: "a.*.*.www0"})
+	s.add(splitElement{line: "cross", source: "a.us-east-1.zoneA.app0", target: "a.eu-west-1.zoneA.app0"})
+	s.add(splitElement{line: "entry", source: "a.*.*.www0", target: "a.us-east-1.zoneA.app0"})
+	s.add(splitElement{line: "westdone", node: "a.eu-west-1.zoneA.app0"})
+	lines := s.regions()
+	fmt.Println(lines)
+	east := lines["us-east-1"]
+	if len(lines) != 2 || len(east) != 6 || east[0] != "east" || east[2] != "cross" || east[4] != "entry" || east[5] != "westdone" {
+		t.Fail()
+	}
+	var stub NodeV0r4
+	json.Unmarshal([]byte(east[1]), &stub)
+	if stub.Node != "a.eu-west-1.zoneA.app0" || stub.Package != "karyon" || stub.Metadata != "stub/eu-west-1" {
+		t.Fail()
+	}
+	json.Unmarshal([]byte(east[3]), &stub)
+	if stub.Metadata != "stub/global" {
+		t.Fail()
+	}
+	if west := lines["eu-west-1"]; len(west) != 4 || west[0] != "west" || west[2] != "cross" || west[3] != "westdone" {
+		t.Fail()
+	}
+}
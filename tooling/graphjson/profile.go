@@ -0,0 +1,97 @@
+package graphjson
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Profile renames the fields of each node and edge so the graph can be loaded directly by a visualization tool
+type Profile struct {
+	Rename map[string]string // spigo field name to the name the tool expects
+	Wrap   string            // if set each element is nested inside an object with this field name
+}
+
+// Profiles are the built in field naming profiles selected by -jsonprofile, legacy is the spigo format
+var Profiles = map[string]Profile{
+	"legacy": {},
+	// d3 force layouts identify nodes by id, group them for color, and link source to target
+	"d3": {Rename: map[string]string{"node": "id", "edge": "id", "package": "group"}},
+	// cytoscape.js elements keep their fields in a data object, with id, source and target
+	"cytoscape": {Rename: map[string]string{"node": "id", "edge": "id", "package": "type"}, Wrap: "data"},
+	// vis.js networks use id and group for nodes, and from and to for edges
+	"vis": {Rename: map[string]string{"node": "id", "edge": "id", "package": "group", "source": "from", "target": "to"}},
+}
+
+// ProfileNames lists the built in profiles for help and error messages
+func ProfileNames() []string {
+	var pn []string
+	for n := range Profiles {
+		pn = append(pn, n)
+	}
+	sort.Strings(pn)
+	return pn
+}
+
+// apply the profile to an element that has already been marshaled in the spigo format
+func (p Profile) apply(j []byte) []byte {
+	if len(p.Rename) == 0 && p.Wrap == "" {
+		return j
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(j, &fields); err != nil {
+		return j
+	}
+	renamed := make(map[string]json.RawMessage, len(fields))
+	for k, v := range fields {
+		if r, ok := p.Rename[k]; ok {
+			k = r
+		}
+		renamed[k] = v
+	}
+	var out []byte
+	if p.Wrap != "" {
+		out, _ = json.Marshal(map[string]map[string]json.RawMessage{p.Wrap: renamed})
+	} else {
+		out, _ = json.Marshal(renamed)
+	}
+	return out
+}
+
+// undo the profile for an element read from a file, id is put back as node or edge depending on whether it has a source
+func (p Profile) undo(j []byte) ([]byte, error) {
+	if len(p.Rename) == 0 && p.Wrap == "" {
+		return j, nil
+	}
+	var fields map[string]json.RawMessage
+	if p.Wrap != "" {
+		var wrapped map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(j, &wrapped); err != nil {
+			return nil, err
+		}
+		fields = wrapped[p.Wrap]
+	} else if err := json.Unmarshal(j, &fields); err != nil {
+		return nil, err
+	}
+	reverse := make(map[string]string, len(p.Rename))
+	for k, v := range p.Rename {
+		if k != "node" && k != "edge" {
+			reverse[v] = k
+		}
+	}
+	original := make(map[string]json.RawMessage, len(fields))
+	for k, v := range fields {
+		if r, ok := reverse[k]; ok {
+			k = r
+		}
+		original[k] = v
+	}
+	if id, ok := original["id"]; ok && (p.Rename["node"] == "id" || p.Rename["edge"] == "id") {
+		delete(original, "id")
+		if _, edge := original["source"]; edge {
+			original["edge"] = id
+		} else {
+			original["node"] = id
+		}
+	}
+	return json.Marshal(original)
+}
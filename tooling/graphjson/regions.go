@@ -0,0 +1,129 @@
+package graphjson
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// splitter keeps the elements written to the graph, so the graph of each region can be written to its own file at the end
+type splitter struct {
+	base, arch string // file name without the region and .json, and the architecture for the header
+	gzip       bool
+	elements   []splitElement
+	packages   map[string]string // of each node, for its stubs
+}
+
+// splitElement is a graph element as it was written, with the node it's for or the source and target of the edge
+type splitElement struct {
+	line           string
+	node           string // node or done
+	source, target string // edge or forget
+}
+
+var split *splitter // nil unless -splitregions is set
+
+// regional is false for the nodes that aren't in a region, like a global denominator
+func regional(r string) bool {
+	return r != "" && r != "*"
+}
+
+func (s *splitter) add(e splitElement) {
+	if s == nil {
+		return
+	}
+	s.elements = append(s.elements, e)
+}
+
+// regions splits the elements by the region of their nodes. A region has its own nodes and the edges to and from them, and
+// the node at the other end of an edge to another region, or one that isn't in a region, as a stub that's written before
+// the edge and goes away with the node
+func (s *splitter) regions() map[string][]string {
+	lines := make(map[string][]string)
+	stubs := make(map[string]map[string]bool) // nodes with a stub in each region
+	for _, e := range s.elements {
+		if e.node != "" {
+			r := names.Region(e.node)
+			if regional(r) {
+				lines[r] = append(lines[r], e.line)
+			}
+			for sr, st := range stubs {
+				if st[e.node] {
+					lines[sr] = append(lines[sr], e.line) // the stub goes away with the node
+				}
+			}
+			continue
+		}
+		from, to := names.Region(e.source), names.Region(e.target)
+		if regional(from) {
+			s.edge(lines, stubs, from, e.line, e.target, to)
+		}
+		if regional(to) && to != from {
+			s.edge(lines, stubs, to, e.line, e.source, from)
+		}
+	}
+	return lines
+}
+
+// edge adds an edge or forget to a region, after a stub for the node at the other end if it's somewhere else
+func (s *splitter) edge(lines map[string][]string, stubs map[string]map[string]bool, region, line, other, otherRegion string) {
+	if otherRegion != region && !stubs[region][other] {
+		if stubs[region] == nil {
+			stubs[region] = make(map[string]bool)
+		}
+		stubs[region][other] = true
+		lines[region] = append(lines[region], s.stub(other, otherRegion))
+	}
+	lines[region] = append(lines[region], line)
+}
+
+// stub is a node in another region, marked with the region in its metadata instead of an IP address
+func (s *splitter) stub(node, region string) string {
+	if !regional(region) {
+		region = "global"
+	}
+	j, _ := json.Marshal(NodeV0r4{Node: node, Package: s.packages[node], Metadata: "stub/" + region})
+	return string(profile.apply(j))
+}
+
+// write the graph of each region to <base>_<region>.json, compressed like the combined graph
+func (s *splitter) write() {
+	if s == nil {
+		return
+	}
+	lines := s.regions()
+	var regions []string
+	for r := range lines {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+	for _, r := range regions {
+		fn := s.base + "_" + r + ".json"
+		if s.gzip {
+			fn += ".gz"
+		}
+		f, err := os.Create(fn)
+		if err != nil {
+			log.Println("graphjson: can't split region " + r + ": " + err.Error())
+			continue
+		}
+		var w io.Writer = f
+		var zw *gzip.Writer
+		if s.gzip {
+			zw = gzip.NewWriter(f)
+			w = zw
+		}
+		io.WriteString(w, header(s.arch)+"\n    "+strings.Join(lines[r], ",\n    ")+"\n  ]\n}\n")
+		if zw != nil {
+			zw.Close()
+		}
+		f.Close()
+		log.Printf("graphjson: wrote %v elements of region %v to %v\n", len(lines[r]), r, fn)
+	}
+}
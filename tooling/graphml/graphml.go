@@ -3,61 +3,113 @@
 package graphml
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"io"
 	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Enabled is set by command line flags to turn on graphml logging
 var Enabled bool
 
 var file *os.File
-var edgeid int // unique id for each edge to keep graphml happy
+var zip *gzip.Writer // wraps file when -gzip is set
+var out io.Writer    // file or zip
+var edgeid int       // unique id for each edge to keep graphml happy
 
 // Setup opens the file and and writes the header
 func Setup(filename string) {
-	Enabled = true
 	ss := ""
 	if archaius.Conf.StopStep > 0 {
 		ss = fmt.Sprintf("%v", archaius.Conf.StopStep)
 	}
-	file, _ = os.Create("gml/" + filename + ss + ".graphml")
-	file.WriteString(
-		"<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n  <graphml xmlns=\"http://graphml.graphdrawing.org/xmlns/graphml\"\n   xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\"\n   xsi:schemaLocation=\"http://graphml.graphdrawing.org/xmlns/graphml http://www.yworks.com/xml/schema/graphml/1.0/ygraphml.xsd\"\n    xmlns:y=\"http://www.yworks.com/xml/graphml\">\n    <key id=\"d0\" for=\"node\" yfiles.type=\"nodegraphics\"/>\n    <key id=\"d1\" for=\"edge\" yfiles.type=\"edgegraphics\"/>\n    <key id=\"d2\" for=\"node\" attr.name=\"Text\" attr.type=\"string\"/>\n    <graph id=\"spigo\" edgedefault=\"directed\">\n")
+	fn := "gml/" + filename + ss + ".graphml"
+	if archaius.Conf.Gzip {
+		fn += ".gz"
+	}
+	SetupFile(fn)
 }
 
-// WriteNode logs a node in the file given a space separated name and service type
-func WriteNode(nameService string) {
+// SetupFile opens the named file, compressed if the name ends in .gz, and writes the header
+func SetupFile(fn string) {
+	Enabled = true
+	file, _ = os.Create(fn)
+	out = file
+	if strings.HasSuffix(fn, ".gz") {
+		zip = gzip.NewWriter(file)
+		out = zip
+	}
+	Write(
+		"<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n  <graphml xmlns=\"http://graphml.graphdrawing.org/xmlns/graphml\"\n   xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\"\n   xsi:schemaLocation=\"http://graphml.graphdrawing.org/xmlns/graphml http://www.yworks.com/xml/schema/graphml/1.0/ygraphml.xsd\"\n    xmlns:y=\"http://www.yworks.com/xml/graphml\">\n    <key id=\"d0\" for=\"node\" yfiles.type=\"nodegraphics\"/>\n    <key id=\"d1\" for=\"edge\" yfiles.type=\"edgegraphics\"/>\n    <key id=\"d2\" for=\"node\" attr.name=\"Text\" attr.type=\"string\"/>\n    <key id=\"tags\" for=\"node\" attr.name=\"tags\" attr.type=\"string\"/>\n    <key id=\"timestamp\" for=\"all\" attr.name=\"timestamp\" attr.type=\"string\"/>\n    <key id=\"run\" for=\"graph\" attr.name=\"run\" attr.type=\"string\"/>\n    <graph id=\"spigo\" edgedefault=\"directed\">\n")
+	// record the run metadata as json in a graph level attribute
+	run, _ := json.Marshal(archaius.Run())
+	var esc bytes.Buffer
+	xml.EscapeText(&esc, run)
+	Write(fmt.Sprintf("      <data key=\"run\">%v</data>\n", esc.String()))
+}
+
+// WriteNode logs a node in the file given a space separated name and service type, tags are written as key=value,key=value,
+// and the time it was made unless it's zero
+func WriteNode(nameService string, tags map[string]string, t time.Time) {
 	if Enabled == false {
 		return
 	}
 	var name, service string
 	fmt.Sscanf(nameService, "%s%s", &name, &service) // space delimited
 	// node name should be unique and service indicates service type
-	file.WriteString(fmt.Sprintf("      <node id=\"%v\"><data key=\"service\">%v</data></node>\n", name, service))
+	data := fmt.Sprintf("<data key=\"service\">%v</data>", service)
+	if len(tags) > 0 {
+		var kv []string
+		for k, v := range tags {
+			kv = append(kv, k+"="+v)
+		}
+		sort.Strings(kv)
+		var esc bytes.Buffer
+		xml.EscapeText(&esc, []byte(strings.Join(kv, ",")))
+		data += fmt.Sprintf("<data key=\"tags\">%v</data>", esc.String())
+	}
+	Write(fmt.Sprintf("      <node id=\"%v\">%v%v</node>\n", name, data, timestamp(t)))
 }
 
-func edge(from, to string) string {
+// timestamp data for a node or edge, or nothing if the time isn't known
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("<data key=\"timestamp\">%v</data>", t.Format(time.RFC3339Nano))
+}
+
+func edge(from, to string, t time.Time) string {
 	if Enabled == false {
 		return ""
 	}
 	edgeid = edgeid + 1
-	return fmt.Sprintf("      <edge id=\"e%v\" source=\"%v\" target=\"%v\"/>\n", edgeid, from, to)
+	if t.IsZero() {
+		return fmt.Sprintf("      <edge id=\"e%v\" source=\"%v\" target=\"%v\"/>\n", edgeid, from, to)
+	}
+	return fmt.Sprintf("      <edge id=\"e%v\" source=\"%v\" target=\"%v\">%v</edge>\n", edgeid, from, to, timestamp(t))
 }
 
 // Write a string to the file
 func Write(str string) {
-	file.WriteString(str)
+	io.WriteString(out, str)
 }
 
-// WriteEdge logs and edge in the file given a space separated from and to name
-func WriteEdge(fromTo string) {
+// WriteEdge logs and edge in the file given a space separated from and to name, and the time it was made unless it's zero
+func WriteEdge(fromTo string, t time.Time) {
 	if Enabled == false {
 		return
 	}
 	var from, to string
 	fmt.Sscanf(fromTo, "%s%s", &from, &to) // two space delimited names
-	file.WriteString(edge(from, to))
+	Write(edge(from, to, t))
 }
 
 // Close finishes off the file footer and closes it
@@ -65,6 +117,9 @@ func Close() {
 	if Enabled == false {
 		return
 	}
-	file.WriteString("    </graph>\n  </graphml>\n")
+	Write("    </graph>\n  </graphml>\n")
+	if zip != nil {
+		zip.Close()
+	}
 	file.Close()
 }
@@ -5,12 +5,15 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/dhcp"
 	"github.com/adrianco/spigo/tooling/gotocol"
 	"github.com/adrianco/spigo/tooling/names"
 	_ "gopkg.in/cq.v1" // cq used without package prefix
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -19,6 +22,9 @@ var Enabled bool
 var db *sql.DB
 var ss string
 var epoch int64
+var instances = make(map[string]int)   // nodes written for each service label
+var services = make(map[string]string) // service name for each label, which can't have a -
+var conns = make(map[string]bool)      // CONN relationships written, by space separated from and to node name
 
 // Setup by opening a connection to neo4j then removing stuff that's going to be updated
 func Setup(neo4jurl string) {
@@ -53,20 +59,52 @@ func Write(str string) {
 	stmt.Close()
 }
 
-// WriteNode writes the node to a file given a space separated name and service type
-func WriteNode(nameService string, t time.Time) {
+// property makes a tag key usable as a property name in Cypher without quoting
+func property(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// tagProperties are the tags of a node as properties to add to the ones it's created with, in key order
+func tagProperties(tags map[string]string) string {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := ""
+	for _, k := range keys {
+		s += fmt.Sprintf(", %v:%q", property(k), tags[k])
+	}
+	return s
+}
+
+// label replaces the - in a name, which Cypher labels and the names used to match nodes can't have
+func label(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// WriteNode writes the node to a file given a space separated name and service type, and the service tags as properties
+func WriteNode(nameService string, tags map[string]string, t time.Time) {
 	if Enabled == false {
 		return
 	}
 	var node, pack string
 	fmt.Sscanf(nameService, "%s%s", &node, &pack) // space delimited
 	tstamp := t.Format(time.RFC3339Nano)
+	services[label(names.Service(node))] = names.Service(node)
+	node = label(node)
+	instances[names.Service(node)]++
 	// node id should be unique and package indicates service type
-	nodestmt, err := db.Prepare(fmt.Sprintf("CREATE (:%v:%v:%v {name:{0}, node:{1}, timestamp:{2}, ip:{3}, region:{4}, zone:{5}})", ss, pack, names.Service(node)))
+	nodestmt, err := db.Prepare(fmt.Sprintf("CREATE (:%v:%v:%v {name:{0}, node:{1}, timestamp:{2}, ip:{3}, region:{4}, zone:{5}, package:{6}, service:{7}%v})", ss, pack, names.Service(node), tagProperties(tags)))
 	if err != nil {
 		log.Fatal(err)
 	}
-	_, err = nodestmt.Exec(names.Instance(node), node, tstamp, dhcp.Lookup(node), names.Region(node), names.Zone(node))
+	_, err = nodestmt.Exec(names.Instance(node), node, tstamp, dhcp.Lookup(node), names.Region(node), names.Zone(node), pack, names.Service(node))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -88,6 +126,7 @@ func WriteEdge(fromTo string, t time.Time) {
 	var source, target string
 	fmt.Sscanf(fromTo, "%s%s", &source, &target) // two space delimited names
 	tstamp := t.Format(time.RFC3339Nano)
+	conns[source+" "+target] = true
 	Write(fmt.Sprintf("MATCH (from:%v:%v {name: %q}), (to:%v:%v {name: %q}) CREATE (from)-[:CONN {arch:%q, timestamp:%q}]->(to)", ss, names.Service(source), names.Instance(source), ss, names.Service(target), names.Instance(target), ss, tstamp))
 }
 
@@ -109,10 +148,60 @@ func WriteFlow(source, target, call string, tnano int64, trace gotocol.TraceCont
 //	}
 //}
 
-// Close the database session
-func Close() {
+// percentiles of a set of response times in milliseconds, sorting them in place
+func percentiles(d []time.Duration) (p50, p99 float64) {
+	if len(d) == 0 {
+		return 0, 0
+	}
+	sort.Sort(byDuration(d))
+	ms := func(q float64) float64 { return float64(d[int(q*float64(len(d)-1))]) / float64(time.Millisecond) }
+	return ms(0.5), ms(0.99)
+}
+
+type byDuration []time.Duration
+
+func (b byDuration) Len() int           { return len(b) }
+func (b byDuration) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDuration) Less(i, j int) bool { return b[i] < b[j] }
+
+// Close sets the properties that are only known at the end of the run and closes the database session. Every node gets the
+// instance count of its service and, if Collect is enabled, the requests and response time percentiles of the service, and
+// calls has the round trip times of the calls between instances by full name, for the call count and percentiles of each CONN
+// relationship, nil if they weren't collected
+func Close(calls map[string]map[string][]time.Duration) {
 	if Enabled == false {
 		return
 	}
+	var labels []string
+	for s := range instances {
+		labels = append(labels, s)
+	}
+	sort.Strings(labels)
+	for _, s := range labels {
+		set := fmt.Sprintf("n.instances = %v", instances[s])
+		if p50, n := collect.ServiceQuantile(services[s], 0.5); n > 0 {
+			p99, _ := collect.ServiceQuantile(services[s], 0.99)
+			set += fmt.Sprintf(", n.requests = %v, n.p50ms = %v, n.p99ms = %v", n, float64(p50)/float64(time.Millisecond), float64(p99)/float64(time.Millisecond))
+		}
+		Write(fmt.Sprintf("MATCH (n:%v:%v) SET %v", ss, s, set))
+	}
+	latencies := make(map[string][]time.Duration, len(conns))
+	for from, row := range calls {
+		for to, d := range row {
+			latencies[label(from)+" "+label(to)] = d
+		}
+	}
+	var edges []string
+	for e := range conns {
+		edges = append(edges, e)
+	}
+	sort.Strings(edges)
+	for _, e := range edges {
+		var source, target string
+		fmt.Sscanf(e, "%s%s", &source, &target)
+		d := latencies[e]
+		p50, p99 := percentiles(d)
+		Write(fmt.Sprintf("MATCH (from:%v:%v {name: %q})-[r:CONN]->(to:%v:%v {name: %q}) SET r.calls = %v, r.p50ms = %v, r.p99ms = %v", ss, names.Service(source), names.Instance(source), ss, names.Service(target), names.Instance(target), len(d), p50, p99))
+	}
 	db.Close()
 }
@@ -19,9 +19,26 @@ func TestGraph(t *testing.T) {
 	Setup("localhost:7474")
 	Write(testNeo)
 	dal0 := names.Make("test", "us-east-1", "ZoneA", "dal", "staash", 0)
-	WriteNode(dal0+" staash", time.Now())
+	WriteNode(dal0+" staash", map[string]string{"tier": "data"}, time.Now())
 	WriteEdge(dal0+" test.us-east-1.zoneA..mysql00...mysql.store", time.Now())
 	WriteEdge(dal0+" test.us-east-1.zoneA..mysql01...mysql.store", time.Now())
 	WriteFlow(dal0, "test.us-east-1.zoneA..mysql00...mysql.store", "Put", 100, 1)
-	Close()
+	Close(map[string]map[string][]time.Duration{dal0: {"test.us-east-1.zoneA..mysql00...mysql.store": {time.Millisecond}}})
+}
+
+// properties don't need a database
+func TestProperties(t *testing.T) {
+	if p := tagProperties(map[string]string{"tier": "data", "team-owner": "dba"}); p != `, team_owner:"dba", tier:"data"` {
+		t.Error("tag properties: " + p)
+	}
+	var d []time.Duration
+	for i := 100; i > 0; i-- {
+		d = append(d, time.Duration(i)*time.Millisecond)
+	}
+	if p50, p99 := percentiles(d); p50 != 50 || p99 != 99 {
+		t.Error("percentiles:", p50, p99)
+	}
+	if p50, p99 := percentiles(nil); p50 != 0 || p99 != 0 {
+		t.Error("percentiles of nothing:", p50, p99)
+	}
 }
@@ -0,0 +1,101 @@
+// Package graphviz writes nodes and edges to dot/<arch>.dot as a GraphViz digraph, for a quick picture with dot -Tpng
+package graphviz
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// Enabled is set by command line flags to turn on dot logging
+var Enabled bool
+
+var filename string
+
+var nodes = make(map[string]string) // service by node name, kept until Close so the nodes are written before the edges
+var edges = make(map[string]bool)   // space separated from and to names
+
+// Setup remembers the file name, nothing is written until Close
+func Setup(name string) {
+	ss := ""
+	if archaius.Conf.StopStep > 0 {
+		ss = fmt.Sprintf("%v", archaius.Conf.StopStep)
+	}
+	SetupFile("dot/" + name + ss + ".dot")
+}
+
+// SetupFile remembers the full file name, and its directory is made at Close
+func SetupFile(fn string) {
+	Enabled = true
+	filename = fn
+}
+
+// WriteNode records a node given its name and the service it runs, which it's labelled with
+func WriteNode(name, service string) {
+	if Enabled == false {
+		return
+	}
+	nodes[name] = service
+}
+
+// WriteEdge records an edge given a space separated from and to name
+func WriteEdge(fromTo string) {
+	if Enabled == false {
+		return
+	}
+	var from, to string
+	fmt.Sscanf(fromTo, "%s%s", &from, &to) // two space delimited names
+	edges[from+" "+to] = true
+}
+
+// quote makes a string a DOT id
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Close writes the file, with the nodes and edges in name order
+func Close() {
+	if Enabled == false {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Fatal(err)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	var ns []string
+	for n := range nodes {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	var es []string
+	for e := range edges {
+		var from, to string
+		fmt.Sscanf(e, "%s%s", &from, &to)
+		if _, ok := nodes[from]; ok { // dot would draw nodes that were filtered out back in, unlabelled
+			if _, ok := nodes[to]; ok {
+				es = append(es, e)
+			}
+		}
+	}
+	sort.Strings(es)
+	log.Printf("Writing %v nodes and %v edges to %v\n", len(ns), len(es), filename)
+	file.WriteString(fmt.Sprintf("digraph %v {\n", quote(archaius.Conf.Arch)))
+	for _, n := range ns {
+		file.WriteString(fmt.Sprintf("  %v [label=%v];\n", quote(n), quote(nodes[n])))
+	}
+	for _, e := range es {
+		var from, to string
+		fmt.Sscanf(e, "%s%s", &from, &to)
+		file.WriteString(fmt.Sprintf("  %v -> %v;\n", quote(from), quote(to)))
+	}
+	file.WriteString("}\n")
+}
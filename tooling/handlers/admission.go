@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"math"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// AdmissionStats is what the admission controller of a service decided, and the queue sojourn of the calls it admitted
+type AdmissionStats struct {
+	Controller  string  `json:"controller"`
+	Arrivals    int     `json:"arrivals"`
+	Admitted    int     `json:"admitted"`
+	Rejected    int     `json:"rejected"`
+	MeanSojourn float64 `json:"meansojournms"` // time the admitted calls queued for an instance
+	MaxSojourn  float64 `json:"maxsojournms"`
+	Overloaded  float64 `json:"overloadedms"` // time codel spent rejecting
+	Reject      float64 `json:"reject"`       // fraction of the calls pid was rejecting at the end
+	total       time.Duration
+	samples     int
+}
+
+// admission is the state of the controller of a service, the caller holds sizeLock
+type admission struct {
+	stats          *AdmissionStats
+	target         time.Duration
+	interval       time.Duration
+	above          bool      // codel, the sojourn is over the target
+	since          time.Time // codel, when the sojourn last crossed the target
+	overloaded     bool      // codel, once the sojourn has stayed over the target for an interval, until it stays under for one
+	integral, last float64   // pid, of the sojourn over the target as a fraction of the target
+	at             time.Time // of the last arrival
+}
+
+var admissions = make(map[string]*admission) // by service, guarded by sizeLock with the slots
+
+func summarizeAdmission() {
+	summary := make(map[string]AdmissionStats, len(admissions))
+	for k, v := range admissions {
+		summary[k] = *v.stats
+	}
+	collect.Summarize("admission", summary)
+}
+
+// admissionOf finds the controller of the service of an instance, nil if it doesn't have one, the caller holds sizeLock
+func admissionOf(callee string) (*admission, *archaius.AdmissionConfig) {
+	ac := archaius.Service(names.Service(callee)).Admission
+	if ac == nil {
+		return nil, nil
+	}
+	ad := admissions[names.Service(callee)]
+	if ad == nil {
+		ad = &admission{stats: &AdmissionStats{Controller: ac.Controller}, target: 5 * time.Millisecond, interval: 100 * time.Millisecond}
+		if t, err := time.ParseDuration(ac.Target); err == nil {
+			ad.target = t
+		}
+		if i, err := time.ParseDuration(ac.Interval); err == nil {
+			ad.interval = i
+		}
+		admissions[names.Service(callee)] = ad
+	}
+	return ad, ac
+}
+
+// admit decides if a call that has arrived at an instance is let in to be served or queue. The controller measures the
+// sojourn as how long the call at the head of the queue of the instance has waited, zero if nothing is waiting, so it sees
+// the queue drain while it's rejecting. The caller holds sizeLock
+func admit(callee string, sl *slots) bool {
+	ad, ac := admissionOf(callee)
+	if ad == nil {
+		return true
+	}
+	now := clock.Now()
+	var wait time.Duration
+	if len(sl.waiting) > 0 {
+		wait = now.Sub(sl.waiting[0].msg.Sent)
+	}
+	dt := 0.0
+	if !ad.at.IsZero() {
+		dt = now.Sub(ad.at).Seconds()
+	}
+	ad.at = now
+	ad.stats.Arrivals++
+	ok := true
+	switch ac.Controller {
+	case "codel":
+		if ad.overloaded {
+			ad.stats.Overloaded += dt * 1000
+		}
+		if above := wait >= ad.target; above != ad.above || ad.since.IsZero() {
+			ad.above, ad.since = above, now
+		}
+		if now.Sub(ad.since) >= ad.interval {
+			ad.overloaded = ad.above
+		}
+		ok = !ad.overloaded || !ad.above // an overloaded instance only admits calls while its queue is under the target
+	case "pid":
+		kp, ki, kd := ac.Kp, ac.Ki, ac.Kd
+		if kp == 0 && ki == 0 && kd == 0 {
+			kp, ki = 0.1, 1
+		}
+		e := float64(wait-ad.target) / float64(ad.target)
+		ad.integral += e * dt
+		if ki > 0 { // the integral alone can't push the fraction outside 0 to 1, so it doesn't wind up
+			ad.integral = math.Max(0, math.Min(1/ki, ad.integral))
+		}
+		d := 0.0
+		if dt > 0 {
+			d = (e - ad.last) / dt
+		}
+		ad.last = e
+		ad.stats.Reject = math.Max(0, math.Min(1, kp*e+ki*ad.integral+kd*d))
+		ok = archaius.Rand(callee).Float64() >= ad.stats.Reject
+	}
+	if ok {
+		ad.stats.Admitted++
+	} else {
+		ad.stats.Rejected++
+	}
+	summarizeAdmission()
+	return ok
+}
+
+// sojourn records how long an admitted call queued for an instance before it was served, zero for a call that found a free
+// slot, the caller holds sizeLock
+func sojourn(callee string, wait time.Duration) {
+	ad, _ := admissionOf(callee)
+	if ad == nil {
+		return
+	}
+	st := ad.stats
+	st.samples++
+	st.total += wait
+	st.MeanSojourn = float64(st.total) / float64(st.samples) / float64(time.Millisecond)
+	if ms := float64(wait) / float64(time.Millisecond); ms > st.MaxSojourn {
+		st.MaxSojourn = ms
+	}
+	summarizeAdmission()
+}
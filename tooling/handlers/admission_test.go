@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+)
+
+func admissionStatOf(service string) AdmissionStats {
+	sizeLock.Lock()
+	defer sizeLock.Unlock()
+	return *admissions[service].stats
+}
+
+// TestAdmissionCodel checks codel admits calls while the sojourn first goes over the target, rejects them once it has
+// stayed over it for an interval, and admits them again once the queue has drained
+func TestAdmissionCodel(t *testing.T) {
+	st := newShedTest("admitcodel", archaius.ServiceConfig{Shed: &archaius.ShedConfig{Concurrency: 1},
+		Admission: &archaius.AdmissionConfig{Controller: "codel", Target: "1ms", Interval: "10ms"}})
+	first := st.send("1", gotocol.NewTrace())
+	st.arrived(t)
+	st.send("2", gotocol.NewTrace())
+	time.Sleep(15 * time.Millisecond)
+	st.send("3", gotocol.NewTrace()) // over the target, but not for an interval yet
+	time.Sleep(15 * time.Millisecond)
+	st.send("4", gotocol.NewTrace())
+	if m := st.shed(t); m.Intention != gotocol.Failure("admission") {
+		t.Errorf("rejected with %v", m.Intention)
+	}
+	st.finish(first)
+	st.finish(st.arrived(t))
+	st.finish(st.arrived(t))
+	st.send("5", gotocol.NewTrace())
+	if m := st.arrived(t); m.Intention != "5" {
+		t.Errorf("call %v arrived after the queue drained", m.Intention)
+	}
+	if s := admissionStatOf("admitcodel"); s.Arrivals != 5 || s.Admitted != 4 || s.Rejected != 1 || s.MaxSojourn < 15 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// TestAdmissionPID checks pid rejects every call while the sojourn is far over the target, and none once it's under it
+func TestAdmissionPID(t *testing.T) {
+	st := newShedTest("admitpid", archaius.ServiceConfig{Shed: &archaius.ShedConfig{Concurrency: 1},
+		Admission: &archaius.AdmissionConfig{Controller: "pid", Target: "1ms", Kp: 1}})
+	first := st.send("1", gotocol.NewTrace())
+	st.arrived(t)
+	st.send("2", gotocol.NewTrace())
+	time.Sleep(20 * time.Millisecond)
+	st.send("3", gotocol.NewTrace())
+	if m := st.shed(t); m.Intention != gotocol.Failure("admission") {
+		t.Errorf("rejected with %v", m.Intention)
+	}
+	if s := admissionStatOf("admitpid"); s.Reject != 1 {
+		t.Errorf("rejecting %v of the calls far over the target", s.Reject)
+	}
+	st.finish(first)
+	st.finish(st.arrived(t))
+	st.send("4", gotocol.NewTrace())
+	if m := st.arrived(t); m.Intention != "4" {
+		t.Errorf("call %v arrived after the queue drained", m.Intention)
+	}
+	if s := admissionStatOf("admitpid"); s.Arrivals != 4 || s.Rejected != 1 || s.Reject != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// BackoffStats is the retries a sidecar made over an edge with a backoff, and how they were spread out over the run
+type BackoffStats struct {
+	Backoff string  `json:"backoff"`
+	Retries int     `json:"retries"`
+	Mean    float64 `json:"meanms"` // wait before each retry
+	Peak    int     `json:"peak"`   // most retries sent in one step of the series
+	Series  []int   `json:"series"` // retries sent in each step from the start of the run
+	total   time.Duration
+}
+
+const backoffStep = 100 * time.Millisecond // of the series, short enough to see a synchronized burst of retries
+
+var backoffStats = make(map[string]*BackoffStats) // by caller->callee service names
+var backoffLock sync.Mutex
+
+func summarizeBackoff() {
+	summary := make(map[string]BackoffStats, len(backoffStats))
+	for k, v := range backoffStats {
+		s := *v
+		s.Series = append([]int(nil), v.Series...)
+		summary[k] = s
+	}
+	collect.Summarize("backoff", summary)
+}
+
+// backoffLimits are the shortest and longest backoff of an edge
+func backoffLimits(e archaius.EdgeConfig) (base, limit time.Duration) {
+	base, limit = 10*time.Millisecond, time.Second
+	if b, err := time.ParseDuration(e.BackoffBase); err == nil && b > 0 {
+		base = b
+	}
+	if c, err := time.ParseDuration(e.BackoffCap); err == nil && c > 0 {
+		limit = c
+	}
+	if limit < base {
+		limit = base
+	}
+	return base, limit
+}
+
+// backoff is how long a sidecar waits before retrying a failed call over the edge to the callee, zero if the edge doesn't have a
+// backoff. Attempt counts the retries from 1, and last is the wait before the retry before, which decorrelated jitter grows from
+func backoff(name, callee string, attempt int, last time.Duration) time.Duration {
+	e := archaius.Edge(names.Service(name), names.Service(callee))
+	if e.Backoff == "" {
+		return 0
+	}
+	base, limit := backoffLimits(e)
+	exp := base
+	for i := 1; i < attempt && exp < limit; i++ {
+		exp *= 2
+	}
+	if exp > limit {
+		exp = limit
+	}
+	var wait time.Duration
+	switch e.Backoff {
+	case "fixed":
+		wait = base
+	case "exponential":
+		wait = exp
+	case "jitter":
+		wait = time.Duration(archaius.Rand(name).Int63n(int64(exp) + 1))
+	case "decorrelated":
+		if last < base {
+			last = base
+		}
+		wait = base + time.Duration(archaius.Rand(name).Int63n(int64(3*last-base)+1))
+		if wait > limit {
+			wait = limit
+		}
+	}
+	backoffLock.Lock()
+	defer backoffLock.Unlock()
+	k := names.Service(name) + "->" + names.Service(callee)
+	s := backoffStats[k]
+	if s == nil {
+		s = &BackoffStats{Backoff: e.Backoff}
+		backoffStats[k] = s
+	}
+	s.Retries++
+	s.total += wait
+	s.Mean = float64(s.total) / float64(s.Retries) / float64(time.Millisecond)
+	step := int((collect.Elapsed() + wait) / backoffStep) // when the retry goes out
+	for len(s.Series) <= step {
+		s.Series = append(s.Series, 0)
+	}
+	if s.Series[step]++; s.Series[step] > s.Peak {
+		s.Peak = s.Series[step]
+	}
+	summarizeBackoff()
+	return wait
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// TestBackoff checks the wait before each retry of the strategies stays between the base and the cap of the edge, fixed
+// waits the base, exponential doubles up to the cap, and the jittered ones stay under what they grow from
+func TestBackoff(t *testing.T) {
+	callee := names.Make("test", "us-east-1", "zoneA", "db", "store", 0)
+	ms := time.Millisecond
+	for _, c := range []struct {
+		backoff string
+		check   func(wait, last, exp time.Duration) bool
+	}{
+		{"", func(wait, last, exp time.Duration) bool { return wait == 0 }},
+		{"fixed", func(wait, last, exp time.Duration) bool { return wait == 10*ms }},
+		{"exponential", func(wait, last, exp time.Duration) bool { return wait == exp }},
+		{"jitter", func(wait, last, exp time.Duration) bool { return wait >= 0 && wait <= exp }},
+		{"decorrelated", func(wait, last, exp time.Duration) bool {
+			if last < 10*ms {
+				last = 10 * ms
+			}
+			return wait >= 10*ms && wait <= 3*last && wait <= 50*ms
+		}},
+	} {
+		service := "backoff" + c.backoff
+		archaius.SetService(service, archaius.ServiceConfig{Edges: map[string]archaius.EdgeConfig{"db": {Backoff: c.backoff, BackoffBase: "10ms", BackoffCap: "50ms"}}})
+		name := names.Make("test", "us-east-1", "zoneA", service, "karyon", 0)
+		var last time.Duration
+		exp := 10 * ms
+		for attempt := 1; attempt <= 6; attempt++ {
+			wait := backoff(name, callee, attempt, last)
+			if !c.check(wait, last, exp) {
+				t.Errorf("%v: retry %v waited %v after %v", c.backoff, attempt, wait, last)
+			}
+			last = wait
+			if exp *= 2; exp > 50*ms {
+				exp = 50 * ms
+			}
+		}
+		if c.backoff == "" {
+			continue
+		}
+		backoffLock.Lock()
+		s := *backoffStats[service+"->db"]
+		backoffLock.Unlock()
+		if s.Retries != 6 || s.Backoff != c.backoff {
+			t.Errorf("%v: stats %+v", c.backoff, s)
+		}
+	}
+}
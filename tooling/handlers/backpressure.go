@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// BackpressureStats is how often the instances of a service were held up by a full queue of a dependency with backpressure
+type BackpressureStats struct {
+	Blocked int     `json:"blocked"` // calls that waited for room in the queue of the dependency
+	Stalls  int     `json:"stalls"`  // times an instance stopped starting the calls sent to it
+	Stalled float64 `json:"stalledms"`
+}
+
+var fromInstance = make(map[string]string)                  // caller instance by span route, for calls to a service with backpressure
+var blockedBy = make(map[string]int)                        // calls each instance has waiting for room in a full queue
+var blockedSince = make(map[string]time.Time)               // when each instance was last held up
+var backpressureStats = make(map[string]*BackpressureStats) // by caller service, all guarded by sizeLock with the slots
+
+func summarizeBackpressure() {
+	summary := make(map[string]BackpressureStats, len(backpressureStats))
+	for k, v := range backpressureStats {
+		summary[k] = *v
+	}
+	collect.Summarize("backpressure", summary)
+}
+
+func backpressureStat(caller string) *BackpressureStats {
+	st := backpressureStats[names.Service(caller)]
+	if st == nil {
+		st = &BackpressureStats{}
+		backpressureStats[names.Service(caller)] = st
+	}
+	return st
+}
+
+// block holds a call that found the queue of its instance full at its caller, which stops starting the calls sent to it
+// until there's room, so they queue for it and its own queue fills in turn. The caller holds sizeLock
+func block(sl *slots, p pending, callee, caller string, sh *archaius.ShedConfig) {
+	sl.blocked = append(sl.blocked, p)
+	shedStat(callee, sh).Blocked++
+	summarizeShed()
+	st := backpressureStat(caller)
+	st.Blocked++
+	if blockedBy[caller] == 0 {
+		blockedSince[caller] = clock.Now()
+		st.Stalls++
+	}
+	blockedBy[caller]++
+	summarizeBackpressure()
+}
+
+// unqueue moves the calls held at their callers into the queue of an instance while there's room, the caller holds sizeLock
+func unqueue(sl *slots, callee string, sh *archaius.ShedConfig) {
+	for sh != nil && len(sl.blocked) > 0 && len(sl.waiting) < sh.Queue {
+		w := sl.blocked[0]
+		sl.blocked = sl.blocked[1:]
+		push(sl, w, callee, sh)
+		shedStat(callee, sh).Queued++
+		summarizeShed()
+		unblock(fromInstance[w.msg.Ctx.Route()])
+	}
+}
+
+// unblock counts off a call an instance had held up, or given up on, and once it has none left it starts the calls that
+// queued for it meanwhile, which can make room for the ones held up further back. The caller holds sizeLock
+func unblock(caller string) {
+	if blockedBy[caller] == 0 {
+		return
+	}
+	if blockedBy[caller]--; blockedBy[caller] > 0 {
+		return
+	}
+	delete(blockedBy, caller)
+	backpressureStat(caller).Stalled += float64(clock.Since(blockedSince[caller])) / float64(time.Millisecond)
+	delete(blockedSince, caller)
+	summarizeBackpressure()
+	sl := instanceSlots[caller]
+	if sl == nil {
+		return
+	}
+	limit, sh := limits(caller)
+	for sl.inuse < limit && blockedBy[caller] == 0 {
+		w, ok := dequeue(sl, caller, sh)
+		if !ok {
+			break
+		}
+		sl.inuse++
+		start(w, caller, sh)
+	}
+	unqueue(sl, caller, sh)
+}
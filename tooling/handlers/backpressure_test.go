@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+)
+
+// TestBackpressure checks a call that finds a full queue is held at its caller rather than shed, the caller stops starting
+// the calls sent to it meanwhile, and both carry on once there's room in the queue
+func TestBackpressure(t *testing.T) {
+	up := newShedTest("backweb", archaius.ServiceConfig{Shed: &archaius.ShedConfig{Concurrency: 1, Queue: 5}})
+	down := newShedTest("backdb", archaius.ServiceConfig{Shed: &archaius.ShedConfig{Concurrency: 1, Queue: 1, Backpressure: true}})
+	down.name = up.callee // the calls to the db come from the web instance
+	first := down.send("1", gotocol.NewTrace())
+	down.arrived(t)
+	down.send("2", gotocol.NewTrace())
+	down.send("3", gotocol.NewTrace()) // the queue is full
+	select {
+	case m := <-down.caller:
+		t.Fatalf("caller got %v instead of being held up", m.Intention)
+	default:
+	}
+	up.send("a", gotocol.NewTrace())
+	up.idle(t) // queued while the web instance is held up
+	sizeLock.Lock()
+	held := blockedBy[up.callee]
+	sizeLock.Unlock()
+	if held != 1 {
+		t.Errorf("web instance has %v calls held up", held)
+	}
+	down.finish(first)
+	if m := down.arrived(t); m.Intention != "2" {
+		t.Errorf("call %v arrived", m.Intention)
+	}
+	if m := up.arrived(t); m.Intention != "a" {
+		t.Errorf("call %v arrived at the web instance once it wasn't held up", m.Intention)
+	}
+	if s := shedStatOf("backdb"); s.Blocked != 1 || s.Shed != 0 || s.Queued != 2 {
+		t.Errorf("db stats %+v", s)
+	}
+	sizeLock.Lock()
+	bp := *backpressureStats["backweb"]
+	sizeLock.Unlock()
+	if bp.Blocked != 1 || bp.Stalls != 1 {
+		t.Errorf("web stats %+v", bp)
+	}
+}
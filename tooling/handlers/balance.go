@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// recent latency seen by one instance calling another
+type ewma struct {
+	latency  float64 // nanoseconds, decayed over the window
+	last     time.Time
+	inflight int
+}
+
+// call sent to an adaptively balanced dependency, waiting for its response
+type started struct {
+	key    string        // caller and callee instance names
+	window time.Duration // of the edge between their services
+	sent   time.Time
+}
+
+var ewmas = make(map[string]*ewma)          // by caller and callee instance names
+var outstanding = make(map[string]started)  // by span context
+var picks = make(map[string]map[string]int) // calls to each callee instance by caller->callee service names
+var balanceLock sync.Mutex
+
+// score is lower for instances that are faster and less busy, instances that haven't responded yet score 0 so they get tried
+func (e *ewma) score() float64 {
+	if e == nil {
+		return 0
+	}
+	return e.latency * float64(e.inflight+1)
+}
+
+// balance replaces the instance picked by route with the faster of two random instances of the same service for adaptive edges,
+// the healthier of the two for health edges, or the home of the session of the request for sticky edges
+func balance(msg gotocol.Message, name string, router *ribbon.Router, c chan gotocol.Message) chan gotocol.Message {
+	callee := router.NameChan(c)
+	dep := names.Service(callee)
+	mode := archaius.Service(names.Service(name)).Edges[dep].Balance
+	if mode == "sticky" {
+		return sticky(msg, router, c)
+	}
+	if mode != "adaptive" && mode != "health" {
+		return c
+	}
+	peers := router.Select(func(n string) bool { return names.Service(n) == dep }).Names()
+	if len(peers) > 1 {
+		balanceLock.Lock()
+		defer balanceLock.Unlock()
+		i := archaius.Rand(name).Intn(len(peers))
+		j := archaius.Rand(name).Intn(len(peers) - 1)
+		if j >= i {
+			j++ // two different instances
+		}
+		callee = peers[i]
+		if mode == "health" {
+			if hc := archaius.Service(dep).Health; hc != nil {
+				healthLock.Lock()
+				if health[peers[j]].score(hc) < health[callee].score(hc) {
+					callee = peers[j]
+				}
+				healthLock.Unlock()
+			}
+		} else if ewmas[name+" "+peers[j]].score() < ewmas[name+" "+callee].score() {
+			callee = peers[j]
+		}
+	}
+	return router.Named(callee)
+}
+
+// sending records the start of an adaptively balanced call, and counts the picks of health balanced ones
+func sending(msg gotocol.Message, name, callee string) {
+	e := archaius.Service(names.Service(name)).Edges[names.Service(callee)]
+	if e.Balance != "adaptive" && e.Balance != "health" {
+		return
+	}
+	balanceLock.Lock()
+	defer balanceLock.Unlock()
+	edge := names.Service(name) + "->" + names.Service(callee)
+	if picks[edge] == nil {
+		picks[edge] = make(map[string]int)
+	}
+	picks[edge][names.Instance(callee)]++
+	if e.Balance == "health" {
+		return // the health of the callee is tracked for every caller by healthSent
+	}
+	window, err := time.ParseDuration(e.Window)
+	if err != nil || window <= 0 {
+		window = time.Second
+	}
+	key := name + " " + callee
+	a := ewmas[key]
+	if a == nil {
+		a = &ewma{}
+		ewmas[key] = a
+	}
+	a.inflight++
+	outstanding[msg.Ctx.String()] = started{key, window, msg.Sent}
+	// a call that gets no response, a request dropped along the way, counts as taking the window and is no longer in flight
+	ctx := msg.Ctx
+	clock.AfterFunc(msg.Sent.Sub(clock.Now())+window, func() { observe(ctx) })
+}
+
+// responded updates the latency average with the first response or timeout for a call
+func responded(msg gotocol.Message) {
+	observe(msg.Ctx)
+	healthObserve(msg.Ctx, gotocol.Failed(msg.Intention))
+}
+
+// observe the latency of a call the first time it completes, later responses are ignored
+func observe(ctx gotocol.Context) {
+	balanceLock.Lock()
+	defer balanceLock.Unlock()
+	s, ok := outstanding[ctx.String()]
+	if !ok {
+		return
+	}
+	delete(outstanding, ctx.String())
+	e := ewmas[s.key]
+	e.inflight--
+	now := clock.Now()
+	latency := float64(now.Sub(s.sent))
+	if e.last.IsZero() {
+		e.latency = latency
+	} else {
+		alpha := 1 - math.Exp(-float64(now.Sub(e.last))/float64(s.window))
+		e.latency += alpha * (latency - e.latency)
+	}
+	e.last = now
+}
+
+// SummarizeBalance records how many calls went to each instance of adaptively balanced dependencies
+func SummarizeBalance() {
+	balanceLock.Lock()
+	defer balanceLock.Unlock()
+	if len(picks) == 0 {
+		return
+	}
+	summary := make(map[string]map[string]int, len(picks))
+	for edge, p := range picks {
+		summary[edge] = make(map[string]int, len(p))
+		for i, n := range p {
+			summary[edge][i] = n
+		}
+	}
+	collect.Summarize("balance", summary)
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// BatchStats is the calls made over an edge with batching, and the batch calls they were sent as
+type BatchStats struct {
+	Batch    int     `json:"batch"`
+	Calls    int     `json:"calls"`   // that would have been sent one at a time
+	Batches  int     `json:"batches"` // batch calls sent
+	Saved    int     `json:"saved"`   // calls that weren't made
+	MeanSize float64 `json:"meansize"`
+	Filled   int     `json:"filled"`     // batches sent because they were full rather than when the window closed
+	MeanWait float64 `json:"meanwaitms"` // of the calls for the window to close or the batch to fill
+	MaxWait  float64 `json:"maxwaitms"`
+	MeanCost float64 `json:"meancostms"` // batch latency over the edge latency of a single call
+	waits    time.Duration
+	cost     time.Duration
+}
+
+// batch of calls from one instance to a dependency, collecting calls until the window closes or it's full, then sent as the
+// first call, which carries the rest
+type batch struct {
+	edge     string
+	first    gotocol.Message
+	name     string
+	dep      string
+	to       chan gotocol.Message
+	latency  time.Duration // of the first call on its own
+	listener chan gotocol.Message
+	calls    []gotocol.Message // the rest
+	timer    *clock.Timer
+	due      time.Time // when the window closes, moved on by each call that joins if there's a batchmaxwait
+}
+
+var batchStats = make(map[string]*BatchStats) // by caller->callee service names
+var collecting = make(map[string]*batch)      // by instance name and dependency service
+var batched = make(map[string]*batch)         // in flight, by span route of the first call
+var batchLock sync.Mutex
+
+func summarizeBatches() {
+	summary := make(map[string]BatchStats, len(batchStats))
+	for k, v := range batchStats {
+		summary[k] = *v
+	}
+	collect.Summarize("batches", summary)
+}
+
+// batchCall adds a call to the batch being collected for an edge with batching, or starts one, and returns false if the edge
+// doesn't batch so the call is sent the usual way. The batch is sent when it's full or the window closes, with the edge latency
+// scaled by the number of calls to the power of the batchscale, so a batch costs less than the calls would one at a time. With
+// a batchmaxwait the window slides, each call that joins keeps it open for another batchwindow until the first call has waited
+// the batchmaxwait
+func batchCall(msg gotocol.Message, name, dep string, c chan gotocol.Message, latency time.Duration) bool {
+	e := archaius.Service(names.Service(name)).Edges[dep]
+	if e.Batch <= 1 {
+		return false
+	}
+	window, err := time.ParseDuration(e.BatchWindow)
+	if err != nil || window <= 0 {
+		window = 5 * time.Millisecond
+	}
+	maxwait, _ := time.ParseDuration(e.BatchMaxWait)
+	key := name + " " + dep
+	batchLock.Lock()
+	defer batchLock.Unlock()
+	b := collecting[key]
+	if b == nil {
+		b = &batch{names.Service(name) + "->" + dep, msg, name, dep, c, latency, msg.ResponseChan, nil, nil, msg.Sent.Add(window)}
+		collecting[key] = b
+		// the window opens when the call is ready to go, after any think time
+		b.timer = clock.AfterFunc(b.due.Sub(clock.Now()), func() {
+			batchLock.Lock()
+			defer batchLock.Unlock()
+			if collecting[key] == b && !clock.Now().Before(b.due) { // not moved on while this waited for the lock
+				sendBatch(key, b, e.BatchScale, false)
+			}
+		})
+		return true
+	}
+	b.calls = append(b.calls, msg)
+	if len(b.calls)+1 >= e.Batch {
+		sendBatch(key, b, e.BatchScale, true)
+		return true
+	}
+	if maxwait > 0 {
+		due := msg.Sent.Add(window)
+		if last := b.first.Sent.Add(maxwait); due.After(last) {
+			due = last
+		}
+		if due.After(b.due) {
+			b.due = due
+			b.timer.Reset(due.Sub(clock.Now()))
+		}
+	}
+	return true
+}
+
+// sendBatch closes a batch and sends its first call over a connection, the caller holds batchLock
+func sendBatch(key string, b *batch, scale float64, full bool) {
+	delete(collecting, key)
+	b.timer.Stop()
+	if scale <= 0 {
+		scale = 0.5
+	}
+	n := len(b.calls) + 1
+	latency := time.Duration(float64(b.latency) * math.Pow(float64(n), scale))
+	s := batchStats[b.edge]
+	if s == nil {
+		s = &BatchStats{Batch: archaius.Service(names.Service(b.name)).Edges[b.dep].Batch}
+		batchStats[b.edge] = s
+	}
+	s.Calls += n
+	s.Batches++
+	if full {
+		s.Filled++
+	}
+	s.Saved = s.Calls - s.Batches
+	s.MeanSize = float64(s.Calls) / float64(s.Batches)
+	for _, m := range append(b.calls, b.first) {
+		w := batchWait(m)
+		s.waits += w
+		if ms := float64(w) / float64(time.Millisecond); ms > s.MaxWait {
+			s.MaxWait = ms
+		}
+	}
+	s.cost += latency - b.latency
+	s.MeanWait = float64(s.waits) / float64(s.Calls) / float64(time.Millisecond)
+	s.MeanCost = float64(s.cost) / float64(s.Batches) / float64(time.Millisecond)
+	summarizeBatches()
+	if n > 1 {
+		batched[b.first.Ctx.Route()] = b
+		flow.NoteBatch(b.first, b.name, fmt.Sprintf("%v calls after %.1fms", n, float64(batchWait(b.first))/float64(time.Millisecond)))
+		for _, m := range b.calls {
+			flow.NoteBatch(m, b.name, fmt.Sprintf("in %v after %.1fms", b.first.Ctx, float64(batchWait(m))/float64(time.Millisecond)))
+		}
+	}
+	connect(b.first, b.name, b.dep, b.to, latency)
+}
+
+// batchWait is how long a call has waited for its batch to go, the latency the batching added to it
+func batchWait(m gotocol.Message) time.Duration {
+	if w := clock.Since(m.Sent); w > 0 {
+		return w
+	}
+	return 0 // still thinking
+}
+
+// unbatch passes the response to the first call of a batch on to the rest of its calls, as responses to them via my own listener
+// so they each take the normal response path. A failure or timeout of the batch fails all of them
+func unbatch(msg gotocol.Message) {
+	batchLock.Lock()
+	defer batchLock.Unlock()
+	b, ok := batched[msg.Ctx.Route()]
+	if !ok {
+		return
+	}
+	delete(batched, msg.Ctx.Route())
+	for _, m := range b.calls {
+		gotocol.Message{gotocol.GetResponse, b.listener, clock.Now(), m.Ctx, msg.Intention}.GoSend(b.listener)
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// TestBatch checks calls over an edge with batching go as one call when the batch fills or its window closes, and the
+// response to that call answers each of them
+func TestBatch(t *testing.T) {
+	archaius.SetService("batchweb", archaius.ServiceConfig{Edges: map[string]archaius.EdgeConfig{"db": {Batch: 3, BatchWindow: "20ms"}}})
+	name := names.Make("test", "us-east-1", "zoneA", "batchweb", "karyon", 0)
+	listener := make(chan gotocol.Message, 10)
+	db := make(chan gotocol.Message, 10)
+	send := func(n int) []gotocol.Message {
+		var ms []gotocol.Message
+		for i := 0; i < n; i++ {
+			m := gotocol.Message{gotocol.GetRequest, listener, time.Now(), gotocol.NewTrace().NewParent(), "get"}
+			if !batchCall(m, name, "db", db, 0) {
+				t.Fatal("the call wasn't batched")
+			}
+			ms = append(ms, m)
+		}
+		return ms
+	}
+	arrived := func() gotocol.Message {
+		select {
+		case m := <-db:
+			return m
+		case <-time.After(time.Second):
+			t.Fatal("the batch wasn't sent")
+		}
+		return gotocol.Message{}
+	}
+	// a full batch goes straight away as its first call
+	calls := send(3)
+	if m := arrived(); m.Ctx != calls[0].Ctx {
+		t.Errorf("batch sent as %v, not the first call %v", m.Ctx, calls[0].Ctx)
+	}
+	unbatch(gotocol.Message{gotocol.GetResponse, db, time.Now(), calls[0].Ctx, "ok"})
+	answered := make(map[gotocol.Context]bool)
+	for range calls[1:] {
+		select {
+		case m := <-listener:
+			if m.Intention != "ok" {
+				t.Errorf("call in the batch answered with %v", m.Intention)
+			}
+			answered[m.Ctx] = true
+		case <-time.After(time.Second):
+			t.Fatal("the rest of the batch wasn't answered")
+		}
+	}
+	if !answered[calls[1].Ctx] || !answered[calls[2].Ctx] {
+		t.Errorf("answered %v", answered)
+	}
+	// one that isn't full waits for the window to close
+	start := time.Now()
+	calls = send(2)
+	if m := arrived(); m.Ctx != calls[0].Ctx || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("batch sent as %v after %v", m.Ctx, time.Since(start))
+	}
+	batchLock.Lock()
+	s := *batchStats["batchweb->db"]
+	batchLock.Unlock()
+	if s.Calls != 5 || s.Batches != 2 || s.Saved != 3 || s.Filled != 1 {
+		t.Errorf("stats %+v", s)
+	}
+}
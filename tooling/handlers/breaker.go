@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/actors/edda"
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// BreakerStats counts what the circuit breakers on an edge did, summed over the instances of the calling service
+type BreakerStats struct {
+	Calls    int     `json:"calls"` // sent while the circuit was closed, and trials
+	Failures int     `json:"failures"`
+	Opened   int     `json:"opened"`
+	Closed   int     `json:"closed"`   // by a trial call that succeeded
+	Trials   int     `json:"trials"`   // calls let through once the circuit had been open for the sleep
+	Rejected int     `json:"rejected"` // calls that failed fast while the circuit was open
+	OpenTime float64 `json:"openms"`   // total time the circuits were open or waiting for a trial
+}
+
+// breaker states, closed lets calls through, open fails them fast, and halfOpen has sent a trial call and fails the rest
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// edgeBreaker is the circuit breaker from a caller instance to a dependency service
+type edgeBreaker struct {
+	state          int
+	start          time.Time // of the window the calls and failures are counted in
+	calls, failed  int
+	opened         time.Time
+	trial          time.Time // when the trial call was sent
+	router         *ribbon.Router
+	caller, callee string // instance and service names
+}
+
+// breakerCall is a call made through a breaker, remembered until its response
+type breakerCall struct {
+	b     *edgeBreaker
+	trial bool
+}
+
+var edgeBreakers = make(map[string]*edgeBreaker)  // by caller instance and dependency service names
+var breakerCalls = make(map[string]breakerCall)   // by span route
+var breakerStats = make(map[string]*BreakerStats) // by caller->dependency service names
+var breakerLock sync.Mutex
+var breakerSummarized sync.Once
+
+// breakerSummary is the breakers section of the summary, copied from the stats when the summary is written rather than on
+// every call
+type breakerSummary struct{}
+
+func (breakerSummary) MarshalJSON() ([]byte, error) {
+	breakerLock.Lock()
+	defer breakerLock.Unlock()
+	summary := make(map[string]BreakerStats, len(breakerStats))
+	for k, v := range breakerStats {
+		summary[k] = *v
+	}
+	return json.Marshal(summary)
+}
+
+// breakerStat finds the stats of the edge a breaker is on, the caller holds breakerLock
+func breakerStat(b *edgeBreaker) *BreakerStats {
+	k := names.Service(b.caller) + "->" + b.callee
+	s := breakerStats[k]
+	if s == nil {
+		s = &BreakerStats{}
+		breakerStats[k] = s
+	}
+	return s
+}
+
+// breakerSleep is how long a circuit stays open before a trial call, and breakerWindow how long failures are counted over
+func breakerSleep(e archaius.EdgeConfig) time.Duration {
+	d, err := time.ParseDuration(e.BreakerSleep)
+	if err != nil || d <= 0 {
+		d = 5 * time.Second
+	}
+	return d
+}
+
+func breakerWindow(e archaius.EdgeConfig) time.Duration {
+	d, err := time.ParseDuration(e.BreakerWindow)
+	if err != nil || d <= 0 {
+		d = 10 * time.Second
+	}
+	return d
+}
+
+// breakerAllows checks the breaker on the edge from an instance to the dependency of a call that's about to be sent, it
+// returns false if the call should fail fast. Once the circuit has been open for the sleep the next call goes as a trial,
+// and another one goes if the trial hasn't been answered after another sleep
+func breakerAllows(outmsg gotocol.Message, name string, router *ribbon.Router, dep string) bool {
+	e := archaius.Service(names.Service(name)).Edges[dep]
+	if e.Breaker <= 0 {
+		return true
+	}
+	breakerSummarized.Do(func() { collect.Summarize("breakers", breakerSummary{}) })
+	breakerLock.Lock()
+	defer breakerLock.Unlock()
+	key := name + " " + dep
+	b := edgeBreakers[key]
+	if b == nil {
+		b = &edgeBreaker{start: clock.Now(), router: router, caller: name, callee: dep}
+		edgeBreakers[key] = b
+	}
+	s := breakerStat(b)
+	sleep := breakerSleep(e)
+	trial := false
+	switch b.state {
+	case breakerOpen:
+		trial = clock.Since(b.opened) >= sleep
+	case breakerHalfOpen:
+		trial = clock.Since(b.trial) >= sleep
+	}
+	if b.state != breakerClosed && !trial {
+		s.Rejected++
+		return false
+	}
+	if trial {
+		b.state, b.trial = breakerHalfOpen, clock.Now()
+		s.Trials++
+	}
+	s.Calls++
+	breakerCalls[outmsg.Ctx.Route()] = breakerCall{b, trial}
+	return true
+}
+
+// breakerSent records a trial call in the flow, once its annotation has been made
+func breakerSent(outmsg gotocol.Message, name string) {
+	breakerLock.Lock()
+	bc, ok := breakerCalls[outmsg.Ctx.Route()]
+	breakerLock.Unlock()
+	if ok && bc.trial {
+		flow.NoteBreaker(outmsg, name, "half-open trial")
+	}
+}
+
+// breakerResponse counts the response to a call made through a breaker, opening the circuit if too many of the calls in
+// the window failed, or closing it or opening it again after a trial
+func breakerResponse(msg gotocol.Message, name string) {
+	breakerLock.Lock()
+	bc, ok := breakerCalls[msg.Ctx.Route()]
+	if !ok {
+		breakerLock.Unlock()
+		return
+	}
+	delete(breakerCalls, msg.Ctx.Route()) // a late response after a timeout is dropped
+	b := bc.b
+	e := archaius.Service(names.Service(name)).Edges[b.callee]
+	s := breakerStat(b)
+	failed := gotocol.Failed(msg.Intention)
+	if w := breakerWindow(e); clock.Since(b.start) >= w {
+		b.start, b.calls, b.failed = clock.Now(), 0, 0
+	}
+	b.calls++
+	if failed {
+		b.failed++
+		s.Failures++
+	}
+	change := ""
+	volume := e.BreakerVolume
+	if volume == 0 {
+		volume = 20
+	}
+	switch {
+	case bc.trial && b.state == breakerHalfOpen && !failed:
+		s.OpenTime += float64(clock.Since(b.opened)) / float64(time.Millisecond)
+		b.state, b.start, b.calls, b.failed = breakerClosed, clock.Now(), 0, 0
+		s.Closed++
+		change = "closed"
+	case bc.trial && b.state == breakerHalfOpen:
+		b.state = breakerOpen
+		s.OpenTime += float64(clock.Since(b.opened)) / float64(time.Millisecond)
+		b.opened = clock.Now()
+		change = "opened"
+	case b.state == breakerClosed && failed && b.calls >= volume && float64(b.failed) >= e.Breaker*float64(b.calls):
+		b.state, b.opened = breakerOpen, clock.Now()
+		s.Opened++
+		change = "opened"
+	}
+	breakerLock.Unlock()
+	if change == "" {
+		return
+	}
+	flow.NoteBreaker(msg, name, change)
+	if archaius.Conf.Msglog || change == "opened" && !bc.trial {
+		log.Printf("%v: circuit breaker to %v %v\n", name, b.callee, change)
+	}
+	if !bc.trial {
+		collect.Mark("breakeropen", name+" -> "+b.callee)
+	} else if change == "closed" {
+		collect.Mark("breakerclose", name+" -> "+b.callee)
+	}
+	if change == "opened" && bc.trial {
+		return // edda already has the edges gone
+	}
+	// edda drops the edges to the dependency from the graph while the circuit is open, and puts them back when it closes
+	imp := gotocol.Forget
+	if change == "closed" {
+		imp = gotocol.Inform
+	}
+	if edda.Logchan != nil {
+		for _, n := range b.router.Names() {
+			if names.Service(n) == b.callee {
+				edda.Logchan <- gotocol.Message{imp, nil, clock.Now(), gotocol.NilContext, name + " " + n}
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// TestBreaker takes the breaker on an edge round its states, it opens on failures, rejects calls while open, lets a trial
+// through after the sleep, closes when the trial succeeds and opens again when one fails
+func TestBreaker(t *testing.T) {
+	archaius.SetService("web", archaius.ServiceConfig{Edges: map[string]archaius.EdgeConfig{"db": {Breaker: 0.5, BreakerVolume: 2, BreakerSleep: "20ms"}}})
+	name := names.Make("test", "us-east-1", "zoneA", "web", "karyon", 0)
+	router := ribbon.MakeRouter()
+	call := func() (gotocol.Message, bool) {
+		outmsg := gotocol.Message{gotocol.GetRequest, nil, time.Now(), gotocol.NewTrace().NewParent(), "get"}
+		return outmsg, breakerAllows(outmsg, name, router, "db")
+	}
+	respond := func(outmsg gotocol.Message, failed bool) {
+		body := "ok"
+		if failed {
+			body = gotocol.Failure("error")
+		}
+		breakerResponse(gotocol.Message{gotocol.GetResponse, nil, time.Now(), outmsg.Ctx, body}, name)
+	}
+	state := func() int {
+		breakerLock.Lock()
+		defer breakerLock.Unlock()
+		return edgeBreakers[name+" db"].state
+	}
+	// closed to open, once enough of the calls in the window have failed
+	for i := 0; i < 2; i++ {
+		m, ok := call()
+		if !ok {
+			t.Fatalf("call %v rejected while closed", i)
+		}
+		respond(m, true)
+	}
+	if state() != breakerOpen {
+		t.Fatalf("breaker in state %v after the failures", state())
+	}
+	// rejected while open
+	if _, ok := call(); ok {
+		t.Error("call let through while open")
+	}
+	// a trial after the sleep, and the rest rejected until it's answered
+	time.Sleep(25 * time.Millisecond)
+	trial, ok := call()
+	if !ok || state() != breakerHalfOpen {
+		t.Fatalf("no trial after the sleep, state %v", state())
+	}
+	if _, ok := call(); ok {
+		t.Error("call let through while the trial is out")
+	}
+	// a trial that succeeds closes it
+	respond(trial, false)
+	if state() != breakerClosed {
+		t.Fatalf("breaker in state %v after the trial succeeded", state())
+	}
+	// and one that fails opens it again
+	for i := 0; i < 2; i++ {
+		m, _ := call()
+		respond(m, true)
+	}
+	time.Sleep(25 * time.Millisecond)
+	trial, _ = call()
+	respond(trial, true)
+	if state() != breakerOpen {
+		t.Fatalf("breaker in state %v after the trial failed", state())
+	}
+	if _, ok := call(); ok {
+		t.Error("call let through after the trial failed")
+	}
+	var summary map[string]BreakerStats
+	j, err := json.Marshal(breakerSummary{})
+	if err != nil || json.Unmarshal(j, &summary) != nil {
+		t.Fatal(err, string(j))
+	}
+	s := summary["web->db"]
+	if s.Opened != 2 || s.Closed != 1 || s.Trials != 2 || s.Rejected != 3 || s.Failures != 5 || s.Calls != 6 {
+		t.Errorf("summary %+v", s)
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// CoalesceStats counts the identical requests a service answered from a single call to its dependencies, summed over its instances
+type CoalesceStats struct {
+	Requests  int `json:"requests"`
+	Calls     int `json:"calls"`     // requests that were passed on, with others waiting on them or not
+	Coalesced int `json:"coalesced"` // requests that waited for an identical one already in flight
+}
+
+// flight is a request being passed on by an instance, and the identical requests waiting for its response
+type flight struct {
+	started time.Time
+	key     string
+	waiters []gotocol.Routetype
+}
+
+var flights = make(map[string]*flight)             // by instance name and coalesce key
+var leaders = make(map[string]*flight)             // by instance name and request route
+var coalesceStats = make(map[string]CoalesceStats) // by service name
+var coalesceLock sync.Mutex
+
+func summarizeCoalesce() {
+	summary := make(map[string]CoalesceStats, len(coalesceStats))
+	for k, v := range coalesceStats {
+		summary[k] = v
+	}
+	collect.Summarize("coalesce", summary)
+}
+
+// coalesceKey is what identifies identical requests, the request body or the value of a baggage item
+func coalesceKey(msg gotocol.Message, cc *archaius.CoalesceConfig) string {
+	if cc.Key == "" {
+		return msg.Intention
+	}
+	return msg.Ctx.BaggageItem(cc.Key)
+}
+
+// Coalesce attaches a request to an identical one that this instance already has in flight, if the service coalesces requests,
+// so it gets the same response without another call to the dependencies, and returns true if it did
+func Coalesce(msg gotocol.Message, name string) bool {
+	cc := archaius.Service(names.Service(name)).Coalesce
+	if cc == nil {
+		return false
+	}
+	key := coalesceKey(msg, cc)
+	if key == "" {
+		return false
+	}
+	window, err := time.ParseDuration(cc.Window)
+	if err != nil || window <= 0 {
+		window = time.Second
+	}
+	coalesceLock.Lock()
+	defer coalesceLock.Unlock()
+	f := flights[name+" "+key]
+	if f == nil || clock.Since(f.started) >= window {
+		return false
+	}
+	f.waiters = append(f.waiters, msg.Route())
+	s := coalesceStats[names.Service(name)]
+	s.Requests++
+	s.Coalesced++
+	coalesceStats[names.Service(name)] = s
+	summarizeCoalesce()
+	return true
+}
+
+// lead makes a request that was passed on the one later identical requests wait for, until it's answered or the window runs out.
+// A request that couldn't be passed on isn't in flight, so nothing waits for it
+func lead(msg gotocol.Message, name string) {
+	cc := archaius.Service(names.Service(name)).Coalesce
+	if cc == nil {
+		return
+	}
+	key := coalesceKey(msg, cc)
+	if key == "" {
+		return
+	}
+	coalesceLock.Lock()
+	defer coalesceLock.Unlock()
+	f := &flight{started: clock.Now(), key: key}
+	flights[name+" "+key] = f
+	leaders[name+" "+msg.Ctx.Route()] = f
+	s := coalesceStats[names.Service(name)]
+	s.Requests++
+	s.Calls++
+	coalesceStats[names.Service(name)] = s
+	summarizeCoalesce()
+}
+
+// answerCoalesced sends the response to a request on to the identical requests that were waiting for it,
+// tagged as coalesced in the flow
+func answerCoalesced(r gotocol.Routetype, name string, listener chan gotocol.Message, intention string) {
+	coalesceLock.Lock()
+	f := leaders[name+" "+r.Ctx.Route()]
+	if f == nil {
+		coalesceLock.Unlock()
+		return
+	}
+	delete(leaders, name+" "+r.Ctx.Route())
+	if flights[name+" "+f.key] == f {
+		delete(flights, name+" "+f.key)
+	}
+	coalesceLock.Unlock()
+	for _, w := range f.waiters {
+		collect.MeasureService(names.Service(name), clock.Since(w.Sent), gotocol.Failed(intention))
+		outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), w.Ctx, intention}
+		flow.AnnotateDedup(outmsg, name, "coalesced")
+		outmsg.GoRespond(w.ResponseChan)
+	}
+}
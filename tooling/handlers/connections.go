@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// call waiting for a free connection
+type pending struct {
+	msg     gotocol.Message
+	to      chan gotocol.Message
+	latency time.Duration
+}
+
+// pool of connections from one instance to a dependency service
+type pool struct {
+	edge    string // caller->callee service names
+	inuse   int
+	waiting []pending
+}
+
+// ConnStats counts the calls that queued for a free connection on an edge, summed over the calling instances
+type ConnStats struct {
+	Waits      int     `json:"waits"`
+	MeanWait   float64 `json:"meanwaitms"`
+	MaxWait    float64 `json:"maxwaitms"`
+	MaxWaiting int     `json:"maxwaiting"`
+	total      time.Duration
+}
+
+var pools = make(map[string]*pool)         // by instance name and dependency service
+var held = make(map[string]string)         // pool key by span route, while the call is waiting or in flight
+var connStats = make(map[string]ConnStats) // by caller->callee service names
+var connLock sync.Mutex
+
+// connect sends a call once the caller has a free connection to the dependency, the request latency starts when it is sent.
+// The client send annotation has already been made, so any wait shows up as network time in the flow
+func connect(msg gotocol.Message, name string, dep string, c chan gotocol.Message, latency time.Duration) {
+	limit := archaius.Service(names.Service(name)).Edges[dep].Connections
+	if limit <= 0 {
+		deliver(msg, c, latency)
+		return
+	}
+	key := name + " " + dep
+	connLock.Lock()
+	defer connLock.Unlock()
+	p := pools[key]
+	if p == nil {
+		p = &pool{edge: names.Service(name) + "->" + dep}
+		pools[key] = p
+	}
+	held[msg.Ctx.Route()] = key
+	if p.inuse < limit {
+		p.inuse++
+		deliver(msg, c, latency)
+		return
+	}
+	p.waiting = append(p.waiting, pending{msg, c, latency})
+	s := connStats[p.edge]
+	if len(p.waiting) > s.MaxWaiting {
+		s.MaxWaiting = len(p.waiting)
+		connStats[p.edge] = s
+	}
+}
+
+// Release frees the connection used by a call when its response or timeout arrives, and sends the next waiting call.
+// A call that times out while it is still waiting is never sent. Adaptive balancing also learns the latency of the call here
+func Release(msg gotocol.Message) {
+	responded(msg)
+	finished(msg)
+	connLock.Lock()
+	defer connLock.Unlock()
+	key, ok := held[msg.Ctx.Route()]
+	if !ok {
+		return
+	}
+	delete(held, msg.Ctx.Route())
+	p := pools[key]
+	for i, w := range p.waiting {
+		if w.msg.Ctx == msg.Ctx {
+			p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+			return
+		}
+	}
+	if len(p.waiting) == 0 {
+		p.inuse--
+		return
+	}
+	w := p.waiting[0] // hand the connection straight to the next call
+	p.waiting = p.waiting[1:]
+	wait := clock.Since(w.msg.Sent)
+	if wait < 0 {
+		wait = 0 // still thinking
+	}
+	deliver(w.msg, w.to, w.latency)
+	s := connStats[p.edge]
+	s.Waits++
+	s.total += wait
+	s.MeanWait = float64(s.total) / float64(s.Waits) / float64(time.Millisecond)
+	if ms := float64(wait) / float64(time.Millisecond); ms > s.MaxWait {
+		s.MaxWait = ms
+	}
+	connStats[p.edge] = s
+	summary := make(map[string]ConnStats, len(connStats))
+	for k, v := range connStats {
+		summary[k] = v
+	}
+	collect.Summarize("connections", summary)
+}
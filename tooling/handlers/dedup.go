@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// DedupStats counts the requests with idempotency keys seen by a service, the keys held are the memory cost of the cache
+type DedupStats struct {
+	Window     string `json:"window"`
+	Checked    int    `json:"checked"`
+	Cached     int    `json:"cached"`     // retries answered with the result of the earlier request
+	InProgress int    `json:"inprogress"` // retries that arrived before the earlier request finished, failed so they are retried again
+	Keys       int    `json:"keys"`
+	MaxKeys    int    `json:"maxkeys"`
+}
+
+// result of a request, kept for the dedup window of the service
+type dedupEntry struct {
+	id     gotocol.TraceContextType
+	seen   time.Time
+	done   bool
+	result string
+}
+
+// dedupCache is shared by all the instances of a service, like an idempotency table in a database they all use
+type dedupCache struct {
+	entries map[gotocol.TraceContextType]*dedupEntry
+	order   []*dedupEntry // oldest first, for expiry
+	stats   DedupStats
+}
+
+var dedups = make(map[string]*dedupCache) // by service name
+var dedupLock sync.Mutex
+
+// expire forgets the keys that are older than the window
+func (c *dedupCache) expire(window time.Duration) {
+	for len(c.order) > 0 && clock.Since(c.order[0].seen) > window {
+		if c.entries[c.order[0].id] == c.order[0] {
+			delete(c.entries, c.order[0].id)
+		}
+		c.order = c.order[1:]
+	}
+	c.stats.Keys = len(c.entries)
+}
+
+func summarizeDedup() {
+	summary := make(map[string]DedupStats, len(dedups))
+	for k, v := range dedups {
+		summary[k] = v.stats
+	}
+	collect.Summarize("dedup", summary)
+}
+
+// Duplicate answers a retry of a request this service has already seen from the idempotency cache, and returns true if it did.
+// A retry of a request that hasn't finished yet gets a failure, so the caller tries again later
+func Duplicate(msg gotocol.Message, name string, listener chan gotocol.Message) bool {
+	if msg.Ctx.Request == 0 {
+		return false
+	}
+	window, _ := time.ParseDuration(archaius.Service(names.Service(name)).Dedup)
+	if window <= 0 {
+		return false
+	}
+	dedupLock.Lock()
+	defer dedupLock.Unlock()
+	c := dedups[names.Service(name)]
+	if c == nil {
+		c = &dedupCache{entries: make(map[gotocol.TraceContextType]*dedupEntry)}
+		c.stats.Window = window.String()
+		dedups[names.Service(name)] = c
+	}
+	c.expire(window)
+	c.stats.Checked++
+	e := c.entries[msg.Ctx.Request]
+	if e == nil {
+		e = &dedupEntry{id: msg.Ctx.Request, seen: clock.Now()}
+		c.entries[e.id] = e
+		c.order = append(c.order, e)
+		c.stats.Keys = len(c.entries)
+		if c.stats.Keys > c.stats.MaxKeys {
+			c.stats.MaxKeys = c.stats.Keys
+		}
+		summarizeDedup()
+		return false
+	}
+	outcome, result := "cached", e.result
+	if e.done {
+		c.stats.Cached++
+	} else {
+		outcome, result = "inprogress", gotocol.Failure("inprogress")
+		c.stats.InProgress++
+	}
+	summarizeDedup()
+	collect.MeasureService(names.Service(name), clock.Since(msg.Sent), !e.done)
+	outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, result}
+	flow.AnnotateDedup(outmsg, name, outcome)
+	outmsg.GoRespond(msg.ResponseChan)
+	return true
+}
+
+// Remember the response to a request with an idempotency key, a failed request is forgotten so a retry does it again
+func Remember(msg gotocol.Message, name string) {
+	if msg.Ctx.Request == 0 {
+		return
+	}
+	dedupLock.Lock()
+	defer dedupLock.Unlock()
+	c := dedups[names.Service(name)]
+	if c == nil || c.entries[msg.Ctx.Request] == nil {
+		return // no dedup window, or already expired
+	}
+	if gotocol.Failed(msg.Intention) {
+		delete(c.entries, msg.Ctx.Request)
+		c.stats.Keys = len(c.entries)
+		return
+	}
+	e := c.entries[msg.Ctx.Request]
+	e.done, e.result = true, msg.Intention
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// DNSStats counts the hostname lookups made by the instances of a service before calling its dependencies
+type DNSStats struct {
+	Calls    int     `json:"calls"`
+	Cached   int     `json:"cached"`   // answered from the instance's cache
+	Resolved int     `json:"resolved"` // looked up, the first call to a dependency or the first after the ttl ran out
+	Expired  int     `json:"expired"`  // of the resolved ones, looked up again after the ttl
+	Extra    float64 `json:"extrams"`  // lookup latency added to the calls
+}
+
+var resolved = make(map[string]time.Time) // when the lookup expires, by caller instance and callee service names
+var dnsStats = make(map[string]DNSStats)  // by caller service name
+var dnsLock sync.Mutex
+
+func summarizeDNS() {
+	summary := make(map[string]DNSStats, len(dnsStats))
+	for k, v := range dnsStats {
+		summary[k] = v
+	}
+	collect.Summarize("dns", summary)
+}
+
+// resolve is the latency of looking up the hostname of the callee's service before a call to it, zero if the caller has it
+// cached or doesn't model dns
+func resolve(name, callee string) time.Duration {
+	d := archaius.Service(names.Service(name)).DNS
+	if d == nil || d.Latency == "" {
+		return 0
+	}
+	dnsLock.Lock()
+	defer dnsLock.Unlock()
+	k := name + " " + names.Service(callee)
+	s := dnsStats[names.Service(name)]
+	s.Calls++
+	expires, seen := resolved[k]
+	var l time.Duration
+	if !seen || !expires.IsZero() && !clock.Now().Before(expires) {
+		l, _ = time.ParseDuration(d.Latency)
+		s.Resolved++
+		if seen {
+			s.Expired++
+		}
+		s.Extra += float64(l) / float64(time.Millisecond)
+		resolved[k] = time.Time{} // cached for the rest of the run without a ttl
+		if ttl, err := time.ParseDuration(d.TTL); err == nil {
+			resolved[k] = clock.Now().Add(l + ttl)
+		}
+	} else {
+		s.Cached++
+	}
+	dnsStats[names.Service(name)] = s
+	summarizeDNS()
+	return l
+}
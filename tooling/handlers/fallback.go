@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// call to a dependency with a fallback, remembered until its response
+type fallbackCall struct {
+	dependency string // service name
+	response   string
+}
+
+var fallbackCalls = make(map[string]fallbackCall) // by span route
+var fallbackStats = make(map[string]int)          // degraded responses by caller->dependency service names
+var fallbackLock sync.Mutex
+
+func summarizeFallback() {
+	summary := make(map[string]int, len(fallbackStats))
+	for k, v := range fallbackStats {
+		summary[k] = v
+	}
+	collect.Summarize("fallback", summary)
+}
+
+// fallbackSent remembers a call to a dependency that has a fallback configured on the edge
+func fallbackSent(outmsg gotocol.Message, name, callee string) {
+	dep := names.Service(callee)
+	fb := archaius.Service(names.Service(name)).Edges[dep].Fallback
+	if fb == "" {
+		return
+	}
+	fallbackLock.Lock()
+	fallbackCalls[outmsg.Ctx.Route()] = fallbackCall{dep, fb}
+	fallbackLock.Unlock()
+}
+
+// fallback forgets the call a response is for, and if the call failed and has a fallback it returns the degraded response
+// and the dependency it stands in for. Otherwise the response is passed back as it is, and the dependency is empty
+func fallback(msg gotocol.Message, name string) (intention, dependency string) {
+	fallbackLock.Lock()
+	defer fallbackLock.Unlock()
+	fc, ok := fallbackCalls[msg.Ctx.Route()]
+	if !ok {
+		return msg.Intention, ""
+	}
+	delete(fallbackCalls, msg.Ctx.Route()) // a late response after a timeout is dropped anyway
+	if !gotocol.Failed(msg.Intention) {
+		return msg.Intention, ""
+	}
+	fallbackStats[names.Service(name)+"->"+fc.dependency]++
+	summarizeFallback()
+	return fc.response, fc.dependency
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// FanoutStats is the calls made over an edge with a fanout, and the most calls one request at the top of a trace turned into by
+// the time they arrived, multiplied up by every fanout on the way
+type FanoutStats struct {
+	Fanout   int `json:"fanout"`
+	Requests int `json:"requests"`
+	Calls    int `json:"calls"`
+	Chain    int `json:"chain"`
+}
+
+var fanoutStats = make(map[string]FanoutStats) // by caller->callee service names
+var fanouts = make(map[string]int)             // responses still to come, by span route
+var fanoutLock sync.Mutex
+
+func summarizeFanout() {
+	summary := make(map[string]FanoutStats, len(fanoutStats))
+	for k, v := range fanoutStats {
+		summary[k] = v
+	}
+	collect.Summarize("fanout", summary)
+}
+
+// amplify tags a call to a dependency with a fanout with fanout=<calls> baggage, the product of the fanouts of this edge and every
+// edge before it in the trace, so the amplification a request has gone through is on every span after it in the flows
+func amplify(ctx gotocol.Context, name, dep string) gotocol.Context {
+	n := archaius.Service(names.Service(name)).Edges[dep].Fanout
+	if n <= 1 {
+		return ctx
+	}
+	chain, err := strconv.Atoi(ctx.BaggageItem("fanout"))
+	if err != nil || chain < 1 {
+		chain = 1
+	}
+	return ctx.WithBaggage("fanout", strconv.Itoa(chain*n))
+}
+
+// fanout makes the rest of the calls to a dependency with a fanout after the first one has been sent, each as a new span to a random
+// instance of the dependency, and waits for all of them to respond before the response goes back up
+func fanout(outmsg gotocol.Message, name string, router *ribbon.Router, dep string) {
+	n := archaius.Service(names.Service(name)).Edges[dep].Fanout
+	if n <= 1 {
+		return
+	}
+	r := router.Select(func(i string) bool { return names.Service(i) == dep })
+	chain, _ := strconv.Atoi(outmsg.Ctx.BaggageItem("fanout"))
+	k := names.Service(name) + "->" + dep
+	fanoutLock.Lock()
+	fanouts[outmsg.Ctx.Route()] = n
+	s := fanoutStats[k]
+	s.Fanout = n
+	s.Requests++
+	s.Calls += n
+	if chain > s.Chain {
+		s.Chain = chain
+	}
+	fanoutStats[k] = s
+	summarizeFanout()
+	fanoutLock.Unlock()
+	for i := 1; i < n; i++ {
+		c := r.Random()
+		if c == nil {
+			fanin(outmsg) // nowhere to send it, so don't wait for it
+			continue
+		}
+		latency, _, _ := edge(name, router, c)
+		m := gotocol.Message{gotocol.GetRequest, outmsg.ResponseChan, outmsg.Sent, outmsg.Ctx.AddSpan(), outmsg.Intention}
+		flow.AnnotateSend(m, name)
+		m.GoSendAfter(c, m.Sent.Sub(clock.Now())+latency)
+	}
+}
+
+// fanin counts a response to one of the calls of a fanout, and returns true until the last one arrives so the others go no further.
+// A failure is passed on straight away and the rest of the responses are dropped, as they are after a timeout
+func fanin(msg gotocol.Message) bool {
+	fanoutLock.Lock()
+	defer fanoutLock.Unlock()
+	n, ok := fanouts[msg.Ctx.Route()]
+	if !ok {
+		return false
+	}
+	if n <= 1 || gotocol.Failed(msg.Intention) {
+		delete(fanouts, msg.Ctx.Route())
+		return false
+	}
+	fanouts[msg.Ctx.Route()] = n - 1
+	return true
+}
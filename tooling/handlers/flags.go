@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// FlagStats is what a feature flag did over the run, the times it was flipped and the calls routed by it either way
+type FlagStats struct {
+	On      bool           `json:"on"` // at the end of the run
+	Flips   int            `json:"flips"`
+	Changes []FlagFlip     `json:"changes,omitempty"`
+	Calls   map[string]int `json:"calls,omitempty"` // by caller->callee and flag state, e.g. home->recs off
+}
+
+// FlagFlip is a change of a feature flag, At milliseconds since the run started, and who made it
+type FlagFlip struct {
+	At float64 `json:"ms"`
+	On bool    `json:"on"`
+	By string  `json:"by"`
+}
+
+var flagStats = make(map[string]*FlagStats)
+var flagLock sync.Mutex
+
+func summarizeFlags() {
+	summary := make(map[string]FlagStats, len(flagStats))
+	for k, v := range flagStats {
+		s := *v
+		s.Changes = append([]FlagFlip(nil), v.Changes...)
+		s.Calls = make(map[string]int, len(v.Calls))
+		for e, n := range v.Calls {
+			s.Calls[e] = n
+		}
+		summary[k] = s
+	}
+	collect.Summarize("flags", summary)
+}
+
+// the stats of a flag, called with the lock held
+func flagStat(flag string) *FlagStats {
+	s := flagStats[flag]
+	if s == nil {
+		s = &FlagStats{Calls: make(map[string]int)}
+		s.On, _ = archaius.FlagOn(flag)
+		flagStats[flag] = s
+	}
+	return s
+}
+
+// flagged is true if calls over an edge can be routed while its feature flag is in its current state, edges without a flag always can
+func flagged(flag string) bool {
+	if flag == "" {
+		return true
+	}
+	on, _ := archaius.FlagOn(strings.TrimPrefix(flag, "!"))
+	return on != strings.HasPrefix(flag, "!")
+}
+
+// flagSent notes the state of the feature flag a call was routed by in the flow, and counts it
+func flagSent(msg gotocol.Message, name, dep string) {
+	flag := strings.TrimPrefix(archaius.Edge(names.Service(name), dep).Flag, "!")
+	if flag == "" {
+		return
+	}
+	on, _ := archaius.FlagOn(flag)
+	state := "off"
+	if on {
+		state = "on"
+	}
+	flow.NoteFlag(msg, name, flag+"="+state)
+	flagLock.Lock()
+	defer flagLock.Unlock()
+	flagStat(flag).Calls[names.Service(name)+"->"+dep+" "+state]++
+	summarizeFlags()
+}
+
+// FlipFlag turns a feature flag on or off for the instance that holds it, and returns false if there's no such flag. Flipping it
+// to the state it's already in isn't counted
+func FlipFlag(flag string, on bool, name string) bool {
+	flagLock.Lock() // every instance of the featureflag service can be told, only the first one flips it
+	defer flagLock.Unlock()
+	was, ok := archaius.FlagOn(flag)
+	if !ok {
+		return false
+	}
+	if was == on {
+		return true
+	}
+	s := flagStat(flag)
+	archaius.SetFlag(flag, on)
+	state := "off"
+	if on {
+		state = "on"
+	}
+	collect.Mark("flag", flag+"="+state)
+	s.On = on
+	s.Flips++
+	s.Changes = append(s.Changes, FlagFlip{float64(collect.Elapsed()) / float64(time.Millisecond), on, names.Service(name)})
+	summarizeFlags()
+	return true
+}
@@ -3,11 +3,17 @@ package handlers
 
 import (
 	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
 	"github.com/adrianco/spigo/tooling/flow"
 	"github.com/adrianco/spigo/tooling/gotocol"
 	"github.com/adrianco/spigo/tooling/names"
 	"github.com/adrianco/spigo/tooling/ribbon"
 	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -25,36 +31,273 @@ func DebugContext(ctx gotocol.Context) gotocol.Context {
 	return gotocol.NilContext
 }
 
+// NewTrace starts a trace carrying one of the baggage entries configured for this service, and one of its sessions
+func NewTrace(name string) gotocol.Context {
+	ctx := gotocol.NewTrace()
+	s := archaius.Service(names.Service(name))
+	if len(s.Baggage) > 0 {
+		ctx.Baggage = s.Baggage[archaius.Rand(name).Intn(len(s.Baggage))]
+	}
+	if s.Sessions > 0 {
+		ctx = ctx.WithBaggage("session", strconv.Itoa(archaius.Rand(name).Intn(s.Sessions)))
+	}
+	if s.Deadline == "" {
+		s.Deadline = archaius.Key(archaius.Conf, "deadline") // default for all services
+	}
+	if d, err := time.ParseDuration(s.Deadline); err == nil {
+		ctx = ctx.WithDeadline(d)
+	}
+	return ctx
+}
+
+// edge finds the configured request and response latency and timeout for a call from this service to the dependency listening on c,
+// the request latency includes any cross zone or wan latency and any correlated event that is slowing the dependency down or the latency of the new version it was deployed with, of its instance size, of a memory leak or drawn from the latency model of its service, and both include
+// the time to serialize and deserialize the message if the edge has a format, and a call to another region pays the wan latency
+// both ways
+func edge(name string, router *ribbon.Router, c chan gotocol.Message) (latency, response, timeout time.Duration) {
+	dep := router.NameChan(c)
+	cross := CrossZone(name, dep) + archaius.Degraded(dep) + cold(dep) + sizeLatency(dep) + leaked(dep) + serviceLatency(name, dep)
+	if d := archaius.Deployed(dep); d != nil {
+		l, _ := time.ParseDuration(d.Latency)
+		cross += l
+	}
+	wan := CrossRegion(name, dep)
+	edges := archaius.Service(names.Service(name)).Edges
+	if len(edges) == 0 {
+		return cross, wan, 0
+	}
+	e := edges[names.Service(dep)]
+	latency, _ = time.ParseDuration(e.Latency)
+	response, _ = time.ParseDuration(e.Response)
+	timeout, _ = time.ParseDuration(e.Timeout)
+	sreq, sresp := serdes(names.Service(name), names.Service(dep), e)
+	return jitter(latency) + cross + sreq, jitter(response) + sresp + wan, timeout
+}
+
+var jitterRand *rand.Rand // its own source so jittered latencies are the same for the same seed whatever else uses math/rand
+var jitterFraction float64
+var jitterOnce sync.Once
+var jitterLock sync.Mutex
+
+// jitter varies an edge latency by up to the jitter:fraction keyval either way, so jitter:0.1 is within 10% of the configured
+// latency for each call. The variation comes from the seed keyval, or 1 if it's not set, so a run can be repeated
+func jitter(d time.Duration) time.Duration {
+	jitterOnce.Do(func() {
+		jitterFraction, _ = strconv.ParseFloat(archaius.Key(archaius.Conf, "jitter"), 64)
+		jitterRand = rand.New(rand.NewSource(archaius.Seed()))
+	})
+	if jitterFraction <= 0 || d <= 0 {
+		return d
+	}
+	jitterLock.Lock()
+	f := 1 + jitterFraction*(2*jitterRand.Float64()-1)
+	jitterLock.Unlock()
+	return time.Duration(float64(d) * f)
+}
+
+// CrossZone is the extra latency of a call between instances in different zones of the same region, or across the wan
+// between instances in different regions
+func CrossZone(from, to string) time.Duration {
+	if names.Region(from) != names.Region(to) {
+		return CrossRegion(from, to)
+	}
+	fz, tz := names.Zone(from), names.Zone(to)
+	if fz == "*" || tz == "*" || fz == tz {
+		return 0 // cross zone services like elb are already in every zone
+	}
+	return archaius.ZoneLatency()
+}
+
+// CrossRegion is the one way wan latency between instances in different regions, cross region services like denominator
+// are already in every region
+func CrossRegion(from, to string) time.Duration {
+	fr, tr := names.Region(from), names.Region(to)
+	if fr == "*" || tr == "*" {
+		return 0
+	}
+	return archaius.RegionLatency(fr, tr)
+}
+
+// think finds the processing time before a call that follows a response from another dependency, zero for the first call of a request
+func think(msg gotocol.Message, name string) time.Duration {
+	if msg.Imposition != gotocol.GetResponse {
+		return 0
+	}
+	t, _ := time.ParseDuration(archaius.Service(names.Service(name)).Think)
+	return t
+}
+
+// route picks a random dependency, skipping edges that are conditional on baggage the request doesn't carry, and shadows that only
+// get mirrored calls. If the pick is one of the dependencies that have a weight, the traffic is split between them by weight instead.
+func route(msg gotocol.Message, name string, router *ribbon.Router) chan gotocol.Message {
+	expire(name, router)
+	edges := archaius.Service(names.Service(name)).Edges
+	if len(edges) == 0 {
+		return router.Random()
+	}
+	shadow := shadows(edges)
+	r := router.Select(func(n string) bool {
+		when := edges[names.Service(n)].When
+		return (when == "" || msg.Ctx.HasBaggage(when)) && flagged(edges[names.Service(n)].Flag) && !shadow[names.Service(n)]
+	})
+	c := r.Random()
+	if c == nil || edges[names.Service(r.NameChan(c))].Weight <= 0 {
+		return c
+	}
+	total := 0
+	weights := make(map[string]int)
+	for _, n := range r.Names() {
+		s := names.Service(n)
+		if w := edges[s].Weight; w > 0 && weights[s] == 0 {
+			weights[s] = w
+			total += w
+		}
+	}
+	pick := archaius.Rand(name).Intn(total)
+	for _, n := range r.Names() { // same order as above, first instance of each service marks its share
+		s := names.Service(n)
+		if w := weights[s]; w > 0 {
+			if pick < w {
+				return r.Select(func(n string) bool { return names.Service(n) == s }).Random()
+			}
+			pick -= w
+			weights[s] = 0
+		}
+	}
+	return c
+}
+
+// InjectError fails a request with an error response at the configured error rate for this service, or the rate of the deployment that started this instance, and returns true if it did
+func InjectError(msg gotocol.Message, name string, listener chan gotocol.Message) bool {
+	rate := archaius.Service(names.Service(name)).Errors
+	if d := archaius.Deployed(name); d != nil {
+		rate = d.Errors
+	}
+	if rate <= 0 || archaius.Rand(name).Float64() >= rate {
+		return false
+	}
+	collect.MeasureService(names.Service(name), clock.Since(msg.Sent), true)
+	outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, gotocol.Failure("error")}
+	flow.AnnotateSend(outmsg, name)
+	Remember(outmsg, name)
+	outmsg.GoRespond(msg.ResponseChan)
+	return true
+}
+
+// tooFar is true if a call is more than -maxhops from the start of the request, to stop a loop between services calling each other forever
+func tooFar(msg gotocol.Message) bool {
+	return archaius.Conf.MaxHops > 0 && msg.Ctx.Hops > archaius.Conf.MaxHops
+}
+
+// partitioned is true if a network partition is currently cutting this service off from the dependency listening on c
+func partitioned(name string, router *ribbon.Router, c chan gotocol.Message) bool {
+	return archaius.Partitioned(names.Region(name), names.Region(router.NameChan(c)))
+}
+
+// GCStart returns a channel that fires at the first garbage collection pause, or nil if this service doesn't model them
+func GCStart(name string) <-chan time.Time {
+	gc := archaius.Service(names.Service(name)).GC
+	if gc == nil {
+		return nil
+	}
+	interval, _ := time.ParseDuration(gc.Interval)
+	if interval <= 0 {
+		return nil
+	}
+	// start at a random point in the interval so instances don't all pause together
+	return clock.After(time.Duration(archaius.Rand(name).Int63n(int64(interval))))
+}
+
+// GCPause stops the world for a pause drawn from the configured distribution, then returns a channel for the next pause.
+// Messages queue up on the listener while paused, so the spike shows up in the latency histograms
+func GCPause(name string) <-chan time.Time {
+	gc := archaius.Service(names.Service(name)).GC
+	interval, _ := time.ParseDuration(gc.Interval)
+	mean, _ := time.ParseDuration(gc.Pause)
+	pause := Draw(name, mean, gc.Distribution)
+	if archaius.Conf.Msglog {
+		log.Printf("%v: gc pause %v\n", name, pause)
+	}
+	clock.Sleep(pause)
+	return clock.After(interval - pause) // interval is measured from the start of the pause
+}
+
+// Draw a duration with a mean from a fixed, uniform (0 to twice the mean) or exponential distribution, the default, from the
+// random numbers of the named instance
+func Draw(name string, mean time.Duration, distribution string) time.Duration {
+	switch distribution {
+	case "fixed":
+		return mean
+	case "uniform":
+		return time.Duration(archaius.Rand(name).Int63n(2*int64(mean) + 1))
+	default:
+		return time.Duration(archaius.Rand(name).ExpFloat64() * float64(mean))
+	}
+}
+
 // Inform default handler for Inform message
 func Inform(msg gotocol.Message, name string, listener chan gotocol.Message) chan gotocol.Message {
 	if name == "" {
 		log.Fatal(name + "Inform message received before Hello message")
 	}
+	if archaius.OnStandby(name) { // it registers when it's taken off standby
+		return msg.ResponseChan
+	}
 	// service registry channel is buffered so don't use GoSend to tell Eureka we exist
-	msg.ResponseChan <- gotocol.Message{gotocol.Put, listener, time.Now(), DebugContext(msg.Ctx), name}
+	msg.ResponseChan <- gotocol.Message{gotocol.Put, listener, clock.Now(), DebugContext(msg.Ctx), name}
 	return msg.ResponseChan
 }
 
+// Registries lists the service registry channels in zone name order, so every run asks and tells them the same way
+func Registries(eureka map[string]chan gotocol.Message) []chan gotocol.Message {
+	zones := make([]string, 0, len(eureka))
+	for z := range eureka {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+	chans := make([]chan gotocol.Message, len(zones))
+	for i, z := range zones {
+		chans[i] = eureka[z]
+	}
+	return chans
+}
+
+// Dependencies lists the dependent service names in order, so every run looks them up the same way
+func Dependencies(dependencies map[string]time.Time) []string {
+	deps := make([]string, 0, len(dependencies))
+	for d := range dependencies {
+		deps = append(deps, d)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
 // NameDrop updates local buddy list
 func NameDrop(dependencies *map[string]time.Time, router *ribbon.Router, msg gotocol.Message, name string, listener chan gotocol.Message, eureka map[string]chan gotocol.Message, crosszone ...bool) {
 	if msg.ResponseChan == nil { // dependency by service name, needs to be looked up in eureka
 		(*dependencies)[msg.Intention] = msg.Sent // remember it for later
-		for _, ch := range eureka {
+		for _, ch := range Registries(eureka) {
 			//log.Println(name + " looking up " + msg.Intention)
-			gotocol.Send(ch, gotocol.Message{gotocol.GetRequest, listener, time.Now(), DebugContext(msg.Ctx), msg.Intention})
+			gotocol.Send(ch, gotocol.Message{gotocol.GetRequest, listener, clock.Now(), DebugContext(msg.Ctx), msg.Intention})
 		}
 	} else { // update dependency with full name and listener channel
 		microservice := msg.Intention // message body is buddy name
+		if subscribe(msg, name, listener, eureka) {
+			(*dependencies)[names.Service(microservice)] = msg.Sent // consumers subscribe from any zone
+			return
+		}
 		if len(crosszone) > 0 || names.Zone(name) == names.Zone(microservice) {
 			if microservice != name && router.Named(microservice) == nil { // don't talk to myself or record duplicates
 				// remember how to talk to this buddy
 				router.Add(microservice, msg.ResponseChan, msg.Sent) // message channel is buddy's listener
 				(*dependencies)[names.Service(microservice)] = msg.Sent
-				for _, ch := range eureka {
+				for _, ch := range Registries(eureka) {
 					// tell just one of the service registries I have a new buddy to talk to so it doesn't get logged more than once
-					gotocol.Send(ch, gotocol.Message{gotocol.Inform, listener, time.Now(), DebugContext(msg.Ctx), name + " " + microservice})
+					gotocol.Send(ch, gotocol.Message{gotocol.Inform, listener, clock.Now(), DebugContext(msg.Ctx), name + " " + microservice})
 					return
 				}
+			} else if microservice != name {
+				router.Add(microservice, msg.ResponseChan, msg.Sent) // eureka confirmed it's still there, so it's fresh again
 			}
 		}
 	}
@@ -73,36 +316,148 @@ func Forget(dependencies *map[string]time.Time, router *ribbon.Router, msg gotoc
 // Put sends a Put message to a service
 func Put(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype, router *ribbon.Router) {
 	// pass on request to a random service - client send
-	c := router.Random()
+	c := route(msg, name, router)
 	if c == nil {
 		return
 	}
-	outmsg := gotocol.Message{gotocol.Put, listener, time.Now(), msg.Ctx.NewParent(), msg.Intention}
-	flow.AnnotateSend(outmsg, name)
-	outmsg.GoSend(c)
+	latency, _, _ := edge(name, router, c)
+	ml, _, mesh := sidecar(name, router.NameChan(c))
+	latency += ml + resolve(name, router.NameChan(c))
+	outmsg := gotocol.Message{gotocol.Put, listener, clock.Now(), msg.Ctx.NewParent(), msg.Intention}
+	if toTopic(router, c) {
+		outmsg.Imposition = gotocol.Publish
+	}
+	if outmsg.Ctx.Exceeds(latency) || partitioned(name, router, c) || tooFar(outmsg) {
+		flow.AnnotateFailFast(outmsg, name) // not enough time left, can't get there or too many hops, so don't bother
+		return
+	}
+	flow.AnnotateMesh(outmsg, name, mesh)
+	outmsg.GoSendAfter(c, latency)
 }
 
-// GetRequest sends a GetRequest message to a service
-func GetRequest(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype, router *ribbon.Router) {
+// GetRequest sends a GetRequest message to a service, and returns the requestor key for the new span, or "" if there wasn't one
+func GetRequest(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype, router *ribbon.Router) string {
+	if msg.Imposition == gotocol.GetRequest && (OOM(msg, name, listener, requestor) || Duplicate(msg, name, listener) || InjectError(msg, name, listener) || Coalesce(msg, name)) { // only once per request, not again for each dependency
+		return ""
+	}
+	ctr := call(msg, name, listener, requestor, router, think(msg, name), nil)
+	if ctr != "" && msg.Imposition == gotocol.GetRequest {
+		lead(msg, name)
+	}
+	inFlight(name, len(*requestor))
+	return ctr
+}
+
+// call passes a request on to a dependency after thinking for t, retry is the failed call if this is a retry by a sidecar
+func call(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype, router *ribbon.Router, t time.Duration, retry *meshCall) string {
 	// pass on request to a random service - client send
-	c := router.Random()
+	c := route(msg, name, router)
 	if c == nil {
-		return
+		return ""
 	}
-	outmsg := gotocol.Message{gotocol.GetRequest, listener, time.Now(), msg.Ctx.NewParent(), msg.Intention}
-	flow.AnnotateSend(outmsg, name)
+	c = balance(msg, name, router, c)
+	open := false
+	if b := breaker(name, router, c, retry); b != nil {
+		c = b
+	} else {
+		open = true // tried anyway, fail fast below
+	}
+	latency, response, timeout := edge(name, router, c)
+	ml, mr, mesh := sidecar(name, router.NameChan(c))
+	latency, response = latency+ml, response+mr
+	wl := warmup(name, router.NameChan(c))
+	dl := resolve(name, router.NameChan(c))
+	rl := rehome(msg, name, router.NameChan(c))
+	latency += wl + dl + rl + pageLatency(name, router.NameChan(c))
+	outmsg := gotocol.Message{gotocol.GetRequest, listener, clock.Now().Add(t), idempotent(amplify(msg.Ctx.NewParent(), name, names.Service(router.NameChan(c))).WithResponse(response), name, retry), msg.Intention}
 	(*requestor)[outmsg.Ctx.Route()] = msg.Route() // remember where to respond to when this span comes back
-	outmsg.GoSend(c)
+	fallbackSent(outmsg, name, router.NameChan(c)) // fail fast below counts as a failure of the dependency too
+	if tooFar(outmsg) {
+		flow.AnnotateFailFast(outmsg, name)
+		gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, gotocol.Failure("hops")}.GoSend(listener)
+		return outmsg.Ctx.Route()
+	}
+	if outmsg.Ctx.Exceeds(t + latency + response) {
+		// not enough time left, fail fast via my own listener so the failure takes the normal response path
+		flow.AnnotateFailFast(outmsg, name)
+		gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, gotocol.Failure("deadline")}.GoSend(listener)
+		return outmsg.Ctx.Route()
+	}
+	if partitioned(name, router, c) {
+		flow.AnnotateFailFast(outmsg, name)
+		gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, gotocol.Failure("partition")}.GoSend(listener)
+		return outmsg.Ctx.Route()
+	}
+	if open {
+		flow.AnnotateFailFast(outmsg, name)
+		gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, gotocol.Failure("circuit")}.GoSend(listener)
+		return outmsg.Ctx.Route()
+	}
+	if !breakerAllows(outmsg, name, router, names.Service(router.NameChan(c))) {
+		flow.AnnotateFailFast(outmsg, name)
+		flow.NoteBreaker(outmsg, name, "open")
+		gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, gotocol.Failure("breaker")}.GoSend(listener)
+		return outmsg.Ctx.Route()
+	}
+	if publish(outmsg, name, listener, router, c, latency) {
+		return outmsg.Ctx.Route()
+	}
+	flow.AnnotateMesh(outmsg, name, meshNote(mesh, retry))
+	breakerSent(outmsg, name)
+	flagSent(outmsg, name, names.Service(router.NameChan(c)))
+	meshSent(outmsg, msg, name, router, router.NameChan(c), retry)
+	sending(outmsg, name, router.NameChan(c))
+	healthSent(outmsg, router.NameChan(c))
+	warmSent(outmsg, name, router.NameChan(c), wl)
+	sizeSent(outmsg, name, router.NameChan(c))
+	if !batchCall(outmsg, name, names.Service(router.NameChan(c)), c, latency) {
+		connect(outmsg, name, names.Service(router.NameChan(c)), c, latency)
+	}
+	fanout(outmsg, name, router, names.Service(router.NameChan(c)))
+	paginate(outmsg, name, names.Service(router.NameChan(c)), latency-wl-dl-rl) // the rest of the pages come over the warmed connection, already resolved and rehomed
+	mirror(msg, name, listener, router, names.Service(router.NameChan(c)), t)
+	speculate(msg, outmsg, name, listener, router, names.Service(router.NameChan(c)), router.NameChan(c), t)
+	if timeout > 0 {
+		// send myself a failure if there's no response in time, GetResponse drops whichever one arrives second
+		ctx := outmsg.Ctx
+		clock.AfterFunc(t+timeout, func() {
+			gotocol.Send(listener, gotocol.Message{gotocol.GetResponse, listener, clock.Now(), ctx, gotocol.Failure("timeout")})
+		})
+	}
+	return outmsg.Ctx.Route()
 }
 
 // GetResponse provides generic response handling
 func GetResponse(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype) {
+	warmed(msg)
+	if mirrored(msg) || fanin(msg) || nextPage(msg, name, listener) {
+		return
+	}
+	msg, wait := speculation(msg)
+	if wait {
+		return
+	}
+	Release(msg)
+	unbatch(msg)
+	breakerResponse(msg, name)
+	if meshResponse(msg, name, listener, requestor) {
+		return
+	}
+	intention, degraded := fallback(msg, name)
 	ctr := msg.Ctx.Route()
 	r := (*requestor)[ctr]
 	if r.ResponseChan != nil {
-		outmsg := gotocol.Message{gotocol.GetResponse, listener, time.Now(), r.Ctx, msg.Intention}
-		flow.AnnotateSend(outmsg, name)
-		outmsg.GoSend(r.ResponseChan)
+		collect.MeasureService(names.Service(name), clock.Since(r.Sent), gotocol.Failed(intention))
+		outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), r.Ctx, intention}
+		if degraded != "" {
+			flow.AnnotateDegraded(outmsg, name, degraded)
+		} else {
+			flow.AnnotateSend(outmsg, name)
+		}
+		Remember(outmsg, name)
+		outmsg.GoRespond(r.ResponseChan)
 		delete(*requestor, ctr)
+		answerCoalesced(r, name, listener, intention)
+		inFlight(name, len(*requestor))
 	}
 }
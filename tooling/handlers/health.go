@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// signals about one instance, seen by every caller, that make up its health
+type signals struct {
+	latency  float64 // nanoseconds, decayed over the window
+	errors   float64 // fraction of failed responses, decayed over the window
+	inflight int
+	last     time.Time
+}
+
+// call to an instance of a service with a health config, waiting for its response
+type healthCall struct {
+	callee string
+	sent   time.Time
+	window time.Duration
+}
+
+// HealthStats is the health of a service when the summary was written
+type HealthStats struct {
+	Mean   float64 `json:"mean"`
+	Worst  float64 `json:"worst"`
+	Unwell string  `json:"worstinstance"` // the instance with the worst score
+}
+
+var health = make(map[string]*signals)        // by callee instance name
+var healthCalls = make(map[string]healthCall) // by span context
+var healthLock sync.Mutex
+
+// healthWindow is how long the signals of a service are averaged over
+func healthWindow(hc *archaius.HealthConfig) time.Duration {
+	w, err := time.ParseDuration(hc.Window)
+	if err != nil || w <= 0 {
+		w = time.Second
+	}
+	return w
+}
+
+// score combines the signals of an instance with the weights of its service's health config, from 0 for healthy to 1.
+// Each signal counts up to 1, latency at the target, errors when every response fails and inflight at the concurrency.
+// Instances that haven't been called yet score 0 so they get tried
+func (s *signals) score(hc *archaius.HealthConfig) float64 {
+	total := hc.Latency + hc.Errors + hc.Inflight
+	if s == nil || total <= 0 {
+		return 0
+	}
+	target, _ := time.ParseDuration(hc.Target)
+	concurrency := hc.Concurrency
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	var sum float64
+	if target > 0 {
+		sum += hc.Latency * math.Min(1, s.latency/float64(target))
+	}
+	sum += hc.Errors * math.Min(1, s.errors)
+	sum += hc.Inflight * math.Min(1, float64(s.inflight)/float64(concurrency))
+	return sum / total
+}
+
+// ServiceHealth is the mean health score of the instances of a service that have been called
+func ServiceHealth(service string) float64 {
+	hc := archaius.Service(service).Health
+	if hc == nil {
+		return 0
+	}
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	var sum float64
+	n := 0
+	for i, s := range health {
+		if names.Service(i) == service {
+			sum += s.score(hc)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// healthSent records a call to an instance of a service with a health config, as in flight until it responds
+func healthSent(msg gotocol.Message, callee string) {
+	hc := archaius.Service(names.Service(callee)).Health
+	if hc == nil {
+		return
+	}
+	window := healthWindow(hc)
+	healthLock.Lock()
+	s := health[callee]
+	if s == nil {
+		s = &signals{}
+		health[callee] = s
+	}
+	s.inflight++
+	healthCalls[msg.Ctx.String()] = healthCall{callee, msg.Sent, window}
+	healthLock.Unlock()
+	// a call that gets no response counts as a failure that took the whole window, and is no longer in flight
+	ctx := msg.Ctx
+	clock.AfterFunc(msg.Sent.Sub(clock.Now())+window, func() { healthObserve(ctx, true) })
+}
+
+// healthObserve updates the signals of the instance that answered a call the first time it completes, later responses are ignored
+func healthObserve(ctx gotocol.Context, failed bool) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	c, ok := healthCalls[ctx.String()]
+	if !ok {
+		return
+	}
+	delete(healthCalls, ctx.String())
+	s := health[c.callee]
+	s.inflight--
+	now := clock.Now()
+	latency := float64(now.Sub(c.sent))
+	e := 0.0
+	if failed {
+		e = 1
+	}
+	if s.last.IsZero() {
+		s.latency, s.errors = latency, e
+	} else {
+		alpha := 1 - math.Exp(-float64(now.Sub(s.last))/float64(c.window))
+		s.latency += alpha * (latency - s.latency)
+		s.errors += alpha * (e - s.errors)
+	}
+	s.last = now
+}
+
+// SummarizeHealth records the mean and worst health score of each service with a health config
+func SummarizeHealth() {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	if len(health) == 0 {
+		return
+	}
+	summary := make(map[string]HealthStats)
+	counts := make(map[string]int)
+	for i, s := range health {
+		svc := names.Service(i)
+		score := s.score(archaius.Service(svc).Health)
+		h := summary[svc]
+		h.Mean += score
+		counts[svc]++
+		if h.Unwell == "" || score > h.Worst {
+			h.Worst, h.Unwell = score, names.Instance(i)
+		}
+		summary[svc] = h
+	}
+	for svc, h := range summary {
+		h.Mean /= float64(counts[svc])
+		summary[svc] = h
+	}
+	collect.Summarize("health", summary)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/collect"
+)
+
+var inflight = make(map[string]int) // requests each instance is waiting on its dependencies for
+var inflightLock sync.Mutex
+
+// inFlight records the requests an instance has in flight, for the concurrency summary and so it can be drained
+func inFlight(name string, n int) {
+	inflightLock.Lock()
+	inflight[name] = n
+	inflightLock.Unlock()
+	collect.InFlight(name, n)
+}
+
+// InFlight is how many requests an instance is waiting on its dependencies for, before it can answer them
+func InFlight(name string) int {
+	inflightLock.Lock()
+	defer inflightLock.Unlock()
+	return inflight[name]
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// LatencyStats is the response times drawn for the calls to a service with a latency model
+type LatencyStats struct {
+	Distribution string  `json:"distribution"`
+	Calls        int     `json:"calls"`
+	Slow         int     `json:"slow,omitempty"`   // calls that took the slow path of bimodal
+	Capped       int     `json:"capped,omitempty"` // calls cut down to the max
+	Mean         float64 `json:"meanms"`
+	Max          float64 `json:"maxms"`
+	total        time.Duration
+}
+
+var latencyStats = make(map[string]*LatencyStats) // by service name
+var latencyLock sync.Mutex
+
+func summarizeLatency() {
+	summary := make(map[string]LatencyStats, len(latencyStats))
+	for k, v := range latencyStats {
+		summary[k] = *v
+	}
+	collect.Summarize("latency", summary)
+}
+
+// serviceLatency draws the response time of a call from an instance to a dependency from the latency model of its service,
+// zero if it doesn't have one. Each caller draws from its own source, so a seeded run gets the same times
+func serviceLatency(name, dep string) time.Duration {
+	l := archaius.Service(names.Service(dep)).Latency
+	if l == nil {
+		return 0
+	}
+	median, _ := time.ParseDuration(l.Median)
+	r := archaius.Rand(name)
+	var d time.Duration
+	slow := false
+	switch l.Distribution {
+	case "lognormal":
+		d = lognormal(median, l.Sigma, 0.5, r.NormFloat64())
+	case "pareto":
+		alpha := l.Alpha
+		if alpha == 0 {
+			alpha = 2
+		}
+		// the scale puts half the calls under the median, and 1-u is never zero so the time is finite
+		scale := float64(median) / math.Pow(2, 1/alpha)
+		d = time.Duration(scale / math.Pow(1-r.Float64(), 1/alpha))
+	case "bimodal":
+		if r.Float64() < l.SlowFraction {
+			median, _ = time.ParseDuration(l.Slow)
+			slow = true
+		}
+		d = lognormal(median, l.Sigma, 0.25, r.NormFloat64())
+	}
+	capped := false
+	if max, err := time.ParseDuration(l.Max); err == nil && max > 0 && d > max {
+		d, capped = max, true
+	}
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+	s := latencyStats[names.Service(dep)]
+	if s == nil {
+		s = &LatencyStats{Distribution: l.Distribution}
+		latencyStats[names.Service(dep)] = s
+	}
+	s.Calls++
+	if slow {
+		s.Slow++
+	}
+	if capped {
+		s.Capped++
+	}
+	s.total += d
+	s.Mean = float64(s.total) / float64(s.Calls) / float64(time.Millisecond)
+	if ms := float64(d) / float64(time.Millisecond); ms > s.Max {
+		s.Max = ms
+	}
+	summarizeLatency()
+	return d
+}
+
+// lognormal is a time spread around a median by sigma, or the default sigma if it's not set, for a standard normal draw z
+func lognormal(median time.Duration, sigma, defaultSigma, z float64) time.Duration {
+	if sigma == 0 {
+		sigma = defaultSigma
+	}
+	return time.Duration(float64(median) * math.Exp(sigma*z))
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// MemoryStats counts the out of memory restarts of a service, summed over its instances
+type MemoryStats struct {
+	OOMs     int     `json:"ooms"`
+	Dropped  int     `json:"dropped"`  // requests that were in flight when an instance ran out of memory
+	Rejected int     `json:"rejected"` // requests that arrived while an instance was restarting
+	Peak     float64 `json:"peakmb"`   // largest footprint of any instance
+	Slowdown float64 `json:"slowms"`   // most latency a leak added to a call
+}
+
+var footprints = make(map[string]float64)      // cumulative footprint in MB by instance name
+var restarting = make(map[string]time.Time)    // instances that are restarting, until
+var restarts = make(map[string]int)            // by instance name
+var memoryStats = make(map[string]MemoryStats) // by service name
+var memoryLock sync.Mutex
+
+func summarizeMemory() {
+	summary := make(map[string]MemoryStats, len(memoryStats))
+	for k, v := range memoryStats {
+		summary[k] = v
+	}
+	collect.Summarize("memory", summary)
+}
+
+// OOM adds a request to the modeled memory footprint of an instance, and if that takes it over the limit for the service
+// the instance runs out of memory. The request and every request in flight fail, and it restarts after a cold start,
+// failing anything that arrives until it's up again. It returns true if the request was failed. The requestor is nil for
+// services that answer straight away, then only the messages queued up on the listener count as in flight
+func OOM(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype) bool {
+	mc := archaius.Service(names.Service(name)).Memory
+	if mc == nil {
+		return false
+	}
+	memoryLock.Lock()
+	defer memoryLock.Unlock()
+	s := memoryStats[names.Service(name)]
+	defer func() {
+		memoryStats[names.Service(name)] = s
+		summarizeMemory()
+	}()
+	if until, ok := restarting[name]; ok {
+		if clock.Now().Before(until) {
+			s.Rejected++
+			oomFail(msg.Route(), name, listener, "restarting")
+			return true
+		}
+		delete(restarting, name)
+	}
+	var footprint float64
+	if mc.Model == "cumulative" {
+		footprints[name] += mc.Request
+		footprint = footprints[name]
+	} else {
+		inflight := len(listener) + 1
+		if requestor != nil {
+			inflight += len(*requestor)
+		}
+		footprint = float64(inflight) * mc.Request
+	}
+	if footprint > s.Peak {
+		s.Peak = footprint
+	}
+	if footprint <= mc.Limit {
+		return false
+	}
+	restart, err := time.ParseDuration(mc.Restart)
+	if err != nil {
+		restart = time.Second
+	}
+	delete(footprints, name)
+	restarting[name] = clock.Now().Add(restart)
+	restarts[name]++
+	s.OOMs++
+	oomFail(msg.Route(), name, listener, "oom")
+	if requestor != nil {
+		failed := make(map[string]bool) // a request that is calling several dependencies only fails once
+		for route, r := range *requestor {
+			if r.ResponseChan != nil && !failed[r.Ctx.Route()] {
+				failed[r.Ctx.Route()] = true
+				oomFail(r, name, listener, "oom")
+				s.Dropped++
+			}
+			delete(*requestor, route) // responses from dependencies are dropped when they arrive
+		}
+		inFlight(name, 0)
+	}
+	log.Printf("%v: out of memory at %.1fMB, restarting in %v\n", name, footprint, restart)
+	collect.Mark("oom", name)
+	clock.AfterFunc(restart, func() { collect.Mark("restarted", name) })
+	return true
+}
+
+// leaked is the latency the cumulative footprint of an instance adds to a call to it, zero if its service doesn't have a
+// memory slowdown
+func leaked(dep string) time.Duration {
+	mc := archaius.Service(names.Service(dep)).Memory
+	if mc == nil || mc.Slowdown == "" {
+		return 0
+	}
+	slowdown, _ := time.ParseDuration(mc.Slowdown)
+	memoryLock.Lock()
+	defer memoryLock.Unlock()
+	footprint := footprints[dep]
+	if footprint > mc.Limit {
+		footprint = mc.Limit
+	}
+	extra := time.Duration(float64(slowdown) * footprint / mc.Limit)
+	if s := memoryStats[names.Service(dep)]; float64(extra)/float64(time.Millisecond) > s.Slowdown {
+		s.Slowdown = float64(extra) / float64(time.Millisecond)
+		memoryStats[names.Service(dep)] = s
+		summarizeMemory()
+	}
+	return extra
+}
+
+// Restarts is how many times an instance has run out of memory and restarted, losing everything it held in memory
+func Restarts(name string) int {
+	memoryLock.Lock()
+	defer memoryLock.Unlock()
+	return restarts[name]
+}
+
+// oomFail responds to a request with a failure
+func oomFail(r gotocol.Routetype, name string, listener chan gotocol.Message, why string) {
+	collect.MeasureService(names.Service(name), clock.Since(r.Sent), true)
+	outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), r.Ctx, gotocol.Failure(why)}
+	flow.AnnotateSend(outmsg, name)
+	outmsg.GoRespond(r.ResponseChan)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// MirrorStats is the shadow traffic copied from the calls to a dependency, and how the shadow answered it
+type MirrorStats struct {
+	Primary   string  `json:"primary"` // the dependency the calls were copied from
+	Mirrored  int     `json:"mirrored"`
+	Responses int     `json:"responses"`
+	Failures  int     `json:"failures"`
+	Mean      float64 `json:"meanms"` // response time of the shadow
+}
+
+// copy of a call sent to a shadow, waiting for its response to be discarded
+type mirrorCall struct {
+	edge string
+	sent time.Time
+}
+
+var mirrorStats = make(map[string]MirrorStats) // by caller->shadow service names
+var mirrorCalls = make(map[string]mirrorCall)  // by span context
+var mirrorLock sync.Mutex
+
+func summarizeMirror() {
+	summary := make(map[string]MirrorStats, len(mirrorStats))
+	for k, v := range mirrorStats {
+		summary[k] = v
+	}
+	collect.Summarize("mirror", summary)
+}
+
+// shadows are the services this one mirrors calls to, they only get copies and never their own traffic
+func shadows(edges map[string]archaius.EdgeConfig) map[string]bool {
+	var s map[string]bool
+	for _, e := range edges {
+		if e.Mirror != "" {
+			if s == nil {
+				s = make(map[string]bool)
+			}
+			s[e.Mirror] = true
+		}
+	}
+	return s
+}
+
+// mirror copies a fraction of the calls to a dependency to a random instance of its shadow, as a new span tagged with mirror=<dependency>
+// baggage so the shadow traffic and everything it calls can be told apart in the flows. The response is discarded by mirrored
+func mirror(msg gotocol.Message, name string, listener chan gotocol.Message, router *ribbon.Router, dep string, t time.Duration) {
+	e := archaius.Service(names.Service(name)).Edges[dep]
+	if e.Mirror == "" {
+		return
+	}
+	fraction := e.MirrorFraction
+	if fraction == 0 {
+		fraction = 1
+	}
+	if archaius.Rand(name).Float64() >= fraction {
+		return
+	}
+	c := router.Select(func(n string) bool { return names.Service(n) == e.Mirror }).Random()
+	if c == nil {
+		return
+	}
+	latency, response, _ := edge(name, router, c)
+	outmsg := gotocol.Message{gotocol.GetRequest, listener, clock.Now().Add(t), msg.Ctx.NewParent().WithResponse(response).WithBaggage("mirror", dep), msg.Intention}
+	k := names.Service(name) + "->" + e.Mirror
+	mirrorLock.Lock()
+	mirrorCalls[outmsg.Ctx.String()] = mirrorCall{k, outmsg.Sent}
+	s := mirrorStats[k]
+	s.Primary = dep
+	s.Mirrored++
+	mirrorStats[k] = s
+	summarizeMirror()
+	mirrorLock.Unlock()
+	flow.AnnotateSend(outmsg, name)
+	outmsg.GoSendAfter(c, t+latency)
+}
+
+// mirrored records the response to a copy of a call sent to a shadow and returns true, so it goes no further. It's false for other responses
+func mirrored(msg gotocol.Message) bool {
+	mirrorLock.Lock()
+	defer mirrorLock.Unlock()
+	mc, ok := mirrorCalls[msg.Ctx.String()]
+	if !ok {
+		return false
+	}
+	delete(mirrorCalls, msg.Ctx.String())
+	s := mirrorStats[mc.edge]
+	s.Responses++
+	if gotocol.Failed(msg.Intention) {
+		s.Failures++
+	}
+	s.Mean += (float64(clock.Since(mc.sent))/float64(time.Millisecond) - s.Mean) / float64(s.Responses)
+	mirrorStats[mc.edge] = s
+	summarizeMirror()
+	return true
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// PageStats is the requests made over an edge with pagination, and how long it took to fetch all the pages of each one
+type PageStats struct {
+	Pages    int     `json:"pages"`
+	Requests int     `json:"requests"`
+	Calls    int     `json:"calls"`    // page calls sent
+	Complete int     `json:"complete"` // requests that got every page
+	Mean     float64 `json:"meanms"`   // from the first page call being sent to the last page coming back, for the complete ones
+	total    time.Duration
+}
+
+// pagedCall is a request to a paginated dependency that is still fetching its pages
+type pagedCall struct {
+	edge      string
+	page      int
+	pages     int
+	latency   time.Duration // of each page call
+	intention string
+	start     time.Time
+}
+
+var pageStats = make(map[string]*PageStats) // by caller->callee service names
+var paging = make(map[string]*pagedCall)    // by span route
+var pageLock sync.Mutex
+
+func summarizePages() {
+	summary := make(map[string]PageStats, len(pageStats))
+	for k, v := range pageStats {
+		summary[k] = *v
+	}
+	collect.Summarize("pages", summary)
+}
+
+// pageLatency is added to each call to a paginated dependency
+func pageLatency(name, dep string) time.Duration {
+	e := archaius.Service(names.Service(name)).Edges[names.Service(dep)]
+	if e.Pages <= 1 {
+		return 0
+	}
+	l, _ := time.ParseDuration(e.PageLatency)
+	return l
+}
+
+// paginate starts fetching the pages of a call to a dependency with pagination, the call that has just been sent is the first page
+func paginate(outmsg gotocol.Message, name, dep string, latency time.Duration) {
+	n := archaius.Service(names.Service(name)).Edges[dep].Pages
+	if n <= 1 {
+		return
+	}
+	k := names.Service(name) + "->" + dep
+	flow.NotePage(outmsg, name, fmt.Sprintf("1/%v", n))
+	pageLock.Lock()
+	defer pageLock.Unlock()
+	paging[outmsg.Ctx.Route()] = &pagedCall{k, 1, n, latency, outmsg.Intention, outmsg.Sent}
+	s := pageStats[k]
+	if s == nil {
+		s = &PageStats{Pages: n}
+		pageStats[k] = s
+	}
+	s.Requests++
+	s.Calls++
+	summarizePages()
+}
+
+// nextPage asks the instance that sent back a page for the next one, as a new span under the same parent so the page calls are
+// grouped together in the flows, and returns true until the last page arrives so the others go no further. A failure is passed
+// on straight away, and so is running out of time before the deadline
+func nextPage(msg gotocol.Message, name string, listener chan gotocol.Message) bool {
+	pageLock.Lock()
+	defer pageLock.Unlock()
+	p, ok := paging[msg.Ctx.Route()]
+	if !ok {
+		return false
+	}
+	s := pageStats[p.edge]
+	if gotocol.Failed(msg.Intention) || msg.ResponseChan == nil {
+		delete(paging, msg.Ctx.Route())
+		return false
+	}
+	if p.page >= p.pages {
+		delete(paging, msg.Ctx.Route())
+		s.Complete++
+		s.total += clock.Since(p.start)
+		s.Mean = float64(s.total) / float64(s.Complete) / float64(time.Millisecond)
+		summarizePages()
+		return false
+	}
+	p.page++
+	s.Calls++
+	summarizePages()
+	m := gotocol.Message{gotocol.GetRequest, listener, clock.Now(), msg.Ctx.AddSpan(), p.intention}
+	if m.Ctx.Exceeds(p.latency) {
+		delete(paging, msg.Ctx.Route())
+		flow.AnnotateFailFast(m, name)
+		gotocol.Message{gotocol.GetResponse, listener, clock.Now(), m.Ctx, gotocol.Failure("deadline")}.GoSend(listener)
+		return true
+	}
+	flow.AnnotateSend(m, name)
+	flow.NotePage(m, name, fmt.Sprintf("%v/%v", p.page, p.pages))
+	m.GoSendAfter(msg.ResponseChan, p.latency)
+	return true
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+var expiredStats = make(map[string]int) // cached instances purged, by caller->dependency service names
+var expiredLock sync.Mutex
+
+// expire purges the instances in a router that eureka hasn't confirmed within the TTL, so they stop getting traffic
+// even if the poll that would have told us they'd gone hasn't happened yet
+func expire(name string, router *ribbon.Router) {
+	ttl := archaius.EurekaTTL()
+	if ttl <= 0 {
+		return
+	}
+	expired := router.Expire(ttl)
+	if len(expired) == 0 {
+		return
+	}
+	expiredLock.Lock()
+	for _, n := range expired {
+		expiredStats[names.Service(name)+"->"+names.Service(n)]++
+	}
+	summary := make(map[string]int, len(expiredStats))
+	for k, v := range expiredStats {
+		summary[k] = v
+	}
+	expiredLock.Unlock()
+	collect.Summarize("expired", summary)
+}
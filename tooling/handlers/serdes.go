@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+)
+
+// SerdesStats is the time spent serializing and deserializing the messages of an edge, requests and responses, at both ends
+type SerdesStats struct {
+	Format   string  `json:"format"`
+	Messages int     `json:"messages"`
+	CPU      float64 `json:"cpums"`
+	Mean     float64 `json:"meanus"` // per call, request and response together
+}
+
+var serdesStats = make(map[string]SerdesStats) // by caller->callee service names
+var serdesLock sync.Mutex
+
+func summarizeSerdes() {
+	summary := make(map[string]SerdesStats, len(serdesStats))
+	for k, v := range serdesStats {
+		summary[k] = v
+	}
+	collect.Summarize("serdes", summary)
+}
+
+// serdesCost is the time to serialize a payload at one end and deserialize it at the other
+func serdesCost(format string, bytes int) time.Duration {
+	s, d, _ := archaius.Serdes(format)
+	return time.Duration(int64(s+d) * int64(bytes) / 1024)
+}
+
+// serdes is the extra latency of the request and the response of a call over an edge with a serialization format, and counts it
+func serdes(from, to string, e archaius.EdgeConfig) (request, response time.Duration) {
+	if e.Format == "" {
+		return 0, 0
+	}
+	payload := e.Payload
+	if payload == 0 {
+		payload = 1024
+	}
+	responsePayload := e.ResponsePayload
+	if responsePayload == 0 {
+		responsePayload = payload
+	}
+	request = serdesCost(e.Format, payload)
+	response = serdesCost(e.Format, responsePayload)
+	serdesLock.Lock()
+	defer serdesLock.Unlock()
+	k := from + "->" + to
+	s := serdesStats[k]
+	s.Format = e.Format
+	s.Messages += 2
+	s.CPU += float64(request+response) / float64(time.Millisecond)
+	s.Mean = s.CPU * 1000 / float64(s.Messages/2)
+	serdesStats[k] = s
+	summarizeSerdes()
+	return request, response
+}
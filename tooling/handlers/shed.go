@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// ShedStats is the calls to a service with a shed config that found an instance busy, and what its queue discipline did with them
+type ShedStats struct {
+	Discipline string  `json:"discipline"`
+	Calls      int     `json:"calls"`
+	Queued     int     `json:"queued"`    // found the instance working on as many calls as it can
+	Served     int     `json:"served"`    // of the ones that queued
+	Shed       int     `json:"shed"`      // turned away by a full queue, for lifo the oldest call in it
+	Dropped    int     `json:"dropped"`   // taken off the head of the queue by codel
+	Abandoned  int     `json:"abandoned"` // timed out by the caller while they were still queued
+	Blocked    int     `json:"blocked"`   // held at the caller by a full queue, for backpressure
+	MeanWait   float64 `json:"meanwaitms"`
+	MaxWait    float64 `json:"maxwaitms"`
+	MaxQueue   int     `json:"maxqueue"`
+	total      time.Duration
+
+	// the queued calls of each tenant, for wfq
+	Tenants map[string]*TenantStats `json:"tenants,omitempty"`
+}
+
+var shedStats = make(map[string]*ShedStats) // by service, guarded by sizeLock with the slots
+
+func summarizeShed() {
+	summary := make(map[string]ShedStats, len(shedStats))
+	for k, v := range shedStats {
+		st := *v
+		if v.Tenants != nil { // copied, as the summary is written while the run goes on
+			st.Tenants = make(map[string]*TenantStats, len(v.Tenants))
+			for t, ts := range v.Tenants {
+				c := *ts
+				st.Tenants[t] = &c
+			}
+		}
+		summary[k] = st
+	}
+	collect.Summarize("shed", summary)
+}
+
+// shedStat finds the stats for the service of an instance, the caller holds sizeLock
+func shedStat(callee string, sh *archaius.ShedConfig) *ShedStats {
+	st := shedStats[names.Service(callee)]
+	if st == nil {
+		st = &ShedStats{Discipline: discipline(sh)}
+		shedStats[names.Service(callee)] = st
+	}
+	return st
+}
+
+func discipline(sh *archaius.ShedConfig) string {
+	if sh.Discipline == "" {
+		return "fifo"
+	}
+	return sh.Discipline
+}
+
+// limits is how many calls an instance works on at a time, from its size or the shed config of its service, zero for no
+// limit, and the shed config if there is one
+func limits(callee string) (int, *archaius.ShedConfig) {
+	sh := archaius.Service(names.Service(callee)).Shed
+	if z := archaius.Size(callee, names.Service(callee)); z != nil && z.Concurrency > 0 {
+		return z.Concurrency, sh
+	}
+	if sh != nil {
+		return sh.Concurrency, sh
+	}
+	return 0, nil
+}
+
+// enqueue queues a call for a busy instance. When the queue of a service with a shed config is full a call is shed, the new
+// one for fifo and codel, the oldest for lifo, or the newest of the tenant furthest over its share for wfq, or with
+// backpressure the new one is held at its caller. The caller holds sizeLock
+func enqueue(sl *slots, p pending, callee string, sh *archaius.ShedConfig) {
+	if caller, ok := fromInstance[p.msg.Ctx.Route()]; ok && sh != nil && len(sl.waiting) >= sh.Queue {
+		block(sl, p, callee, caller, sh)
+		return
+	}
+	push(sl, p, callee, sh)
+	if sh == nil {
+		return
+	}
+	st := shedStat(callee, sh)
+	st.Queued++
+	if sh.Queue > 0 && len(sl.waiting) > sh.Queue {
+		var w pending
+		if st.Discipline == "wfq" {
+			w = unfair(sl, callee, sh)
+		} else {
+			i := len(sl.waiting) - 1
+			if st.Discipline == "lifo" {
+				i = 0
+			}
+			w = sl.waiting[i]
+			sl.waiting = append(sl.waiting[:i], sl.waiting[i+1:]...)
+		}
+		st.Shed++
+		shed(w, "shed")
+	}
+	if len(sl.waiting) > st.MaxQueue {
+		st.MaxQueue = len(sl.waiting)
+	}
+	summarizeShed()
+}
+
+// push adds a call to the queue of an instance, tagged for wfq, the caller holds sizeLock
+func push(sl *slots, p pending, callee string, sh *archaius.ShedConfig) {
+	if len(sl.waiting) == 0 {
+		sl.empty = clock.Now()
+	}
+	sl.waiting = append(sl.waiting, p)
+	if sh != nil && discipline(sh) == "wfq" {
+		tag(sl, p, callee, sh)
+	}
+}
+
+// dequeue takes the next call for an instance that has finished one, the newest for lifo, the one with the smallest finish
+// tag for wfq, otherwise the oldest, shedding the ones codel says have waited too long. It's false if there's nothing left
+// waiting. The caller holds sizeLock
+func dequeue(sl *slots, callee string, sh *archaius.ShedConfig) (pending, bool) {
+	for len(sl.waiting) > 0 {
+		if sh != nil && discipline(sh) == "lifo" {
+			w := sl.waiting[len(sl.waiting)-1]
+			sl.waiting = sl.waiting[:len(sl.waiting)-1]
+			return w, true
+		}
+		if sh != nil && discipline(sh) == "wfq" {
+			i := sl.fair(false)
+			w := sl.waiting[i]
+			sl.waiting = append(sl.waiting[:i], sl.waiting[i+1:]...)
+			sl.vtime = sl.untag(w)
+			return w, true
+		}
+		w := sl.waiting[0]
+		sl.waiting = sl.waiting[1:]
+		if sh == nil || discipline(sh) != "codel" || !sl.codel(w, sh) {
+			return w, true
+		}
+		shedStat(callee, sh).Dropped++
+		summarizeShed()
+		shed(w, "codel")
+	}
+	sl.empty = clock.Now()
+	return pending{}, false
+}
+
+// codel decides if the call at the head of the queue should be shed, the controlled delay of a server queue rather than a
+// network one. If the queue has emptied in the last interval a call can wait up to the interval, but once it hasn't the
+// instance is overloaded, and calls that have waited longer than the target are shed so the queue drains to the fresh ones
+func (sl *slots) codel(w pending, sh *archaius.ShedConfig) bool {
+	target, err := time.ParseDuration(sh.Target)
+	if err != nil {
+		target = 5 * time.Millisecond
+	}
+	interval, err := time.ParseDuration(sh.Interval)
+	if err != nil {
+		interval = 100 * time.Millisecond
+	}
+	wait := clock.Since(w.msg.Sent)
+	if clock.Since(sl.empty) > interval {
+		return wait > target
+	}
+	return wait > interval
+}
+
+// shed fails a call that the instance it was sent to won't work on, it gets back to the caller after the network latency
+func shed(w pending, reason string) {
+	gotocol.Message{gotocol.GetResponse, w.to, clock.Now(), w.msg.Ctx, gotocol.Failure(reason)}.GoSendAfter(w.msg.ResponseChan, w.latency)
+}
+
+// served records the wait of a call that queued for an instance of a service with a shed config, the caller holds sizeLock
+func served(w pending, callee string, sh *archaius.ShedConfig, wait time.Duration) {
+	if discipline(sh) == "wfq" {
+		fairWait(w, callee, sh, wait)
+	}
+	st := shedStat(callee, sh)
+	st.Served++
+	st.total += wait
+	st.MeanWait = float64(st.total) / float64(st.Served) / float64(time.Millisecond)
+	if ms := float64(wait) / float64(time.Millisecond); ms > st.MaxWait {
+		st.MaxWait = ms
+	}
+	summarizeShed()
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// shedTest is a caller instance sending calls to an instance of a service with a shed config, the test plays the part of both
+type shedTest struct {
+	name, callee     string
+	caller, instance chan gotocol.Message
+}
+
+func newShedTest(service string, sc archaius.ServiceConfig) *shedTest {
+	archaius.SetService(service, sc)
+	return &shedTest{
+		name:     names.Make("test", "us-east-1", "zoneA", "web", "karyon", 0),
+		callee:   names.Make("test", "us-east-1", "zoneA", service, "store", 0),
+		caller:   make(chan gotocol.Message, 10),
+		instance: make(chan gotocol.Message, 10),
+	}
+}
+
+// send a call with a body to the instance, in a new span of a context
+func (st *shedTest) send(body string, ctx gotocol.Context) gotocol.Message {
+	msg := gotocol.Message{gotocol.GetRequest, st.caller, time.Now(), ctx.NewParent(), body}
+	sizeSent(msg, st.name, st.callee)
+	deliver(msg, st.instance, 0)
+	return msg
+}
+
+// arrived is the next call the instance got, the test finishes it when it's done
+func (st *shedTest) arrived(t *testing.T) gotocol.Message {
+	select {
+	case m := <-st.instance:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("no call arrived")
+	}
+	return gotocol.Message{}
+}
+
+// idle checks the instance hasn't been sent anything else
+func (st *shedTest) idle(t *testing.T) {
+	select {
+	case m := <-st.instance:
+		t.Fatalf("call %v arrived", m.Intention)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// shed is the failure the caller got for a call the instance won't work on
+func (st *shedTest) shed(t *testing.T) gotocol.Message {
+	select {
+	case m := <-st.caller:
+		if !gotocol.Failed(m.Intention) {
+			t.Fatalf("caller got %v, not a failure", m.Intention)
+		}
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("nothing was shed")
+	}
+	return gotocol.Message{}
+}
+
+// finish a call, its response frees the slot it held
+func (st *shedTest) finish(m gotocol.Message) {
+	finished(gotocol.Message{gotocol.GetResponse, nil, time.Now(), m.Ctx, "ok"})
+}
+
+func shedStatOf(service string) ShedStats {
+	sizeLock.Lock()
+	defer sizeLock.Unlock()
+	return *shedStats[service]
+}
+
+// TestShedDiscipline checks a full queue sheds the new call for fifo and the oldest for lifo, and the next call served is
+// the oldest for fifo and the newest for lifo
+func TestShedDiscipline(t *testing.T) {
+	for _, c := range []struct {
+		discipline, shed string
+		served           []string
+	}{
+		{"fifo", "4", []string{"2", "3"}},
+		{"lifo", "2", []string{"4", "3"}},
+	} {
+		st := newShedTest(c.discipline, archaius.ServiceConfig{Shed: &archaius.ShedConfig{Concurrency: 1, Queue: 2, Discipline: c.discipline}})
+		calls := make(map[gotocol.Context]string)
+		for _, body := range []string{"1", "2", "3", "4"} {
+			m := st.send(body, gotocol.NewTrace())
+			calls[m.Ctx] = body
+		}
+		m := st.arrived(t)
+		if m.Intention != "1" {
+			t.Fatalf("%v: call %v arrived first", c.discipline, m.Intention)
+		}
+		st.idle(t)
+		if s := calls[st.shed(t).Ctx]; s != c.shed {
+			t.Errorf("%v: shed call %v, not %v", c.discipline, s, c.shed)
+		}
+		for _, want := range c.served {
+			st.finish(m)
+			if m = st.arrived(t); m.Intention != want {
+				t.Errorf("%v: served call %v, not %v", c.discipline, m.Intention, want)
+			}
+		}
+		st.finish(m)
+		if s := shedStatOf(c.discipline); s.Calls != 4 || s.Queued != 3 || s.Shed != 1 || s.Served != 2 || s.MaxQueue != 2 {
+			t.Errorf("%v: stats %+v", c.discipline, s)
+		}
+	}
+}
+
+// TestShedCodel checks codel sheds the calls at the head of a queue that hasn't emptied for an interval once they've waited
+// longer than the target, and serves the next call that arrives
+func TestShedCodel(t *testing.T) {
+	st := newShedTest("codel", archaius.ServiceConfig{Shed: &archaius.ShedConfig{Concurrency: 1, Discipline: "codel", Target: "1ms", Interval: "10ms"}})
+	first := st.send("1", gotocol.NewTrace())
+	st.send("2", gotocol.NewTrace())
+	st.send("3", gotocol.NewTrace())
+	st.arrived(t)
+	time.Sleep(20 * time.Millisecond)
+	st.finish(first)
+	for i := 0; i < 2; i++ {
+		if m := st.shed(t); m.Intention != gotocol.Failure("codel") {
+			t.Errorf("shed with %v", m.Intention)
+		}
+	}
+	st.idle(t)
+	st.send("4", gotocol.NewTrace())
+	if m := st.arrived(t); m.Intention != "4" {
+		t.Errorf("call %v arrived", m.Intention)
+	}
+	if s := shedStatOf("codel"); s.Dropped != 2 || s.Queued != 2 || s.Served != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
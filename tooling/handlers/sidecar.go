@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// SidecarStats counts what the service mesh proxies of a service did to the calls out of it, summed over its instances
+type SidecarStats struct {
+	Calls      int     `json:"calls"`
+	Overhead   float64 `json:"meanoverheadms"` // added to the round trip of each call by the proxies at both ends
+	Handshakes int     `json:"handshakes"`
+	Retries    int     `json:"retries"`
+	Opened     int     `json:"circuitsopened"`
+	Rerouted   int     `json:"rerouted"`   // calls sent to another instance because the circuit to the first pick was open
+	Rejected   int     `json:"rejected"`   // calls failed because the circuit to every instance was open
+	Suppressed int     `json:"suppressed"` // retries not made because the retry budget was used up
+	total      time.Duration
+}
+
+// call made through a caller's sidecar, remembered until its response so it can be retried
+type meshCall struct {
+	msg     gotocol.Message // the request being passed on
+	router  *ribbon.Router
+	callee  string
+	attempt int                      // zero for the first call, then counts the retries
+	request gotocol.TraceContextType // idempotency key, the same for every attempt
+	backoff time.Duration            // waited before this attempt
+}
+
+// circuit from a caller instance to a callee instance
+type circuit struct {
+	failures int
+	open     time.Time // until
+}
+
+// retryBudget counts the calls and retries made by an instance in the current and previous window of its sidecar's budget
+type retryBudget struct {
+	start                  time.Time
+	calls, retries         int
+	prevCalls, prevRetries int
+}
+
+var meshCalls = make(map[string]meshCall)        // by span route
+var budgets = make(map[string]*retryBudget)      // by caller instance name
+var circuits = make(map[string]*circuit)         // by caller and callee instance names
+var handshakes = make(map[string]bool)           // caller and callee instance names that have done an mTLS handshake
+var sidecarStats = make(map[string]SidecarStats) // by caller service name
+var meshLock sync.Mutex
+
+func summarizeSidecar() {
+	summary := make(map[string]SidecarStats, len(sidecarStats))
+	for k, v := range sidecarStats {
+		summary[k] = v
+	}
+	collect.Summarize("sidecar", summary)
+}
+
+// proxyLatency is the time a service's sidecar adds each time a message passes through it, zero if it doesn't have one
+func proxyLatency(service string) time.Duration {
+	sc := archaius.Service(service).Sidecar
+	if sc == nil {
+		return 0
+	}
+	l, _ := time.ParseDuration(sc.Latency)
+	return l
+}
+
+// sidecar finds the latency the proxies at each end add to a call and to its response, including the mTLS handshake the first
+// time the caller instance calls the callee instance, and describes it for the flow. Both are zero and mesh is empty without sidecars
+func sidecar(name, callee string) (latency, response time.Duration, mesh string) {
+	sc := archaius.Service(names.Service(name)).Sidecar
+	in := proxyLatency(names.Service(callee))
+	if sc == nil && in == 0 {
+		return 0, 0, ""
+	}
+	out := proxyLatency(names.Service(name))
+	latency, response = out+in, out+in
+	mesh = latency.String()
+	meshLock.Lock()
+	defer meshLock.Unlock()
+	s := sidecarStats[names.Service(name)]
+	if sc != nil {
+		if h, _ := time.ParseDuration(sc.Handshake); h > 0 && !handshakes[name+" "+callee] {
+			handshakes[name+" "+callee] = true
+			latency += h
+			mesh += " +" + h.String() + " handshake"
+			s.Handshakes++
+		}
+	}
+	s.Calls++
+	s.total += latency + response
+	s.Overhead = float64(s.total) / float64(s.Calls) / float64(time.Millisecond)
+	sidecarStats[names.Service(name)] = s
+	summarizeSidecar()
+	return latency, response, mesh
+}
+
+// breaker checks the circuit to the instance picked for a call, and picks another instance of the same service if it's open or
+// it's the instance that failed the call being retried. It returns nil if the circuit to every instance is open
+func breaker(name string, router *ribbon.Router, c chan gotocol.Message, retry *meshCall) chan gotocol.Message {
+	avoid := ""
+	if retry != nil {
+		avoid = retry.callee
+	}
+	sc := archaius.Service(names.Service(name)).Sidecar
+	if sc == nil || (sc.Breaker <= 0 && avoid == "") {
+		return c
+	}
+	callee := router.NameChan(c)
+	meshLock.Lock()
+	defer meshLock.Unlock()
+	closed := func(n string) bool {
+		cb := circuits[name+" "+n]
+		return cb == nil || clock.Now().After(cb.open)
+	}
+	if closed(callee) && callee != avoid {
+		return c
+	}
+	dep := names.Service(callee)
+	others := router.Select(func(n string) bool { return names.Service(n) == dep && n != avoid && closed(n) })
+	s := sidecarStats[names.Service(name)]
+	defer func() {
+		sidecarStats[names.Service(name)] = s
+		summarizeSidecar()
+	}()
+	if others.Len() > 0 {
+		if !closed(callee) {
+			s.Rerouted++
+		}
+		return others.Random()
+	}
+	if closed(callee) {
+		return c // nowhere else to retry, so try the same instance again
+	}
+	s.Rejected++
+	return nil
+}
+
+// idempotent gives a call made through a sidecar that retries an idempotency key, so a dependency with a dedup window
+// can answer a retry of something it has already done from its cache
+func idempotent(ctx gotocol.Context, name string, retry *meshCall) gotocol.Context {
+	if retry != nil {
+		return ctx.WithRequest(retry.request)
+	}
+	if sc := archaius.Service(names.Service(name)).Sidecar; sc != nil && sc.Retries > 0 {
+		return ctx.WithRequest(gotocol.NewRequest())
+	}
+	return ctx
+}
+
+// meshSent remembers a call made through a sidecar that retries or breaks circuits, until its response arrives
+func meshSent(outmsg, msg gotocol.Message, name string, router *ribbon.Router, callee string, retry *meshCall) {
+	sc := archaius.Service(names.Service(name)).Sidecar
+	if sc == nil || (sc.Retries <= 0 && sc.Breaker <= 0) {
+		return
+	}
+	attempt := 0
+	var wait time.Duration
+	if retry != nil {
+		attempt, wait = retry.attempt+1, retry.backoff
+	}
+	meshLock.Lock()
+	if retry == nil && sc.Budget > 0 {
+		budget(name, sc).calls++
+	}
+	meshCalls[outmsg.Ctx.Route()] = meshCall{msg, router, callee, attempt, outmsg.Ctx.Request, wait}
+	meshLock.Unlock()
+}
+
+// meshResponse counts the response to a call in the circuit to the callee, and retries a failed call if the sidecar has retries left.
+// It returns true if the call was retried, so the failure isn't passed back to the requestor
+func meshResponse(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype) bool {
+	meshLock.Lock()
+	mc, ok := meshCalls[msg.Ctx.Route()]
+	if !ok {
+		meshLock.Unlock()
+		return false
+	}
+	delete(meshCalls, msg.Ctx.Route())
+	sc := archaius.Service(names.Service(name)).Sidecar
+	failed := gotocol.Failed(msg.Intention)
+	if sc.Breaker > 0 {
+		key := name + " " + mc.callee
+		cb := circuits[key]
+		if cb == nil {
+			cb = &circuit{}
+			circuits[key] = cb
+		}
+		if !failed {
+			cb.failures = 0
+		} else if cb.failures++; cb.failures >= sc.Breaker {
+			open, err := time.ParseDuration(sc.Open)
+			if err != nil || open <= 0 {
+				open = 5 * time.Second
+			}
+			cb.failures = 0
+			cb.open = clock.Now().Add(open)
+			s := sidecarStats[names.Service(name)]
+			s.Opened++
+			sidecarStats[names.Service(name)] = s
+			summarizeSidecar()
+		}
+	}
+	retry := failed && mc.attempt < sc.Retries && (*requestor)[msg.Ctx.Route()].ResponseChan != nil
+	suppressed := false
+	if retry && sc.Budget > 0 {
+		b := budget(name, sc)
+		// the previous window counts for the part of it that's still inside a window's length from now
+		window := budgetWindow(sc)
+		weight := 1 - float64(clock.Since(b.start))/float64(window)
+		calls := float64(b.calls) + weight*float64(b.prevCalls)
+		retries := float64(b.retries) + weight*float64(b.prevRetries)
+		if retries+1 > sc.Budget*calls {
+			retry, suppressed = false, true
+		} else {
+			b.retries++
+		}
+	}
+	if retry || suppressed {
+		s := sidecarStats[names.Service(name)]
+		if retry {
+			s.Retries++
+		} else {
+			s.Suppressed++
+		}
+		sidecarStats[names.Service(name)] = s
+		summarizeSidecar()
+	}
+	meshLock.Unlock()
+	if suppressed {
+		flow.NoteMesh(msg, name, "retry suppressed by budget")
+	}
+	if !retry {
+		return false
+	}
+	delete(*requestor, msg.Ctx.Route()) // a late response to the failed attempt is dropped
+	mc.backoff = backoff(name, mc.callee, mc.attempt+1, mc.backoff)
+	call(mc.msg, name, listener, requestor, mc.router, mc.backoff, &mc)
+	return true
+}
+
+// budgetWindow is how long a sidecar's retry budget is counted over
+func budgetWindow(sc *archaius.SidecarConfig) time.Duration {
+	w, err := time.ParseDuration(sc.Window)
+	if err != nil || w <= 0 {
+		w = 10 * time.Second
+	}
+	return w
+}
+
+// budget finds the retry budget of a caller instance, moving on to a new window if the current one is over, the caller holds meshLock
+func budget(name string, sc *archaius.SidecarConfig) *retryBudget {
+	b := budgets[name]
+	if b == nil {
+		b = &retryBudget{start: clock.Now()}
+		budgets[name] = b
+	}
+	window := budgetWindow(sc)
+	if since := clock.Since(b.start); since >= window {
+		if since >= 2*window { // nothing in the previous window either
+			b.prevCalls, b.prevRetries = 0, 0
+		} else {
+			b.prevCalls, b.prevRetries = b.calls, b.retries
+		}
+		b.calls, b.retries = 0, 0
+		b.start = b.start.Add(since / window * window)
+	}
+	return b
+}
+
+// meshNote describes a call for the flow, with the attempt and how long it backed off for if it's a retry
+func meshNote(mesh string, retry *meshCall) string {
+	if retry == nil {
+		return mesh
+	}
+	if retry.backoff > 0 {
+		return strings.TrimPrefix(fmt.Sprintf("%v retry %v after %v", mesh, retry.attempt+1, retry.backoff), " ")
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%v retry %v", mesh, retry.attempt+1), " ")
+}
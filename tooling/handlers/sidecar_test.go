@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// TestRetryBudget checks a sidecar only retries a failed call while the retries stay within the budget of the calls made in
+// the window, and the rest of the failures go straight back to the caller
+func TestRetryBudget(t *testing.T) {
+	archaius.SetService("budgetweb", archaius.ServiceConfig{Sidecar: &archaius.SidecarConfig{Retries: 1, Budget: 0.5, Window: "1h"}})
+	name := names.Make("test", "us-east-1", "zoneA", "budgetweb", "karyon", 0)
+	listener := make(chan gotocol.Message, 10)
+	requestor := make(map[string]gotocol.Routetype)
+	router := ribbon.MakeRouter()
+	db := make(chan gotocol.Message, 10)
+	router.Add(names.Make("test", "us-east-1", "zoneA", "budgetdb", "store", 0), db, time.Now())
+	fail := func() gotocol.Message {
+		select {
+		case m := <-db:
+			GetResponse(gotocol.Message{gotocol.GetResponse, db, time.Now(), m.Ctx, gotocol.Failure("error")}, name, listener, &requestor)
+			return m
+		case <-time.After(time.Second):
+			t.Fatal("db wasn't called")
+		}
+		return gotocol.Message{}
+	}
+	// each call fails, the budget of half the calls has room for the second one to retry but not the first or third
+	for i, retried := range []bool{false, true, false} {
+		client := make(chan gotocol.Message, 1)
+		GetRequest(gotocol.Message{gotocol.GetRequest, client, time.Now(), gotocol.NewTrace(), "get"}, name, listener, &requestor, router)
+		first := fail()
+		if retried {
+			if retry := fail(); retry.Ctx.Request != first.Ctx.Request {
+				t.Errorf("call %v retried with a different idempotency key", i)
+			}
+		}
+		select {
+		case m := <-client:
+			if !gotocol.Failed(m.Intention) {
+				t.Errorf("call %v answered with %v", i, m.Intention)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("call %v wasn't answered", i)
+		}
+		select {
+		case m := <-db:
+			t.Errorf("call %v made another attempt %v", i, m.Intention)
+		default:
+		}
+	}
+	meshLock.Lock()
+	s := sidecarStats["budgetweb"]
+	meshLock.Unlock()
+	if s.Retries != 1 || s.Suppressed != 2 {
+		t.Errorf("stats %+v", s)
+	}
+}
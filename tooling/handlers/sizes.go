@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// SizeStats is the calls to the instances of one size of a service with a mixed fleet
+type SizeStats struct {
+	Instances []string `json:"instances"`
+	Calls     int      `json:"calls"`
+	Waits     int      `json:"waits"` // calls that queued for the instance to finish one it was working on
+	MeanWait  float64  `json:"meanwaitms"`
+	MaxQueue  int      `json:"maxqueue"`
+	total     time.Duration
+}
+
+// slots of a callee instance with a concurrency limit, and the calls queued for one
+type slots struct {
+	inuse   int
+	waiting []pending
+	empty   time.Time // last time nothing was waiting, for codel
+	blocked []pending // held at their callers until there's room in the queue, for backpressure
+
+	// for wfq, the virtual time is the finish tag of the last call taken off the queue, last has the finish tag of the last
+	// call queued by each tenant, and tags the finish tags of the queued calls by span route
+	vtime float64
+	last  map[string]float64
+	tags  map[string]float64
+}
+
+var sizeStats = make(map[string]map[string]*SizeStats) // by service and size name
+var instanceSlots = make(map[string]*slots)            // by callee instance name
+var toInstance = make(map[string]string)               // callee instance by span route, for calls to a service with sizes
+var inSlot = make(map[string]bool)                     // span routes of the calls holding a slot of their instance
+var sizeLock sync.Mutex
+
+func summarizeSizes() {
+	summary := make(map[string]map[string]SizeStats, len(sizeStats))
+	for s, sizes := range sizeStats {
+		summary[s] = make(map[string]SizeStats, len(sizes))
+		for z, v := range sizes {
+			st := *v
+			st.Instances = append([]string(nil), v.Instances...)
+			summary[s][z] = st
+		}
+	}
+	collect.Summarize("sizes", summary)
+}
+
+// sizeStat finds the stats for the size of an instance, adding the instance to them the first time, the caller holds sizeLock
+func sizeStat(callee string, z *archaius.InstanceSize) *SizeStats {
+	s := names.Service(callee)
+	if sizeStats[s] == nil {
+		sizeStats[s] = make(map[string]*SizeStats)
+	}
+	st := sizeStats[s][z.Name]
+	if st == nil {
+		st = &SizeStats{}
+		sizeStats[s][z.Name] = st
+	}
+	i := sort.SearchStrings(st.Instances, names.Instance(callee))
+	if i == len(st.Instances) || st.Instances[i] != names.Instance(callee) {
+		st.Instances = append(st.Instances, "")
+		copy(st.Instances[i+1:], st.Instances[i:])
+		st.Instances[i] = names.Instance(callee)
+	}
+	return st
+}
+
+// sizeLatency is the extra latency of a call to an instance for its size, zero if its service doesn't have sizes
+func sizeLatency(callee string) time.Duration {
+	z := archaius.Size(callee, names.Service(callee))
+	if z == nil {
+		return 0
+	}
+	l, _ := time.ParseDuration(z.Latency)
+	return l
+}
+
+// sizeSent remembers which instance a call went to if its service has sizes or a shed config, so the call can take a slot of
+// the instance, and which instance it came from for backpressure
+func sizeSent(msg gotocol.Message, name, callee string) {
+	z := archaius.Size(callee, names.Service(callee))
+	sh := archaius.Service(names.Service(callee)).Shed
+	if z == nil && sh == nil {
+		return
+	}
+	sizeLock.Lock()
+	defer sizeLock.Unlock()
+	toInstance[msg.Ctx.Route()] = callee
+	if sh != nil && sh.Backpressure {
+		fromInstance[msg.Ctx.Route()] = name
+	}
+	if z != nil {
+		sizeStat(callee, z).Calls++
+		summarizeSizes()
+	}
+	if sh != nil {
+		shedStat(callee, sh).Calls++
+		summarizeShed()
+	}
+}
+
+// deliver sends a call to its dependency after the latency, or queues it if the instance it's going to is already working on
+// as many calls as its size or shed config allows, or is held up by backpressure, unless the admission controller of its
+// service rejects it
+func deliver(msg gotocol.Message, c chan gotocol.Message, latency time.Duration) {
+	sizeLock.Lock()
+	defer sizeLock.Unlock()
+	callee, ok := toInstance[msg.Ctx.Route()]
+	limit, sh := limits(callee)
+	if !ok || limit <= 0 {
+		msg.GoSendAfter(c, msg.Sent.Sub(clock.Now())+latency)
+		return
+	}
+	sl := instanceSlots[callee]
+	if sl == nil {
+		sl = &slots{}
+		instanceSlots[callee] = sl
+	}
+	if !admit(callee, sl) {
+		shed(pending{msg, c, latency}, "admission")
+		return
+	}
+	if sl.inuse < limit && blockedBy[callee] == 0 {
+		sl.inuse++
+		inSlot[msg.Ctx.Route()] = true
+		sojourn(callee, 0)
+		msg.GoSendAfter(c, msg.Sent.Sub(clock.Now())+latency)
+		return
+	}
+	enqueue(sl, pending{msg, c, latency}, callee, sh)
+	z := archaius.Size(callee, names.Service(callee))
+	if z == nil {
+		return
+	}
+	if st := sizeStat(callee, z); len(sl.waiting) > st.MaxQueue {
+		st.MaxQueue = len(sl.waiting)
+		summarizeSizes()
+	}
+}
+
+// finished frees the slot a call held at the instance it went to when its response or timeout arrives, and sends the next
+// call queued for the instance by the discipline of its service, unless the instance is held up by backpressure. A call that
+// times out while it's still queued is never sent
+func finished(msg gotocol.Message) {
+	sizeLock.Lock()
+	defer sizeLock.Unlock()
+	callee, ok := toInstance[msg.Ctx.Route()]
+	if !ok {
+		return
+	}
+	delete(toInstance, msg.Ctx.Route())
+	caller := fromInstance[msg.Ctx.Route()]
+	delete(fromInstance, msg.Ctx.Route())
+	sl := instanceSlots[callee]
+	if sl == nil {
+		return // not limited
+	}
+	_, sh := limits(callee)
+	if !inSlot[msg.Ctx.Route()] { // still queued, shed, or waiting for a connection so it never got here
+		for i, w := range sl.waiting {
+			if w.msg.Ctx == msg.Ctx {
+				sl.waiting = append(sl.waiting[:i], sl.waiting[i+1:]...)
+				sl.untag(w)
+				if sh != nil {
+					shedStat(callee, sh).Abandoned++
+					summarizeShed()
+				}
+				break
+			}
+		}
+		for i, w := range sl.blocked {
+			if w.msg.Ctx == msg.Ctx {
+				sl.blocked = append(sl.blocked[:i], sl.blocked[i+1:]...)
+				unblock(caller)
+				break
+			}
+		}
+		return
+	}
+	delete(inSlot, msg.Ctx.Route())
+	defer unqueue(sl, callee, sh) // there may be room in the queue for a blocked call now
+	if blockedBy[callee] > 0 {
+		// the slot waits for the instance to be unblocked
+		sl.inuse--
+		return
+	}
+	w, ok := dequeue(sl, callee, sh) // hand the slot straight to the next call
+	if !ok {
+		sl.inuse--
+		return
+	}
+	start(w, callee, sh)
+}
+
+// start sends a call that waited for an instance when it gets a slot, the caller holds sizeLock
+func start(w pending, callee string, sh *archaius.ShedConfig) {
+	inSlot[w.msg.Ctx.Route()] = true
+	wait := clock.Since(w.msg.Sent)
+	if wait < 0 {
+		wait = 0
+	}
+	w.msg.GoSendAfter(w.to, w.msg.Sent.Sub(clock.Now())+w.latency)
+	sojourn(callee, wait)
+	if sh != nil {
+		served(w, callee, sh, wait)
+	}
+	z := archaius.Size(callee, names.Service(callee))
+	if z == nil {
+		return
+	}
+	st := sizeStat(callee, z)
+	st.Waits++
+	st.total += wait
+	st.MeanWait = float64(st.total) / float64(st.Waits) / float64(time.Millisecond)
+	summarizeSizes()
+}
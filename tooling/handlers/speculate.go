@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// SpeculationStats is the speculative calls started alongside the calls to a dependency, and the work wasted on them
+type SpeculationStats struct {
+	Speculative int     `json:"speculative"`
+	Cancelled   int     `json:"cancelled"` // the primary call succeeded first, so the speculative one was cancelled
+	Late        int     `json:"late"`      // answered before the primary succeeded, so all of its work was thrown away
+	Used        int     `json:"used"`      // answered for a primary call that failed
+	Failed      int     `json:"failed"`    // failed as well as the primary
+	Wasted      float64 `json:"wastedms"`  // downstream work done for speculative calls whose results were thrown away
+}
+
+// speculative call started alongside a primary call, remembered until both have answered
+type specCall struct {
+	edge      string
+	primary   string  // span context of the primary call
+	key       string  // span context of the speculative call, empty until it's started
+	work      float64 // fraction of its work done by the time it's cancelled
+	sent      time.Time
+	answered  bool
+	intention string
+	took      time.Duration
+	cancelled bool
+	held      *gotocol.Message // failure of the primary, held until the speculative call answers
+}
+
+var specStats = make(map[string]SpeculationStats) // by caller->callee service names
+var specPrimaries = make(map[string]*specCall)    // by span context of the primary call
+var specCalls = make(map[string]*specCall)        // by span context of the speculative call
+var specLock sync.Mutex
+
+func summarizeSpeculation() {
+	summary := make(map[string]SpeculationStats, len(specStats))
+	for k, v := range specStats {
+		summary[k] = v
+	}
+	collect.Summarize("speculation", summary)
+}
+
+// speculate starts a speculative call to another instance of a dependency alongside a fraction of the calls to it, as a new
+// span tagged with speculative=<dependency> baggage. It starts straight away, or after the edge's speculateafter if the primary
+// call hasn't answered by then. The speculative call does its work downstream like any other, and is cancelled once the primary
+// succeeds, or answers for the primary if it fails
+func speculate(msg, outmsg gotocol.Message, name string, listener chan gotocol.Message, router *ribbon.Router, dep, picked string, t time.Duration) {
+	e := archaius.Service(names.Service(name)).Edges[dep]
+	if e.Speculate == 0 || archaius.Rand(name).Float64() >= e.Speculate {
+		return
+	}
+	c := router.Select(func(n string) bool { return names.Service(n) == dep && n != picked }).Random()
+	if c == nil {
+		return // nowhere else to send it
+	}
+	after, _ := time.ParseDuration(e.SpeculateAfter)
+	sc := &specCall{edge: names.Service(name) + "->" + dep, primary: outmsg.Ctx.String(), work: e.CancelWork}
+	if sc.work == 0 {
+		sc.work = 0.5
+	}
+	specLock.Lock()
+	specPrimaries[sc.primary] = sc
+	specLock.Unlock()
+	clock.AfterFunc(t+after, func() {
+		specLock.Lock()
+		if specPrimaries[sc.primary] != sc { // the primary answered first
+			specLock.Unlock()
+			return
+		}
+		latency, response, _ := edge(name, router, c)
+		smsg := gotocol.Message{gotocol.GetRequest, listener, clock.Now(), msg.Ctx.NewParent().WithResponse(response).WithBaggage("speculative", dep), msg.Intention}
+		sc.key = smsg.Ctx.String()
+		sc.sent = smsg.Sent
+		specCalls[sc.key] = sc
+		s := specStats[sc.edge]
+		s.Speculative++
+		specStats[sc.edge] = s
+		summarizeSpeculation()
+		specLock.Unlock()
+		flow.AnnotateSend(smsg, name)
+		smsg.GoSendAfter(c, latency)
+	})
+}
+
+// speculation sorts out the responses to calls with a speculative call. A successful primary cancels the speculative call,
+// whose work up to the edge's cancelwork fraction of its response time is wasted, and a failed one is held until the
+// speculative call answers for it. It returns the response to carry on with, or true if there's nothing more to do yet
+func speculation(msg gotocol.Message) (gotocol.Message, bool) {
+	specLock.Lock()
+	defer specLock.Unlock()
+	k := msg.Ctx.String()
+	if sc, ok := specCalls[k]; ok {
+		delete(specCalls, k)
+		sc.answered, sc.intention, sc.took = true, msg.Intention, clock.Since(sc.sent)
+		s := specStats[sc.edge]
+		defer func() { specStats[sc.edge] = s; summarizeSpeculation() }()
+		switch {
+		case sc.cancelled:
+			s.Wasted += sc.work * ms(sc.took)
+			return msg, true
+		case sc.held != nil:
+			delete(specPrimaries, sc.primary)
+			r := *sc.held
+			if gotocol.Failed(msg.Intention) {
+				s.Failed++
+			} else {
+				s.Used++
+				r.Intention = msg.Intention
+			}
+			return r, false
+		}
+		return msg, true // wait for the primary
+	}
+	sc, ok := specPrimaries[k]
+	if !ok || sc.held != nil {
+		return msg, false
+	}
+	if sc.key == "" { // answered before the speculative call started, so it never will
+		delete(specPrimaries, k)
+		return msg, false
+	}
+	s := specStats[sc.edge]
+	defer func() { specStats[sc.edge] = s; summarizeSpeculation() }()
+	if !gotocol.Failed(msg.Intention) {
+		delete(specPrimaries, k)
+		if sc.answered {
+			s.Late++
+			s.Wasted += ms(sc.took)
+		} else {
+			s.Cancelled++
+			sc.cancelled = true
+		}
+		return msg, false
+	}
+	if !sc.answered {
+		sc.held = &msg
+		return msg, true
+	}
+	delete(specPrimaries, k)
+	if gotocol.Failed(sc.intention) {
+		s.Failed++
+	} else {
+		s.Used++
+		msg.Intention = sc.intention
+	}
+	return msg, false
+}
+
+// ms is a duration in milliseconds
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// specTest is a caller with a speculative edge to a dependency with two instances, the test plays the part of both
+type specTest struct {
+	name, picked string
+	listener     chan gotocol.Message
+	router       *ribbon.Router
+	other        chan gotocol.Message
+}
+
+func newSpecTest(service string, e archaius.EdgeConfig) *specTest {
+	archaius.SetService(service, archaius.ServiceConfig{Edges: map[string]archaius.EdgeConfig{"db": e}})
+	st := &specTest{
+		name:     names.Make("test", "us-east-1", "zoneA", service, "karyon", 0),
+		picked:   names.Make("test", "us-east-1", "zoneA", "db", "store", 0),
+		listener: make(chan gotocol.Message, 10),
+		router:   ribbon.MakeRouter(),
+		other:    make(chan gotocol.Message, 10),
+	}
+	st.router.Add(st.picked, make(chan gotocol.Message, 10), time.Now())
+	st.router.Add(names.Make("test", "us-east-1", "zoneA", "db", "store", 1), st.other, time.Now())
+	return st
+}
+
+// primary call to the picked instance, that starts a speculative one to the other
+func (st *specTest) primary() gotocol.Message {
+	msg := gotocol.Message{gotocol.GetRequest, nil, time.Now(), gotocol.NewTrace(), "get"}
+	outmsg := gotocol.Message{gotocol.GetRequest, st.listener, time.Now(), msg.Ctx.NewParent(), "get"}
+	speculate(msg, outmsg, st.name, st.listener, st.router, "db", st.picked, 0)
+	return outmsg
+}
+
+// speculative call the other instance got
+func (st *specTest) speculative(t *testing.T) gotocol.Message {
+	select {
+	case m := <-st.other:
+		if m.Ctx.BaggageItem("speculative") != "db" {
+			t.Errorf("speculative call has baggage %v", m.Ctx.Baggage)
+		}
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("the speculative call wasn't sent")
+	}
+	return gotocol.Message{}
+}
+
+// answer a call, with the response to carry on with, or true if there's nothing more to do yet
+func (st *specTest) answer(m gotocol.Message, intention string) (gotocol.Message, bool) {
+	return speculation(gotocol.Message{gotocol.GetResponse, nil, time.Now(), m.Ctx, intention})
+}
+
+// TestSpeculation checks a successful primary cancels the speculative call and drops its late response, a failed primary
+// waits for the speculative call and carries on with its answer, and they fail together if both do
+func TestSpeculation(t *testing.T) {
+	st := newSpecTest("specweb", archaius.EdgeConfig{Speculate: 1})
+	p := st.primary()
+	s := st.speculative(t)
+	if r, wait := st.answer(p, "ok"); wait || r.Intention != "ok" {
+		t.Errorf("successful primary gave %v, wait %v", r.Intention, wait)
+	}
+	if _, wait := st.answer(s, "ok"); !wait {
+		t.Error("the response to a cancelled speculative call was passed on")
+	}
+	p = st.primary()
+	s = st.speculative(t)
+	if _, wait := st.answer(p, gotocol.Failure("error")); !wait {
+		t.Error("a failed primary didn't wait for the speculative call")
+	}
+	if r, wait := st.answer(s, "theirs"); wait || r.Ctx != p.Ctx || r.Intention != "theirs" {
+		t.Errorf("speculative call answered the primary %v with %v, wait %v", r.Ctx, r.Intention, wait)
+	}
+	p = st.primary()
+	s = st.speculative(t)
+	st.answer(p, gotocol.Failure("error"))
+	if r, _ := st.answer(s, gotocol.Failure("error")); r.Ctx != p.Ctx || !gotocol.Failed(r.Intention) {
+		t.Errorf("both failed but the primary %v got %v", r.Ctx, r.Intention)
+	}
+	specLock.Lock()
+	stats := specStats["specweb->db"]
+	specLock.Unlock()
+	if stats.Speculative != 3 || stats.Cancelled != 1 || stats.Used != 1 || stats.Failed != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+// TestSpeculateAfter checks a primary that answers before speculateafter never starts a speculative call
+func TestSpeculateAfter(t *testing.T) {
+	st := newSpecTest("specafter", archaius.EdgeConfig{Speculate: 1, SpeculateAfter: "20ms"})
+	if r, wait := st.answer(st.primary(), "ok"); wait || r.Intention != "ok" {
+		t.Errorf("primary gave %v, wait %v", r.Intention, wait)
+	}
+	select {
+	case m := <-st.other:
+		t.Errorf("speculative call %v sent after the primary answered", m.Ctx)
+	case <-time.After(40 * time.Millisecond):
+	}
+	p := st.primary()
+	st.speculative(t) // once the primary has taken too long
+	st.answer(p, "ok")
+}
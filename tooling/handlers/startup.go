@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// StartupStats is the calls made to the instances of a service with a startup while they were still cold
+type StartupStats struct {
+	Instances int     `json:"instances"` // that were called while cold
+	Calls     int     `json:"calls"`
+	Extra     float64 `json:"extrams"` // latency added to them
+	Mean      float64 `json:"meanms"`
+}
+
+var startupStats = make(map[string]*StartupStats) // by service name
+var coldCalled = make(map[string]bool)            // instances called while cold
+var startupLock sync.Mutex
+
+func summarizeStartup() {
+	summary := make(map[string]StartupStats, len(startupStats))
+	for k, v := range startupStats {
+		summary[k] = *v
+	}
+	collect.Summarize("startup", summary)
+}
+
+// cold is the extra latency of a call to an instance that is still warming up after it started, it's the startup latency of its
+// service straight away and falls in a straight line to nothing at the end of the warm period
+func cold(dep string) time.Duration {
+	sc := archaius.Service(names.Service(dep)).Startup
+	if sc == nil {
+		return 0
+	}
+	at := archaius.StartedAt(dep)
+	latency, _ := time.ParseDuration(sc.Latency)
+	warm, _ := time.ParseDuration(sc.Warm)
+	age := clock.Since(at)
+	if at.IsZero() || latency <= 0 || warm <= 0 || age >= warm {
+		return 0
+	}
+	extra := time.Duration(float64(latency) * float64(warm-age) / float64(warm))
+	startupLock.Lock()
+	defer startupLock.Unlock()
+	s := startupStats[names.Service(dep)]
+	if s == nil {
+		s = &StartupStats{}
+		startupStats[names.Service(dep)] = s
+	}
+	if !coldCalled[dep] {
+		coldCalled[dep] = true
+		s.Instances++
+	}
+	s.Calls++
+	s.Extra += float64(extra) / float64(time.Millisecond)
+	s.Mean = s.Extra / float64(s.Calls)
+	summarizeStartup()
+	return extra
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+// StickyStats is the sessions sent over a sticky edge, and the ones that had to move when the instances changed
+type StickyStats struct {
+	Sessions  int     `json:"sessions"`
+	Calls     int     `json:"calls"`
+	NoSession int     `json:"nosession"` // calls without a session, sent to any instance
+	Moved     int     `json:"moved"`     // first calls of a session to another instance than the last one
+	Rehome    float64 `json:"rehomems"`  // latency added to the moved calls
+}
+
+var homes = make(map[string]map[string]string)  // instance each session last called, by caller instance and dependency service
+var sessions = make(map[string]map[string]bool) // by caller->callee service names
+var stickyStats = make(map[string]*StickyStats)
+var stickyLock sync.Mutex
+
+func summarizeSticky() {
+	summary := make(map[string]StickyStats, len(stickyStats))
+	for k, v := range stickyStats {
+		summary[k] = *v
+	}
+	collect.Summarize("sticky", summary)
+}
+
+// sticky picks the instance of the dependency that the session of a request hashes to. It's a rendezvous hash, each session
+// goes to the instance with the highest hash of the two together, so when an instance goes away only its sessions move, and
+// a new instance only takes its share of the sessions from the others. A request without a session goes where it was routed
+func sticky(msg gotocol.Message, router *ribbon.Router, c chan gotocol.Message) chan gotocol.Message {
+	session := msg.Ctx.BaggageItem("session")
+	if session == "" {
+		return c
+	}
+	dep := names.Service(router.NameChan(c))
+	var home string
+	var highest uint32
+	for _, n := range router.Select(func(n string) bool { return names.Service(n) == dep }).Names() {
+		h := fnv.New32a()
+		h.Write([]byte(session + " " + n))
+		if s := h.Sum32(); home == "" || s > highest {
+			home, highest = n, s
+		}
+	}
+	if home == "" {
+		return c
+	}
+	return router.Named(home)
+}
+
+// rehome is the latency of the first call of a session to a sticky dependency after it has moved to another instance, as the
+// new one has to set the session up again, and zero for other calls. Each caller instance keeps the home of the sessions it
+// has sent, as callers that route within their own zone see different instances and each keep a session on one of theirs
+func rehome(msg gotocol.Message, name, callee string) time.Duration {
+	e := archaius.Service(names.Service(name)).Edges[names.Service(callee)]
+	if e.Balance != "sticky" {
+		return 0
+	}
+	stickyLock.Lock()
+	defer stickyLock.Unlock()
+	edge := names.Service(name) + "->" + names.Service(callee)
+	st := stickyStats[edge]
+	if st == nil {
+		st = &StickyStats{}
+		stickyStats[edge] = st
+		sessions[edge] = make(map[string]bool)
+	}
+	defer summarizeSticky()
+	st.Calls++
+	session := msg.Ctx.BaggageItem("session")
+	if session == "" {
+		st.NoSession++
+		return 0
+	}
+	if !sessions[edge][session] {
+		sessions[edge][session] = true
+		st.Sessions++
+	}
+	key := name + " " + names.Service(callee)
+	if homes[key] == nil {
+		homes[key] = make(map[string]string)
+	}
+	last, ok := homes[key][session]
+	homes[key][session] = callee
+	if !ok || last == callee {
+		return 0
+	}
+	st.Moved++
+	rl, _ := time.ParseDuration(e.Rehome)
+	st.Rehome += float64(rl) / float64(time.Millisecond)
+	return rl
+}
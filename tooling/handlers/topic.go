@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/actors/packagenames"
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/flow"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+	"github.com/adrianco/spigo/tooling/ribbon"
+)
+
+var subscribed = make(map[string]bool) // by consumer and topic instance names
+var subscribedLock sync.Mutex
+
+// subscribe sends a topic instance eureka has named a Subscribe if the edge to it has a consumer group, and returns false for
+// anything else, including a topic the instance publishes to. Edda is told about the edge the first time
+func subscribe(msg gotocol.Message, name string, listener chan gotocol.Message, eureka map[string]chan gotocol.Message) bool {
+	topic := msg.Intention
+	if names.Package(topic) != packagenames.TopicPkg {
+		return false
+	}
+	group := archaius.Edge(names.Service(name), names.Service(topic)).Group
+	if group == "" {
+		return false
+	}
+	gotocol.Message{gotocol.Subscribe, listener, clock.Now(), gotocol.NilContext, group + " " + name}.GoSend(msg.ResponseChan)
+	subscribedLock.Lock()
+	first := !subscribed[name+" "+topic]
+	subscribed[name+" "+topic] = true
+	subscribedLock.Unlock()
+	if first {
+		for _, ch := range Registries(eureka) {
+			gotocol.Send(ch, gotocol.Message{gotocol.Inform, listener, clock.Now(), DebugContext(msg.Ctx), name + " " + topic})
+			break
+		}
+	}
+	return true
+}
+
+// toTopic is true if a dependency is a topic instance, which is published to rather than called
+func toTopic(router *ribbon.Router, c chan gotocol.Message) bool {
+	return names.Package(router.NameChan(c)) == packagenames.TopicPkg
+}
+
+// publish sends a call to a topic as a Publish that isn't answered, the producer doesn't wait for the consumers, and it
+// answers the call itself straight away so the request it's part of carries on. It returns false if the call isn't to a topic
+func publish(outmsg gotocol.Message, name string, listener chan gotocol.Message, router *ribbon.Router, c chan gotocol.Message, latency time.Duration) bool {
+	if !toTopic(router, c) {
+		return false
+	}
+	outmsg.Imposition = gotocol.Publish
+	flow.AnnotateSend(outmsg, name)
+	outmsg.GoSendAfter(c, latency)
+	gotocol.Message{gotocol.GetResponse, listener, clock.Now(), outmsg.Ctx, "published"}.GoSend(listener)
+	return true
+}
+
+// Deliver processes a message a topic delivered to a consumer like a request, passing it on to a dependency, and the
+// response acknowledges it to the topic. A consumer with nothing to pass it on to acknowledges it straight away
+func Deliver(msg gotocol.Message, name string, listener chan gotocol.Message, requestor *map[string]gotocol.Routetype, router *ribbon.Router) {
+	if InjectError(msg, name, listener) || GetRequest(msg, name, listener, requestor, router) != "" {
+		return
+	}
+	outmsg := gotocol.Message{gotocol.GetResponse, listener, clock.Now(), msg.Ctx, "processed"}
+	flow.AnnotateSend(outmsg, name)
+	outmsg.GoRespond(msg.ResponseChan)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
+	"github.com/adrianco/spigo/tooling/collect"
+	"github.com/adrianco/spigo/tooling/gotocol"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// WarmupStats is the connections opened over an edge with a warmup, and how much slower the calls were while they warmed up
+type WarmupStats struct {
+	Connections int       `json:"connections"` // opened, including the ones reopened after being idle for longer than the keepalive
+	Reopened    int       `json:"reopened"`
+	Cold        int       `json:"cold"`    // calls made before their connection was warm
+	Extra       float64   `json:"extrams"` // latency added to them
+	Curve       []float64 `json:"curvems"` // mean response time of the first, second and so on calls over a connection, the last is the warm ones
+	counts      []int
+}
+
+// connection from a caller instance to a callee instance, warming up as calls are made over it
+type connection struct {
+	calls int
+	last  time.Time
+}
+
+// call over a connection with a warmup, waiting for its response to add to the curve
+type warmCall struct {
+	edge string
+	call int
+	sent time.Time
+}
+
+var warmupStats = make(map[string]*WarmupStats) // by caller->callee service names
+var connections = make(map[string]*connection)  // by caller and callee instance names
+var warmCalls = make(map[string]warmCall)       // by span context
+var warmupLock sync.Mutex
+
+func summarizeWarmup() {
+	summary := make(map[string]WarmupStats, len(warmupStats))
+	for k, v := range warmupStats {
+		s := *v
+		s.Curve = append([]float64(nil), v.Curve...)
+		summary[k] = s
+	}
+	collect.Summarize("warmup", summary)
+}
+
+// warmupCalls is how many calls it takes an edge with a warmup to warm up a connection, default 4
+func warmupCalls(e archaius.EdgeConfig) int {
+	if e.WarmupCalls > 0 {
+		return e.WarmupCalls
+	}
+	return 4
+}
+
+// open finds how many calls have been made over the connection to the callee, it's zero for a new connection
+// or one that has been idle for longer than the keepalive of the edge
+func (c *connection) open(e archaius.EdgeConfig) int {
+	if c == nil {
+		return 0
+	}
+	if keepalive, err := time.ParseDuration(e.KeepAlive); err == nil && keepalive > 0 && clock.Since(c.last) > keepalive {
+		return 0
+	}
+	return c.calls
+}
+
+// warmup is the extra latency of the next call over the connection to the callee if the edge has a warmup. The first call
+// on a new connection gets all of it, and it halves with each call after that, as the congestion window doubles in TCP slow start,
+// until the connection is warm
+func warmup(name, callee string) time.Duration {
+	e := archaius.Service(names.Service(name)).Edges[names.Service(callee)]
+	if e.Warmup == "" {
+		return 0
+	}
+	w, _ := time.ParseDuration(e.Warmup)
+	warmupLock.Lock()
+	n := connections[name+" "+callee].open(e)
+	warmupLock.Unlock()
+	if n >= warmupCalls(e) {
+		return 0
+	}
+	return w >> uint(n)
+}
+
+// warmSent counts a call sent over a connection with a warmup, opening the connection if it's new or has been idle too long,
+// and remembers it so its response time can be added to the curve
+func warmSent(msg gotocol.Message, name, callee string, extra time.Duration) {
+	e := archaius.Service(names.Service(name)).Edges[names.Service(callee)]
+	if e.Warmup == "" {
+		return
+	}
+	warmupLock.Lock()
+	defer warmupLock.Unlock()
+	k := names.Service(name) + "->" + names.Service(callee)
+	s := warmupStats[k]
+	if s == nil {
+		s = &WarmupStats{Curve: make([]float64, warmupCalls(e)+1), counts: make([]int, warmupCalls(e)+1)}
+		warmupStats[k] = s
+	}
+	c := connections[name+" "+callee]
+	n := c.open(e)
+	if n == 0 {
+		if c != nil {
+			s.Reopened++
+		}
+		c = &connection{}
+		connections[name+" "+callee] = c
+		s.Connections++
+	}
+	c.calls++
+	c.last = clock.Now()
+	if n < warmupCalls(e) {
+		s.Cold++
+		s.Extra += float64(extra) / float64(time.Millisecond)
+	}
+	if n >= len(s.Curve) {
+		n = len(s.Curve) - 1
+	}
+	warmCalls[msg.Ctx.String()] = warmCall{k, n, msg.Sent}
+	summarizeWarmup()
+}
+
+// warmed adds the response time of a call over a connection with a warmup to the curve for its position on the connection
+func warmed(msg gotocol.Message) {
+	warmupLock.Lock()
+	defer warmupLock.Unlock()
+	wc, ok := warmCalls[msg.Ctx.String()]
+	if !ok {
+		return
+	}
+	delete(warmCalls, msg.Ctx.String())
+	s := warmupStats[wc.edge]
+	s.counts[wc.call]++
+	s.Curve[wc.call] += (float64(clock.Since(wc.sent))/float64(time.Millisecond) - s.Curve[wc.call]) / float64(s.counts[wc.call])
+	summarizeWarmup()
+}
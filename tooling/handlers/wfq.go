@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"math"
+	"time"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+)
+
+// TenantStats is what wfq did with the queued calls of one tenant of a service
+type TenantStats struct {
+	Queued   int     `json:"queued"`
+	Served   int     `json:"served"`
+	Shed     int     `json:"shed"`
+	MeanWait float64 `json:"meanwaitms"`
+	MaxWait  float64 `json:"maxwaitms"`
+	total    time.Duration
+}
+
+// tenant of a call by its baggage, none if it doesn't have one
+func tenant(p pending, sh *archaius.ShedConfig) string {
+	key := sh.Tenant
+	if key == "" {
+		key = "tenant"
+	}
+	if t := p.msg.Ctx.BaggageItem(key); t != "" {
+		return t
+	}
+	return "none"
+}
+
+func weight(t string, sh *archaius.ShedConfig) float64 {
+	if w, ok := sh.Weights[t]; ok {
+		return w
+	}
+	return 1
+}
+
+// tenantStat finds the stats of the tenant of a call, the caller holds sizeLock
+func tenantStat(p pending, callee string, sh *archaius.ShedConfig) *TenantStats {
+	st := shedStat(callee, sh)
+	if st.Tenants == nil {
+		st.Tenants = make(map[string]*TenantStats)
+	}
+	t := tenant(p, sh)
+	ts := st.Tenants[t]
+	if ts == nil {
+		ts = &TenantStats{}
+		st.Tenants[t] = ts
+	}
+	return ts
+}
+
+// tag gives a call queued for an instance its virtual finish time. Each call costs its tenant one over its weight, starting
+// from where the tenant's last call finishes or the virtual time of the instance if the tenant has caught up, so serving the
+// smallest tag first shares the instance between the tenants with calls waiting by weight. It's self clocked, the virtual
+// time is the tag of the last call taken off the queue. The caller holds sizeLock
+func tag(sl *slots, p pending, callee string, sh *archaius.ShedConfig) {
+	if sl.tags == nil {
+		sl.tags = make(map[string]float64)
+		sl.last = make(map[string]float64)
+	}
+	t := tenant(p, sh)
+	sl.last[t] = math.Max(sl.vtime, sl.last[t]) + 1/weight(t, sh)
+	sl.tags[p.msg.Ctx.Route()] = sl.last[t]
+	tenantStat(p, callee, sh).Queued++
+}
+
+// untag forgets the finish time of a call that's left the queue and returns it
+func (sl *slots) untag(w pending) float64 {
+	f := sl.tags[w.msg.Ctx.Route()]
+	delete(sl.tags, w.msg.Ctx.Route())
+	return f
+}
+
+// fair is the index of the queued call with the smallest finish tag, or with largest the one of the tenant furthest over
+// its share, the newest of its calls
+func (sl *slots) fair(largest bool) int {
+	i := 0
+	for j, w := range sl.waiting {
+		if f := sl.tags[w.msg.Ctx.Route()]; (largest && f > sl.tags[sl.waiting[i].msg.Ctx.Route()]) ||
+			(!largest && f < sl.tags[sl.waiting[i].msg.Ctx.Route()]) {
+			i = j
+		}
+	}
+	return i
+}
+
+// unfair sheds a call from the full queue of an instance, the newest of the tenant furthest over its share, which gives back
+// what it cost the tenant so the ones it'll never send don't push its later calls back. The caller holds sizeLock
+func unfair(sl *slots, callee string, sh *archaius.ShedConfig) pending {
+	i := sl.fair(true)
+	w := sl.waiting[i]
+	sl.waiting = append(sl.waiting[:i], sl.waiting[i+1:]...)
+	t := tenant(w, sh)
+	if sl.untag(w) == sl.last[t] {
+		sl.last[t] -= 1 / weight(t, sh)
+	}
+	tenantStat(w, callee, sh).Shed++
+	return w
+}
+
+// fairWait records the wait of a call wfq served in the stats of its tenant, the caller holds sizeLock
+func fairWait(w pending, callee string, sh *archaius.ShedConfig, wait time.Duration) {
+	ts := tenantStat(w, callee, sh)
+	ts.Served++
+	ts.total += wait
+	ts.MeanWait = float64(ts.total) / float64(ts.Served) / float64(time.Millisecond)
+	if ms := float64(wait) / float64(time.Millisecond); ms > ts.MaxWait {
+		ts.MaxWait = ms
+	}
+}
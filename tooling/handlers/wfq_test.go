@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/gotocol"
+)
+
+// sendAs a tenant
+func (st *shedTest) sendAs(tenant, body string) gotocol.Message {
+	return st.send(body, gotocol.NewTrace().WithBaggage("tenant", tenant))
+}
+
+// TestWFQ checks the queued calls are served in proportion to the weights of their tenants rather than in order
+func TestWFQ(t *testing.T) {
+	st := newShedTest("wfq", archaius.ServiceConfig{Shed: &archaius.ShedConfig{Concurrency: 1, Discipline: "wfq",
+		Weights: map[string]float64{"gold": 2}}})
+	m := st.sendAs("free", "f0")
+	st.arrived(t)
+	for _, body := range []string{"f1", "f2", "f3"} {
+		st.sendAs("free", body)
+	}
+	for _, body := range []string{"g1", "g2", "g3"} {
+		st.sendAs("gold", body)
+	}
+	for _, want := range []string{"g1", "f1", "g2", "g3", "f2", "f3"} {
+		st.finish(m)
+		if m = st.arrived(t); m.Intention != want {
+			t.Fatalf("served %v, not %v", m.Intention, want)
+		}
+	}
+	st.finish(m)
+	s := shedStatOf("wfq")
+	if gold, free := s.Tenants["gold"], s.Tenants["free"]; gold == nil || free == nil || gold.Served != 3 || free.Served != 3 {
+		t.Errorf("tenants %+v %+v", gold, free)
+	}
+}
+
+// TestWFQShed checks a full queue sheds the newest call of the tenant furthest over its share, not the call that filled it
+func TestWFQShed(t *testing.T) {
+	st := newShedTest("wfqshed", archaius.ServiceConfig{Shed: &archaius.ShedConfig{Concurrency: 1, Queue: 3, Discipline: "wfq"}})
+	calls := make(map[gotocol.Context]string)
+	for _, body := range []string{"f0", "f1", "f2", "f3"} {
+		calls[st.sendAs("free", body).Ctx] = body
+	}
+	st.arrived(t)
+	calls[st.sendAs("gold", "g1").Ctx] = "g1"
+	if s := calls[st.shed(t).Ctx]; s != "f3" {
+		t.Errorf("shed %v, not the newest call of the tenant with the most queued", s)
+	}
+	s := shedStatOf("wfqshed")
+	if s.Shed != 1 || s.Tenants["free"].Shed != 1 || s.Tenants["gold"].Shed != 0 || s.Tenants["gold"].Queued != 1 {
+		t.Errorf("stats %+v", s)
+	}
+}
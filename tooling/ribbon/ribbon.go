@@ -2,9 +2,12 @@
 package ribbon
 
 import (
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/clock"
 	"github.com/adrianco/spigo/tooling/gotocol"
 	"github.com/adrianco/spigo/tooling/names"
 	"math/rand"
+	"sort"
 	"time"
 )
 
@@ -12,6 +15,8 @@ import (
 type Router struct {
 	routes  map[string]chan gotocol.Message
 	updated map[string]time.Time // dependent services and time last updated
+	names   []string             // of the routes, kept in order
+	owner   string               // instance whose random numbers pick the routes
 }
 
 // MakeRouter with maps initialized
@@ -28,9 +33,29 @@ func (r *Router) Len() int {
 	return len(r.routes)
 }
 
+// Own names the instance the router belongs to, its routes are picked from that instance's own random numbers, so a seeded
+// run picks the same routes however the other instances are scheduled, and so are those of the routers selected from it
+func (r *Router) Own(name string) {
+	r.owner = name
+}
+
+// Rand is the random numbers of the instance that owns the router
+func (r *Router) Rand() *rand.Rand {
+	if r.owner == "" {
+		return archaius.Rand("ribbon")
+	}
+	return archaius.Rand(r.owner)
+}
+
 // Add an entry to the routing table
 func (r *Router) Add(name string, c chan gotocol.Message, t time.Time) {
 	var nilt time.Time
+	if _, ok := r.routes[name]; !ok {
+		i := sort.SearchStrings(r.names, name)
+		r.names = append(r.names, "")
+		copy(r.names[i+1:], r.names[i:])
+		r.names[i] = name
+	}
 	r.routes[name] = c
 	if t != nilt {
 		r.updated[name] = t
@@ -39,38 +64,60 @@ func (r *Router) Add(name string, c chan gotocol.Message, t time.Time) {
 
 // Remove an entry from the routing table
 func (r *Router) Remove(name string) {
+	if _, ok := r.routes[name]; ok {
+		i := sort.SearchStrings(r.names, name)
+		r.names = append(r.names[:i], r.names[i+1:]...)
+	}
 	delete(r.routes, name)
 	delete(r.updated, name)
 }
 
-// Random channel from the routing table
+// Expire removes the entries that were last updated longer than ttl ago and returns their names in order,
+// entries added without an update time never expire
+func (r *Router) Expire(ttl time.Duration) (expired []string) {
+	for _, n := range r.Names() {
+		if t, ok := r.updated[n]; ok && clock.Since(t) > ttl {
+			expired = append(expired, n)
+			r.Remove(n)
+		}
+	}
+	return expired
+}
+
+// Random channel from the routing table, picked by name order so the same seed picks the same route
 func (r *Router) Random() chan gotocol.Message {
-	lr := len(r.routes)
-	if lr == 0 {
+	if len(r.routes) == 0 {
 		return nil
 	}
-	n := rand.Intn(lr)
-	for _, c := range r.routes {
-		if n == 0 {
-			return c
-		}
-		n--
-	}
-	return nil // the table was empty
+	return r.routes[r.names[r.Rand().Intn(len(r.names))]]
 }
 
 // All routes that match a package
 func (r *Router) All(p string) *Router {
 	packroutes := MakeRouter()
+	packroutes.owner = r.owner
 	var t time.Time
-	for n, c := range r.routes {
+	for _, n := range r.names {
 		if names.Package(n) == p {
-			packroutes.Add(n, c, t)
+			packroutes.Add(n, r.routes[n], t)
 		}
 	}
 	return packroutes
 }
 
+// Select routes whose names pass a filter function
+func (r *Router) Select(f func(string) bool) *Router {
+	selected := MakeRouter()
+	selected.owner = r.owner
+	var t time.Time
+	for _, n := range r.names {
+		if f(n) {
+			selected.Add(n, r.routes[n], t)
+		}
+	}
+	return selected
+}
+
 // Pick a random matching package and return that channel from the routing table
 func (r *Router) Pick(p string) chan gotocol.Message {
 	return r.All(p).Random()
@@ -83,21 +130,17 @@ func (r *Router) Named(n string) chan gotocol.Message {
 
 // NameChan find the name corresponding to a channel
 func (r *Router) NameChan(ch chan gotocol.Message) string {
-	for n, c := range r.routes {
-		if ch == c {
+	for _, n := range r.names {
+		if ch == r.routes[n] {
 			return n
 		}
 	}
 	return ""
 }
 
-// Names return all
+// Names return all, in order
 func (r *Router) Names() (ns []string) {
-	ns = make([]string, 0, 10)
-	for n := range r.routes {
-		ns = append(ns, n)
-	}
-	return ns
+	return append(make([]string, 0, len(r.names)), r.names...)
 }
 
 // Return just the names in the routing table as a string
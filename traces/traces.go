@@ -0,0 +1,525 @@
+// Package traces reads Zipkin or Jaeger json trace exports and derives a spigo architecture from them
+package traces
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/adrianco/spigo/actors/packagenames" // name definitions
+	"github.com/adrianco/spigo/tooling/archaius"
+	"github.com/adrianco/spigo/tooling/architecture"
+	"github.com/adrianco/spigo/tooling/names"
+)
+
+// Span is the common subset of a zipkin or jaeger span that is needed to find dependencies
+type Span struct {
+	Trace, ID, Parent string
+	Service           string        // service that recorded the span
+	Remote            string        // service that was called, if known from the span itself
+	Kind              string        // CLIENT for the caller's side of a call, when the export says so
+	Host              string        // instance address of the server side, used to estimate instance counts
+	Start             time.Time     // when the span started, zero if the export doesn't have it
+	Duration          time.Duration // time taken by the call
+	Server            time.Duration // time the called service took, from sr to ss, when a zipkin v1 span has both sides
+}
+
+// zipkin v1 and v2 span formats combined, the fields that are used don't overlap
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	Ipv4        string `json:"ipv4"`
+}
+
+type zipkinSpan struct {
+	TraceID     string `json:"traceId"`
+	ID          string `json:"id"`
+	ParentID    string `json:"parentId"`
+	Timestamp   int64  `json:"timestamp"`
+	Duration    int64  `json:"duration"` // microseconds
+	Annotations []struct {
+		Endpoint  zipkinEndpoint `json:"endpoint"`
+		Timestamp int64          `json:"timestamp"`
+		Value     string         `json:"value"`
+	} `json:"annotations"`
+	Kind           string          `json:"kind"` // v2
+	LocalEndpoint  *zipkinEndpoint `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint `json:"remoteEndpoint"`
+}
+
+// jaeger json as exported from the query service or UI
+type jaegerExport struct {
+	Data []struct {
+		TraceID string `json:"traceID"`
+		Spans   []struct {
+			TraceID   string `json:"traceID"`
+			SpanID    string `json:"spanID"`
+			ProcessID string `json:"processID"`
+			StartTime int64  `json:"startTime"` // microseconds since the epoch
+			Duration  int64  `json:"duration"`  // microseconds
+			Tags      []struct {
+				Key   string      `json:"key"`
+				Value interface{} `json:"value"`
+			} `json:"tags"`
+			References []struct {
+				RefType string `json:"refType"`
+				SpanID  string `json:"spanID"`
+			} `json:"references"`
+		} `json:"spans"`
+		Processes map[string]struct {
+			ServiceName string `json:"serviceName"`
+			Tags        []struct {
+				Key   string      `json:"key"`
+				Value interface{} `json:"value"`
+			} `json:"tags"`
+		} `json:"processes"`
+	} `json:"data"`
+}
+
+// ReadFile detects the format of a trace export and returns the spans
+func ReadFile(fn string) []Span {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		return readJaeger(data)
+	}
+	return readZipkin(data)
+}
+
+// readZipkin reads an array of spans, or an array of traces that are arrays of spans
+func readZipkin(data []byte) []Span {
+	var zs []zipkinSpan
+	if err := json.Unmarshal(data, &zs); err != nil {
+		var traces [][]zipkinSpan
+		if err2 := json.Unmarshal(data, &traces); err2 != nil {
+			log.Fatal("traces: not a zipkin or jaeger json export: ", err)
+		}
+		for _, t := range traces {
+			zs = append(zs, t...)
+		}
+	}
+	spans := make([]Span, 0, len(zs))
+	for _, z := range zs {
+		s := Span{Trace: z.TraceID, ID: z.ID, Parent: z.ParentID, Start: micros(z.Timestamp), Duration: time.Duration(z.Duration) * time.Microsecond}
+		if z.LocalEndpoint != nil { // v2
+			s.Service = z.LocalEndpoint.ServiceName
+			s.Host = z.LocalEndpoint.Ipv4
+			if z.Kind == "CLIENT" {
+				s.Kind = z.Kind
+				if z.RemoteEndpoint != nil {
+					s.Remote = z.RemoteEndpoint.ServiceName
+				}
+			}
+		} else { // v1, client and server annotations share a span
+			var client, server string
+			var cs, cr, sr, ss int64
+			for _, a := range z.Annotations {
+				switch a.Value {
+				case "cs":
+					client = a.Endpoint.ServiceName
+					cs = a.Timestamp
+				case "cr":
+					cr = a.Timestamp
+				case "sr":
+					server = a.Endpoint.ServiceName
+					s.Host = a.Endpoint.Ipv4
+					sr = a.Timestamp
+				case "ss":
+					ss = a.Timestamp
+				}
+			}
+			if server == "" && len(z.Annotations) > 0 {
+				server = z.Annotations[0].Endpoint.ServiceName
+				s.Host = z.Annotations[0].Endpoint.Ipv4
+			}
+			s.Service = server
+			if client != "" && client != server {
+				s.Service, s.Remote = client, server
+			}
+			if sr > 0 && ss > sr {
+				s.Server = time.Duration(ss-sr) * time.Microsecond
+			}
+			if s.Duration == 0 && cs > 0 && cr > cs {
+				s.Duration = time.Duration(cr-cs) * time.Microsecond
+			}
+			if s.Remote == "" { // only the server side was recorded
+				if s.Duration == 0 {
+					s.Duration = s.Server
+				}
+				s.Server = 0
+			}
+			if s.Start.IsZero() {
+				if cs > 0 {
+					s.Start = micros(cs)
+				} else {
+					s.Start = micros(sr)
+				}
+			}
+		}
+		spans = append(spans, s)
+	}
+	return spans
+}
+
+// readJaeger reads the traces and processes from a jaeger export
+func readJaeger(data []byte) []Span {
+	var je jaegerExport
+	if err := json.Unmarshal(data, &je); err != nil {
+		log.Fatal("traces: not a zipkin or jaeger json export: ", err)
+	}
+	var spans []Span
+	for _, t := range je.Data {
+		for _, js := range t.Spans {
+			s := Span{Trace: js.TraceID, ID: js.SpanID, Start: micros(js.StartTime), Duration: time.Duration(js.Duration) * time.Microsecond}
+			for _, tag := range js.Tags {
+				if tag.Key == "span.kind" && fmt.Sprintf("%v", tag.Value) == "client" {
+					s.Kind = "CLIENT"
+				}
+			}
+			p := t.Processes[js.ProcessID]
+			s.Service = p.ServiceName
+			for _, tag := range p.Tags {
+				if tag.Key == "ip" || tag.Key == "hostname" {
+					s.Host = fmt.Sprintf("%v", tag.Value)
+				}
+			}
+			for _, r := range js.References {
+				if r.RefType == "CHILD_OF" {
+					s.Parent = r.SpanID
+				}
+			}
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// micros is the time of a microsecond timestamp, zero if there isn't one
+func micros(us int64) time.Time {
+	if us <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, us*int64(time.Microsecond)).UTC()
+}
+
+// service name and package for a traced service, spigo generated names are mapped back to their service and package
+func service(s string) (string, string) {
+	if names.Service(s) != "" {
+		for _, p := range Packages {
+			if p == names.Package(s) {
+				return names.Service(s), p
+			}
+		}
+	}
+	return s, ""
+}
+
+// Graph is the service dependency graph derived from a set of spans
+type Graph struct {
+	Packages  map[string]string                     // package for services that came from spigo names
+	Hosts     map[string]map[string]bool            // distinct hosts seen per service
+	Latencies map[string]map[string][]time.Duration // latency samples by caller and callee
+	Called    map[string]bool                       // services that were called by something else
+	Calls     map[string]map[string]int             // calls by caller and callee, including ones without a duration
+	Network   map[string]map[string][]time.Duration // time a call took less the time the callee took, where both sides were traced
+	Self      map[string][]time.Duration            // time each request took at a service less the calls it made
+	Requests  map[string][]time.Time                // start times of the requests that came into each service from outside the traces
+}
+
+// Dependencies builds the service graph from spans
+func Dependencies(spans []Span) *Graph {
+	g := &Graph{make(map[string]string), make(map[string]map[string]bool), make(map[string]map[string][]time.Duration), make(map[string]bool),
+		make(map[string]map[string]int), make(map[string]map[string][]time.Duration), make(map[string][]time.Duration), make(map[string][]time.Time)}
+	clients := make(map[string]*Span)    // spans that name the service they called, by trace and span id
+	servers := make(map[string]*Span)    // the rest, a zipkin v2 server span can share its id with the client span
+	children := make(map[string][]*Span) // by trace and parent id
+	for i := range spans {
+		k := spans[i].Trace + "/" + spans[i].ID
+		if spans[i].Remote != "" {
+			clients[k] = &spans[i]
+		} else {
+			servers[k] = &spans[i]
+		}
+		if spans[i].Parent != "" {
+			children[spans[i].Trace+"/"+spans[i].Parent] = append(children[spans[i].Trace+"/"+spans[i].Parent], &spans[i])
+		}
+	}
+	parent := func(s *Span) *Span {
+		if s.Parent == "" {
+			return nil
+		}
+		if p := clients[s.Trace+"/"+s.Parent]; p != nil {
+			return p
+		}
+		return servers[s.Trace+"/"+s.Parent]
+	}
+	// half is true for the server span of a zipkin v2 call that shares its id with the client span
+	half := func(s *Span) bool {
+		c := clients[s.Trace+"/"+s.ID]
+		if c == nil || s.Remote != "" {
+			return false
+		}
+		r, _ := service(c.Remote)
+		svc, _ := service(s.Service)
+		return r == svc
+	}
+	// callee is the time the called service took for a client span, from the span itself in zipkin v1, or from the
+	// server span that shares its id or is its child
+	callee := func(c *Span, remote string) time.Duration {
+		if c.Server > 0 {
+			return c.Server
+		}
+		for _, s := range append([]*Span{servers[c.Trace+"/"+c.ID]}, children[c.Trace+"/"+c.ID]...) {
+			if s == nil || s.Remote != "" || s.Kind != "" {
+				continue
+			}
+			if svc, _ := service(s.Service); svc == remote {
+				return s.Duration
+			}
+		}
+		return 0
+	}
+	edge := func(from, to string, d, server time.Duration) {
+		if from == "" || to == "" || from == to {
+			return
+		}
+		if g.Latencies[from] == nil {
+			g.Latencies[from] = make(map[string][]time.Duration)
+			g.Calls[from] = make(map[string]int)
+		}
+		g.Calls[from][to]++
+		if d > 0 {
+			g.Latencies[from][to] = append(g.Latencies[from][to], d)
+		} else if g.Latencies[from][to] == nil {
+			g.Latencies[from][to] = []time.Duration{}
+		}
+		if server > 0 && d >= server {
+			if g.Network[from] == nil {
+				g.Network[from] = make(map[string][]time.Duration)
+			}
+			g.Network[from][to] = append(g.Network[from][to], d-server)
+		}
+		g.Called[to] = true
+		if g.Hosts[to] == nil { // callee may not have recorded any spans of its own
+			g.Hosts[to] = make(map[string]bool)
+		}
+	}
+	for i := range spans {
+		s := &spans[i]
+		svc, pack := service(s.Service)
+		if svc == "" {
+			continue
+		}
+		if pack != "" {
+			g.Packages[svc] = pack
+		}
+		if g.Hosts[svc] == nil {
+			g.Hosts[svc] = make(map[string]bool)
+		}
+		// the host belongs to the server side of the span
+		server, full, work := svc, s.Service, s.Duration
+		p := parent(s)
+		if s.Remote != "" {
+			remote, rpack := service(s.Remote)
+			if rpack != "" {
+				g.Packages[remote] = rpack
+			}
+			edge(svc, remote, s.Duration, callee(s, remote))
+			server, full, work = remote, s.Remote, s.Server
+		} else if p != nil && s.Kind == "" && !half(s) {
+			// the caller is the server side of the parent span, or the service that recorded it if it's a client span
+			// that doesn't name the callee
+			caller, _ := service(p.Service)
+			switch {
+			case p.Remote != "":
+				caller, _ = service(p.Remote)
+				edge(caller, svc, s.Duration, 0)
+			case p.Kind != "":
+				edge(caller, svc, p.Duration, s.Duration)
+			default:
+				edge(caller, svc, s.Duration, 0)
+			}
+		}
+		if names.Instance(full) != "" {
+			g.Hosts[server][names.Instance(full)] = true // spigo name
+		} else if s.Host != "" {
+			g.Hosts[server][s.Host] = true
+		}
+		if p == nil && !half(s) {
+			g.Requests[svc] = append(g.Requests[svc], s.Start)
+		}
+		// the work a request did at a service is the server side of a span less the calls it made, spans nested inside
+		// the same service's work are left out
+		if s.Kind != "" || work <= 0 {
+			continue
+		}
+		if p != nil && p.Remote == "" && p.Kind == "" {
+			if caller, _ := service(p.Service); caller == server {
+				continue
+			}
+		}
+		for _, c := range children[s.Trace+"/"+s.ID] {
+			if csvc, _ := service(c.Service); c.Remote != "" || c.Kind != "" || (csvc != server && !half(c)) {
+				work -= c.Duration
+			}
+		}
+		if work >= 0 {
+			g.Self[server] = append(g.Self[server], work)
+		}
+	}
+	return g
+}
+
+// byDuration sortable latencies
+type byDuration []time.Duration
+
+func (a byDuration) Len() int           { return len(a) }
+func (a byDuration) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byDuration) Less(i, j int) bool { return a[i] < a[j] }
+
+// quantile of sorted samples
+func quantile(d []time.Duration, q float64) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	return d[int(q*float64(len(d)-1))]
+}
+
+// TraceArch writes a spigo architecture to json_arch/<name>_arch.json derived from the dependencies in a trace export.
+// Services are counted from the distinct hosts that were seen, and each gets a lognormal latency model fitted to the time
+// its requests spent there less the calls they made. Each edge gets the median network time where both sides of the call
+// were traced, or otherwise the median call latency if the callee has no model, and the dependencies of a service are
+// weighted by how often they were called. The services nothing calls become entry points at the rate their traces started.
+func TraceArch(name string, spans []Span) {
+	g := Dependencies(spans)
+	a := architecture.MakeArch(name, "derived from trace export")
+	svcs := make([]string, 0, len(g.Hosts))
+	for s := range g.Hosts {
+		svcs = append(svcs, s)
+	}
+	sort.Strings(svcs)
+	var roots []string
+	for _, s := range svcs {
+		var deps []string
+		for d := range g.Latencies[s] {
+			deps = append(deps, d)
+		}
+		sort.Strings(deps)
+		pack := g.Packages[s]
+		if pack == "" {
+			pack = KaryonPkg
+			if len(deps) == 0 {
+				pack = StorePkg
+			}
+		}
+		if pack == DenominatorPkg {
+			roots = append(roots, deps...) // a new denominator is added at the end
+			continue
+		}
+		if pack == EurekaPkg {
+			continue // added by spigo
+		}
+		count := len(g.Hosts[s])
+		if count < len(archaius.Conf.ZoneNames) {
+			count = len(archaius.Conf.ZoneNames) // at least one per zone
+		}
+		if pack == ElbPkg {
+			count = 0 // elb is cross zone
+		}
+		architecture.AddContainer(a, s, "", "", "", "", pack, 1, count, deps)
+		if l := latencyModel(g.Self[s]); l != nil {
+			architecture.SetLatency(a, s, l)
+		}
+		total := 0
+		for _, d := range deps {
+			total += g.Calls[s][d]
+		}
+		for _, d := range deps {
+			var e archaius.EdgeConfig
+			l, n := g.Latencies[s][d], g.Network[s][d]
+			sort.Sort(byDuration(l))
+			sort.Sort(byDuration(n))
+			if len(n) > 0 { // the network time is split evenly between the request and the response
+				e.Latency, e.Response = (quantile(n, 0.5) / 2).String(), (quantile(n, 0.5) - quantile(n, 0.5)/2).String()
+			} else if len(l) > 0 && len(g.Self[d]) == 0 {
+				e.Latency = quantile(l, 0.5).String()
+			}
+			if len(deps) > 1 && total > 0 {
+				e.Weight = (100*g.Calls[s][d] + total/2) / total
+				if e.Weight < 1 {
+					e.Weight = 1
+				}
+			}
+			if e != (archaius.EdgeConfig{}) {
+				architecture.AddEdge(a, s, d, e)
+			}
+			log.Printf("traces: %v->%v %v calls, p50 %v p99 %v, network p50 %v\n", s, d, g.Calls[s][d], quantile(l, 0.5), quantile(l, 0.99), quantile(n, 0.5))
+		}
+		if !g.Called[s] {
+			roots = append(roots, s)
+		}
+	}
+	if len(roots) == 0 && len(svcs) > 0 {
+		roots = svcs[:1] // everything is called by something, so just start somewhere
+	}
+	// traffic is injected by a denominator that calls all the root services, which must be last in the list
+	architecture.AddContainer(a, "www", "", "", "", "", DenominatorPkg, 0, 0, roots)
+	if entries := entrypoints(roots, g); entries != nil {
+		a.Entrypoints = entries
+	}
+	architecture.WriteFile(a, "json_arch/"+name+"_arch")
+}
+
+// latencyModel fits a lognormal to the time requests spent at a service, the sigma comes from how far the p99 is above the
+// median once there are enough samples to tell, and nil if nothing was measured
+func latencyModel(self []time.Duration) *archaius.LatencyConfig {
+	sort.Sort(byDuration(self))
+	p50, p99 := quantile(self, 0.5), quantile(self, 0.99)
+	if p50 <= 0 {
+		return nil
+	}
+	l := &archaius.LatencyConfig{Distribution: "lognormal", Median: p50.String()}
+	if len(self) >= 20 && p99 > p50 {
+		l.Sigma = math.Log(float64(p99)/float64(p50)) / 2.326 // the p99 of a normal is 2.326 standard deviations out
+		l.Sigma = math.Min(math.Max(l.Sigma, 0.05), 3)
+	}
+	return l
+}
+
+// entrypoints sends traffic into each root service at the mean interval between the traces that started there, it returns
+// nil if any of them doesn't call anything or has too few timed traces, and the denominator's default rate is used instead
+func entrypoints(roots []string, g *Graph) []archaius.Entrypoint {
+	var entries []archaius.Entrypoint
+	for _, r := range roots {
+		var first, last time.Time
+		n := 0
+		for _, t := range g.Requests[r] {
+			if t.IsZero() {
+				continue
+			}
+			if n == 0 || t.Before(first) {
+				first = t
+			}
+			if n == 0 || t.After(last) {
+				last = t
+			}
+			n++
+		}
+		if n < 2 || !last.After(first) || len(g.Latencies[r]) == 0 {
+			return nil
+		}
+		rate := last.Sub(first) / time.Duration(n-1)
+		if rate < time.Millisecond {
+			rate = time.Millisecond
+		}
+		log.Printf("traces: %v traces started at %v, one every %v\n", n, r, rate)
+		entries = append(entries, archaius.Entrypoint{Service: r, Rate: rate.String()})
+	}
+	return entries
+}
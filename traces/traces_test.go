@@ -0,0 +1,55 @@
+// traces tests - make sure dependencies are found in each export format
+package traces
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func check(t *testing.T, format string, spans []Span) {
+	g := Dependencies(spans)
+	fmt.Println(format, g.Latencies)
+	if len(g.Latencies["frontend"]["api"]) != 1 || !g.Called["api"] || g.Called["frontend"] {
+		t.Error(format + " didn't find frontend->api")
+	}
+}
+
+func TestFormats(t *testing.T) {
+	check(t, "zipkin v1", readZipkin([]byte(`[{"traceId":"a","id":"1","annotations":[
+		{"endpoint":{"serviceName":"frontend","ipv4":"10.0.0.1"},"timestamp":100,"value":"cs"},
+		{"endpoint":{"serviceName":"api","ipv4":"10.0.0.2"},"timestamp":150,"value":"sr"},
+		{"endpoint":{"serviceName":"api","ipv4":"10.0.0.2"},"timestamp":250,"value":"ss"},
+		{"endpoint":{"serviceName":"frontend","ipv4":"10.0.0.1"},"timestamp":300,"value":"cr"}]}]`)))
+	check(t, "zipkin v2", readZipkin([]byte(`[[{"traceId":"a","id":"1","kind":"CLIENT","duration":200,
+		"localEndpoint":{"serviceName":"frontend"},"remoteEndpoint":{"serviceName":"api"}}]]`)))
+	check(t, "jaeger", readJaeger([]byte(`{"data":[{"traceID":"a","spans":[
+		{"traceID":"a","spanID":"1","processID":"p1","duration":300},
+		{"traceID":"a","spanID":"2","processID":"p2","duration":100,"references":[{"refType":"CHILD_OF","spanID":"1"}]}],
+		"processes":{"p1":{"serviceName":"frontend"},"p2":{"serviceName":"api"}}}]}`)))
+}
+
+// the network time, the time spent at each service and when the traces started come from both sides of the calls
+func TestTimes(t *testing.T) {
+	g := Dependencies(readZipkin([]byte(`[
+		{"traceId":"a","id":"1","kind":"SERVER","timestamp":1000000,"duration":500,"localEndpoint":{"serviceName":"frontend"}},
+		{"traceId":"a","id":"2","parentId":"1","kind":"CLIENT","duration":300,"localEndpoint":{"serviceName":"frontend"},"remoteEndpoint":{"serviceName":"api"}},
+		{"traceId":"a","id":"2","parentId":"1","kind":"SERVER","duration":200,"localEndpoint":{"serviceName":"api"}},
+		{"traceId":"b","id":"1","kind":"SERVER","timestamp":1100000,"duration":400,"localEndpoint":{"serviceName":"frontend"}},
+		{"traceId":"b","id":"2","parentId":"1","kind":"CLIENT","duration":100,"localEndpoint":{"serviceName":"frontend"},"remoteEndpoint":{"serviceName":"db"}}]`)))
+	if g.Calls["frontend"]["api"] != 1 || g.Calls["frontend"]["db"] != 1 || len(g.Network["frontend"]["api"]) != 1 || g.Network["frontend"]["api"][0] != 100*time.Microsecond {
+		t.Errorf("frontend->api calls %v network %v", g.Calls["frontend"], g.Network["frontend"])
+	}
+	if len(g.Self["frontend"]) != 2 || g.Self["frontend"][0] != 200*time.Microsecond || g.Self["frontend"][1] != 300*time.Microsecond {
+		t.Errorf("frontend self time %v", g.Self["frontend"])
+	}
+	if len(g.Self["api"]) != 1 || g.Self["api"][0] != 200*time.Microsecond {
+		t.Errorf("api self time %v", g.Self["api"])
+	}
+	if len(g.Requests["frontend"]) != 2 || len(g.Requests["api"]) != 0 {
+		t.Errorf("requests %v", g.Requests)
+	}
+	if e := entrypoints([]string{"frontend"}, g); len(e) != 1 || e[0].Rate != "100ms" {
+		t.Errorf("entrypoints %v", e)
+	}
+}
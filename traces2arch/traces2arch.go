@@ -0,0 +1,19 @@
+// utility to read a zipkin or jaeger json trace export and write out an arch_json
+package main
+
+import (
+	"flag"
+	"github.com/adrianco/spigo/traces"
+)
+
+func main() {
+	var fn, arch string
+	flag.StringVar(&fn, "file", "", "zipkin (v1 or v2) or jaeger json trace export file")
+	flag.StringVar(&arch, "a", "traced", "architecture name, output is written to json_arch/<arch>_arch.json")
+	flag.Parse()
+	if fn != "" {
+		traces.TraceArch(arch, traces.ReadFile(fn))
+	} else {
+		flag.PrintDefaults()
+	}
+}